@@ -0,0 +1,171 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/easzlab/ezft/internal/exitcode"
+	"github.com/easzlab/ezft/pkg/bench"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/easzlab/ezft/pkg/utils/logger"
+	"github.com/spf13/cobra"
+)
+
+// bench subcommand related variables
+var (
+	benchURL          string
+	benchConcurrency  []int
+	benchChunkSizes   []string
+	benchSize         = utils.ByteSize(256 * 1024 * 1024)
+	benchRounds       int
+	benchUser         string
+	benchDisableQUIC  bool
+	benchNoProxyEnv   bool
+	benchOutputFormat string
+	benchLogHome      string
+	benchLogLevel     string
+	benchLogFormat    string
+	benchLogOutput    string
+)
+
+func init() {
+	// bench subcommand parameters
+	BenchCmd.Flags().StringVarP(&benchURL, "url", "u", "", "File to download, by default a file generated by a loopback server")
+	BenchCmd.Flags().IntSliceVarP(&benchConcurrency, "concurrency", "c", bench.DefaultConcurrency, "Concurrency counts to measure")
+	BenchCmd.Flags().StringSliceVarP(&benchChunkSizes, "chunk-size", "s", []string{"256K", "1M", "4M", "16M"}, "Chunk sizes to measure in bytes, with an optional binary unit such as 4M")
+	BenchCmd.Flags().Var(&benchSize, "size", "Size of the file generated by the loopback server, with an optional binary unit such as 1G")
+	BenchCmd.Flags().IntVar(&benchRounds, "rounds", 1, "Downloads per combination, the fastest counts")
+	BenchCmd.Flags().StringVar(&benchUser, "user", "", "Basic auth credentials username:password")
+	BenchCmd.Flags().BoolVar(&benchDisableQUIC, "no-quic", false, "Measure HTTP range requests even when the server offers QUIC")
+	BenchCmd.Flags().BoolVar(&benchNoProxyEnv, "no-proxy-env", false, "Ignore the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables and connect to servers directly")
+	BenchCmd.Flags().StringVar(&benchOutputFormat, "output-format", "text", "Format of the report, text or json")
+	BenchCmd.Flags().StringVarP(&benchLogHome, "log-home", "", "./logs", "Log file home")
+	BenchCmd.Flags().StringVarP(&benchLogLevel, "log-level", "", "info", "Log level")
+	BenchCmd.Flags().StringVar(&benchLogFormat, "log-format", logger.FormatConsole, "Log format, console or json for log ingestion pipelines")
+	BenchCmd.Flags().StringVar(&benchLogOutput, "log-output", logger.OutputFile, "Where the log goes: file under --log-home, stdout or stderr, or several separated by commas such as file,stderr")
+
+	BenchCmd.RegisterFlagCompletionFunc("output-format", cobra.FixedCompletions([]string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp))
+}
+
+var BenchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "EZFT Bench - Measure download throughput and recommend settings",
+	Long: `EZFT bench downloads a file with every combination of the concurrency counts
+and chunk sizes given, measures the throughput of each and recommends the
+settings of the client: the fewest connections, then the largest chunks, within
+5% of the best throughput. Without --url a loopback server generates the file,
+which measures the host itself. Downloads go to a temporary directory removed
+afterwards.
+
+  ezft bench
+  ezft bench -u http://files.example.com:8080/os.img -c 1,4,16 -s 1M,8M`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if benchOutputFormat != "text" && benchOutputFormat != "json" {
+			return exitcode.UsageError(fmt.Errorf("invalid output format %q, must be text or json", benchOutputFormat))
+		}
+		config := &bench.Config{
+			URL:         benchURL,
+			Concurrency: benchConcurrency,
+			Rounds:      benchRounds,
+			DisableQUIC: benchDisableQUIC,
+			NoProxyEnv:  benchNoProxyEnv,
+		}
+		for _, c := range benchConcurrency {
+			if c < 1 {
+				return exitcode.UsageError(fmt.Errorf("invalid concurrency %d, must be at least 1", c))
+			}
+		}
+		for _, s := range benchChunkSizes {
+			size, err := parseSize(s)
+			if err != nil {
+				return exitcode.UsageError(err)
+			}
+			config.ChunkSizes = append(config.ChunkSizes, size)
+		}
+		if benchSize <= 0 {
+			return exitcode.UsageError(fmt.Errorf("invalid size %s", benchSize.String()))
+		}
+		config.Size = int64(benchSize)
+		if benchUser != "" {
+			var err error
+			if config.Username, config.Password, err = utils.ParseCredentials(benchUser); err != nil {
+				return exitcode.UsageError(err)
+			}
+		}
+
+		if logger.WritesFile(benchLogOutput) {
+			if err := utils.EnsureDir(benchLogHome); err != nil {
+				return fmt.Errorf("failed to create log directory: %w", err)
+			}
+		}
+		l, err := logger.New(logger.Config{File: benchLogHome + "/bench.log", Output: benchLogOutput, Level: benchLogLevel, Format: benchLogFormat})
+		if err != nil {
+			return fmt.Errorf("failed to create logger: %w", err)
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+		b := bench.NewBench(config)
+		b.SetLogger(l)
+		jsonOutput := benchOutputFormat == "json"
+		if !jsonOutput {
+			target := benchURL
+			if target == "" {
+				target = "loopback server, " + utils.FormatBytes(config.Size)
+			}
+			fmt.Printf("Measuring %d combinations against %s\n\n", len(config.Concurrency)*len(config.ChunkSizes), target)
+			fmt.Printf(rowFormat, "CONCURRENCY", "CHUNK SIZE", "DURATION", "THROUGHPUT")
+			// Rows are printed as measured
+			b.OnResult(printResult)
+		}
+		report, err := b.Run(ctx)
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+		} else if r := report.Recommended; r != nil {
+			fmt.Printf("\nRecommended: -c %d -s %s (%s/s)\n", r.Concurrency, utils.FormatSize(r.ChunkSize), utils.FormatBytes(int64(r.Throughput)))
+		}
+		if report.Recommended == nil {
+			return errors.New("all downloads failed")
+		}
+		return nil
+	},
+}
+
+// rowFormat format of the rows of the table of results
+const rowFormat = "%-13s%-12s%-10s%s\n"
+
+// printResult prints a result as a row of the table
+func printResult(r *bench.Result) {
+	duration, throughput := "-", "failed: "+r.Error
+	if r.Error == "" {
+		duration, throughput = utils.FormatDuration(r.Duration), utils.FormatBytes(int64(r.Throughput))+"/s"
+	}
+	fmt.Printf(rowFormat, fmt.Sprint(r.Concurrency), utils.FormatBytes(r.ChunkSize), duration, throughput)
+}
+
+// parseSize parses a chunk size in bytes with an optional binary unit suffix
+func parseSize(s string) (int64, error) {
+	size, err := utils.ParseBytes(s)
+	if err != nil {
+		return 0, err
+	}
+	if size <= 0 {
+		return 0, fmt.Errorf("invalid size %q, must be positive", strings.TrimSpace(s))
+	}
+	return size, nil
+}
@@ -0,0 +1,153 @@
+package cast
+
+import (
+	"context"
+	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/multicast"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/easzlab/ezft/pkg/utils/logger"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// cast subcommand related variables
+var (
+	castGroup        string
+	castInterface    string
+	castTTL          int
+	castRate         string
+	castPasses       int
+	castRepairListen string
+	castLinger       time.Duration
+	castWait         time.Duration
+	castIdleTimeout  time.Duration
+	castLogHome      string
+	castLogLevel     string
+	castLogFormat    string
+	castLogOutput    string
+)
+
+func init() {
+	// cast subcommand parameters, shared by send and receive
+	CastCmd.PersistentFlags().StringVarP(&castGroup, "group", "g", multicast.DefaultGroup, "Multicast group and port")
+	CastCmd.PersistentFlags().StringVarP(&castInterface, "interface", "i", "", "Network interface to multicast on, such as eth0 (default: chosen by the system)")
+	CastCmd.PersistentFlags().StringVarP(&castLogHome, "log-home", "", "./logs", "Log file home")
+	CastCmd.PersistentFlags().StringVarP(&castLogLevel, "log-level", "", "debug", "Log level")
+	CastCmd.PersistentFlags().StringVar(&castLogFormat, "log-format", logger.FormatConsole, "Log format, console or json for log ingestion pipelines")
+	CastCmd.PersistentFlags().StringVar(&castLogOutput, "log-output", logger.OutputFile, "Where the log goes: file under --log-home, stdout or stderr, or several separated by commas such as file,stderr")
+
+	// send parameters
+	sendCmd.Flags().IntVar(&castTTL, "ttl", 1, "Routers the datagrams may cross, 1 keeps them in the LAN")
+	sendCmd.Flags().StringVar(&castRate, "rate", "100M", "Multicast rate in bytes per second such as 50M, off for no limit")
+	sendCmd.Flags().IntVar(&castPasses, "passes", 1, "Times the file is multicast, receivers catch missed datagrams in later passes")
+	sendCmd.Flags().StringVar(&castRepairListen, "repair-listen", ":7701", "Address serving missed datagrams to receivers over HTTP")
+	sendCmd.Flags().DurationVar(&castLinger, "linger", time.Minute, "Time missed datagrams are served after the last pass")
+
+	// receive parameters
+	receiveCmd.Flags().DurationVar(&castWait, "wait", 0, "Give up when no sender starts within this time, 0 to wait forever")
+	receiveCmd.Flags().DurationVar(&castIdleTimeout, "idle-timeout", multicast.DefaultIdleTimeout, "Repair the missed datagrams after receiving nothing for this long")
+
+	CastCmd.AddCommand(sendCmd, receiveCmd)
+}
+
+var CastCmd = &cobra.Command{
+	Use:   "cast",
+	Short: "EZFT Cast - Push a file to many machines of a LAN at once",
+	Long: `EZFT cast multicasts a file to all receivers of a LAN at once, so the network
+carries it once whatever the number of receivers. Start the receivers with
+"ezft cast receive", then the sender with "ezft cast send". Receivers fetch the
+datagrams they missed from the sender over HTTP and check the file against the
+SHA-256 of the sender.`,
+}
+
+var sendCmd = &cobra.Command{
+	Use:   "send <file>",
+	Short: "Multicast a file to the receivers of the group",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rate, err := ratelimit.ParseRate(castRate)
+		if err != nil {
+			return err
+		}
+		l, err := newLogger("cast-send.log")
+		if err != nil {
+			return err
+		}
+
+		sender := multicast.NewSender(&multicast.SenderConfig{
+			Path:       args[0],
+			Group:      castGroup,
+			Interface:  castInterface,
+			TTL:        castTTL,
+			Rate:       max(rate, 0),
+			Passes:     castPasses,
+			RepairAddr: castRepairListen,
+			Linger:     castLinger,
+		})
+		sender.SetLogger(l)
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+		fmt.Printf("Multicasting %s to %s\n", args[0], castGroup)
+		report, err := sender.Send(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ Sent %d bytes in %s, %d bytes repaired\n", report.Size, report.Duration.Round(time.Millisecond), report.Repaired)
+		return nil
+	},
+}
+
+var receiveCmd = &cobra.Command{
+	Use:   "receive <output>",
+	Short: "Receive the file multicast to the group",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		l, err := newLogger("cast-receive.log")
+		if err != nil {
+			return err
+		}
+
+		receiver := multicast.NewReceiver(&multicast.ReceiverConfig{
+			Group:       castGroup,
+			Interface:   castInterface,
+			OutputPath:  args[0],
+			Wait:        castWait,
+			IdleTimeout: castIdleTimeout,
+		})
+		receiver.SetLogger(l)
+		if err := receiver.Listen(); err != nil {
+			return err
+		}
+		defer receiver.Close()
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+		fmt.Printf("Waiting for a sender on %s\n", castGroup)
+		report, err := receiver.Receive(ctx)
+		if err != nil {
+			l.Error("multicast receive failed", zap.Error(err))
+			return err
+		}
+		fmt.Printf("✓ Received %d bytes in %s, %d bytes repaired\n", report.Size, report.Duration.Round(time.Millisecond), report.Repaired)
+		return nil
+	},
+}
+
+func newLogger(name string) (*zap.Logger, error) {
+	if logger.WritesFile(castLogOutput) {
+		if err := utils.EnsureDir(castLogHome); err != nil {
+			return nil, fmt.Errorf("failed to create log directory: %w", err)
+		}
+	}
+	l, err := logger.New(logger.Config{File: castLogHome + "/" + name, Output: castLogOutput, Level: castLogLevel, Format: castLogFormat})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logger: %w", err)
+	}
+	return l, nil
+}
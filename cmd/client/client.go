@@ -2,14 +2,30 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/easzlab/ezft/internal/config"
+	"github.com/easzlab/ezft/internal/exitcode"
+	"github.com/easzlab/ezft/internal/keyring"
+	"github.com/easzlab/ezft/internal/prompt"
 	"github.com/easzlab/ezft/pkg/client"
+	"github.com/easzlab/ezft/pkg/e2e"
+	"github.com/easzlab/ezft/pkg/hook"
+	"github.com/easzlab/ezft/pkg/metrics"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/sockopt"
 	"github.com/easzlab/ezft/pkg/utils"
 	"github.com/easzlab/ezft/pkg/utils/logger"
 	"github.com/spf13/cobra"
@@ -18,70 +34,357 @@ import (
 
 // client subcommand related variables
 var (
-	clientURL          string
-	clientOutput       string
-	clientChunkSize    int64
-	clientConcurrency  int
-	clientRetryCount   int
-	clientResume       bool
-	clientAutoChunk    bool
-	clientShowProgress bool
-	clientLogHome      string
-	clientLogLevel     string
+	clientURLs          []string
+	clientParallel      int
+	clientOutput        string
+	clientChunkSize     = utils.ByteSize(1024 * 1024)
+	clientConcurrency   int
+	clientPrewarm       int
+	clientRetryCount    int
+	clientResume        bool
+	clientAutoChunk     bool
+	clientShowProgress  bool
+	clientDelta         bool
+	clientPatchFrom     string
+	clientVerifyChunks  bool
+	clientChunkManifest string
+	clientPatchBase     string
+	clientQUIC          bool
+	clientUDPRate       string
+	clientMultiplex     bool
+	clientMultiRange    int
+	clientOffsetResume  bool
+	clientWaitLock      bool
+	clientDisposition   bool
+	clientCompress      bool
+	clientP2PListen     string
+	clientP2PAdvertise  string
+	clientP2PSeedTime   time.Duration
+	clientP2PRelay      bool
+	clientP2PPunch      bool
+	clientUser          string
+	clientNoInteractive bool
+	clientNoProxyEnv    bool
+	clientProbeCache    bool
+	clientTCPNoDelay    bool
+	clientTCPRcvBuf     utils.ByteSize
+	clientTCPSndBuf     utils.ByteSize
+	clientTCPCongestion string
+	clientE2EKey        string
+	clientBwLimit       string
+	clientLogHome       string
+	clientLogLevel      string
+	clientLogFormat     string
+	clientLogOutput     string
+	clientLogStdout     bool
+	clientTransferLogs  string
+	clientQuiet         bool
+	clientVerbose       bool
+	clientOutputFormat  string
+	clientDryRun        bool
+	clientSummary       bool
+	clientXattr         bool
+	clientTrace         bool
+	clientOnSuccess     string
+	clientOnFailure     string
+	clientWebhook       string
+	clientMetricsPush   string
+	clientConfig        string
+	clientProfile       string
+	clientBaseURL       string
+	clientOutputDir     string
 )
 
 func init() {
 	// client subcommand parameters
-	ClientCmd.Flags().StringVarP(&clientURL, "url", "u", "", "Download URL (required)")
+	ClientCmd.Flags().StringArrayVarP(&clientURLs, "url", "u", nil, "Download URL, repeated or given as arguments to download several files")
 	ClientCmd.Flags().StringVarP(&clientOutput, "output", "o", "", "Output file path")
+	ClientCmd.Flags().StringVar(&clientOutputDir, "output-dir", "down", "Directory of the output file when --output is not given")
+	ClientCmd.Flags().IntVar(&clientParallel, "parallel", client.DefaultBatchParallel, "Files downloaded at the same time when several URLs are given")
+	ClientCmd.Flags().StringVar(&clientBaseURL, "base-url", "", "URL relative download URLs are resolved against, such as http://mirror.example.com:8080/releases/")
 	ClientCmd.Flags().StringVarP(&clientLogHome, "log-home", "", "./logs", "Log file home")
-	ClientCmd.Flags().StringVarP(&clientLogLevel, "log-level", "", "debug", "Log level")
-	ClientCmd.Flags().Int64VarP(&clientChunkSize, "chunk-size", "s", 1024*1024, "Chunk size (bytes)")
-	ClientCmd.Flags().IntVarP(&clientConcurrency, "concurrency", "c", 1, "Concurrency count")
+	ClientCmd.Flags().StringVarP(&clientLogLevel, "log-level", "", "", "Log level, info by default, debug with --verbose and warn with --quiet")
+	ClientCmd.Flags().StringVar(&clientLogFormat, "log-format", logger.FormatConsole, "Log format, console or json for log ingestion pipelines")
+	ClientCmd.Flags().StringVar(&clientLogOutput, "log-output", logger.OutputFile, "Where the log goes: file under --log-home, stdout or stderr, or several separated by commas such as file,stderr")
+	ClientCmd.Flags().StringVar(&clientTransferLogs, "transfer-logs", "", "Also log every download to its own file in this directory, named after the output file, to look into a single failed download of a batch")
+	ClientCmd.Flags().BoolVar(&clientLogStdout, "log-stdout", false, "Log to the standard output instead of a file under --log-home, short for --log-output stdout")
+	ClientCmd.Flags().BoolVarP(&clientQuiet, "quiet", "q", false, "Print nothing but errors, without progress")
+	ClientCmd.Flags().BoolVarP(&clientVerbose, "verbose", "v", false, "Print the files being downloaded, and log debug messages")
+	ClientCmd.Flags().StringVar(&clientOutputFormat, "output-format", "text", "Format of the result printed on completion, text or json")
+	ClientCmd.Flags().BoolVar(&clientDryRun, "dry-run", false, "Probe the server and print how the files would be downloaded, without transferring them")
+	ClientCmd.Flags().BoolVar(&clientSummary, "summary", false, "Write <output>.ezft.json next to every completed download with its source URL, ETag, checksum, chunk layout, timings and the ezft version")
+	ClientCmd.Flags().BoolVar(&clientXattr, "xattr", false, "Record the source URL, ETag and SHA-256 of every completed download in its user.ezft.* extended attributes, Linux and macOS only")
+	ClientCmd.Flags().BoolVar(&clientTrace, "trace", false, "Time the DNS lookup, connect, TLS handshake and first byte of every chunk requested over HTTP and print a summary, to tell network latency from a slow server")
+	ClientCmd.Flags().VarP(&clientChunkSize, "chunk-size", "s", "Chunk size in bytes, with an optional binary unit such as 4M")
+	ClientCmd.Flags().IntVarP(&clientConcurrency, "concurrency", "c", 0, "Concurrency count, 0 picks it from the CPUs and, with --auto-chunk, the latency of the link")
+	ClientCmd.Flags().IntVar(&clientPrewarm, "prewarm", 0, "Open this many connections, up to --concurrency, at the same time before the chunks are transferred, so fast downloads do not ramp up one handshake at a time")
 	ClientCmd.Flags().IntVarP(&clientRetryCount, "retry", "r", 3, "Retry count")
 	ClientCmd.Flags().BoolVar(&clientResume, "resume", true, "Support resume download")
 	ClientCmd.Flags().BoolVar(&clientAutoChunk, "auto-chunk", true, "Auto chunking")
 	ClientCmd.Flags().BoolVarP(&clientShowProgress, "progress", "p", true, "Show download progress")
+	ClientCmd.Flags().BoolVar(&clientDisposition, "content-disposition", false, "Name output files after the Content-Disposition header of the server instead of the URL, unless --output is given")
+	ClientCmd.Flags().StringVar(&clientUser, "user", "", "Basic auth credentials username:password")
+	ClientCmd.Flags().BoolVar(&clientNoInteractive, "no-interactive", false, "Fail instead of asking for credentials on the terminal when the server requires them")
+	ClientCmd.Flags().BoolVar(&clientNoProxyEnv, "no-proxy-env", false, "Ignore the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables and connect to servers directly")
+	ClientCmd.Flags().BoolVar(&clientProbeCache, "probe-cache", false, "Remember for an hour in the user cache directory whether servers answer range requests and offer QUIC, sparing later downloads from them the probes")
+	ClientCmd.Flags().BoolVar(&clientTCPNoDelay, "tcp-nodelay", true, "Disable Nagle's algorithm on the connections to servers, sending small writes at once")
+	ClientCmd.Flags().Var(&clientTCPRcvBuf, "tcp-rcvbuf", "Receive buffer size of the connections to servers, such as 4M for links with a high bandwidth-delay product, 0 for the system default")
+	ClientCmd.Flags().Var(&clientTCPSndBuf, "tcp-sndbuf", "Send buffer size of the connections to servers, 0 for the system default")
+	ClientCmd.Flags().StringVar(&clientTCPCongestion, "tcp-congestion", "", "TCP congestion control of the connections to servers, such as bbr, Linux only")
+	ClientCmd.Flags().StringVar(&clientE2EKey, "e2e-key", "", "Pre-shared key decrypting file contents the server encrypts end to end (ezft server only)")
+	ClientCmd.Flags().StringVar(&clientBwLimit, "bwlimit", "", "Bandwidth limit, a rate such as 1M or a schedule such as \"09:00,1M 18:00,off\"")
+	// --limit-rate as in curl and wget
+	ClientCmd.Flags().SetNormalizeFunc(config.NormalizeAliases)
+	ClientCmd.Flags().BoolVar(&clientDelta, "delta", false, "Update an existing output file by transferring only changed blocks (ezft server only)")
+	ClientCmd.Flags().StringVar(&clientPatchFrom, "patch-from", "", "Build the file from this local older version and a binary diff generated by the server (ezft server only)")
+	ClientCmd.Flags().StringVar(&clientPatchBase, "patch-base", "", "Path of the --patch-from version on the server, by default the file of the same name next to the download")
+	ClientCmd.Flags().BoolVar(&clientVerifyChunks, "verify-chunks", false, "Verify every chunk against the chunk manifest of the server, also when resuming (ezft server only)")
+	ClientCmd.Flags().StringVar(&clientChunkManifest, "chunk-manifest", "", "Verify every chunk against this chunk manifest file, such as one generated by ezft manifest")
+	ClientCmd.Flags().BoolVar(&clientQUIC, "quic", true, "Transfer chunks over QUIC when the server offers the native protocol (ezft server only)")
+	ClientCmd.Flags().StringVar(&clientUDPRate, "udp-rate", "", "Blast chunks as UDP datagrams at up to this rate, such as 500M, for links with a high bandwidth-delay product (requires --quic)")
+	ClientCmd.Flags().BoolVar(&clientMultiplex, "multiplex", false, "Transfer all chunks over a single HTTP/2 connection, or a single HTTP/1.1 connection when the server lacks HTTP/2")
+	ClientCmd.Flags().IntVar(&clientMultiRange, "multi-range", 0, "Request up to this many chunks in a single multipart/byteranges request over HTTP, fewer requests for high-latency links, 0 or 1 for one chunk per request")
+	ClientCmd.Flags().BoolVar(&clientOffsetResume, "offset-resume", false, "Download over a single connection with one request of the bytes from the end of the partial file, for servers that accept ranges but misbehave under concurrent connections")
+	ClientCmd.Flags().BoolVar(&clientWaitLock, "wait-lock", false, "Wait for another ezft process downloading to the same output file to finish instead of failing")
+	ClientCmd.Flags().BoolVar(&clientCompress, "compress", false, "Ask the server to compress chunks sent over HTTP, skipped for files compressed already (ezft server only)")
+	ClientCmd.Flags().StringVar(&clientP2PListen, "p2p-listen", "", "Exchange chunks with the other clients of the file, serving them on this address such as :7400 (requires a server with --tracker)")
+	ClientCmd.Flags().StringVar(&clientP2PAdvertise, "p2p-advertise", "", "URL the other clients reach this one at, by default its address as seen by the server")
+	ClientCmd.Flags().DurationVar(&clientP2PSeedTime, "p2p-seed-time", 0, "Keep serving the complete file to the other clients for this long after the download")
+	ClientCmd.Flags().BoolVar(&clientP2PRelay, "p2p-relay", false, "Also serve the other clients through the server, for clients they cannot connect to such as behind NAT")
+	ClientCmd.Flags().BoolVar(&clientP2PPunch, "p2p-punch", false, "Connect to the other clients behind NAT by hole punching negotiated by the server before relaying, for clients with --p2p-relay")
 
-	// Mark required parameters
-	ClientCmd.MarkFlagRequired("url")
+	ClientCmd.Flags().StringVar(&clientOnSuccess, "on-success", "", "Shell command run after a successful download, with EZFT_* variables describing it")
+	ClientCmd.Flags().StringVar(&clientOnFailure, "on-failure", "", "Shell command run after a failed download, with EZFT_* variables describing it")
+	ClientCmd.Flags().StringVar(&clientWebhook, "webhook", "", "URL the result of the download is posted to as JSON")
+	ClientCmd.Flags().StringVar(&clientMetricsPush, "metrics-push", "", "Push the bytes, duration, retries and outcome of the download on completion to StatsD, such as statsd://127.0.0.1:8125, or a Prometheus Pushgateway, such as http://pushgateway:9091")
+
+	ClientCmd.Flags().StringVar(&clientProfile, "profile", "", "Profile of the configuration file whose options apply, taking precedence over its client section")
+	ClientCmd.Flags().StringVar(&clientConfig, "config", "", "YAML or TOML file with defaults of these options in its client section, by default the first ezft.yaml or ezft.toml found in the working directory, the user configuration directory and /etc/ezft")
+
+	ClientCmd.MarkFlagsMutuallyExclusive("quiet", "verbose")
+	ClientCmd.RegisterFlagCompletionFunc("output-format", cobra.FixedCompletions([]string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp))
+
+	ClientCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+	ClientCmd.RegisterFlagCompletionFunc("url", completeURLs)
+	ClientCmd.ValidArgsFunction = completeURLs
+	ClientCmd.MarkFlagDirname("output-dir")
 }
 
 var ClientCmd = &cobra.Command{
-	Use:   "client",
+	Use:   "client [URL...]",
 	Short: "EZFT Client - Download files",
 	Long:  "EZFT client supports high-performance concurrent downloads, with resume download, multi-threaded download and progress display.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// URLs may also come from the environment or the file
+		if err := config.ApplyEnv(cmd.Flags(), "client"); err != nil {
+			return exitcode.UsageError(err)
+		}
+		file, err := config.FindFile(clientConfig)
+		if err != nil {
+			return exitcode.UsageError(err)
+		}
+		// Options of the profile take precedence over the client section
+		if clientProfile != "" {
+			if err := file.ApplyProfile(cmd.Flags(), clientProfile); err != nil {
+				return exitcode.UsageError(err)
+			}
+		}
+		return exitcode.UsageError(file.Apply(cmd.Flags(), "client"))
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if clientOutput == "" {
-			urlParts := strings.Split(clientURL, "/")
-			// default output path is the last part of the URL
-			clientOutput = "down/" + urlParts[len(urlParts)-1]
+		if clientOutputFormat != "text" && clientOutputFormat != "json" {
+			return exitcode.UsageError(fmt.Errorf("invalid output format %q, must be text or json", clientOutputFormat))
 		}
-
-		if err := utils.EnsureDir(clientLogHome); err != nil {
-			return fmt.Errorf("failed to create log directory: %w", err)
+		if clientConcurrency < 0 {
+			return exitcode.UsageError(errors.New("--concurrency must not be negative"))
+		}
+		if clientMultiRange < 0 {
+			return exitcode.UsageError(errors.New("--multi-range must not be negative"))
+		}
+		jsonOutput := clientOutputFormat == "json"
+		logOutputs, err := logger.ParseOutput(logOutput())
+		if err != nil {
+			return exitcode.UsageError(err)
+		}
+		if jsonOutput && slices.Contains(logOutputs, logger.OutputStdout) {
+			return exitcode.UsageError(errors.New("logging to the standard output would mix the log with the JSON result"))
+		}
+		urls := slices.Concat(clientURLs, args)
+		if len(urls) == 0 {
+			return exitcode.UsageError(errors.New("no download URL, give one with --url or as an argument"))
+		}
+		if len(urls) > 1 {
+			for _, name := range []string{"output", "patch-from", "chunk-manifest", "p2p-listen"} {
+				if cmd.Flags().Changed(name) {
+					return exitcode.UsageError(fmt.Errorf("--%s applies to a single URL", name))
+				}
+			}
+		}
+		outputs := make([]string, len(urls))
+		downloadedBy := make(map[string]string)
+		for i := range urls {
+			if clientBaseURL != "" {
+				resolved, err := resolveURL(clientBaseURL, urls[i])
+				if err != nil {
+					return exitcode.UsageError(err)
+				}
+				urls[i] = resolved
+			}
+			outputs[i] = clientOutput
+			if outputs[i] == "" {
+				// default output path is the decoded last part of the URL
+				outputs[i] = filepath.Join(clientOutputDir, utils.URLFilename(urls[i]))
+			}
+			if other, ok := downloadedBy[outputs[i]]; ok {
+				return exitcode.UsageError(fmt.Errorf("%s and %s would both be downloaded to %s", other, urls[i], outputs[i]))
+			}
+			downloadedBy[outputs[i]] = urls[i]
 		}
 
-		// Create logger
-		l, err := logger.NewLogger(clientLogHome+"/client.log", clientLogLevel)
+		l, err := newLogger()
 		if err != nil {
-			return fmt.Errorf("failed to create logger: %w", err)
+			return err
+		}
+		// The standard output only carries the result in JSON
+		out := io.Writer(os.Stdout)
+		if clientQuiet || jsonOutput {
+			out = io.Discard
 		}
 
-		// Create download configuration
-		config := &client.DownloadConfig{
-			URL:            clientURL,
-			OutputPath:     clientOutput,
-			ChunkSize:      clientChunkSize,
-			MaxConcurrency: clientConcurrency,
-			RetryCount:     clientRetryCount,
-			EnableResume:   clientResume,
-			AutoChunk:      clientAutoChunk,
+		// Remembered for the completion of --url
+		for _, u := range urls {
+			if err := config.RecordURL(u); err != nil {
+				l.Warn("failed to record URL", zap.Error(err))
+			}
 		}
 
-		// Create client
-		downloadClient := client.NewClient(config)
-		downloadClient.SetLogger(l)
+		hooks := &hook.Hooks{OnSuccess: clientOnSuccess, OnFailure: clientOnFailure, Webhook: clientWebhook}
+		if err := hooks.Validate(); err != nil {
+			return exitcode.UsageError(err)
+		}
+		var pusher *metrics.Pusher
+		if clientMetricsPush != "" {
+			if pusher, err = metrics.NewPusher(clientMetricsPush); err != nil {
+				return exitcode.UsageError(err)
+			}
+		}
+
+		var username, password string
+		if clientUser != "" {
+			if username, password, err = utils.ParseCredentials(clientUser); err != nil {
+				return exitcode.UsageError(err)
+			}
+		}
+		// Credentials the server requires are those stored by ezft login, else
+		// asked on the terminal unless given
+		var prompter *prompt.Prompter
+		if clientUser == "" && !clientNoInteractive && prompt.Interactive() {
+			prompter = prompt.NewPrompter()
+			out = prompter.Writer(out)
+		}
+		credentials := keyring.CredentialsFunc(prompter)
+
+		if clientTCPCongestion != "" {
+			if err := sockopt.CheckCongestion(clientTCPCongestion); err != nil {
+				return exitcode.UsageError(err)
+			}
+		}
+
+		var udpRate int64
+		if clientUDPRate != "" {
+			if udpRate, err = ratelimit.ParseRate(clientUDPRate); err != nil {
+				return exitcode.UsageError(err)
+			}
+		}
+
+		// The files of a batch share the bandwidth limit
+		var limiter *ratelimit.Limiter
+		if clientBwLimit != "" {
+			schedule, err := ratelimit.ParseSchedule(clientBwLimit)
+			if err != nil {
+				return exitcode.UsageError(err)
+			}
+			limiter = ratelimit.NewLimiter(schedule)
+		}
+		var key *e2e.Key
+		if clientE2EKey != "" {
+			if key, err = e2e.ParseKey(clientE2EKey); err != nil {
+				return exitcode.UsageError(err)
+			}
+		}
+
+		if clientTransferLogs != "" {
+			if err := utils.EnsureDir(clientTransferLogs); err != nil {
+				return fmt.Errorf("failed to create transfer log directory: %w", err)
+			}
+		}
+
+		// The files of a batch share the probes of their servers
+		probes := client.NewProbeCache()
+		if clientProbeCache {
+			path, err := config.CachePath("probes.json")
+			if err != nil {
+				return fmt.Errorf("failed to locate probe cache: %w", err)
+			}
+			if probes, err = client.LoadProbeCache(path); err != nil {
+				l.Warn("Ignoring the probe cache", zap.Error(err))
+				probes = client.NewProbeCache()
+			}
+		}
+
+		// Create a client per file
+		clients := make([]*client.Client, len(urls))
+		for i := range urls {
+			config := &client.DownloadConfig{
+				URL:             urls[i],
+				OutputPath:      outputs[i],
+				ChunkSize:       int64(clientChunkSize),
+				MaxConcurrency:  clientConcurrency,
+				AutoConcurrency: clientConcurrency == 0,
+				PrewarmConns:    clientPrewarm,
+				RetryCount:      clientRetryCount,
+				EnableResume:    clientResume,
+				AutoChunk:       clientAutoChunk,
+				EnableDelta:     clientDelta,
+				PatchFrom:       clientPatchFrom,
+				VerifyChunks:    clientVerifyChunks,
+				ChunkManifest:   clientChunkManifest,
+				PatchBase:       clientPatchBase,
+				DisableQUIC:     !clientQUIC,
+				UDPRate:         udpRate,
+				Multiplex:       clientMultiplex,
+				MultiRange:      clientMultiRange,
+				OffsetResume:    clientOffsetResume,
+				WaitLock:        clientWaitLock,
+				Compress:        clientCompress,
+				P2PListen:       clientP2PListen,
+				P2PAdvertise:    clientP2PAdvertise,
+				P2PSeedTime:     clientP2PSeedTime,
+				P2PRelay:        clientP2PRelay,
+				P2PPunch:        clientP2PPunch,
+				NoProxyEnv:      clientNoProxyEnv,
+				Socket:          socketOptions(),
+				LogFormat:       clientLogFormat,
+				Trace:           clientTrace,
+				Username:        username,
+				Password:        password,
+			}
+			// The output files of a batch share a directory, their names differ
+			if clientTransferLogs != "" {
+				config.LogFile = filepath.Join(clientTransferLogs, filepath.Base(outputs[i])+".log")
+			}
+			clients[i] = client.NewClient(config)
+			clients[i].SetLogger(l)
+			clients[i].SetOutput(out)
+			if limiter != nil {
+				clients[i].SetRateLimiter(limiter)
+			}
+			if key != nil {
+				clients[i].SetE2EKey(key)
+			}
+			clients[i].SetCredentialsFunc(credentials)
+			clients[i].SetProbeCache(probes)
+		}
 
 		// Set signal handling
 		ctx, cancel := context.WithCancel(context.Background())
@@ -90,41 +393,575 @@ var ClientCmd = &cobra.Command{
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+		// The state of interrupted downloads is saved for resuming them,
+		// unless interrupted again
 		go func() {
 			<-sigChan
-			fmt.Println("\nReceived interrupt signal, stopping download...")
+			fmt.Fprintln(out, "\nReceived interrupt signal, saving download state, interrupt again to quit at once...")
 			cancel()
+			<-sigChan
+			os.Exit(exitcode.Canceled)
 		}()
 
+		if clientDisposition && clientOutput == "" {
+			if err := nameByDisposition(ctx, clients, urls, outputs, l); err != nil {
+				return exitcode.UsageError(err)
+			}
+		}
+
+		if clientDryRun {
+			return printPlans(ctx, clients, urls, outputs)
+		}
+		if clientVerbose {
+			for i := range urls {
+				fmt.Fprintf(out, "Downloading %s to %s\n", urls[i], outputs[i])
+			}
+		}
+		if len(clients) > 1 {
+			return downloadBatch(ctx, clients, hooks, pusher, l, out)
+		}
+		downloadClient := clients[0]
+
 		startTime := time.Now()
 
 		// Start progress display
-		if clientShowProgress {
+		if clientShowProgress && !clientQuiet && !jsonOutput {
 			go downloadClient.ShowProgressLoop(ctx)
 		}
 
 		// Execute download
-		if err := downloadClient.Download(ctx); err != nil {
+		err = downloadClient.Download(ctx)
+		duration := time.Since(startTime)
+		size, _ := utils.GetFileSize(outputs[0])
+		pushMetrics(pusher, l, &metrics.Transfer{Type: "download", Bytes: size, Duration: duration, Retries: downloadClient.Retries(), Err: err})
+
+		var event *hook.Event
+		if !hooks.Empty() || jsonOutput {
+			event = downloadEvent(urls[0], outputs[0], downloadClient.Checksum(), err, duration)
+		}
+		if err != nil {
+			// Failure hooks run unless interrupted
+			if ctx.Err() == nil {
+				if hookErr := runHooks(hooks, event); hookErr != nil {
+					l.Info("Download hooks failed",
+						zap.Error(hookErr),
+					)
+				}
+			}
+			if jsonOutput {
+				printJSON(newResult(event, downloadClient))
+			} else if clientTrace {
+				printTrace(out, downloadClient)
+			}
 			return fmt.Errorf("download failed: %w", err)
 		}
-
-		duration := time.Since(startTime)
+		// Before the hooks, which may read it
+		if clientSummary {
+			if err := downloadClient.WriteSummary(config.FullVersion()); err != nil {
+				return fmt.Errorf("failed to write download summary: %w", err)
+			}
+		}
+		if clientXattr {
+			writeXattrs(downloadClient, outputs[0], l)
+		}
 
 		// Display file information
-		if info, err := os.Stat(clientOutput); err == nil {
-			fmt.Printf("\n✓ Download completed! Duration: %s File size: %s Average speed: %s\n",
+		if info, err := os.Stat(outputs[0]); err == nil {
+			fmt.Fprintf(out, "\n✓ Download completed! Duration: %s File size: %s Average speed: %s\n",
 				utils.FormatDuration(duration),
 				utils.FormatBytes(info.Size()),
 				utils.CalculateSpeed(info.Size(), duration),
 			)
-			l.Info("",
-				zap.String("msg", "Download completed"),
+			l.Info("Download completed",
 				zap.String("duration", utils.FormatDuration(duration)),
 				zap.String("file_size", utils.FormatBytes(info.Size())),
 				zap.String("average_speed", utils.CalculateSpeed(info.Size(), duration)),
 			)
 		}
+		if clientTrace && !jsonOutput {
+			printTrace(out, downloadClient)
+		}
 
-		return nil
+		err = runHooks(hooks, event)
+		if jsonOutput {
+			printJSON(newResult(event, downloadClient))
+		}
+		return err
 	},
 }
+
+// writeXattrs records the provenance of the download of c in the extended
+// attributes of its output file, a failure only being logged as the file is
+// complete
+func writeXattrs(c *client.Client, path string, l *zap.Logger) {
+	if err := c.WriteXattrs(); err != nil {
+		l.Warn("Failed to record the provenance in extended attributes",
+			zap.String("path", path),
+			zap.Error(err),
+		)
+	}
+}
+
+// nameByDisposition renames the outputs of the clients after the file names
+// the Content-Disposition headers of their servers suggest, keeping the names
+// from the URLs of servers suggesting none
+func nameByDisposition(ctx context.Context, clients []*client.Client, urls, outputs []string, l *zap.Logger) error {
+	downloadedBy := make(map[string]string)
+	for i, c := range clients {
+		name, err := c.RemoteFilename(ctx)
+		if err != nil {
+			l.Warn("Failed to ask the server for the file name",
+				zap.String("url", urls[i]),
+				zap.Error(err),
+			)
+		}
+		if name != "" {
+			outputs[i] = filepath.Join(clientOutputDir, name)
+			c.SetOutputPath(outputs[i])
+		}
+		if other, ok := downloadedBy[outputs[i]]; ok {
+			return fmt.Errorf("%s and %s would both be downloaded to %s", other, urls[i], outputs[i])
+		}
+		downloadedBy[outputs[i]] = urls[i]
+	}
+	return nil
+}
+
+// downloadBatch downloads the files of clients through a batch, with combined
+// progress and a summary table of the files
+func downloadBatch(ctx context.Context, clients []*client.Client, hooks *hook.Hooks, pusher *metrics.Pusher, l *zap.Logger, out io.Writer) error {
+	batch := client.NewBatch(clients, clientParallel)
+	batch.SetLogger(l)
+	batch.SetOutput(out)
+	jsonOutput := clientOutputFormat == "json"
+
+	// The table follows the last frame of the progress
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	progressDone := make(chan struct{})
+	if clientShowProgress && !clientQuiet && !jsonOutput {
+		go func() {
+			defer close(progressDone)
+			batch.ShowProgressLoop(progressCtx)
+		}()
+	} else {
+		close(progressDone)
+	}
+	startTime := time.Now()
+	results := batch.Download(ctx)
+	duration := time.Since(startTime)
+	if clientSummary {
+		for i, result := range results {
+			if result.Err != nil {
+				continue
+			}
+			if err := clients[i].WriteSummary(config.FullVersion()); err != nil {
+				result.Err = fmt.Errorf("failed to write download summary: %w", err)
+			}
+		}
+	}
+	if clientXattr {
+		for i, result := range results {
+			if result.Err == nil {
+				writeXattrs(clients[i], result.OutputPath, l)
+			}
+		}
+	}
+	stopProgress()
+	<-progressDone
+
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSIZE\tDURATION\tSPEED\tSTATUS")
+	var failed []string
+	var errs []error
+	var total, retries int64
+	batchResult := &result{Status: hook.StatusSuccess, Duration: duration.Seconds()}
+	for i, result := range results {
+		status := "ok"
+		if result.Err != nil {
+			status = "failed: " + result.Err.Error()
+			failed = append(failed, result.URL)
+			errs = append(errs, result.Err)
+		}
+		total += result.Size
+		retries += result.Retries
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			result.OutputPath,
+			utils.FormatBytes(result.Size),
+			utils.FormatDuration(result.Duration),
+			utils.CalculateSpeed(result.Size, result.Duration),
+			status,
+		)
+
+		var event *hook.Event
+		if !hooks.Empty() || jsonOutput {
+			event = downloadEvent(result.URL, result.OutputPath, clients[i].Checksum(), result.Err, result.Duration)
+		}
+		if jsonOutput {
+			fileResult := newResult(event, clients[i])
+			batchResult.Files = append(batchResult.Files, fileResult)
+			batchResult.Bytes += fileResult.Bytes
+			batchResult.Retries += fileResult.Retries
+		}
+
+		// Failure hooks run unless interrupted
+		if result.Err != nil && ctx.Err() != nil {
+			continue
+		}
+		if err := runHooks(hooks, event); err != nil {
+			l.Info("Download hooks failed",
+				zap.String("url", result.URL),
+				zap.Error(err),
+			)
+		}
+	}
+	w.Flush()
+	fmt.Fprintf(out, "%d of %d files downloaded, %s in %s\n", len(results)-len(failed), len(results), utils.FormatBytes(total), utils.FormatDuration(duration))
+	if clientTrace && !jsonOutput {
+		printTrace(out, clients...)
+	}
+
+	var err error
+	if len(failed) > 0 {
+		err = &batchError{
+			msg:  fmt.Sprintf("%d of %d downloads failed: %s", len(failed), len(results), strings.Join(failed, ", ")),
+			errs: errs,
+		}
+		batchResult.Status = hook.StatusFailure
+		batchResult.Error = err.Error()
+	}
+	pushMetrics(pusher, l, &metrics.Transfer{Type: "download", Bytes: total, Duration: duration, Retries: retries, Err: err})
+	if jsonOutput {
+		printJSON(batchResult)
+	}
+	return err
+}
+
+// printPlans probes the downloads of urls to outputs by clients and prints
+// how they would proceed, in JSON with --output-format json
+func printPlans(ctx context.Context, clients []*client.Client, urls, outputs []string) error {
+	results := make([]*planResult, len(clients))
+	var failed []string
+	var errs []error
+	for i, c := range clients {
+		plan, err := c.Plan(ctx)
+		results[i] = &planResult{Plan: plan, URL: urls[i], Path: outputs[i]}
+		if err != nil {
+			results[i].Error = err.Error()
+			failed = append(failed, results[i].URL)
+			errs = append(errs, err)
+		}
+	}
+
+	if clientOutputFormat == "json" {
+		if len(results) == 1 {
+			printJSON(results[0])
+		} else {
+			printJSON(&planResult{Files: results})
+		}
+	} else {
+		for i, r := range results {
+			if i > 0 {
+				fmt.Println()
+			}
+			printPlan(r)
+		}
+	}
+
+	switch {
+	case len(errs) == 0:
+		return nil
+	case len(clients) == 1:
+		return fmt.Errorf("dry run failed: %w", errs[0])
+	default:
+		return &batchError{
+			msg:  fmt.Sprintf("%d of %d dry runs failed: %s", len(failed), len(results), strings.Join(failed, ", ")),
+			errs: errs,
+		}
+	}
+}
+
+// planResult the plan of a download printed with --dry-run
+type planResult struct {
+	*client.Plan
+	URL   string        `json:"url,omitempty"`
+	Path  string        `json:"path,omitempty"`
+	Error string        `json:"error,omitempty"`
+	Files []*planResult `json:"files,omitempty"` // Plans of the files when several URLs are given
+}
+
+// printPlan prints the plan r as text
+func printPlan(r *planResult) {
+	fmt.Println(r.URL)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "  Output:\t%s\n", r.Path)
+	if r.Plan == nil {
+		fmt.Fprintf(w, "  Error:\t%s\n", r.Error)
+		return
+	}
+	p := r.Plan
+	rangeSupport := "range requests supported"
+	if !p.SupportsRange {
+		rangeSupport = "no range requests"
+	}
+	size, remaining := utils.FormatBytes(p.Size), utils.FormatBytes(p.Remaining)
+	if p.Size < 0 {
+		size, remaining, rangeSupport = "unknown", "unknown", "streamed"
+	}
+	fmt.Fprintf(w, "  Size:\t%s (%s)\n", size, rangeSupport)
+	strategy := p.Strategy
+	if p.Transport != "" {
+		strategy += " over " + p.Transport
+	}
+	fmt.Fprintf(w, "  Strategy:\t%s\n", strategy)
+	if p.Compression != "" {
+		fmt.Fprintf(w, "  Compression:\t%s\n", p.Compression)
+	}
+	if p.Chunks > 0 {
+		chunkSize := utils.FormatBytes(p.ChunkSize)
+		if clientAutoChunk {
+			chunkSize += " (auto)"
+		}
+		fmt.Fprintf(w, "  Chunk size:\t%s\n", chunkSize)
+		chunks := fmt.Sprint(p.Chunks)
+		if p.VerifyChunks {
+			chunks += ", at most, chunks on disk matching the manifest are kept"
+		}
+		fmt.Fprintf(w, "  Chunks:\t%s\n", chunks)
+	}
+	fmt.Fprintf(w, "  Concurrency:\t%d\n", p.Concurrency)
+	if p.ExistingSize > 0 {
+		resume := utils.FormatBytes(p.ExistingSize) + " on disk"
+		switch {
+		case p.Strategy == client.StrategyBasic:
+			resume += ", overwritten"
+		case p.FailedChunks > 0:
+			resume += fmt.Sprintf(" reused, %d failed chunks downloaded again first", p.FailedChunks)
+		default:
+			resume += " reused"
+		}
+		fmt.Fprintf(w, "  Resume:\t%s\n", resume)
+	}
+	fmt.Fprintf(w, "  Remaining:\t%s\n", remaining)
+}
+
+// printTrace prints the summary of the timings of the requests of clients
+// traced with --trace
+func printTrace(out io.Writer, clients ...*client.Client) {
+	var traces []client.ChunkTrace
+	quic := false
+	for _, c := range clients {
+		traces = append(traces, c.Traces()...)
+		if s := c.Summary(); s != nil && s.Transport == "quic" {
+			quic = true
+		}
+	}
+	stats := client.SummarizeTraces(traces)
+	if stats == nil {
+		fmt.Fprintln(out, "\nNothing was requested over HTTP to trace")
+		return
+	}
+	fmt.Fprintf(out, "\nTimings of %d requests, %d over reused connections:\n", stats.Requests, stats.Reused)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PHASE\tCOUNT\tMIN\tAVG\tP95\tMAX")
+	for _, phase := range []struct {
+		name  string
+		stats client.PhaseStats
+	}{
+		{"dns", stats.DNS},
+		{"connect", stats.Connect},
+		{"tls", stats.TLS},
+		{"wait", stats.Wait},
+		{"ttfb", stats.TTFB},
+		{"transfer", stats.Transfer},
+	} {
+		p := phase.stats
+		if p.Count == 0 {
+			fmt.Fprintf(w, "%s\t0\t-\t-\t-\t-\n", phase.name)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", phase.name, p.Count,
+			p.Min.Round(time.Microsecond), p.Avg.Round(time.Microsecond), p.P95.Round(time.Microsecond), p.Max.Round(time.Microsecond))
+	}
+	w.Flush()
+	fmt.Fprintln(out, "connect takes about a network round trip, wait the server time plus a round trip")
+	if quic {
+		fmt.Fprintln(out, "Chunks transferred over QUIC are not traced, add --quic=false to trace them")
+	}
+}
+
+// batchError the failure of downloads of a batch, matching their errors
+type batchError struct {
+	msg  string
+	errs []error
+}
+
+func (e *batchError) Error() string {
+	return e.msg
+}
+
+func (e *batchError) Unwrap() []error {
+	return e.errs
+}
+
+// runHooks runs the hooks of the download described by event
+func runHooks(hooks *hook.Hooks, event *hook.Event) error {
+	if hooks.Empty() {
+		return nil
+	}
+	return hooks.Run(context.Background(), event)
+}
+
+// pushMetrics pushes the metrics of the download unless pusher is nil, a
+// failed push only being logged
+func pushMetrics(pusher *metrics.Pusher, l *zap.Logger, t *metrics.Transfer) {
+	if pusher == nil {
+		return
+	}
+	if err := pusher.Push(context.Background(), t); err != nil {
+		l.Warn("failed to push metrics", zap.Error(err))
+	}
+}
+
+// downloadEvent describes the download of rawURL to path that finished with
+// err, hashing the file of a successful download unless its checksum was
+// computed while it was downloaded
+func downloadEvent(rawURL, path, checksum string, err error, duration time.Duration) *hook.Event {
+	size, _ := utils.GetFileSize(path)
+	event := &hook.Event{
+		Status:   hook.StatusSuccess,
+		Type:     "download",
+		URL:      rawURL,
+		Path:     path,
+		Bytes:    size,
+		Duration: duration,
+	}
+	if err != nil {
+		event.Status = hook.StatusFailure
+		event.Error = err.Error()
+	} else if checksum != "" {
+		event.Checksum = checksum
+	} else {
+		event.Checksum, _ = utils.CalculateFileHash(path, "sha256")
+	}
+	return event
+}
+
+// result the outcome printed with --output-format json
+type result struct {
+	Status   string             `json:"status"` // success or failure
+	URL      string             `json:"url,omitempty"`
+	Path     string             `json:"path,omitempty"`
+	Bytes    int64              `json:"bytes"`
+	Duration float64            `json:"duration"`           // Seconds
+	Checksum string             `json:"checksum,omitempty"` // sha256 of the file after a successful download
+	Retries  int64              `json:"retries"`            // Chunk or whole file transfers retried
+	Error    string             `json:"error,omitempty"`
+	Trace    *client.TraceStats `json:"trace,omitempty"` // Timings of the chunk requests with --trace
+	Files    []*result          `json:"files,omitempty"` // Results of the files when several URLs are given
+}
+
+// newResult returns the result of the download of c described by event
+func newResult(event *hook.Event, c *client.Client) *result {
+	return &result{
+		Status:   event.Status,
+		URL:      event.URL,
+		Path:     event.Path,
+		Bytes:    event.Bytes,
+		Duration: event.Duration.Seconds(),
+		Checksum: event.Checksum,
+		Retries:  c.Retries(),
+		Error:    event.Error,
+		Trace:    c.TraceStats(),
+	}
+}
+
+// printJSON prints v as JSON on the standard output
+func printJSON(v any) {
+	data, _ := json.Marshal(v)
+	fmt.Println(string(data))
+}
+
+// logOutput returns the outputs of the log, --log-stdout being short for
+// --log-output stdout
+func logOutput() string {
+	if clientLogStdout {
+		return logger.OutputStdout
+	}
+	return clientLogOutput
+}
+
+// newLogger creates the logger of the client, writing to its outputs
+func newLogger() (*zap.Logger, error) {
+	level := clientLogLevel
+	if level == "" {
+		switch {
+		case clientQuiet:
+			level = "warn"
+		case clientVerbose:
+			level = "debug"
+		default:
+			level = "info"
+		}
+	}
+	output := logOutput()
+	if logger.WritesFile(output) {
+		if err := utils.EnsureDir(clientLogHome); err != nil {
+			return nil, fmt.Errorf("failed to create log directory: %w", err)
+		}
+	}
+	l, err := logger.New(logger.Config{File: clientLogHome + "/client.log", Output: output, Level: level, Format: clientLogFormat})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logger: %w", err)
+	}
+	return l, nil
+}
+
+// resolveURL resolves the download URL ref against base unless it is absolute
+func resolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil || b.Scheme == "" || b.Host == "" {
+		return "", fmt.Errorf("invalid base URL %q", base)
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %w", ref, err)
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// completeProfiles completes the profiles of the configuration file
+func completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	file, err := config.FindFile(clientConfig)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	return file.ProfileNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeURLs completes the recently downloaded URLs
+func completeURLs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var urls []string
+	for _, u := range config.RecentURLs() {
+		if strings.HasPrefix(u, toComplete) {
+			urls = append(urls, u)
+		}
+	}
+	return urls, cobra.ShellCompDirectiveNoFileComp
+}
+
+// socketOptions returns the TCP options of the flags, nil if they keep the
+// defaults
+func socketOptions() *sockopt.Options {
+	o := &sockopt.Options{
+		Nagle:       !clientTCPNoDelay,
+		ReadBuffer:  int(clientTCPRcvBuf),
+		WriteBuffer: int(clientTCPSndBuf),
+		Congestion:  clientTCPCongestion,
+	}
+	if *o == (sockopt.Options{}) {
+		return nil
+	}
+	return o
+}
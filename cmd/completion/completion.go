@@ -0,0 +1,38 @@
+package completion
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var CompletionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish|powershell",
+	Short: "EZFT Completion - Generate the shell completion script",
+	Long: `EZFT completion writes the completion script of a shell to standard output.
+Besides commands and flags, it completes the profiles of the configuration file
+after --profile and recently downloaded URLs after --url.
+
+  bash:       source <(ezft completion bash)
+  zsh:        ezft completion zsh > "${fpath[1]}/_ezft"
+  fish:       ezft completion fish > ~/.config/fish/completions/ezft.fish
+  powershell: ezft completion powershell | Out-String | Invoke-Expression`,
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root := cmd.Root()
+		switch args[0] {
+		case "bash":
+			return root.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return root.GenZshCompletion(os.Stdout)
+		case "fish":
+			return root.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			return root.GenPowerShellCompletionWithDesc(os.Stdout)
+		}
+		return fmt.Errorf("unsupported shell %q", args[0])
+	},
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/easzlab/ezft/cmd/client"
+	"github.com/easzlab/ezft/cmd/server"
+	"github.com/easzlab/ezft/internal/config"
+	"github.com/easzlab/ezft/internal/exitcode"
+	"github.com/easzlab/ezft/pkg/e2e"
+	"github.com/easzlab/ezft/pkg/ftp"
+	"github.com/easzlab/ezft/pkg/metrics"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/sockopt"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/easzlab/ezft/pkg/utils/logger"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap/zapcore"
+)
+
+// config subcommand related variables
+var (
+	configFile      string
+	configEffective bool
+	configProfile   string
+)
+
+func init() {
+	// config subcommand parameters
+	ConfigCmd.PersistentFlags().StringVar(&configFile, "config", "", "YAML or TOML configuration file, by default the first ezft.yaml or ezft.toml found in the working directory, the user configuration directory and /etc/ezft")
+	showCmd.Flags().BoolVar(&configEffective, "effective", false, "Print the value of every option of the command and where it comes from: the command line, the environment, the file or the default")
+	showCmd.Flags().StringVar(&configProfile, "profile", "", "Profile of the configuration file applied, with --effective for the client")
+
+	ConfigCmd.AddCommand(validateCmd, showCmd)
+}
+
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "EZFT Config - Check and show the configuration",
+	Long: `EZFT config checks the configuration file of the client and the server, and
+shows the options the commands run with once the command line, the environment
+and the file are merged, to find out why a setting does not take effect.
+
+  ezft config validate
+  ezft config show --config /etc/ezft/mirror.yaml
+  ezft config show --effective client --profile mirror-eu -- -c 4`,
+}
+
+// commands the commands reading a section of the configuration file
+var commands = map[string]*cobra.Command{
+	"client": client.ClientCmd,
+	"server": server.ServerCmd,
+}
+
+// checks check the values of options beyond their type, as the commands do
+// before running
+var checks = map[string]func(string) error{
+	"auth":     checkCredentials,
+	"base-url": checkURL,
+	"bwlimit": func(value string) error {
+		_, err := ratelimit.ParseSchedule(value)
+		return err
+	},
+	"e2e-key": func(value string) error {
+		_, err := e2e.ParseKey(value)
+		return err
+	},
+	"ftp-passive-ports": func(value string) error {
+		_, _, err := ftp.ParsePortRange(value)
+		return err
+	},
+	"log-format": logger.CheckFormat,
+	"log-output": func(value string) error {
+		_, err := logger.ParseOutput(value)
+		return err
+	},
+	"log-level": func(value string) error {
+		var level zapcore.Level
+		return level.UnmarshalText([]byte(value))
+	},
+	"metrics-push": func(value string) error {
+		_, err := metrics.NewPusher(value)
+		return err
+	},
+	"output-format": func(value string) error {
+		if value != "text" && value != "json" {
+			return fmt.Errorf("invalid output format %q, must be text or json", value)
+		}
+		return nil
+	},
+	"tcp-congestion": sockopt.CheckCongestion,
+	"udp-rate": func(value string) error {
+		_, err := ratelimit.ParseRate(value)
+		return err
+	},
+	"user": checkCredentials,
+}
+
+func checkCredentials(value string) error {
+	_, _, err := utils.ParseCredentials(value)
+	return err
+}
+
+func checkURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", value)
+	}
+	return nil
+}
+
+// check checks the value of the option name, empty values being the default
+// of all the options checked
+func check(name, value string) error {
+	if fn, ok := checks[name]; ok && value != "" {
+		return fn(value)
+	}
+	return nil
+}
+
+// newFlagSet returns the flags of the command reading section, unchanged
+func newFlagSet(section string) *pflag.FlagSet {
+	cmd, ok := commands[section]
+	if !ok {
+		return nil
+	}
+	fs := pflag.NewFlagSet(cmd.Name(), pflag.ContinueOnError)
+	fs.SetNormalizeFunc(cmd.Flags().GetNormalizeFunc())
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		clone := *flag
+		clone.Changed = false
+		fs.AddFlag(&clone)
+	})
+	return fs
+}
+
+// configPath returns the configuration file given, else the one named by the
+// environment as the command reading section finds it
+func configPath(section string) string {
+	if configFile != "" {
+		return configFile
+	}
+	for _, name := range []string{config.EnvName(section, "config"), config.EnvName("", "config")} {
+		if path := os.Getenv(name); path != "" {
+			return path
+		}
+	}
+	return ""
+}
+
+// findFile returns the configuration file, failing if there is none
+func findFile() (*config.File, error) {
+	file, err := config.FindFile(configPath(""))
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("no configuration file found in %s", strings.Join(config.SearchPaths(), ", "))
+	}
+	return file, nil
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration file",
+	Long: `Check that the configuration file is valid YAML or TOML, that its sections are those
+of commands and that every option is one of the command with a valid value.
+All the problems are printed with their line and column, and the exit status
+is 2 if there is any.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := findFile()
+		if err != nil {
+			return exitcode.UsageError(err)
+		}
+		errs := file.Validate(newFlagSet, check)
+		if len(errs) == 0 {
+			fmt.Printf("%s: valid\n", file.Path)
+			return nil
+		}
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return exitcode.UsageError(fmt.Errorf("%s: %d invalid options", file.Path, len(errs)))
+	},
+}
+
+var showCmd = &cobra.Command{
+	Use:   "show [command] [-- flags]",
+	Short: "Show the configuration file, or the effective options of a command",
+	Long: `Show the configuration file used. With --effective, show instead the value
+of every option of the command, client by default, once the flags given after
+--, the environment and the file are merged as the command does, and where each
+value comes from.`,
+	ValidArgs: []string{"client", "server"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !configEffective {
+			if len(args) > 0 {
+				return exitcode.UsageError(errors.New("a command is only given with --effective"))
+			}
+			file, err := findFile()
+			if err != nil {
+				return exitcode.UsageError(err)
+			}
+			data, err := os.ReadFile(file.Path)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("# %s\n%s", file.Path, data)
+			return nil
+		}
+
+		section := "client"
+		if len(args) > 0 && cmd.ArgsLenAtDash() != 0 {
+			section, args = args[0], args[1:]
+		}
+		fs := newFlagSet(section)
+		if fs == nil {
+			return exitcode.UsageError(fmt.Errorf("unknown command %q, want client or server", section))
+		}
+		if configProfile != "" && section != "client" {
+			return exitcode.UsageError(errors.New("--profile only applies to the client"))
+		}
+		if err := fs.Parse(args); err != nil {
+			return exitcode.UsageError(err)
+		}
+		// The commands have a --config flag of their own
+		path := configPath(section)
+		if flag := fs.Lookup("config"); flag != nil && flag.Changed {
+			path = flag.Value.String()
+		}
+		file, err := config.FindFile(path)
+		if err != nil {
+			return exitcode.UsageError(err)
+		}
+		settings, err := config.Effective(fs, section, file, configProfile)
+		if err != nil {
+			return exitcode.UsageError(err)
+		}
+
+		if file != nil {
+			fmt.Printf("Configuration file: %s\n\n", file.Path)
+		} else {
+			fmt.Print("Configuration file: none\n\n")
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "OPTION\tVALUE\tSOURCE")
+		for _, s := range settings {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, redact(s.Name, s.Value), s.Source)
+		}
+		return w.Flush()
+	},
+}
+
+// redact hides the secrets of the options holding some
+func redact(name, value string) string {
+	switch name {
+	case "auth", "user":
+		if username, _, ok := strings.Cut(value, ":"); ok {
+			return username + ":***"
+		}
+	case "e2e-key":
+		if value != "" {
+			return "***"
+		}
+	}
+	return value
+}
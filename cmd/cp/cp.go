@@ -0,0 +1,248 @@
+package cp
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/easzlab/ezft/internal/config"
+	"github.com/easzlab/ezft/internal/exitcode"
+	"github.com/easzlab/ezft/internal/keyring"
+	"github.com/easzlab/ezft/internal/prompt"
+	"github.com/easzlab/ezft/pkg/client"
+	"github.com/easzlab/ezft/pkg/metrics"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/easzlab/ezft/pkg/utils/logger"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// cp subcommand related variables
+var (
+	cpConcurrency   int
+	cpUser          string
+	cpNoInteractive bool
+	cpNoProxyEnv    bool
+	cpBwLimit       string
+	cpQuiet         bool
+	cpConfig        string
+	cpLogHome       string
+	cpLogLevel      string
+	cpLogFormat     string
+	cpLogOutput     string
+	cpMetricsPush   string
+)
+
+func init() {
+	// cp subcommand parameters
+	CpCmd.Flags().IntVarP(&cpConcurrency, "concurrency", "c", 4, "Concurrency count of downloads")
+	CpCmd.Flags().StringVar(&cpUser, "user", "", "Basic auth credentials username:password, by default those of the profile")
+	CpCmd.Flags().BoolVar(&cpNoInteractive, "no-interactive", false, "Fail instead of asking for credentials on the terminal when the server requires them")
+	CpCmd.Flags().BoolVar(&cpNoProxyEnv, "no-proxy-env", false, "Ignore the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables and connect to servers directly")
+	CpCmd.Flags().StringVar(&cpBwLimit, "bwlimit", "", "Bandwidth limit, a rate such as 1M or a schedule such as \"09:00,1M 18:00,off\"")
+	// --limit-rate as in curl and wget
+	CpCmd.Flags().SetNormalizeFunc(config.NormalizeAliases)
+	CpCmd.Flags().BoolVarP(&cpQuiet, "quiet", "q", false, "Print nothing but errors, without progress")
+	CpCmd.Flags().StringVar(&cpMetricsPush, "metrics-push", "", "Push the bytes, duration and outcome of the copy on completion to StatsD, such as statsd://127.0.0.1:8125, or a Prometheus Pushgateway, such as http://pushgateway:9091")
+	CpCmd.Flags().StringVar(&cpConfig, "config", "", "YAML or TOML file whose profiles remote arguments may name, by default the first ezft.yaml or ezft.toml found in the working directory, the user configuration directory and /etc/ezft")
+	CpCmd.Flags().StringVarP(&cpLogHome, "log-home", "", "./logs", "Log file home")
+	CpCmd.Flags().StringVarP(&cpLogLevel, "log-level", "", "info", "Log level")
+	CpCmd.Flags().StringVar(&cpLogFormat, "log-format", logger.FormatConsole, "Log format, console or json for log ingestion pipelines")
+	CpCmd.Flags().StringVar(&cpLogOutput, "log-output", logger.OutputFile, "Where the log goes: file under --log-home, stdout or stderr, or several separated by commas such as file,stderr")
+}
+
+var CpCmd = &cobra.Command{
+	Use:   "cp <source> <destination>",
+	Short: "EZFT Copy - Copy a file from or to an ezft server",
+	Long: `EZFT cp copies a file between the local machine and an ezft server, with the
+syntax of scp: one argument is local and the other remote, which picks a
+download or an upload. A remote argument is name:path or a URL. The name is a
+profile of the configuration file, path being relative to its base-url, or
+else the host of an ezft server on port 8080. Uploads require a server started
+with --enable-upload.
+
+  ezft cp files.example.com:/releases/os.img ./
+  ezft cp mirror:os.img /data/os.img
+  ezft cp ./os.img files.example.com:/incoming/`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		src, dst := args[0], args[1]
+		srcRemote, dstRemote := config.IsRemote(src), config.IsRemote(dst)
+		if srcRemote == dstRemote {
+			return exitcode.UsageError(errors.New("one of the source and destination must be remote, as host:path or a URL, and the other local"))
+		}
+		file, err := config.FindFile(cpConfig)
+		if err != nil {
+			return exitcode.UsageError(err)
+		}
+		remoteArg := src
+		if dstRemote {
+			remoteArg = dst
+		}
+		remote, err := file.ResolveRemote(remoteArg)
+		if err != nil {
+			return exitcode.UsageError(err)
+		}
+
+		var username, password string
+		if user := cmp.Or(cpUser, remote.User); user != "" {
+			if username, password, err = utils.ParseCredentials(user); err != nil {
+				return exitcode.UsageError(err)
+			}
+		}
+		var limiter *ratelimit.Limiter
+		if cpBwLimit != "" {
+			schedule, err := ratelimit.ParseSchedule(cpBwLimit)
+			if err != nil {
+				return exitcode.UsageError(err)
+			}
+			limiter = ratelimit.NewLimiter(schedule)
+		}
+		var pusher *metrics.Pusher
+		if cpMetricsPush != "" {
+			if pusher, err = metrics.NewPusher(cpMetricsPush); err != nil {
+				return exitcode.UsageError(err)
+			}
+		}
+
+		if logger.WritesFile(cpLogOutput) {
+			if err := utils.EnsureDir(cpLogHome); err != nil {
+				return fmt.Errorf("failed to create log directory: %w", err)
+			}
+		}
+		l, err := logger.New(logger.Config{File: cpLogHome + "/cp.log", Output: cpLogOutput, Level: cpLogLevel, Format: cpLogFormat})
+		if err != nil {
+			return fmt.Errorf("failed to create logger: %w", err)
+		}
+		out := io.Writer(os.Stdout)
+		if cpQuiet {
+			out = io.Discard
+		}
+
+		// Credentials the server requires are those stored by ezft login, else
+		// asked on the terminal unless given
+		var prompter *prompt.Prompter
+		if username == "" && password == "" && !cpNoInteractive && prompt.Interactive() {
+			prompter = prompt.NewPrompter()
+			out = prompter.Writer(out)
+		}
+		credentials := keyring.CredentialsFunc(prompter)
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+		start := time.Now()
+		var size int64
+		var from, to string
+		if srcRemote {
+			if strings.HasSuffix(remote.URL, "/") {
+				return exitcode.UsageError(fmt.Errorf("%s is a directory, copy directories with ezft sync", src))
+			}
+			from, to = remote.URL, localTarget(dst, remote.URL)
+			c := client.NewClient(&client.DownloadConfig{
+				URL:            from,
+				OutputPath:     to,
+				ChunkSize:      1024 * 1024,
+				MaxConcurrency: cpConcurrency,
+				RetryCount:     3,
+				EnableResume:   true,
+				AutoChunk:      true,
+				NoProxyEnv:     cpNoProxyEnv,
+				Username:       username,
+				Password:       password,
+			})
+			c.SetLogger(l)
+			c.SetOutput(out)
+			if limiter != nil {
+				c.SetRateLimiter(limiter)
+			}
+			c.SetCredentialsFunc(credentials)
+			if !cpQuiet {
+				go c.ShowProgressLoop(ctx)
+			}
+			err := c.Download(ctx)
+			size, _ = utils.GetFileSize(to)
+			pushMetrics(pusher, l, &metrics.Transfer{Type: "download", Bytes: size, Duration: time.Since(start), Retries: c.Retries(), Err: err})
+			if err != nil {
+				return fmt.Errorf("download failed: %w", err)
+			}
+		} else {
+			from, to = src, remote.URL
+			if info, err := os.Stat(src); err == nil && info.IsDir() {
+				return exitcode.UsageError(fmt.Errorf("%s is a directory, copy directories with ezft sync", src))
+			}
+			// Into the remote directory under the name of the file
+			if strings.HasSuffix(to, "/") {
+				to += url.PathEscape(filepath.Base(src))
+			}
+			u := client.NewUploader(&client.UploadConfig{
+				URL:        to,
+				InputPath:  from,
+				NoProxyEnv: cpNoProxyEnv,
+				Username:   username,
+				Password:   password,
+			})
+			u.SetLogger(l)
+			if limiter != nil {
+				u.SetRateLimiter(limiter)
+			}
+			u.SetCredentialsFunc(credentials)
+			fmt.Fprintf(out, "Uploading %s to %s\n", from, to)
+			size, err = u.Upload(ctx)
+			pushMetrics(pusher, l, &metrics.Transfer{Type: "upload", Bytes: size, Duration: time.Since(start), Err: err})
+			if err != nil {
+				return fmt.Errorf("upload failed: %w", err)
+			}
+		}
+
+		duration := time.Since(start)
+		if srcRemote {
+			// After the progress bar
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "✓ Copied %s to %s: %s in %s, %s\n",
+			from, to,
+			utils.FormatBytes(size),
+			utils.FormatDuration(duration),
+			utils.CalculateSpeed(size, duration),
+		)
+		l.Info("Copy completed",
+			zap.String("from", from),
+			zap.String("to", to),
+			zap.Int64("size", size),
+			zap.Duration("duration", duration),
+		)
+		return nil
+	},
+}
+
+// pushMetrics pushes the metrics of the copy unless pusher is nil, a failed
+// push only being logged
+func pushMetrics(pusher *metrics.Pusher, l *zap.Logger, t *metrics.Transfer) {
+	if pusher == nil {
+		return
+	}
+	if err := pusher.Push(context.Background(), t); err != nil {
+		l.Warn("failed to push metrics", zap.Error(err))
+	}
+}
+
+// localTarget returns the path a download of fileURL to dst writes, into dst
+// under the name of the remote file when dst is a directory
+func localTarget(dst, fileURL string) string {
+	info, err := os.Stat(dst)
+	isDir := err == nil && info.IsDir()
+	if !isDir && !strings.HasSuffix(dst, "/") && !strings.HasSuffix(dst, string(filepath.Separator)) {
+		return dst
+	}
+	return filepath.Join(dst, utils.URLFilename(fileURL))
+}
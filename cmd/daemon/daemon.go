@@ -0,0 +1,236 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/easzlab/ezft/internal/config"
+	"github.com/easzlab/ezft/pkg/daemon"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/upgrade"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/easzlab/ezft/pkg/utils/logger"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// daemon subcommand related variables
+var (
+	daemonListen    string
+	daemonGRPC      string
+	daemonMaxJobs   int
+	daemonPolicy    string
+	daemonBwLimit   string
+	daemonState     string
+	daemonConfig    string
+	daemonHistory   int
+	daemonHistAge   time.Duration
+	daemonPidFile   string
+	daemonLogHome   string
+	daemonLogLevel  string
+	daemonLogFormat string
+	daemonLogOutput string
+	daemonJobLogs   string
+)
+
+func init() {
+	// daemon subcommand parameters
+	DaemonCmd.Flags().StringVarP(&daemonListen, "listen", "l", daemon.DefaultListen, "Address of the REST job API")
+	DaemonCmd.Flags().StringVar(&daemonGRPC, "grpc-listen", "", "Address of the gRPC job API, disabled if empty")
+	DaemonCmd.Flags().IntVar(&daemonMaxJobs, "max-jobs", daemon.DefaultMaxJobs, "Number of jobs running at the same time")
+	DaemonCmd.Flags().StringVar(&daemonPolicy, "policy", string(daemon.PolicyFIFO), "Scheduling policy of queued jobs: fifo, fair-share")
+	DaemonCmd.Flags().StringVar(&daemonBwLimit, "bwlimit", "", "Bandwidth limit of all jobs together, split by job weight, a rate such as 1M or a schedule such as \"09:00,1M 18:00,off\"")
+	// --limit-rate as in curl and wget
+	DaemonCmd.Flags().SetNormalizeFunc(config.NormalizeAliases)
+	DaemonCmd.Flags().StringVar(&daemonState, "state-file", "./ezft-jobs.db", "Database keeping all jobs and the chunks left of their downloads across restarts, empty to keep jobs in memory only")
+	DaemonCmd.Flags().IntVar(&daemonHistory, "history", daemon.DefaultHistorySize, "Finished jobs kept in the history, 0 for all")
+	DaemonCmd.Flags().DurationVar(&daemonHistAge, "history-age", daemon.DefaultHistoryAge, "How long finished jobs are kept in the history, 0 for ever")
+	DaemonCmd.Flags().StringVar(&daemonConfig, "config", "", "YAML configuration file defining job profiles, notifiers, API tokens and bandwidth classes")
+	DaemonCmd.Flags().StringVar(&daemonPidFile, "pid-file", "", "File the process ID is written to, the upgraded daemon updates it")
+	DaemonCmd.Flags().StringVarP(&daemonLogHome, "log-home", "", "./logs", "Log file home")
+	DaemonCmd.Flags().StringVarP(&daemonLogLevel, "log-level", "", "debug", "Log level")
+	DaemonCmd.Flags().StringVar(&daemonLogFormat, "log-format", logger.FormatConsole, "Log format, console or json for log ingestion pipelines")
+	DaemonCmd.Flags().StringVar(&daemonLogOutput, "log-output", logger.OutputFile, "Where the log goes: file under --log-home, stdout or stderr, or several separated by commas such as file,stderr")
+	DaemonCmd.Flags().StringVar(&daemonJobLogs, "job-logs", "", "Also log every job to its own file <job ID>.log in this directory, to look into a single failed job")
+}
+
+var DaemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "EZFT Daemon - Run transfer jobs submitted through a REST API",
+	Long: `EZFT daemon keeps running and executes download, upload and sync jobs submitted through its REST API
+and optionally its gRPC API,
+letting other tools drive transfers without starting ezft for each of them.
+The API listens on ` + daemon.DefaultListen + ` unless --listen is given, it is open unless API tokens are configured.
+On SIGUSR2 the daemon starts its executable again and hands its listeners and jobs over to it,
+so an upgraded binary takes over without dropping API connections, transfers resume where they stopped.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if logger.WritesFile(daemonLogOutput) {
+			if err := utils.EnsureDir(daemonLogHome); err != nil {
+				return fmt.Errorf("failed to create log directory: %w", err)
+			}
+		}
+
+		// Create logger
+		l, err := logger.New(logger.Config{File: daemonLogHome + "/daemon.log", Output: daemonLogOutput, Level: daemonLogLevel, Format: daemonLogFormat})
+		if err != nil {
+			return fmt.Errorf("failed to create logger: %w", err)
+		}
+
+		policy, err := daemon.ParsePolicy(daemonPolicy)
+		if err != nil {
+			return err
+		}
+
+		manager := daemon.NewManager(daemonMaxJobs)
+		manager.SetLogger(l)
+		if daemonJobLogs != "" {
+			if err := utils.EnsureDir(daemonJobLogs); err != nil {
+				return fmt.Errorf("failed to create job log directory: %w", err)
+			}
+			manager.SetJobLogs(daemonJobLogs, daemonLogFormat)
+		}
+		manager.SetPolicy(policy)
+		manager.SetRetention(daemonHistory, daemonHistAge)
+		if daemonBwLimit != "" {
+			schedule, err := ratelimit.ParseSchedule(daemonBwLimit)
+			if err != nil {
+				return err
+			}
+			manager.SetRateLimiter(ratelimit.NewLimiter(schedule))
+		}
+		if daemonConfig != "" {
+			config, err := daemon.LoadConfig(daemonConfig)
+			if err != nil {
+				return err
+			}
+			manager.SetProfiles(config.Profiles)
+			manager.SetNotifiers(config.Notifiers)
+			manager.SetAccess(config.Tokens, config.Classes)
+			l.Info("configuration loaded",
+				zap.Strings("profiles", config.Profiles.Names()),
+				zap.Strings("notifiers", config.NotifierNames()),
+				zap.Int("tokens", len(config.Tokens)),
+			)
+		}
+		// An upgraded daemon inherits the listeners of the previous one, and
+		// takes over its jobs once the previous one stopped them
+		listeners := map[string]net.Listener{}
+		apiLis, err := upgrade.Listen("api", daemonListen)
+		if err != nil {
+			return fmt.Errorf("failed to listen: %w", err)
+		}
+		listeners["api"] = apiLis
+		if daemonGRPC != "" {
+			lis, err := upgrade.Listen("grpc", daemonGRPC)
+			if err != nil {
+				return fmt.Errorf("failed to listen for gRPC: %w", err)
+			}
+			listeners["grpc"] = lis
+		}
+		if upgrade.Upgraded() {
+			l.Info("waiting for the previous daemon to hand over its jobs")
+		}
+		if err := upgrade.Ready(); err != nil {
+			return err
+		}
+		if daemonPidFile != "" {
+			if err := os.WriteFile(daemonPidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+				return fmt.Errorf("failed to write pid file: %w", err)
+			}
+		}
+
+		if daemonState != "" {
+			if err := manager.LoadState(daemonState); err != nil {
+				return err
+			}
+		}
+
+		var grpcSrv *grpc.Server
+		if lis := listeners["grpc"]; lis != nil {
+			grpcSrv = daemon.NewGRPCServer(manager)
+			go grpcSrv.Serve(lis)
+
+			fmt.Printf("Serving gRPC job API at %s\n", lis.Addr())
+			l.Info("Serving gRPC job API",
+				zap.String("addr", daemonGRPC),
+			)
+		}
+
+		srv := &http.Server{Addr: daemonListen, Handler: manager.Handler()}
+
+		// Set signal handling
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		upgradeChan := make(chan os.Signal, 1)
+		upgrade.Notify(upgradeChan)
+		handoff := make(chan *upgrade.Child, 1)
+
+		go func() {
+			for {
+				select {
+				case <-sigChan:
+					fmt.Println("\nReceived interrupt signal, stopping daemon...")
+				case <-upgradeChan:
+					fmt.Println("Received upgrade signal, starting the new daemon...")
+					child, err := upgrade.Start(listeners)
+					if err != nil {
+						fmt.Printf("Upgrade failed: %v\n", err)
+						l.Info("upgrade failed",
+							zap.Error(err),
+						)
+						continue
+					}
+					fmt.Printf("Handing over to the new daemon (pid %d)...\n", child.Pid)
+					l.Info("handing over to the new daemon",
+						zap.Int("pid", child.Pid),
+					)
+					handoff <- child
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				srv.Shutdown(ctx)
+				return
+			}
+		}()
+
+		fmt.Printf("Serving job API at http://%s%s\n", apiLis.Addr(), daemon.APIPrefix)
+		fmt.Printf("Dashboard at http://%s/\n", apiLis.Addr())
+		fmt.Printf("Prometheus metrics at http://%s/metrics\n", apiLis.Addr())
+		l.Info("Serving job API",
+			zap.String("addr", daemonListen),
+			zap.Int("max_jobs", daemonMaxJobs),
+			zap.String("policy", daemonPolicy),
+		)
+
+		err = srv.Serve(apiLis)
+		if grpcSrv != nil {
+			grpcSrv.Stop()
+		}
+		// Running jobs are queued again with their progress saved, so the
+		// new daemon resumes them
+		manager.Close()
+		select {
+		case child := <-handoff:
+			if err := child.Handoff(); err != nil {
+				return fmt.Errorf("failed to hand over: %w", err)
+			}
+		default:
+			if daemonPidFile != "" {
+				os.Remove(daemonPidFile)
+			}
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("daemon failed: %w", err)
+		}
+		return nil
+	},
+}
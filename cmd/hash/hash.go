@@ -0,0 +1,195 @@
+package hash
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/easzlab/ezft/internal/exitcode"
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// hash subcommand related variables
+var (
+	hashAlgo   string
+	hashOutput string
+	hashCheck  bool
+)
+
+func init() {
+	// hash subcommand parameters
+	HashCmd.Flags().StringVarP(&hashAlgo, "algo", "a", "sha256", "Hash algorithm: md5, sha1, sha256 or sha512, guessed from the hashes with --check")
+	HashCmd.Flags().StringVarP(&hashOutput, "output", "o", "", "Write the checksums to this file instead of standard output, with paths relative to its directory")
+	HashCmd.Flags().BoolVarP(&hashCheck, "check", "c", false, "Verify the files listed in the checksum files given against their hashes")
+
+	HashCmd.RegisterFlagCompletionFunc("algo", cobra.FixedCompletions([]string{"md5", "sha1", "sha256", "sha512"}, cobra.ShellCompDirectiveNoFileComp))
+}
+
+var HashCmd = &cobra.Command{
+	Use:   "hash <file|dir>...",
+	Short: "EZFT Hash - Generate or verify checksum files",
+	Long: `EZFT hash writes the checksums of files, and of all files of directories, in
+the format of sha256sum and the like: their --check, as well as ezft hash
+--check, verify the checksum file. Files of directories are listed relative to
+them, skipping the resume and state files of ezft. The hashes are those the
+server returns for its files and the download hooks receive.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := utils.NewHash(hashAlgo); err != nil {
+			return exitcode.UsageError(err)
+		}
+		if hashCheck {
+			if hashOutput != "" {
+				return exitcode.UsageError(errors.New("--output cannot be combined with --check"))
+			}
+			return check(args, cmd.Flags().Changed("algo"))
+		}
+
+		// Paths are relative to the checksum file, or to the directory hashed
+		// alone, otherwise as given
+		var baseDir string
+		if hashOutput != "" {
+			baseDir = filepath.Dir(hashOutput)
+		} else if info, err := os.Stat(args[0]); err == nil && info.IsDir() && len(args) == 1 {
+			baseDir = args[0]
+		}
+		var sums []manifest.Sum
+		for _, arg := range args {
+			files, err := listFiles(arg)
+			if err != nil {
+				return err
+			}
+			for _, name := range files {
+				p := filepath.Join(arg, name)
+				// The checksum file being written is left out of its directory
+				if hashOutput != "" && sameFile(p, hashOutput) {
+					continue
+				}
+				sum, err := utils.CalculateFileHash(p, hashAlgo)
+				if err != nil {
+					return err
+				}
+				if baseDir != "" {
+					p = relativeTo(baseDir, p)
+				}
+				sums = append(sums, manifest.Sum{Hash: sum, Path: filepath.ToSlash(p)})
+			}
+		}
+
+		if hashOutput == "" {
+			return manifest.WriteSums(os.Stdout, sums)
+		}
+		out, err := os.Create(hashOutput)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if err := manifest.WriteSums(out, sums); err != nil {
+			return err
+		}
+		return out.Close()
+	},
+}
+
+// listFiles returns the regular files of the directory path relative to it,
+// or "." if path is a file
+func listFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().IsRegular() {
+		return []string{"."}, nil
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a regular file or a directory", path)
+	}
+	m, err := manifest.Scan(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range m.Entries {
+		if !e.IsLink() {
+			files = append(files, filepath.FromSlash(e.Path))
+		}
+	}
+	return files, nil
+}
+
+// relativeTo returns the path p relative to dir, or absolute when it cannot
+// be, such as on another volume
+func relativeTo(dir, p string) string {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return p
+	}
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if rel, err := filepath.Rel(absDir, absPath); err == nil {
+		return rel
+	}
+	return absPath
+}
+
+// sameFile reports whether the paths a and b name the same existing file
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	return err == nil && os.SameFile(infoA, infoB)
+}
+
+// check verifies the files listed in the checksum files, resolving their
+// paths against the directory of the checksum file. The algorithm is guessed
+// from the length of the hashes unless given.
+func check(checksumFiles []string, algoGiven bool) error {
+	var failed, total int
+	for _, checksumFile := range checksumFiles {
+		f, err := os.Open(checksumFile)
+		if err != nil {
+			return err
+		}
+		sums, err := manifest.ReadSums(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", checksumFile, err)
+		}
+
+		for _, s := range sums {
+			total++
+			algo := hashAlgo
+			if !algoGiven {
+				algo = manifest.SumAlgo(s.Hash)
+			}
+			p := filepath.FromSlash(s.Path)
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(filepath.Dir(checksumFile), p)
+			}
+			status := "OK"
+			switch sum, err := utils.CalculateFileHash(p, algo); {
+			case algo == "":
+				status = "FAILED unknown hash algorithm"
+			case err != nil:
+				status = "FAILED open or read: " + err.Error()
+			case sum != s.Hash:
+				status = "FAILED"
+			}
+			if status != "OK" {
+				failed++
+			}
+			fmt.Printf("%s: %s\n", s.Path, status)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d files", manifest.ErrSumMismatch, failed, total)
+	}
+	return nil
+}
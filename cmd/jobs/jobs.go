@@ -0,0 +1,333 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/easzlab/ezft/internal/keyring"
+	"github.com/easzlab/ezft/pkg/daemon"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// jobs subcommand related variables
+var (
+	jobsDaemon string
+	jobsToken  string
+	jobsState  string
+
+	submitProfile  string
+	submitType     string
+	submitURL      string
+	submitPath     string
+	submitPriority int
+	submitGroup    string
+	submitWeight   int
+	submitClass    string
+	submitCron     string
+	submitRetries  int
+	submitNotify   []string
+)
+
+func init() {
+	// jobs subcommand parameters
+	JobsCmd.PersistentFlags().StringVar(&jobsDaemon, "daemon", daemon.DefaultListen, "Address of the daemon REST API")
+	JobsCmd.PersistentFlags().StringVar(&jobsToken, "token", os.Getenv("EZFT_TOKEN"), "API token of the daemon, $EZFT_TOKEN by default")
+	listCmd.Flags().StringVar(&jobsState, "state", "", "Only list jobs in this state: queued, running, scheduled, retrying, succeeded, failed, canceled")
+
+	submitCmd.Flags().StringVarP(&submitProfile, "profile", "p", "", "Profile of the daemon configuration providing the options not given")
+	submitCmd.Flags().StringVarP(&submitType, "type", "t", "", "Job type: download, upload, sync")
+	submitCmd.Flags().StringVarP(&submitURL, "url", "u", "", "Remote file or directory URL")
+	submitCmd.Flags().StringVarP(&submitPath, "path", "o", "", "Local file or directory path, relative to the profile path if any")
+	submitCmd.Flags().IntVar(&submitPriority, "priority", 0, "Jobs with a higher priority start first")
+	submitCmd.Flags().StringVar(&submitGroup, "group", "", "Group sharing running slots under fair-share scheduling")
+	submitCmd.Flags().IntVar(&submitWeight, "weight", 0, "Part of the daemon bandwidth limit relative to other running jobs")
+	submitCmd.Flags().StringVar(&submitClass, "class", "", "Bandwidth class of the daemon configuration setting the weight and limit")
+	submitCmd.Flags().StringVar(&submitCron, "cron", "", "Cron expression running the job repeatedly")
+	submitCmd.Flags().IntVar(&submitRetries, "retries", 0, "Automatic retries of the job when it failed")
+	submitCmd.Flags().StringSliceVar(&submitNotify, "notify", nil, "Notifiers of the daemon configuration told when the job finished (can be repeated)")
+
+	JobsCmd.AddCommand(submitCmd, profilesCmd, listCmd, statusCmd, cancelCmd, retryCmd, tailCmd, statsCmd)
+}
+
+var JobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "EZFT Jobs - Manage the jobs of a running daemon",
+	Long:  "EZFT jobs submits, lists, inspects, cancels, retries and follows the jobs of a running ezft daemon through its REST API.",
+}
+
+var submitCmd = &cobra.Command{
+	Use:   "submit",
+	Short: "Submit a job, optionally based on a profile",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := submitPath
+		if path != "" && submitProfile == "" {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			path = abs
+		}
+		job, err := newClient().Submit(cmd.Context(), daemon.JobSpec{
+			Profile:  submitProfile,
+			Type:     daemon.JobType(submitType),
+			URL:      submitURL,
+			Path:     path,
+			Priority: submitPriority,
+			Group:    submitGroup,
+			Weight:   submitWeight,
+			Class:    submitClass,
+			Cron:     submitCron,
+			Retries:  submitRetries,
+			Notify:   submitNotify,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ Job %s %s\n", job.ID, job.State)
+		return nil
+	},
+}
+
+var profilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "List the job profiles of the daemon",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profiles, err := newClient().Profiles(cmd.Context())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-20s  %-8s  %-30s  %s\n", "PROFILE", "TYPE", "PATH", "URL")
+		for _, name := range profiles.Names() {
+			spec := profiles[name]
+			fmt.Printf("%-20s  %-8s  %-30s  %s\n", name, spec.Type, spec.Path, spec.URL)
+		}
+		return nil
+	},
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List jobs in submission order",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jobs, err := newClient().List(cmd.Context(), daemon.State(jobsState))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-16s  %-9s  %-8s  %10s  %s\n", "ID", "STATE", "TYPE", "BYTES", "URL")
+		for _, job := range jobs {
+			fmt.Printf("%-16s  %-9s  %-8s  %10s  %s\n", job.ID, job.State, job.Spec.Type, utils.FormatBytes(job.Bytes), job.Spec.URL)
+		}
+		return nil
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status <id>",
+	Short: "Show the details of a job",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		job, err := newClient().Get(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		printJob(job)
+		return nil
+	},
+}
+
+var cancelCmd = &cobra.Command{
+	Use:   "cancel <id>",
+	Short: "Cancel a queued, running, retrying or recurring job",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		job, err := newClient().Cancel(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ Job %s canceled\n", job.ID)
+		return nil
+	},
+}
+
+var retryCmd = &cobra.Command{
+	Use:   "retry <id>",
+	Short: "Queue a finished job again, or schedule a canceled recurring job again",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		job, err := newClient().Retry(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ Job %s queued again\n", job.ID)
+		return nil
+	},
+}
+
+var tailCmd = &cobra.Command{
+	Use:   "tail <id>",
+	Short: "Follow the progress of a job until it finished",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+
+		var last daemon.Job
+		var lastTime time.Time
+		err := newClient().Watch(ctx, args[0], func(job daemon.Job) error {
+			now := time.Now()
+			speed := "-"
+			if job.State == daemon.StateRunning && !lastTime.IsZero() && job.Bytes >= last.Bytes {
+				speed = utils.CalculateSpeed(job.Bytes-last.Bytes, now.Sub(lastTime))
+			}
+			last, lastTime = job, now
+
+			if job.Total > 0 {
+				bar := utils.NewProgressBar(job.Total, 40)
+				bar.Update(job.Bytes)
+				fmt.Printf("\r\033[K%-9s %s", job.State, bar.String())
+			} else {
+				fmt.Printf("\r\033[K%-9s %s %s", job.State, utils.FormatBytes(job.Bytes), speed)
+			}
+			return nil
+		})
+		fmt.Println()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch last.State {
+		case daemon.StateSucceeded:
+			fmt.Printf("✓ Job %s succeeded in %s\n", last.ID, utils.FormatDuration(last.Finished.Sub(last.Started)))
+		case daemon.StateFailed:
+			return fmt.Errorf("job %s failed: %s", last.ID, last.Error)
+		default:
+			fmt.Printf("Job %s %s\n", last.ID, last.State)
+		}
+		return nil
+	},
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show statistics of the finished jobs in the history",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stats, err := newClient().Stats(cmd.Context())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Jobs:         %d (succeeded %d, failed %d, canceled %d)\n", stats.Jobs, stats.Succeeded, stats.Failed, stats.Canceled)
+		fmt.Printf("Success rate: %.1f%%\n", stats.SuccessRate*100)
+		fmt.Printf("Transferred:  %s\n", utils.FormatBytes(stats.Bytes))
+		fmt.Printf("Mean speed:   %s/s\n", utils.FormatBytes(int64(stats.MeanSpeed)))
+		if len(stats.Days) > 0 {
+			fmt.Printf("\n%-10s  %6s  %9s  %6s  %10s\n", "DATE", "JOBS", "SUCCEEDED", "FAILED", "BYTES")
+			for _, day := range stats.Days {
+				fmt.Printf("%-10s  %6d  %9d  %6d  %10s\n", day.Date, day.Jobs, day.Succeeded, day.Failed, utils.FormatBytes(day.Bytes))
+			}
+		}
+		return nil
+	},
+}
+
+// newClient returns a client of the daemon given by the flags
+func newClient() *daemon.Client {
+	c := daemon.NewClient(jobsDaemon)
+	token := jobsToken
+	if token == "" {
+		// Stored by ezft login
+		token = keyring.Token(jobsDaemon)
+	}
+	c.SetToken(token)
+	return c
+}
+
+// overlapName returns the overlap policy, skip if unset
+func overlapName(overlap daemon.Overlap) daemon.Overlap {
+	if overlap == "" {
+		return daemon.OverlapSkip
+	}
+	return overlap
+}
+
+// printJob prints the details of a job
+func printJob(job daemon.Job) {
+	fmt.Printf("ID:       %s\n", job.ID)
+	fmt.Printf("Type:     %s\n", job.Spec.Type)
+	if job.Spec.Profile != "" {
+		fmt.Printf("Profile:  %s\n", job.Spec.Profile)
+	}
+	fmt.Printf("State:    %s\n", job.State)
+	fmt.Printf("URL:      %s\n", job.Spec.URL)
+	fmt.Printf("Path:     %s\n", job.Spec.Path)
+	if job.Spec.Cron != "" {
+		fmt.Printf("Cron:     %s (overlap: %s)\n", job.Spec.Cron, overlapName(job.Spec.Overlap))
+	}
+	if !job.Next.IsZero() {
+		fmt.Printf("Next:     %s\n", job.Next.Format(time.RFC3339))
+	}
+	if job.Schedule != "" {
+		fmt.Printf("Schedule: %s\n", job.Schedule)
+	}
+	if job.Spec.Priority != 0 || job.Spec.Group != "" {
+		fmt.Printf("Priority: %d\n", job.Spec.Priority)
+		fmt.Printf("Group:    %s\n", job.Spec.Group)
+	}
+	if len(job.Spec.Notify) > 0 {
+		fmt.Printf("Notify:   %s\n", strings.Join(job.Spec.Notify, ", "))
+	}
+	if job.Spec.Weight != 0 || job.Spec.Class != "" {
+		fmt.Printf("Weight:   %d\n", job.Spec.Weight)
+		fmt.Printf("Class:    %s\n", job.Spec.Class)
+	}
+	if job.Owner != "" {
+		fmt.Printf("Owner:    %s\n", job.Owner)
+	}
+	fmt.Printf("Created:  %s\n", job.Created.Format(time.RFC3339))
+	if !job.Started.IsZero() {
+		fmt.Printf("Started:  %s\n", job.Started.Format(time.RFC3339))
+	}
+	if !job.Finished.IsZero() {
+		fmt.Printf("Finished: %s\n", job.Finished.Format(time.RFC3339))
+	}
+	if job.Total > 0 {
+		fmt.Printf("Bytes:    %s of %s\n", utils.FormatBytes(job.Bytes), utils.FormatBytes(job.Total))
+	} else {
+		fmt.Printf("Bytes:    %s\n", utils.FormatBytes(job.Bytes))
+	}
+	if r := job.Report; r != nil {
+		fmt.Printf("Sync:     downloaded %d, uploaded %d, copied %d, deleted %d, unchanged %d\n",
+			r.Downloaded, r.Uploaded, r.Copied, r.Deleted, r.Skipped)
+	}
+	if job.Spec.Retries > 0 {
+		fmt.Printf("Retries:  %d of %d\n", job.Retries, job.Spec.Retries)
+	}
+	if !job.RetryAt.IsZero() {
+		fmt.Printf("Retry at: %s\n", job.RetryAt.Format(time.RFC3339))
+	}
+	if job.Error != "" {
+		fmt.Printf("Error:    %s\n", job.Error)
+	}
+	if job.HookError != "" {
+		fmt.Printf("Hooks:    %s\n", job.HookError)
+	}
+	if len(job.Attempts) > 0 {
+		fmt.Println("Attempts:")
+		for i, attempt := range job.Attempts {
+			fmt.Printf("  %d. %s  %s\n", i+1, attempt.Finished.Format(time.RFC3339), attempt.Error)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/easzlab/ezft/internal/exitcode"
+	"github.com/easzlab/ezft/internal/keyring"
+	"github.com/easzlab/ezft/internal/prompt"
+	"github.com/spf13/cobra"
+)
+
+// login subcommand related variables
+var (
+	loginUsername      string
+	loginPasswordStdin bool
+	loginToken         bool
+	loginRemove        bool
+	loginKeyring       string
+)
+
+func init() {
+	// login subcommand parameters
+	LoginCmd.Flags().StringVarP(&loginUsername, "username", "u", "", "Username, asked on the terminal if not given")
+	LoginCmd.Flags().BoolVar(&loginPasswordStdin, "password-stdin", false, "Read the password or token from the standard input instead of the terminal")
+	LoginCmd.Flags().BoolVar(&loginToken, "token", false, "Store the API token of a daemon instead of a username and password")
+	LoginCmd.Flags().BoolVar(&loginRemove, "remove", false, "Remove the credentials stored for the host")
+	LoginCmd.Flags().StringVar(&loginKeyring, "keyring", "", "Where to store the credentials: auto, system or file, $EZFT_KEYRING by default")
+
+	LoginCmd.MarkFlagsMutuallyExclusive("username", "token")
+	LoginCmd.MarkFlagsMutuallyExclusive("remove", "username")
+	LoginCmd.MarkFlagsMutuallyExclusive("remove", "token")
+	LoginCmd.MarkFlagsMutuallyExclusive("remove", "password-stdin")
+}
+
+var LoginCmd = &cobra.Command{
+	Use:   "login <host>",
+	Short: "EZFT Login - Store the credentials of a server",
+	Long: `EZFT login stores the credentials of a server in the keyring of the system:
+the macOS keychain, the Secret Service of the desktop session on Linux or the
+Windows Credential Manager. Without one they are stored in a file of the user
+configuration directory, encrypted with a passphrase. The client, cp, sync and
+verify commands use the credentials stored for the host of the URL when the
+server requires them, jobs uses the token stored for the daemon address.
+
+  ezft login files.example.com:8080
+  ezft login files.example.com:8080 -u alice --password-stdin < password.txt
+  ezft login 127.0.0.1:7070 --token
+  ezft login files.example.com:8080 --remove`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		host := keyring.Host(args[0])
+		if host == "" {
+			return exitcode.UsageError(fmt.Errorf("invalid host %q", args[0]))
+		}
+		var prompter *prompt.Prompter
+		if prompt.Interactive() {
+			prompter = prompt.NewPrompter()
+		}
+		store, err := keyring.Open(loginKeyring, keyring.PromptPassphrase(prompter))
+		if err != nil {
+			return err
+		}
+
+		if loginRemove {
+			if err := store.Delete(host); errors.Is(err, keyring.ErrNotFound) {
+				return fmt.Errorf("no credentials stored for %s in %s", host, store.Name())
+			} else if err != nil {
+				return err
+			}
+			fmt.Printf("Removed the credentials of %s from %s\n", host, store.Name())
+			return nil
+		}
+
+		c := &keyring.Credential{Username: loginUsername}
+		label := "Password: "
+		if loginToken {
+			label = "Token: "
+		} else if c.Username == "" {
+			if prompter == nil {
+				return exitcode.UsageError(errors.New("--username is required when the standard input is not a terminal"))
+			}
+			if c.Username, err = prompter.Line("Username: "); err != nil {
+				return err
+			}
+		}
+		var secret string
+		switch {
+		case loginPasswordStdin:
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			secret = strings.TrimRight(string(data), "\r\n")
+		case prompter != nil:
+			if secret, err = prompter.Secret(label); err != nil {
+				return err
+			}
+		default:
+			return exitcode.UsageError(errors.New("--password-stdin is required when the standard input is not a terminal"))
+		}
+		if secret == "" {
+			return exitcode.UsageError(fmt.Errorf("empty %s", strings.ToLower(strings.TrimSuffix(label, ": "))))
+		}
+		if loginToken {
+			c.Token = secret
+		} else {
+			c.Password = secret
+		}
+
+		if err := store.Set(host, c); err != nil {
+			return fmt.Errorf("failed to store credentials: %w", err)
+		}
+		fmt.Printf("Stored the credentials of %s in %s\n", host, store.Name())
+		return nil
+	},
+}
@@ -4,21 +4,64 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/easzlab/ezft/cmd/bench"
+	"github.com/easzlab/ezft/cmd/cast"
 	"github.com/easzlab/ezft/cmd/client"
+	"github.com/easzlab/ezft/cmd/completion"
+	configcmd "github.com/easzlab/ezft/cmd/config"
+	"github.com/easzlab/ezft/cmd/cp"
+	"github.com/easzlab/ezft/cmd/daemon"
+	"github.com/easzlab/ezft/cmd/hash"
+	"github.com/easzlab/ezft/cmd/jobs"
+	"github.com/easzlab/ezft/cmd/login"
+	"github.com/easzlab/ezft/cmd/manifest"
 	"github.com/easzlab/ezft/cmd/server"
+	"github.com/easzlab/ezft/cmd/share"
+	"github.com/easzlab/ezft/cmd/sync"
+	"github.com/easzlab/ezft/cmd/verify"
+	"github.com/easzlab/ezft/cmd/version"
 	"github.com/easzlab/ezft/internal/config"
+	"github.com/easzlab/ezft/internal/exitcode"
 	"github.com/spf13/cobra"
 )
 
 var showVersion bool
 
+// validated is set once the arguments and flags of the command were accepted
+var validated bool
+
 func init() {
 	// Add version flag to root command
 	rootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "Show version information")
 
+	// Errors before a command runs are usage errors
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return exitcode.UsageError(err)
+	})
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		validated = true
+	}
+
+	// Replaced by the completion subcommand
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+
 	// Add subcommands to root command
+	rootCmd.AddCommand(bench.BenchCmd)
+	rootCmd.AddCommand(cast.CastCmd)
 	rootCmd.AddCommand(client.ClientCmd)
+	rootCmd.AddCommand(completion.CompletionCmd)
+	rootCmd.AddCommand(configcmd.ConfigCmd)
+	rootCmd.AddCommand(cp.CpCmd)
+	rootCmd.AddCommand(daemon.DaemonCmd)
+	rootCmd.AddCommand(hash.HashCmd)
+	rootCmd.AddCommand(jobs.JobsCmd)
+	rootCmd.AddCommand(login.LoginCmd)
+	rootCmd.AddCommand(manifest.ManifestCmd)
 	rootCmd.AddCommand(server.ServerCmd)
+	rootCmd.AddCommand(share.ShareCmd)
+	rootCmd.AddCommand(sync.SyncCmd)
+	rootCmd.AddCommand(verify.VerifyCmd)
+	rootCmd.AddCommand(version.VersionCmd)
 }
 
 var rootCmd = &cobra.Command{
@@ -27,12 +70,7 @@ var rootCmd = &cobra.Command{
 	Long:  "EZFT (Easy File Transfer) is a high-performance file transfer tool that supports client download and server functionality.",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if showVersion {
-			fmt.Printf("Version: %s\nBuild commit: %s\nBuild branch: %s\nBuild time: %s\n",
-				config.FullVersion(),
-				config.BuildCommit,
-				config.BuildBranch,
-				config.BuildTime,
-			)
+			fmt.Print(config.GetBuildInfo())
 			return nil
 		}
 		return cmd.Help()
@@ -42,7 +80,10 @@ var rootCmd = &cobra.Command{
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		if !validated {
+			err = exitcode.UsageError(err)
+		}
+		os.Exit(exitcode.Code(err))
 	}
 }
 
@@ -0,0 +1,70 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// manifest subcommand related variables
+var (
+	manifestChunkSize = utils.ByteSize(manifest.DefaultChunkSize)
+	manifestOutput    string
+)
+
+func init() {
+	// manifest subcommand parameters
+	ManifestCmd.Flags().VarP(&manifestChunkSize, "chunk-size", "s", "Chunk size in bytes, with an optional binary unit such as 4M")
+	ManifestCmd.Flags().StringVarP(&manifestOutput, "output", "o", "", "Write the manifest to this file instead of standard output")
+}
+
+var ManifestCmd = &cobra.Command{
+	Use:   "manifest <file>",
+	Short: "EZFT Manifest - Generate the chunk manifest of a file",
+	Long: `EZFT manifest hashes every chunk of a file offline, writing the same chunk
+manifest an ezft server serves. Clients given the manifest with --chunk-manifest
+verify every chunk against it while downloading and when resuming.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", args[0])
+		}
+
+		chunks, err := manifest.HashChunks(file, info.Size(), int64(manifestChunkSize))
+		if err != nil {
+			return err
+		}
+		chunks.Name = filepath.Base(args[0])
+
+		out := os.Stdout
+		if manifestOutput != "" {
+			if out, err = os.Create(manifestOutput); err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(chunks); err != nil {
+			return err
+		}
+		if manifestOutput != "" {
+			return out.Close()
+		}
+		return nil
+	},
+}
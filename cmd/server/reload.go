@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/easzlab/ezft/internal/config"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/server"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// reloadOptions are the options applied again from the configuration file on
+// the reload signal, the others only take effect on restart
+var reloadOptions = []string{"auth", "bwlimit", "slow-request", "ftp-cert", "ftp-key"}
+
+// certificate holds the FTPS certificate, replaced on reload while sessions
+// keep the one of their handshake
+type certificate struct {
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// loadCertificate loads the key pair of the flags
+func loadCertificate() (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(serverFTPCert, serverFTPKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load FTP certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// watchReload reloads the configuration of srv on every reload signal until
+// the process exits. A configuration failing to load is logged and the
+// current one kept.
+func watchReload(fs *pflag.FlagSet, srv *server.Server, cert *certificate, l *zap.Logger) {
+	c := make(chan os.Signal, 1)
+	notifyReload(c)
+	go func() {
+		for range c {
+			if err := reload(fs, srv, cert); err != nil {
+				l.Error("failed to reload configuration, keeping the current one",
+					zap.Error(err),
+				)
+				continue
+			}
+			l.Info("Configuration reloaded",
+				zap.Bool("auth", serverAuth != ""),
+				zap.String("bwlimit", serverBwLimit),
+				zap.Duration("slow_request", serverSlowReq),
+			)
+		}
+	}()
+}
+
+// reload reads the configuration file again and applies its reloadOptions to
+// srv, all of them or none when one is invalid. Options given on the command
+// line or in the environment keep their value.
+func reload(fs *pflag.FlagSet, srv *server.Server, cert *certificate) error {
+	previous := map[string]string{}
+	for _, name := range reloadOptions {
+		previous[name] = fs.Lookup(name).Value.String()
+	}
+	restore := func() {
+		for name, value := range previous {
+			fs.Set(name, value)
+		}
+	}
+
+	file, err := config.FindFile(serverConfig)
+	if err == nil {
+		err = file.Reload(fs, "server", reloadOptions, serverGiven)
+	}
+	if err != nil {
+		restore()
+		return err
+	}
+
+	// Parse everything before applying anything
+	var limiter *ratelimit.Limiter
+	bwChanged := serverBwLimit != previous["bwlimit"]
+	if bwChanged && serverBwLimit != "" {
+		schedule, err := ratelimit.ParseSchedule(serverBwLimit)
+		if err != nil {
+			restore()
+			return err
+		}
+		limiter = ratelimit.NewLimiter(schedule)
+	}
+	if err := checkUploadAuth(); err != nil {
+		restore()
+		return err
+	}
+	var username, password string
+	if serverAuth != "" {
+		if username, password, err = utils.ParseCredentials(serverAuth); err != nil {
+			restore()
+			return err
+		}
+	}
+	var newCert *tls.Certificate
+	if cert != nil {
+		if newCert, err = loadCertificate(); err != nil {
+			restore()
+			return err
+		}
+	}
+
+	srv.SetAuth(username, password)
+	if bwChanged {
+		srv.SetRateLimiter(limiter)
+	}
+	srv.SetSlowRequestThreshold(serverSlowReq)
+	if newCert != nil {
+		cert.cert.Store(newCert)
+	}
+	return nil
+}
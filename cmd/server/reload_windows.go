@@ -0,0 +1,6 @@
+package server
+
+import "os"
+
+// notifyReload does nothing, Windows has no reload signal
+func notifyReload(c chan<- os.Signal) {}
@@ -1,20 +1,66 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/easzlab/ezft/internal/config"
+	"github.com/easzlab/ezft/internal/exitcode"
+	"github.com/easzlab/ezft/pkg/e2e"
+	"github.com/easzlab/ezft/pkg/ftp"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/rsyncd"
 	"github.com/easzlab/ezft/pkg/server"
+	"github.com/easzlab/ezft/pkg/sockopt"
 	"github.com/easzlab/ezft/pkg/utils"
 	"github.com/easzlab/ezft/pkg/utils/logger"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 // server subcommand related variables
 var (
-	serverRootDir  string
-	serverPort     int
-	serverLogHome  string
-	serverLogLevel string
+	serverRootDir   string
+	serverPort      int
+	serverLogHome   string
+	serverLogLevel  string
+	serverLogFormat string
+	serverLogOutput string
+	serverUpload    bool
+	serverInsecure  bool
+	serverAuth      string
+	serverBwLimit   string
+	serverQUIC      bool
+	serverCompress  bool
+	serverTracker   bool
+	serverE2EKey    string
+	serverConfig    string
+	serverSlowReq   time.Duration
+	serverPprof     bool
+	serverAdminAddr string
+	serverAccessLog string
+	serverFileCache int
+
+	serverTCPNoDelay    bool
+	serverTCPRcvBuf     utils.ByteSize
+	serverTCPSndBuf     utils.ByteSize
+	serverTCPCongestion string
+
+	serverFTPPort         int
+	serverFTPPassivePorts string
+	serverFTPPublicIP     string
+	serverFTPCert         string
+	serverFTPKey          string
+
+	// Options given on the command line or in the environment, kept on reload
+	serverGiven map[string]bool
+
+	serverRsyncPort   int
+	serverRsyncModule string
 )
 
 func init() {
@@ -23,24 +69,73 @@ func init() {
 	ServerCmd.Flags().IntVarP(&serverPort, "port", "p", 8080, "Service port")
 	ServerCmd.Flags().StringVarP(&serverLogHome, "log-home", "", "./logs", "Log file home")
 	ServerCmd.Flags().StringVarP(&serverLogLevel, "log-level", "", "debug", "Log level")
+	ServerCmd.Flags().StringVar(&serverLogFormat, "log-format", logger.FormatConsole, "Log format, console or json for log ingestion pipelines")
+	ServerCmd.Flags().StringVar(&serverLogOutput, "log-output", logger.OutputFile, "Where the log goes: file under --log-home, stdout or stderr, or several separated by commas such as file,stderr")
+	ServerCmd.Flags().StringVar(&serverAccessLog, "access-log", "", "Also write the requests over HTTP and FTP to this file in the W3C extended log format, read by log analyzers such as AWStats and GoAccess")
+	ServerCmd.Flags().DurationVar(&serverSlowReq, "slow-request", 0, "Log requests taking longer than this, such as 30s, at warn level with their range, bytes and client, 0 disables it")
+	ServerCmd.Flags().IntVar(&serverFileCache, "open-file-cache", 0, "Keep up to this many recently served files open, sharing their descriptors between concurrent requests, 0 disables it")
+	ServerCmd.Flags().BoolVar(&serverPprof, "enable-pprof", false, "Serve the pprof profiles and expvar counters on --admin-addr to diagnose CPU and memory use")
+	ServerCmd.Flags().StringVar(&serverAdminAddr, "admin-addr", server.DefaultAdminAddr, "Address of the unauthenticated pprof and expvar endpoints of --enable-pprof")
+	ServerCmd.Flags().BoolVar(&serverUpload, "enable-upload", false, "Accept file uploads (PUT), required by push and bidirectional sync")
+	ServerCmd.Flags().BoolVar(&serverInsecure, "insecure-upload", false, "Allow --enable-upload without --auth, letting anyone who reaches the server write and delete files")
+	ServerCmd.Flags().StringVar(&serverAuth, "auth", "", "Require basic auth with username:password")
+	ServerCmd.Flags().StringVar(&serverBwLimit, "bwlimit", "", "Bandwidth limit of all downloads together, a rate such as 10M or a schedule such as \"09:00,10M 18:00,off\"")
+	// --limit-rate as in curl and wget
+	ServerCmd.Flags().SetNormalizeFunc(config.NormalizeAliases)
+	ServerCmd.Flags().BoolVar(&serverQUIC, "quic", false, "Serve the native ezft protocol over QUIC on the UDP port of the same number")
+	ServerCmd.Flags().BoolVar(&serverCompress, "compress", true, "Compress chunks for ezft clients asking for it, unless the file is compressed already")
+	ServerCmd.Flags().BoolVar(&serverTracker, "tracker", false, "Track the clients downloading a file so they exchange chunks with each other")
+	ServerCmd.Flags().StringVar(&serverE2EKey, "e2e-key", "", "Encrypt file contents end to end with this pre-shared key of at least 16 characters, clients need the same key")
+	ServerCmd.Flags().BoolVar(&serverTCPNoDelay, "tcp-nodelay", true, "Disable Nagle's algorithm on HTTP connections, sending small writes at once")
+	ServerCmd.Flags().Var(&serverTCPRcvBuf, "tcp-rcvbuf", "Receive buffer size of HTTP connections, 0 for the system default")
+	ServerCmd.Flags().Var(&serverTCPSndBuf, "tcp-sndbuf", "Send buffer size of HTTP connections, such as 4M for links with a high bandwidth-delay product, 0 for the system default")
+	ServerCmd.Flags().StringVar(&serverTCPCongestion, "tcp-congestion", "", "TCP congestion control of HTTP connections, such as bbr, Linux only")
+	ServerCmd.Flags().IntVar(&serverFTPPort, "ftp-port", 0, "Also serve the root over passive-mode FTP on this port, 0 disables FTP")
+	ServerCmd.Flags().StringVar(&serverFTPPassivePorts, "ftp-passive-ports", "", "Port range of FTP data connections such as 30000-30100, any free port by default")
+	ServerCmd.Flags().StringVar(&serverFTPPublicIP, "ftp-public-ip", "", "IPv4 address announced for FTP data connections, for servers behind NAT")
+	ServerCmd.Flags().StringVar(&serverFTPCert, "ftp-cert", "", "Certificate file offering FTPS through AUTH TLS, requires --ftp-key")
+	ServerCmd.Flags().StringVar(&serverFTPKey, "ftp-key", "", "Private key file of --ftp-cert")
+	ServerCmd.Flags().StringVar(&serverConfig, "config", "", "YAML or TOML file with defaults of these options in its server section, by default the first ezft.yaml or ezft.toml found in the working directory, the user configuration directory and /etc/ezft")
+	ServerCmd.Flags().IntVar(&serverRsyncPort, "rsync-port", 0, "Also serve the root read-only to rsync clients over the rsync daemon protocol on this port, such as 873, 0 disables it")
+	ServerCmd.Flags().StringVar(&serverRsyncModule, "rsync-module", "ezft", "Name of the rsync module of the root, as in rsync://host/ezft/path")
 }
 
 var ServerCmd = &cobra.Command{
 	Use:   "server",
 	Short: "EZFT Server - Provide file download service",
-	Long:  "EZFT server is a high-performance file download server that supports resume download, Range requests and multi-client concurrent downloads.",
+	Long:  "EZFT server is a high-performance file download server that supports resume download, Range requests and multi-client concurrent downloads.\n\nOn SIGHUP, except on Windows, the server reloads --auth, --bwlimit, --slow-request and the FTPS certificate of --ftp-cert and --ftp-key from its configuration file, keeping the values given on the command line or in the environment. These are all the access and TLS settings it has: there are no access lists or deny patterns, and HTTP is served without TLS. Other options take effect on restart.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := config.ApplyEnv(cmd.Flags(), "server"); err != nil {
+			return exitcode.UsageError(err)
+		}
+		serverGiven = map[string]bool{}
+		cmd.Flags().Visit(func(f *pflag.Flag) {
+			serverGiven[f.Name] = true
+		})
+		file, err := config.FindFile(serverConfig)
+		if err != nil {
+			return exitcode.UsageError(err)
+		}
+		return exitcode.UsageError(file.Apply(cmd.Flags(), "server"))
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkUploadAuth(); err != nil {
+			return exitcode.UsageError(err)
+		}
+
 		// Check if root directory exists, create if it doesn't exist
 		if err := utils.EnsureDir(serverRootDir); err != nil {
 			return fmt.Errorf("failed to create root directory: %w", err)
 		}
 
-		if err := utils.EnsureDir(serverLogHome); err != nil {
-			return fmt.Errorf("failed to create log directory: %w", err)
+		if logger.WritesFile(serverLogOutput) {
+			if err := utils.EnsureDir(serverLogHome); err != nil {
+				return fmt.Errorf("failed to create log directory: %w", err)
+			}
 		}
 
 		// Create logger
-		l, err := logger.NewLogger(serverLogHome+"/server.log", serverLogLevel)
+		l, err := logger.New(logger.Config{File: serverLogHome + "/server.log", Output: serverLogOutput, Level: serverLogLevel, Format: serverLogFormat})
 		if err != nil {
 			return fmt.Errorf("failed to create logger: %w", err)
 		}
@@ -48,6 +143,89 @@ var ServerCmd = &cobra.Command{
 		// Create and start server
 		srv := server.NewServer(serverRootDir, serverPort)
 		srv.SetLogger(l)
+		srv.SetUploadEnabled(serverUpload)
+		srv.SetQUICEnabled(serverQUIC)
+		srv.SetCompressionEnabled(serverCompress)
+		srv.SetTrackerEnabled(serverTracker)
+		srv.SetSlowRequestThreshold(serverSlowReq)
+		if serverFileCache < 0 {
+			return exitcode.UsageError(errors.New("--open-file-cache must not be negative"))
+		}
+		srv.SetOpenFileCache(serverFileCache)
+		if serverPprof {
+			srv.SetAdminAddr(serverAdminAddr)
+		}
+		if serverAccessLog != "" {
+			f, err := os.OpenFile(serverAccessLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return fmt.Errorf("failed to open access log: %w", err)
+			}
+			defer f.Close()
+			accessLog, err := server.NewAccessLog(f, "ezft "+config.FullVersion())
+			if err != nil {
+				return fmt.Errorf("failed to write access log: %w", err)
+			}
+			srv.SetAccessLog(accessLog)
+		}
+
+		socket := &sockopt.Options{
+			Nagle:       !serverTCPNoDelay,
+			ReadBuffer:  int(serverTCPRcvBuf),
+			WriteBuffer: int(serverTCPSndBuf),
+			Congestion:  serverTCPCongestion,
+		}
+		if *socket != (sockopt.Options{}) {
+			if socket.Congestion != "" {
+				if err := sockopt.CheckCongestion(socket.Congestion); err != nil {
+					return exitcode.UsageError(err)
+				}
+			}
+			srv.SetSocketOptions(socket)
+		}
+
+		if serverBwLimit != "" {
+			schedule, err := ratelimit.ParseSchedule(serverBwLimit)
+			if err != nil {
+				return exitcode.UsageError(err)
+			}
+			srv.SetRateLimiter(ratelimit.NewLimiter(schedule))
+		}
+
+		if serverAuth != "" {
+			username, password, err := utils.ParseCredentials(serverAuth)
+			if err != nil {
+				return err
+			}
+			srv.SetAuth(username, password)
+		}
+
+		if serverE2EKey != "" {
+			key, err := e2e.ParseKey(serverE2EKey)
+			if err != nil {
+				return err
+			}
+			srv.SetE2EKey(key)
+		}
+
+		var cert *certificate
+		if serverFTPPort > 0 {
+			var config *ftp.Config
+			config, cert, err = ftpConfig()
+			if err != nil {
+				return err
+			}
+			srv.SetFTPConfig(config)
+		}
+		if serverRsyncPort > 0 {
+			if serverRsyncModule == "" || strings.ContainsAny(serverRsyncModule, "/ \t\n") {
+				return exitcode.UsageError(fmt.Errorf("invalid rsync module name %q", serverRsyncModule))
+			}
+			srv.SetRsyncConfig(&rsyncd.Config{
+				Addr:    fmt.Sprintf(":%d", serverRsyncPort),
+				Modules: []rsyncd.Module{{Name: serverRsyncModule, Path: serverRootDir, Comment: "ezft file root"}},
+			})
+		}
+		watchReload(cmd.Flags(), srv, cert, l)
 
 		if err := srv.Start(); err != nil {
 			return fmt.Errorf("server failed: %w", err)
@@ -55,3 +233,39 @@ var ServerCmd = &cobra.Command{
 		return nil
 	},
 }
+
+// ftpConfig builds the configuration of the FTP front end from the flags, and
+// the holder of its certificate when FTPS is offered
+func ftpConfig() (*ftp.Config, *certificate, error) {
+	config := &ftp.Config{
+		Addr:     fmt.Sprintf(":%d", serverFTPPort),
+		PublicIP: serverFTPPublicIP,
+	}
+	if serverFTPPassivePorts != "" {
+		low, high, err := ftp.ParsePortRange(serverFTPPassivePorts)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.MinPassivePort, config.MaxPassivePort = low, high
+	}
+	if serverFTPCert != "" || serverFTPKey != "" {
+		c, err := loadCertificate()
+		if err != nil {
+			return nil, nil, err
+		}
+		cert := &certificate{}
+		cert.cert.Store(c)
+		config.TLS = &tls.Config{GetCertificate: cert.get}
+		return config, cert, nil
+	}
+	return config, nil, nil
+}
+
+// checkUploadAuth refuses uploads open to anyone unless --insecure-upload
+// asks for them
+func checkUploadAuth() error {
+	if serverUpload && serverAuth == "" && !serverInsecure {
+		return errors.New("--enable-upload requires --auth, or --insecure-upload to accept uploads from anyone")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package share
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/easzlab/ezft/internal/exitcode"
+	"github.com/easzlab/ezft/pkg/share"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/easzlab/ezft/pkg/utils/logger"
+	"github.com/spf13/cobra"
+)
+
+// share subcommand related variables
+var (
+	shareListen    string
+	shareDownloads int
+	shareTTL       time.Duration
+	shareLogHome   string
+	shareLogLevel  string
+	shareLogFormat string
+	shareLogOutput string
+)
+
+func init() {
+	// share subcommand parameters
+	ShareCmd.Flags().StringVarP(&shareListen, "listen", "l", ":0", "Address to serve the file on, any free port of all interfaces by default")
+	ShareCmd.Flags().IntVarP(&shareDownloads, "downloads", "n", 0, "Stop after this many complete downloads, 0 for no limit")
+	ShareCmd.Flags().DurationVar(&shareTTL, "ttl", 0, "Stop after this long, such as 30m, 0 for no limit")
+	ShareCmd.Flags().StringVarP(&shareLogHome, "log-home", "", "./logs", "Log file home")
+	ShareCmd.Flags().StringVarP(&shareLogLevel, "log-level", "", "debug", "Log level")
+	ShareCmd.Flags().StringVar(&shareLogFormat, "log-format", logger.FormatConsole, "Log format, console or json for log ingestion pipelines")
+	ShareCmd.Flags().StringVar(&shareLogOutput, "log-output", logger.OutputFile, "Where the log goes: file under --log-home, stdout or stderr, or several separated by commas such as file,stderr")
+}
+
+var ShareCmd = &cobra.Command{
+	Use:   "share <file>",
+	Short: "EZFT Share - Serve a file once under a temporary URL",
+	Long: `EZFT share serves a single file under a URL with a random token until it was
+downloaded a number of times or a time to live elapsed, then exits. Nothing
+else is served. A download counts once a client received every byte of the
+file, in one request or in the ranges of a resumed or chunked download.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if shareDownloads < 0 || shareTTL < 0 {
+			return exitcode.UsageError(errors.New("--downloads and --ttl must not be negative"))
+		}
+		if logger.WritesFile(shareLogOutput) {
+			if err := utils.EnsureDir(shareLogHome); err != nil {
+				return fmt.Errorf("failed to create log directory: %w", err)
+			}
+		}
+		l, err := logger.New(logger.Config{File: shareLogHome + "/share.log", Output: shareLogOutput, Level: shareLogLevel, Format: shareLogFormat})
+		if err != nil {
+			return fmt.Errorf("failed to create logger: %w", err)
+		}
+
+		s := share.NewShare(&share.Config{
+			Path:         args[0],
+			Addr:         shareListen,
+			MaxDownloads: shareDownloads,
+			TTL:          shareTTL,
+		})
+		s.SetLogger(l)
+		s.OnDownload(func(host string, n int) {
+			of := ""
+			if shareDownloads > 0 {
+				of = fmt.Sprintf(" of %d", shareDownloads)
+			}
+			fmt.Printf("✓ Download %d%s completed by %s\n", n, of, host)
+		})
+		if err := s.Listen(); err != nil {
+			return err
+		}
+
+		var limits []string
+		if shareDownloads > 0 {
+			limits = append(limits, fmt.Sprintf("after %d downloads", shareDownloads))
+		}
+		if shareTTL > 0 {
+			limits = append(limits, "in "+shareTTL.String())
+		}
+		stop := "stop with Ctrl+C"
+		if len(limits) > 0 {
+			stop = "stops " + strings.Join(limits, " or ")
+		}
+		fmt.Printf("Sharing %s (%s), %s\n", args[0], utils.FormatBytes(s.Size()), stop)
+		for _, u := range s.URLs() {
+			fmt.Printf("  %s\n", u)
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+		report, err := s.Serve(ctx)
+		fmt.Printf("Share stopped: %d downloads, %s served in %s\n", report.Downloads, utils.FormatBytes(report.Bytes), utils.FormatDuration(report.Duration))
+		return err
+	},
+}
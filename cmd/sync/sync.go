@@ -0,0 +1,360 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/easzlab/ezft/internal/config"
+	"github.com/easzlab/ezft/internal/keyring"
+	"github.com/easzlab/ezft/internal/prompt"
+	"github.com/easzlab/ezft/pkg/metrics"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/syncer"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/easzlab/ezft/pkg/utils/logger"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// sync subcommand related variables
+var (
+	syncBidirectional  bool
+	syncConflict       string
+	syncConflictReport string
+	syncUser           string
+	syncNoInteractive  bool
+	syncNoProxyEnv     bool
+	syncChunkSize      = utils.ByteSize(1024 * 1024)
+	syncConcurrency    int
+	syncRetryCount     int
+	syncLogHome        string
+	syncLogLevel       string
+	syncLogFormat      string
+	syncLogOutput      string
+	syncDryRun         bool
+	syncTransfers      int
+	syncInclude        []string
+	syncExclude        []string
+	syncDelete         bool
+	syncMaxDelete      int
+	syncBackupDir      string
+	syncWatch          bool
+	syncDebounce       time.Duration
+	syncPollInterval   time.Duration
+	syncBwLimit        string
+	syncNoResume       bool
+	syncVerify         bool
+	syncVerifyReport   string
+	syncVerifyKey      string
+	syncMetricsPush    string
+)
+
+func init() {
+	// sync subcommand parameters
+	SyncCmd.Flags().BoolVar(&syncBidirectional, "bidirectional", false, "Propagate changes in both directions")
+	SyncCmd.Flags().StringVar(&syncConflict, "conflict", string(syncer.Abort), "Conflict strategy of bidirectional sync: newer-wins, keep-both, abort")
+	SyncCmd.Flags().StringVar(&syncConflictReport, "conflict-report", "", "Write conflicts as JSON to this file")
+	SyncCmd.Flags().BoolVar(&syncDryRun, "dry-run", false, "Print the planned actions without transferring or writing anything")
+	SyncCmd.Flags().StringArrayVar(&syncInclude, "include", nil, "Only sync files matching this glob pattern, can be repeated")
+	SyncCmd.Flags().StringArrayVar(&syncExclude, "exclude", nil, "Skip files matching this glob pattern, can be repeated")
+	SyncCmd.Flags().BoolVar(&syncDelete, "delete", false, "Delete destination files missing from the source")
+	SyncCmd.Flags().IntVar(&syncMaxDelete, "max-delete", 0, "Do not delete anything if more files would be deleted, 0 for no limit")
+	SyncCmd.Flags().StringVar(&syncBackupDir, "backup-dir", "", "Move deleted files into this directory, relative to the destination root")
+	SyncCmd.Flags().BoolVar(&syncWatch, "watch", false, "Keep running and sync changes as they happen")
+	SyncCmd.Flags().DurationVar(&syncDebounce, "debounce", syncer.DefaultDebounce, "Quiet period after local changes before syncing in watch mode")
+	SyncCmd.Flags().DurationVar(&syncPollInterval, "poll-interval", syncer.DefaultPollInterval, "Interval of remote change polling in watch mode")
+	SyncCmd.Flags().StringVar(&syncBwLimit, "bwlimit", "", "Bandwidth limit shared by all transfers, a rate such as 1M or a schedule such as \"09:00,1M 18:00,off\"")
+	// --limit-rate as in curl and wget
+	SyncCmd.Flags().SetNormalizeFunc(config.NormalizeAliases)
+	SyncCmd.Flags().BoolVar(&syncNoResume, "no-resume", false, "Discard an interrupted sync and plan from scratch instead of resuming it")
+	SyncCmd.Flags().BoolVar(&syncVerify, "verify", false, "Hash the transferred files on both sides after the sync and fail on mismatches")
+	SyncCmd.Flags().StringVar(&syncVerifyReport, "verify-report", "", "Write the verification result as JSON to this file")
+	SyncCmd.Flags().StringVar(&syncVerifyKey, "verify-key", "", "Sign the verification report with HMAC-SHA256 using the key in this file")
+	SyncCmd.Flags().StringVar(&syncMetricsPush, "metrics-push", "", "Push the bytes, duration and outcome of every sync run to StatsD, such as statsd://127.0.0.1:8125, or a Prometheus Pushgateway, such as http://pushgateway:9091")
+	SyncCmd.Flags().StringVar(&syncUser, "user", "", "Basic auth credentials username:password")
+	SyncCmd.Flags().BoolVar(&syncNoInteractive, "no-interactive", false, "Fail instead of asking for credentials on the terminal when the server requires them")
+	SyncCmd.Flags().BoolVar(&syncNoProxyEnv, "no-proxy-env", false, "Ignore the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables and connect to servers directly")
+	SyncCmd.Flags().VarP(&syncChunkSize, "chunk-size", "s", "Chunk size in bytes, with an optional binary unit such as 4M")
+	SyncCmd.Flags().IntVarP(&syncConcurrency, "concurrency", "c", 1, "Concurrency count per file")
+	SyncCmd.Flags().IntVarP(&syncTransfers, "transfers", "t", syncer.DefaultTransfers, "Number of files transferred in parallel")
+	SyncCmd.Flags().IntVarP(&syncRetryCount, "retry", "r", 3, "Retry count")
+	SyncCmd.Flags().StringVarP(&syncLogHome, "log-home", "", "./logs", "Log file home")
+	SyncCmd.Flags().StringVarP(&syncLogLevel, "log-level", "", "debug", "Log level")
+	SyncCmd.Flags().StringVar(&syncLogFormat, "log-format", logger.FormatConsole, "Log format, console or json for log ingestion pipelines")
+	SyncCmd.Flags().StringVar(&syncLogOutput, "log-output", logger.OutputFile, "Where the log goes: file under --log-home, stdout or stderr, or several separated by commas such as file,stderr")
+}
+
+var SyncCmd = &cobra.Command{
+	Use:   "sync <source> <destination>",
+	Short: "EZFT Sync - Synchronize a local directory with an ezft server",
+	Long: `EZFT sync mirrors a directory between the local filesystem and an ezft server.
+One of source and destination is a local directory, the other a remote directory URL such as http://host:8080/releases/
+or ezft://host/releases/ (port 8080 unless given, ezfts:// for https).
+Changed files are updated by delta transfer, uploads require a server started with --enable-upload.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		source, destination := args[0], args[1]
+
+		config := &syncer.Config{
+			ChunkSize:      int64(syncChunkSize),
+			MaxConcurrency: syncConcurrency,
+			RetryCount:     syncRetryCount,
+			DryRun:         syncDryRun,
+			Transfers:      syncTransfers,
+			Include:        syncInclude,
+			Exclude:        syncExclude,
+			Delete:         syncDelete,
+			MaxDelete:      syncMaxDelete,
+			BackupDir:      syncBackupDir,
+			Debounce:       syncDebounce,
+			PollInterval:   syncPollInterval,
+			NoResume:       syncNoResume,
+			Verify:         syncVerify,
+			NoProxyEnv:     syncNoProxyEnv,
+		}
+
+		switch {
+		case isRemote(source) && !isRemote(destination):
+			config.RemoteURL, config.LocalDir, config.Direction = source, destination, syncer.Pull
+		case !isRemote(source) && isRemote(destination):
+			config.LocalDir, config.RemoteURL, config.Direction = source, destination, syncer.Push
+		default:
+			return fmt.Errorf("exactly one of source and destination must be a remote URL")
+		}
+		if syncBidirectional {
+			config.Direction = syncer.Bidirectional
+		}
+
+		conflict, err := syncer.ParseConflictStrategy(syncConflict)
+		if err != nil {
+			return err
+		}
+		config.Conflict = conflict
+
+		if syncUser != "" {
+			if config.Username, config.Password, err = utils.ParseCredentials(syncUser); err != nil {
+				return err
+			}
+		}
+
+		if (syncVerifyReport != "" || syncVerifyKey != "") && !syncVerify {
+			return fmt.Errorf("--verify-report and --verify-key require --verify")
+		}
+		var verifyKey []byte
+		if syncVerifyKey != "" {
+			if verifyKey, err = os.ReadFile(syncVerifyKey); err != nil {
+				return fmt.Errorf("failed to read verification key: %w", err)
+			}
+		}
+		var pusher *metrics.Pusher
+		if syncMetricsPush != "" {
+			if pusher, err = metrics.NewPusher(syncMetricsPush); err != nil {
+				return err
+			}
+		}
+
+		if logger.WritesFile(syncLogOutput) {
+			if err := utils.EnsureDir(syncLogHome); err != nil {
+				return fmt.Errorf("failed to create log directory: %w", err)
+			}
+		}
+
+		// Create logger
+		l, err := logger.New(logger.Config{File: syncLogHome + "/sync.log", Output: syncLogOutput, Level: syncLogLevel, Format: syncLogFormat})
+		if err != nil {
+			return fmt.Errorf("failed to create logger: %w", err)
+		}
+
+		s, err := syncer.NewSyncer(config)
+		if err != nil {
+			return err
+		}
+		s.SetLogger(l)
+		// Credentials the server requires are those stored by ezft login, else
+		// asked on the terminal unless given
+		var prompter *prompt.Prompter
+		if syncUser == "" && !syncNoInteractive && prompt.Interactive() {
+			prompter = prompt.NewPrompter()
+		}
+		s.SetCredentialsFunc(keyring.CredentialsFunc(prompter))
+		if syncBwLimit != "" {
+			schedule, err := ratelimit.ParseSchedule(syncBwLimit)
+			if err != nil {
+				return err
+			}
+			s.SetRateLimiter(ratelimit.NewLimiter(schedule))
+		}
+
+		// Set signal handling
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+		go func() {
+			<-sigChan
+			fmt.Println("\nReceived interrupt signal, stopping sync...")
+			cancel()
+		}()
+
+		if syncWatch {
+			fmt.Printf("Watching %s for changes, press Ctrl+C to stop\n", config.LocalDir)
+			return s.Watch(ctx, func(report *syncer.Report, err error) {
+				if err := finishRun(l, pusher, report, err, verifyKey); err != nil {
+					fmt.Printf("✗ %v\n", err)
+				}
+			})
+		}
+
+		report, err := s.Run(ctx)
+		return finishRun(l, pusher, report, err, verifyKey)
+	},
+}
+
+// finishRun prints the outcome of a sync run and pushes its metrics to pusher
+// if not nil, the verification report is signed with verifyKey if given
+func finishRun(l *zap.Logger, pusher *metrics.Pusher, report *syncer.Report, err error, verifyKey []byte) error {
+	if pusher != nil && !syncDryRun {
+		t := &metrics.Transfer{Type: "sync", Err: err}
+		if report != nil {
+			t.Bytes, t.Duration = report.Bytes, report.Duration
+		}
+		if err := pusher.Push(context.Background(), t); err != nil {
+			l.Warn("failed to push metrics", zap.Error(err))
+		}
+	}
+	if report != nil {
+		for _, c := range report.Conflicts {
+			fmt.Printf("Conflict: %s (%s)\n", c.Path, c.Resolution)
+		}
+		if syncConflictReport != "" {
+			if err := report.WriteConflictReport(syncConflictReport); err != nil {
+				return err
+			}
+		}
+		if err := finishVerification(report.Verification, verifyKey); err != nil {
+			return err
+		}
+	}
+
+	if syncDryRun && report != nil {
+		printPlan(report)
+	}
+
+	if err != nil {
+		if errors.Is(err, syncer.ErrConflicts) {
+			return fmt.Errorf("%w, choose a --conflict strategy to resolve them", err)
+		}
+		if errors.Is(err, syncer.ErrTooManyDeletes) {
+			return fmt.Errorf("%w, raise --max-delete to allow them", err)
+		}
+		if errors.Is(err, syncer.ErrVerifyFailed) {
+			return err
+		}
+		return fmt.Errorf("sync failed: %w", err)
+	}
+
+	if syncDryRun {
+		return nil
+	}
+
+	if report.Resumed > 0 {
+		fmt.Printf("Resumed an interrupted sync, %d files were already done\n", report.Resumed)
+	}
+	fmt.Printf("✓ Sync completed! Duration: %s Downloaded: %d Uploaded: %d Copied: %d Deleted: %d Unchanged: %d Transferred: %s\n",
+		utils.FormatDuration(report.Duration),
+		report.Downloaded,
+		report.Uploaded,
+		report.Copied,
+		report.Deleted,
+		report.Skipped,
+		utils.FormatBytes(report.Bytes),
+	)
+	if report.Verification != nil {
+		fmt.Printf("✓ Verified %d transferred files\n", len(report.Verification.Files))
+	}
+	l.Info("Sync completed",
+		zap.String("duration", utils.FormatDuration(report.Duration)),
+		zap.Int("downloaded", report.Downloaded),
+		zap.Int("uploaded", report.Uploaded),
+		zap.Int("copied", report.Copied),
+		zap.Int("deleted", report.Deleted),
+		zap.Int("skipped", report.Skipped),
+		zap.Int("resumed", report.Resumed),
+		zap.Int("conflicts", len(report.Conflicts)),
+	)
+	return nil
+}
+
+// finishVerification prints the mismatches found by the verification pass
+// and writes its report
+func finishVerification(v *syncer.Verification, key []byte) error {
+	if v == nil {
+		return nil
+	}
+	for _, f := range v.Files {
+		switch {
+		case f.Error != "":
+			fmt.Printf("Unverified: %s (%s)\n", f.Path, f.Error)
+		case !f.Match:
+			fmt.Printf("Mismatch: %s (local %s, remote %s)\n", f.Path, f.LocalHash, f.RemoteHash)
+		}
+	}
+	if syncVerifyReport == "" {
+		return nil
+	}
+	if key != nil {
+		if err := v.Sign(key); err != nil {
+			return err
+		}
+	}
+	return v.Write(syncVerifyReport)
+}
+
+// printPlan prints the planned actions followed by per action totals
+func printPlan(report *syncer.Report) {
+	order := []syncer.Action{syncer.ActionDownload, syncer.ActionUpload, syncer.ActionKeepBoth, syncer.ActionCopy, syncer.ActionDelete, syncer.ActionSkip}
+	counts := map[syncer.Action]int{}
+	bytes := map[syncer.Action]int64{}
+
+	for _, task := range report.Plan {
+		counts[task.Action]++
+		bytes[task.Action] += task.Bytes()
+		if task.Action == syncer.ActionCopy {
+			fmt.Printf("%-10s %10s  %s -> %s\n", task.Action, utils.FormatBytes(task.Bytes()), task.From, task.Path)
+			continue
+		}
+		fmt.Printf("%-10s %10s  %s\n", task.Action, utils.FormatBytes(task.Bytes()), task.Path)
+	}
+
+	var totals []string
+	var total int64
+	for _, action := range order {
+		if counts[action] == 0 {
+			continue
+		}
+		totals = append(totals, fmt.Sprintf("%s %d (%s)", action, counts[action], utils.FormatBytes(bytes[action])))
+		total += bytes[action]
+	}
+	if len(totals) == 0 {
+		totals = append(totals, "nothing to do")
+	}
+	fmt.Printf("Dry run: %s, %s to transfer\n", strings.Join(totals, ", "), utils.FormatBytes(total))
+}
+
+// isRemote reports whether a sync argument is a remote URL
+func isRemote(s string) bool {
+	for _, scheme := range []string{"http://", "https://", "ezft://", "ezfts://"} {
+		if strings.HasPrefix(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
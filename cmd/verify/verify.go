@@ -0,0 +1,238 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/easzlab/ezft/internal/exitcode"
+	"github.com/easzlab/ezft/internal/keyring"
+	"github.com/easzlab/ezft/internal/prompt"
+	"github.com/easzlab/ezft/pkg/client"
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// verify subcommand related variables
+var (
+	verifyManifest      string
+	verifySHA256        string
+	verifySums          string
+	verifyURL           string
+	verifyUser          string
+	verifyNoInteractive bool
+	verifyNoProxyEnv    bool
+)
+
+// sidecarExtensions extensions of the checksum files looked for next to the
+// file when no source is given, the summary written by ezft client --summary
+// last
+var sidecarExtensions = []string{".sha256", ".sha512", ".sha1", ".md5", client.SummaryExt}
+
+// maxListedChunks bounds the mismatching chunks printed
+const maxListedChunks = 10
+
+func init() {
+	// verify subcommand parameters
+	VerifyCmd.Flags().StringVarP(&verifyManifest, "manifest", "m", "", "Chunk manifest to verify every chunk of the file against")
+	VerifyCmd.Flags().StringVar(&verifySHA256, "sha256", "", "Expected SHA-256 of the file, hex encoded")
+	VerifyCmd.Flags().StringVar(&verifySums, "sums", "", "Checksum file listing the file, such as a SHA256SUMS, a sidecar file.sha256 or the download summary file.ezft.json")
+	VerifyCmd.Flags().StringVarP(&verifyURL, "url", "u", "", "URL the file was downloaded from, whose digest the server provides")
+	VerifyCmd.Flags().StringVar(&verifyUser, "user", "", "Basic auth credentials username:password for --url")
+	VerifyCmd.Flags().BoolVar(&verifyNoInteractive, "no-interactive", false, "Fail instead of asking for credentials on the terminal when the server requires them")
+	VerifyCmd.Flags().BoolVar(&verifyNoProxyEnv, "no-proxy-env", false, "Ignore the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables and connect to servers directly")
+
+	VerifyCmd.MarkFlagsMutuallyExclusive("manifest", "sha256", "sums", "url")
+}
+
+var VerifyCmd = &cobra.Command{
+	Use:   "verify <file>",
+	Short: "EZFT Verify - Verify a downloaded file",
+	Long: `EZFT verify checks a file against a chunk manifest, a hash, a checksum file or
+the digest of the URL it was downloaded from, and exits with status 4 if it
+does not match. The digest of a URL comes from the Repr-Digest or Digest header
+of the server, or from the hash endpoint of ezft servers. Without a source the
+checksum file named like the file with the extension .sha256, .sha512, .sha1 or
+.md5 is used, else the summary .ezft.json written by ezft client --summary,
+else the SHA-256 recorded in the extended attributes by ezft client --xattr.
+
+  ezft verify os.img --manifest os.img.json
+  ezft verify os.img --sha256 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+  ezft verify os.img --url http://files.example.com:8080/os.img`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return exitcode.UsageError(fmt.Errorf("%s is not a regular file", path))
+		}
+
+		if verifyManifest != "" {
+			return verifyChunks(path, info.Size())
+		}
+		var algo, want, source string
+		switch {
+		case verifySHA256 != "":
+			algo, want, source = "sha256", strings.ToLower(verifySHA256), "--sha256"
+			if manifest.SumAlgo(want) != "sha256" {
+				return exitcode.UsageError(fmt.Errorf("invalid SHA-256 %q, 64 hex digits expected", verifySHA256))
+			}
+		case verifyURL != "":
+			var username, password string
+			if verifyUser != "" {
+				if username, password, err = utils.ParseCredentials(verifyUser); err != nil {
+					return exitcode.UsageError(err)
+				}
+			}
+			c := client.NewClient(&client.DownloadConfig{URL: verifyURL, NoProxyEnv: verifyNoProxyEnv, Username: username, Password: password})
+			var prompter *prompt.Prompter
+			if verifyUser == "" && !verifyNoInteractive && prompt.Interactive() {
+				prompter = prompt.NewPrompter()
+			}
+			c.SetCredentialsFunc(keyring.CredentialsFunc(prompter))
+			digest, err := c.RemoteDigest(context.Background())
+			if err != nil {
+				return err
+			}
+			if digest.Size >= 0 && digest.Size != info.Size() {
+				fmt.Printf("%s: FAILED size %d, the remote file has %d\n", path, info.Size(), digest.Size)
+				return fmt.Errorf("%w: %s", manifest.ErrSumMismatch, path)
+			}
+			algo, want, source = digest.Algo, digest.Hash, digest.Source
+		case verifySums == "" && findSidecar(path) == "":
+			// Recorded by ezft client --xattr
+			summary, err := client.ReadXattrs(path)
+			if err != nil {
+				return exitcode.UsageError(errors.New("one of --manifest, --sha256, --sums or --url is required, no checksum file found next to the file nor provenance in its extended attributes"))
+			}
+			algo, want, source = "sha256", summary.SHA256, client.XattrSHA256
+			if summary.URL != "" {
+				source += " (" + summary.URL + ")"
+			}
+		default:
+			sums := verifySums
+			if sums == "" {
+				sums = findSidecar(path)
+			}
+			if strings.HasSuffix(sums, client.SummaryExt) {
+				summary, err := client.ReadSummary(sums)
+				if err != nil {
+					return fmt.Errorf("%s: %w", sums, err)
+				}
+				if summary.Size != info.Size() {
+					fmt.Printf("%s: FAILED size %d, the download had %d\n", path, info.Size(), summary.Size)
+					return fmt.Errorf("%w: %s", manifest.ErrSumMismatch, path)
+				}
+				want = summary.SHA256
+			} else if want, err = readSidecar(sums, filepath.Base(path), verifySums == ""); err != nil {
+				return err
+			}
+			algo, source = manifest.SumAlgo(want), sums
+			if algo == "" {
+				return fmt.Errorf("%s: unknown hash algorithm of %q", sums, want)
+			}
+		}
+
+		sum, err := utils.CalculateFileHash(path, algo)
+		if err != nil {
+			return err
+		}
+		if sum != want {
+			fmt.Printf("%s: FAILED %s %s, expected %s from %s\n", path, algo, sum, want, source)
+			return fmt.Errorf("%w: %s", manifest.ErrSumMismatch, path)
+		}
+		fmt.Printf("%s: OK %s %s from %s\n", path, algo, sum, source)
+		return nil
+	},
+}
+
+// verifyChunks checks every chunk of the file against the chunk manifest,
+// listing those not matching
+func verifyChunks(path string, size int64) error {
+	f, err := os.Open(verifyManifest)
+	if err != nil {
+		return err
+	}
+	chunks, err := manifest.ReadChunks(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", verifyManifest, err)
+	}
+	if chunks.Size != size {
+		fmt.Printf("%s: FAILED size %d, the manifest describes %d bytes\n", path, size, chunks.Size)
+		return fmt.Errorf("%w: %s", manifest.ErrChunkMismatch, path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var invalid int
+	for i := range chunks.Hashes {
+		err := chunks.VerifyAt(file, i)
+		if errors.Is(err, manifest.ErrChunkMismatch) {
+			if invalid++; invalid <= maxListedChunks {
+				start, end := chunks.Range(i)
+				fmt.Printf("%s: chunk %d, bytes %d-%d: FAILED\n", path, i, start, end)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if invalid > 0 {
+		if invalid > maxListedChunks {
+			fmt.Printf("%s: %d more chunks FAILED\n", path, invalid-maxListedChunks)
+		}
+		fmt.Printf("%s: FAILED %d of %d chunks\n", path, invalid, len(chunks.Hashes))
+		return fmt.Errorf("%d chunks of %s: %w", invalid, path, manifest.ErrChunkMismatch)
+	}
+	fmt.Printf("%s: OK %d chunks of %s\n", path, len(chunks.Hashes), utils.FormatBytes(chunks.ChunkSize))
+	return nil
+}
+
+// findSidecar returns the checksum file next to path named after it, empty
+// if there is none
+func findSidecar(path string) string {
+	for _, ext := range sidecarExtensions {
+		if info, err := os.Stat(path + ext); err == nil && info.Mode().IsRegular() {
+			return path + ext
+		}
+	}
+	return ""
+}
+
+// readSidecar returns the hash of the file name listed in the checksum file,
+// which may also hold nothing but a hash. A sidecar named after the file may
+// list it under any name.
+func readSidecar(sumsPath, name string, sidecar bool) (string, error) {
+	data, err := os.ReadFile(sumsPath)
+	if err != nil {
+		return "", err
+	}
+	if fields := strings.Fields(string(data)); len(fields) == 1 {
+		return strings.ToLower(fields[0]), nil
+	}
+	sums, err := manifest.ReadSums(strings.NewReader(string(data)))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", sumsPath, err)
+	}
+	for _, s := range sums {
+		if s.Path == name || filepath.Base(filepath.FromSlash(s.Path)) == name {
+			return s.Hash, nil
+		}
+	}
+	if len(sums) == 1 && sidecar {
+		return sums[0].Hash, nil
+	}
+	return "", fmt.Errorf("%s does not list %s", sumsPath, name)
+}
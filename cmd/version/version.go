@@ -0,0 +1,39 @@
+package version
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/easzlab/ezft/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// version subcommand related variables
+var versionJSON bool
+
+func init() {
+	// version subcommand parameters
+	VersionCmd.Flags().BoolVar(&versionJSON, "json", false, "Print the build metadata as JSON")
+}
+
+var VersionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "EZFT Version - Show version and build information",
+	Long: `EZFT version prints the version of ezft, the commit and branch it was built
+from, the build time, the Go version and the platform, like ezft --version.
+
+  ezft version
+  ezft version --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		info := config.GetBuildInfo()
+		if versionJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(info)
+		}
+		fmt.Print(info)
+		return nil
+	},
+}
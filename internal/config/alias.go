@@ -0,0 +1,18 @@
+package config
+
+import "github.com/spf13/pflag"
+
+// flagAliases names flags are also known by: --limit-rate of curl and wget
+// is --bwlimit
+var flagAliases = map[string]string{
+	"limit-rate": "bwlimit",
+}
+
+// NormalizeAliases is a normalize function of flag sets accepting the aliases
+// of flags, on the command line and in configuration files
+func NormalizeAliases(fs *pflag.FlagSet, name string) pflag.NormalizedName {
+	if target, ok := flagAliases[name]; ok {
+		name = target
+	}
+	return pflag.NormalizedName(name)
+}
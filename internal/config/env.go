@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// EnvPrefix prefix of the environment variables setting options
+const EnvPrefix = "EZFT_"
+
+// EnvName returns the environment variable setting the flag name of the
+// command section, such as EZFT_CLIENT_LOG_HOME, or the one shared by all
+// commands if section is empty, such as EZFT_LOG_HOME
+func EnvName(section, name string) string {
+	if section != "" {
+		name = section + "_" + name
+	}
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// ApplyEnv sets the flags of fs not given on the command line from the
+// environment, the variable of the command section taking precedence over
+// the shared one. Empty variables are ignored.
+func ApplyEnv(fs *pflag.FlagSet, section string) error {
+	var err error
+	fs.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "help" {
+			return
+		}
+		for _, name := range []string{EnvName(section, flag.Name), EnvName("", flag.Name)} {
+			value := os.Getenv(name)
+			if value == "" {
+				continue
+			}
+			if setErr := flag.Value.Set(value); setErr != nil {
+				err = fmt.Errorf("%s: %w", name, setErr)
+				return
+			}
+			// Counts as given, the configuration file does not override it
+			flag.Changed = true
+			return
+		}
+	})
+	return err
+}
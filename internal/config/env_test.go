@@ -0,0 +1,54 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("client", "p2p-seed-time"); got != "EZFT_CLIENT_P2P_SEED_TIME" {
+		t.Errorf("EnvName() = %q", got)
+	}
+	if got := EnvName("", "log-home"); got != "EZFT_LOG_HOME" {
+		t.Errorf("EnvName() = %q", got)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("EZFT_URL", "http://example.com/shared.img")
+	t.Setenv("EZFT_CLIENT_URL", "http://example.com/client.img")
+	t.Setenv("EZFT_CONCURRENCY", "8")
+	t.Setenv("EZFT_RESUME", "false")
+	t.Setenv("EZFT_P2P_SEED_TIME", "")
+
+	fs := newFlagSet()
+	if err := fs.Parse([]string{"--concurrency", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyEnv(fs, "client"); err != nil {
+		t.Fatalf("ApplyEnv() error = %v", err)
+	}
+	url, _ := fs.GetString("url")
+	concurrency, _ := fs.GetInt("concurrency")
+	resume, _ := fs.GetBool("resume")
+	if url != "http://example.com/client.img" || concurrency != 2 || resume || fs.Lookup("p2p-seed-time").Changed {
+		t.Errorf("flags = %q %d %v", url, concurrency, resume)
+	}
+
+	// The environment takes precedence over the configuration file
+	path := writeConfig(t, "client:\n  url: http://example.com/file.img\n  retry: 5\n")
+	fs.Int("retry", 3, "")
+	if _, err := applyFile(fs, "client", path); err != nil {
+		t.Fatal(err)
+	}
+	url, _ = fs.GetString("url")
+	retry, _ := fs.GetInt("retry")
+	if url != "http://example.com/client.img" || retry != 5 {
+		t.Errorf("url = %q, retry = %d", url, retry)
+	}
+
+	t.Setenv("EZFT_CONCURRENCY", "many")
+	if err := ApplyEnv(newFlagSet(), "client"); err == nil || !strings.Contains(err.Error(), "EZFT_CONCURRENCY") {
+		t.Errorf("ApplyEnv() error = %v, want one naming the variable", err)
+	}
+}
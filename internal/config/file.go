@@ -0,0 +1,264 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// FileName name of the configuration file in the search paths
+	FileName = "ezft.yaml"
+	// TOMLFileName name of the configuration file in TOML, looked for after
+	// FileName in each search path
+	TOMLFileName = "ezft.toml"
+)
+
+// profilesKey key of the client profiles in the configuration file, all
+// other keys are sections of commands
+const profilesKey = "profiles"
+
+// SearchPaths returns the configuration files looked for when none is given,
+// in order: the working directory, the user configuration directory and /etc
+func SearchPaths() []string {
+	paths := []string{FileName, TOMLFileName}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "ezft", FileName), filepath.Join(dir, "ezft", TOMLFileName))
+	}
+	if runtime.GOOS != "windows" {
+		paths = append(paths, filepath.Join("/etc/ezft", FileName), filepath.Join("/etc/ezft", TOMLFileName))
+	}
+	return paths
+}
+
+// File a configuration file: the defaults of the options of each command,
+// keyed by the long names of the flags, and named profiles of the client
+type File struct {
+	Path     string
+	Sections map[string]map[string]any // Options by command
+	Profiles map[string]map[string]any // Client options by profile name
+
+	positions map[string]position // Of the options keyed by section.name, profiles.profile.name
+}
+
+// position line and column of a key in the file
+type position struct {
+	line, column int
+}
+
+// Error an invalid option of the file, located at its key
+type Error struct {
+	Path   string
+	Line   int
+	Column int
+	Key    string // section.name, profiles.profile.name
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s: %v", e.Path, e.Key, e.Err)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %v", e.Path, e.Line, e.Column, e.Key, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// FindFile loads the configuration file at path, or the first file of
+// SearchPaths if path is empty. It returns nil if none was found.
+func FindFile(path string) (*File, error) {
+	if path == "" {
+		for _, p := range SearchPaths() {
+			if _, err := os.Stat(p); err == nil {
+				return LoadFile(p)
+			}
+		}
+		return nil, nil
+	}
+	return LoadFile(path)
+}
+
+// LoadFile loads the configuration file at path, in TOML if its extension is
+// .toml and in YAML otherwise
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	f := &File{Path: path, Profiles: make(map[string]map[string]any), positions: make(map[string]position)}
+	var sections map[string]map[string]any
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		doc, err := decodeTOML(data, f.positions, 3)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+		}
+		sections = make(map[string]map[string]any, len(doc))
+		for name, options := range doc {
+			m, ok := options.(map[string]any)
+			if !ok {
+				return nil, f.errorAt(name, errors.New("a section must map options to values"))
+			}
+			sections[name] = m
+		}
+	} else {
+		var root yaml.Node
+		if err := yaml.Unmarshal(data, &root); err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+		}
+		if err := root.Decode(&sections); err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+		}
+		if len(root.Content) > 0 {
+			recordPositions(f.positions, "", root.Content[0], 3)
+		}
+	}
+	f.Sections = sections
+	if profiles, ok := sections[profilesKey]; ok {
+		delete(f.Sections, profilesKey)
+		for name, options := range profiles {
+			m, ok := options.(map[string]any)
+			if !ok {
+				return nil, f.errorAt(profilesKey+"."+name, errors.New("a profile must map options to values"))
+			}
+			f.Profiles[name] = m
+		}
+	}
+	return f, nil
+}
+
+// recordPositions records the positions of the keys of the mapping node down
+// to depth levels, keyed by their path joined with dots
+func recordPositions(positions map[string]position, prefix string, node *yaml.Node, depth int) {
+	if node.Kind != yaml.MappingNode || depth == 0 {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		positions[path] = position{key.Line, key.Column}
+		recordPositions(positions, path, node.Content[i+1], depth-1)
+	}
+}
+
+// errorAt returns err located at the key of the file
+func (f *File) errorAt(key string, err error) *Error {
+	pos := f.positions[key]
+	return &Error{Path: f.Path, Line: pos.line, Column: pos.column, Key: key, Err: err}
+}
+
+// Apply sets the flags of fs not given otherwise from the section of the
+// file, nothing if f is nil
+func (f *File) Apply(fs *pflag.FlagSet, section string) error {
+	if f == nil {
+		return nil
+	}
+	return f.apply(fs, section, f.Sections[section])
+}
+
+// Reload sets the flags names of fs again from the section of the file, for
+// a command applying its configuration file again while it runs. The flags
+// in given, set on the command line or in the environment, are kept, and
+// those missing from the file get their default back, also when f is nil as
+// the file is gone. Only flags of a single value can be reloaded.
+func (f *File) Reload(fs *pflag.FlagSet, section string, names []string, given map[string]bool) error {
+	var options map[string]any
+	if f != nil {
+		options = f.Sections[section]
+	}
+	for _, name := range names {
+		flag := fs.Lookup(name)
+		if flag == nil {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		if given[name] {
+			continue
+		}
+		if err := flag.Value.Set(flag.DefValue); err != nil {
+			return fmt.Errorf("failed to reset option %q: %w", name, err)
+		}
+		flag.Changed = false
+		if value, ok := options[name]; ok {
+			if err := setFlag(fs, name, value); err != nil {
+				return f.errorAt(section+"."+name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyProfile sets the flags of fs not given otherwise from the profile name
+func (f *File) ApplyProfile(fs *pflag.FlagSet, name string) error {
+	if f == nil {
+		return fmt.Errorf("unknown profile %q, no configuration file found", name)
+	}
+	options, ok := f.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, profiles of %s: %v", name, f.Path, f.ProfileNames())
+	}
+	return f.apply(fs, profilesKey+"."+name, options)
+}
+
+// ProfileNames returns the names of the profiles, sorted
+func (f *File) ProfileNames() []string {
+	if f == nil {
+		return nil
+	}
+	return sortedKeys(f.Profiles)
+}
+
+func (f *File) apply(fs *pflag.FlagSet, section string, options map[string]any) error {
+	for _, name := range sortedKeys(options) {
+		if err := setFlag(fs, name, options[name]); err != nil {
+			return f.errorAt(section+"."+name, err)
+		}
+	}
+	return nil
+}
+
+// setFlag sets the flag name to value unless given otherwise
+func setFlag(fs *pflag.FlagSet, name string, value any) error {
+	flag := fs.Lookup(name)
+	if flag == nil || name == "config" || name == "profile" || name == "help" {
+		return errors.New("unknown option")
+	}
+	if flag.Changed {
+		return nil
+	}
+
+	var err error
+	switch v := value.(type) {
+	case nil:
+		return errors.New("missing value")
+	case map[string]any:
+		return errors.New("a single value or list expected")
+	case []any:
+		slice, ok := flag.Value.(pflag.SliceValue)
+		if !ok {
+			return errors.New("a single value expected")
+		}
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = fmt.Sprint(item)
+		}
+		err = slice.Replace(values)
+	default:
+		err = flag.Value.Set(fmt.Sprint(v))
+	}
+	if err != nil {
+		return err
+	}
+	// Counts as given, required flags are satisfied by the file
+	flag.Changed = true
+	return nil
+}
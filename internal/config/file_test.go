@@ -0,0 +1,312 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func newFlagSet() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("client", pflag.ContinueOnError)
+	fs.StringP("url", "u", "", "")
+	fs.IntP("concurrency", "c", 1, "")
+	fs.Bool("resume", true, "")
+	fs.Duration("p2p-seed-time", 0, "")
+	fs.StringArray("include", nil, "")
+	return fs
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ezft.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// applyFile applies the section of the configuration file found for path
+func applyFile(fs *pflag.FlagSet, section, path string) (string, error) {
+	f, err := FindFile(path)
+	if err != nil || f == nil {
+		return "", err
+	}
+	return f.Path, f.Apply(fs, section)
+}
+
+func TestApplyFile(t *testing.T) {
+	path := writeConfig(t, `
+client:
+  url: http://example.com/os.img
+  concurrency: 8
+  resume: false
+  p2p-seed-time: 10m
+  include: ["*.img", "*.iso"]
+server:
+  port: 9090
+`)
+	fs := newFlagSet()
+	// Flags given on the command line take precedence over the file
+	if err := fs.Parse([]string{"-c", "4"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := applyFile(fs, "client", path); err != nil || got != path {
+		t.Fatalf("FindFile() = %q, %v", got, err)
+	}
+
+	url, _ := fs.GetString("url")
+	concurrency, _ := fs.GetInt("concurrency")
+	resume, _ := fs.GetBool("resume")
+	seed, _ := fs.GetDuration("p2p-seed-time")
+	include, _ := fs.GetStringArray("include")
+	if url != "http://example.com/os.img" || concurrency != 4 || resume || seed != 10*time.Minute || strings.Join(include, ",") != "*.img,*.iso" {
+		t.Errorf("flags = %q %d %v %s %v", url, concurrency, resume, seed, include)
+	}
+	if !fs.Lookup("url").Changed {
+		t.Error("Options of the file must count as given")
+	}
+}
+
+func TestApplyTOMLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ezft.toml")
+	if err := os.WriteFile(path, []byte(`
+[client]
+url = "http://example.com/os.img"
+concurrency = 8
+resume = false
+p2p-seed-time = "10m"
+include = ["*.img", "*.iso"]
+
+[profiles.mirror-eu]
+url = "http://eu.example.com/os.img"
+
+[server]
+port = 9090
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if names := f.ProfileNames(); strings.Join(names, ",") != "mirror-eu" {
+		t.Errorf("ProfileNames() = %v", names)
+	}
+
+	fs := newFlagSet()
+	if err := f.Apply(fs, "client"); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	url, _ := fs.GetString("url")
+	concurrency, _ := fs.GetInt("concurrency")
+	resume, _ := fs.GetBool("resume")
+	seed, _ := fs.GetDuration("p2p-seed-time")
+	include, _ := fs.GetStringArray("include")
+	if url != "http://example.com/os.img" || concurrency != 8 || resume || seed != 10*time.Minute || strings.Join(include, ",") != "*.img,*.iso" {
+		t.Errorf("flags = %q %d %v %s %v", url, concurrency, resume, seed, include)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown option", "[client]\nurl = \"x\"\n  colour = \"red\"\n", "ezft.toml:3:3: client.colour: unknown option"},
+		{"dotted key", "client.colour = \"red\"\n", "ezft.toml:1:8: client.colour: unknown option"},
+		{"inline table", "client = { url = \"x\", colour = \"red\" }\n", "ezft.toml:1:23: client.colour: unknown option"},
+		{"not a section", "concurrency = 4\n", "ezft.toml:1:1: concurrency: a section must map options to values"},
+		{"invalid toml", "[client\n", "invalid configuration file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := applyFile(newFlagSet(), "client", path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Apply() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloadFile(t *testing.T) {
+	path := writeConfig(t, `
+client:
+  url: http://example.com/os.img
+  concurrency: 8
+  p2p-seed-time: 10m
+`)
+	fs := newFlagSet()
+	if err := fs.Parse([]string{"-c", "4"}); err != nil {
+		t.Fatal(err)
+	}
+	given := map[string]bool{"concurrency": true}
+	if _, err := applyFile(fs, "client", path); err != nil {
+		t.Fatal(err)
+	}
+
+	// The URL changed and the seed time was removed
+	if err := os.WriteFile(path, []byte("client:\n  url: http://example.com/new.img\n  concurrency: 16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"url", "concurrency", "p2p-seed-time"}
+	if err := f.Reload(fs, "client", names, given); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	url, _ := fs.GetString("url")
+	concurrency, _ := fs.GetInt("concurrency")
+	seed, _ := fs.GetDuration("p2p-seed-time")
+	if url != "http://example.com/new.img" || concurrency != 4 || seed != 0 {
+		t.Errorf("flags = %q %d %s", url, concurrency, seed)
+	}
+
+	// Without the file every option not given is back to its default
+	var none *File
+	if err := none.Reload(fs, "client", names, given); err != nil {
+		t.Fatalf("Reload() without file error = %v", err)
+	}
+	if url, _ := fs.GetString("url"); url != "" {
+		t.Errorf("url = %q, want the default", url)
+	}
+	if err := none.Reload(fs, "client", []string{"missing"}, given); err == nil {
+		t.Error("Reload() of an unknown option succeeded")
+	}
+}
+
+func TestApplyFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown option", "client:\n  colour: red\n", "ezft.yaml:2:3: client.colour: unknown option"},
+		{"invalid value", "client:\n  concurrency: many\n", "client.concurrency"},
+		{"list for a single value", "client:\n  url: [a, b]\n", "a single value expected"},
+		{"invalid yaml", "client: [", "invalid configuration file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := applyFile(newFlagSet(), "client", writeConfig(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Apply() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := applyFile(newFlagSet(), "client", filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("FindFile() must fail for a missing file given explicitly")
+	}
+}
+
+func TestApplyFileSearch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	fs := newFlagSet()
+	if got, err := applyFile(fs, "client", ""); err != nil || (got != "" && got != filepath.Join("/etc/ezft", FileName)) {
+		t.Fatalf("FindFile() = %q, %v without a file in the working directory", got, err)
+	}
+
+	if err := os.WriteFile(FileName, []byte("client:\n  concurrency: 6\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs = newFlagSet()
+	if got, err := applyFile(fs, "client", ""); err != nil || got != FileName {
+		t.Fatalf("FindFile() = %q, %v", got, err)
+	}
+	if concurrency, _ := fs.GetInt("concurrency"); concurrency != 6 {
+		t.Errorf("concurrency = %d, want 6", concurrency)
+	}
+
+	// The TOML file is used without a YAML one in the same directory
+	if err := os.Remove(FileName); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(TOMLFileName, []byte("[client]\nconcurrency = 7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs = newFlagSet()
+	if got, err := applyFile(fs, "client", ""); err != nil || got != TOMLFileName {
+		t.Fatalf("FindFile() = %q, %v", got, err)
+	}
+	if concurrency, _ := fs.GetInt("concurrency"); concurrency != 7 {
+		t.Errorf("concurrency = %d, want 7", concurrency)
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	f, err := LoadFile(writeConfig(t, `
+client:
+  concurrency: 2
+  url: http://example.com/default.img
+profiles:
+  mirror-eu:
+    url: http://eu.example.com/os.img
+    concurrency: 8
+  mirror-us:
+    url: http://us.example.com/os.img
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := f.ProfileNames(); strings.Join(names, ",") != "mirror-eu,mirror-us" {
+		t.Errorf("ProfileNames() = %v", names)
+	}
+	if _, ok := f.Sections["profiles"]; ok {
+		t.Error("Profiles must not be a section")
+	}
+
+	// The profile takes precedence over the section of the command
+	fs := newFlagSet()
+	if err := f.ApplyProfile(fs, "mirror-eu"); err != nil {
+		t.Fatalf("ApplyProfile() error = %v", err)
+	}
+	if err := f.Apply(fs, "client"); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	url, _ := fs.GetString("url")
+	concurrency, _ := fs.GetInt("concurrency")
+	if url != "http://eu.example.com/os.img" || concurrency != 8 {
+		t.Errorf("url = %q, concurrency = %d", url, concurrency)
+	}
+
+	if err := f.ApplyProfile(newFlagSet(), "mirror-asia"); err == nil || !strings.Contains(err.Error(), "mirror-eu") {
+		t.Errorf("ApplyProfile() error = %v, want one listing the profiles", err)
+	}
+	var none *File
+	if err := none.ApplyProfile(newFlagSet(), "mirror-eu"); err == nil {
+		t.Error("ApplyProfile() must fail without a configuration file")
+	}
+}
+
+func TestNormalizeAliases(t *testing.T) {
+	fs := newFlagSet()
+	fs.String("bwlimit", "", "")
+	fs.SetNormalizeFunc(NormalizeAliases)
+	if err := fs.Parse([]string{"--limit-rate", "500k"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fs.GetString("bwlimit"); got != "500k" {
+		t.Errorf("bwlimit = %q", got)
+	}
+
+	fs = newFlagSet()
+	fs.String("bwlimit", "", "")
+	fs.SetNormalizeFunc(NormalizeAliases)
+	path := writeConfig(t, "client:\n  limit-rate: 1.5G\n")
+	if _, err := applyFile(fs, "client", path); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fs.GetString("bwlimit"); got != "1.5G" {
+		t.Errorf("bwlimit = %q from the configuration file", got)
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxRecentURLs bounds the URLs kept for completion
+const maxRecentURLs = 100
+
+// CachePath returns the path of the file name in the ezft directory of the
+// user cache directory
+func CachePath(name string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ezft", name), nil
+}
+
+// recentURLsPath returns the file keeping the recently downloaded URLs
+func recentURLsPath() (string, error) {
+	return CachePath("recent-urls")
+}
+
+// RecentURLs returns the recently downloaded URLs, the most recent first
+func RecentURLs() []string {
+	path, err := recentURLsPath()
+	if err != nil {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var urls []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			urls = append(urls, line)
+		}
+	}
+	return urls
+}
+
+// RecordURL adds the URL u to the recently downloaded URLs, without its
+// credentials
+func RecordURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	parsed.User = nil
+	u = parsed.String()
+
+	urls := []string{u}
+	for _, recent := range RecentURLs() {
+		if recent != u && len(urls) < maxRecentURLs {
+			urls = append(urls, recent)
+		}
+	}
+	path, err := recentURLsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(urls, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"runtime"
+	"strings"
+)
+
+// DefaultServerPort port of the ezft servers of host:path arguments and
+// ezft:// URLs without an explicit port
+const DefaultServerPort = "8080"
+
+// Remote a file or directory of an ezft server named by a copy argument
+type Remote struct {
+	URL  string // http or https URL, ending with a slash for directories
+	User string // Basic auth credentials username:password of the profile, if any
+}
+
+// IsRemote reports whether arg names a remote file: a URL, or host:path as
+// with scp when the colon comes before any slash. Windows drive letters are
+// local paths.
+func IsRemote(arg string) bool {
+	for _, scheme := range []string{"http://", "https://", "ezft://", "ezfts://"} {
+		if strings.HasPrefix(arg, scheme) {
+			return true
+		}
+	}
+	host, _, ok := cutHost(arg)
+	if !ok || strings.ContainsAny(host, `/\`) {
+		return false
+	}
+	return len(host) > 1 || runtime.GOOS != "windows"
+}
+
+// cutHost splits host:path, the host of an IPv6 address in brackets
+func cutHost(arg string) (string, string, bool) {
+	if strings.HasPrefix(arg, "[") {
+		if i := strings.Index(arg, "]:"); i > 0 {
+			return arg[1:i], arg[i+2:], true
+		}
+		return "", "", false
+	}
+	host, p, ok := strings.Cut(arg, ":")
+	return host, p, ok && host != ""
+}
+
+// ResolveRemote resolves the remote argument arg. In name:path, name is a
+// profile of f whose base-url path is relative to, or else the host of an
+// ezft server on the default port, another one given as host:port:path.
+// Absolute paths start at the root of the server either way.
+func (f *File) ResolveRemote(arg string) (*Remote, error) {
+	var base, ref string
+	r := &Remote{}
+	if u, err := url.Parse(arg); err == nil && strings.Contains(arg, "://") {
+		switch u.Scheme {
+		case "http", "https":
+		case "ezft", "ezfts":
+			// ezft://host/path is http://host:8080/path, ezfts:// the same over https
+			u.Scheme = strings.Replace(u.Scheme, "ezft", "http", 1)
+			if u.Port() == "" {
+				u.Host = net.JoinHostPort(u.Hostname(), DefaultServerPort)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported URL scheme: %s", u.Scheme)
+		}
+		r.URL = u.String()
+		return r, nil
+	}
+
+	name, p, ok := cutHost(arg)
+	if !ok {
+		return nil, fmt.Errorf("invalid remote %q, expected host:path or a URL", arg)
+	}
+	var profile map[string]any
+	if f != nil {
+		profile = f.Profiles[name]
+	}
+	if profile != nil {
+		baseURL, ok := profile["base-url"]
+		if !ok {
+			return nil, fmt.Errorf("profile %q of %s has no base-url", name, f.Path)
+		}
+		base = fmt.Sprint(baseURL)
+		if user, ok := profile["user"]; ok {
+			r.User = fmt.Sprint(user)
+		}
+	} else {
+		// host:port:path names a server on another port
+		port := DefaultServerPort
+		if digits, rest, ok := strings.Cut(p, ":"); ok && digits != "" && strings.Trim(digits, "0123456789") == "" {
+			port, p = digits, rest
+		}
+		base = "http://" + net.JoinHostPort(name, port) + "/"
+	}
+
+	b, err := url.Parse(base)
+	if err != nil || b.Scheme == "" || b.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", base)
+	}
+	// Paths are not URL encoded, and are relative to the base URL as a directory
+	if !strings.HasSuffix(b.Path, "/") {
+		b.Path += "/"
+	}
+	ref = p
+	if !strings.HasPrefix(ref, "/") {
+		ref = strings.TrimPrefix(ref, "./")
+	}
+	r.URL = b.ResolveReference(&url.URL{Path: ref}).String()
+	return r, nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestIsRemote(t *testing.T) {
+	tests := map[string]bool{
+		"host:/srv/os.img":           true,
+		"mirror:os.img":              true,
+		"[::1]:/os.img":              true,
+		"ezft://host/os.img":         true,
+		"http://host:8080/os.img":    true,
+		"./os.img":                   false,
+		"os.img":                     false,
+		"dir/file:with:colons":       false,
+		"/data/file:name":            false,
+		"C:" + `\data\os.img`:        runtime.GOOS != "windows",
+		"[::1]/os.img":               false,
+		":os.img":                    false,
+		"backup-2024-01-01T10:00:00": true,
+	}
+	for arg, want := range tests {
+		if got := IsRemote(arg); got != want {
+			t.Errorf("IsRemote(%q) = %v, want %v", arg, got, want)
+		}
+	}
+}
+
+func TestResolveRemote(t *testing.T) {
+	f, err := LoadFile(writeConfig(t, `
+profiles:
+  mirror:
+    base-url: http://mirror.example.com:9000/releases/v2
+    user: alice:secret
+  nobase:
+    concurrency: 4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		arg, url, user string
+	}{
+		{"mirror:os.img", "http://mirror.example.com:9000/releases/v2/os.img", "alice:secret"},
+		{"mirror:./iso/", "http://mirror.example.com:9000/releases/v2/iso/", "alice:secret"},
+		{"mirror:/other/os.img", "http://mirror.example.com:9000/other/os.img", "alice:secret"},
+		{"files.example.com:/srv/os image.img", "http://files.example.com:8080/srv/os%20image.img", ""},
+		{"[::1]:os.img", "http://[::1]:8080/os.img", ""},
+		{"files.example.com:9090:/srv/os.img", "http://files.example.com:9090/srv/os.img", ""},
+		{"mirror:2024:notes.txt", "http://mirror.example.com:9000/releases/v2/2024:notes.txt", "alice:secret"},
+		{"ezfts://files.example.com/os.img", "https://files.example.com:8080/os.img", ""},
+		{"http://files.example.com/os.img", "http://files.example.com/os.img", ""},
+	}
+	for _, tt := range tests {
+		r, err := f.ResolveRemote(tt.arg)
+		if err != nil || r.URL != tt.url || r.User != tt.user {
+			t.Errorf("ResolveRemote(%q) = %+v, %v, want %s", tt.arg, r, err, tt.url)
+		}
+	}
+	for _, arg := range []string{"nobase:os.img", "ftp://host/os.img"} {
+		if _, err := f.ResolveRemote(arg); err == nil {
+			t.Errorf("ResolveRemote(%q) must fail", arg)
+		}
+	}
+
+	// Without configuration file, names are hosts
+	var none *File
+	if r, err := none.ResolveRemote("mirror:os.img"); err != nil || r.URL != "http://mirror:8080/os.img" {
+		t.Errorf("ResolveRemote() = %+v, %v", r, err)
+	}
+}
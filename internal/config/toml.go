@@ -0,0 +1,62 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+)
+
+// decodeTOML decodes a configuration file in TOML, recording the positions of
+// its keys down to depth levels like recordPositions
+func decodeTOML(data []byte, positions map[string]position, depth int) (map[string]any, error) {
+	var doc map[string]any
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	p := unstable.Parser{}
+	p.Reset(data)
+	var table []string
+	for p.NextExpression() {
+		e := p.Expression()
+		switch e.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			table = recordTOMLKey(&p, positions, nil, e.Key(), depth)
+		case unstable.KeyValue:
+			recordTOMLKeyValue(&p, positions, table, e, depth)
+		}
+	}
+	return doc, p.Error()
+}
+
+// recordTOMLKeyValue records the positions of the key of a key-value and of
+// the keys of its value if it is an inline table
+func recordTOMLKeyValue(p *unstable.Parser, positions map[string]position, prefix []string, kv *unstable.Node, depth int) {
+	key := recordTOMLKey(p, positions, prefix, kv.Key(), depth)
+	if value := kv.Value(); value.Kind == unstable.InlineTable {
+		it := value.Children()
+		for it.Next() {
+			recordTOMLKeyValue(p, positions, key, it.Node(), depth)
+		}
+	}
+}
+
+// recordTOMLKey records the position of every part of a dotted key following
+// prefix, and returns the whole key
+func recordTOMLKey(p *unstable.Parser, positions map[string]position, prefix []string, it unstable.Iterator, depth int) []string {
+	key := append([]string(nil), prefix...)
+	for it.Next() {
+		part := it.Node()
+		key = append(key, string(part.Data))
+		if len(key) > depth {
+			continue
+		}
+		path := strings.Join(key, ".")
+		if _, ok := positions[path]; !ok {
+			start := p.Shape(part.Raw).Start
+			positions[path] = position{start.Line, start.Column}
+		}
+	}
+	return key
+}
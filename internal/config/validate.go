@@ -0,0 +1,138 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/pflag"
+)
+
+// CheckFunc checks the value an option was set to beyond its type, given the
+// long name of its flag
+type CheckFunc func(name, value string) error
+
+// Validate checks every option of the sections and profiles of f, returning
+// all the invalid ones located in the file. flags returns new flags of the
+// command reading a section, nil if none does, profiles being checked against
+// those of the client section. check may be nil.
+func (f *File) Validate(flags func(section string) *pflag.FlagSet, check CheckFunc) []error {
+	var errs []error
+	validate := func(fs *pflag.FlagSet, section string, options map[string]any) {
+		for _, name := range sortedKeys(options) {
+			if err := setFlag(fs, name, options[name]); err != nil {
+				errs = append(errs, f.errorAt(section+"."+name, err))
+				continue
+			}
+			if check == nil {
+				continue
+			}
+			flag := fs.Lookup(name)
+			if err := check(flag.Name, flag.Value.String()); err != nil {
+				errs = append(errs, f.errorAt(section+"."+name, err))
+			}
+		}
+	}
+	for _, section := range sortedKeys(f.Sections) {
+		fs := flags(section)
+		if fs == nil {
+			errs = append(errs, f.errorAt(section, errors.New("unknown section")))
+			continue
+		}
+		validate(fs, section, f.Sections[section])
+	}
+	for _, name := range f.ProfileNames() {
+		if fs := flags("client"); fs != nil {
+			validate(fs, profilesKey+"."+name, f.Profiles[name])
+		}
+	}
+	return errs
+}
+
+// Setting the value of an option of a command and where it comes from
+type Setting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"` // "default", "command line", the environment variable or the location in the file
+}
+
+// Effective sets the flags of fs not given on the command line from the
+// environment, the profile if not empty and the section of f, in the order
+// the commands do, and returns the settings of all the flags sorted by name
+func Effective(fs *pflag.FlagSet, section string, f *File, profile string) ([]Setting, error) {
+	sources := make(map[string]string)
+	fs.Visit(func(flag *pflag.Flag) {
+		sources[flag.Name] = "command line"
+	})
+	if err := ApplyEnv(fs, section); err != nil {
+		return nil, err
+	}
+	// ApplyEnv marks the flags it sets as changed, Visit does not see them
+	fs.VisitAll(func(flag *pflag.Flag) {
+		if _, ok := sources[flag.Name]; ok || !flag.Changed {
+			return
+		}
+		for _, name := range []string{EnvName(section, flag.Name), EnvName("", flag.Name)} {
+			if os.Getenv(name) != "" {
+				sources[flag.Name] = "$" + name
+				return
+			}
+		}
+	})
+	if profile != "" {
+		if err := f.ApplyProfile(fs, profile); err != nil {
+			return nil, err
+		}
+		f.locate(fs, sources, profilesKey+"."+profile, f.Profiles[profile])
+	}
+	if err := f.Apply(fs, section); err != nil {
+		return nil, err
+	}
+	if f != nil {
+		f.locate(fs, sources, section, f.Sections[section])
+	}
+
+	var settings []Setting
+	fs.VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "help" {
+			return
+		}
+		source, ok := sources[flag.Name]
+		if !ok {
+			source = "default"
+		}
+		settings = append(settings, Setting{Name: flag.Name, Value: flag.Value.String(), Source: source})
+	})
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Name < settings[j].Name })
+	return settings, nil
+}
+
+// locate records the location of the options of section as the sources of
+// the flags they set
+func (f *File) locate(fs *pflag.FlagSet, sources map[string]string, section string, options map[string]any) {
+	for name := range options {
+		flag := fs.Lookup(name)
+		if flag == nil || !flag.Changed {
+			continue
+		}
+		if _, ok := sources[flag.Name]; ok {
+			continue
+		}
+		key := section + "." + name
+		if pos, ok := f.positions[key]; ok {
+			sources[flag.Name] = fmt.Sprintf("%s:%d (%s)", f.Path, pos.line, key)
+		} else {
+			sources[flag.Name] = f.Path + " (" + key + ")"
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
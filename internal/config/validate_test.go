@@ -0,0 +1,105 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestValidate(t *testing.T) {
+	f, err := LoadFile(writeConfig(t, `
+client:
+  concurrency: many
+  url: http://example.com/os.img
+  colour: red
+server:
+  port: 8080
+profiles:
+  mirror:
+    limit-rate: fast
+    include: ["*.img"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := func(section string) *pflag.FlagSet {
+		if section != "client" {
+			return nil
+		}
+		fs := newFlagSet()
+		fs.String("bwlimit", "", "")
+		fs.SetNormalizeFunc(NormalizeAliases)
+		return fs
+	}
+	check := func(name, value string) error {
+		if name == "bwlimit" && value == "fast" {
+			return errors.New("invalid rate")
+		}
+		return nil
+	}
+	errs := f.Validate(flags, check)
+	want := []string{
+		":5:3: client.colour: unknown option",
+		":3:3: client.concurrency: ",
+		":6:1: server: unknown section",
+		":10:5: profiles.mirror.limit-rate: invalid rate",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Validate() = %v, want %d errors", errs, len(want))
+	}
+	for i, err := range errs {
+		var fileErr *Error
+		if !errors.As(err, &fileErr) || !strings.Contains(err.Error(), want[i]) {
+			t.Errorf("Validate() error %d = %v, want %q", i, err, want[i])
+		}
+	}
+
+	f, _ = LoadFile(writeConfig(t, "client:\n  concurrency: 4\n"))
+	if errs := f.Validate(flags, check); len(errs) != 0 {
+		t.Errorf("Validate() = %v for a valid file", errs)
+	}
+}
+
+func TestEffective(t *testing.T) {
+	t.Setenv("EZFT_RESUME", "false")
+	f, err := LoadFile(writeConfig(t, `
+client:
+  concurrency: 2
+  url: http://example.com/section.img
+profiles:
+  mirror:
+    url: http://mirror.example.com/os.img
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := newFlagSet()
+	if err := fs.Parse([]string{"--p2p-seed-time", "1m"}); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := Effective(fs, "client", f, "mirror")
+	if err != nil {
+		t.Fatalf("Effective() error = %v", err)
+	}
+	var got []string
+	for _, s := range settings {
+		got = append(got, fmt.Sprintf("%s=%s from %s", s.Name, s.Value, s.Source))
+	}
+	want := []string{
+		"concurrency=2 from " + f.Path + ":3 (client.concurrency)",
+		"include=[] from default",
+		"p2p-seed-time=1m0s from command line",
+		"resume=false from $EZFT_RESUME",
+		"url=http://mirror.example.com/os.img from " + f.Path + ":7 (profiles.mirror.url)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Effective() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := Effective(newFlagSet(), "client", nil, "mirror"); err == nil {
+		t.Error("Effective() must fail for a profile without configuration file")
+	}
+}
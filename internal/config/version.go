@@ -1,10 +1,14 @@
 package config
 
 import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
 )
 
+// Build metadata, set with -ldflags "-X" by make build
 var (
 	BuildTime   string
 	BuildCommit string
@@ -12,7 +16,68 @@ var (
 	Version     = "0.5.0"
 )
 
+// BuildInfo build metadata of the binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Branch    string `json:"branch"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// GetBuildInfo returns the build metadata. Without ldflags the commit and
+// time come from the version control information embedded by go build,
+// unknown fields are "unknown".
+func GetBuildInfo() *BuildInfo {
+	info := &BuildInfo{
+		Version:   Version,
+		Commit:    BuildCommit,
+		Branch:    BuildBranch,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		var modified bool
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value[:min(len(setting.Value), 7)]
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && BuildCommit == "" && info.Commit != "" {
+			info.Commit += "-dirty"
+		}
+	}
+	for _, field := range []*string{&info.Commit, &info.Branch, &info.BuildTime} {
+		if *field == "" {
+			*field = "unknown"
+		}
+	}
+	return info
+}
+
+// String formats the build metadata as printed by ezft --version
+func (i *BuildInfo) String() string {
+	return fmt.Sprintf("Version: %s\nBuild commit: %s\nBuild branch: %s\nBuild time: %s\nGo version: %s\nPlatform: %s\n",
+		i.Version, i.Commit, i.Branch, i.BuildTime, i.GoVersion, i.Platform)
+}
+
+// FullVersion returns the version followed by the commit it was built from,
+// as in 0.5.0+1c0eae6, or the version alone if the commit is unknown
 func FullVersion() string {
+	if commit := GetBuildInfo().Commit; commit != "unknown" {
+		return Version + "+" + commit
+	}
 	return Version
 }
 
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGetBuildInfo(t *testing.T) {
+	defer func(commit, branch, time string) {
+		BuildCommit, BuildBranch, BuildTime = commit, branch, time
+	}(BuildCommit, BuildBranch, BuildTime)
+
+	BuildCommit, BuildBranch, BuildTime = "1c0eae6", "master", "2025-01-02T03:04:05Z"
+	info := GetBuildInfo()
+	want := BuildInfo{
+		Version:   Version,
+		Commit:    "1c0eae6",
+		Branch:    "master",
+		BuildTime: "2025-01-02T03:04:05Z",
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if *info != want {
+		t.Errorf("GetBuildInfo() = %+v, want %+v", info, want)
+	}
+	if got := FullVersion(); got != Version+"+1c0eae6" {
+		t.Errorf("FullVersion() = %q", got)
+	}
+	if s := info.String(); !strings.Contains(s, "Build commit: 1c0eae6\n") || !strings.Contains(s, "Platform: "+want.Platform+"\n") {
+		t.Errorf("String() = %q", s)
+	}
+
+	// Test binaries carry no version control information
+	BuildCommit, BuildBranch, BuildTime = "", "", ""
+	info = GetBuildInfo()
+	if info.Branch != "unknown" || info.Commit == "" || info.BuildTime == "" {
+		t.Errorf("GetBuildInfo() = %+v", info)
+	}
+}
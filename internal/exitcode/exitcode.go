@@ -0,0 +1,113 @@
+// Package exitcode defines the exit statuses of ezft, which tell scripts why
+// a command failed without parsing its output
+package exitcode
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/easzlab/ezft/pkg/client"
+	"github.com/easzlab/ezft/pkg/daemon"
+	"github.com/easzlab/ezft/pkg/delta"
+	"github.com/easzlab/ezft/pkg/e2e"
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/multicast"
+	"github.com/easzlab/ezft/pkg/p2p"
+	"github.com/easzlab/ezft/pkg/quicproto"
+	"github.com/easzlab/ezft/pkg/syncer"
+)
+
+// Exit statuses
+const (
+	OK       = 0
+	Failure  = 1   // Any other error
+	Usage    = 2   // Invalid arguments, options or configuration
+	Network  = 3   // Server unreachable, connection lost or timed out
+	Checksum = 4   // Data transferred not matching its hash
+	Auth     = 5   // Credentials, token or end-to-end key refused
+	DiskFull = 6   // No space left on the device
+	Canceled = 130 // Interrupted, as shells report commands stopped by Ctrl+C
+)
+
+var (
+	authErrors = []error{
+		client.ErrUnauthorized,
+		quicproto.ErrUnauthorized,
+		daemon.ErrUnauthorized,
+		daemon.ErrForbidden,
+		e2e.ErrKeyMismatch,
+	}
+	checksumErrors = []error{
+		manifest.ErrChunkMismatch,
+		manifest.ErrSumMismatch,
+		quicproto.ErrChecksum,
+		delta.ErrChecksumMismatch,
+		p2p.ErrPieceMismatch,
+		e2e.ErrDecrypt,
+		syncer.ErrVerifyFailed,
+		multicast.ErrHashMismatch,
+	}
+	networkErrors = []error{
+		io.ErrUnexpectedEOF,
+		syscall.ECONNREFUSED,
+		syscall.ECONNRESET,
+	}
+)
+
+// usageError an error caused by invalid arguments, options or configuration
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+// UsageError marks err as caused by invalid arguments, options or
+// configuration, nil if err is nil
+func UsageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &usageError{err: err}
+}
+
+// Code returns the exit status of a command that failed with err
+func Code(err error) int {
+	var usage *usageError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return OK
+	case errors.As(err, &usage):
+		return Usage
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	case isAny(err, diskFullErrors):
+		return DiskFull
+	case isAny(err, authErrors):
+		return Auth
+	case isAny(err, checksumErrors):
+		return Checksum
+	case isAny(err, networkErrors) || errors.As(err, &netErr):
+		return Network
+	}
+	return Failure
+}
+
+// isAny reports whether err matches any of targets
+func isAny(err error, targets []error) bool {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package exitcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/client"
+	"github.com/easzlab/ezft/pkg/manifest"
+)
+
+func TestCode(t *testing.T) {
+	refused := &url.Error{Op: "Head", URL: "http://127.0.0.1:1/", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, OK},
+		{"other", errors.New("boom"), Failure},
+		{"usage", UsageError(errors.New("invalid rate")), Usage},
+		{"canceled", fmt.Errorf("download failed: %w", context.Canceled), Canceled},
+		{"canceled request", &url.Error{Op: "Get", Err: context.Canceled}, Canceled},
+		{"network", fmt.Errorf("failed to get file information: %w", refused), Network},
+		{"checksum", fmt.Errorf("2 chunks of the file: %w", manifest.ErrChunkMismatch), Checksum},
+		{"auth", fmt.Errorf("download failed: %w", client.ErrUnauthorized), Auth},
+		{"disk full", fmt.Errorf("failed to write chunk: %w", &os.PathError{Op: "write", Path: "f", Err: diskFullErrors[0]}), DiskFull},
+		{"joined", errors.Join(refused, client.ErrUnauthorized), Auth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Code(tt.err); got != tt.want {
+				t.Errorf("Code(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+	if UsageError(nil) != nil {
+		t.Error("UsageError(nil) must be nil")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package exitcode
+
+import "syscall"
+
+// diskFullErrors errors of writes to a full device
+var diskFullErrors = []error{syscall.ENOSPC}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package exitcode
+
+import "syscall"
+
+// diskFullErrors errors of writes to a full device: ERROR_HANDLE_DISK_FULL
+// and ERROR_DISK_FULL
+var diskFullErrors = []error{syscall.Errno(39), syscall.Errno(112)}
@@ -0,0 +1,99 @@
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/easzlab/ezft/internal/prompt"
+	"github.com/easzlab/ezft/pkg/client"
+)
+
+// PromptPassphrase returns a PassphraseFunc asking the passphrase of the file
+// to p, twice when it is a new one. It returns nil if p is nil.
+func PromptPassphrase(p *prompt.Prompter) PassphraseFunc {
+	if p == nil {
+		return nil
+	}
+	return func(create bool) (string, error) {
+		path, _ := FilePath()
+		if !create {
+			return p.Secret("Passphrase of " + path + ": ")
+		}
+		passphrase, err := p.Secret("New passphrase of " + path + ": ")
+		if err != nil {
+			return "", err
+		}
+		again, err := p.Secret("Repeat the passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("passphrases do not match")
+		}
+		return passphrase, nil
+	}
+}
+
+// CredentialsFunc returns a client.CredentialsFunc returning the username and
+// password stored for the host, else asking them to p unless nil. The store
+// is opened on the first call, the passphrase of the file being asked to p
+// too.
+func CredentialsFunc(p *prompt.Prompter) client.CredentialsFunc {
+	var (
+		mu     sync.Mutex
+		store  Store
+		err    error // Opening or reading the store failed, it is not read again
+		stored = make(map[string]*Credential)
+	)
+	lookup := func(host string) (*Credential, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if c, ok := stored[host]; ok {
+			return c, nil
+		}
+		if store == nil && err == nil {
+			store, err = Open("", PromptPassphrase(p))
+		}
+		if err != nil {
+			return nil, err
+		}
+		c, lookupErr := Lookup(store, host)
+		if lookupErr == nil && (c.Username != "" || c.Password != "") {
+			stored[host] = c
+			return c, nil
+		}
+		if lookupErr == nil || errors.Is(lookupErr, ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		err = lookupErr
+		return nil, err
+	}
+
+	return func(host string) (string, string, error) {
+		c, err := lookup(host)
+		if err == nil {
+			return c.Username, c.Password, nil
+		}
+		if p != nil {
+			return p.Credentials(host)
+		}
+		if errors.Is(err, ErrNotFound) {
+			return "", "", fmt.Errorf("%w for %s", err, host)
+		}
+		return "", "", fmt.Errorf("credentials of %s: %w", host, err)
+	}
+}
+
+// Token returns the token stored for host, empty if none could be read
+func Token(host string) string {
+	store, err := Open("", nil)
+	if err != nil {
+		return ""
+	}
+	c, err := Lookup(store, host)
+	if err != nil {
+		return ""
+	}
+	return c.Token
+}
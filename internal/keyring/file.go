@@ -0,0 +1,209 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileName name of the credentials file in the configuration directory
+const FileName = "credentials.enc"
+
+const (
+	// kdfIterations PBKDF2-SHA256 iterations deriving the key of the file
+	kdfIterations = 600000
+	fileVersion   = 1
+)
+
+// ErrWrongPassphrase is returned when the file cannot be decrypted
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted credentials file")
+
+// FilePath returns the path of the credentials file in the user
+// configuration directory
+func FilePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ezft", FileName), nil
+}
+
+// encryptedFile the content of the file, the credentials by host encrypted
+// with AES-256-GCM under a key derived from the passphrase
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// FileStore stores credentials in a file encrypted with a passphrase, asked
+// the first time the file is read or created
+type FileStore struct {
+	mu          sync.Mutex
+	path        string
+	passphrase  PassphraseFunc
+	key         []byte
+	salt        []byte
+	credentials map[string]*Credential // Decrypted, nil until loaded
+}
+
+// NewFileStore creates a store of the file at path
+func NewFileStore(path string, passphrase PassphraseFunc) *FileStore {
+	return &FileStore{path: path, passphrase: passphrase}
+}
+
+func (s *FileStore) Name() string {
+	return s.path
+}
+
+func (s *FileStore) Get(host string) (*Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	c, ok := s.credentials[host]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+func (s *FileStore) Set(host string, c *Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.credentials[host] = c
+	return s.save()
+}
+
+func (s *FileStore) Delete(host string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	if _, ok := s.credentials[host]; !ok {
+		return ErrNotFound
+	}
+	delete(s.credentials, host)
+	return s.save()
+}
+
+// load decrypts the file once, a missing file holding no credentials
+func (s *FileStore) load() error {
+	if s.credentials != nil {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.credentials = make(map[string]*Credential)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f encryptedFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("invalid credentials file %s: %w", s.path, err)
+	}
+	if f.Version != fileVersion {
+		return fmt.Errorf("unsupported credentials file version %d", f.Version)
+	}
+	passphrase, err := s.askPassphrase(false)
+	if err != nil {
+		return err
+	}
+	key, err := pbkdf2.Key(sha256.New, passphrase, f.Salt, f.Iterations, 32)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	plain, err := gcm.Open(nil, f.Nonce, f.Data, nil)
+	if err != nil {
+		return ErrWrongPassphrase
+	}
+	credentials := make(map[string]*Credential)
+	if err := json.Unmarshal(plain, &credentials); err != nil {
+		return fmt.Errorf("invalid credentials file %s: %w", s.path, err)
+	}
+	s.key, s.salt, s.credentials = key, f.Salt, credentials
+	return nil
+}
+
+// save encrypts the credentials to the file, asking a new passphrase if it
+// does not exist yet
+func (s *FileStore) save() error {
+	if s.key == nil {
+		passphrase, err := s.askPassphrase(true)
+		if err != nil {
+			return err
+		}
+		s.salt = make([]byte, 16)
+		rand.Read(s.salt)
+		if s.key, err = pbkdf2.Key(sha256.New, passphrase, s.salt, kdfIterations, 32); err != nil {
+			return err
+		}
+	}
+	plain, err := json.Marshal(s.credentials)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(s.key)
+	if err != nil {
+		return err
+	}
+	f := encryptedFile{Version: fileVersion, Iterations: kdfIterations, Salt: s.salt, Nonce: make([]byte, gcm.NonceSize())}
+	rand.Read(f.Nonce)
+	f.Data = gcm.Seal(nil, f.Nonce, plain, nil)
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *FileStore) askPassphrase(create bool) (string, error) {
+	if s.passphrase == nil {
+		return "", fmt.Errorf("passphrase of %s required, set $%s", s.path, EnvPassphrase)
+	}
+	passphrase, err := s.passphrase(create)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+	return passphrase, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
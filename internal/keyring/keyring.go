@@ -0,0 +1,132 @@
+// Package keyring stores the credentials of servers by host, in the keyring of
+// the operating system or else in a file encrypted with a passphrase
+package keyring
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// service name the credentials are stored under in the system keyring
+const service = "ezft"
+
+// Backends of the store
+const (
+	BackendAuto   = "auto"   // The system keyring if available, else the file
+	BackendSystem = "system" // The keyring of the operating system
+	BackendFile   = "file"   // A file encrypted with a passphrase
+)
+
+// Environment variables configuring the store
+const (
+	// EnvBackend backend of the store, BackendAuto if unset
+	EnvBackend = "EZFT_KEYRING"
+	// EnvPassphrase passphrase of the file, asked on the terminal if unset
+	EnvPassphrase = "EZFT_KEYRING_PASSPHRASE"
+)
+
+// ErrNotFound is returned when no credentials are stored for a host
+var ErrNotFound = errors.New("no credentials stored")
+
+// errNoSystemKeyring is returned when the system has no keyring to store in
+var errNoSystemKeyring = errors.New("no system keyring available")
+
+// Credential credentials stored for a host: a username and password for
+// basic auth, or the API token of a daemon
+type Credential struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// Store stores credentials by host
+type Store interface {
+	// Name describes where the credentials are stored
+	Name() string
+	// Get returns the credentials of host, ErrNotFound if none
+	Get(host string) (*Credential, error)
+	// Set stores the credentials of host, replacing those stored
+	Set(host string, c *Credential) error
+	// Delete removes the credentials of host, ErrNotFound if none
+	Delete(host string) error
+}
+
+// PassphraseFunc returns the passphrase of the file, create being true when
+// the file does not exist yet and the passphrase is a new one
+type PassphraseFunc func(create bool) (string, error)
+
+// Open opens the store of backend, that of $EZFT_KEYRING if empty. The
+// passphrase of the file is $EZFT_KEYRING_PASSPHRASE, else asked to
+// passphrase if not nil.
+func Open(backend string, passphrase PassphraseFunc) (Store, error) {
+	if backend == "" {
+		backend = os.Getenv(EnvBackend)
+	}
+	switch backend {
+	case "", BackendAuto:
+		if s, err := newSystemStore(); err == nil {
+			return s, nil
+		}
+		return openFile(passphrase)
+	case BackendSystem:
+		return newSystemStore()
+	case BackendFile:
+		return openFile(passphrase)
+	default:
+		return nil, fmt.Errorf("unknown keyring backend %q, want %s, %s or %s", backend, BackendAuto, BackendSystem, BackendFile)
+	}
+}
+
+func openFile(passphrase PassphraseFunc) (Store, error) {
+	path, err := FilePath()
+	if err != nil {
+		return nil, err
+	}
+	if env := os.Getenv(EnvPassphrase); env != "" {
+		passphrase = func(bool) (string, error) { return env, nil }
+	}
+	return NewFileStore(path, passphrase), nil
+}
+
+// Host returns the host credentials are stored for: the host and port of a
+// URL, or s itself lowercased
+func Host(s string) string {
+	if strings.Contains(s, "://") {
+		if u, err := url.Parse(s); err == nil {
+			s = u.Host
+		}
+	}
+	return strings.ToLower(strings.TrimSuffix(s, "/"))
+}
+
+// Lookup returns the credentials of host, those stored for its name alone if
+// none are for its port
+func Lookup(s Store, host string) (*Credential, error) {
+	host = Host(host)
+	c, err := s.Get(host)
+	if !errors.Is(err, ErrNotFound) {
+		return c, err
+	}
+	if name, _, splitErr := net.SplitHostPort(host); splitErr == nil {
+		return s.Get(name)
+	}
+	return nil, err
+}
+
+// encode encodes c as the secret stored in the system keyring
+func encode(c *Credential) ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func decode(secret []byte) (*Credential, error) {
+	var c Credential
+	if err := json.Unmarshal(secret, &c); err != nil {
+		return nil, fmt.Errorf("invalid stored credentials: %w", err)
+	}
+	return &c, nil
+}
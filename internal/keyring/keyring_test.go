@@ -0,0 +1,135 @@
+package keyring
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHost(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"files.example.com:8080", "files.example.com:8080"},
+		{"Files.Example.com", "files.example.com"},
+		{"http://files.example.com:8080/dir/os.img", "files.example.com:8080"},
+		{"https://files.example.com/", "files.example.com"},
+		{"127.0.0.1:7070/", "127.0.0.1:7070"},
+	}
+	for _, tt := range tests {
+		if got := Host(tt.in); got != tt.want {
+			t.Errorf("Host(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ezft", FileName)
+	asked := 0
+	passphrase := func(create bool) (string, error) {
+		asked++
+		return "correct horse", nil
+	}
+
+	s := NewFileStore(path, passphrase)
+	if _, err := s.Get("files.example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v without file, want %v", err, ErrNotFound)
+	}
+	if asked != 0 {
+		t.Error("Passphrase asked without file")
+	}
+	if err := s.Set("files.example.com", &Credential{Username: "alice", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("127.0.0.1:7070", &Credential{Token: "t0k3n"}); err != nil {
+		t.Fatal(err)
+	}
+	if asked != 1 {
+		t.Errorf("Passphrase asked %d times, want 1", asked)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "alice") {
+		t.Error("Credentials stored in clear")
+	}
+
+	// Read back by another store
+	s = NewFileStore(path, passphrase)
+	if c, err := s.Get("files.example.com"); err != nil || c.Username != "alice" || c.Password != "secret" {
+		t.Errorf("Get() = %+v, %v", c, err)
+	}
+	if err := s.Delete("127.0.0.1:7070"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("127.0.0.1:7070"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() error = %v, want %v", err, ErrNotFound)
+	}
+
+	wrong := NewFileStore(path, func(bool) (string, error) { return "wrong", nil })
+	if _, err := wrong.Get("files.example.com"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Get() error = %v, want %v", err, ErrWrongPassphrase)
+	}
+	if _, err := NewFileStore(path, nil).Get("files.example.com"); err == nil || !strings.Contains(err.Error(), EnvPassphrase) {
+		t.Errorf("Get() error = %v without passphrase", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	s := NewFileStore(filepath.Join(t.TempDir(), FileName), func(bool) (string, error) { return "pass", nil })
+	s.Set("files.example.com", &Credential{Username: "alice", Password: "any port"})
+	s.Set("files.example.com:8443", &Credential{Username: "alice", Password: "8443"})
+
+	tests := []struct {
+		host, want string
+	}{
+		{"files.example.com:8443", "8443"},
+		{"files.example.com:8080", "any port"},
+		{"http://FILES.example.com/os.img", "any port"},
+	}
+	for _, tt := range tests {
+		if c, err := Lookup(s, tt.host); err != nil || c.Password != tt.want {
+			t.Errorf("Lookup(%q) = %+v, %v, want password %q", tt.host, c, err, tt.want)
+		}
+	}
+	if _, err := Lookup(s, "other.example.com:8080"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCredentialsFunc(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("AppData", dir)
+	t.Setenv(EnvBackend, BackendFile)
+	t.Setenv(EnvPassphrase, "pass")
+	store, err := Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("files.example.com:8080", &Credential{Username: "alice", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Set("127.0.0.1:7070", &Credential{Token: "t0k3n"})
+
+	credentials := CredentialsFunc(nil)
+	if username, password, err := credentials("files.example.com:8080"); err != nil || username != "alice" || password != "secret" {
+		t.Errorf("credentials() = %q, %q, %v", username, password, err)
+	}
+	// Tokens are not basic auth credentials
+	if _, _, err := credentials("127.0.0.1:7070"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("credentials() error = %v, want %v", err, ErrNotFound)
+	}
+	if token := Token("http://127.0.0.1:7070"); token != "t0k3n" {
+		t.Errorf("Token() = %q", token)
+	}
+
+	t.Setenv(EnvPassphrase, "wrong")
+	if _, _, err := CredentialsFunc(nil)("files.example.com:8080"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("credentials() error = %v, want %v", err, ErrWrongPassphrase)
+	}
+}
@@ -0,0 +1,76 @@
+package keyring
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// errItemNotFound exit status of security when no item matches
+const errItemNotFound = 44
+
+// systemStore stores credentials in the login keychain with the security
+// tool, as generic passwords of the service ezft
+type systemStore struct{}
+
+func newSystemStore() (Store, error) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, errNoSystemKeyring
+	}
+	return systemStore{}, nil
+}
+
+func (systemStore) Name() string {
+	return "macOS keychain"
+}
+
+func (systemStore) Get(host string) (*Credential, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", host, "-w").Output()
+	if err != nil {
+		return nil, securityError(err)
+	}
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored credentials: %w", err)
+	}
+	return decode(secret)
+}
+
+func (systemStore) Set(host string, c *Credential) error {
+	if strings.ContainsAny(host, "'\\ \t\n") {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	secret, err := encode(c)
+	if err != nil {
+		return err
+	}
+	// Given on the standard input of the interactive mode, the secret does
+	// not show in the arguments of the process. Base64 needs no quoting.
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a '%s' -w %s\n",
+		service, host, base64.StdEncoding.EncodeToString(secret)))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("security: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (systemStore) Delete(host string) error {
+	return securityError(exec.Command("security", "delete-generic-password", "-s", service, "-a", host).Run())
+}
+
+func securityError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == errItemNotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("security: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build !(darwin || windows || linux || freebsd || netbsd || openbsd || dragonfly)
+
+package keyring
+
+func newSystemStore() (Store, error) {
+	return nil, errNoSystemKeyring
+}
@@ -0,0 +1,77 @@
+//go:build linux || freebsd || netbsd || openbsd || dragonfly
+
+package keyring
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// systemStore stores credentials in the Secret Service of the desktop session
+// (GNOME Keyring, KWallet) with secret-tool, as items of the service ezft
+type systemStore struct{}
+
+func newSystemStore() (Store, error) {
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return nil, errNoSystemKeyring
+	}
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil, errNoSystemKeyring
+	}
+	return systemStore{}, nil
+}
+
+func (systemStore) Name() string {
+	return "Secret Service"
+}
+
+func (systemStore) Get(host string) (*Credential, error) {
+	out, err := secretTool(nil, "lookup", "service", service, "host", host)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing is printed when no item matches
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return decode(out)
+}
+
+func (systemStore) Set(host string, c *Credential) error {
+	secret, err := encode(c)
+	if err != nil {
+		return err
+	}
+	_, err = secretTool(secret, "store", "--label", "ezft credentials for "+host, "service", service, "host", host)
+	return err
+}
+
+func (s systemStore) Delete(host string) error {
+	// clear succeeds whether or not an item matched
+	if _, err := s.Get(host); err != nil {
+		return err
+	}
+	_, err := secretTool(nil, "clear", "service", service, "host", host)
+	return err
+}
+
+// secretTool runs secret-tool with args, stdin given on its standard input
+func secretTool(stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && args[0] == "lookup" && stderr.Len() == 0 {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("secret-tool %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
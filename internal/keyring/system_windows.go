@@ -0,0 +1,116 @@
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+var (
+	advapi32        = windows.NewLazySystemDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+)
+
+// credential the CREDENTIALW structure
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// systemStore stores credentials in the Windows Credential Manager, as
+// generic credentials named ezft:<host>
+type systemStore struct{}
+
+func newSystemStore() (Store, error) {
+	if err := procCredReadW.Find(); err != nil {
+		return nil, errNoSystemKeyring
+	}
+	return systemStore{}, nil
+}
+
+func (systemStore) Name() string {
+	return "Windows Credential Manager"
+}
+
+func (systemStore) Get(host string) (*Credential, error) {
+	target, err := windows.UTF16PtrFromString(service + ":" + host)
+	if err != nil {
+		return nil, err
+	}
+	var cred *credential
+	r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		return nil, credError("CredRead", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	secret := make([]byte, cred.CredentialBlobSize)
+	copy(secret, unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize))
+	return decode(secret)
+}
+
+func (systemStore) Set(host string, c *Credential) error {
+	secret, err := encode(c)
+	if err != nil {
+		return err
+	}
+	target, err := windows.UTF16PtrFromString(service + ":" + host)
+	if err != nil {
+		return err
+	}
+	userName, err := windows.UTF16PtrFromString(c.Username)
+	if err != nil {
+		return err
+	}
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(secret)),
+		CredentialBlob:     &secret[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           userName,
+	}
+	r, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if r == 0 {
+		return credError("CredWrite", err)
+	}
+	return nil
+}
+
+func (systemStore) Delete(host string) error {
+	target, err := windows.UTF16PtrFromString(service + ":" + host)
+	if err != nil {
+		return err
+	}
+	r, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0)
+	if r == 0 {
+		return credError("CredDelete", err)
+	}
+	return nil
+}
+
+func credError(op string, err error) error {
+	if errors.Is(err, windows.ERROR_NOT_FOUND) {
+		return ErrNotFound
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
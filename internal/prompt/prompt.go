@@ -0,0 +1,133 @@
+// Package prompt asks the user for credentials on the terminal, the password
+// being read without echo
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrNotTerminal is returned when the standard input is not a terminal to
+// read the credentials from
+var ErrNotTerminal = errors.New("standard input is not a terminal")
+
+// Interactive reports whether the user can be prompted: the standard input
+// and error are terminals
+func Interactive() bool {
+	return isTerminal(os.Stdin) && isTerminal(os.Stderr)
+}
+
+// Prompter asks for the credentials of each host once, on the standard error
+// and input. Concurrent downloads from a host wait for the first prompt and
+// reuse its answer.
+type Prompter struct {
+	mu          sync.Mutex
+	in          *os.File
+	out         io.Writer
+	credentials map[string][2]string // Username and password by host
+}
+
+// NewPrompter creates a prompter on the terminal of the process
+func NewPrompter() *Prompter {
+	return &Prompter{in: os.Stdin, out: os.Stderr, credentials: make(map[string][2]string)}
+}
+
+// Credentials asks the username and password for host, it is a
+// client.CredentialsFunc
+func (p *Prompter) Credentials(host string) (string, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.credentials[host]; ok {
+		return c[0], c[1], nil
+	}
+	if !isTerminal(p.in) {
+		return "", "", ErrNotTerminal
+	}
+
+	fmt.Fprintf(p.out, "Authentication required by %s\nUsername: ", host)
+	username, err := readLine(p.in)
+	if err != nil {
+		return "", "", err
+	}
+	fmt.Fprint(p.out, "Password: ")
+	password, err := readPassword(p.in)
+	// The newline typed was not echoed
+	fmt.Fprintln(p.out)
+	if err != nil {
+		return "", "", err
+	}
+	p.credentials[host] = [2]string{username, password}
+	return username, password, nil
+}
+
+// Line asks for a line after printing label
+func (p *Prompter) Line(label string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !isTerminal(p.in) {
+		return "", ErrNotTerminal
+	}
+	fmt.Fprint(p.out, label)
+	return readLine(p.in)
+}
+
+// Secret asks for a secret such as a password or passphrase after printing
+// label, it is read without echo
+func (p *Prompter) Secret(label string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !isTerminal(p.in) {
+		return "", ErrNotTerminal
+	}
+	fmt.Fprint(p.out, label)
+	secret, err := readPassword(p.in)
+	fmt.Fprintln(p.out)
+	return secret, err
+}
+
+// readLine reads a line from f a byte at a time, so nothing after it is
+// consumed
+func readLine(f *os.File) (string, error) {
+	var sb strings.Builder
+	b := make([]byte, 1)
+	for {
+		n, err := f.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			sb.WriteByte(b[0])
+		}
+		if errors.Is(err, io.EOF) {
+			if sb.Len() == 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\r"), nil
+}
+
+// Writer returns a writer to w holding writes while prompting, so progress
+// drawn meanwhile does not overwrite the prompt
+func (p *Prompter) Writer(w io.Writer) io.Writer {
+	return &heldWriter{w: w, mu: &p.mu}
+}
+
+type heldWriter struct {
+	w  io.Writer
+	mu *sync.Mutex
+}
+
+func (w *heldWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(b)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+
+package prompt
+
+import "os"
+
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func readPassword(f *os.File) (string, error) {
+	return "", ErrNotTerminal
+}
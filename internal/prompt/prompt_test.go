@@ -0,0 +1,50 @@
+package prompt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestReadLine(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.WriteString("alice\r\nsecond\nlast")
+	w.Close()
+
+	for _, want := range []string{"alice", "second", "last"} {
+		if got, err := readLine(r); err != nil || got != want {
+			t.Errorf("readLine() = %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := readLine(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("readLine() error = %v at the end, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	var out bytes.Buffer
+	p := &Prompter{in: r, out: &out, credentials: map[string][2]string{"known:8080": {"bob", "secret"}}}
+
+	if _, _, err := p.Credentials("other:8080"); !errors.Is(err, ErrNotTerminal) {
+		t.Errorf("Credentials() error = %v, want %v", err, ErrNotTerminal)
+	}
+	if username, password, err := p.Credentials("known:8080"); err != nil || username != "bob" || password != "secret" {
+		t.Errorf("Credentials() = %q, %q, %v", username, password, err)
+	}
+	p.Writer(&out).Write([]byte("progress"))
+	if out.String() != "progress" {
+		t.Errorf("Output %q", out.String())
+	}
+}
@@ -0,0 +1,240 @@
+// Package bench measures the download throughput of combinations of
+// concurrency and chunk size, to recommend the settings of a link. The file
+// is downloaded from a URL, or from a loopback server serving generated data
+// to measure the host itself.
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/client"
+	"go.uber.org/zap"
+)
+
+// Defaults of the sweep
+var (
+	DefaultConcurrency = []int{1, 2, 4, 8, 16}
+	DefaultChunkSizes  = []int64{256 * 1024, 1024 * 1024, 4 * 1024 * 1024, 16 * 1024 * 1024}
+)
+
+// DefaultSize size of the file the loopback server generates
+const DefaultSize = 256 * 1024 * 1024
+
+// tolerance share of the best throughput within which fewer connections and
+// larger chunks are recommended, as they spare the server
+const tolerance = 0.05
+
+// Config benchmark configuration
+type Config struct {
+	URL         string  // File downloaded, a loopback server generates one if empty
+	Size        int64   // Size of the generated file, DefaultSize if 0
+	Concurrency []int   // Concurrency counts measured, DefaultConcurrency if empty
+	ChunkSizes  []int64 // Chunk sizes measured, DefaultChunkSizes if empty
+	Rounds      int     // Downloads per combination, the fastest counts
+	Username    string  // Basic auth username
+	Password    string  // Basic auth password
+	DisableQUIC bool    // Measure HTTP range requests even when the server offers QUIC
+	NoProxyEnv  bool    // Ignore the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, connecting directly
+}
+
+// Result the measure of a combination
+type Result struct {
+	Concurrency int           `json:"concurrency"`
+	ChunkSize   int64         `json:"chunk_size"`
+	Bytes       int64         `json:"bytes"`
+	Duration    time.Duration `json:"-"`
+	Throughput  float64       `json:"throughput"` // Bytes per second of the fastest round
+	Error       string        `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes the duration in seconds, like the results of the client
+func (r *Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	return json.Marshal(struct {
+		*result
+		Duration float64 `json:"duration"`
+	}{(*result)(r), r.Duration.Seconds()})
+}
+
+// Report the measures of all combinations
+type Report struct {
+	URL         string    `json:"url"`
+	Results     []*Result `json:"results"`
+	Recommended *Result   `json:"recommended,omitempty"` // Nil if all failed
+}
+
+// Bench runs a benchmark
+type Bench struct {
+	config   *Config
+	logger   *zap.Logger
+	onResult func(*Result)
+}
+
+// NewBench creates a benchmark with config
+func NewBench(config *Config) *Bench {
+	if config.Size <= 0 {
+		config.Size = DefaultSize
+	}
+	if len(config.Concurrency) == 0 {
+		config.Concurrency = DefaultConcurrency
+	}
+	if len(config.ChunkSizes) == 0 {
+		config.ChunkSizes = DefaultChunkSizes
+	}
+	if config.Rounds <= 0 {
+		config.Rounds = 1
+	}
+	return &Bench{config: config, logger: zap.NewNop()}
+}
+
+func (b *Bench) SetLogger(logger *zap.Logger) {
+	b.logger = logger
+}
+
+// OnResult sets a function called with the result of every combination once
+// measured
+func (b *Bench) OnResult(f func(*Result)) {
+	b.onResult = f
+}
+
+// Run measures every combination, a failing one does not stop the others
+func (b *Bench) Run(ctx context.Context) (*Report, error) {
+	fileURL := b.config.URL
+	if fileURL == "" {
+		u, stop, err := serveLoopback(b.config.Size)
+		if err != nil {
+			return nil, err
+		}
+		defer stop()
+		fileURL = u
+	}
+	dir, err := os.MkdirTemp("", "ezft-bench-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	report := &Report{URL: fileURL}
+	for _, concurrency := range b.config.Concurrency {
+		for _, chunkSize := range b.config.ChunkSizes {
+			result := &Result{Concurrency: concurrency, ChunkSize: chunkSize}
+			for round := 0; round < b.config.Rounds; round++ {
+				n, d, err := b.download(ctx, fileURL, filepath.Join(dir, "file"), concurrency, chunkSize)
+				if ctx.Err() != nil {
+					return report, ctx.Err()
+				}
+				if err != nil {
+					result.Error = err.Error()
+					break
+				}
+				if throughput := float64(n) / d.Seconds(); throughput > result.Throughput {
+					result.Bytes, result.Duration, result.Throughput = n, d, throughput
+				}
+			}
+			b.logger.Info("combination measured",
+				zap.Int("concurrency", concurrency),
+				zap.Int64("chunkSize", chunkSize),
+				zap.Float64("throughput", result.Throughput),
+				zap.String("error", result.Error),
+			)
+			report.Results = append(report.Results, result)
+			if b.onResult != nil {
+				b.onResult(result)
+			}
+		}
+	}
+	report.Recommended = recommend(report.Results)
+	return report, nil
+}
+
+// download downloads fileURL to path, which is removed afterwards, returning
+// the bytes downloaded and the time it took
+func (b *Bench) download(ctx context.Context, fileURL, path string, concurrency int, chunkSize int64) (int64, time.Duration, error) {
+	defer os.Remove(path)
+	c := client.NewClient(&client.DownloadConfig{
+		URL:               fileURL,
+		OutputPath:        path,
+		FailedChunksJason: path + ".failed_chunks.json",
+		ChunkSize:         chunkSize,
+		MaxConcurrency:    concurrency,
+		RetryCount:        0,
+		EnableResume:      true,
+		DisableQUIC:       b.config.DisableQUIC,
+		NoProxyEnv:        b.config.NoProxyEnv,
+		Username:          b.config.Username,
+		Password:          b.config.Password,
+	})
+	c.SetLogger(b.logger)
+	c.SetOutput(io.Discard)
+	start := time.Now()
+	if err := c.Download(ctx); err != nil {
+		os.Remove(path + ".failed_chunks.json")
+		return 0, 0, err
+	}
+	d := time.Since(start)
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return info.Size(), d, nil
+}
+
+// recommend returns the result with the least concurrency, then the largest
+// chunks, within tolerance of the best throughput
+func recommend(results []*Result) *Result {
+	var best float64
+	for _, r := range results {
+		best = max(best, r.Throughput)
+	}
+	if best == 0 {
+		return nil
+	}
+	var chosen *Result
+	for _, r := range results {
+		if r.Error != "" || r.Throughput < best*(1-tolerance) {
+			continue
+		}
+		if chosen == nil || r.Concurrency < chosen.Concurrency ||
+			(r.Concurrency == chosen.Concurrency && r.ChunkSize > chosen.ChunkSize) {
+			chosen = r
+		}
+	}
+	return chosen
+}
+
+// serveLoopback serves a generated file of size bytes on the loopback
+// interface, returning its URL and a function stopping the server
+func serveLoopback(size int64) (string, func(), error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to listen on loopback: %w", err)
+	}
+	modTime := time.Now()
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "bench.bin", modTime, io.NewSectionReader(pattern{}, 0, size))
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go srv.Serve(lis)
+	return "http://" + lis.Addr().String() + "/bench.bin", func() { srv.Close() }, nil
+}
+
+// pattern an endless file of generated bytes
+type pattern struct{}
+
+func (pattern) ReadAt(b []byte, off int64) (int, error) {
+	for i := range b {
+		x := uint64(off+int64(i)) * 0x9e3779b97f4a7c15
+		b[i] = byte(x >> 56)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,74 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBench(t *testing.T) {
+	var measured int
+	b := NewBench(&Config{
+		Size:        4*1024*1024 + 123,
+		Concurrency: []int{1, 4},
+		ChunkSizes:  []int64{256 * 1024, 1024 * 1024},
+		Rounds:      2,
+	})
+	b.OnResult(func(*Result) { measured++ })
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	report, err := b.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(report.Results) != 4 || measured != 4 {
+		t.Fatalf("%d results, %d reported, want 4", len(report.Results), measured)
+	}
+	for _, r := range report.Results {
+		if r.Error != "" || r.Bytes != 4*1024*1024+123 || r.Throughput <= 0 {
+			t.Errorf("Result %+v", r)
+		}
+	}
+	if report.Recommended == nil {
+		t.Fatal("No recommendation")
+	}
+}
+
+func TestBenchError(t *testing.T) {
+	b := NewBench(&Config{URL: "http://127.0.0.1:1/missing", Concurrency: []int{1}, ChunkSizes: []int64{1024}})
+	report, err := b.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(report.Results) != 1 || report.Results[0].Error == "" || report.Recommended != nil {
+		t.Errorf("Report %+v", report)
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	results := []*Result{
+		{Concurrency: 1, ChunkSize: 1024, Throughput: 50},
+		{Concurrency: 2, ChunkSize: 1024, Throughput: 97},
+		{Concurrency: 2, ChunkSize: 4096, Throughput: 96},
+		{Concurrency: 8, ChunkSize: 1024, Throughput: 100},
+		{Concurrency: 1, ChunkSize: 4096, Error: "failed"},
+	}
+	if got := recommend(results); got != results[2] {
+		t.Errorf("recommend() = %+v, want %+v", got, results[2])
+	}
+	if got := recommend(results[4:]); got != nil {
+		t.Errorf("recommend() = %+v, want nil", got)
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	b, err := json.Marshal(&Result{Concurrency: 4, ChunkSize: 1024, Bytes: 2048, Duration: 1500 * time.Millisecond, Throughput: 1365.3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"concurrency":4,"chunk_size":1024,"bytes":2048,"throughput":1365.3,"duration":1.5}`
+	if string(b) != want {
+		t.Errorf("json.Marshal() = %s, want %s", b, want)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/easzlab/ezft/pkg/utils"
 	"go.uber.org/zap"
 )
 
@@ -32,9 +33,8 @@ func (c *Client) BasicDownload(ctx context.Context) error {
 	// Retry mechanism
 	for attempt := 0; attempt <= c.config.RetryCount; attempt++ {
 		if attempt > 0 {
-			c.logger.Info("",
-				zap.String("msg", fmt.Sprintf("Retry attempt %d/%d", attempt, c.config.RetryCount)),
-			)
+			c.retries.Add(1)
+			c.logger.Info(fmt.Sprintf("Retry attempt %d/%d", attempt, c.config.RetryCount))
 			// Exponential backoff
 			backoff := time.Duration(attempt) * time.Second
 			select {
@@ -50,8 +50,7 @@ func (c *Client) BasicDownload(ctx context.Context) error {
 		}
 
 		lastErr = err
-		c.logger.Info("",
-			zap.String("msg", fmt.Sprintf("Download attempt %d failed", attempt+1)),
+		c.logger.Info(fmt.Sprintf("Download attempt %d failed", attempt+1),
 			zap.Error(err),
 		)
 	}
@@ -59,14 +58,28 @@ func (c *Client) BasicDownload(ctx context.Context) error {
 	return fmt.Errorf("download failed after %d attempts: %w", c.config.RetryCount+1, lastErr)
 }
 
+// streamDownload downloads a resource whose size the server does not tell,
+// such as one generated as it is sent, whole and without resuming, and
+// records its size once complete
+func (c *Client) streamDownload(ctx context.Context) error {
+	c.logger.Info("File size unknown, downloading as a stream")
+	if err := c.BasicDownload(ctx); err != nil {
+		return err
+	}
+	size, err := c.getExistingFileSize()
+	if err != nil {
+		return err
+	}
+	c.config.FileSize = size
+	return nil
+}
+
 // performBasicDownload performs the actual download with optimizations
 func (c *Client) performBasicDownload(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", c.config.URL, nil)
+	req, err := c.newRequest(ctx, "GET", c.config.URL, nil)
 	if err != nil {
 		return err
 	}
-	// Set User-Agent
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; ezft/1.0)")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -74,6 +87,9 @@ func (c *Client) performBasicDownload(ctx context.Context) error {
 	}
 	defer resp.Body.Close()
 
+	if err := checkAuth(resp); err != nil {
+		return err
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("download failed, status code: %d", resp.StatusCode)
 	}
@@ -97,8 +113,7 @@ func (c *Client) performBasicDownload(ctx context.Context) error {
 	bufferedWriter := bufio.NewWriterSize(file, int(bufferSize))
 	defer func() {
 		if flushErr := bufferedWriter.Flush(); flushErr != nil {
-			c.logger.Error("",
-				zap.String("msg", "failed to flush buffer"),
+			c.logger.Error("failed to flush buffer",
 				zap.Error(flushErr),
 			)
 		}
@@ -110,17 +125,16 @@ func (c *Client) performBasicDownload(ctx context.Context) error {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
-	c.logger.Info("",
-		zap.String("msg", fmt.Sprintf("Download completed: %d bytes written", written)),
-	)
+	c.logger.Info(fmt.Sprintf("Download completed: %d bytes written", written))
 	return nil
 }
 
 // CopyWithOptimizedBuffer copies data with optimized buffer size
 func (c *Client) CopyWithOptimizedBuffer(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
 	// Use unified buffer size for consistency
-	bufSize := c.getOptimalBufferSize()
-	buf := make([]byte, bufSize)
+	bufp := utils.GetBuffer(int(c.getOptimalBufferSize()))
+	defer utils.PutBuffer(bufp)
+	buf := *bufp
 	var written int64
 
 	for {
@@ -7,8 +7,10 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"go.uber.org/zap"
 )
@@ -517,3 +519,63 @@ func TestBasicDownloadRetryContextCancellation(t *testing.T) {
 
 	t.Logf("Cancelled after %d attempts with error: %v", attemptCount, err)
 }
+
+func TestStreamDownload(t *testing.T) {
+	parts := []string{"generated ", "as it ", "is sent"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No Content-Length, the body is sent with chunked transfer encoding
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead {
+			return
+		}
+		for _, part := range parts {
+			w.Write([]byte(part))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "stream.txt")
+	client := NewClient(&DownloadConfig{
+		URL:          server.URL + "/stream",
+		OutputPath:   output,
+		EnableResume: true,
+	})
+	client.SetLogger(zap.NewNop())
+
+	plan, err := client.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if plan.Strategy != StrategyBasic || plan.Size != -1 {
+		t.Errorf("Plan() = %+v, want basic strategy of unknown size", plan)
+	}
+
+	if err := client.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join(parts, "")
+	if string(content) != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+	if got := client.config.FileSize; got != int64(len(want)) {
+		t.Errorf("FileSize = %d, want %d", got, len(want))
+	}
+}
+
+func TestIndeterminateBar(t *testing.T) {
+	for _, elapsed := range []time.Duration{0, 300 * time.Millisecond, 4 * time.Second, time.Minute} {
+		bar := indeterminateBar(elapsed, 20)
+		if n := utf8.RuneCountInString(bar); n != 20 {
+			t.Errorf("indeterminateBar(%v) has %d characters, want 20", elapsed, n)
+		}
+		if n := strings.Count(bar, "█"); n != 4 {
+			t.Errorf("indeterminateBar(%v) = %q, want a block of 4", elapsed, bar)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/utils"
+
+	"go.uber.org/zap"
+)
+
+// DefaultBatchParallel default number of files of a batch downloaded at the
+// same time
+const DefaultBatchParallel = 2
+
+// progressNameWidth width of the file names of the progress lines
+const progressNameWidth = 24
+
+// BatchResult the outcome of the download of a file of a batch
+type BatchResult struct {
+	URL        string
+	OutputPath string
+	Size       int64 // Size of the output file
+	Duration   time.Duration
+	Retries    int64 // Transfers retried
+	Err        error
+}
+
+// Batch downloads several files, up to a maximum of them at the same time in
+// the order given
+type Batch struct {
+	clients  []*Client
+	parallel int
+	started  []bool // Downloads started, by index of the client
+	finished []bool // Downloads finished, by index of the client
+	mu       sync.Mutex
+	out      io.Writer
+	logger   *zap.Logger
+}
+
+// NewBatch creates a batch downloading the files of clients, parallel at a
+// time. Clients without a probe cache share one, probing each server once.
+func NewBatch(clients []*Client, parallel int) *Batch {
+	if parallel < 1 {
+		parallel = DefaultBatchParallel
+	}
+	probes := NewProbeCache()
+	for _, c := range clients {
+		if c.probes == nil {
+			c.SetProbeCache(probes)
+		}
+	}
+	return &Batch{
+		clients:  clients,
+		parallel: parallel,
+		started:  make([]bool, len(clients)),
+		finished: make([]bool, len(clients)),
+		out:      os.Stdout,
+		logger:   zap.NewNop(),
+	}
+}
+
+// SetOutput sets the writer the progress is printed to, the standard output
+// by default
+func (b *Batch) SetOutput(w io.Writer) {
+	b.out = w
+}
+
+func (b *Batch) SetLogger(logger *zap.Logger) {
+	b.logger = logger
+}
+
+// Download downloads all files, the failure of one does not stop the others.
+// It returns the results in the order of the clients.
+func (b *Batch) Download(ctx context.Context) []*BatchResult {
+	results := make([]*BatchResult, len(b.clients))
+	slots := make(chan struct{}, b.parallel)
+	var wg sync.WaitGroup
+	for i, c := range b.clients {
+		result := &BatchResult{URL: c.config.URL, OutputPath: c.config.OutputPath}
+		results[i] = result
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			result.Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			b.mu.Lock()
+			b.started[i] = true
+			b.mu.Unlock()
+			start := time.Now()
+			result.Err = c.Download(ctx)
+			result.Duration = time.Since(start)
+			result.Size, _ = c.getExistingFileSize()
+			result.Retries = c.Retries()
+
+			b.mu.Lock()
+			b.finished[i] = true
+			b.mu.Unlock()
+			if result.Err != nil {
+				b.logger.Info("Batch download failed",
+					zap.String("url", result.URL),
+					zap.Error(result.Err),
+				)
+			} else {
+				b.logger.Info("Batch download completed",
+					zap.String("url", result.URL),
+					zap.String("path", result.OutputPath),
+					zap.Duration("duration", result.Duration),
+				)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// GetProgress gets the progress of the files whose size is known, and the
+// number of files finished
+func (b *Batch) GetProgress() (float64, int) {
+	var done, total int64
+	for _, c := range b.clients {
+		if c.config.FileSize <= 0 {
+			continue
+		}
+		size, err := c.downloadedSize()
+		if err != nil {
+			continue
+		}
+		done += min(size, c.config.FileSize)
+		total += c.config.FileSize
+	}
+
+	b.mu.Lock()
+	finished := 0
+	for _, f := range b.finished {
+		if f {
+			finished++
+		}
+	}
+	b.mu.Unlock()
+
+	if total == 0 {
+		return 0, finished
+	}
+	return float64(done) / float64(total) * 100, finished
+}
+
+// ShowProgressLoop shows a bar per file being downloaded and one of the whole
+// batch, redrawn in place until ctx is done
+func (b *Batch) ShowProgressLoop(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	drawn := 0
+	for {
+		select {
+		case <-ctx.Done():
+			// The last frame stays on screen
+			b.draw(drawn)
+			return
+		case <-ticker.C:
+			drawn = b.draw(drawn)
+		}
+	}
+}
+
+// draw replaces the previous frame of drawn lines by the current one, and
+// returns its number of lines
+func (b *Batch) draw(drawn int) int {
+	var sb strings.Builder
+	if drawn > 0 {
+		// Back to the first line of the previous frame
+		fmt.Fprintf(&sb, "\x1b[%dF", drawn)
+	}
+	lines := b.progressLines()
+	for _, line := range lines {
+		// Each line is cleared of the longer one it replaces
+		sb.WriteString(line + "\x1b[K\n")
+	}
+	// Lines of the previous frame below, once files finished
+	sb.WriteString("\x1b[J")
+	io.WriteString(b.out, sb.String())
+	return len(lines)
+}
+
+// progressLines renders a line per file being downloaded, then the line of
+// the whole batch
+func (b *Batch) progressLines() []string {
+	b.mu.Lock()
+	active := make([]*Client, 0, b.parallel)
+	for i, c := range b.clients {
+		if b.started[i] && !b.finished[i] {
+			active = append(active, c)
+		}
+	}
+	b.mu.Unlock()
+
+	lines := make([]string, 0, len(active)+1)
+	for _, c := range active {
+		name := filepath.Base(c.config.OutputPath)
+		if r := []rune(name); len(r) > progressNameWidth {
+			name = string(r[:progressNameWidth-3]) + "..."
+		}
+		size, _ := c.downloadedSize()
+		var line string
+		switch {
+		case c.config.FileSize == 0:
+			line = fmt.Sprintf("[%s]   starting", progressBar(0, 30))
+		case c.config.FileSize < 0:
+			line = fmt.Sprintf("[%s]      ? %s", progressBar(0, 30), utils.FormatBytes(size))
+		default:
+			size = min(size, c.config.FileSize)
+			progress := float64(size) / float64(c.config.FileSize) * 100
+			line = fmt.Sprintf("[%s] %5.1f%% %s/%s", progressBar(progress, 30), progress,
+				utils.FormatBytes(size), utils.FormatBytes(c.config.FileSize))
+		}
+		lines = append(lines, fmt.Sprintf("  %-*s %s", progressNameWidth, name, line))
+	}
+
+	progress, finished := b.GetProgress()
+	lines = append(lines, fmt.Sprintf("Download progress: [%s] %.1f%% (%d/%d files)",
+		progressBar(progress, 30), progress, finished, len(b.clients)))
+	return lines
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestBatchDownload(t *testing.T) {
+	serverDir := t.TempDir()
+	files := map[string]string{
+		"a.bin": strings.Repeat("a", 100*1024),
+		"b.bin": strings.Repeat("b", 10*1024),
+		"c.bin": strings.Repeat("c", 50*1024),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(serverDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var active, maxActive atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for m := maxActive.Load(); n > m && !maxActive.CompareAndSwap(m, n); m = maxActive.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		http.FileServer(http.Dir(serverDir)).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	outputDir := t.TempDir()
+	names := []string{"a.bin", "missing.bin", "b.bin", "c.bin"}
+	clients := make([]*Client, len(names))
+	for i, name := range names {
+		clients[i] = NewClient(&DownloadConfig{
+			URL:          ts.URL + "/" + name,
+			OutputPath:   filepath.Join(outputDir, name),
+			ChunkSize:    16 * 1024,
+			RetryCount:   1,
+			EnableResume: true,
+		})
+		clients[i].SetLogger(zap.NewNop())
+	}
+	batch := NewBatch(clients, 2)
+	batch.SetLogger(zap.NewNop())
+	results := batch.Download(context.Background())
+
+	if len(results) != len(names) {
+		t.Fatalf("%d results, want %d", len(results), len(names))
+	}
+	for i, result := range results {
+		if result.URL != ts.URL+"/"+names[i] {
+			t.Errorf("results[%d].URL = %s, want the order of the clients", i, result.URL)
+		}
+		content, ok := files[names[i]]
+		if !ok {
+			if result.Err == nil {
+				t.Errorf("Download of %s succeeded", names[i])
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("Download of %s error = %v", names[i], result.Err)
+		}
+		if got, _ := os.ReadFile(result.OutputPath); string(got) != content || result.Size != int64(len(content)) {
+			t.Errorf("Downloaded %s differs, size %d", names[i], result.Size)
+		}
+	}
+	if n := maxActive.Load(); n > 2 {
+		t.Errorf("%d requests at the same time, want at most 2", n)
+	}
+	if progress, finished := batch.GetProgress(); progress != 100 || finished != len(names) {
+		t.Errorf("GetProgress() = %.1f, %d", progress, finished)
+	}
+}
+
+func TestBatchProgressLines(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"done.bin", "a-rather-long-file-name-of-a-release.iso", "starting.bin", "queued.bin"}
+	clients := make([]*Client, len(names))
+	for i, name := range names {
+		clients[i] = NewClient(&DownloadConfig{OutputPath: filepath.Join(dir, name)})
+	}
+	clients[0].config.FileSize = 100
+	clients[1].config.FileSize = 100
+	os.WriteFile(clients[0].config.OutputPath, make([]byte, 100), 0644)
+	os.WriteFile(clients[1].config.OutputPath, make([]byte, 25), 0644)
+
+	batch := NewBatch(clients, 2)
+	batch.started = []bool{true, true, true, false}
+	batch.finished = []bool{true, false, false, false}
+	lines := batch.progressLines()
+
+	// A line per file being downloaded, then the batch
+	if len(lines) != 3 {
+		t.Fatalf("progressLines() = %q, want 3 lines", lines)
+	}
+	if !strings.Contains(lines[0], "a-rather-long-file-na...") || !strings.Contains(lines[0], " 25.0%") {
+		t.Errorf("lines[0] = %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "starting.bin") || !strings.Contains(lines[1], "starting") {
+		t.Errorf("lines[1] = %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "62.5%") || !strings.Contains(lines[2], "(1/4 files)") {
+		t.Errorf("lines[2] = %q", lines[2])
+	}
+
+	var out strings.Builder
+	batch.SetOutput(&out)
+	if drawn := batch.draw(0); drawn != 3 || strings.Contains(out.String(), "\x1b[3F") {
+		t.Errorf("draw(0) = %d, %q", drawn, out.String())
+	}
+	out.Reset()
+	batch.finished[1] = true
+	if drawn := batch.draw(3); drawn != 2 || !strings.HasPrefix(out.String(), "\x1b[3F") || !strings.HasSuffix(out.String(), "\x1b[J") {
+		t.Errorf("draw(3) = %d, %q", drawn, out.String())
+	}
+}
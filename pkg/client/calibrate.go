@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// calibrationMinSize smallest download whose chunk size is calibrated,
+	// smaller ones use the size table of calculateChunkSize
+	calibrationMinSize = 128 * 1024 * 1024
+	// Sizes of the two chunks of the probe, timed to tell the overhead of a
+	// request from the time of its bytes
+	probeSmallSize = 256 * 1024
+	probeLargeSize = 4 * 1024 * 1024
+	// calibrationOverheadRatio chunks take this many times the overhead of a
+	// request to transfer, so that it is at most 5% of their time
+	calibrationOverheadRatio = 20
+	// Bounds of the calibrated chunk sizes
+	minCalibratedChunkSize = 1024 * 1024       // 1MB
+	maxCalibratedChunkSize = 100 * 1024 * 1024 // 100MB
+	// Bounds of AutoConcurrency, which adds a connection per
+	// concurrencyOverheadStep of overhead of a request
+	minAutoConcurrency      = 2
+	maxAutoConcurrency      = 16
+	concurrencyOverheadStep = 10 * time.Millisecond
+)
+
+// calibrateChunkSize downloads the first bytes from start as a probe of two
+// chunks, one small and one large, and sets the chunk size of the remaining
+// bytes, up to end, from the overhead of a request and the throughput of a
+// connection they show, and with AutoConcurrency the concurrency. It returns
+// the offset the remaining bytes start at.
+func (c *Client) calibrateChunkSize(ctx context.Context, file *os.File, start, end int64) (int64, error) {
+	var durations [2]time.Duration
+	offset := start
+	retries := c.Retries()
+	for i, size := range []int64{probeSmallSize, probeLargeSize} {
+		chunk := Chunk{Index: int64(i), Start: offset, End: offset + size - 1}
+		begin := time.Now()
+		if err := c.downloadChunksSequentially(ctx, file, []Chunk{chunk}); err != nil {
+			return 0, err
+		}
+		durations[i] = time.Since(begin)
+		offset += size
+	}
+
+	// Time of a request is its overhead plus its size at the throughput
+	remaining := end - offset
+	throughput := float64(probeLargeSize-probeSmallSize) / (durations[1] - durations[0]).Seconds()
+	overhead := durations[0] - time.Duration(probeSmallSize/throughput*float64(time.Second))
+	if c.Retries() != retries || durations[1] <= durations[0] || overhead <= 0 {
+		// Retries or noise hiding the overhead make the probe useless
+		c.config.ChunkSize = calculateChunkSize(remaining)
+		c.logger.Debug("Chunk size calibration inconclusive, using the size table",
+			zap.Duration("small", durations[0]),
+			zap.Duration("large", durations[1]),
+			zap.Int64("chunkSize", c.config.ChunkSize),
+		)
+		return offset, nil
+	}
+
+	if c.config.AutoConcurrency {
+		c.config.MaxConcurrency = chooseConcurrency(overhead, runtime.NumCPU())
+	}
+	c.config.ChunkSize = chooseChunkSize(overhead, throughput, remaining, c.config.MaxConcurrency)
+	c.logger.Info("Calibrated chunk size",
+		zap.Duration("overhead", overhead),
+		zap.String("throughput", utils.FormatBytes(int64(throughput))+"/s"),
+		zap.Int64("chunkSize", c.config.ChunkSize),
+		zap.Int("concurrency", c.config.MaxConcurrency),
+	)
+	return offset, nil
+}
+
+// chooseChunkSize returns the size of chunks taking calibrationOverheadRatio
+// times the overhead of a request to transfer at throughput, in bytes per
+// second, while leaving enough chunks of remaining bytes for every one of
+// concurrency workers to get several
+func chooseChunkSize(overhead time.Duration, throughput float64, remaining int64, concurrency int) int64 {
+	size := int64(throughput * overhead.Seconds() * calibrationOverheadRatio)
+	size = min(size, remaining/int64(4*max(concurrency, 1)))
+	size = min(max(size, minCalibratedChunkSize), maxCalibratedChunkSize)
+	// Whole blocks of 64KB
+	return size &^ (64*1024 - 1)
+}
+
+// defaultConcurrency returns the concurrency of AutoConcurrency before the
+// link was probed: two connections per CPU, within the bounds of
+// AutoConcurrency
+func defaultConcurrency(cpus int) int {
+	return min(max(2*cpus, minAutoConcurrency), maxAutoConcurrency)
+}
+
+// chooseConcurrency returns the concurrency of a link whose requests have
+// overhead: a connection per concurrencyOverheadStep of it, as connections of
+// distant servers spend most of their time waiting for round trips, up to
+// the default concurrency of cpus
+func chooseConcurrency(overhead time.Duration, cpus int) int {
+	n := int((overhead + concurrencyOverheadStep - 1) / concurrencyOverheadStep)
+	return min(max(n, minAutoConcurrency), defaultConcurrency(cpus))
+}
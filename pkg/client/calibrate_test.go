@@ -0,0 +1,113 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestChooseChunkSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		overhead    time.Duration
+		throughput  float64
+		remaining   int64
+		concurrency int
+		want        int64
+	}{
+		{"overhead_bound", 10 * time.Millisecond, 50 * 1024 * 1024, 10 * 1024 * 1024 * 1024, 4, 10 * 1024 * 1024},
+		{"min_size", time.Millisecond, 1024 * 1024, 10 * 1024 * 1024 * 1024, 4, minCalibratedChunkSize},
+		{"max_size", time.Second, 1024 * 1024 * 1024, 100 * 1024 * 1024 * 1024, 4, maxCalibratedChunkSize},
+		{"enough_chunks", 100 * time.Millisecond, 100 * 1024 * 1024, 256 * 1024 * 1024, 8, 8 * 1024 * 1024},
+		{"block_aligned", 10 * time.Millisecond, 10*1024*1024 + 1000, 10 * 1024 * 1024 * 1024, 1, 2 * 1024 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chooseChunkSize(tt.overhead, tt.throughput, tt.remaining, tt.concurrency); got != tt.want {
+				t.Errorf("chooseChunkSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalibrateChunkSize(t *testing.T) {
+	data := make([]byte, 16*1024*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Overhead of every request
+		time.Sleep(20 * time.Millisecond)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	testFile := filepath.Join(t.TempDir(), "calibrate.bin")
+	client := NewClient(&DownloadConfig{
+		URL:            server.URL + "/test.bin",
+		OutputPath:     testFile,
+		MaxConcurrency: 4,
+		AutoChunk:      true,
+	})
+	client.SetLogger(zap.NewNop())
+
+	file, err := os.Create(testFile)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	defer file.Close()
+
+	next, err := client.calibrateChunkSize(context.Background(), file, 1000, int64(len(data)))
+	if err != nil {
+		t.Fatalf("calibrateChunkSize() error = %v", err)
+	}
+	if want := int64(1000 + probeSmallSize + probeLargeSize); next != want {
+		t.Errorf("calibrateChunkSize() = %d, want %d", next, want)
+	}
+	if size := client.config.ChunkSize; size < minCalibratedChunkSize || size > maxCalibratedChunkSize {
+		t.Errorf("Calibrated chunk size %d out of bounds", size)
+	}
+
+	got, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if !bytes.Equal(got[1000:], data[1000:next]) {
+		t.Error("Probe chunks do not match the file")
+	}
+}
+
+func TestChooseConcurrency(t *testing.T) {
+	tests := []struct {
+		name     string
+		overhead time.Duration
+		cpus     int
+		want     int
+	}{
+		{"lan", 500 * time.Microsecond, 8, minAutoConcurrency},
+		{"regional", 35 * time.Millisecond, 8, 4},
+		{"distant", 120 * time.Millisecond, 8, 12},
+		{"cpu_bound", 120 * time.Millisecond, 2, 4},
+		{"max", time.Second, 64, maxAutoConcurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chooseConcurrency(tt.overhead, tt.cpus); got != tt.want {
+				t.Errorf("chooseConcurrency() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	for cpus, want := range map[int]int{1: 2, 4: 8, 32: maxAutoConcurrency} {
+		if got := defaultConcurrency(cpus); got != want {
+			t.Errorf("defaultConcurrency(%d) = %d, want %d", cpus, got, want)
+		}
+	}
+}
@@ -3,11 +3,18 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"os"
+	"sync/atomic"
 	"time"
+
+	"github.com/easzlab/ezft/pkg/p2p"
+	"github.com/easzlab/ezft/pkg/quicproto"
+	"github.com/easzlab/ezft/pkg/utils"
 )
 
 // Chunk represents a download chunk
@@ -17,11 +24,38 @@ type Chunk struct {
 	End   int64
 }
 
+// interruptedChunkError is returned for a chunk whose download was
+// interrupted after some of its data was written, only the rest of it is
+// left to download
+type interruptedChunkError struct {
+	left Chunk
+	err  error
+}
+
+func (e *interruptedChunkError) Error() string {
+	return e.err.Error()
+}
+
+func (e *interruptedChunkError) Unwrap() error {
+	return e.err
+}
+
 // downloadChunk downloads a single chunk
 func (c *Client) downloadChunk(ctx context.Context, file *os.File, chunk Chunk) error {
 	for retry := 0; retry <= c.config.RetryCount; retry++ {
-		if err := c.downloadChunkOnce(ctx, file, chunk); err != nil {
-			if retry == c.config.RetryCount {
+		err := c.downloadChunkOnce(ctx, file, chunk)
+		if err == nil && c.chunkSums != nil {
+			if err = c.verifyChunk(file, chunk); err != nil {
+				// Downloaded again by the retry
+				c.downloaded.Add(-(chunk.End - chunk.Start + 1))
+			} else if c.verified != nil {
+				c.verified.set(int(chunk.Start / c.chunkSums.ChunkSize))
+			}
+		}
+		if err != nil {
+			// Retrying cannot help once the remote file changed
+			var mismatch *sizeMismatchError
+			if retry == c.config.RetryCount || errors.Is(err, quicproto.ErrChanged) || errors.Is(err, errRemoteChanged) || errors.As(err, &mismatch) || ctx.Err() != nil {
 				return err
 			}
 
@@ -30,9 +64,17 @@ func (c *Client) downloadChunk(ctx context.Context, file *os.File, chunk Chunk)
 			case <-ctx.Done():
 				return ctx.Err()
 			case <-time.After(time.Duration(retry+1) * time.Second):
+				c.retries.Add(1)
 				continue
 			}
 		}
+		if c.p2p != nil {
+			c.p2p.node.Add(p2p.Range{Start: chunk.Start, End: chunk.End})
+		}
+		// Batched chunks are hashed and journaled once written
+		if c.batch == nil {
+			c.chunkWritten(chunk)
+		}
 		return nil
 	}
 	return nil
@@ -40,17 +82,36 @@ func (c *Client) downloadChunk(ctx context.Context, file *os.File, chunk Chunk)
 
 // downloadChunkOnce executes one chunk download
 func (c *Client) downloadChunkOnce(ctx context.Context, file *os.File, chunk Chunk) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", c.config.URL, nil)
+	if c.p2p != nil {
+		if err := c.fetchFromPeers(ctx, file, chunk); err == nil {
+			c.downloaded.Add(chunk.End - chunk.Start + 1)
+			return nil
+		}
+	}
+	if c.quic != nil {
+		if err := c.quic.readChunk(ctx, file, chunk, c.blastRate()); err != nil {
+			return err
+		}
+		c.downloaded.Add(chunk.End - chunk.Start + 1)
+		return nil
+	}
+
+	req, err := c.newRequest(ctx, "GET", c.config.URL, nil)
 	if err != nil {
 		return err
 	}
 
-	// Set User-Agent
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; ezft/1.0)")
-
 	// Set Range header
 	rangeHeader := fmt.Sprintf("bytes=%d-%d", chunk.Start, chunk.End)
 	req.Header.Set("Range", rangeHeader)
+	if c.ifRange != "" {
+		req.Header.Set("If-Range", c.ifRange)
+	}
+	var tracer *chunkTracer
+	if c.config.Trace {
+		tracer = newChunkTracer(chunk.Index)
+		req = req.WithContext(httptrace.WithClientTrace(ctx, tracer.clientTrace()))
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -58,66 +119,145 @@ func (c *Client) downloadChunkOnce(ctx context.Context, file *os.File, chunk Chu
 	}
 	defer resp.Body.Close()
 
+	if err := checkAuth(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK && c.ifRange != "" {
+		return errRemoteChanged
+	}
 	if resp.StatusCode != http.StatusPartialContent {
 		return fmt.Errorf("server does not support Range requests, status code: %d", resp.StatusCode)
 	}
+	if err := c.checkRangeTotal(resp.Header.Get("Content-Range")); err != nil {
+		return err
+	}
 
-	// Streaming download: use buffer for batch read and write
-	buffer := make([]byte, 32*1024) // 32KB buffer
-	currentOffset := chunk.Start
+	var n int64
+	if c.batch != nil {
+		n, err = c.batch.readChunk(resp.Body, chunk, &c.downloaded)
+	} else {
+		// Streaming download: read into one buffer while the other is written
+		n, err = writeChunkBody(ctx, file, resp.Body, chunk, &c.downloaded)
+	}
+	if err != nil {
+		// Interrupted, the data written is kept for resuming unless it is
+		// verified with the whole chunk
+		if ctx.Err() != nil && n > 0 && c.batch == nil && c.chunkSums == nil {
+			return &interruptedChunkError{left: Chunk{Index: chunk.Index, Start: chunk.Start + n, End: chunk.End}, err: err}
+		}
+		// Written again by the retry
+		c.downloaded.Add(-n)
+		return err
+	}
 
-	for {
-		// Check if context is cancelled
+	if tracer != nil {
+		c.addTrace(tracer.finish())
+	}
+	return nil
+}
+
+// chunkBufferSize is the size of each of the two buffers of writeChunkBody
+const chunkBufferSize = 256 * 1024
+
+// filledBuffer is a buffer read from the response of a chunk, to write at off
+type filledBuffer struct {
+	buf *[]byte
+	n   int
+	off int64
+}
+
+// writeChunkBody writes body to file from the start of chunk, up to its end,
+// adding the bytes written to progress as they are and returning them. Two
+// buffers are pipelined: one is read from the network while the other is
+// written to disk, so neither waits for the other.
+func writeChunkBody(ctx context.Context, file *os.File, body io.Reader, chunk Chunk, progress *atomic.Int64) (int64, error) {
+	free := make(chan *[]byte, 2)
+	full := make(chan filledBuffer, 2)
+	failed := make(chan struct{})
+	writeErr := make(chan error, 1)
+	var written int64
+	for range 2 {
+		free <- utils.GetBuffer(chunkBufferSize)
+	}
+	defer func() {
+		for range 2 {
+			utils.PutBuffer(<-free)
+		}
+	}()
+
+	// Writer, giving back every buffer even once it failed
+	go func() {
+		var err error
+		for f := range full {
+			if err == nil {
+				if _, werr := file.WriteAt((*f.buf)[:f.n], f.off); werr != nil {
+					err = fmt.Errorf("failed to write data: %w", werr)
+					close(failed)
+				} else {
+					written += int64(f.n)
+					progress.Add(int64(f.n))
+				}
+			}
+			free <- f.buf
+		}
+		writeErr <- err
+	}()
+
+	err := readChunkBody(ctx, body, chunk, free, full, failed)
+	close(full)
+	if werr := <-writeErr; werr != nil {
+		return written, werr
+	}
+	return written, err
+}
+
+// readChunkBody reads body into the free buffers and hands them to the writer
+// until the end of chunk, the body or a write failed
+func readChunkBody(ctx context.Context, body io.Reader, chunk Chunk, free chan *[]byte, full chan<- filledBuffer, failed <-chan struct{}) error {
+	currentOffset := chunk.Start
+	for currentOffset <= chunk.End {
+		var buf *[]byte
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		default:
+		case <-failed:
+			return nil
+		case buf = <-free:
 		}
 
-		// Read data to buffer
-		n, err := resp.Body.Read(buffer)
+		// Ensure not exceeding chunk boundary
+		want := min(int64(len(*buf)), chunk.End+1-currentOffset)
+		n, err := io.ReadFull(body, (*buf)[:want])
 		if n > 0 {
-			// Ensure not exceeding chunk boundary
-			if currentOffset+int64(n) > chunk.End+1 {
-				n = int(chunk.End + 1 - currentOffset)
-			}
-
-			// Write data to specified position
-			_, writeErr := file.WriteAt(buffer[:n], currentOffset)
-			if writeErr != nil {
-				return fmt.Errorf("failed to write data: %w", writeErr)
-			}
-
+			full <- filledBuffer{buf: buf, n: n, off: currentOffset}
 			currentOffset += int64(n)
+		} else {
+			free <- buf
 		}
 
 		// Check if reading is complete
-		if err == io.EOF {
-			break
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
 		}
 		if err != nil {
 			return fmt.Errorf("failed to read response data: %w", err)
 		}
-
-		// Check if reached chunk end position
-		if currentOffset > chunk.End {
-			break
-		}
 	}
-
 	return nil
 }
 
 // calculateChunks calculates download chunks
 func (c *Client) calculateChunks(start, end int64) []Chunk {
-	var chunks []Chunk
-
 	if c.config.AutoChunk {
 		c.config.ChunkSize = calculateChunkSize(end - start)
 	}
+	return splitChunks(start, end, c.config.ChunkSize)
+}
 
-	chunkSize := c.config.ChunkSize
-
+// splitChunks splits the range from start to end, excluded, into chunks of
+// chunkSize bytes
+func splitChunks(start, end, chunkSize int64) []Chunk {
+	var chunks []Chunk
 	for i := start; i < end; i += chunkSize {
 		chunk := Chunk{
 			Index: (i - start) / chunkSize,
@@ -136,31 +276,76 @@ func (c *Client) calculateChunks(start, end int64) []Chunk {
 	return chunks
 }
 
-// loadFailedChunks loads failed chunks record
-func (c *Client) loadFailedChunks() ([]Chunk, error) {
-	var failedChunks []Chunk
-	if _, err := os.Stat(c.config.FailedChunksJason); err == nil {
-		data, err := os.ReadFile(c.config.FailedChunksJason)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read failed chunks record file: %w", err)
-		}
+// ChunkRecord keeps the chunks left of an interrupted download, which the next
+// run downloads first. A record saved empty still exists until it is removed.
+type ChunkRecord interface {
+	// Load returns the chunks recorded, and false when there is no record
+	Load() ([]Chunk, bool, error)
+	Save(chunks []Chunk) error
+	Remove() error
+}
 
-		if err := json.Unmarshal(data, &failedChunks); err != nil {
-			return nil, fmt.Errorf("failed to parse failed chunks record file: %w", err)
-		}
+// fileRecord a chunk record kept in a JSON file
+type fileRecord string
+
+func (path fileRecord) Load() ([]Chunk, bool, error) {
+	data, err := os.ReadFile(string(path))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read failed chunks record file: %w", err)
 	}
 
-	return failedChunks, nil
+	var chunks []Chunk
+	if err := json.Unmarshal(data, &chunks); err != nil {
+		return nil, false, fmt.Errorf("failed to parse failed chunks record file: %w", err)
+	}
+	return chunks, true, nil
 }
 
-// saveFailedChunks saves failed chunks record
-func (c *Client) saveFailedChunks(chunks []Chunk) error {
+func (path fileRecord) Save(chunks []Chunk) error {
 	data, err := json.Marshal(chunks)
 	if err != nil {
 		return fmt.Errorf("failed to serialize failed chunks record: %w", err)
 	}
+	return utils.WriteFileAtomic(string(path), data, 0644)
+}
+
+func (path fileRecord) Remove() error {
+	if err := os.Remove(string(path)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete failed chunks record file: %w", err)
+	}
+	return nil
+}
+
+// chunkRecord returns the record of the chunks left of the download
+func (c *Client) chunkRecord() ChunkRecord {
+	if c.record != nil {
+		return c.record
+	}
+	return fileRecord(c.config.FailedChunksJason)
+}
+
+// loadFailedChunks loads failed chunks record
+func (c *Client) loadFailedChunks() ([]Chunk, error) {
+	chunks, _, err := c.chunkRecord().Load()
+	return chunks, err
+}
 
-	return os.WriteFile(c.config.FailedChunksJason, data, 0644)
+// hasFailedChunks reports whether chunks of the output file are recorded left
+// to download, the file is then incomplete even at its full size
+func (c *Client) hasFailedChunks() bool {
+	failedChunks, err := c.loadFailedChunks()
+	return err == nil && len(failedChunks) > 0
+}
+
+// saveFailedChunks saves failed chunks record
+func (c *Client) saveFailedChunks(chunks []Chunk) error {
+	if err := c.saveVerified(); err != nil {
+		return fmt.Errorf("failed to save verified chunks record: %w", err)
+	}
+	return c.chunkRecord().Save(chunks)
 }
 
 // Dynamically adjust chunk size based on file size
@@ -1,11 +1,13 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 )
 
@@ -330,6 +332,46 @@ func TestDownloadChunkOnce(t *testing.T) {
 	}
 }
 
+func TestWriteChunkBody(t *testing.T) {
+	// Several buffers of data past the end of the chunk
+	data := make([]byte, 3*chunkBufferSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	chunk := Chunk{Index: 1, Start: 10, End: 10 + 2*chunkBufferSize + 49}
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "pipelined.bin"))
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	defer file.Close()
+
+	var progress atomic.Int64
+	n, err := writeChunkBody(context.Background(), file, bytes.NewReader(data), chunk, &progress)
+	if err != nil {
+		t.Fatalf("writeChunkBody() error = %v", err)
+	}
+	if size := chunk.End - chunk.Start + 1; n != size || progress.Load() != size {
+		t.Errorf("writeChunkBody() = %d, progress %d, want %d", n, progress.Load(), size)
+	}
+	got := make([]byte, chunk.End+1)
+	if _, err := file.ReadAt(got, 0); err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if !bytes.Equal(got[chunk.Start:], data[:chunk.End-chunk.Start+1]) {
+		t.Error("writeChunkBody() wrote unexpected data")
+	}
+	if info, _ := file.Stat(); info.Size() != chunk.End+1 {
+		t.Errorf("File size = %d, want %d", info.Size(), chunk.End+1)
+	}
+
+	// Write errors are returned
+	file.Close()
+	if _, err := writeChunkBody(context.Background(), file, bytes.NewReader(data), chunk, &progress); err == nil {
+		t.Error("writeChunkBody() to a closed file error = nil")
+	}
+}
+
 func TestDownloadChunkWithRetry(t *testing.T) {
 	tempDir := t.TempDir()
 	testFile := filepath.Join(tempDir, "retry_test.txt")
@@ -376,6 +418,9 @@ func TestDownloadChunkWithRetry(t *testing.T) {
 	if attempts != 3 {
 		t.Errorf("Expected 3 attempts, got %d", attempts)
 	}
+	if retries := client.Retries(); retries != 2 {
+		t.Errorf("Retries() = %d, want 2", retries)
+	}
 }
 
 func TestDownloadChunkMaxRetries(t *testing.T) {
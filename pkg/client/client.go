@@ -2,28 +2,76 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/easzlab/ezft/pkg/e2e"
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/sockopt"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/easzlab/ezft/pkg/utils/logger"
 	"go.uber.org/zap"
+	"golang.org/x/net/http/httpproxy"
 )
 
+// ErrUnauthorized is wrapped by the errors of requests the server refused for
+// missing or wrong credentials
+var ErrUnauthorized = errors.New("unauthorized")
+
+// CredentialsFunc returns the credentials for host, asked once when the server
+// refuses a request sent without any
+type CredentialsFunc func(host string) (username, password string, err error)
+
 // DownloadConfig download configuration
 type DownloadConfig struct {
-	URL               string // Download URL
-	OutputPath        string // Output file path
-	FailedChunksJason string // Failed chunks record file
-	ChunkSize         int64  // Size of each chunk
-	FileSize          int64  // Size of file to download
-	MaxConcurrency    int    // Maximum concurrency
-	RetryCount        int    // Retry count
-	EnableResume      bool   // Whether to support resume download
-	AutoChunk         bool   // Whether to auto chunk, if true, ignore ChunkSize and auto calculate chunk size
+	URL               string           // Download URL
+	OutputPath        string           // Output file path
+	FailedChunksJason string           // Failed chunks record file
+	ChunkSize         int64            // Size of each chunk
+	FileSize          int64            // Size of file to download, -1 while unknown
+	MaxConcurrency    int              // Maximum concurrency
+	AutoConcurrency   bool             // Whether to pick MaxConcurrency from the CPUs and the overhead of requests on the link, ignoring MaxConcurrency
+	PrewarmConns      int              // Connections opened at the same time before the chunks of a concurrent download over HTTP, up to MaxConcurrency, 0 for none
+	RetryCount        int              // Retry count
+	EnableResume      bool             // Whether to support resume download
+	AutoChunk         bool             // Whether to auto chunk, if true, ignore ChunkSize and auto calculate chunk size
+	EnableDelta       bool             // Whether to update an existing file by transferring only changed blocks
+	VerifyChunks      bool             // Whether to verify every chunk against the chunk manifest of the server
+	ChunkManifest     string           // Chunk manifest file to verify every chunk against instead of the one of the server
+	PatchFrom         string           // Local older version of the file to build it from with a diff generated by the server
+	PatchBase         string           // Path of that version on the server, by default the file named like PatchFrom next to the download
+	DisableQUIC       bool             // Whether to use HTTP range requests even when the server offers the native protocol over QUIC
+	UDPRate           int64            // Target rate in bytes per second of the UDP blast mode of the native protocol, 0 to transfer chunks on QUIC streams
+	Multiplex         bool             // Whether to transfer all HTTP requests over a single HTTP/2 connection
+	MultiRange        int              // Chunks requested at once in a multipart/byteranges request over HTTP, 0 or 1 for one per request
+	OffsetResume      bool             // Whether to download over a single HTTP connection with one request of the bytes from the end of the partial file, for servers misbehaving under concurrent connections
+	WaitLock          bool             // Whether to wait for another process downloading to OutputPath to finish instead of failing
+	Compress          bool             // Whether to ask ezft servers to compress the chunks sent over HTTP
+	P2PListen         string           // Address serving completed chunks to other clients downloading the file, empty disables peer-assisted downloads
+	P2PAdvertise      string           // URL other clients reach the chunks at, by default the address the tracker sees
+	P2PSeedTime       time.Duration    // Time to keep serving chunks to other clients after the download completed
+	P2PRelay          bool             // Also serve the other clients through the relay of the server, for clients they cannot reach such as behind NAT
+	P2PPunch          bool             // Whether to connect to the other clients behind NAT by hole punching negotiated by the server before relaying, announcing from the port of P2PListen
+	NoProxyEnv        bool             // Whether to ignore the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, connecting directly
+	Socket            *sockopt.Options // TCP options of the connections to the server, nil for the system defaults
+	LogFile           string           // File the log of this download is also written to, appended to, empty for none
+	LogFormat         string           // Format of LogFile, logger.FormatConsole if empty
+	Trace             bool             // Whether to record the DNS, connect, TLS and first byte timings of every chunk requested over HTTP
+	Username          string           // Basic auth username
+	Password          string           // Basic auth password
 }
 
 // DefaultConfig default configuration
@@ -38,9 +86,31 @@ func DefaultConfig() *DownloadConfig {
 
 // Client download client
 type Client struct {
-	config     *DownloadConfig
-	httpClient *http.Client
-	logger     *zap.Logger
+	config      *DownloadConfig
+	httpClient  *http.Client
+	customHTTP  bool               // The http client was replaced, its transport may account traffic QUIC would bypass
+	limiter     *ratelimit.Limiter // Bandwidth limit, also applied to QUIC transfers
+	quic        *quicConn          // Native protocol connection of the current download, nil over HTTP
+	p2p         *swarm             // Peers of the current download, nil unless peer-assisted
+	e2eKey      *e2e.Key           // Key decrypting file contents encrypted by the server, nil if they are not
+	chunkSums   *manifest.Chunks   // Hashes every chunk is verified against, nil unless verifying
+	verified    *chunkBitmap       // Chunks of the current download verified against chunkSums
+	out         io.Writer          // Messages and progress for the user
+	retries     atomic.Int64       // Transfers of chunks or of the whole file retried
+	downloaded  atomic.Int64       // Bytes of the file downloaded so far by a chunked download
+	chunked     atomic.Bool        // Whether the current download is chunked, its progress is then downloaded
+	hasher      *chunkHasher       // Hashes the file of the current chunked download as its chunks complete
+	batch       *writeBatcher      // Batches the writes of the small chunks of the current download, nil writes every chunk
+	journal     *journal           // Checkpoints the chunks left of the current download, nil without a record to keep
+	singleRange atomic.Bool        // Whether the server answered a multi-range request with the whole file
+	ifRange     string             // ETag the chunk requests of the current download are conditional on, "" for none
+	record      ChunkRecord        // Keeps the chunks left between runs, nil for the FailedChunksJason file
+	credentials CredentialsFunc    // Asked for credentials the server requires, nil to fail
+	summary     *Summary           // Provenance of the current or last download
+	probes      *ProbeCache        // Probes of servers shared with other clients, nil to probe every download
+	tracesMu    sync.Mutex
+	traces      []ChunkTrace // Timings of the chunk requests of the current or last download, with Trace
+	logger      *zap.Logger
 }
 
 // NewClient creates a new download client
@@ -49,91 +119,422 @@ func NewClient(config *DownloadConfig) *Client {
 		config = DefaultConfig()
 	}
 
-	transport := &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout:   5 * time.Second, // Connection establishment timeout
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
-		ResponseHeaderTimeout: 10 * time.Second, // Response header timeout
-	}
-
 	// Only set default FailedChunksJason if not already set
 	if config.FailedChunksJason == "" {
 		config.FailedChunksJason = config.OutputPath + ".failed_chunks.json"
 	}
+	// Refined by the calibration of the chunk size of large downloads
+	if config.AutoConcurrency {
+		config.MaxConcurrency = defaultConcurrency(runtime.NumCPU())
+	}
 
-	return &Client{
+	c := &Client{
 		config: config,
-		httpClient: &http.Client{
-			Transport: transport,
-		},
+		out:    os.Stdout,
+	}
+	c.httpClient = &http.Client{
+		Transport: c.newTransport(config.Multiplex, config.Multiplex),
+	}
+	return c
+}
+
+// ProxyFunc returns the proxy of the requests of transports: that of the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables as with curl
+// and wget, or none if ignoreEnv. The variables are read on every call,
+// rather than once per process as by http.ProxyFromEnvironment.
+func ProxyFunc(ignoreEnv bool) func(*http.Request) (*url.URL, error) {
+	if ignoreEnv {
+		return nil
+	}
+	proxy := httpproxy.FromEnvironment().ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
 	}
 }
 
+// newTransport creates the transport of a client. With http2 it speaks only
+// HTTP/2, over TLS or in cleartext (h2c) to the ezft server, and with
+// singleConn all requests share one connection to a host, as streams of it
+// over HTTP/2 and one after the other over HTTP/1.1.
+func (c *Client) newTransport(http2, singleConn bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second, // Connection establishment timeout
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 ProxyFunc(c.config.NoProxyEnv),
+		DialContext:           dialer.DialContext,
+		ResponseHeaderTimeout: 10 * time.Second, // Response header timeout
+		// Connections of concurrent chunks are kept for the next ones
+		MaxIdleConnsPerHost: max(c.config.MaxConcurrency, http.DefaultMaxIdleConnsPerHost),
+	}
+	if c.config.Socket != nil {
+		transport.DialContext = c.config.Socket.Dialer(dialer)
+	}
+	if http2 {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	if singleConn {
+		transport.MaxConnsPerHost = 1
+	}
+	return transport
+}
+
 func (c *Client) SetLogger(logger *zap.Logger) {
 	c.logger = logger
 }
 
-// Download executes download
+// SetOutput sets where messages and progress for the user are printed, the
+// standard output by default
+func (c *Client) SetOutput(w io.Writer) {
+	c.out = w
+}
+
+// SetHTTPClient replaces the http client, allowing connections to be shared between clients
+func (c *Client) SetHTTPClient(httpClient *http.Client) {
+	c.httpClient = httpClient
+	c.customHTTP = true
+}
+
+// SetRateLimiter limits the bandwidth of all transfers of the client by limiter
+func (c *Client) SetRateLimiter(limiter *ratelimit.Limiter) {
+	limited := *c.httpClient
+	limited.Transport = ratelimit.NewTransport(c.httpClient.Transport, limiter)
+	c.httpClient = &limited
+	c.limiter = limiter
+}
+
+// SetE2EKey decrypts file contents the server encrypted end to end with key,
+// downloads fail if the server does not encrypt them
+func (c *Client) SetE2EKey(key *e2e.Key) {
+	wrapped := *c.httpClient
+	wrapped.Transport = e2e.NewTransport(c.httpClient.Transport, key)
+	c.httpClient = &wrapped
+	c.e2eKey = key
+}
+
+// SetProbeCache shares the results of the probes of servers, whether they
+// answer range requests and offer QUIC, with the other clients of cache
+func (c *Client) SetProbeCache(cache *ProbeCache) {
+	c.probes = cache
+}
+
+// SetChunkRecord keeps the chunks left of interrupted downloads in record
+// instead of the FailedChunksJason file
+func (c *Client) SetChunkRecord(record ChunkRecord) {
+	c.record = record
+}
+
+// SetOutputPath sets the file the download is written to, moving the failed
+// chunks record along unless it was set apart
+func (c *Client) SetOutputPath(path string) {
+	if c.config.FailedChunksJason == c.config.OutputPath+".failed_chunks.json" {
+		c.config.FailedChunksJason = path + ".failed_chunks.json"
+	}
+	c.config.OutputPath = path
+}
+
+// SetCredentialsFunc sets the function asked for credentials when the server
+// refuses the download without any
+func (c *Client) SetCredentialsFunc(f CredentialsFunc) {
+	c.credentials = f
+}
+
+// canUseQUIC reports whether chunks may be transferred over QUIC: not when
+// the http client was replaced, file contents are encrypted end to end or
+// requests go through a proxy, which QUIC cannot traverse
+func (c *Client) canUseQUIC() bool {
+	if c.config.DisableQUIC || c.customHTTP || c.e2eKey != nil {
+		return false
+	}
+	if proxy := ProxyFunc(c.config.NoProxyEnv); proxy != nil {
+		u, err := url.Parse(c.config.URL)
+		if err != nil {
+			return false
+		}
+		if proxyURL, err := proxy(&http.Request{URL: u}); err != nil || proxyURL != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequest creates a request carrying the client User-Agent and credentials
+func (c *Client) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set User-Agent
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; ezft/1.0)")
+	if c.config.Username != "" || c.config.Password != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	return req, nil
+}
+
+// Download executes download, also logging it to the log file of the download
+// if any
 func (c *Client) Download(ctx context.Context) error {
+	c.summary = &Summary{URL: c.config.URL, Path: c.config.OutputPath, Concurrency: 1, Started: time.Now()}
+	c.chunked.Store(false)
+	c.verified = nil
+	defer c.finishSummary()
+	c.tracesMu.Lock()
+	c.traces = nil
+	c.tracesMu.Unlock()
+	lock, err := c.lockOutput(ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if c.config.LogFile == "" {
+		return c.download(ctx)
+	}
+	l, closeLog, err := logger.Tee(c.logger, c.config.LogFile, c.config.LogFormat)
+	if err != nil {
+		return fmt.Errorf("failed to open download log: %w", err)
+	}
+	defer closeLog()
+	saved := c.logger
+	c.logger = l
+	defer func() { c.logger = saved }()
+
+	c.logger.Info("Download started",
+		zap.String("url", c.config.URL),
+		zap.String("output", c.config.OutputPath),
+	)
+	start := time.Now()
+	err = c.download(ctx)
+	if err != nil {
+		c.logger.Warn("Download failed",
+			zap.String("url", c.config.URL),
+			zap.Duration("duration", time.Since(start)),
+			zap.Int64("retries", c.Retries()),
+			zap.Error(err),
+		)
+		return err
+	}
+	c.logger.Info("Download finished",
+		zap.Duration("duration", time.Since(start)),
+		zap.Int64("retries", c.Retries()),
+	)
+	return nil
+}
+
+func (c *Client) download(ctx context.Context) error {
 	// Get file information
 	fileSize, supportsRange, err := c.getFileInfo(ctx)
+	if err != nil && c.config.Multiplex && !c.customHTTP {
+		// Servers without HTTP/2 still get all requests on one connection
+		c.logger.Info("HTTP/2 unavailable, multiplexing over a single HTTP/1.1 connection",
+			zap.Error(err),
+		)
+		c.httpClient.Transport = c.newTransport(false, true)
+		if c.limiter != nil {
+			c.httpClient.Transport = ratelimit.NewTransport(c.httpClient.Transport, c.limiter)
+		}
+		if c.e2eKey != nil {
+			c.httpClient.Transport = e2e.NewTransport(c.httpClient.Transport, c.e2eKey)
+		}
+		fileSize, supportsRange, err = c.getFileInfo(ctx)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get file information: %w", err)
 	}
 
 	c.config.FileSize = fileSize
-	c.logger.Info("",
-		zap.String("msg", "retrieve file information"),
+	c.summary.Strategy = StrategyBasic
+	c.logger.Info("retrieve file information",
 		zap.Int64("fileSize", fileSize),
 		zap.Bool("supportRange", supportsRange),
 	)
 
+	if fileSize < 0 {
+		return c.streamDownload(ctx)
+	}
+
 	// Check if partial download file already exists
 	existingSize, err := c.getExistingFileSize()
 	if err != nil {
 		return fmt.Errorf("failed to check existing file: %w", err)
 	}
 
+	// Build the file from an older version and the diff the server generates
+	if c.config.PatchFrom != "" {
+		err := c.patchDownload(ctx)
+		if err == nil {
+			c.summary.Strategy = StrategyPatch
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		c.logger.Info("Patch download failed, downloading the whole file",
+			zap.Error(err),
+		)
+	}
+
+	// Update a changed local copy by transferring only the differences
+	if c.config.EnableDelta && existingSize > 0 {
+		err := c.deltaDownload(ctx)
+		if err == nil {
+			c.summary.Strategy = StrategyDelta
+			return nil
+		}
+		if !errors.Is(err, errDeltaUnsupported) {
+			return fmt.Errorf("delta download failed: %w", err)
+		}
+		c.logger.Info("Delta transfer unsupported, falling back to normal download")
+	}
+
+	// Verify every chunk, a file of the right size may still have holes
+	if c.config.VerifyChunks || c.config.ChunkManifest != "" {
+		if err := c.loadChunkManifest(ctx, fileSize); err != nil {
+			return fmt.Errorf("failed to load chunk manifest: %w", err)
+		}
+	}
+
 	// If file is already completely downloaded
-	if existingSize == fileSize {
-		fmt.Printf("File already completely downloaded: %s\n", c.config.OutputPath)
+	if existingSize == fileSize && c.chunkSums == nil && !c.hasFailedChunks() {
+		fmt.Fprintf(c.out, "File already completely downloaded: %s\n", c.config.OutputPath)
+		c.summary.Strategy = StrategyComplete
 		return nil
 	}
 
 	// Determine download strategy
+	if supportsRange && c.config.EnableResume && c.config.OffsetResume && c.chunkSums == nil {
+		// A single connection, neither QUIC nor peers
+		if err := c.replan(ctx, fileSize, c.offsetDownload); err != nil {
+			return err
+		}
+		c.summary.Strategy = StrategyOffset
+		c.summary.Transport = "http"
+		os.Remove(c.etagPath())
+		return nil
+	}
 	if supportsRange && c.config.EnableResume {
+		// Transfer the chunks over QUIC from ezft servers offering it
+		if c.canUseQUIC() {
+			if err := c.connectQUIC(ctx); err != nil {
+				c.logger.Info("QUIC unavailable, downloading over HTTP",
+					zap.Error(err),
+				)
+			} else {
+				defer c.closeQUIC()
+			}
+		}
+		if c.quic == nil {
+			c.tryCompression(ctx)
+		}
+
+		// Exchange chunks with other clients downloading the file, which
+		// would send them unencrypted
+		if c.config.P2PListen != "" && c.e2eKey == nil {
+			if err := c.startP2P(ctx); err != nil {
+				c.logger.Info("P2P unavailable, downloading from the server only",
+					zap.Error(err),
+				)
+			} else {
+				defer c.stopP2P()
+			}
+		}
+
 		// Support resume download, use chunked download
-		return c.downloadWithResume(ctx, fileSize)
+		if err := c.replan(ctx, fileSize, c.downloadWithResume); err != nil {
+			return err
+		}
+		c.setChunkLayout(fileSize)
+		os.Remove(c.etagPath())
+		if c.quic != nil {
+			os.Remove(c.tokenPath())
+		}
+		if c.p2p != nil {
+			c.seed(ctx, fileSize)
+		}
+		return nil
 	}
 
 	// Basic download, no concurrency, no resume support
-	c.logger.Debug("", zap.String("msg", "Starting basic download"))
-	return c.BasicDownload(ctx)
+	c.logger.Debug("Starting basic download")
+	c.tryCompression(ctx)
+	if err := c.BasicDownload(ctx); err != nil {
+		return err
+	}
+	return c.verifyFile()
 }
 
-// getFileInfo gets file information
-func (c *Client) getFileInfo(ctx context.Context) (int64, bool, error) {
-	req, err := http.NewRequestWithContext(ctx, "HEAD", c.config.URL, nil)
-	if err != nil {
-		return 0, false, err
+// replan runs download, and runs it again when its plan turned out wrong:
+// from the start when the partial output file it resumed is of an older
+// version of the remote file, and for the size of the file the server reports
+// in Content-Range when it differs from that of HEAD, as with some CDNs
+func (c *Client) replan(ctx context.Context, fileSize int64, download func(context.Context, int64) error) error {
+	err := download(ctx, fileSize)
+	if errors.Is(err, errRemoteChanged) && c.ifRange != c.summary.ETag {
+		c.logger.Info("remote file changed, restarting download",
+			zap.String("path", c.config.OutputPath),
+		)
+		if err = c.discardPartial(); err == nil {
+			err = download(ctx, fileSize)
+		}
+	}
+	var mismatch *sizeMismatchError
+	if errors.As(err, &mismatch) {
+		c.logger.Warn("Content-Range and HEAD disagree on the file size, trusting Content-Range",
+			zap.Int64("head", mismatch.planned),
+			zap.Int64("contentRange", mismatch.reported),
+		)
+		if err = c.resize(mismatch.reported); err == nil {
+			err = download(ctx, mismatch.reported)
+		}
 	}
+	return err
+}
 
-	// Set User-Agent
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; ezft/1.0)")
+// Retries returns the number of chunk or whole file transfers retried by the
+// downloads of the client
+func (c *Client) Retries() int64 {
+	return c.retries.Load()
+}
 
-	resp, err := c.httpClient.Do(req)
+// getFileInfo gets file information
+func (c *Client) getFileInfo(ctx context.Context) (int64, bool, error) {
+	resp, err := c.head(ctx)
 	if err != nil {
 		return 0, false, err
 	}
 	defer resp.Body.Close()
 
+	if err := checkAuth(resp); err != nil {
+		return 0, false, err
+	}
 	if resp.StatusCode != http.StatusOK {
 		return 0, false, fmt.Errorf("server returned error status: %d", resp.StatusCode)
 	}
+	encrypted := resp.Header.Get(e2e.Header) != ""
+	if c.e2eKey != nil && !encrypted {
+		return 0, false, errors.New("server does not encrypt file contents end to end")
+	}
+	if c.e2eKey == nil && encrypted {
+		return 0, false, errors.New("server encrypts file contents end to end, a key is required")
+	}
+
+	// Kept in the summary of downloads for later conditional requests
+	if c.summary != nil {
+		c.summary.ETag = resp.Header.Get("ETag")
+		c.summary.LastModified = resp.Header.Get("Last-Modified")
+	}
 
-	// Get file size
+	// Get file size, unknown for resources generated as they are sent with
+	// chunked transfer encoding, which are downloaded whole
 	contentLength := resp.Header.Get("Content-Length")
+	if contentLength == "" {
+		c.config.FileSize = -1
+		return -1, false, nil
+	}
 	fileSize, err := strconv.ParseInt(contentLength, 10, 64)
 	if err != nil {
 		return 0, false, fmt.Errorf("unable to parse file size: %s", contentLength)
@@ -147,13 +548,17 @@ func (c *Client) getFileInfo(ctx context.Context) (int64, bool, error) {
 		return fileSize, true, nil
 	}
 
-	// Method 2: Check if Range requests are supported
-	req, err = http.NewRequestWithContext(ctx, "GET", c.config.URL, nil)
+	// Method 2: Check if Range requests are supported, once per server
+	if c.probes != nil {
+		if p, ok := c.probes.get(c.config.URL); ok && p.Ranges != nil {
+			return fileSize, *p.Ranges, nil
+		}
+	}
+	req, err := c.newRequest(ctx, "GET", c.config.URL, nil)
 	if err != nil {
 		return 0, false, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Range", "bytes=0-0") // Request first byte
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; ezft/1.0)")
 
 	resp2, err := c.httpClient.Do(req)
 	if err != nil {
@@ -162,11 +567,186 @@ func (c *Client) getFileInfo(ctx context.Context) (int64, bool, error) {
 	defer resp2.Body.Close()
 
 	// Check if status code is 206
-	if resp2.StatusCode == http.StatusPartialContent {
-		return fileSize, true, nil
+	supportsRange := resp2.StatusCode == http.StatusPartialContent
+	if size, ok := contentRangeSize(resp2.Header.Get("Content-Range")); supportsRange && ok && size != fileSize {
+		// The ranges are what gets downloaded
+		c.logger.Warn("Content-Range and HEAD disagree on the file size, trusting Content-Range",
+			zap.Int64("head", fileSize),
+			zap.Int64("contentRange", size),
+		)
+		fileSize = size
+		c.config.FileSize = size
+	}
+	if c.probes != nil {
+		if err := c.probes.update(c.config.URL, func(p *ServerProbes) { p.Ranges = &supportsRange }); err != nil {
+			c.logger.Debug("Failed to save the probe cache", zap.Error(err))
+		}
+	}
+	return fileSize, supportsRange, nil
+}
+
+// RemoteFilename returns the file name suggested by the Content-Disposition
+// header of the server, sanitized, or "" if it suggests none
+func (c *Client) RemoteFilename(ctx context.Context) (string, error) {
+	resp, err := c.head(ctx)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if err := checkAuth(resp); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server returned error status: %d", resp.StatusCode)
+	}
+	return utils.DispositionFilename(resp.Header.Get("Content-Disposition")), nil
+}
+
+// head asks the server for the headers of the file with a HEAD request, or
+// with headByGet if the server refuses the method
+func (c *Client) head(ctx context.Context) (*http.Response, error) {
+	resp, err := c.sendHead(ctx)
+	if err != nil || !headRefused(resp.StatusCode) {
+		return resp, err
+	}
+	resp.Body.Close()
+	c.logger.Debug("Server refused HEAD, asking with a range request",
+		zap.Int("status", resp.StatusCode),
+	)
+	return c.headByGet(ctx)
+}
+
+// headRefused reports whether a HEAD response status may be a refusal of the
+// method rather than of the file: 405 and 501, and 403 of URLs signed for GET
+// only such as presigned S3 URLs
+func headRefused(status int) bool {
+	return status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented || status == http.StatusForbidden
+}
+
+// headByGet stands in for a HEAD request with a GET of the first byte of the
+// file. A partial response is turned into the one HEAD would have had: status
+// 200, the size of the file from Content-Range in Content-Length and ranges
+// accepted. A full response of a server ignoring ranges is returned as is,
+// its body closed unread.
+func (c *Client) headByGet(ctx context.Context) (*http.Response, error) {
+	req, err := c.newRequest(ctx, "GET", c.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp, nil
+	}
+
+	resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+	resp.Header.Set("Accept-Ranges", "bytes")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	if size, ok := contentRangeSize(resp.Header.Get("Content-Range")); ok {
+		resp.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+		resp.ContentLength = size
+	}
+	resp.Header.Del("Content-Range")
+	return resp, nil
+}
+
+// sizeMismatchError is returned by range requests whose Content-Range tells a
+// size of the file other than the one the download is planned for
+type sizeMismatchError struct {
+	planned, reported int64
+}
+
+func (e *sizeMismatchError) Error() string {
+	return fmt.Sprintf("server reports a file of %d bytes in Content-Range, the download was planned for %d bytes", e.reported, e.planned)
+}
+
+// checkRangeTotal returns a sizeMismatchError if the complete length of a
+// Content-Range header differs from the size of the file being downloaded
+func (c *Client) checkRangeTotal(header string) error {
+	if size, ok := contentRangeSize(header); ok && c.config.FileSize > 0 && size != c.config.FileSize {
+		return &sizeMismatchError{planned: c.config.FileSize, reported: size}
+	}
+	return nil
+}
+
+// contentRangeSize returns the complete length of a Content-Range header such
+// as "bytes 0-0/1234", false if it is missing or unknown ("*")
+func contentRangeSize(header string) (int64, bool) {
+	unit, rest, ok := strings.Cut(header, " ")
+	if !ok || unit != "bytes" {
+		return 0, false
+	}
+	_, total, ok := strings.Cut(rest, "/")
+	if !ok {
+		return 0, false
 	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
 
-	return fileSize, false, nil
+// sendHead sends a HEAD request for the file, again with the credentials
+// asked if the server refuses it without any
+func (c *Client) sendHead(ctx context.Context) (*http.Response, error) {
+	req, err := c.newRequest(ctx, "HEAD", c.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var tracer *chunkTracer
+	if c.config.Trace {
+		tracer = newChunkTracer(InfoRequest)
+		req = req.WithContext(httptrace.WithClientTrace(ctx, tracer.clientTrace()))
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if tracer != nil {
+		c.addTrace(tracer.finish())
+	}
+	retry, err := askCredentials(c.credentials, resp, &c.config.Username, &c.config.Password)
+	if !retry && err == nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if req, err = c.newRequest(ctx, "HEAD", c.config.URL, nil); err != nil {
+		return nil, err
+	}
+	return c.httpClient.Do(req)
+}
+
+// askCredentials asks f for the credentials of the server of resp if it
+// refused a request sent without any, setting username and password. It
+// reports whether the request is to be sent again with them.
+func askCredentials(f CredentialsFunc, resp *http.Response, username, password *string) (bool, error) {
+	if f == nil || resp.StatusCode != http.StatusUnauthorized || *username != "" || *password != "" {
+		return false, nil
+	}
+	u, p, err := f(resp.Request.URL.Host)
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	*username, *password = u, p
+	return true, nil
+}
+
+// checkAuth returns an error wrapping ErrUnauthorized if the server refused
+// the request of resp for its credentials
+func checkAuth(resp *http.Response) error {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: server returned error status: %d", ErrUnauthorized, resp.StatusCode)
+	}
+	return nil
 }
 
 // getExistingFileSize gets the size of existing file
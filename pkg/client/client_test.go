@@ -1,16 +1,27 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/easzlab/ezft/pkg/e2e"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/server"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestNewClient(t *testing.T) {
@@ -121,6 +132,162 @@ func TestGetFileInfo(t *testing.T) {
 	}
 }
 
+func TestGetFileInfoHeadRefused(t *testing.T) {
+	content := strings.Repeat("x", 1000)
+	tests := []struct {
+		name        string
+		status      int
+		ranges      bool
+		wantSize    int64
+		wantRange   bool
+		wantRequest int32
+	}{
+		{"method not allowed", http.StatusMethodNotAllowed, true, 1000, true, 2},
+		{"not implemented", http.StatusNotImplemented, true, 1000, true, 2},
+		{"signed for get", http.StatusForbidden, true, 1000, true, 2},
+		{"ranges ignored", http.StatusMethodNotAllowed, false, 1000, false, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if r.Method == http.MethodHead {
+					w.WriteHeader(tt.status)
+					return
+				}
+				if !tt.ranges {
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					io.WriteString(w, content)
+					return
+				}
+				http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(content))
+			}))
+			defer server.Close()
+
+			client := NewClient(&DownloadConfig{URL: server.URL + "/file.bin"})
+			client.SetLogger(zap.NewNop())
+			size, supportsRange, err := client.getFileInfo(context.Background())
+			if err != nil {
+				t.Fatalf("getFileInfo() error = %v", err)
+			}
+			if size != tt.wantSize || supportsRange != tt.wantRange {
+				t.Errorf("getFileInfo() = %d, %v, want %d, %v", size, supportsRange, tt.wantSize, tt.wantRange)
+			}
+			if n := requests.Load(); n != tt.wantRequest {
+				t.Errorf("%d requests, want %d", n, tt.wantRequest)
+			}
+		})
+	}
+}
+
+func TestRemoteFilename(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/named" {
+			w.Header().Set("Content-Disposition", `attachment; filename*=UTF-8''%E6%96%87%E4%BB%B6.iso`)
+		}
+		w.Header().Set("Content-Length", "0")
+	}))
+	defer server.Close()
+
+	for path, want := range map[string]string{"/named": "文件.iso", "/plain": ""} {
+		client := NewClient(&DownloadConfig{URL: server.URL + path, OutputPath: "download"})
+		client.SetLogger(zap.NewNop())
+		name, err := client.RemoteFilename(context.Background())
+		if err != nil {
+			t.Fatalf("RemoteFilename() error = %v", err)
+		}
+		if name != want {
+			t.Errorf("RemoteFilename() of %s = %q, want %q", path, name, want)
+		}
+
+		client.SetOutputPath(name)
+		if got := client.config.FailedChunksJason; got != name+".failed_chunks.json" {
+			t.Errorf("FailedChunksJason = %q after SetOutputPath(%q)", got, name)
+		}
+	}
+}
+
+func TestContentRangeSize(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+		ok     bool
+	}{
+		{"bytes 0-0/1234", 1234, true},
+		{"bytes 100-199/200", 200, true},
+		{"bytes 0-0/*", 0, false},
+		{"items 0-0/10", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := contentRangeSize(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("contentRangeSize(%q) = %d, %v, want %d, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDownloadContentRangeSize(t *testing.T) {
+	content := make([]byte, 8000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	tests := []struct {
+		name         string
+		headSize     int
+		acceptRanges bool
+		concurrency  int
+		offsetResume bool
+	}{
+		{"head smaller", 6000, true, 1, false},
+		{"head larger", 10000, true, 1, false},
+		{"head larger concurrent", 10000, true, 4, false},
+		{"head smaller concurrent", 5500, true, 4, false},
+		{"probed", 6000, false, 4, false},
+		{"offset resume", 6000, true, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A CDN whose HEAD responses disagree with the ranges it serves
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.Header().Set("Content-Length", strconv.Itoa(tt.headSize))
+					if tt.acceptRanges {
+						w.Header().Set("Accept-Ranges", "bytes")
+					}
+					return
+				}
+				http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+			}))
+			defer ts.Close()
+
+			output := filepath.Join(t.TempDir(), "data.bin")
+			client := NewClient(&DownloadConfig{
+				URL:               ts.URL + "/data.bin",
+				OutputPath:        output,
+				FailedChunksJason: output + ".failed_chunks.json",
+				ChunkSize:         1000,
+				MaxConcurrency:    tt.concurrency,
+				EnableResume:      true,
+				OffsetResume:      tt.offsetResume,
+			})
+			client.SetLogger(zap.NewNop())
+			client.SetOutput(io.Discard)
+			if err := client.Download(context.Background()); err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+				t.Errorf("Downloaded %d bytes, want the %d of Content-Range", len(got), len(content))
+			}
+			if s := client.Summary(); s.Size != int64(len(content)) {
+				t.Errorf("Summary().Size = %d, want %d", s.Size, len(content))
+			}
+		})
+	}
+}
+
 func TestGetFileInfoError(t *testing.T) {
 	// Test with invalid URL - use a more reliable invalid URL that fails quickly
 	config := &DownloadConfig{
@@ -224,6 +391,44 @@ func TestGetProgress(t *testing.T) {
 	}
 }
 
+func TestGetProgressChunked(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "progress_chunked.txt")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	config := &DownloadConfig{
+		URL:        server.URL + "/test.txt",
+		OutputPath: testFile,
+		FileSize:   40,
+	}
+	client := NewClient(config)
+	client.SetLogger(zap.NewNop())
+	client.chunked.Store(true)
+
+	file, err := os.Create(testFile)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	defer file.Close()
+
+	// The last chunk makes the file full size, only a quarter is downloaded
+	if err := client.downloadChunk(context.Background(), file, Chunk{Index: 3, Start: 30, End: 39}); err != nil {
+		t.Fatalf("downloadChunk() error = %v", err)
+	}
+	progress, err := client.GetProgress()
+	if err != nil {
+		t.Fatalf("GetProgress() error = %v", err)
+	}
+	if progress != 25 {
+		t.Errorf("Expected progress 25.00%%, got %.2f%%", progress)
+	}
+}
+
 func TestDownloadBasic(t *testing.T) {
 	tempDir := t.TempDir()
 	testFile := filepath.Join(tempDir, "download_test.txt")
@@ -267,6 +472,71 @@ func TestDownloadBasic(t *testing.T) {
 	}
 }
 
+func TestDownloadLogFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "missing.txt.log")
+	client := NewClient(&DownloadConfig{
+		URL:        server.URL + "/missing.txt",
+		OutputPath: filepath.Join(dir, "missing.txt"),
+		LogFile:    logFile,
+	})
+	client.SetLogger(zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()), zapcore.AddSync(io.Discard), zap.InfoLevel)))
+	if err := client.Download(context.Background()); err == nil {
+		t.Fatal("Download() of a missing file succeeded")
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Download started", "/missing.txt", "Download failed", "404"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Download log lacks %q:\n%s", want, data)
+		}
+	}
+
+	client.config.LogFile = filepath.Join(dir, "missing", "missing.txt.log")
+	if err := client.Download(context.Background()); err == nil || !strings.Contains(err.Error(), "download log") {
+		t.Errorf("Download() error = %v without log directory", err)
+	}
+}
+
+func TestDownloadWithRateLimiter(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "limited.bin")
+	testContent := strings.Repeat("x", 48*1024)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "limited.bin", time.Time{}, strings.NewReader(testContent))
+	}))
+	defer server.Close()
+
+	client := NewClient(&DownloadConfig{
+		URL:        server.URL + "/limited.bin",
+		OutputPath: testFile,
+	})
+	client.SetLogger(zap.NewNop())
+	// 32KB/s with a one second burst, the remaining 16KB take about half a second
+	client.SetRateLimiter(ratelimit.NewLimiter(ratelimit.NewSchedule(32 * 1024)))
+
+	start := time.Now()
+	if err := client.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected download to be limited, took %v", elapsed)
+	}
+
+	content, err := os.ReadFile(testFile)
+	if err != nil || string(content) != testContent {
+		t.Errorf("Downloaded content mismatch (%v)", err)
+	}
+}
+
 func TestDownloadWithContext(t *testing.T) {
 	tempDir := t.TempDir()
 	testFile := filepath.Join(tempDir, "context_test.txt")
@@ -345,3 +615,244 @@ func TestDownloadAlreadyComplete(t *testing.T) {
 		t.Errorf("File content changed unexpectedly. Expected %q, got %q", testContent, string(content))
 	}
 }
+
+func TestDownloadMultiplex(t *testing.T) {
+	serverDir := t.TempDir()
+	content := strings.Repeat("multiplexed chunk data ", 50000)
+	if err := os.WriteFile(filepath.Join(serverDir, "data.bin"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, h2c := range []bool{true, false} {
+		var conns, http2 atomic.Int32
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// HTTP/1.1 servers hand the h2c connection preface to handlers
+			if r.ProtoMajor == 2 && r.Method != "PRI" {
+				http2.Add(1)
+			}
+			http.FileServer(http.Dir(serverDir)).ServeHTTP(w, r)
+		}))
+		if h2c {
+			ts.Config.Protocols = server.Protocols()
+		}
+		ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				conns.Add(1)
+			}
+		}
+		ts.Start()
+		defer ts.Close()
+
+		outputPath := filepath.Join(t.TempDir(), "data.bin")
+		client := NewClient(&DownloadConfig{
+			URL:            ts.URL + "/data.bin",
+			OutputPath:     outputPath,
+			ChunkSize:      64 * 1024,
+			MaxConcurrency: 8,
+			RetryCount:     1,
+			EnableResume:   true,
+			Multiplex:      true,
+		})
+		client.SetLogger(zap.NewNop())
+		if err := client.Download(context.Background()); err != nil {
+			t.Fatalf("Download() error = %v (h2c: %v)", err, h2c)
+		}
+		if got, _ := os.ReadFile(outputPath); string(got) != content {
+			t.Errorf("Downloaded file differs (h2c: %v)", h2c)
+		}
+
+		// Without HTTP/2 the failed h2c attempt costs one more connection
+		wantConns := int32(1)
+		if !h2c {
+			wantConns = 2
+		}
+		if n := conns.Load(); n != wantConns {
+			t.Errorf("%d connections, want %d (h2c: %v)", n, wantConns, h2c)
+		}
+		if n := http2.Load(); (n > 0) != h2c {
+			t.Errorf("%d HTTP/2 requests (h2c: %v)", n, h2c)
+		}
+	}
+}
+
+func TestDownloadE2E(t *testing.T) {
+	serverDir := t.TempDir()
+	content := strings.Repeat("end to end encrypted chunk ", 40000)
+	if err := os.WriteFile(filepath.Join(serverDir, "data.bin"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	key, err := e2e.ParseKey("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.NewServer(serverDir, 0)
+	srv.SetLogger(zap.NewNop())
+	srv.SetE2EKey(key)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	download := func(key *e2e.Key, concurrency int, delta bool) (string, error) {
+		outputPath := filepath.Join(t.TempDir(), "data.bin")
+		if delta {
+			os.WriteFile(outputPath, []byte("stale "+content[:len(content)/2]), 0644)
+		}
+		client := NewClient(&DownloadConfig{
+			URL:            ts.URL + "/data.bin",
+			OutputPath:     outputPath,
+			ChunkSize:      100 * 1024,
+			MaxConcurrency: concurrency,
+			RetryCount:     1,
+			EnableResume:   true,
+			EnableDelta:    delta,
+		})
+		client.SetLogger(zap.NewNop())
+		if key != nil {
+			client.SetE2EKey(key)
+		}
+		if err := client.Download(context.Background()); err != nil {
+			return "", err
+		}
+		got, _ := os.ReadFile(outputPath)
+		return string(got), nil
+	}
+
+	for _, tt := range []struct {
+		concurrency int
+		delta       bool
+	}{{1, false}, {4, false}, {1, true}} {
+		got, err := download(key, tt.concurrency, tt.delta)
+		if err != nil {
+			t.Fatalf("Download() error = %v (concurrency %d, delta %v)", err, tt.concurrency, tt.delta)
+		}
+		if got != content {
+			t.Errorf("Decrypted file differs (concurrency %d, delta %v)", tt.concurrency, tt.delta)
+		}
+	}
+
+	if _, err := download(nil, 1, false); err == nil {
+		t.Error("Download() without a key should fail")
+	}
+	other, _ := e2e.ParseKey("another sixteen char key")
+	if _, err := download(other, 1, false); !errors.Is(err, e2e.ErrKeyMismatch) {
+		t.Errorf("Download() with another key error = %v, want %v", err, e2e.ErrKeyMismatch)
+	}
+}
+
+func TestDownloadCredentialsFunc(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("protected ", 1000)
+	os.WriteFile(filepath.Join(dir, "data.bin"), []byte(content), 0644)
+	srv := server.NewServer(dir, 0)
+	srv.SetLogger(zap.NewNop())
+	srv.SetAuth("user", "secret")
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		password string
+		err      error
+		asked    int
+	}{
+		{"accepted", "secret", nil, 1},
+		{"refused", "wrong", ErrUnauthorized, 1},
+		{"failed", "", ErrUnauthorized, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "data.bin")
+			c := NewClient(&DownloadConfig{URL: ts.URL + "/data.bin", OutputPath: output, ChunkSize: 1024, MaxConcurrency: 2, EnableResume: true})
+			c.SetLogger(zap.NewNop())
+			var asked int
+			c.SetCredentialsFunc(func(host string) (string, string, error) {
+				asked++
+				if host != strings.TrimPrefix(ts.URL, "http://") {
+					t.Errorf("Credentials asked for %s", host)
+				}
+				if tt.password == "" {
+					return "", "", errors.New("no terminal")
+				}
+				return "user", tt.password, nil
+			})
+			err := c.Download(context.Background())
+			if !errors.Is(err, tt.err) || (err == nil) != (tt.err == nil) {
+				t.Fatalf("Download() error = %v, want %v", err, tt.err)
+			}
+			if asked != tt.asked {
+				t.Errorf("Credentials asked %d times, want %d", asked, tt.asked)
+			}
+			if err == nil {
+				if got, _ := os.ReadFile(output); string(got) != content {
+					t.Error("Downloaded file differs")
+				}
+			}
+		})
+	}
+
+	// Credentials given are not replaced
+	c := NewClient(&DownloadConfig{URL: ts.URL + "/data.bin", OutputPath: filepath.Join(dir, "out.bin"), Username: "user", Password: "wrong"})
+	c.SetLogger(zap.NewNop())
+	c.SetCredentialsFunc(func(string) (string, string, error) {
+		t.Error("Credentials asked although given")
+		return "", "", nil
+	})
+	if err := c.Download(context.Background()); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Download() error = %v, want %v", err, ErrUnauthorized)
+	}
+}
+
+func TestDownloadProxyEnv(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("proxied ", 1000)
+	os.WriteFile(filepath.Join(dir, "data.bin"), []byte(content), 0644)
+	srv := server.NewServer(dir, 0)
+	srv.SetLogger(zap.NewNop())
+	// The proxy serves the files itself, of a host that does not resolve
+	var proxied atomic.Int64
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "files.example.invalid" || !r.URL.IsAbs() {
+			t.Errorf("Proxy got %s %s", r.Host, r.URL)
+		}
+		proxied.Add(1)
+		srv.Handler().ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	download := func(noProxyEnv bool) (*Client, error) {
+		c := NewClient(&DownloadConfig{
+			URL:            "http://files.example.invalid/data.bin",
+			OutputPath:     filepath.Join(t.TempDir(), "data.bin"),
+			ChunkSize:      1024,
+			MaxConcurrency: 2,
+			RetryCount:     0,
+			EnableResume:   true,
+			NoProxyEnv:     noProxyEnv,
+		})
+		c.SetLogger(zap.NewNop())
+		c.SetOutput(io.Discard)
+		return c, c.Download(context.Background())
+	}
+	c, err := download(false)
+	if err != nil {
+		t.Fatalf("Download() error = %v through the proxy", err)
+	}
+	if got, _ := os.ReadFile(c.config.OutputPath); string(got) != content {
+		t.Error("Downloaded file differs")
+	}
+	if proxied.Load() == 0 {
+		t.Error("No request went through the proxy")
+	}
+	if c.canUseQUIC() {
+		t.Error("QUIC must not be used through a proxy")
+	}
+
+	proxied.Store(0)
+	if _, err := download(true); err == nil || proxied.Load() != 0 {
+		t.Errorf("Download() error = %v with %d proxied requests, want a direct connection", err, proxied.Load())
+	}
+	t.Setenv("NO_PROXY", ".example.invalid")
+	if _, err := download(false); err == nil || proxied.Load() != 0 {
+		t.Errorf("Download() error = %v with %d proxied requests, want NO_PROXY honored", err, proxied.Load())
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/easzlab/ezft/pkg/compress"
+	"go.uber.org/zap"
+)
+
+// errCompressionUnsupported is returned when the server does not offer compression
+var errCompressionUnsupported = errors.New("server does not support compression")
+
+// enableCompression runs the compression handshake with the server, asking
+// for the chunks of the download to be compressed when the file is worth it
+func (c *Client) enableCompression(ctx context.Context) error {
+	handshakeURL, err := c.apiURL("compression")
+	if err != nil {
+		return err
+	}
+	req, err := c.newRequest(ctx, "GET", handshakeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errCompressionUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned error status: %d", resp.StatusCode)
+	}
+	var offer compress.Offer
+	if err := json.NewDecoder(resp.Body).Decode(&offer); err != nil {
+		return fmt.Errorf("invalid compression offer: %w", err)
+	}
+
+	if !offer.Compressible {
+		c.logger.Info("Downloading without compression",
+			zap.String("reason", offer.Reason),
+		)
+		return nil
+	}
+	supported := false
+	for _, codec := range offer.Codecs {
+		supported = supported || codec == compress.Zstd
+	}
+	if !supported || compress.DictionaryID(offer.Dictionary) != offer.DictionaryID {
+		return errCompressionUnsupported
+	}
+
+	u, err := url.Parse(c.config.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	wrapped := *c.httpClient
+	wrapped.Transport = compress.NewTransport(c.httpClient.Transport, u, offer.Dictionary)
+	c.httpClient = &wrapped
+	c.logger.Info("Compressing chunks",
+		zap.String("codec", compress.Zstd),
+		zap.String("dictionary", offer.DictionaryID),
+	)
+	return nil
+}
+
+// tryCompression enables compression when configured, downloading as is
+// when the handshake fails
+func (c *Client) tryCompression(ctx context.Context) {
+	if !c.config.Compress {
+		return
+	}
+	if err := c.enableCompression(ctx); err != nil {
+		c.logger.Info("Compression unavailable, downloading without it",
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/e2e"
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+)
+
+// countingWriter counts the body bytes sent by a handler
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w countingWriter) Write(b []byte) (int, error) {
+	w.n.Add(int64(len(b)))
+	return w.ResponseWriter.Write(b)
+}
+
+func TestDownloadCompressed(t *testing.T) {
+	serverDir := t.TempDir()
+	content := strings.Repeat("2026-10-16 12:00:00 INFO request served\n", 30000)
+	for _, name := range []string{"app.log", "app.log.gz"} {
+		if err := os.WriteFile(filepath.Join(serverDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key, _ := e2e.ParseKey("0123456789abcdef")
+
+	for _, tt := range []struct {
+		name     string
+		key      *e2e.Key
+		basic    bool
+		wantSent bool // Whether the file is sent compressed
+	}{
+		{name: "app.log", wantSent: true},
+		{name: "app.log", key: key, wantSent: true},
+		{name: "app.log", basic: true, wantSent: true},
+		{name: "app.log.gz"},
+	} {
+		srv := server.NewServer(serverDir, 0)
+		srv.SetLogger(zap.NewNop())
+		srv.SetCompressionEnabled(true)
+		if tt.key != nil {
+			srv.SetE2EKey(tt.key)
+		}
+		handler := srv.Handler()
+		var sent atomic.Int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/"+tt.name && r.Method == http.MethodGet {
+				w = countingWriter{ResponseWriter: w, n: &sent}
+			}
+			handler.ServeHTTP(w, r)
+		}))
+
+		outputPath := filepath.Join(t.TempDir(), tt.name)
+		client := NewClient(&DownloadConfig{
+			URL:            ts.URL + "/" + tt.name,
+			OutputPath:     outputPath,
+			ChunkSize:      256 * 1024,
+			MaxConcurrency: 4,
+			RetryCount:     1,
+			EnableResume:   !tt.basic,
+			Compress:       true,
+		})
+		client.SetLogger(zap.NewNop())
+		if tt.key != nil {
+			client.SetE2EKey(tt.key)
+		}
+		if err := client.Download(context.Background()); err != nil {
+			t.Fatalf("Download() of %s error = %v", tt.name, err)
+		}
+		ts.Close()
+
+		if got, _ := os.ReadFile(outputPath); string(got) != content {
+			t.Errorf("Downloaded %s differs (e2e %v, basic %v)", tt.name, tt.key != nil, tt.basic)
+		}
+		if compressed := sent.Load() < int64(len(content))/10; compressed != tt.wantSent {
+			t.Errorf("%s: %d bytes sent for %d (e2e %v, basic %v)", tt.name, sent.Load(), len(content), tt.key != nil, tt.basic)
+		}
+	}
+}
@@ -7,69 +7,82 @@ import (
 	"sync"
 )
 
-// downloadChunksConcurrently downloads chunks concurrently
+// downloadChunksConcurrently downloads chunks concurrently, by a fixed pool of
+// MaxConcurrency workers taking them from a queue so that the goroutines and
+// memory used do not grow with the number of chunks. With multi-range
+// requests the workers take groups of chunks.
 func (c *Client) downloadChunksConcurrently(ctx context.Context, file *os.File, chunks []Chunk) error {
 	var wg sync.WaitGroup
-	errChan := make(chan error, len(chunks))
-	semaphore := make(chan struct{}, c.config.MaxConcurrency)
+	queue := make(chan []Chunk)
 
-	// Used to collect failed chunks
+	// Used to collect failed chunks and the first error
 	var failedChunksMutex sync.Mutex
 	var failedChunks []Chunk
+	var firstErr error
 
-	for _, chunk := range chunks {
-		// Control concurrency
-		semaphore <- struct{}{}
+	groups := c.groupChunks(chunks)
+	workers := max(min(c.config.MaxConcurrency, len(groups)), 1)
+	for range workers {
 		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range queue {
+				if failed, err := c.downloadGroup(ctx, file, group); err != nil {
+					// Record failed chunks
+					failedChunksMutex.Lock()
+					failedChunks = append(failedChunks, failed...)
+					if firstErr == nil {
+						firstErr = err
+					}
+					failedChunksMutex.Unlock()
+				}
+			}
+		}()
+	}
 
-		go func(ck Chunk) {
-			defer func() {
-				wg.Done()
-				<-semaphore
-			}()
-
-			if err := c.downloadChunk(ctx, file, ck); err != nil {
-				// Record failed chunk
-				failedChunksMutex.Lock()
-				failedChunks = append(failedChunks, ck)
-				failedChunksMutex.Unlock()
-
-				// Send error to channel
-				errChan <- fmt.Errorf("failed to download chunk %d: %w", ck.Index, err)
+	// Once interrupted, the groups not started are recorded failed for
+	// resuming without being requested
+feed:
+	for i, group := range groups {
+		select {
+		case queue <- group:
+		case <-ctx.Done():
+			failedChunksMutex.Lock()
+			for _, rest := range groups[i:] {
+				failedChunks = append(failedChunks, rest...)
+			}
+			if firstErr == nil {
+				firstErr = ctx.Err()
 			}
-		}(chunk)
+			failedChunksMutex.Unlock()
+			break feed
+		}
 	}
+	close(queue)
 
-	// Wait for all goroutines to complete
+	// Wait for all workers to complete
 	wg.Wait()
 
-	// Close error channel
-	close(errChan)
-
-	// Collect all errors
-	var errors []error
-	for err := range errChan {
-		errors = append(errors, err)
+	// Batched chunks whose write failed are downloaded again
+	if failed := c.flushBatch(); len(failed) > 0 {
+		failedChunks = append(failedChunks, failed...)
+		if firstErr == nil {
+			firstErr = fmt.Errorf("failed to write %d batched chunks", len(failed))
+		}
 	}
 
 	// If there are failed chunks, save record
 	if len(failedChunks) > 0 {
-		if err := c.saveFailedChunks(failedChunks); err != nil {
+		if err := c.recordFailedChunks(file, failedChunks); err != nil {
 			return fmt.Errorf("failed to save failed chunks record: %w", err)
 		}
 	}
 
 	// If there are errors, return the first error
-	if len(errors) > 0 {
-		return errors[0]
+	if firstErr != nil {
+		return firstErr
 	}
 
 	// All chunks downloaded successfully, delete failed chunks record file
-	if _, err := os.Stat(c.config.FailedChunksJason); err == nil {
-		if err := os.Remove(c.config.FailedChunksJason); err != nil {
-			return fmt.Errorf("failed to delete failed chunks record file: %w", err)
-		}
-	}
-
-	return nil
+	return c.removeFailedChunks(file)
 }
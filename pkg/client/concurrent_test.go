@@ -1,14 +1,20 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
+
+	"go.uber.org/zap"
 )
 
 func TestDownloadChunksConcurrently(t *testing.T) {
@@ -318,3 +324,118 @@ func TestDownloadChunksConcurrentlyEmptyChunks(t *testing.T) {
 		t.Fatalf("downloadChunksConcurrently() with empty chunks error = %v", err)
 	}
 }
+
+func TestDownloadChunksConcurrentlyWorkerPool(t *testing.T) {
+	const workers, count = 4, 400
+
+	var mutex sync.Mutex
+	active, peak := 0, 0
+	var goroutinePeak int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		active++
+		peak = max(peak, active)
+		goroutinePeak = max(goroutinePeak, runtime.NumGoroutine())
+		mutex.Unlock()
+		time.Sleep(time.Millisecond)
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, count*10))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(bytes.Repeat([]byte{'x'}, end-start+1))
+	}))
+	defer server.Close()
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "pool.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	client := NewClient(&DownloadConfig{
+		URL:            server.URL + "/pool.bin",
+		OutputPath:     file.Name(),
+		MaxConcurrency: workers,
+	})
+
+	before := runtime.NumGoroutine()
+	if err := client.downloadChunksConcurrently(context.Background(), file, splitChunks(0, count*10, 10)); err != nil {
+		t.Fatalf("downloadChunksConcurrently() error = %v", err)
+	}
+	if peak > workers {
+		t.Errorf("%d chunks downloaded at once, want at most %d", peak, workers)
+	}
+	// The workers and the connections of the test server, never a goroutine
+	// per chunk
+	if goroutinePeak > before+10*workers {
+		t.Errorf("%d goroutines while downloading %d chunks, %d before", goroutinePeak, count, before)
+	}
+}
+
+func TestDownloadChunksConcurrentlyCancelQueued(t *testing.T) {
+	const workers, count = 3, 1000
+
+	started := make(chan struct{}, count)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		// Never answered, the download is cancelled while requests wait
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "cancel.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	client := NewClient(&DownloadConfig{
+		URL:            server.URL + "/cancel.bin",
+		OutputPath:     file.Name(),
+		MaxConcurrency: workers,
+		RetryCount:     3,
+	})
+	client.SetLogger(zap.NewNop())
+
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.downloadChunksConcurrently(ctx, file, splitChunks(0, count*10, 10))
+	}()
+	for range workers {
+		<-started
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("downloadChunksConcurrently() error = %v, want cancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("downloadChunksConcurrently() did not return after cancel")
+	}
+
+	// Every chunk is left for resuming, none requested after the cancel
+	failed, err := client.loadFailedChunks()
+	if err != nil || len(failed) != count {
+		t.Errorf("recorded %d failed chunks, %v, want %d", len(failed), err, count)
+	}
+	if n := len(started); n > 0 {
+		t.Errorf("%d more chunks requested after the cancel", n)
+	}
+
+	// The workers are gone once it returned
+	client.httpClient.CloseIdleConnections()
+	server.CloseClientConnections()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left, %d before", n, before)
+	}
+}
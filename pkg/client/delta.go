@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/easzlab/ezft/pkg/delta"
+	"go.uber.org/zap"
+)
+
+// apiPrefix prefix of the ezft server specific endpoints
+const apiPrefix = "/_ezft/"
+
+// errDeltaUnsupported is returned when the server does not provide delta transfer
+var errDeltaUnsupported = errors.New("server does not support delta transfer")
+
+// apiURL builds the URL of an ezft endpoint for the configured download URL
+func (c *Client) apiURL(endpoint string) (string, error) {
+	u, err := url.Parse(c.config.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	u.Path = apiPrefix + endpoint + u.Path
+	u.RawPath = ""
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// deltaDownload updates the existing output file by transferring only the
+// blocks that differ from the remote file
+func (c *Client) deltaDownload(ctx context.Context) error {
+	base, err := os.Open(c.config.OutputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open existing file: %w", err)
+	}
+	defer base.Close()
+
+	blockSize := delta.BlockSizeFor(c.config.FileSize)
+	sig, err := delta.ComputeSignature(base, blockSize)
+	if err != nil {
+		return fmt.Errorf("failed to compute signature: %w", err)
+	}
+
+	body, err := json.Marshal(sig)
+	if err != nil {
+		return fmt.Errorf("failed to serialize signature: %w", err)
+	}
+
+	deltaURL, err := c.apiURL("delta")
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, "POST", deltaURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return errDeltaUnsupported
+	default:
+		return fmt.Errorf("delta request failed, status code: %d", resp.StatusCode)
+	}
+
+	// Rebuild into a temporary file so the existing file stays intact on failure
+	tmpPath := c.config.OutputPath + ".ezft.tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	written, err := delta.Apply(base, blockSize, resp.Body, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to apply delta: %w", err)
+	}
+
+	base.Close()
+	if err := os.Rename(tmpPath, c.config.OutputPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+
+	c.logger.Info("Delta download completed",
+		zap.Int64("written", written),
+	)
+	return nil
+}
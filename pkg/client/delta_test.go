@@ -0,0 +1,105 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+)
+
+func TestApiURL(t *testing.T) {
+	client := NewClient(&DownloadConfig{URL: "http://example.com:8080/dir/file.iso?x=1"})
+
+	got, err := client.apiURL("delta")
+	if err != nil {
+		t.Fatalf("apiURL() error = %v", err)
+	}
+	if want := "http://example.com:8080/_ezft/delta/dir/file.iso"; got != want {
+		t.Errorf("apiURL() = %s, want %s", got, want)
+	}
+}
+
+func TestDeltaDownload(t *testing.T) {
+	serverDir := t.TempDir()
+	clientDir := t.TempDir()
+
+	// Local copy is an older version of the remote file
+	oldContent := make([]byte, 512*1024)
+	rand.New(rand.NewSource(1)).Read(oldContent)
+	newContent := append([]byte("new header"), oldContent...)
+	copy(newContent[300*1024:], bytes.Repeat([]byte("x"), 4096))
+
+	if err := os.WriteFile(filepath.Join(serverDir, "data.bin"), newContent, 0644); err != nil {
+		t.Fatalf("Failed to create server file: %v", err)
+	}
+	outputPath := filepath.Join(clientDir, "data.bin")
+	if err := os.WriteFile(outputPath, oldContent, 0644); err != nil {
+		t.Fatalf("Failed to create local file: %v", err)
+	}
+
+	srv := server.NewServer(serverDir, 0)
+	srv.SetLogger(zap.NewNop())
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	client := NewClient(&DownloadConfig{
+		URL:          ts.URL + "/data.bin",
+		OutputPath:   outputPath,
+		ChunkSize:    64 * 1024,
+		RetryCount:   1,
+		EnableResume: true,
+		EnableDelta:  true,
+	})
+	client.SetLogger(zap.NewNop())
+
+	if err := client.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	got, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(got, newContent) {
+		t.Error("Downloaded content does not match remote file")
+	}
+	if _, err := os.Stat(outputPath + ".ezft.tmp"); !os.IsNotExist(err) {
+		t.Error("Temporary file should be removed")
+	}
+}
+
+func TestDeltaDownloadUnsupported(t *testing.T) {
+	content := []byte("plain http server content")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/file.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "file.txt", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	outputPath := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(outputPath, []byte("stale"), 0644); err != nil {
+		t.Fatalf("Failed to create local file: %v", err)
+	}
+
+	client := NewClient(&DownloadConfig{
+		URL:         ts.URL + "/file.txt",
+		OutputPath:  outputPath,
+		EnableDelta: true,
+	})
+	client.SetLogger(zap.NewNop())
+
+	if err := client.deltaDownload(context.Background()); err != errDeltaUnsupported {
+		t.Errorf("Expected errDeltaUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrNoDigest is returned when the server provides no digest of a file
+var ErrNoDigest = errors.New("server provides no digest of the file")
+
+// digestAlgos algorithms of digest headers by preference, with the names of
+// utils.NewHash
+var digestAlgos = []struct{ header, algo string }{
+	{"sha-512", "sha512"},
+	{"sha-256", "sha256"},
+	{"sha", "sha1"},
+	{"md5", "md5"},
+}
+
+// Digest the hash of a remote file
+type Digest struct {
+	Algo   string // Hash algorithm, as accepted by utils.NewHash
+	Hash   string // Hex encoded hash
+	Size   int64  // Size of the file, -1 if unknown
+	Source string // Header or endpoint the digest came from
+}
+
+// RemoteDigest returns the digest of the remote file, from the Repr-Digest
+// (RFC 9530) or Digest (RFC 3230) header of its HEAD response, or else from
+// the hash endpoint of ezft servers
+func (c *Client) RemoteDigest(ctx context.Context) (*Digest, error) {
+	resp, err := c.head(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if err := checkAuth(resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned error status: %d", resp.StatusCode)
+	}
+	for _, header := range []string{"Repr-Digest", "Digest"} {
+		if d := parseDigest(resp.Header.Values(header)); d != nil {
+			d.Size, d.Source = resp.ContentLength, header
+			return d, nil
+		}
+	}
+
+	hashURL, err := c.apiURL("hash")
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, "GET", hashURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkAuth(resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrNoDigest
+	}
+	var result struct {
+		Algo string `json:"algo"`
+		Hash string `json:"hash"`
+		Size int64  `json:"size"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Hash == "" {
+		return nil, fmt.Errorf("invalid hash response: %v", err)
+	}
+	return &Digest{Algo: result.Algo, Hash: result.Hash, Size: result.Size, Source: "hash endpoint"}, nil
+}
+
+// parseDigest returns the preferred digest of the values of a digest header,
+// nil if none has a supported algorithm. Values are comma separated
+// algorithm=base64 pairs, the base64 being enclosed in colons in Repr-Digest.
+func parseDigest(values []string) *Digest {
+	hashes := make(map[string][]byte)
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			name, encoded, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				continue
+			}
+			// Parameters of structured fields are ignored
+			encoded, _, _ = strings.Cut(encoded, ";")
+			b, err := base64.StdEncoding.DecodeString(strings.Trim(encoded, ":"))
+			if err == nil && len(b) > 0 {
+				hashes[strings.ToLower(name)] = b
+			}
+		}
+	}
+	for _, a := range digestAlgos {
+		if b, ok := hashes[a.header]; ok {
+			return &Digest{Algo: a.algo, Hash: hex.EncodeToString(b)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+)
+
+func TestParseDigest(t *testing.T) {
+	content := []byte("hello")
+	sha := sha256.Sum256(content)
+	md := md5.Sum(content)
+	b64 := base64.StdEncoding.EncodeToString(sha[:])
+	want := hex.EncodeToString(sha[:])
+
+	tests := []struct {
+		name   string
+		values []string
+		algo   string
+		hash   string
+	}{
+		{"repr-digest", []string{"sha-256=:" + b64 + ":"}, "sha256", want},
+		{"digest", []string{"SHA-256=" + b64}, "sha256", want},
+		{"preferred", []string{"md5=" + base64.StdEncoding.EncodeToString(md[:]) + ", sha-256=:" + b64 + ":"}, "sha256", want},
+		{"several values", []string{"unixsum=30637", "SHA-256=" + b64}, "sha256", want},
+		{"unsupported", []string{"unixsum=30637"}, "", ""},
+		{"invalid", []string{"sha-256=:***:"}, "", ""},
+		{"empty", nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := parseDigest(tt.values)
+			if tt.algo == "" {
+				if d != nil {
+					t.Errorf("parseDigest() = %+v, want nil", d)
+				}
+				return
+			}
+			if d == nil || d.Algo != tt.algo || d.Hash != tt.hash {
+				t.Errorf("parseDigest() = %+v, want %s %s", d, tt.algo, tt.hash)
+			}
+		})
+	}
+}
+
+func TestRemoteDigest(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("remote digest")
+	os.WriteFile(filepath.Join(dir, "data.bin"), content, 0644)
+	sum := sha256.Sum256(content)
+	want := hex.EncodeToString(sum[:])
+
+	srv := server.NewServer(dir, 0)
+	srv.SetLogger(zap.NewNop())
+	ezft := httptest.NewServer(srv.Handler())
+	defer ezft.Close()
+	withHeader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		w.Write(content)
+	}))
+	defer withHeader.Close()
+	plain := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer plain.Close()
+
+	tests := []struct {
+		url    string
+		source string
+		err    error
+	}{
+		{ezft.URL + "/data.bin", "hash endpoint", nil},
+		{withHeader.URL + "/data.bin", "Repr-Digest", nil},
+		{plain.URL + "/data.bin", "", ErrNoDigest},
+	}
+	for _, tt := range tests {
+		c := NewClient(&DownloadConfig{URL: tt.url})
+		d, err := c.RemoteDigest(context.Background())
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("RemoteDigest(%s) error = %v, want %v", tt.url, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || d.Hash != want || d.Algo != "sha256" || d.Size != int64(len(content)) || d.Source != tt.source {
+			t.Errorf("RemoteDigest(%s) = %+v, %v", tt.url, d, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+// hashBufferSize size of the reads of the hashing worker
+const hashBufferSize = 1024 * 1024
+
+// errHashStopped is returned by the hashing worker of an abandoned download
+var errHashStopped = errors.New("hashing stopped")
+
+// chunkHasher computes the SHA-256 of the output file while it is downloaded.
+// Completed chunks are reported as they arrive, in any order, and a dedicated
+// worker hashes the file in order up to the first chunk still missing, so the
+// hash is ready shortly after the last chunk instead of taking another pass
+// over the file.
+type chunkHasher struct {
+	file *os.File // Output file, opened for the worker
+	size int64
+
+	mu      sync.Mutex
+	pending map[int64]int64 // End of completed chunks beyond next, by start
+	next    int64           // Offset the file is hashed up to
+	stopped bool
+
+	wake chan struct{}
+	done chan struct{}
+	hash hash.Hash
+	err  error
+}
+
+// newChunkHasher starts hashing the output file at path of size bytes, of
+// which only the given chunks are still to be downloaded
+func newChunkHasher(path string, size int64, chunks []Chunk) (*chunkHasher, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	h := &chunkHasher{
+		file:    file,
+		size:    size,
+		pending: make(map[int64]int64),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		hash:    sha256.New(),
+	}
+
+	// Everything around the chunks left is on disk already
+	left := slices.Clone(chunks)
+	slices.SortFunc(left, func(a, b Chunk) int { return cmp.Compare(a.Start, b.Start) })
+	var pos int64
+	for _, chunk := range left {
+		if chunk.Start > pos {
+			h.pending[pos] = chunk.Start - 1
+		}
+		pos = max(pos, chunk.End+1)
+	}
+	if pos < size {
+		h.pending[pos] = size - 1
+	}
+
+	go h.run()
+	h.notify()
+	return h, nil
+}
+
+// add reports a chunk written to the file completely
+func (h *chunkHasher) add(chunk Chunk) {
+	h.mu.Lock()
+	h.pending[chunk.Start] = chunk.End
+	h.mu.Unlock()
+	h.notify()
+}
+
+func (h *chunkHasher) notify() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run hashes the file in order as far as it is complete, until it is hashed
+// completely or the hasher is stopped
+func (h *chunkHasher) run() {
+	defer close(h.done)
+	defer h.file.Close()
+
+	buf := utils.GetBuffer(hashBufferSize)
+	defer utils.PutBuffer(buf)
+
+	for range h.wake {
+		h.mu.Lock()
+		if h.stopped {
+			h.mu.Unlock()
+			h.err = errHashStopped
+			return
+		}
+		start, end := h.next, h.next-1
+		for {
+			e, ok := h.pending[end+1]
+			if !ok {
+				break
+			}
+			delete(h.pending, end+1)
+			end = e
+		}
+		h.mu.Unlock()
+
+		if end >= start {
+			if _, err := io.CopyBuffer(h.hash, io.NewSectionReader(h.file, start, end-start+1), *buf); err != nil {
+				h.err = err
+				return
+			}
+			h.mu.Lock()
+			h.next = end + 1
+			h.mu.Unlock()
+		}
+		if end+1 >= h.size {
+			return
+		}
+	}
+}
+
+// sum waits for the worker and returns the hex encoded SHA-256 of the file,
+// which must be downloaded completely
+func (h *chunkHasher) sum() (string, error) {
+	<-h.done
+	if h.err != nil {
+		return "", h.err
+	}
+	if h.next != h.size {
+		return "", errors.New("file not downloaded completely")
+	}
+	return hex.EncodeToString(h.hash.Sum(nil)), nil
+}
+
+// stop abandons hashing an incomplete download
+func (h *chunkHasher) stop() {
+	h.mu.Lock()
+	h.stopped = true
+	h.mu.Unlock()
+	h.notify()
+	<-h.done
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChunkHasher(t *testing.T) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	want := sha256.Sum256(data)
+	chunks := splitChunks(0, int64(len(data)), 1024)
+
+	tests := []struct {
+		name string
+		left []Chunk // Chunks still to download
+		done []int   // Order the chunks left complete in
+	}{
+		{"in order", chunks, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"out of order", chunks, []int{9, 3, 0, 1, 8, 2, 4, 7, 6, 5}},
+		{"resumed", chunks[4:7], []int{2, 0, 1}},
+		{"resumed with holes", []Chunk{chunks[1], chunks[8]}, []int{1, 0}},
+		{"complete", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file.bin")
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			h, err := newChunkHasher(path, int64(len(data)), tt.left)
+			if err != nil {
+				t.Fatalf("newChunkHasher() error = %v", err)
+			}
+			for _, i := range tt.done {
+				h.add(tt.left[i])
+			}
+			sum, err := h.sum()
+			if err != nil {
+				t.Fatalf("sum() error = %v", err)
+			}
+			if sum != hex.EncodeToString(want[:]) {
+				t.Errorf("sum() = %s, want %x", sum, want)
+			}
+		})
+	}
+
+	// An abandoned download is not hashed
+	path := filepath.Join(t.TempDir(), "file.bin")
+	os.WriteFile(path, data, 0644)
+	h, err := newChunkHasher(path, int64(len(data)), chunks)
+	if err != nil {
+		t.Fatalf("newChunkHasher() error = %v", err)
+	}
+	h.add(chunks[0])
+	h.stop()
+	if _, err := h.sum(); err == nil {
+		t.Error("sum() of a stopped hasher succeeded")
+	}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// checkpointInterval is the time between two checkpoints of a chunked
+// download, a crash loses at most the chunks written since the last one
+const checkpointInterval = 5 * time.Second
+
+// journal records the chunks left of a chunked download in the failed chunks
+// record at checkpoints while it runs, so that a download killed or cut by a
+// power loss resumes from its last checkpoint. The output file is synced
+// before every record, which never leaves out a chunk not on disk.
+type journal struct {
+	c    *Client
+	file *os.File
+	left []Chunk // Chunks left at the last checkpoint
+
+	mu      sync.Mutex
+	written []Chunk // Ranges written since the last checkpoint
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startJournal records chunks as left to download into file and checkpoints
+// the download until stopJournal
+func (c *Client) startJournal(file *os.File, chunks []Chunk) {
+	j := &journal{
+		c:    c,
+		file: file,
+		left: slices.Clone(chunks),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if err := j.checkpoint(); err != nil {
+		c.logger.Info("failed to journal download",
+			zap.Error(err),
+		)
+		return
+	}
+	c.journal = j
+	go j.run()
+}
+
+// stopJournal stops the checkpoints of the current download, whose final
+// record is written by the caller
+func (c *Client) stopJournal() {
+	if c.journal == nil {
+		return
+	}
+	close(c.journal.stop)
+	<-c.journal.done
+	c.journal = nil
+}
+
+// add marks a range of the output file as written, dropped from the record
+// at the next checkpoint
+func (j *journal) add(chunk Chunk) {
+	j.mu.Lock()
+	j.written = append(j.written, chunk)
+	j.mu.Unlock()
+}
+
+func (j *journal) run() {
+	defer close(j.done)
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			if err := j.checkpoint(); err != nil {
+				j.c.logger.Info("failed to checkpoint download",
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// checkpoint syncs the output file and records the chunks left
+func (j *journal) checkpoint() error {
+	j.mu.Lock()
+	written := j.written
+	j.written = nil
+	j.mu.Unlock()
+
+	// Written ranges leave the record only once on disk
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	for _, chunk := range written {
+		j.left = subtractRange(j.left, chunk.Start, chunk.End)
+	}
+	return j.c.saveFailedChunks(j.left)
+}
+
+// subtractRange returns chunks without the bytes from start to end, included.
+// The parts of a chunk left keep its index.
+func subtractRange(chunks []Chunk, start, end int64) []Chunk {
+	var left []Chunk
+	for _, chunk := range chunks {
+		if chunk.End < start || chunk.Start > end {
+			left = append(left, chunk)
+			continue
+		}
+		if chunk.Start < start {
+			left = append(left, Chunk{Index: chunk.Index, Start: chunk.Start, End: start - 1})
+		}
+		if chunk.End > end {
+			left = append(left, Chunk{Index: chunk.Index, Start: end + 1, End: chunk.End})
+		}
+	}
+	return left
+}
+
+// recordFailedChunks stops the checkpoints and records chunks as left once
+// the chunks written are on disk
+func (c *Client) recordFailedChunks(file *os.File, chunks []Chunk) error {
+	c.stopJournal()
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	return c.saveFailedChunks(chunks)
+}
+
+// removeFailedChunks stops the checkpoints and deletes the failed chunks
+// record of a download completed, once the file is on disk
+func (c *Client) removeFailedChunks(file *os.File) error {
+	c.stopJournal()
+	if _, ok, err := c.chunkRecord().Load(); err == nil && !ok {
+		return nil
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	return c.chunkRecord().Remove()
+}
+
+// chunkWritten hands a range written to the output file to the hasher and the
+// journal of the download
+func (c *Client) chunkWritten(chunk Chunk) {
+	if c.hasher != nil {
+		c.hasher.add(chunk)
+	}
+	if c.journal != nil {
+		c.journal.add(chunk)
+	}
+}
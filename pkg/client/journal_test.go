@@ -0,0 +1,80 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestSubtractRange(t *testing.T) {
+	chunks := []Chunk{{Index: 0, Start: 0, End: 9}, {Index: 1, Start: 10, End: 19}, {Index: 2, Start: 20, End: 29}}
+	tests := []struct {
+		name       string
+		start, end int64
+		want       []Chunk
+	}{
+		{"whole chunk", 10, 19, []Chunk{{Index: 0, Start: 0, End: 9}, {Index: 2, Start: 20, End: 29}}},
+		{"several chunks", 0, 19, []Chunk{{Index: 2, Start: 20, End: 29}}},
+		{"part of a chunk", 12, 15, []Chunk{{Index: 0, Start: 0, End: 9}, {Index: 1, Start: 10, End: 11}, {Index: 1, Start: 16, End: 19}, {Index: 2, Start: 20, End: 29}}},
+		{"across chunks", 5, 24, []Chunk{{Index: 0, Start: 0, End: 4}, {Index: 2, Start: 25, End: 29}}},
+		{"outside", 30, 39, chunks},
+		{"all", 0, 29, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subtractRange(chunks, tt.start, tt.end); !slices.Equal(got, tt.want) {
+				t.Errorf("subtractRange(%d, %d) = %v, want %v", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJournal(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "file.bin")
+	file, err := os.Create(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	client := NewClient(&DownloadConfig{
+		URL:        "http://example.com/file.bin",
+		OutputPath: output,
+	})
+	client.SetLogger(zap.NewNop())
+
+	chunks := splitChunks(0, 40, 10)
+	client.startJournal(file, chunks)
+	defer client.stopJournal()
+
+	// Every chunk is left until the first checkpoint
+	client.chunkWritten(chunks[1])
+	client.chunkWritten(chunks[3])
+	if got, err := client.loadFailedChunks(); err != nil || !slices.Equal(got, chunks) {
+		t.Fatalf("record = %v, %v, want %v", got, err, chunks)
+	}
+
+	if err := client.journal.checkpoint(); err != nil {
+		t.Fatalf("checkpoint() error = %v", err)
+	}
+	want := []Chunk{chunks[0], chunks[2]}
+	if got, err := client.loadFailedChunks(); err != nil || !slices.Equal(got, want) {
+		t.Errorf("record = %v, %v, want %v", got, err, want)
+	}
+	if !client.hasFailedChunks() {
+		t.Error("hasFailedChunks() = false with chunks left")
+	}
+
+	if err := client.removeFailedChunks(file); err != nil {
+		t.Fatalf("removeFailedChunks() error = %v", err)
+	}
+	if client.journal != nil {
+		t.Error("journal not stopped")
+	}
+	if _, err := os.Stat(client.config.FailedChunksJason); !os.IsNotExist(err) {
+		t.Errorf("record not removed: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+// lockWaitInterval is how often a download waiting for another process to
+// finish downloading to its output file tries again
+const lockWaitInterval = 500 * time.Millisecond
+
+// lockPath returns the lock file held while downloading to the output file
+func (c *Client) lockPath() string {
+	return c.config.OutputPath + ".ezft.lock"
+}
+
+// lockOutput takes the lock of the output file, so that two processes
+// downloading to it do not overwrite each other's chunks and records. When
+// another process holds it, the download fails, or waits for it with
+// WaitLock and then finds the file it downloaded.
+func (c *Client) lockOutput(ctx context.Context) (*utils.FileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(c.config.OutputPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	for waiting := false; ; waiting = true {
+		lock, err := utils.TryLock(c.lockPath())
+		if !errors.Is(err, utils.ErrLocked) {
+			return lock, err
+		}
+		owner := "another process"
+		if pid := utils.LockOwner(c.lockPath()); pid > 0 {
+			owner = fmt.Sprintf("process %d", pid)
+		}
+		if !c.config.WaitLock {
+			return nil, fmt.Errorf("%s is being downloaded by %s: %w", c.config.OutputPath, owner, utils.ErrLocked)
+		}
+		if !waiting {
+			fmt.Fprintf(c.out, "Waiting for %s downloading to %s\n", owner, c.config.OutputPath)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockWaitInterval):
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/utils"
+	"go.uber.org/zap"
+)
+
+func TestDownloadLocked(t *testing.T) {
+	data := bytes.Repeat([]byte("ezft"), 1024)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	output := filepath.Join(t.TempDir(), "file.bin")
+	newClient := func(wait bool) (*Client, *bytes.Buffer) {
+		client := NewClient(&DownloadConfig{
+			URL:            ts.URL + "/file.bin",
+			OutputPath:     output,
+			ChunkSize:      1024,
+			MaxConcurrency: 2,
+			RetryCount:     1,
+			EnableResume:   true,
+			WaitLock:       wait,
+		})
+		client.SetLogger(zap.NewNop())
+		var out bytes.Buffer
+		client.SetOutput(&out)
+		return client, &out
+	}
+
+	// Held by another download
+	lock, err := utils.TryLock(output + ".ezft.lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, _ := newClient(false)
+	if err := client.Download(context.Background()); !errors.Is(err, utils.ErrLocked) {
+		t.Fatalf("Download() error = %v, want ErrLocked", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("locked output file written: %v", err)
+	}
+
+	client, out := newClient(true)
+	done := make(chan error, 1)
+	go func() { done <- client.Download(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Download() returned %v while locked", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "Waiting for process") {
+		t.Errorf("output %q does not tell the download waits", out.String())
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, data) {
+		t.Error("downloaded file differs")
+	}
+	if _, err := os.Stat(output + ".ezft.lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// groupChunks splits chunks into the groups requested at once, of up to
+// MultiRange chunks when multi-range requests are enabled, else of one chunk
+func (c *Client) groupChunks(chunks []Chunk) [][]Chunk {
+	n := 1
+	if c.multiRange() {
+		n = c.config.MultiRange
+	}
+	groups := make([][]Chunk, 0, (len(chunks)+n-1)/n)
+	for i := 0; i < len(chunks); i += n {
+		groups = append(groups, chunks[i:min(i+n, len(chunks))])
+	}
+	return groups
+}
+
+// multiRange reports whether chunks are requested several at once. Chunks
+// exchanged with peers or transferred over QUIC are not, and neither are
+// those encrypted end to end, which the server encrypts per response.
+func (c *Client) multiRange() bool {
+	return c.config.MultiRange > 1 && !c.singleRange.Load() && c.p2p == nil && c.quic == nil && c.e2eKey == nil
+}
+
+// downloadGroup downloads a group of chunks with one multi-range request, then
+// those it did not complete one by one with retries. It returns the chunks
+// that failed, only their part left to download when interrupted, and the
+// first error.
+func (c *Client) downloadGroup(ctx context.Context, file *os.File, group []Chunk) ([]Chunk, error) {
+	left := group
+	if len(group) > 1 && c.multiRange() {
+		var err error
+		if left, err = c.downloadRanges(ctx, file, group); err != nil {
+			c.logger.Debug("Multi-range request failed, requesting its chunks one by one",
+				zap.Int("chunks", len(group)),
+				zap.Error(err),
+			)
+		}
+	}
+
+	var failed []Chunk
+	var firstErr error
+	for _, chunk := range left {
+		if err := c.downloadChunk(ctx, file, chunk); err != nil {
+			var interrupted *interruptedChunkError
+			if errors.As(err, &interrupted) {
+				chunk = interrupted.left
+			}
+			failed = append(failed, chunk)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to download chunk %d: %w", chunk.Index, err)
+			}
+		}
+	}
+	return failed, firstErr
+}
+
+// downloadRanges requests the chunks of group in a single GET, answered with
+// a multipart/byteranges response or, when the server merged the ranges, a
+// single part. It returns the chunks left to download: those not completely
+// received, not matching the chunk manifest, or all of them on error.
+func (c *Client) downloadRanges(ctx context.Context, file *os.File, group []Chunk) ([]Chunk, error) {
+	req, err := c.newRequest(ctx, "GET", c.config.URL, nil)
+	if err != nil {
+		return group, err
+	}
+	req.Header.Set("Range", rangesHeader(group))
+	if c.ifRange != "" {
+		req.Header.Set("If-Range", c.ifRange)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return group, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkAuth(resp); err != nil {
+		return group, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		// Ranges ignored, the server would send the whole file every time.
+		// A changed remote file is told by the If-Range of the single
+		// requests.
+		c.singleRange.Store(true)
+		return group, errors.New("server ignores multi-range requests")
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return group, fmt.Errorf("server returned error status: %d", resp.StatusCode)
+	}
+
+	// Bytes received of each chunk of the group
+	received := make([]int64, len(group))
+	write := func(body io.Reader, contentRange string) error {
+		start, end, err := parseContentRange(contentRange)
+		if err != nil {
+			return err
+		}
+		if err := c.checkRangeTotal(contentRange); err != nil {
+			return err
+		}
+		if !rangesCover(group, start, end) {
+			return fmt.Errorf("server sent range %d-%d not requested", start, end)
+		}
+		n, err := writeChunkBody(ctx, file, body, Chunk{Start: start, End: end}, &c.downloaded)
+		for i, chunk := range group {
+			received[i] += max(min(chunk.End, start+n-1)-max(chunk.Start, start)+1, 0)
+		}
+		return err
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "multipart/byteranges" {
+		mr := multipart.NewReader(resp.Body, params["boundary"])
+		for {
+			part, perr := mr.NextPart()
+			if perr == io.EOF {
+				break
+			}
+			if perr != nil {
+				err = fmt.Errorf("failed to read response part: %w", perr)
+				break
+			}
+			if err = write(part, part.Header.Get("Content-Range")); err != nil {
+				break
+			}
+		}
+	} else {
+		err = write(resp.Body, resp.Header.Get("Content-Range"))
+	}
+
+	var left []Chunk
+	for i, chunk := range group {
+		size := chunk.End - chunk.Start + 1
+		if received[i] != size {
+			// Downloaded again on its own
+			c.downloaded.Add(-received[i])
+			left = append(left, chunk)
+			continue
+		}
+		if c.chunkSums != nil {
+			if verr := c.verifyChunk(file, chunk); verr != nil {
+				c.downloaded.Add(-size)
+				left = append(left, chunk)
+				continue
+			}
+			if c.verified != nil {
+				c.verified.set(int(chunk.Start / c.chunkSums.ChunkSize))
+			}
+		}
+		c.chunkWritten(chunk)
+	}
+	return left, err
+}
+
+// rangesHeader returns the Range header requesting the chunks, adjacent
+// chunks merged into one range
+func rangesHeader(chunks []Chunk) string {
+	var ranges []string
+	start, end := chunks[0].Start, chunks[0].End
+	for _, chunk := range chunks[1:] {
+		if chunk.Start == end+1 {
+			end = chunk.End
+			continue
+		}
+		ranges = append(ranges, fmt.Sprintf("%d-%d", start, end))
+		start, end = chunk.Start, chunk.End
+	}
+	ranges = append(ranges, fmt.Sprintf("%d-%d", start, end))
+	return "bytes=" + strings.Join(ranges, ",")
+}
+
+// rangesCover reports whether the bytes from start to end, included, all
+// belong to the chunks
+func rangesCover(chunks []Chunk, start, end int64) bool {
+	for pos := start; pos <= end; {
+		next := pos
+		for _, chunk := range chunks {
+			if chunk.Start <= pos && pos <= chunk.End {
+				next = chunk.End + 1
+				break
+			}
+		}
+		if next == pos {
+			return false
+		}
+		pos = next
+	}
+	return true
+}
+
+// parseContentRange returns the first and last byte of a Content-Range
+// header such as "bytes 0-1023/4096"
+func parseContentRange(header string) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	spec, _, _ = strings.Cut(spec, "/")
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	return start, end, nil
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestDownloadRanges(t *testing.T) {
+	data := make([]byte, 64*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	tests := []struct {
+		name         string
+		ignoreRanges bool
+		multiRange   int
+		wantRequests int32
+	}{
+		{"multipart", false, 4, 4},
+		{"one per request", false, 0, 16},
+		{"ranges ignored", true, 4, 17},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if tt.ignoreRanges && strings.Contains(r.Header.Get("Range"), ",") {
+					w.Write(data)
+					return
+				}
+				http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+			}))
+			defer ts.Close()
+
+			output := filepath.Join(t.TempDir(), "file.bin")
+			file, err := os.Create(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			client := NewClient(&DownloadConfig{
+				URL:            ts.URL + "/file.bin",
+				OutputPath:     output,
+				MaxConcurrency: 1, // A single worker sends the first multi-range request
+				MultiRange:     tt.multiRange,
+			})
+			client.SetLogger(zap.NewNop())
+
+			// Every other chunk, as left by an interrupted download
+			var chunks []Chunk
+			for i, chunk := range splitChunks(0, int64(len(data)), 2*1024) {
+				if i%2 == 0 {
+					chunks = append(chunks, chunk)
+				}
+			}
+			if err := client.downloadChunksConcurrently(context.Background(), file, chunks); err != nil {
+				t.Fatalf("downloadChunksConcurrently() error = %v", err)
+			}
+
+			if n := requests.Load(); n != tt.wantRequests {
+				t.Errorf("%d requests, want %d", n, tt.wantRequests)
+			}
+			if got := client.downloaded.Load(); got != int64(len(data)/2) {
+				t.Errorf("downloaded = %d, want %d", got, len(data)/2)
+			}
+			got, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, chunk := range chunks {
+				if !bytes.Equal(got[chunk.Start:chunk.End+1], data[chunk.Start:chunk.End+1]) {
+					t.Errorf("chunk %d differs", chunk.Index)
+				}
+			}
+		})
+	}
+}
+
+func TestRangesHeader(t *testing.T) {
+	tests := []struct {
+		chunks []Chunk
+		want   string
+	}{
+		{[]Chunk{{Start: 0, End: 9}}, "bytes=0-9"},
+		{[]Chunk{{Start: 0, End: 9}, {Start: 10, End: 19}}, "bytes=0-19"},
+		{[]Chunk{{Start: 0, End: 9}, {Start: 20, End: 29}, {Start: 30, End: 39}}, "bytes=0-9,20-39"},
+	}
+	for _, tt := range tests {
+		if got := rangesHeader(tt.chunks); got != tt.want {
+			t.Errorf("rangesHeader(%v) = %q, want %q", tt.chunks, got, tt.want)
+		}
+	}
+}
+
+func TestRangesCover(t *testing.T) {
+	chunks := []Chunk{{Start: 0, End: 9}, {Start: 10, End: 19}, {Start: 40, End: 49}}
+	tests := []struct {
+		start, end int64
+		want       bool
+	}{
+		{0, 19, true},
+		{5, 12, true},
+		{40, 49, true},
+		{15, 45, false},
+		{40, 50, false},
+	}
+	for _, tt := range tests {
+		if got := rangesCover(chunks, tt.start, tt.end); got != tt.want {
+			t.Errorf("rangesCover(%d, %d) = %v, want %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header     string
+		start, end int64
+		wantErr    bool
+	}{
+		{"bytes 0-1023/4096", 0, 1023, false},
+		{"bytes 100-199/*", 100, 199, false},
+		{"bytes */4096", 0, 0, true},
+		{"bytes 9-1/10", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, tt := range tests {
+		start, end, err := parseContentRange(tt.header)
+		if (err != nil) != tt.wantErr || start != tt.start || end != tt.end {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v", tt.header, start, end, err)
+		}
+	}
+}
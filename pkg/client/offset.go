@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// resumeOffset returns the offset an offset download resumes from: the end of
+// the bytes of the output file downloaded without a gap, before the first
+// failed chunk recorded by an interrupted chunked download
+func (c *Client) resumeOffset(existingSize int64) (int64, error) {
+	failedChunks, err := c.loadFailedChunks()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load failed chunks record: %w", err)
+	}
+	offset := existingSize
+	for _, chunk := range failedChunks {
+		offset = min(offset, chunk.Start)
+	}
+	return max(offset, 0), nil
+}
+
+// offsetDownload downloads the file over a single connection, with one
+// request of the bytes from the resume offset to the end of the file,
+// streamed in order. Servers accepting ranges but misbehaving under
+// concurrent connections are resumed this way. The output file always ends
+// at the last byte received, where a retry or the next run resumes.
+func (c *Client) offsetDownload(ctx context.Context, fileSize int64) error {
+	if err := os.MkdirAll(filepath.Dir(c.config.OutputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	file, err := openOutput(c.config.OutputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if err := c.setIfRange(file); err != nil {
+		return fmt.Errorf("failed to save ETag: %w", err)
+	}
+	existingSize, err := c.getExistingFileSize()
+	if err != nil {
+		return fmt.Errorf("failed to check existing file: %w", err)
+	}
+	offset, err := c.resumeOffset(min(existingSize, fileSize))
+	if err != nil {
+		return err
+	}
+	// The file ends where the next run resumes, the failed chunks beyond
+	// are downloaded with the rest
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+	c.chunkRecord().Remove()
+	c.downloaded.Store(offset)
+	c.chunked.Store(true)
+
+	c.logger.Debug("Starting offset download",
+		zap.Int64("offset", offset),
+		zap.Int64("remaining", fileSize-offset),
+	)
+	c.startHash(fileSize, []Chunk{{Start: offset, End: fileSize - 1}})
+
+	for attempt := 0; offset < fileSize; attempt++ {
+		n, err := c.downloadFrom(ctx, file, offset, fileSize)
+		if n > 0 && c.hasher != nil {
+			c.hasher.add(Chunk{Start: offset, End: offset + n - 1})
+		}
+		offset += n
+		if err == nil {
+			continue
+		}
+		var mismatch *sizeMismatchError
+		if attempt == c.config.RetryCount || errors.Is(err, errRemoteChanged) || errors.As(err, &mismatch) || ctx.Err() != nil {
+			c.finishHash(err)
+			return err
+		}
+		c.logger.Info(fmt.Sprintf("Download attempt %d failed, resuming at offset %d", attempt+1, offset),
+			zap.Error(err),
+		)
+		select {
+		case <-ctx.Done():
+			c.finishHash(ctx.Err())
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+			c.retries.Add(1)
+		}
+	}
+	c.finishHash(nil)
+	return nil
+}
+
+// downloadFrom requests the bytes of the file from offset to its end and
+// writes them to file as they arrive, returning the bytes written
+func (c *Client) downloadFrom(ctx context.Context, file *os.File, offset, fileSize int64) (int64, error) {
+	req, err := c.newRequest(ctx, "GET", c.config.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	if c.ifRange != "" {
+		req.Header.Set("If-Range", c.ifRange)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkAuth(resp); err != nil {
+		return 0, err
+	}
+	if resp.StatusCode == http.StatusOK && c.ifRange != "" {
+		return 0, errRemoteChanged
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("server does not support Range requests, status code: %d", resp.StatusCode)
+	}
+	if start, _, err := parseContentRange(resp.Header.Get("Content-Range")); err != nil || start != offset {
+		return 0, fmt.Errorf("server sent range %q, requested from offset %d", resp.Header.Get("Content-Range"), offset)
+	}
+	if err := c.checkRangeTotal(resp.Header.Get("Content-Range")); err != nil {
+		return 0, err
+	}
+
+	n, err := writeChunkBody(ctx, file, resp.Body, Chunk{Start: offset, End: fileSize - 1}, &c.downloaded)
+	if err == nil && offset+n < fileSize {
+		err = fmt.Errorf("connection closed after %d of %d bytes", n, fileSize-offset)
+	}
+	return n, err
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestOffsetDownload(t *testing.T) {
+	content := make([]byte, 8000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	tests := []struct {
+		name       string
+		partial    int
+		failed     []Chunk
+		cutAt      int // Bytes sent by the first response before the connection drops, 0 for all
+		wantRanges []string
+	}{
+		{"from scratch", 0, nil, 0, []string{"bytes=0-"}},
+		{"partial file", 3000, nil, 0, []string{"bytes=3000-"}},
+		{"failed chunks", 6000, []Chunk{{Index: 5, Start: 5000, End: 5999}, {Index: 2, Start: 2000, End: 2999}}, 0, []string{"bytes=2000-"}},
+		{"connection dropped", 3000, nil, 1000, []string{"bytes=3000-", "bytes=4000-"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var ranges []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet {
+					http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+					return
+				}
+				mu.Lock()
+				ranges = append(ranges, r.Header.Get("Range"))
+				first := len(ranges) == 1
+				mu.Unlock()
+				if first && tt.cutAt > 0 {
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", tt.partial, len(content)-1, len(content)))
+					w.Header().Set("Content-Length", fmt.Sprint(len(content)-tt.partial))
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write(content[tt.partial : tt.partial+tt.cutAt])
+					return
+				}
+				http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+			}))
+			defer ts.Close()
+
+			output := filepath.Join(t.TempDir(), "data.bin")
+			if tt.partial > 0 {
+				// Bytes of failed chunks are zero
+				partial := slices.Clone(content[:tt.partial])
+				for _, chunk := range tt.failed {
+					clear(partial[chunk.Start : chunk.End+1])
+				}
+				if err := os.WriteFile(output, partial, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := NewClient(&DownloadConfig{
+				URL:               ts.URL + "/data.bin",
+				OutputPath:        output,
+				FailedChunksJason: output + ".failed_chunks.json",
+				ChunkSize:         1000,
+				MaxConcurrency:    4,
+				RetryCount:        1,
+				EnableResume:      true,
+				OffsetResume:      true,
+			})
+			c.SetLogger(zap.NewNop())
+			c.SetOutput(io.Discard)
+			if tt.failed != nil {
+				if err := c.saveFailedChunks(tt.failed); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := c.Download(context.Background()); err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+				t.Error("Downloaded file differs")
+			}
+			if !slices.Equal(ranges, tt.wantRanges) {
+				t.Errorf("ranges requested = %q, want %q", ranges, tt.wantRanges)
+			}
+			if s := c.Summary(); s.Strategy != StrategyOffset {
+				t.Errorf("Strategy = %q, want %q", s.Strategy, StrategyOffset)
+			}
+			if _, err := os.Stat(output + ".failed_chunks.json"); !os.IsNotExist(err) {
+				t.Error("failed chunks record kept")
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package client
+
+import "os"
+
+// openOutput opens the output file of a resumable download for reading and
+// writing, created if missing
+func openOutput(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+}
@@ -0,0 +1,31 @@
+package client
+
+import (
+	"errors"
+	"os"
+
+	"github.com/easzlab/ezft/pkg/utils"
+	"golang.org/x/sys/windows"
+)
+
+// openOutput opens the output file of a resumable download for reading and
+// writing, created if missing. Other processes may read, rename or delete it
+// but not write it while it is downloaded, so that two downloads to the same
+// file do not interleave their chunks.
+func openOutput(path string) (*os.File, error) {
+	name, err := windows.UTF16PtrFromString(utils.LongPath(path))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	h, err := windows.CreateFile(name,
+		windows.GENERIC_READ|windows.GENERIC_WRITE,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_ALWAYS, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if errors.Is(err, windows.ERROR_SHARING_VIOLATION) {
+			err = errors.New("file is being written by another process")
+		}
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenOutput(t *testing.T) {
+	// Deeper than MAX_PATH
+	dir := t.TempDir()
+	for range 3 {
+		dir = filepath.Join(dir, strings.Repeat("d", 100))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "file.bin")
+
+	file, err := openOutput(path)
+	if err != nil {
+		t.Fatalf("openOutput() error = %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt([]byte("data"), 0); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+
+	// Readable by others, but not writable by a second download
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+	if second, err := openOutput(path); err == nil {
+		second.Close()
+		t.Error("openOutput() of a file being downloaded succeeded")
+	}
+}
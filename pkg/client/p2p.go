@@ -0,0 +1,465 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/p2p"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"go.uber.org/zap"
+)
+
+// peerAttempts bounds the peers tried for a chunk before downloading it from the server
+const peerAttempts = 2
+
+var (
+	// errNotTracker is returned when the server does not track peers
+	errNotTracker = errors.New("server is not a tracker")
+	// errNoPeer is returned when no peer can serve a chunk
+	errNoPeer = errors.New("no peer has the chunk")
+	// errPunchClosed is returned once the connection punched to a peer closed
+	errPunchClosed = errors.New("punched connection closed")
+)
+
+// swarm the peers a download exchanges chunks with
+type swarm struct {
+	node      *p2p.Node
+	id        string
+	key       string
+	client    *http.Client // Transfers between peers, which must not see the server credentials
+	fromPeers atomic.Int64 // Bytes downloaded from peers
+	stop      context.CancelFunc
+	done      chan struct{}
+	relayed   chan struct{} // Closed once the node stopped serving through the relay, nil unless P2PRelay
+	tracker   *http.Client  // Announces from the port of the node, so that peers can punch holes to it, nil unless P2PPunch
+
+	mu          sync.Mutex
+	peers       []p2p.Peer
+	pieces      *p2p.Pieces                // Piece hashes verifying chunks from peers, nil until the server hashed the file
+	unreachable map[string]bool            // Peers failing direct connections, reached through the relay
+	punched     map[string]*http.Transport // Connections punched to unreachable peers, nil once the punch failed
+}
+
+// startP2P joins the swarm of the file at the tracker, serving completed
+// chunks to the other peers
+func (c *Client) startP2P(ctx context.Context) error {
+	node := p2p.NewNode(c.config.OutputPath)
+	node.SetLogger(c.logger)
+	if err := node.Listen(c.config.P2PListen); err != nil {
+		return err
+	}
+	go node.Serve()
+
+	id := make([]byte, 8)
+	cryptorand.Read(id)
+	transport := http.RoundTripper(c.newTransport(false, false))
+	if c.limiter != nil {
+		transport = ratelimit.NewTransport(transport, c.limiter)
+	}
+	c.p2p = &swarm{
+		node:   node,
+		id:     hex.EncodeToString(id),
+		client: &http.Client{Transport: transport},
+		done:   make(chan struct{}),
+
+		unreachable: make(map[string]bool),
+		punched:     make(map[string]*http.Transport),
+	}
+	if c.config.P2PPunch && !c.customHTTP {
+		// One connection from the port of the node, whose NAT mapping the
+		// tracker sees
+		trackerTransport := c.newTransport(false, true)
+		trackerTransport.DialContext = node.Dialer().DialContext
+		c.p2p.tracker = &http.Client{Transport: trackerTransport}
+	}
+
+	s, err := c.announce(ctx, false)
+	if err != nil && c.p2p.tracker != nil && !errors.Is(err, errNotTracker) {
+		c.logger.Info("failed to announce from the port of the node, hole punching disabled",
+			zap.Error(err),
+		)
+		c.p2p.tracker = nil
+		s, err = c.announce(ctx, false)
+	}
+	if err != nil {
+		node.Close()
+		c.p2p = nil
+		return err
+	}
+	c.p2p.key = s.Key
+	c.p2p.peers = s.Peers
+	node.SetKey(s.Key)
+	c.updatePieces(ctx)
+
+	loopCtx, cancel := context.WithCancel(ctx)
+	c.p2p.stop = cancel
+	go c.announceLoop(loopCtx)
+	if c.config.P2PRelay {
+		relayURL, _ := c.relayURL(c.p2p.id) // The URL was parsed before
+		// Requests to the relay wait for the other peers, without a header timeout
+		relayTransport := c.newTransport(false, false)
+		relayTransport.ResponseHeaderTimeout = 0
+		relayClient := &http.Client{Transport: relayTransport}
+		if c.limiter != nil {
+			relayClient.Transport = ratelimit.NewTransport(relayTransport, c.limiter)
+		}
+		c.p2p.relayed = make(chan struct{})
+		go func() {
+			defer close(c.p2p.relayed)
+			node.Relay(loopCtx, relayClient, relayURL)
+		}()
+	}
+
+	c.logger.Info("joined the swarm",
+		zap.String("addr", node.Addr().String()),
+		zap.Int("peers", len(s.Peers)),
+		zap.Bool("relay", c.config.P2PRelay),
+		zap.Bool("punch", c.p2p.tracker != nil),
+	)
+	return nil
+}
+
+// stopP2P leaves the swarm
+func (c *Client) stopP2P() {
+	c.p2p.stop()
+	<-c.p2p.done
+	if c.p2p.relayed != nil {
+		<-c.p2p.relayed
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.announce(ctx, true)
+	c.p2p.node.Close()
+	if c.p2p.tracker != nil {
+		c.p2p.tracker.CloseIdleConnections()
+	}
+	c.p2p.mu.Lock()
+	for _, transport := range c.p2p.punched {
+		if transport != nil {
+			transport.CloseIdleConnections()
+		}
+	}
+	c.p2p.mu.Unlock()
+
+	c.logger.Info("left the swarm",
+		zap.Int64("fromPeers", c.p2p.fromPeers.Load()),
+		zap.Int64("toPeers", c.p2p.node.Served()),
+	)
+	c.p2p = nil
+}
+
+// announce tells the tracker the completed ranges of the download
+func (c *Client) announce(ctx context.Context, stopped bool) (*p2p.Swarm, error) {
+	announceURL, err := c.apiURL("peers")
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(p2p.Announce{
+		ID:      c.p2p.id,
+		Port:    c.p2p.node.Addr().(*net.TCPAddr).Port,
+		URL:     c.config.P2PAdvertise,
+		Ranges:  c.p2p.node.Ranges(),
+		Relay:   c.config.P2PRelay,
+		Punch:   c.p2p.tracker != nil,
+		Stopped: stopped,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, "POST", announceURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	httpClient := c.httpClient
+	if c.p2p.tracker != nil {
+		httpClient = c.p2p.tracker
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, errNotTracker
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned error status: %d", resp.StatusCode)
+	}
+	var s p2p.Swarm
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid announce response: %w", err)
+	}
+	return &s, nil
+}
+
+// relayURL returns the URL of the peer id at the relay of the server, which
+// peers reach without the server credentials
+func (c *Client) relayURL(id string) (string, error) {
+	u, err := url.Parse(c.config.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	u.User = nil
+	u.Path = apiPrefix + "relay/" + id
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// loadPieces fetches the piece hashes of the file, nil while the server is
+// still hashing it
+func (c *Client) loadPieces(ctx context.Context) (*p2p.Pieces, error) {
+	piecesURL, err := c.apiURL("pieces")
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, "GET", piecesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusAccepted {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned error status: %d", resp.StatusCode)
+	}
+	var pieces p2p.Pieces
+	if err := json.NewDecoder(resp.Body).Decode(&pieces); err != nil {
+		return nil, fmt.Errorf("invalid piece hashes: %w", err)
+	}
+	if pieces.Size != c.config.FileSize || pieces.PieceSize <= 0 {
+		return nil, fmt.Errorf("piece hashes do not match the file")
+	}
+	return &pieces, nil
+}
+
+// updatePieces loads the piece hashes unless they are loaded already
+func (c *Client) updatePieces(ctx context.Context) {
+	c.p2p.mu.Lock()
+	loaded := c.p2p.pieces != nil
+	c.p2p.mu.Unlock()
+	if loaded {
+		return
+	}
+	pieces, err := c.loadPieces(ctx)
+	if err != nil {
+		c.logger.Debug("failed to load piece hashes", zap.Error(err))
+	}
+	c.p2p.mu.Lock()
+	c.p2p.pieces = pieces
+	c.p2p.mu.Unlock()
+}
+
+// announceLoop keeps the peers and piece hashes of the swarm up to date
+func (c *Client) announceLoop(ctx context.Context) {
+	defer close(c.p2p.done)
+	ticker := time.NewTicker(p2p.AnnounceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.updatePieces(ctx)
+		s, err := c.announce(ctx, false)
+		if err != nil {
+			c.logger.Debug("failed to announce", zap.Error(err))
+			continue
+		}
+		c.p2p.mu.Lock()
+		c.p2p.peers = s.Peers
+		c.p2p.mu.Unlock()
+	}
+}
+
+// fetchFromPeers downloads a chunk from a peer having it, verified by the
+// piece hashes of the server
+func (c *Client) fetchFromPeers(ctx context.Context, file *os.File, chunk Chunk) error {
+	r := p2p.Range{Start: chunk.Start, End: chunk.End}
+	c.p2p.mu.Lock()
+	pieces := c.p2p.pieces
+	var candidates []p2p.Peer
+	for _, peer := range c.p2p.peers {
+		if p2p.Covers(peer.Ranges, r) {
+			candidates = append(candidates, peer)
+		}
+	}
+	c.p2p.mu.Unlock()
+	if pieces == nil || !pieces.Aligned(r) || len(candidates) == 0 {
+		return errNoPeer
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	var err error
+	for _, peer := range candidates[:min(len(candidates), peerAttempts)] {
+		if err = c.fetchFromPeer(ctx, file, peer, pieces, r); err == nil {
+			c.p2p.fromPeers.Add(r.End - r.Start + 1)
+			return nil
+		}
+		c.logger.Debug("failed to download chunk from peer",
+			zap.String("peer", peer.URL),
+			zap.Int64("start", r.Start),
+			zap.Error(err),
+		)
+	}
+	return err
+}
+
+// fetchFromPeer downloads the range r from peer, directly or else through the
+// relay if the peer serves there too. Peers failing a direct connection once
+// are only reached through the relay from then on, or over a connection
+// punched through their NAT with P2PPunch as long as it lasts.
+func (c *Client) fetchFromPeer(ctx context.Context, file *os.File, peer p2p.Peer, pieces *p2p.Pieces, r p2p.Range) error {
+	c.p2p.mu.Lock()
+	unreachable := c.p2p.unreachable[peer.ID]
+	c.p2p.mu.Unlock()
+	if !unreachable {
+		err := c.fetchRange(ctx, c.p2p.client, file, peer, pieces, r)
+		var urlErr *url.Error
+		if err == nil || !peer.Relay || !errors.As(err, &urlErr) || ctx.Err() != nil {
+			return err
+		}
+		c.p2p.mu.Lock()
+		c.p2p.unreachable[peer.ID] = true
+		c.p2p.mu.Unlock()
+		c.logger.Info("peer unreachable, going through the relay",
+			zap.String("peer", peer.ID),
+			zap.Error(err),
+		)
+	}
+
+	if transport := c.punch(ctx, peer); transport != nil {
+		punched := peer
+		punched.URL = "http://" + peer.Addr + "/"
+		err := c.fetchRange(ctx, c.peerClient(transport), file, punched, pieces, r)
+		var urlErr *url.Error
+		if err == nil || !errors.As(err, &urlErr) || ctx.Err() != nil {
+			return err
+		}
+		c.p2p.mu.Lock()
+		c.p2p.punched[peer.ID] = nil
+		c.p2p.mu.Unlock()
+		transport.CloseIdleConnections()
+		c.logger.Info("punched connection lost, going through the relay",
+			zap.String("peer", peer.ID),
+			zap.Error(err),
+		)
+	}
+
+	relayURL, err := c.relayURL(peer.ID)
+	if err != nil {
+		return err
+	}
+	peer.URL = relayURL
+	return c.fetchRange(ctx, c.p2p.client, file, peer, pieces, r)
+}
+
+// punch returns the transport of the connection punched through the NAT of
+// the unreachable peer, asking the tracker for the hole punch the first time,
+// nil if the peer is only reached through the relay. Chunks requested while
+// the punch is under way go through the relay.
+func (c *Client) punch(ctx context.Context, peer p2p.Peer) *http.Transport {
+	if c.p2p.tracker == nil || !peer.Relay || peer.Addr == "" {
+		return nil
+	}
+	c.p2p.mu.Lock()
+	transport, tried := c.p2p.punched[peer.ID]
+	if !tried {
+		c.p2p.punched[peer.ID] = nil
+	}
+	c.p2p.mu.Unlock()
+	if tried {
+		return transport
+	}
+
+	relayURL, err := c.relayURL(peer.ID)
+	if err != nil {
+		return nil
+	}
+	conn, err := c.p2p.node.Punch(ctx, c.p2p.client, relayURL, c.p2p.id, peer)
+	if err != nil {
+		c.logger.Info("hole punch failed, going through the relay",
+			zap.String("peer", peer.ID),
+			zap.Error(err),
+		)
+		return nil
+	}
+	c.logger.Info("hole punched to peer",
+		zap.String("peer", peer.ID),
+		zap.String("addr", peer.Addr),
+	)
+
+	// Requests to the peer take turns on the punched connection
+	conns := make(chan net.Conn, 1)
+	conns <- conn
+	transport = c.newTransport(false, true)
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		select {
+		case conn := <-conns:
+			return conn, nil
+		default:
+			return nil, errPunchClosed
+		}
+	}
+	c.p2p.mu.Lock()
+	c.p2p.punched[peer.ID] = transport
+	c.p2p.mu.Unlock()
+	return transport
+}
+
+// peerClient returns a client of the peers over transport, within the
+// bandwidth limit
+func (c *Client) peerClient(transport *http.Transport) *http.Client {
+	if c.limiter != nil {
+		return &http.Client{Transport: ratelimit.NewTransport(transport, c.limiter)}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// fetchRange downloads the range r from the peer at peer.URL into file over
+// client, verified by the piece hashes
+func (c *Client) fetchRange(ctx context.Context, client *http.Client, file *os.File, peer p2p.Peer, pieces *p2p.Pieces, r p2p.Range) error {
+	v := pieces.NewVerifier(r.Start)
+	w := io.MultiWriter(io.NewOffsetWriter(file, r.Start), v)
+	if err := p2p.Fetch(ctx, client, peer, c.p2p.key, r, w); err != nil {
+		return err
+	}
+	return v.Complete()
+}
+
+// seed serves the complete file to the peers for the configured seed time
+func (c *Client) seed(ctx context.Context, fileSize int64) {
+	c.p2p.node.Add(p2p.Range{Start: 0, End: fileSize - 1})
+	if c.config.P2PSeedTime <= 0 || fileSize == 0 {
+		return
+	}
+	fmt.Fprintf(c.out, "Seeding to peers for %s\n", c.config.P2PSeedTime)
+	c.announce(ctx, false)
+	select {
+	case <-ctx.Done():
+	case <-time.After(c.config.P2PSeedTime):
+	}
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/p2p"
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+)
+
+// newTrackerServer serves dir with the tracker enabled, counting the range
+// requests served from the file, relayed ones excluded, and those relayed
+func newTrackerServer(t *testing.T, dir string) (string, *atomic.Int32, *atomic.Int32) {
+	t.Helper()
+
+	srv := server.NewServer(dir, 0)
+	srv.SetLogger(zap.NewNop())
+	srv.SetTrackerEnabled(true)
+	ranges, relayed := &atomic.Int32{}, &atomic.Int32{}
+	handler := srv.Handler()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Range") == "":
+		case strings.HasPrefix(r.URL.Path, "/_ezft/relay/"):
+			relayed.Add(1)
+		case !strings.HasPrefix(r.URL.Path, "/_ezft/"):
+			ranges.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL, ranges, relayed
+}
+
+// announceProbe announces a peer without chunks, returning the other peers
+func announceProbe(t *testing.T, url string, a p2p.Announce) []p2p.Peer {
+	t.Helper()
+
+	body, _ := json.Marshal(a)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to announce: %v", err)
+	}
+	defer resp.Body.Close()
+	var swarm p2p.Swarm
+	if err := json.NewDecoder(resp.Body).Decode(&swarm); err != nil {
+		t.Fatalf("Failed to decode swarm: %v", err)
+	}
+	return swarm.Peers
+}
+
+func newP2PClient(url, outputPath string, seed time.Duration) *Client {
+	client := NewClient(&DownloadConfig{
+		URL:            url,
+		OutputPath:     outputPath,
+		ChunkSize:      p2p.PieceSize,
+		MaxConcurrency: 4,
+		RetryCount:     1,
+		EnableResume:   true,
+		P2PListen:      "127.0.0.1:0",
+		P2PSeedTime:    seed,
+	})
+	client.SetLogger(zap.NewNop())
+	return client
+}
+
+func TestP2PDownload(t *testing.T) {
+	serverDir := t.TempDir()
+	content := make([]byte, 3*p2p.PieceSize+100)
+	rand.New(rand.NewSource(3)).Read(content)
+	if err := os.WriteFile(filepath.Join(serverDir, "data.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	url, ranges, _ := newTrackerServer(t, serverDir)
+
+	// The first client downloads from the server and seeds
+	ctx, cancel := context.WithCancel(context.Background())
+	seeded := make(chan error, 1)
+	go func() {
+		seeded <- newP2PClient(url+"/data.bin", filepath.Join(t.TempDir(), "data.bin"), time.Minute).Download(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-seeded; err != nil {
+			t.Errorf("Download() of the seed error = %v", err)
+		}
+	}()
+	whole := p2p.Range{Start: 0, End: int64(len(content)) - 1}
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		peers := announceProbe(t, url+"/_ezft/peers/data.bin", p2p.Announce{ID: "probe", Port: 1})
+		if len(peers) == 1 && p2p.Covers(peers[0].Ranges, whole) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The first client did not seed the file")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The second client gets all chunks from the first one
+	ranges.Store(0)
+	outputPath := filepath.Join(t.TempDir(), "data.bin")
+	client := newP2PClient(url+"/data.bin", outputPath, 0)
+	if err := client.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(outputPath); !bytes.Equal(got, content) {
+		t.Error("Downloaded file differs")
+	}
+	if n := ranges.Load(); n != 0 {
+		t.Errorf("%d range requests reached the server, want none", n)
+	}
+}
+
+func TestP2PCorruptPeer(t *testing.T) {
+	serverDir := t.TempDir()
+	content := make([]byte, 2*p2p.PieceSize)
+	rand.New(rand.NewSource(4)).Read(content)
+	if err := os.WriteFile(filepath.Join(serverDir, "data.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	url, ranges, _ := newTrackerServer(t, serverDir)
+
+	// A peer claiming the whole file but sending garbage
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(make([]byte, p2p.PieceSize))
+	}))
+	defer bad.Close()
+	announceProbe(t, url+"/_ezft/peers/data.bin", p2p.Announce{
+		ID:     "bad",
+		URL:    bad.URL,
+		Ranges: []p2p.Range{{Start: 0, End: int64(len(content)) - 1}},
+	})
+
+	outputPath := filepath.Join(t.TempDir(), "data.bin")
+	client := newP2PClient(url+"/data.bin", outputPath, 0)
+	if err := client.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(outputPath); !bytes.Equal(got, content) {
+		t.Error("Data of the corrupt peer must be rejected")
+	}
+	if n := ranges.Load(); n != 2 {
+		t.Errorf("%d range requests reached the server, want 2", n)
+	}
+}
+
+func TestP2PRelay(t *testing.T) {
+	serverDir := t.TempDir()
+	content := make([]byte, 2*p2p.PieceSize+100)
+	rand.New(rand.NewSource(5)).Read(content)
+	if err := os.WriteFile(filepath.Join(serverDir, "data.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	url, ranges, relayed := newTrackerServer(t, serverDir)
+
+	// The seed advertises an address nobody can connect to, as behind NAT
+	ctx, cancel := context.WithCancel(context.Background())
+	seeded := make(chan error, 1)
+	seed := newP2PClient(url+"/data.bin", filepath.Join(t.TempDir(), "data.bin"), time.Minute)
+	seed.config.P2PAdvertise = "http://127.0.0.1:1/"
+	seed.config.P2PRelay = true
+	go func() {
+		seeded <- seed.Download(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-seeded; err != nil {
+			t.Errorf("Download() of the seed error = %v", err)
+		}
+	}()
+	whole := p2p.Range{Start: 0, End: int64(len(content)) - 1}
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		peers := announceProbe(t, url+"/_ezft/peers/data.bin", p2p.Announce{ID: "probe", Port: 1})
+		if len(peers) == 1 && peers[0].Relay && p2p.Covers(peers[0].Ranges, whole) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The first client did not seed the file")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The second client fails to connect and goes through the relay
+	ranges.Store(0)
+	outputPath := filepath.Join(t.TempDir(), "data.bin")
+	if err := newP2PClient(url+"/data.bin", outputPath, 0).Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(outputPath); !bytes.Equal(got, content) {
+		t.Error("Downloaded file differs")
+	}
+	if n := ranges.Load(); n != 0 {
+		t.Errorf("%d range requests reached the server, want none", n)
+	}
+	if relayed.Load() == 0 {
+		t.Error("No chunk went through the relay")
+	}
+}
+
+func TestP2PPunch(t *testing.T) {
+	serverDir := t.TempDir()
+	content := make([]byte, 2*p2p.PieceSize+100)
+	rand.New(rand.NewSource(6)).Read(content)
+	if err := os.WriteFile(filepath.Join(serverDir, "data.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	url, ranges, relayed := newTrackerServer(t, serverDir)
+
+	// The seed advertises an address nobody can connect to, as behind NAT
+	ctx, cancel := context.WithCancel(context.Background())
+	seeded := make(chan error, 1)
+	seed := newP2PClient(url+"/data.bin", filepath.Join(t.TempDir(), "data.bin"), time.Minute)
+	seed.config.P2PAdvertise = "http://127.0.0.1:1/"
+	seed.config.P2PRelay = true
+	seed.config.P2PPunch = true
+	go func() {
+		seeded <- seed.Download(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-seeded; err != nil {
+			t.Errorf("Download() of the seed error = %v", err)
+		}
+	}()
+	whole := p2p.Range{Start: 0, End: int64(len(content)) - 1}
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		peers := announceProbe(t, url+"/_ezft/peers/data.bin", p2p.Announce{ID: "probe", Port: 1})
+		if len(peers) == 1 && peers[0].Relay && peers[0].Addr != "" && p2p.Covers(peers[0].Ranges, whole) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The first client did not seed the file")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The second client fails to connect and punches a hole to the seed, the
+	// chunks requested during the punch going through the relay
+	ranges.Store(0)
+	relayed.Store(0)
+	outputPath := filepath.Join(t.TempDir(), "data.bin")
+	client := newP2PClient(url+"/data.bin", outputPath, 0)
+	client.config.P2PPunch = true
+	client.config.MaxConcurrency = 1
+	if err := client.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(outputPath); !bytes.Equal(got, content) {
+		t.Error("Downloaded file differs")
+	}
+	if n := ranges.Load(); n != 0 {
+		t.Errorf("%d range requests reached the server, want none", n)
+	}
+	if n := relayed.Load(); n != 0 {
+		t.Errorf("%d chunks went through the relay, want none", n)
+	}
+}
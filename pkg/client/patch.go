@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/easzlab/ezft/pkg/compress"
+	"github.com/easzlab/ezft/pkg/delta"
+	"go.uber.org/zap"
+)
+
+// errPatchUnsupported is returned when the server does not serve diffs
+var errPatchUnsupported = errors.New("server does not support binary diffs")
+
+// patchBase returns the path on the server of the version the local file of
+// PatchFrom holds, by default the file of the same name next to the download
+func (c *Client) patchBase() (string, error) {
+	u, err := url.Parse(c.config.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	base := c.config.PatchBase
+	if base == "" {
+		base = filepath.Base(c.config.PatchFrom)
+	}
+	if !path.IsAbs(base) {
+		base = path.Join(path.Dir(u.Path), base)
+	}
+	return base, nil
+}
+
+// patchDownload builds the output file from the local older version given by
+// PatchFrom and the diff the server generates from that version to the file
+func (c *Client) patchDownload(ctx context.Context) error {
+	base, err := os.Open(c.config.PatchFrom)
+	if err != nil {
+		return fmt.Errorf("failed to open base file: %w", err)
+	}
+	defer base.Close()
+	baseInfo, err := base.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat base file: %w", err)
+	}
+
+	basePath, err := c.patchBase()
+	if err != nil {
+		return err
+	}
+	diffURL, err := c.apiURL("diff")
+	if err != nil {
+		return err
+	}
+	diffURL += "?from=" + url.QueryEscape(basePath)
+
+	req, err := c.newRequest(ctx, "GET", diffURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// Either the server or the base version on it is missing
+		return errPatchUnsupported
+	default:
+		return fmt.Errorf("diff request failed, status code: %d", resp.StatusCode)
+	}
+	if size := resp.Header.Get("X-Ezft-Base-Size"); size != strconv.FormatInt(baseInfo.Size(), 10) {
+		return fmt.Errorf("base file has %d bytes, the version %s on the server %s", baseInfo.Size(), basePath, size)
+	}
+
+	// Rebuild into a temporary file, the base may be the output file itself
+	tmpPath := c.config.OutputPath + ".ezft.tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	written, err := applyDiff(base, baseInfo.Size(), resp, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to apply diff: %w", err)
+	}
+
+	base.Close()
+	if err := os.Rename(tmpPath, c.config.OutputPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+
+	c.logger.Info("Patch download completed",
+		zap.String("base", basePath),
+		zap.Int64("diff", resp.ContentLength),
+		zap.Int64("written", written),
+	)
+	return nil
+}
+
+// applyDiff writes to w the file rebuilt from base and the diff of resp, in
+// the format the server chose; servers without the format header send deltas
+func applyDiff(base io.ReaderAt, baseSize int64, resp *http.Response, w io.Writer) (int64, error) {
+	switch format := resp.Header.Get("X-Ezft-Diff-Format"); format {
+	case "", "delta":
+		blockSize, err := strconv.Atoi(resp.Header.Get("X-Ezft-Block-Size"))
+		if err != nil {
+			return 0, errPatchUnsupported
+		}
+		return delta.Apply(base, blockSize, resp.Body, w)
+	case "zstd":
+		if baseSize > compress.MaxPatchSize {
+			return 0, fmt.Errorf("base file of %d bytes too large for a zstd diff", baseSize)
+		}
+		data := make([]byte, baseSize)
+		if _, err := base.ReadAt(data, 0); err != nil && err != io.EOF {
+			return 0, err
+		}
+		r, err := compress.NewPatchReader(resp.Body, data)
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+		return io.Copy(w, r)
+	default:
+		return 0, fmt.Errorf("unsupported diff format %q: %w", format, errPatchUnsupported)
+	}
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/delta"
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+)
+
+func TestPatchDownload(t *testing.T) {
+	serverDir := t.TempDir()
+	oldContent := make([]byte, 2*1024*1024)
+	rand.New(rand.NewSource(7)).Read(oldContent)
+	newContent := append([]byte("v2"), oldContent...)
+	copy(newContent[1024*1024:], bytes.Repeat([]byte("y"), 4096))
+	os.WriteFile(filepath.Join(serverDir, "image-v1.img"), oldContent, 0644)
+	os.WriteFile(filepath.Join(serverDir, "image-v2.img"), newContent, 0644)
+
+	srv := server.NewServer(serverDir, 0)
+	srv.SetLogger(zap.NewNop())
+	handler := srv.Handler()
+	var full atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/image-v2.img" {
+			full.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	download := func(base, patchBase, outputPath string) {
+		t.Helper()
+		client := NewClient(&DownloadConfig{
+			URL:          ts.URL + "/image-v2.img",
+			OutputPath:   outputPath,
+			ChunkSize:    512 * 1024,
+			RetryCount:   1,
+			EnableResume: true,
+			PatchFrom:    base,
+			PatchBase:    patchBase,
+		})
+		client.SetLogger(zap.NewNop())
+		if err := client.Download(context.Background()); err != nil {
+			t.Fatalf("Download() error = %v", err)
+		}
+		if got, _ := os.ReadFile(outputPath); !bytes.Equal(got, newContent) {
+			t.Fatal("Downloaded file differs")
+		}
+	}
+
+	// The local copy named like the old version is updated in place
+	clientDir := t.TempDir()
+	base := filepath.Join(clientDir, "image-v1.img")
+	os.WriteFile(base, oldContent, 0644)
+	download(base, "", base)
+	if n := full.Load(); n != 0 {
+		t.Errorf("%d requests for the whole file, want none", n)
+	}
+
+	// A base differing from the version on the server falls back to a full download
+	other := filepath.Join(clientDir, "other.img")
+	os.WriteFile(other, oldContent[:1024], 0644)
+	download(other, "/image-v1.img", filepath.Join(clientDir, "image-v2.img"))
+	if full.Load() == 0 {
+		t.Error("The whole file should be downloaded")
+	}
+}
+
+func TestApplyDiff(t *testing.T) {
+	oldContent := make([]byte, 256*1024)
+	rand.New(rand.NewSource(9)).Read(oldContent)
+	newContent := append([]byte("v2"), oldContent...)
+	sig, err := delta.ComputeSignature(bytes.NewReader(oldContent), 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diff bytes.Buffer
+	if _, err := delta.ComputeDelta(sig, bytes.NewReader(newContent), &diff); err != nil {
+		t.Fatal(err)
+	}
+
+	// Servers predating the format header send deltas
+	resp := &http.Response{Header: http.Header{"X-Ezft-Block-Size": {"4096"}}, Body: io.NopCloser(&diff)}
+	var rebuilt bytes.Buffer
+	if _, err := applyDiff(bytes.NewReader(oldContent), int64(len(oldContent)), resp, &rebuilt); err != nil || !bytes.Equal(rebuilt.Bytes(), newContent) {
+		t.Errorf("applyDiff() of a delta failed: %v", err)
+	}
+
+	resp = &http.Response{Header: http.Header{"X-Ezft-Diff-Format": {"bsdiff"}}, Body: io.NopCloser(&diff)}
+	if _, err := applyDiff(bytes.NewReader(oldContent), int64(len(oldContent)), resp, io.Discard); !errors.Is(err, errPatchUnsupported) {
+		t.Errorf("applyDiff() of an unknown format error = %v", err)
+	}
+}
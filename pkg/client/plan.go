@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/easzlab/ezft/pkg/compress"
+	"github.com/easzlab/ezft/pkg/manifest"
+)
+
+// Download strategies of a plan
+const (
+	StrategyComplete = "complete" // The output file is complete already
+	StrategyChunked  = "chunked"  // Range requests of chunks, resumable
+	StrategyBasic    = "basic"    // A single request of the whole file, not resumable
+	StrategyOffset   = "offset"   // A single request of the bytes from the end of the output file, resumable
+	StrategyDelta    = "delta"    // Only the blocks differing from the output file
+	StrategyPatch    = "patch"    // A binary diff from the older version of PatchFrom
+)
+
+// Plan the strategy a download would follow, probed without transferring data
+type Plan struct {
+	URL           string `json:"url"`
+	OutputPath    string `json:"path"`
+	Size          int64  `json:"size"`
+	SupportsRange bool   `json:"supports_range"`
+	Strategy      string `json:"strategy"`
+	Transport     string `json:"transport,omitempty"`   // quic, http or http/2 for chunked downloads
+	Compression   string `json:"compression,omitempty"` // Outcome of the compression handshake when asked for
+	ChunkSize     int64  `json:"chunk_size,omitempty"`
+	Chunks        int    `json:"chunks,omitempty"` // Chunks left to download
+	Concurrency   int    `json:"concurrency"`
+	ExistingSize  int64  `json:"existing_size"`           // Bytes of the output file on disk
+	FailedChunks  int    `json:"failed_chunks,omitempty"` // Recorded failed chunks downloaded first
+	Remaining     int64  `json:"remaining"`               // Bytes left to download, at most
+	VerifyChunks  bool   `json:"verify_chunks,omitempty"`
+}
+
+// Plan probes the server for the file and its capabilities, and returns how
+// Download would download it. Nothing is written.
+func (c *Client) Plan(ctx context.Context) (*Plan, error) {
+	fileSize, supportsRange, err := c.getFileInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existingSize, err := c.getExistingFileSize()
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{
+		URL:           c.config.URL,
+		OutputPath:    c.config.OutputPath,
+		Size:          fileSize,
+		SupportsRange: supportsRange,
+		Concurrency:   max(c.config.MaxConcurrency, 1),
+		ExistingSize:  existingSize,
+		Remaining:     fileSize,
+		VerifyChunks:  c.config.VerifyChunks || c.config.ChunkManifest != "",
+	}
+
+	switch {
+	case fileSize < 0:
+		// Streamed whole as the server does not tell the size
+		plan.Strategy = StrategyBasic
+		plan.Concurrency = 1
+		return plan, nil
+	case c.config.PatchFrom != "":
+		plan.Strategy = StrategyPatch
+		return plan, nil
+	case c.config.EnableDelta && existingSize > 0:
+		plan.Strategy = StrategyDelta
+		return plan, nil
+	case existingSize == fileSize && !plan.VerifyChunks && !c.hasFailedChunks():
+		plan.Strategy = StrategyComplete
+		plan.Remaining = 0
+		return plan, nil
+	case !supportsRange || !c.config.EnableResume:
+		plan.Strategy = StrategyBasic
+		plan.Concurrency = 1
+		plan.Compression = c.planCompression(ctx)
+		return plan, nil
+	case c.config.OffsetResume && !plan.VerifyChunks:
+		offset, err := c.resumeOffset(min(existingSize, fileSize))
+		if err != nil {
+			return nil, err
+		}
+		plan.Strategy = StrategyOffset
+		plan.Transport = "http"
+		plan.Concurrency = 1
+		plan.Remaining = fileSize - offset
+		return plan, nil
+	}
+
+	plan.Strategy = StrategyChunked
+	plan.Transport = "http"
+	if c.config.Multiplex {
+		plan.Transport = "http/2"
+	}
+	if c.canUseQUIC() && c.offers(ctx, "quic") {
+		plan.Transport = "quic"
+	} else {
+		plan.Compression = c.planCompression(ctx)
+	}
+
+	if plan.VerifyChunks {
+		// Chunks on disk are hashed, at worst all are downloaded
+		chunkSize := c.config.ChunkSize
+		if c.config.AutoChunk {
+			chunkSize = calculateChunkSize(fileSize)
+		}
+		plan.ChunkSize = min(max(chunkSize, manifest.MinChunkSize), manifest.MaxChunkSize)
+		plan.Chunks = int((fileSize + plan.ChunkSize - 1) / plan.ChunkSize)
+		return plan, nil
+	}
+
+	failedChunks, err := c.loadFailedChunks()
+	if err != nil {
+		return nil, err
+	}
+	plan.FailedChunks = len(failedChunks)
+	plan.Remaining = max(fileSize-existingSize, 0)
+	for _, chunk := range failedChunks {
+		plan.Remaining += chunk.End - chunk.Start + 1
+	}
+	config := *c.config
+	planner := &Client{config: &config}
+	chunks := planner.calculateChunks(min(existingSize, fileSize), fileSize)
+	plan.ChunkSize = config.ChunkSize
+	plan.Chunks = len(failedChunks) + len(chunks)
+	return plan, nil
+}
+
+// offers reports whether the server offers the API endpoint for the file
+func (c *Client) offers(ctx context.Context, endpoint string) bool {
+	u, err := c.apiURL(endpoint)
+	if err != nil {
+		return false
+	}
+	req, err := c.newRequest(ctx, "GET", u, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// planCompression describes the outcome of the compression handshake, empty
+// unless compression is asked for
+func (c *Client) planCompression(ctx context.Context) string {
+	if !c.config.Compress {
+		return ""
+	}
+	u, err := c.apiURL("compression")
+	if err != nil {
+		return "unavailable"
+	}
+	req, err := c.newRequest(ctx, "GET", u, nil)
+	if err != nil {
+		return "unavailable"
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "unavailable"
+	}
+	defer resp.Body.Close()
+	var offer compress.Offer
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&offer) != nil {
+		return "unavailable"
+	}
+	if !offer.Compressible {
+		return "declined: " + offer.Reason
+	}
+	return compress.Zstd
+}
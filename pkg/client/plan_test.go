@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestPlan(t *testing.T) {
+	serverDir := t.TempDir()
+	content := strings.Repeat("x", 100)
+	if err := os.WriteFile(filepath.Join(serverDir, "file.bin"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var ranges atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		http.FileServer(http.Dir(serverDir)).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		existing     string
+		enableResume bool
+		offsetResume bool
+		want         Plan
+	}{
+		{"new", "", true, false, Plan{Strategy: StrategyChunked, Transport: "http", ChunkSize: 30, Chunks: 4, Concurrency: 2, Remaining: 100}},
+		{"resume", content[:40], true, false, Plan{Strategy: StrategyChunked, Transport: "http", ChunkSize: 30, Chunks: 2, Concurrency: 2, ExistingSize: 40, Remaining: 60}},
+		{"complete", content, true, false, Plan{Strategy: StrategyComplete, Concurrency: 2, ExistingSize: 100}},
+		{"basic", "", false, false, Plan{Strategy: StrategyBasic, Concurrency: 1, Remaining: 100}},
+		{"offset", content[:40], true, true, Plan{Strategy: StrategyOffset, Transport: "http", Concurrency: 1, ExistingSize: 40, Remaining: 60}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "file.bin")
+			if tt.existing != "" {
+				if err := os.WriteFile(output, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			client := NewClient(&DownloadConfig{
+				URL:            ts.URL + "/file.bin",
+				OutputPath:     output,
+				ChunkSize:      30,
+				MaxConcurrency: 2,
+				EnableResume:   tt.enableResume,
+				OffsetResume:   tt.offsetResume,
+			})
+			client.SetLogger(zap.NewNop())
+			plan, err := client.Plan(context.Background())
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+			want := tt.want
+			want.URL, want.OutputPath, want.Size, want.SupportsRange = ts.URL+"/file.bin", output, 100, true
+			if *plan != want {
+				t.Errorf("Plan() = %+v, want %+v", *plan, want)
+			}
+			if got, _ := os.ReadFile(output); string(got) != tt.existing {
+				t.Errorf("Output file changed to %q", got)
+			}
+		})
+	}
+	if n := ranges.Load(); n != 0 {
+		t.Errorf("%d range requests transferred data", n)
+	}
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// prewarm opens up to PrewarmConns connections to the server at the same
+// time, by sending as many HEAD requests at once, so that the workers of a
+// concurrent download do not each wait for their TCP and TLS handshakes in
+// turn. The connections are then idle in the pool of the transport, which
+// keeps MaxConcurrency of them.
+func (c *Client) prewarm(ctx context.Context) {
+	n := min(c.config.PrewarmConns, c.config.MaxConcurrency)
+	if n < 2 || c.config.Multiplex {
+		return
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed int
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := c.newRequest(ctx, "HEAD", c.config.URL, nil)
+			if err == nil {
+				var resp *http.Response
+				if resp, err = c.httpClient.Do(req); err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			}
+			if err != nil {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	c.logger.Debug("Pre-warmed connections",
+		zap.Int("connections", n),
+		zap.Int("failed", failed),
+		zap.Duration("duration", time.Since(start)),
+	)
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestPrewarm(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Keep the requests overlapping so each opens its connection
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Length", "10")
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	c := NewClient(&DownloadConfig{
+		URL:            server.URL + "/test.bin",
+		MaxConcurrency: 4,
+		PrewarmConns:   8,
+	})
+	c.SetLogger(zap.NewNop())
+	c.prewarm(context.Background())
+	if n := conns.Load(); n != 4 {
+		t.Fatalf("prewarm() opened %d connections, want 4", n)
+	}
+
+	// The chunks reuse the idle connections
+	for range 4 {
+		resp, err := c.httpClient.Get(server.URL + "/test.bin")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		resp.Body.Close()
+	}
+	if n := conns.Load(); n != 4 {
+		t.Errorf("Requests after prewarm() opened %d more connections", n-4)
+	}
+}
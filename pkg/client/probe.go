@@ -0,0 +1,121 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/quicproto"
+)
+
+// probeCacheTTL time the probes of a server are trusted for, servers may be
+// reconfigured or upgraded in between
+const probeCacheTTL = time.Hour
+
+// ServerProbes what the probes of a server found, the same for all its files
+type ServerProbes struct {
+	Ranges  *bool                `json:"ranges,omitempty"`  // Whether the server answers range requests without advertising it, nil if not probed
+	QUIC    *quicproto.Discovery `json:"quic,omitempty"`    // Native protocol endpoint of the server, nil if not probed or not offered
+	NoQUIC  bool                 `json:"no_quic,omitempty"` // Whether the server does not offer the native protocol
+	Updated time.Time            `json:"updated"`           // Time of the last probe
+}
+
+// ProbeCache keeps the results of the probes of servers by scheme and host,
+// so that downloads of many files from a server probe it once. It is kept in
+// memory, and in a file if it has a path.
+type ProbeCache struct {
+	path    string
+	mu      sync.Mutex
+	servers map[string]*ServerProbes
+}
+
+// NewProbeCache creates a cache kept in memory only
+func NewProbeCache() *ProbeCache {
+	return &ProbeCache{servers: make(map[string]*ServerProbes)}
+}
+
+// LoadProbeCache loads the cache kept in the file at path, which is created
+// on the first probe if it does not exist and replaced if it is invalid
+func LoadProbeCache(path string) (*ProbeCache, error) {
+	pc := NewProbeCache()
+	pc.path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pc, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read probe cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &pc.servers); err != nil || pc.servers == nil {
+		pc.servers = make(map[string]*ServerProbes)
+	}
+	return pc, nil
+}
+
+// probeKey returns the key of the server of rawURL in the cache
+func probeKey(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	return u.Scheme + "://" + u.Host, true
+}
+
+// get returns the probes of the server of rawURL, false if unknown or expired
+func (pc *ProbeCache) get(rawURL string) (ServerProbes, bool) {
+	key, ok := probeKey(rawURL)
+	if !ok {
+		return ServerProbes{}, false
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	p, ok := pc.servers[key]
+	if !ok || time.Since(p.Updated) > probeCacheTTL {
+		return ServerProbes{}, false
+	}
+	return *p, true
+}
+
+// update records the probes of the server of rawURL changed by f, saving
+// the cache if it has a file
+func (pc *ProbeCache) update(rawURL string, f func(*ServerProbes)) error {
+	key, ok := probeKey(rawURL)
+	if !ok {
+		return nil
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	p, ok := pc.servers[key]
+	if !ok || time.Since(p.Updated) > probeCacheTTL {
+		p = &ServerProbes{}
+		pc.servers[key] = p
+	}
+	f(p)
+	p.Updated = time.Now()
+	if pc.path == "" {
+		return nil
+	}
+
+	// Expired servers are dropped from the file
+	for k, p := range pc.servers {
+		if time.Since(p.Updated) > probeCacheTTL {
+			delete(pc.servers, k)
+		}
+	}
+	data, err := json.MarshalIndent(pc.servers, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pc.path), 0755); err != nil {
+		return err
+	}
+	tmp := pc.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, pc.path)
+}
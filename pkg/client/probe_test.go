@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestProbeCache(t *testing.T) {
+	// A server answering range requests without advertising them
+	var rangeProbes, quicProbes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, apiPrefix+"quic/") {
+			quicProbes.Add(1)
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Range") == "bytes=0-0" {
+			rangeProbes.Add(1)
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("0"))
+			return
+		}
+		w.Header().Set("Content-Length", "10")
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "probes.json")
+	probes, err := LoadProbeCache(path)
+	if err != nil {
+		t.Fatalf("LoadProbeCache() error = %v", err)
+	}
+	for _, name := range []string{"a.bin", "b.bin"} {
+		c := NewClient(&DownloadConfig{URL: server.URL + "/" + name, OutputPath: filepath.Join(t.TempDir(), name)})
+		c.SetLogger(zap.NewNop())
+		c.SetProbeCache(probes)
+		if _, supportsRange, err := c.getFileInfo(context.Background()); err != nil || !supportsRange {
+			t.Fatalf("getFileInfo() = %v, %v", supportsRange, err)
+		}
+		if _, _, err := c.discoverQUIC(context.Background()); err != errQUICUnsupported {
+			t.Fatalf("discoverQUIC() error = %v, want %v", err, errQUICUnsupported)
+		}
+	}
+	if rangeProbes.Load() != 1 || quicProbes.Load() != 1 {
+		t.Errorf("Server probed %d times for ranges and %d for QUIC, want once", rangeProbes.Load(), quicProbes.Load())
+	}
+
+	// The probes are kept in the file for other processes
+	loaded, err := LoadProbeCache(path)
+	if err != nil {
+		t.Fatalf("LoadProbeCache() error = %v", err)
+	}
+	p, ok := loaded.get(server.URL + "/c.bin")
+	if !ok || p.Ranges == nil || !*p.Ranges || !p.NoQUIC {
+		t.Errorf("Loaded probes = %+v, %v", p, ok)
+	}
+
+	// Expired probes are probed again
+	loaded.servers["http://"+server.Listener.Addr().String()].Updated = time.Now().Add(-2 * probeCacheTTL)
+	if _, ok := loaded.get(server.URL + "/c.bin"); ok {
+		t.Error("Expired probes still used")
+	}
+}
+
+func TestLoadProbeCacheInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "probes.json")
+	if err := os.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatalf("Failed to write probe cache: %v", err)
+	}
+	probes, err := LoadProbeCache(path)
+	if err != nil {
+		t.Fatalf("LoadProbeCache() error = %v", err)
+	}
+	if err := probes.update("http://example.com/file", func(p *ServerProbes) { p.NoQUIC = true }); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+	if p, ok := probes.get("http://example.com/other"); !ok || !p.NoQUIC {
+		t.Errorf("get() = %+v, %v", p, ok)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/easzlab/ezft/pkg/utils"
 )
 
 // GetProgress gets download progress
@@ -12,9 +14,12 @@ func (c *Client) GetProgress() (float64, error) {
 	if c.config.FileSize == 0 {
 		return 0, fmt.Errorf("file size is 0")
 	}
+	if c.config.FileSize < 0 {
+		return 0, fmt.Errorf("file size unknown")
+	}
 
 	// Get current downloaded size
-	currentSize, err := c.getExistingFileSize()
+	currentSize, err := c.downloadedSize()
 	if err != nil {
 		return 0, err
 	}
@@ -22,25 +27,71 @@ func (c *Client) GetProgress() (float64, error) {
 	return float64(currentSize) / float64(c.config.FileSize) * 100, nil
 }
 
+// downloadedSize gets the bytes of the file downloaded. Chunks written at any
+// offset and holes left by failed chunks make the file size wrong for chunked
+// downloads, their chunks count the bytes written instead.
+func (c *Client) downloadedSize() (int64, error) {
+	if c.chunked.Load() {
+		return c.downloaded.Load(), nil
+	}
+	return c.getExistingFileSize()
+}
+
 func (c *Client) ShowProgressLoop(ctx context.Context) {
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
+
+	// The ETA is estimated from the rate since the first known size
+	startSize := int64(-1)
+	var start time.Time
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			progress, err := c.GetProgress()
+			if c.config.FileSize == 0 {
+				continue
+			}
+			size, err := c.downloadedSize()
 			if err != nil {
 				continue
 			}
+			if startSize < 0 {
+				startSize, start = size, time.Now()
+			}
 
-			// Simple progress bar display
-			barWidth := 50
-			filled := int(progress * float64(barWidth) / 100)
-			bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+			// Streams of unknown size show the bytes and rate instead
+			if c.config.FileSize < 0 {
+				speed := utils.CalculateSpeed(size-startSize, time.Since(start))
+				fmt.Fprintf(c.out, "\rDownload progress: [%s] %s at %s\x1b[K", indeterminateBar(time.Since(start), 50), utils.FormatBytes(size), speed)
+				continue
+			}
 
-			fmt.Printf("\rDownload progress: [%s] %.1f%%", bar, progress)
+			eta := "-"
+			if elapsed := time.Since(start); size > startSize && elapsed > 0 {
+				rate := float64(size-startSize) / elapsed.Seconds()
+				eta = utils.FormatDuration(time.Duration(float64(max(c.config.FileSize-size, 0)) / rate * float64(time.Second)))
+			}
+			progress := float64(size) / float64(c.config.FileSize) * 100
+			fmt.Fprintf(c.out, "\rDownload progress: [%s] %.1f%% ETA %s\x1b[K", progressBar(progress, 50), progress, eta)
 		}
 	}
 }
+
+// progressBar renders progress in percent as a bar of width characters
+func progressBar(progress float64, width int) string {
+	filled := min(max(int(progress*float64(width)/100), 0), width)
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+// indeterminateBar renders a bar of width characters for progress of unknown
+// extent, a block moving back and forth over it with elapsed time
+func indeterminateBar(elapsed time.Duration, width int) string {
+	block := max(width/5, 1)
+	span := width - block
+	step := int(elapsed/(100*time.Millisecond)) % (2 * max(span, 1))
+	if step > span {
+		step = 2*span - step
+	}
+	return strings.Repeat("░", step) + strings.Repeat("█", block) + strings.Repeat("░", width-block-step)
+}
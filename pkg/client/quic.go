@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/quicproto"
+	"go.uber.org/zap"
+)
+
+// quicDialTimeout bounds connecting over QUIC, networks dropping UDP fall
+// back to HTTP after it
+const quicDialTimeout = 5 * time.Second
+
+// errQUICUnsupported is returned when the server does not offer the native protocol
+var errQUICUnsupported = errors.New("server does not support QUIC")
+
+// quicConn the native protocol connection of a download
+type quicConn struct {
+	conn  *quicproto.Conn
+	path  string // Path of the file on the server
+	token string // Resume token of the file
+	blast bool   // Whether the server supports the UDP blast mode
+}
+
+// readChunk downloads a chunk over QUIC, verified by its hash. With a rate it
+// is blasted as UDP datagrams instead of being read from a stream.
+func (q *quicConn) readChunk(ctx context.Context, file *os.File, chunk Chunk, rate int64) error {
+	length := chunk.End - chunk.Start + 1
+	if rate > 0 {
+		return q.conn.BlastChunk(ctx, q.path, q.token, chunk.Start, length, rate, file)
+	}
+	w := io.NewOffsetWriter(file, chunk.Start)
+	return q.conn.ReadChunk(ctx, q.path, q.token, chunk.Start, length, w)
+}
+
+// blastRate returns the rate to blast a chunk at: its part of the UDP rate
+// within the bandwidth limit in effect, 0 to read it from a stream
+func (c *Client) blastRate() int64 {
+	rate := c.config.UDPRate
+	if rate <= 0 || !c.quic.blast {
+		return 0
+	}
+	if c.limiter != nil {
+		if limit := c.limiter.Rate(); limit > 0 {
+			rate = min(rate, limit)
+		}
+	}
+	return max(rate/int64(max(c.config.MaxConcurrency, 1)), 1)
+}
+
+// tokenPath returns the file keeping the resume token of the download
+func (c *Client) tokenPath() string {
+	return c.config.OutputPath + ".ezft.token"
+}
+
+// connectQUIC connects to the native protocol of an ezft server for the
+// download. A partial output file is discarded when the resume token it was
+// downloaded with no longer matches the remote file.
+func (c *Client) connectQUIC(ctx context.Context) error {
+	discovery, cached, err := c.discoverQUIC(ctx)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(c.config.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, quicDialTimeout)
+	defer cancel()
+	conn, err := quicproto.Dial(dialCtx, net.JoinHostPort(u.Hostname(), strconv.Itoa(discovery.Port)), discovery.Fingerprint)
+	if err != nil {
+		// The server may have been restarted on another port or certificate
+		if cached {
+			c.probes.update(c.config.URL, func(p *ServerProbes) { p.QUIC = nil })
+		}
+		return err
+	}
+	conn.SetAuth(c.config.Username, c.config.Password)
+	conn.SetRateLimiter(c.limiter)
+
+	info, err := conn.Stat(ctx, u.Path)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if info.Size != c.config.FileSize {
+		conn.Close()
+		return fmt.Errorf("file size changed from %d to %d bytes", c.config.FileSize, info.Size)
+	}
+
+	saved, err := os.ReadFile(c.tokenPath())
+	if err == nil && string(saved) != info.Token {
+		c.logger.Info("remote file changed, restarting download",
+			zap.String("path", c.config.OutputPath),
+		)
+		if err := os.Remove(c.config.OutputPath); err != nil && !os.IsNotExist(err) {
+			conn.Close()
+			return err
+		}
+		c.chunkRecord().Remove()
+	}
+	if err := os.MkdirAll(filepath.Dir(c.config.OutputPath), 0755); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(c.tokenPath(), []byte(info.Token), 0644); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to save resume token: %w", err)
+	}
+
+	c.quic = &quicConn{conn: conn, path: u.Path, token: info.Token, blast: discovery.Blast}
+	c.logger.Info("downloading over QUIC",
+		zap.String("addr", net.JoinHostPort(u.Hostname(), strconv.Itoa(discovery.Port))),
+		zap.Int64("blastRate", c.blastRate()),
+	)
+	return nil
+}
+
+// discoverQUIC asks the server for its native protocol endpoint, unless the
+// probe cache knows it, and reports whether it came from the cache
+func (c *Client) discoverQUIC(ctx context.Context) (*quicproto.Discovery, bool, error) {
+	if c.probes != nil {
+		if p, ok := c.probes.get(c.config.URL); ok && p.NoQUIC {
+			return nil, true, errQUICUnsupported
+		} else if ok && p.QUIC != nil {
+			return p.QUIC, true, nil
+		}
+	}
+
+	discoveryURL, err := c.apiURL("quic")
+	if err != nil {
+		return nil, false, err
+	}
+	req, err := c.newRequest(ctx, "GET", discoveryURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		c.recordQUIC(nil)
+		return nil, false, errQUICUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("server returned error status: %d", resp.StatusCode)
+	}
+	var discovery quicproto.Discovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, false, fmt.Errorf("invalid QUIC discovery: %w", err)
+	}
+	c.recordQUIC(&discovery)
+	return &discovery, false, nil
+}
+
+// recordQUIC records the native protocol endpoint of the server in the probe
+// cache, nil if it is not offered
+func (c *Client) recordQUIC(discovery *quicproto.Discovery) {
+	if c.probes == nil {
+		return
+	}
+	err := c.probes.update(c.config.URL, func(p *ServerProbes) {
+		p.QUIC, p.NoQUIC = discovery, discovery == nil
+	})
+	if err != nil {
+		c.logger.Debug("Failed to save the probe cache", zap.Error(err))
+	}
+}
+
+// closeQUIC closes the native protocol connection of the download
+func (c *Client) closeQUIC() {
+	c.quic.conn.Close()
+	c.quic = nil
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+)
+
+// newQUICServer serves dir over HTTP and QUIC, counting the HTTP range requests
+func newQUICServer(t *testing.T, dir string) (string, *atomic.Int32) {
+	t.Helper()
+
+	srv := server.NewServer(dir, 0)
+	srv.SetLogger(zap.NewNop())
+	if err := srv.ListenQUIC("127.0.0.1:0"); err != nil {
+		t.Fatalf("ListenQUIC() error = %v", err)
+	}
+	ranges := &atomic.Int32{}
+	handler := srv.Handler()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL, ranges
+}
+
+func TestQUICDownload(t *testing.T) {
+	serverDir := t.TempDir()
+	content := make([]byte, 2*1024*1024+123)
+	rand.New(rand.NewSource(1)).Read(content)
+	if err := os.WriteFile(filepath.Join(serverDir, "data.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	url, ranges := newQUICServer(t, serverDir)
+
+	for _, disable := range []bool{false, true} {
+		ranges.Store(0)
+		outputPath := filepath.Join(t.TempDir(), "data.bin")
+		client := NewClient(&DownloadConfig{
+			URL:            url + "/data.bin",
+			OutputPath:     outputPath,
+			ChunkSize:      256 * 1024,
+			MaxConcurrency: 4,
+			RetryCount:     1,
+			EnableResume:   true,
+			DisableQUIC:    disable,
+		})
+		client.SetLogger(zap.NewNop())
+		if err := client.Download(context.Background()); err != nil {
+			t.Fatalf("Download() error = %v", err)
+		}
+
+		got, _ := os.ReadFile(outputPath)
+		if !bytes.Equal(got, content) {
+			t.Errorf("Downloaded file differs (QUIC disabled: %v)", disable)
+		}
+		if n := ranges.Load(); (n == 0) == disable {
+			t.Errorf("%d HTTP range requests with QUIC disabled: %v", n, disable)
+		}
+		if _, err := os.Stat(outputPath + ".ezft.token"); !os.IsNotExist(err) {
+			t.Error("Resume token must be removed after the download")
+		}
+	}
+}
+
+func TestQUICBlastDownload(t *testing.T) {
+	serverDir := t.TempDir()
+	content := make([]byte, 3*1024*1024+5)
+	rand.New(rand.NewSource(2)).Read(content)
+	if err := os.WriteFile(filepath.Join(serverDir, "data.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	url, ranges := newQUICServer(t, serverDir)
+
+	outputPath := filepath.Join(t.TempDir(), "down", "data.bin")
+	client := NewClient(&DownloadConfig{
+		URL:            url + "/data.bin",
+		OutputPath:     outputPath,
+		ChunkSize:      512 * 1024,
+		MaxConcurrency: 3,
+		RetryCount:     1,
+		EnableResume:   true,
+		UDPRate:        100 * 1024 * 1024,
+	})
+	client.SetLogger(zap.NewNop())
+	if err := client.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(outputPath); !bytes.Equal(got, content) {
+		t.Error("Downloaded file differs")
+	}
+	if n := ranges.Load(); n != 0 {
+		t.Errorf("%d HTTP range requests, want none", n)
+	}
+}
+
+func TestQUICResumeToken(t *testing.T) {
+	serverDir := t.TempDir()
+	content := bytes.Repeat([]byte("new version "), 100000)
+	if err := os.WriteFile(filepath.Join(serverDir, "data.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	url, _ := newQUICServer(t, serverDir)
+
+	// A partial download of an older version of the file
+	outputPath := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(outputPath, bytes.Repeat([]byte("old"), 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outputPath+".ezft.token", []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(&DownloadConfig{
+		URL:          url + "/data.bin",
+		OutputPath:   outputPath,
+		ChunkSize:    64 * 1024,
+		RetryCount:   1,
+		EnableResume: true,
+	})
+	client.SetLogger(zap.NewNop())
+	if err := client.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(outputPath); !bytes.Equal(got, content) {
+		t.Error("The partial file of the old version must be discarded")
+	}
+}
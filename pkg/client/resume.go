@@ -2,13 +2,84 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/easzlab/ezft/pkg/utils"
 	"go.uber.org/zap"
 )
 
+// errRemoteChanged is returned by chunk requests answered with the whole file
+// because the remote file no longer matches the ETag of their If-Range
+var errRemoteChanged = errors.New("remote file changed since the download started")
+
+// etagPath returns the file keeping the ETag of the remote file a partial
+// output file was downloaded from
+func (c *Client) etagPath() string {
+	return c.config.OutputPath + ".ezft.etag"
+}
+
+// setIfRange makes the chunk requests of the download conditional on the ETag
+// the partial output file was downloaded from, or on that of the remote file
+// when starting, and records it for resuming. If-Range only compares strong
+// ETags, so the requests are unconditional without one.
+func (c *Client) setIfRange(file *os.File) error {
+	c.ifRange = ""
+	if c.summary != nil && !strings.HasPrefix(c.summary.ETag, "W/") {
+		c.ifRange = c.summary.ETag
+	}
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		if saved, err := os.ReadFile(c.etagPath()); err == nil && len(saved) > 0 {
+			c.ifRange = string(saved)
+		}
+	}
+	if c.ifRange == "" {
+		os.Remove(c.etagPath())
+		return nil
+	}
+	return utils.WriteFileAtomic(c.etagPath(), []byte(c.ifRange), 0644)
+}
+
+// discardPartial empties the output file and forgets the chunks downloaded
+// into it, to download the file again from the start
+func (c *Client) discardPartial() error {
+	if err := os.Truncate(c.config.OutputPath, 0); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to discard partial file: %w", err)
+	}
+	c.chunkRecord().Remove()
+	os.Remove(c.verifiedPath())
+	os.Remove(c.etagPath())
+	c.downloaded.Store(0)
+	return nil
+}
+
+// resize replans the download for a file of size bytes, truncating the output
+// file and the recorded failed chunks to it
+func (c *Client) resize(size int64) error {
+	c.config.FileSize = size
+	if existing, err := c.getExistingFileSize(); err == nil && existing > size {
+		if err := os.Truncate(c.config.OutputPath, size); err != nil {
+			return fmt.Errorf("failed to truncate file: %w", err)
+		}
+	}
+	failedChunks, err := c.loadFailedChunks()
+	if err != nil || len(failedChunks) == 0 {
+		return err
+	}
+	var kept []Chunk
+	for _, chunk := range failedChunks {
+		if chunk.Start < size {
+			chunk.End = min(chunk.End, size-1)
+			kept = append(kept, chunk)
+		}
+	}
+	return c.saveFailedChunks(kept)
+}
+
 // downloadWithResume downloads using resume functionality
 func (c *Client) downloadWithResume(ctx context.Context, fileSize int64) error {
 	// Create directory
@@ -16,77 +87,208 @@ func (c *Client) downloadWithResume(ctx context.Context, fileSize int64) error {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	// Open file for writing, use O_RDWR to support resume download
-	file, err := os.OpenFile(c.config.OutputPath, os.O_CREATE|os.O_RDWR, 0644)
+	// Open file for reading and writing to support resume download
+	file, err := openOutput(c.config.OutputPath)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	// Load failed chunks record
-	failedChunks, err := c.loadFailedChunks()
-	if err != nil {
-		return fmt.Errorf("failed to load failed chunks record: %w", err)
+	if err := c.setIfRange(file); err != nil {
+		return fmt.Errorf("failed to save ETag: %w", err)
 	}
 
-	// Download failed chunks
-	if len(failedChunks) > 0 {
-		if err := c.downloadChunksSequentially(ctx, file, failedChunks); err != nil {
-			return err
+	var chunks []Chunk
+	if c.chunkSums != nil {
+		// The chunks left are those not matching their hashes, which
+		// includes the recorded failures
+		c.chunkRecord().Remove()
+		chunks, err = c.resumeChunks(file)
+		if err != nil {
+			return fmt.Errorf("failed to check existing file: %w", err)
+		}
+		if len(chunks) == 0 {
+			return nil
+		}
+	} else {
+		// Load failed chunks record
+		failedChunks, err := c.loadFailedChunks()
+		if err != nil {
+			return fmt.Errorf("failed to load failed chunks record: %w", err)
 		}
-	}
 
-	// Update actual file size
-	newExistingSize, err := c.getExistingFileSize()
-	if err != nil {
-		return fmt.Errorf("failed to update actual file size: %w", err)
-	}
+		// Download failed chunks
+		if len(failedChunks) > 0 {
+			// The failed chunks may be holes below the size of the file
+			existingSize, err := c.getExistingFileSize()
+			if err != nil {
+				return fmt.Errorf("failed to check existing file: %w", err)
+			}
+			for _, chunk := range failedChunks {
+				existingSize -= max(min(chunk.End+1, existingSize)-chunk.Start, 0)
+			}
+			c.downloaded.Store(existingSize)
+			c.chunked.Store(true)
+			c.startJournal(file, failedChunks)
+			if err := c.downloadChunksSequentially(ctx, file, failedChunks); err != nil {
+				return err
+			}
+		}
 
-	// Recalculate remaining chunks
-	remainingSize := fileSize - newExistingSize
-	if remainingSize <= 0 {
-		return nil
+		// Update actual file size
+		newExistingSize, err := c.getExistingFileSize()
+		if err != nil {
+			return fmt.Errorf("failed to update actual file size: %w", err)
+		}
+
+		// Recalculate remaining chunks
+		if fileSize-newExistingSize <= 0 {
+			return nil
+		}
+		if c.config.AutoChunk && c.p2p == nil && fileSize-newExistingSize >= calibrationMinSize {
+			// Measure the link on the first bytes to size the chunks of the rest
+			next, err := c.calibrateChunkSize(ctx, file, newExistingSize, fileSize)
+			if err != nil {
+				return err
+			}
+			chunks = splitChunks(next, fileSize, c.config.ChunkSize)
+		} else {
+			chunks = c.calculateChunks(newExistingSize, fileSize)
+		}
+	}
+	var remainingSize, largest int64
+	for _, chunk := range chunks {
+		remainingSize += chunk.End - chunk.Start + 1
+		largest = max(largest, chunk.End-chunk.Start+1)
 	}
+	c.downloaded.Store(fileSize - remainingSize)
+	c.chunked.Store(true)
 
-	chunks := c.calculateChunks(newExistingSize, fileSize)
+	// Peers fetching the chunks in different orders have more to exchange.
+	// Concurrent downloads record all unfinished chunks as failed, so the
+	// order does not matter for resuming.
+	if c.p2p != nil && c.config.MaxConcurrency >= 2 {
+		rand.Shuffle(len(chunks), func(i, j int) { chunks[i], chunks[j] = chunks[j], chunks[i] })
+	}
 
-	c.logger.Debug("",
-		zap.String("msg", "Starting resume download"),
+	c.logger.Debug("Starting resume download",
 		zap.Int("chunks", len(chunks)),
 		zap.Int(("concurrent"), c.config.MaxConcurrency),
-		zap.Int64("downloaded", newExistingSize),
+		zap.Int64("downloaded", fileSize-remainingSize),
 		zap.Int64("remaining", remainingSize),
 	)
 
+	c.startHash(fileSize, chunks)
+
+	// Small chunks are written by blocks, unless they are read back before
+	// the download completed
+	if largest <= batchChunkLimit && c.chunkSums == nil && c.p2p == nil && c.quic == nil && !c.multiRange() {
+		c.batch = newWriteBatcher(file, chunks, c.batchWritten)
+		defer func() { c.batch = nil }()
+	}
+
+	// Chunks verified against their hashes need no record, the file is
+	// checked again when resuming
+	if c.chunkSums == nil {
+		c.startJournal(file, chunks)
+	}
+
 	// Use sequential download for remaining chunks
 	if c.config.MaxConcurrency < 2 {
-		return c.downloadChunksSequentially(ctx, file, chunks)
+		err = c.downloadChunksSequentially(ctx, file, chunks)
+	} else {
+		// Use concurrent download for remaining chunks
+		if c.quic == nil {
+			c.prewarm(ctx)
+		}
+		err = c.downloadChunksConcurrently(ctx, file, chunks)
+	}
+	c.finishHash(err)
+	if err == nil && c.chunkSums != nil {
+		os.Remove(c.verifiedPath())
+	}
+	return err
+}
+
+// startHash hashes the output file in a worker while the chunks left of it
+// are downloaded, without it the file is hashed once it is complete
+func (c *Client) startHash(fileSize int64, chunks []Chunk) {
+	if c.summary == nil {
+		return
+	}
+	hasher, err := newChunkHasher(c.config.OutputPath, fileSize, chunks)
+	if err != nil {
+		c.logger.Debug("Hashing the file after the download",
+			zap.Error(err),
+		)
+		return
 	}
+	c.hasher = hasher
+}
 
-	// Use concurrent download for remaining chunks
-	return c.downloadChunksConcurrently(ctx, file, chunks)
+// finishHash records the hash of the file downloaded without error, or
+// abandons hashing it
+func (c *Client) finishHash(err error) {
+	if c.hasher == nil {
+		return
+	}
+	defer func() { c.hasher = nil }()
+	if err != nil {
+		c.hasher.stop()
+		return
+	}
+	sum, err := c.hasher.sum()
+	if err != nil {
+		c.logger.Debug("Hashing the file after the download",
+			zap.Error(err),
+		)
+		return
+	}
+	c.summary.SHA256 = sum
 }
 
 // downloadChunksSequentially downloads chunks sequentially
 func (c *Client) downloadChunksSequentially(ctx context.Context, file *os.File, chunks []Chunk) error {
-	for _, chunk := range chunks {
-		if err := c.downloadChunk(ctx, file, chunk); err != nil {
-			// Record failed chunk
-			if saveErr := c.saveFailedChunks([]Chunk{chunk}); saveErr != nil {
+	groups := c.groupChunks(chunks)
+	for i, group := range groups {
+		if failedChunks, err := c.downloadGroup(ctx, file, group); err != nil {
+			// Record failed chunks and those not downloaded yet, which may be
+			// below the end of the file
+			failed := append(c.flushBatch(), failedChunks...)
+			for _, rest := range groups[i+1:] {
+				failed = append(failed, rest...)
+			}
+			if saveErr := c.recordFailedChunks(file, failed); saveErr != nil {
 				// Log the save error but still return the original download error
-				c.logger.Info("",
-					zap.String("msg", "failed to save failed chunks"),
+				c.logger.Info("failed to save failed chunks",
 					zap.Error(saveErr),
 				)
 			}
 			return err
 		}
 	}
-	// Delete failed chunks record after successful completion
-	if _, err := os.Stat(c.config.FailedChunksJason); err == nil {
-		if err := os.Remove(c.config.FailedChunksJason); err != nil {
-			return fmt.Errorf("failed to delete failed chunks record file: %w", err)
+	if failed := c.flushBatch(); len(failed) > 0 {
+		if err := c.recordFailedChunks(file, failed); err != nil {
+			return fmt.Errorf("failed to save failed chunks record: %w", err)
 		}
+		return fmt.Errorf("failed to write %d batched chunks", len(failed))
 	}
-	return nil
+
+	// Delete failed chunks record after successful completion
+	return c.removeFailedChunks(file)
+}
+
+// flushBatch writes the chunks batched so far, returning those whose write
+// failed
+func (c *Client) flushBatch() []Chunk {
+	if c.batch == nil {
+		return nil
+	}
+	return c.batch.flush()
+}
+
+// batchWritten hands the ranges written by the batch to the hasher and the
+// journal
+func (c *Client) batchWritten(start, end int64) {
+	c.chunkWritten(Chunk{Start: start, End: end})
 }
@@ -1,13 +1,20 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -450,3 +457,153 @@ func TestDownloadWithResumeCreateDirectory(t *testing.T) {
 		t.Errorf("Downloaded content mismatch. Expected %q, got %q", testContent, string(content))
 	}
 }
+
+func TestDownloadIfRange(t *testing.T) {
+	content := bytes.Repeat([]byte("version 2 "), 800)
+	tests := []struct {
+		name         string
+		partial      []byte
+		etag         string
+		wantRequests int32
+	}{
+		{"same version", content[:4000], `"v2"`, 4},
+		{"remote changed", bytes.Repeat([]byte("version 1 "), 400), `"v1"`, 9},
+		{"no record", content[:4000], "", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") != "" {
+					requests.Add(1)
+					if r.Header.Get("If-Range") == "" {
+						t.Errorf("Range %s requested without If-Range", r.Header.Get("Range"))
+					}
+				}
+				w.Header().Set("ETag", `"v2"`)
+				http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+			}))
+			defer ts.Close()
+
+			output := filepath.Join(t.TempDir(), "data.bin")
+			if err := os.WriteFile(output, tt.partial, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.etag != "" {
+				if err := os.WriteFile(output+".ezft.etag", []byte(tt.etag), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client := NewClient(&DownloadConfig{
+				URL:            ts.URL + "/data.bin",
+				OutputPath:     output,
+				ChunkSize:      1000,
+				MaxConcurrency: 1,
+				EnableResume:   true,
+			})
+			client.SetLogger(zap.NewNop())
+			client.SetOutput(io.Discard)
+			if err := client.Download(context.Background()); err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+				t.Error("Downloaded file differs")
+			}
+			if n := requests.Load(); n != tt.wantRequests {
+				t.Errorf("%d range requests, want %d", n, tt.wantRequests)
+			}
+			if _, err := os.Stat(output + ".ezft.etag"); !os.IsNotExist(err) {
+				t.Error("ETag record kept after the download completed")
+			}
+		})
+	}
+}
+
+func TestDownloadWithResumeInterrupted(t *testing.T) {
+	const chunkSize, sent = 512 * 1024, 100 * 1024
+	content := make([]byte, 4*chunkSize)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	for _, concurrency := range []int{1, 2} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			// Chunks stall after their first bytes until interrupted
+			flushed := make(chan struct{}, 4)
+			stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				start, end, err := parseContentRange("bytes " + strings.TrimPrefix(r.Header.Get("Range"), "bytes="))
+				if err != nil {
+					t.Errorf("Range %q: %v", r.Header.Get("Range"), err)
+					return
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+				w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content[start : start+sent])
+				w.(http.Flusher).Flush()
+				flushed <- struct{}{}
+				<-r.Context().Done()
+			}))
+			defer stalled.Close()
+
+			output := filepath.Join(t.TempDir(), "data.bin")
+			config := &DownloadConfig{
+				URL:               stalled.URL + "/data.bin",
+				OutputPath:        output,
+				FailedChunksJason: output + ".failed_chunks.json",
+				ChunkSize:         chunkSize,
+				MaxConcurrency:    concurrency,
+				EnableResume:      true,
+			}
+			client := NewClient(config)
+			client.SetLogger(zap.NewNop())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				for range concurrency {
+					<-flushed
+				}
+				// Received by the client
+				time.Sleep(100 * time.Millisecond)
+				cancel()
+			}()
+			if err := client.downloadWithResume(ctx, int64(len(content))); !errors.Is(err, context.Canceled) {
+				t.Fatalf("downloadWithResume() error = %v, want context.Canceled", err)
+			}
+
+			// Every chunk is recorded, those in flight from their first byte not written
+			failed, err := client.loadFailedChunks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.SortFunc(failed, func(a, b Chunk) int { return int(a.Start - b.Start) })
+			var want []Chunk
+			for i := range int64(4) {
+				start := i * chunkSize
+				if i < int64(concurrency) {
+					start += sent
+				}
+				want = append(want, Chunk{Index: i, Start: start, End: (i+1)*chunkSize - 1})
+			}
+			if !slices.Equal(failed, want) {
+				t.Errorf("failed chunks = %v, want %v", failed, want)
+			}
+
+			// Resumed with the rest of the chunks only
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+			}))
+			defer ts.Close()
+			config.URL = ts.URL + "/data.bin"
+			client = NewClient(config)
+			client.SetLogger(zap.NewNop())
+			if err := client.downloadWithResume(context.Background(), int64(len(content))); err != nil {
+				t.Fatalf("downloadWithResume() error = %v", err)
+			}
+			if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+				t.Error("Resumed file differs")
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+// SummaryExt extension of the summary file written next to a completed
+// download
+const SummaryExt = ".ezft.json"
+
+// Summary the provenance of a download: where the file comes from, how it was
+// transferred and its checksum, written next to the output file
+type Summary struct {
+	URL          string    `json:"url"`
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`          // ETag of the remote file, if the server sent one
+	LastModified string    `json:"last_modified,omitempty"` // Last-Modified of the remote file, if the server sent one
+	SHA256       string    `json:"sha256,omitempty"`        // Hash of the output file once completed
+	Strategy     string    `json:"strategy"`                // One of the strategies of Plan
+	Transport    string    `json:"transport,omitempty"`     // quic, http or http/2 for chunked downloads
+	ChunkSize    int64     `json:"chunk_size,omitempty"`
+	Chunks       int       `json:"chunks,omitempty"` // Chunks of the whole file
+	Concurrency  int       `json:"concurrency"`
+	Started      time.Time `json:"started"`
+	Finished     time.Time `json:"finished"`
+	Duration     float64   `json:"duration"` // Seconds
+	Retries      int64     `json:"retries"`  // Chunk or whole file transfers retried
+	Version      string    `json:"ezft_version,omitempty"`
+}
+
+// Summary returns the summary of the last download of the client, nil if it
+// did not download anything yet
+func (c *Client) Summary() *Summary {
+	if c.summary == nil {
+		return nil
+	}
+	s := *c.summary
+	return &s
+}
+
+// finishSummary records the end of the download in its summary
+func (c *Client) finishSummary() {
+	c.summary.Finished = time.Now()
+	c.summary.Duration = c.summary.Finished.Sub(c.summary.Started).Seconds()
+	c.summary.Size = c.config.FileSize
+	c.summary.Retries = c.Retries()
+}
+
+// setChunkLayout records the chunks the file was split into for a chunked
+// download
+func (c *Client) setChunkLayout(fileSize int64) {
+	c.summary.Strategy = StrategyChunked
+	c.summary.Concurrency = max(c.config.MaxConcurrency, 1)
+	c.summary.Transport = "http"
+	if c.config.Multiplex {
+		c.summary.Transport = "http/2"
+	}
+	if c.quic != nil {
+		c.summary.Transport = "quic"
+	}
+	if chunkSize := c.config.ChunkSize; chunkSize > 0 {
+		c.summary.ChunkSize = chunkSize
+		c.summary.Chunks = int((fileSize + chunkSize - 1) / chunkSize)
+	}
+}
+
+// Checksum returns the SHA-256 of the output file of the last download,
+// computed while it was downloaded, empty if it was not
+func (c *Client) Checksum() string {
+	if c.summary == nil {
+		return ""
+	}
+	return c.summary.SHA256
+}
+
+// completedSummary returns the summary of the completed download, hashing
+// the file unless it was hashed while downloaded
+func (c *Client) completedSummary() (*Summary, error) {
+	if c.summary == nil {
+		return nil, errors.New("nothing downloaded")
+	}
+	if c.summary.SHA256 == "" {
+		sum, err := utils.CalculateFileHash(c.summary.Path, "sha256")
+		if err != nil {
+			return nil, err
+		}
+		c.summary.SHA256 = sum
+	}
+	return c.Summary(), nil
+}
+
+// WriteSummary writes the summary of the completed download next to its
+// output file, hashing the file unless it was hashed while downloaded,
+// version being that of ezft
+func (c *Client) WriteSummary(version string) error {
+	s, err := c.completedSummary()
+	if err != nil {
+		return err
+	}
+	s.Version = version
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.Path+SummaryExt, append(data, '\n'), 0644)
+}
+
+// ReadSummary reads the summary file at path
+func ReadSummary(path string) (*Summary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Summary
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestWriteSummary(t *testing.T) {
+	content := strings.Repeat("summary ", 1000)
+	modTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "data.bin", modTime, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	output := filepath.Join(t.TempDir(), "data.bin")
+	c := NewClient(&DownloadConfig{
+		URL:            ts.URL + "/data.bin",
+		OutputPath:     output,
+		ChunkSize:      1024,
+		MaxConcurrency: 2,
+		EnableResume:   true,
+	})
+	c.SetLogger(zap.NewNop())
+	c.SetOutput(io.Discard)
+	if c.Summary() != nil {
+		t.Error("Summary() before any download")
+	}
+	if err := c.WriteSummary("1.2.3"); err == nil {
+		t.Error("WriteSummary() succeeded before any download")
+	}
+	if err := c.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	if got := c.Checksum(); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("Checksum() = %q, want the hash computed while downloading", got)
+	}
+	if err := c.WriteSummary("1.2.3"); err != nil {
+		t.Fatalf("WriteSummary() error = %v", err)
+	}
+
+	s, err := ReadSummary(output + SummaryExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.URL != ts.URL+"/data.bin" || s.Path != output || s.Size != int64(len(content)) || s.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Summary() = %+v", s)
+	}
+	if s.ETag != `"v1"` || s.LastModified != modTime.Format(http.TimeFormat) || s.Version != "1.2.3" {
+		t.Errorf("Summary() = %+v, want the ETag, Last-Modified and version", s)
+	}
+	if s.Strategy != StrategyChunked || s.Transport != "http" || s.ChunkSize != 1024 || s.Chunks != 8 || s.Concurrency != 2 {
+		t.Errorf("Summary() = %+v, want the chunk layout", s)
+	}
+	if s.Started.IsZero() || s.Finished.Before(s.Started) {
+		t.Errorf("Summary() = %+v, want the timings", s)
+	}
+
+	// Complete already
+	if err := c.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if s := c.Summary(); s.Strategy != StrategyComplete || s.Chunks != 0 {
+		t.Errorf("Summary() = %+v of a complete file", s)
+	}
+}
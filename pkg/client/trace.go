@@ -0,0 +1,226 @@
+package client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http/httptrace"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// InfoRequest index of the trace of the request of the file information,
+// which usually opens the connection the first chunk reuses
+const InfoRequest = -1
+
+// ChunkTrace the timings of the HTTP request of a chunk
+type ChunkTrace struct {
+	Index    int64         // Index of the chunk, InfoRequest for the file information
+	Reused   bool          // Sent over the connection of an earlier request, without DNS, connect or TLS
+	DNS      time.Duration // Resolving the host
+	Connect  time.Duration // Establishing the TCP connection, about a round trip
+	TLS      time.Duration // TLS handshake
+	Wait     time.Duration // From the request written to the first response byte: the server time plus a round trip
+	TTFB     time.Duration // From the request started to the first response byte
+	Transfer time.Duration // From the first response byte to the end of the chunk
+}
+
+// PhaseStats the distribution of the duration of a phase among the chunk
+// requests going through it
+type PhaseStats struct {
+	Count int
+	Min   time.Duration
+	Avg   time.Duration
+	P95   time.Duration
+	Max   time.Duration
+}
+
+// MarshalJSON encodes the durations in seconds
+func (p PhaseStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count int     `json:"count"`
+		Min   float64 `json:"min"`
+		Avg   float64 `json:"avg"`
+		P95   float64 `json:"p95"`
+		Max   float64 `json:"max"`
+	}{p.Count, p.Min.Seconds(), p.Avg.Seconds(), p.P95.Seconds(), p.Max.Seconds()})
+}
+
+// TraceStats summary of the requests traced during a download
+type TraceStats struct {
+	Requests int        `json:"requests"`
+	Reused   int        `json:"reused"` // Requests over a reused connection
+	DNS      PhaseStats `json:"dns"`
+	Connect  PhaseStats `json:"connect"`
+	TLS      PhaseStats `json:"tls"`
+	Wait     PhaseStats `json:"wait"`
+	TTFB     PhaseStats `json:"ttfb"`
+	Transfer PhaseStats `json:"transfer"`
+}
+
+// Traces returns the timings of the chunk requests of the last download,
+// traced with DownloadConfig.Trace
+func (c *Client) Traces() []ChunkTrace {
+	c.tracesMu.Lock()
+	defer c.tracesMu.Unlock()
+	return slices.Clone(c.traces)
+}
+
+// TraceStats summarizes the timings of the chunk requests of the last
+// download, nil if none was traced
+func (c *Client) TraceStats() *TraceStats {
+	return SummarizeTraces(c.Traces())
+}
+
+// SummarizeTraces summarizes the timings of chunk requests, nil if there are
+// none
+func SummarizeTraces(traces []ChunkTrace) *TraceStats {
+	if len(traces) == 0 {
+		return nil
+	}
+	stats := &TraceStats{Requests: len(traces)}
+	var dns, connect, handshake, wait, ttfb, transfer []time.Duration
+	for _, t := range traces {
+		if t.Reused {
+			stats.Reused++
+		} else {
+			// Connection phases of new connections only, skipped when not needed
+			// such as DNS for an IP address
+			if t.DNS > 0 {
+				dns = append(dns, t.DNS)
+			}
+			if t.Connect > 0 {
+				connect = append(connect, t.Connect)
+			}
+			if t.TLS > 0 {
+				handshake = append(handshake, t.TLS)
+			}
+		}
+		wait = append(wait, t.Wait)
+		ttfb = append(ttfb, t.TTFB)
+		transfer = append(transfer, t.Transfer)
+	}
+	stats.DNS = phaseStats(dns)
+	stats.Connect = phaseStats(connect)
+	stats.TLS = phaseStats(handshake)
+	stats.Wait = phaseStats(wait)
+	stats.TTFB = phaseStats(ttfb)
+	stats.Transfer = phaseStats(transfer)
+	return stats
+}
+
+// phaseStats returns the distribution of durations
+func phaseStats(durations []time.Duration) PhaseStats {
+	if len(durations) == 0 {
+		return PhaseStats{}
+	}
+	slices.Sort(durations)
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	return PhaseStats{
+		Count: len(durations),
+		Min:   durations[0],
+		Avg:   total / time.Duration(len(durations)),
+		P95:   durations[(len(durations)*95+99)/100-1],
+		Max:   durations[len(durations)-1],
+	}
+}
+
+// addTrace records the timings of a chunk request
+func (c *Client) addTrace(t ChunkTrace) {
+	c.tracesMu.Lock()
+	c.traces = append(c.traces, t)
+	c.tracesMu.Unlock()
+	c.logger.Debug("Chunk request traced",
+		zap.Int64("chunk", t.Index),
+		zap.Bool("reused", t.Reused),
+		zap.Duration("dns", t.DNS),
+		zap.Duration("connect", t.Connect),
+		zap.Duration("tls", t.TLS),
+		zap.Duration("wait", t.Wait),
+		zap.Duration("ttfb", t.TTFB),
+		zap.Duration("transfer", t.Transfer),
+	)
+}
+
+// chunkTracer times the phases of a chunk request through httptrace, whose
+// hooks may run on other goroutines
+type chunkTracer struct {
+	mu           sync.Mutex
+	trace        ChunkTrace
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+}
+
+func newChunkTracer(index int64) *chunkTracer {
+	return &chunkTracer{trace: ChunkTrace{Index: index}, start: time.Now()}
+}
+
+// record runs f with the tracer locked
+func (t *chunkTracer) record(f func(now time.Time)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f(time.Now())
+}
+
+func (t *chunkTracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.record(func(time.Time) { t.trace.Reused = info.Reused })
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.record(func(now time.Time) { t.dnsStart = now })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.record(func(now time.Time) { t.trace.DNS = now.Sub(t.dnsStart) })
+		},
+		// Dialing several addresses in parallel, the connection is established
+		// once one of them is
+		ConnectStart: func(string, string) {
+			t.record(func(now time.Time) {
+				if t.connectStart.IsZero() {
+					t.connectStart = now
+				}
+			})
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.record(func(now time.Time) {
+				if err == nil && t.trace.Connect == 0 {
+					t.trace.Connect = now.Sub(t.connectStart)
+				}
+			})
+		},
+		TLSHandshakeStart: func() {
+			t.record(func(now time.Time) { t.tlsStart = now })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.record(func(now time.Time) { t.trace.TLS = now.Sub(t.tlsStart) })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.record(func(now time.Time) { t.wroteRequest = now })
+		},
+		GotFirstResponseByte: func() {
+			t.record(func(now time.Time) {
+				t.firstByte = now
+				t.trace.Wait = now.Sub(t.wroteRequest)
+				t.trace.TTFB = now.Sub(t.start)
+			})
+		},
+	}
+}
+
+// finish returns the timings of the request once its body was read
+func (t *chunkTracer) finish() ChunkTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trace.Transfer = time.Since(t.firstByte)
+	return t.trace
+}
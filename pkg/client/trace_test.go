@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestTrace(t *testing.T) {
+	content := strings.Repeat("trace ", 1000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			time.Sleep(10 * time.Millisecond)
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	c := NewClient(&DownloadConfig{
+		URL:            ts.URL + "/data.bin",
+		OutputPath:     filepath.Join(t.TempDir(), "data.bin"),
+		ChunkSize:      1024,
+		MaxConcurrency: 2,
+		EnableResume:   true,
+		Trace:          true,
+	})
+	c.SetLogger(zap.NewNop())
+	c.SetOutput(io.Discard)
+	if err := c.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	traces := c.Traces()
+	if len(traces) != 7 || traces[0].Index != InfoRequest || traces[0].Reused || traces[0].Connect == 0 {
+		t.Fatalf("Traces() = %+v, want the file information and 6 chunks", traces)
+	}
+	for _, trace := range traces[1:] {
+		if trace.Wait < 10*time.Millisecond || trace.TTFB < trace.Wait {
+			t.Errorf("Trace of chunk %d = %+v, want the server time", trace.Index, trace)
+		}
+	}
+	stats := c.TraceStats()
+	if stats.Requests != 7 || stats.Reused+stats.Connect.Count != 7 || stats.TLS.Count != 0 {
+		t.Errorf("TraceStats() = %+v", stats)
+	}
+	if stats.Wait.P95 < 10*time.Millisecond || stats.Wait.P95 > stats.Wait.Max || stats.Wait.Avg > stats.Wait.Max {
+		t.Errorf("TraceStats().Wait = %+v", stats.Wait)
+	}
+}
+
+func TestPhaseStats(t *testing.T) {
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	got := phaseStats(durations)
+	want := PhaseStats{Count: 100, Min: time.Millisecond, Avg: 50500 * time.Microsecond, P95: 95 * time.Millisecond, Max: 100 * time.Millisecond}
+	if got != want {
+		t.Errorf("phaseStats() = %+v, want %+v", got, want)
+	}
+	if got := phaseStats(nil); got != (PhaseStats{}) {
+		t.Errorf("phaseStats(nil) = %+v", got)
+	}
+}
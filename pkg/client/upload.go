@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"go.uber.org/zap"
+)
+
+// UploadConfig upload configuration
+type UploadConfig struct {
+	URL        string // Destination URL on an ezft server accepting uploads
+	InputPath  string // Local file to upload
+	NoProxyEnv bool   // Whether to ignore the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, connecting directly
+	Username   string // Basic auth username
+	Password   string // Basic auth password
+}
+
+// Uploader upload client
+type Uploader struct {
+	config      *UploadConfig
+	httpClient  *http.Client
+	credentials CredentialsFunc // Asked for credentials the server requires, nil to fail
+	logger      *zap.Logger
+}
+
+// NewUploader creates a new upload client
+func NewUploader(config *UploadConfig) *Uploader {
+	return &Uploader{
+		config: config,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy: ProxyFunc(config.NoProxyEnv),
+				DialContext: (&net.Dialer{
+					Timeout:   5 * time.Second, // Connection establishment timeout
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+			},
+		},
+		logger: zap.NewNop(),
+	}
+}
+
+func (u *Uploader) SetLogger(logger *zap.Logger) {
+	u.logger = logger
+}
+
+// SetCredentialsFunc sets the function asked for credentials when the server
+// refuses the upload without any
+func (u *Uploader) SetCredentialsFunc(f CredentialsFunc) {
+	u.credentials = f
+}
+
+// SetHTTPClient replaces the http client, allowing connections to be shared between clients
+func (u *Uploader) SetHTTPClient(httpClient *http.Client) {
+	u.httpClient = httpClient
+}
+
+// SetRateLimiter limits the bandwidth of the upload by limiter
+func (u *Uploader) SetRateLimiter(limiter *ratelimit.Limiter) {
+	limited := *u.httpClient
+	limited.Transport = ratelimit.NewTransport(u.httpClient.Transport, limiter)
+	u.httpClient = &limited
+}
+
+// Upload sends the input file to the destination URL, preserving its
+// modification time and mode, and returns the number of bytes sent
+func (u *Uploader) Upload(ctx context.Context) (int64, error) {
+	info, err := os.Stat(u.config.InputPath)
+	if err != nil {
+		return 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return 0, fmt.Errorf("not a regular file: %s", u.config.InputPath)
+	}
+
+	resp, err := u.put(ctx, info)
+	if err != nil {
+		return 0, err
+	}
+	// Sent again from the start with the credentials asked
+	retry, err := askCredentials(u.credentials, resp, &u.config.Username, &u.config.Password)
+	if retry || err != nil {
+		resp.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+		if resp, err = u.put(ctx, info); err != nil {
+			return 0, err
+		}
+	}
+	defer resp.Body.Close()
+
+	if err := checkAuth(resp); err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return 0, fmt.Errorf("server returned error status: %d", resp.StatusCode)
+	}
+
+	u.logger.Info("file uploaded",
+		zap.String("url", u.config.URL),
+		zap.Int64("size", info.Size()),
+	)
+	return info.Size(), nil
+}
+
+// put sends the input file to the destination URL, the file is closed once
+// sent
+func (u *Uploader) put(ctx context.Context, info os.FileInfo) (*http.Response, error) {
+	file, err := os.Open(u.config.InputPath)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.config.URL, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; ezft/1.0)")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Ezft-Mtime", info.ModTime().UTC().Format(time.RFC3339Nano))
+	if runtime.GOOS != "windows" {
+		req.Header.Set("X-Ezft-Mode", strconv.FormatUint(uint64(info.Mode().Perm()), 8))
+	}
+	if u.config.Username != "" || u.config.Password != "" {
+		req.SetBasicAuth(u.config.Username, u.config.Password)
+	}
+	return u.httpClient.Do(req)
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpload(t *testing.T) {
+	var gotBody, gotMtime, gotUser string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		gotMtime = r.Header.Get("X-Ezft-Mtime")
+		gotUser, _, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	input := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(input, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := os.Chtimes(input, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	u := NewUploader(&UploadConfig{URL: server.URL + "/data.txt", InputPath: input, Username: "admin", Password: "secret"})
+	n, err := u.Upload(context.Background())
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if n != 5 || gotBody != "hello" {
+		t.Errorf("Upload() sent %d bytes %q, want 5 bytes hello", n, gotBody)
+	}
+	if gotMtime != mtime.Format(time.RFC3339Nano) {
+		t.Errorf("Expected mtime header %s, got %s", mtime.Format(time.RFC3339Nano), gotMtime)
+	}
+	if gotUser != "admin" {
+		t.Errorf("Expected basic auth user admin, got %q", gotUser)
+	}
+}
+
+func TestUploadError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer server.Close()
+
+	input := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(input, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewUploader(&UploadConfig{URL: server.URL + "/data.txt", InputPath: input}).Upload(context.Background()); err == nil {
+		t.Error("Expected error when the server rejects the upload")
+	}
+	if _, err := NewUploader(&UploadConfig{URL: server.URL + "/x", InputPath: filepath.Dir(input)}).Upload(context.Background()); err == nil {
+		t.Error("Expected error when uploading a directory")
+	}
+}
+
+func TestUploadCredentialsFunc(t *testing.T) {
+	var gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	input := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(input, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	u := NewUploader(&UploadConfig{URL: server.URL + "/data.txt", InputPath: input})
+	u.SetCredentialsFunc(func(string) (string, string, error) { return "admin", "secret", nil })
+	if n, err := u.Upload(context.Background()); err != nil || n != 5 || gotBody != "hello" {
+		t.Errorf("Upload() = %d, %v, sent %q", n, err, gotBody)
+	}
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+// verifiedRecord the chunks of a partial file verified against the chunk
+// manifest, saved with the failed chunks so that resuming only hashes them
+// again when the file changed since
+type verifiedRecord struct {
+	SHA256    string    `json:"sha256"` // Hash of the whole file of the manifest
+	ChunkSize int64     `json:"chunk_size"`
+	Size      int64     `json:"size"`   // Size of the partial file when saved
+	ModTime   time.Time `json:"mtime"`  // Modification time of the partial file when saved
+	Bitmap    []byte    `json:"bitmap"` // Bit i set for verified chunk i
+}
+
+// chunkBitmap marks chunks, safe for concurrent use
+type chunkBitmap struct {
+	words []atomic.Uint64
+}
+
+func newChunkBitmap(n int) *chunkBitmap {
+	return &chunkBitmap{words: make([]atomic.Uint64, (n+63)/64)}
+}
+
+func (b *chunkBitmap) set(i int) {
+	b.words[i/64].Or(1 << (i % 64))
+}
+
+func (b *chunkBitmap) has(i int) bool {
+	return b.words[i/64].Load()&(1<<(i%64)) != 0
+}
+
+// bytes returns the bitmap with chunk i at bit i%8 of byte i/8
+func (b *chunkBitmap) bytes() []byte {
+	data := make([]byte, len(b.words)*8)
+	for i := range b.words {
+		w := b.words[i].Load()
+		for j := range 8 {
+			data[i*8+j] = byte(w >> (8 * j))
+		}
+	}
+	return data
+}
+
+// verifiedPath returns the file keeping the record of the verified chunks
+func (c *Client) verifiedPath() string {
+	return c.config.OutputPath + ".ezft.verified"
+}
+
+// loadVerified reads the record of the chunks verified by an interrupted
+// download, which must be of the same chunk manifest
+func (c *Client) loadVerified() (*verifiedRecord, error) {
+	data, err := os.ReadFile(c.verifiedPath())
+	if err != nil {
+		return nil, err
+	}
+	var rec verifiedRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	if rec.SHA256 != c.chunkSums.SHA256 || rec.ChunkSize != c.chunkSums.ChunkSize || len(rec.Bitmap)*8 < len(c.chunkSums.Hashes) {
+		return nil, errors.New("record of another chunk manifest")
+	}
+	return &rec, nil
+}
+
+// saveVerified records the chunks verified so far with the size and
+// modification time of the partial file
+func (c *Client) saveVerified() error {
+	if c.verified == nil {
+		return nil
+	}
+	info, err := os.Stat(c.config.OutputPath)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(verifiedRecord{
+		SHA256:    c.chunkSums.SHA256,
+		ChunkSize: c.chunkSums.ChunkSize,
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		Bitmap:    c.verified.bytes(),
+	})
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(c.verifiedPath(), data, 0644)
+}
+
+// resumeChunks returns the chunks of the output file left to download with a
+// chunk manifest, marking the others verified. With the record of an
+// interrupted download only the chunks it verified are kept, and they are
+// hashed again only if the file changed since it was saved. Without a
+// record every chunk is hashed.
+func (c *Client) resumeChunks(file *os.File) ([]Chunk, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	rec, recErr := c.loadVerified()
+	os.Remove(c.verifiedPath())
+	if err := file.Truncate(c.chunkSums.Size); err != nil {
+		return nil, err
+	}
+
+	c.verified = newChunkBitmap(len(c.chunkSums.Hashes))
+	if recErr != nil {
+		chunks := c.invalidChunks(file)
+		missing := make(map[int64]bool, len(chunks))
+		for _, chunk := range chunks {
+			missing[chunk.Index] = true
+		}
+		for i := range c.chunkSums.Hashes {
+			if !missing[int64(i)] {
+				c.verified.set(i)
+			}
+		}
+		return chunks, nil
+	}
+
+	changed := info.Size() != rec.Size || !info.ModTime().Equal(rec.ModTime)
+	var chunks []Chunk
+	for i := range c.chunkSums.Hashes {
+		start, end := c.chunkSums.Range(i)
+		verified := rec.Bitmap[i/8]&(1<<(i%8)) != 0 && end < info.Size()
+		if verified && changed {
+			verified = c.chunkSums.VerifyAt(file, i) == nil
+		}
+		if !verified {
+			chunks = append(chunks, Chunk{Index: int64(i), Start: start, End: end})
+			continue
+		}
+		c.verified.set(i)
+	}
+	return chunks, nil
+}
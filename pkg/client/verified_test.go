@@ -0,0 +1,98 @@
+package client
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"go.uber.org/zap"
+)
+
+func TestResumeChunks(t *testing.T) {
+	content := make([]byte, 4*64*1024)
+	rand.New(rand.NewSource(4)).Read(content)
+	sums, err := manifest.HashChunks(bytes.NewReader(content), int64(len(content)), 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputPath := filepath.Join(t.TempDir(), "data.bin")
+
+	// resume writes the partial file with chunk 1 corrupt and chunk 3
+	// missing, after an interrupted download verified chunks 0 and 1
+	resume := func(changed bool) []Chunk {
+		t.Helper()
+		partial := bytes.Clone(content[:3*64*1024])
+		partial[64*1024] ^= 0xff
+		if err := os.WriteFile(outputPath, partial, 0644); err != nil {
+			t.Fatal(err)
+		}
+		c := NewClient(&DownloadConfig{OutputPath: outputPath})
+		c.SetLogger(zap.NewNop())
+		c.chunkSums = sums
+		c.verified = newChunkBitmap(len(sums.Hashes))
+		c.verified.set(0)
+		c.verified.set(1)
+		if err := c.saveVerified(); err != nil {
+			t.Fatalf("saveVerified() error = %v", err)
+		}
+		if changed {
+			later := time.Now().Add(time.Minute)
+			os.Chtimes(outputPath, later, later)
+		}
+
+		file, err := os.OpenFile(outputPath, os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		chunks, err := c.resumeChunks(file)
+		if err != nil {
+			t.Fatalf("resumeChunks() error = %v", err)
+		}
+		if _, err := os.Stat(c.verifiedPath()); !os.IsNotExist(err) {
+			t.Error("Record of the verified chunks kept after reading it")
+		}
+		for _, chunk := range chunks {
+			if c.verified.has(int(chunk.Index)) {
+				t.Errorf("Chunk %d left to download is marked verified", chunk.Index)
+			}
+		}
+		return chunks
+	}
+	indexes := func(chunks []Chunk) []int64 {
+		var got []int64
+		for _, chunk := range chunks {
+			got = append(got, chunk.Index)
+		}
+		return got
+	}
+
+	// Unchanged since the record: its chunks are trusted without hashing,
+	// the chunk it did not verify is downloaded even though it is on disk
+	if got := indexes(resume(false)); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("Chunks left of an unchanged file = %v, want [2 3]", got)
+	}
+
+	// Changed since: the chunks of the record are hashed again
+	if got := indexes(resume(true)); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("Chunks left of a changed file = %v, want [1 2 3]", got)
+	}
+
+	// Without a record every chunk is hashed
+	os.WriteFile(outputPath, content[:3*64*1024], 0644)
+	c := NewClient(&DownloadConfig{OutputPath: outputPath})
+	c.chunkSums = sums
+	file, _ := os.OpenFile(outputPath, os.O_RDWR, 0644)
+	defer file.Close()
+	chunks, err := c.resumeChunks(file)
+	if err != nil {
+		t.Fatalf("resumeChunks() error = %v", err)
+	}
+	if got := indexes(chunks); len(got) != 1 || got[0] != 3 {
+		t.Errorf("Chunks left without a record = %v, want [3]", got)
+	}
+}
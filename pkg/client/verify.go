@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"go.uber.org/zap"
+)
+
+// loadChunkManifest loads the chunk manifest every chunk is verified against,
+// from the file of ChunkManifest or else from the server. Chunks are then
+// cut along the chunks of the manifest.
+func (c *Client) loadChunkManifest(ctx context.Context, fileSize int64) error {
+	var chunks *manifest.Chunks
+	var err error
+	if c.config.ChunkManifest != "" {
+		chunks, err = readChunkManifest(c.config.ChunkManifest)
+	} else {
+		chunks, err = c.fetchChunkManifest(ctx, fileSize)
+	}
+	if err != nil {
+		return err
+	}
+	if chunks.Size != fileSize {
+		return fmt.Errorf("chunk manifest describes %d bytes, the remote file has %d", chunks.Size, fileSize)
+	}
+
+	c.chunkSums = chunks
+	c.config.ChunkSize = chunks.ChunkSize
+	c.config.AutoChunk = false
+	c.logger.Info("Verifying chunks against their hashes",
+		zap.Int("chunks", len(chunks.Hashes)),
+		zap.Int64("chunkSize", chunks.ChunkSize),
+	)
+	return nil
+}
+
+func readChunkManifest(name string) (*manifest.Chunks, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return manifest.ReadChunks(f)
+}
+
+// fetchChunkManifest asks the server for the chunk manifest of the file, for
+// the chunk size the download would use
+func (c *Client) fetchChunkManifest(ctx context.Context, fileSize int64) (*manifest.Chunks, error) {
+	chunkSize := c.config.ChunkSize
+	if c.config.AutoChunk {
+		chunkSize = calculateChunkSize(fileSize)
+	}
+	chunkSize = min(max(chunkSize, manifest.MinChunkSize), manifest.MaxChunkSize)
+
+	manifestURL, err := c.apiURL("chunks")
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, "GET", manifestURL+"?chunk_size="+strconv.FormatInt(chunkSize, 10), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("chunk manifest request failed, status code: %d", resp.StatusCode)
+	}
+	return manifest.ReadChunks(resp.Body)
+}
+
+// verifyChunk checks a downloaded chunk against the chunk manifest
+func (c *Client) verifyChunk(file *os.File, chunk Chunk) error {
+	i := int(chunk.Start / c.chunkSums.ChunkSize)
+	if start, end := c.chunkSums.Range(i); start != chunk.Start || end != chunk.End {
+		return fmt.Errorf("chunk %d-%d is not a chunk of the manifest", chunk.Start, chunk.End)
+	}
+	return c.chunkSums.VerifyAt(file, i)
+}
+
+// invalidChunks returns the chunks of the output file not matching the chunk
+// manifest, which are all that is left to download
+func (c *Client) invalidChunks(file *os.File) []Chunk {
+	var chunks []Chunk
+	for i := range c.chunkSums.Hashes {
+		if c.chunkSums.VerifyAt(file, i) != nil {
+			start, end := c.chunkSums.Range(i)
+			chunks = append(chunks, Chunk{Index: int64(i), Start: start, End: end})
+		}
+	}
+	return chunks
+}
+
+// verifyFile checks the whole output file against the chunk manifest, if any
+func (c *Client) verifyFile() error {
+	if c.chunkSums == nil {
+		return nil
+	}
+	file, err := os.Open(c.config.OutputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if invalid := c.invalidChunks(file); len(invalid) > 0 {
+		return fmt.Errorf("%d chunks of the file: %w", len(invalid), manifest.ErrChunkMismatch)
+	}
+	return nil
+}
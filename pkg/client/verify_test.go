@@ -0,0 +1,85 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+)
+
+func TestVerifiedDownload(t *testing.T) {
+	serverDir := t.TempDir()
+	content := make([]byte, 8*64*1024+123)
+	rand.New(rand.NewSource(8)).Read(content)
+	os.WriteFile(filepath.Join(serverDir, "data.bin"), content, 0644)
+
+	srv := server.NewServer(serverDir, 0)
+	srv.SetLogger(zap.NewNop())
+	handler := srv.Handler()
+	var ranges atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	outputPath := filepath.Join(t.TempDir(), "data.bin")
+	download := func(config DownloadConfig) error {
+		config.URL = ts.URL + "/data.bin"
+		config.OutputPath = outputPath
+		config.ChunkSize = 64 * 1024
+		config.MaxConcurrency = 4
+		config.EnableResume = true
+		client := NewClient(&config)
+		client.SetLogger(zap.NewNop())
+		return client.Download(context.Background())
+	}
+
+	if err := download(DownloadConfig{VerifyChunks: true}); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(outputPath); !bytes.Equal(got, content) {
+		t.Fatal("Downloaded file differs")
+	}
+
+	// Resuming a complete file of the right size with a corrupt chunk only
+	// downloads that chunk again
+	f, _ := os.OpenFile(outputPath, os.O_WRONLY, 0644)
+	f.WriteAt([]byte("corrupt"), 3*64*1024+10)
+	f.Close()
+	ranges.Store(0)
+	if err := download(DownloadConfig{VerifyChunks: true}); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(outputPath); !bytes.Equal(got, content) {
+		t.Error("Corrupt chunk was not repaired")
+	}
+	if n := ranges.Load(); n != 1 {
+		t.Errorf("%d range requests, want 1", n)
+	}
+
+	// An offline manifest of other content rejects what the server sends
+	other := bytes.Clone(content)
+	other[100] ^= 1
+	chunks, _ := manifest.HashChunks(bytes.NewReader(other), int64(len(other)), 64*1024)
+	manifestPath := filepath.Join(t.TempDir(), "data.bin.chunks.json")
+	data, _ := json.Marshal(chunks)
+	os.WriteFile(manifestPath, data, 0644)
+	os.Remove(outputPath)
+	if err := download(DownloadConfig{ChunkManifest: manifestPath}); !errors.Is(err, manifest.ErrChunkMismatch) {
+		t.Errorf("Download() error = %v, want %v", err, manifest.ErrChunkMismatch)
+	}
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+// Writes of small chunks are batched into aligned blocks
+const (
+	batchChunkLimit = 256 * 1024  // Chunks up to this size are batched
+	batchBlockSize  = 1024 * 1024 // Size and alignment of the batched writes
+	batchMaxBlocks  = 32          // Blocks held in memory, beyond them the pending data is written as it is
+)
+
+// writeBatcher holds the data of small chunks in memory and writes it by
+// blocks aligned to batchBlockSize once all chunks of a block completed, so
+// that a block takes one write instead of one per chunk. Chunks count as
+// downloaded once batched, the data is on disk after flush.
+type writeBatcher struct {
+	file    *os.File
+	written func(start, end int64) // Called with the ranges written to the file
+
+	mu     sync.Mutex
+	needed map[int64]int64       // Bytes of the chunks of each block not written yet, by block index
+	blocks map[int64]*batchBlock // Blocks holding data, by block index
+	failed []Chunk               // Ranges of batched chunks whose write failed
+}
+
+// batchBlock the data of the chunks of one block received so far
+type batchBlock struct {
+	buf      *[]byte
+	segments [][2]int64 // Ranges of buf holding data, from the start of the block, end excluded
+	filled   int64
+}
+
+// newWriteBatcher batches the writes of chunks to file, written being called
+// with the ranges once they are written
+func newWriteBatcher(file *os.File, chunks []Chunk, written func(start, end int64)) *writeBatcher {
+	b := &writeBatcher{
+		file:    file,
+		written: written,
+		needed:  make(map[int64]int64),
+		blocks:  make(map[int64]*batchBlock),
+	}
+	for _, chunk := range chunks {
+		for off := chunk.Start; off <= chunk.End; {
+			next := min(chunk.End+1, (off/batchBlockSize+1)*batchBlockSize)
+			b.needed[off/batchBlockSize] += next - off
+			off = next
+		}
+	}
+	return b
+}
+
+// readChunk reads the data of chunk from body into memory and batches it,
+// adding the bytes read to progress and returning them
+func (b *writeBatcher) readChunk(body io.Reader, chunk Chunk, progress *atomic.Int64) (int64, error) {
+	buf := utils.GetBuffer(int(chunk.End - chunk.Start + 1))
+	defer utils.PutBuffer(buf)
+
+	n, err := io.ReadFull(body, *buf)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read response data: %w", err)
+	}
+	b.add(chunk, *buf)
+	progress.Add(int64(n))
+	return int64(n), nil
+}
+
+// add copies the data of a completed chunk into its blocks, writing those
+// completed by it
+func (b *writeBatcher) add(chunk Chunk, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for off := chunk.Start; off <= chunk.End; {
+		index := off / batchBlockSize
+		blockStart := index * batchBlockSize
+		next := min(chunk.End+1, blockStart+batchBlockSize)
+
+		block := b.blocks[index]
+		if block == nil {
+			block = &batchBlock{buf: utils.GetBuffer(batchBlockSize)}
+			b.blocks[index] = block
+		}
+		copy((*block.buf)[off-blockStart:], data[off-chunk.Start:next-chunk.Start])
+		block.segments = append(block.segments, [2]int64{off - blockStart, next - blockStart})
+		block.filled += next - off
+		if block.filled >= b.needed[index] {
+			b.writeBlock(index, block)
+		}
+		off = next
+	}
+
+	if len(b.blocks) > batchMaxBlocks {
+		for index, block := range b.blocks {
+			b.writeBlock(index, block)
+		}
+	}
+}
+
+// writeBlock writes the data held by a block, a run of adjacent chunks by one
+// write, and releases it. Failed writes are recorded in failed.
+func (b *writeBatcher) writeBlock(index int64, block *batchBlock) {
+	defer utils.PutBuffer(block.buf)
+	delete(b.blocks, index)
+	if b.needed[index] -= block.filled; b.needed[index] <= 0 {
+		delete(b.needed, index)
+	}
+
+	slices.SortFunc(block.segments, func(x, y [2]int64) int { return int(x[0] - y[0]) })
+	blockStart := index * batchBlockSize
+	for i := 0; i < len(block.segments); {
+		start, end := block.segments[i][0], block.segments[i][1]
+		for i++; i < len(block.segments) && block.segments[i][0] == end; i++ {
+			end = block.segments[i][1]
+		}
+		if _, err := b.file.WriteAt((*block.buf)[start:end], blockStart+start); err != nil {
+			b.failed = append(b.failed, Chunk{Index: index, Start: blockStart + start, End: blockStart + end - 1})
+			continue
+		}
+		if b.written != nil {
+			b.written(blockStart+start, blockStart+end-1)
+		}
+	}
+}
+
+// flush writes the data of all blocks and returns the ranges of batched
+// chunks whose write failed, to download again
+func (b *writeBatcher) flush() []Chunk {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for index, block := range b.blocks {
+		b.writeBlock(index, block)
+	}
+	failed := b.failed
+	b.failed = nil
+	return failed
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWriteBatcher(t *testing.T) {
+	data := make([]byte, 3*batchBlockSize+12345)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	// Resumed after the first bytes, with chunks not dividing the blocks
+	const resumed = 1000
+	chunks := splitChunks(resumed, int64(len(data)), 10*1024)
+	rand.Shuffle(len(chunks), func(i, j int) { chunks[i], chunks[j] = chunks[j], chunks[i] })
+
+	path := filepath.Join(t.TempDir(), "file.bin")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.Write(data[:resumed])
+
+	var writes int
+	var covered int64
+	b := newWriteBatcher(file, chunks, func(start, end int64) {
+		writes++
+		covered += end - start + 1
+	})
+	for _, chunk := range chunks {
+		b.add(chunk, data[chunk.Start:chunk.End+1])
+	}
+	if failed := b.flush(); len(failed) > 0 {
+		t.Fatalf("flush() failed chunks %v", failed)
+	}
+
+	if writes > len(chunks)/10 {
+		t.Errorf("%d chunks took %d writes", len(chunks), writes)
+	}
+	if covered != int64(len(data)-resumed) {
+		t.Errorf("Written ranges cover %d bytes, want %d", covered, len(data)-resumed)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("File content differs from the data of the chunks")
+	}
+}
+
+func TestWriteBatcherReadChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.bin")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	chunk := Chunk{Start: 0, End: 9}
+	b := newWriteBatcher(file, []Chunk{chunk}, nil)
+	var progress atomic.Int64
+	if _, err := b.readChunk(bytes.NewReader([]byte("short")), chunk, &progress); err == nil {
+		t.Error("readChunk() of a truncated body succeeded")
+	}
+	if n, err := b.readChunk(bytes.NewReader([]byte("0123456789")), chunk, &progress); err != nil || n != 10 {
+		t.Fatalf("readChunk() = %d, %v", n, err)
+	}
+	if progress.Load() != 10 {
+		t.Errorf("progress = %d, want 10", progress.Load())
+	}
+	// The only chunk of its block completed it
+	if got, _ := os.ReadFile(path); string(got) != "0123456789" {
+		t.Errorf("File content = %q", got)
+	}
+}
@@ -0,0 +1,47 @@
+package client
+
+import "github.com/easzlab/ezft/pkg/utils"
+
+// Extended attributes recording the provenance of a completed download on its
+// output file
+const (
+	XattrURL    = "user.ezft.url"
+	XattrETag   = "user.ezft.etag"   // Empty if the server sent none
+	XattrSHA256 = "user.ezft.sha256" // Hex encoded
+)
+
+// WriteXattrs records the source URL, ETag and SHA-256 of the completed
+// download in the extended attributes of its output file, hashing the file
+// unless it was hashed while downloaded. It returns
+// utils.ErrXattrUnsupported on file systems without them.
+func (c *Client) WriteXattrs() error {
+	s, err := c.completedSummary()
+	if err != nil {
+		return err
+	}
+	// The ETag is cleared rather than kept from an earlier download
+	for _, attr := range [][2]string{{XattrURL, s.URL}, {XattrETag, s.ETag}, {XattrSHA256, s.SHA256}} {
+		if err := utils.SetXattr(s.Path, attr[0], attr[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadXattrs returns the provenance WriteXattrs recorded on the file at path,
+// a summary with its URL, ETag and SHA-256. It returns utils.ErrXattrMissing
+// if the file has no recorded hash.
+func ReadXattrs(path string) (*Summary, error) {
+	s := &Summary{Path: path}
+	var err error
+	if s.SHA256, err = utils.GetXattr(path, XattrSHA256); err != nil {
+		return nil, err
+	}
+	if s.SHA256 == "" {
+		return nil, utils.ErrXattrMissing
+	}
+	// Both are optional
+	s.URL, _ = utils.GetXattr(path, XattrURL)
+	s.ETag, _ = utils.GetXattr(path, XattrETag)
+	return s, nil
+}
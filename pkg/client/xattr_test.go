@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+func TestWriteXattrs(t *testing.T) {
+	content := strings.Repeat("xattr ", 1000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "data.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	output := filepath.Join(t.TempDir(), "data.bin")
+	c := NewClient(&DownloadConfig{
+		URL:            ts.URL + "/data.bin",
+		OutputPath:     output,
+		ChunkSize:      1024,
+		MaxConcurrency: 2,
+	})
+	c.SetLogger(zap.NewNop())
+	c.SetOutput(io.Discard)
+	if err := c.WriteXattrs(); err == nil {
+		t.Error("WriteXattrs() succeeded before any download")
+	}
+	if err := c.Download(context.Background()); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	if _, err := ReadXattrs(output); !errors.Is(err, utils.ErrXattrMissing) {
+		if errors.Is(err, utils.ErrXattrUnsupported) {
+			t.Skip("extended attributes not supported")
+		}
+		t.Errorf("ReadXattrs() before WriteXattrs() error = %v, want ErrXattrMissing", err)
+	}
+	if err := c.WriteXattrs(); err != nil {
+		if errors.Is(err, utils.ErrXattrUnsupported) {
+			t.Skip("extended attributes not supported")
+		}
+		t.Fatalf("WriteXattrs() error = %v", err)
+	}
+
+	s, err := ReadXattrs(output)
+	if err != nil {
+		t.Fatalf("ReadXattrs() error = %v", err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	if s.URL != ts.URL+"/data.bin" || s.ETag != `"v1"` || s.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("ReadXattrs() = %+v", s)
+	}
+}
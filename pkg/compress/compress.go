@@ -0,0 +1,175 @@
+// Package compress compresses chunks exchanged between ezft clients and
+// servers. Both ends agree on it with a handshake: the server offers its
+// codecs together with a dictionary sampled from the file, or declines when the
+// file looks compressed already, and the client then asks for every chunk to
+// be compressed with that dictionary. Chunks compress well on their own that
+// way, even when they are small.
+//
+// The codec is zstd with the sample as a raw content dictionary. The handshake
+// names codecs, so others can be added without breaking older clients.
+package compress
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Header carries the codec and dictionary ID a client accepts on requests,
+// and the codec of compressed responses
+const Header = "X-Ezft-Compression"
+
+// Zstd the zstd codec with a raw content dictionary
+const Zstd = "zstd"
+
+const (
+	dictionarySize = 64 * 1024
+	sampleCount    = 16
+	windowSize     = 1 << 20    // Bounds the memory of both ends, chunks gain little from a larger window
+	minFileSize    = 256 * 1024 // Files smaller than this are sent as they are
+	maxRatio       = 0.9        // Samples compressing worse than this are taken as incompressible
+)
+
+// Offer the answer of a server to the compression handshake of a file
+type Offer struct {
+	Codecs       []string `json:"codecs"`
+	Compressible bool     `json:"compressible"`
+	Reason       string   `json:"reason,omitempty"` // Why the file is sent as it is
+	Dictionary   []byte   `json:"dictionary,omitempty"`
+	DictionaryID string   `json:"dictionary_id,omitempty"`
+}
+
+// compressedExts extensions of formats which are compressed already
+var compressedExts = map[string]bool{
+	".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".txz": true, ".zst": true,
+	".lz4": true, ".lzma": true, ".7z": true, ".rar": true, ".zip": true, ".jar": true,
+	".war": true, ".apk": true, ".whl": true, ".deb": true, ".rpm": true, ".squashfs": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true,
+	".heic": true, ".mp3": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true,
+	".pdf": true, ".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".epub": true,
+	".woff": true, ".woff2": true,
+}
+
+// Sample builds the dictionary of a file from blocks spread over it
+func Sample(r io.ReaderAt, size int64) ([]byte, error) {
+	if size <= dictionarySize {
+		dict := make([]byte, size)
+		_, err := r.ReadAt(dict, 0)
+		if err == io.EOF {
+			err = nil
+		}
+		return dict, err
+	}
+
+	block := int64(dictionarySize / sampleCount)
+	dict := make([]byte, 0, dictionarySize)
+	for i := range int64(sampleCount) {
+		buf := make([]byte, block)
+		n, err := r.ReadAt(buf, i*(size-block)/(sampleCount-1))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		dict = append(dict, buf[:n]...)
+	}
+	return dict, nil
+}
+
+// DictionaryID identifies a dictionary, so servers notice clients holding the
+// dictionary of an older version of the file
+func DictionaryID(dict []byte) string {
+	sum := sha256.Sum256(dict)
+	return hex.EncodeToString(sum[:8])
+}
+
+// frameDictionaryID the ID of dict written in zstd frames, so a chunk
+// compressed with another dictionary fails to decompress instead of being
+// decoded into garbage
+func frameDictionaryID(dict []byte) uint32 {
+	sum := sha256.Sum256(dict)
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// Skip returns why a file is better sent as it is, judged by its name, its
+// size and the dictionary sampled from it, or "" when it is worth compressing
+func Skip(name string, size int64, sample []byte) string {
+	if size < minFileSize {
+		return "small file"
+	}
+	if compressedExts[strings.ToLower(path.Ext(name))] {
+		return "compressed format"
+	}
+	if isCompressedType(http.DetectContentType(sample)) {
+		return "compressed content"
+	}
+
+	// Formats unknown by name or content are tried on the sample
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	defer enc.Close()
+	if float64(len(enc.EncodeAll(sample, nil))) > maxRatio*float64(len(sample)) {
+		return "incompressible content"
+	}
+	return ""
+}
+
+// isCompressedType reports whether a sniffed content type is a compressed format
+func isCompressedType(contentType string) bool {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	switch contentType {
+	case "image/bmp", "image/x-icon", "image/svg+xml", "audio/wave":
+		return false
+	case "application/x-gzip", "application/zip", "application/x-rar-compressed",
+		"application/pdf", "font/woff", "font/woff2":
+		return true
+	}
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept returns the value of Header a client sends to get chunks compressed
+// with the dictionary of id
+func Accept(id string) string {
+	return Zstd + "; dict=" + id
+}
+
+// ParseAccept returns the dictionary ID of a request accepting compressed
+// chunks, false when it does not accept a supported codec
+func ParseAccept(value string) (string, bool) {
+	codec, params, _ := strings.Cut(value, ";")
+	if strings.TrimSpace(codec) != Zstd {
+		return "", false
+	}
+	id, ok := strings.CutPrefix(strings.TrimSpace(params), "dict=")
+	return id, ok && id != ""
+}
+
+// NewWriter compresses a chunk to w with dict, Close flushes it
+func NewWriter(w io.Writer, dict []byte) io.WriteCloser {
+	zw, _ := zstd.NewWriter(w,
+		zstd.WithEncoderLevel(zstd.SpeedFastest),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(windowSize),
+		zstd.WithEncoderDictRaw(frameDictionaryID(dict), dict),
+	)
+	return zw
+}
+
+// NewReader decompresses a chunk compressed with dict
+func NewReader(r io.Reader, dict []byte) io.ReadCloser {
+	zr, _ := zstd.NewReader(r,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(windowSize),
+		zstd.WithDecoderDictRaw(frameDictionaryID(dict), dict),
+	)
+	return zr.IOReadCloser()
+}
@@ -0,0 +1,147 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	small := []byte("small file")
+	if dict, err := Sample(bytes.NewReader(small), int64(len(small))); err != nil || !bytes.Equal(dict, small) {
+		t.Errorf("Sample() of a small file = %q, %v", dict, err)
+	}
+
+	content := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	dict, err := Sample(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dict) != dictionarySize {
+		t.Fatalf("Dictionary has %d bytes, want %d", len(dict), dictionarySize)
+	}
+	block := dictionarySize / sampleCount
+	if !bytes.Equal(dict[:block], content[:block]) || !bytes.Equal(dict[len(dict)-block:], content[len(content)-block:]) {
+		t.Error("Dictionary should sample the start and the end of the file")
+	}
+}
+
+func TestSkip(t *testing.T) {
+	text := []byte(strings.Repeat("log line with some repeated words\n", 1000))
+	random := make([]byte, 32*1024)
+	rand.New(rand.NewSource(2)).Read(random)
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), text...)
+
+	tests := []struct {
+		name   string
+		size   int64
+		sample []byte
+		want   string
+	}{
+		{"app.log", 1 << 20, text, ""},
+		{"tiny.log", 1024, text, "small file"},
+		{"backup.TAR.GZ", 1 << 20, text, "compressed format"},
+		{"picture", 1 << 20, png, "compressed content"},
+		{"data.bin", 1 << 20, random, "incompressible content"},
+	}
+	for _, tt := range tests {
+		if got := Skip(tt.name, tt.size, tt.sample); got != tt.want {
+			t.Errorf("Skip(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseAccept(t *testing.T) {
+	if id, ok := ParseAccept(Accept("abc")); !ok || id != "abc" {
+		t.Errorf("ParseAccept() = %q, %v", id, ok)
+	}
+	for _, value := range []string{"", "deflate; dict=abc", "zstd", "zstd; dict="} {
+		if _, ok := ParseAccept(value); ok {
+			t.Errorf("ParseAccept(%q) should fail", value)
+		}
+	}
+}
+
+func TestDictionary(t *testing.T) {
+	words := strings.Fields("chunk block file server client request response offset length")
+	random := rand.New(rand.NewSource(3))
+	var text strings.Builder
+	for text.Len() < 256*1024 {
+		text.WriteString(words[random.Intn(len(words))] + " ")
+	}
+	content := []byte(text.String())
+	dict, err := Sample(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressed := func(chunk, dict []byte) []byte {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, dict)
+		w.Write(chunk)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	chunk := content[100000:104096]
+	withDict := compressed(chunk, dict)
+	if without := compressed(chunk, nil); len(withDict) >= len(without) {
+		t.Errorf("Chunk compressed to %d bytes with the dictionary, %d without", len(withDict), len(without))
+	}
+
+	r := NewReader(bytes.NewReader(withDict), dict)
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, chunk) {
+		t.Fatalf("Decompressed chunk differs: %v", err)
+	}
+
+	// A chunk compressed with the dictionary of an older version of the file
+	// fails instead of decoding into other content
+	r = NewReader(bytes.NewReader(withDict), dict[1:])
+	if _, err := io.ReadAll(r); err == nil {
+		t.Error("Decompressing with another dictionary should fail")
+	}
+	r.Close()
+}
+
+func TestTransport(t *testing.T) {
+	content := strings.Repeat("compressible chunk ", 10000)
+	dict := []byte(content[:1000])
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := ParseAccept(r.Header.Get(Header))
+		if !ok || id != DictionaryID(dict) {
+			io.WriteString(w, content)
+			return
+		}
+		w.Header().Set(Header, Zstd)
+		cw := NewWriter(w, dict)
+		io.WriteString(cw, content)
+		cw.Close()
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/file.txt")
+	client := &http.Client{Transport: NewTransport(nil, u, dict)}
+	for _, path := range []string{"/file.txt", "/other.txt"} {
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != content {
+			t.Errorf("Body of %s differs: %v", path, err)
+		}
+		if compressed := resp.Header.Get(Header) == Zstd; compressed != (path == "/file.txt") {
+			t.Errorf("%s compressed: %v", path, compressed)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package compress
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// MaxPatchSize the largest size of a base and a target together diffed with
+// zstd. The whole base is held in memory on both ends, the window covers both,
+// and the match finder of the encoder loses track of data in larger bases.
+const MaxPatchSize = 64 << 20
+
+// patchDictionaryID the dictionary ID of the base in patch frames, the one of
+// zstd --patch-from, so diffs can be applied with the zstd command as well
+const patchDictionaryID = 0
+
+// patchWindow returns the window letting the data of the whole base be
+// referenced until the end of a target of targetSize bytes
+func patchWindow(baseSize, targetSize int64) int {
+	window := zstd.MinWindowSize
+	for int64(window) < baseSize+targetSize && window < zstd.MaxWindowSize {
+		window <<= 1
+	}
+	return window
+}
+
+// NewPatchWriter writes to w the diff turning base into the targetSize bytes
+// written to it, a zstd frame using base as its dictionary like zstd
+// --patch-from. Close flushes it.
+func NewPatchWriter(w io.Writer, base []byte, targetSize int64) (io.WriteCloser, error) {
+	return zstd.NewWriter(w,
+		zstd.WithEncoderLevel(zstd.SpeedBestCompression),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(patchWindow(int64(len(base)), targetSize)),
+		zstd.WithEncoderDictRaw(patchDictionaryID, base),
+	)
+}
+
+// NewPatchReader reads the target rebuilt from base and the diff read from r
+func NewPatchReader(r io.Reader, base []byte) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(zstd.MaxWindowSize),
+		zstd.WithDecoderDictRaw(patchDictionaryID, base),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
@@ -0,0 +1,52 @@
+package compress
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Transport asks for the chunks of a file to be compressed and decompresses
+// them, other requests pass through untouched
+type Transport struct {
+	Base       http.RoundTripper // Underlying transport, http.DefaultTransport if nil
+	URL        *url.URL          // URL of the file
+	Dictionary []byte
+	id         string
+}
+
+// NewTransport wraps base so chunks of the file at u are compressed with dict
+func NewTransport(base http.RoundTripper, u *url.URL, dict []byte) *Transport {
+	return &Transport{Base: base, URL: u, Dictionary: dict, id: DictionaryID(dict)}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Method != http.MethodGet || req.URL.Host != t.URL.Host || req.URL.Path != t.URL.Path {
+		return base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set(Header, Accept(t.id))
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get(Header) != Zstd {
+		return resp, nil
+	}
+
+	resp.Body = &readCloser{Reader: NewReader(resp.Body, t.Dictionary), Closer: resp.Body}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
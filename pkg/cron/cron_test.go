@@ -0,0 +1,72 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@often",
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := Parse(expr); err == nil {
+				t.Errorf("Parse(%q) expected an error", expr)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, 1, 14, 10, 30, 20, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", at(1, 14, 10, 31)},
+		{"0 3 * * *", at(1, 15, 3, 0)},
+		{"@daily", at(1, 15, 0, 0)},
+		{"@hourly", at(1, 14, 11, 0)},
+		{"*/15 * * * *", at(1, 14, 10, 45)},
+		{"10-50/20 * * * *", at(1, 14, 10, 50)},
+		{"40 10 * * *", at(1, 14, 10, 40)},
+		{"0 9 * * mon-fri", at(1, 15, 9, 0)},
+		{"0 9 * * sat,sun", at(1, 17, 9, 0)},
+		{"0 9 * * 7", at(1, 18, 9, 0)},
+		{"0 0 1 * *", at(2, 1, 0, 0)},
+		{"0 0 31 * *", at(1, 31, 0, 0)},
+		{"0 0 29 feb *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted
+		{"0 0 20 * fri", at(1, 16, 0, 0)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
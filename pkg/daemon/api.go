@@ -0,0 +1,197 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// APIPrefix prefix of all daemon REST API endpoints
+const APIPrefix = "/api/v1/"
+
+// DefaultListen default address of the daemon REST API, local only
+const DefaultListen = "127.0.0.1:7070"
+
+// maxSpecSize upper bound of a submitted job specification
+const maxSpecSize = 1 << 20
+
+// Handler returns the http handler of the REST job API, requiring an API
+// token if any is configured
+func (m *Manager) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+APIPrefix+"jobs", m.handleSubmit)
+	mux.HandleFunc("GET "+APIPrefix+"jobs", m.handleList)
+	mux.HandleFunc("GET "+APIPrefix+"jobs/{id}", m.handleGet)
+	mux.HandleFunc("POST "+APIPrefix+"jobs/{id}/cancel", m.handleCancel)
+	mux.HandleFunc("POST "+APIPrefix+"jobs/{id}/retry", m.handleRetry)
+	mux.HandleFunc("GET "+APIPrefix+"jobs/{id}/events", m.handleEvents)
+	mux.HandleFunc("GET "+APIPrefix+"events", m.handleAllEvents)
+	mux.HandleFunc("GET "+APIPrefix+"profiles", m.handleProfiles)
+	mux.HandleFunc("GET "+APIPrefix+"stats", m.handleStats)
+	mux.HandleFunc("GET /metrics", m.handleMetrics)
+	mux.HandleFunc("GET /{$}", handleDashboard)
+	return sameOrigin(m.requireToken(mux))
+}
+
+// sameOrigin rejects requests sent by browsers from pages of other origins, so
+// that the web pages a user visits cannot drive a daemon on localhost
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				writeError(w, http.StatusForbidden, fmt.Errorf("cross-origin request from %q", origin))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleSubmit queues the job specification of the request body
+func (m *Manager) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	// Forms cannot post JSON, which keeps other sites from submitting jobs
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("content type must be application/json"))
+		return
+	}
+	var spec JobSpec
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSpecSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := m.submitRemote(spec, tokenFrom(r.Context()))
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, job)
+}
+
+// handleList returns the jobs of the token, optionally only those in the
+// state given by the state parameter
+func (m *Manager) handleList(w http.ResponseWriter, r *http.Request) {
+	tok := tokenFrom(r.Context())
+	state := State(r.URL.Query().Get("state"))
+	jobs := []Job{}
+	for _, job := range m.List() {
+		if tok.owns(job) && (state == "" || job.State == state) {
+			jobs = append(jobs, job)
+		}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (m *Manager) handleGet(w http.ResponseWriter, r *http.Request) {
+	job, err := m.authorize(tokenFrom(r.Context()), r.PathValue("id"))
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (m *Manager) handleCancel(w http.ResponseWriter, r *http.Request) {
+	job, err := m.authorize(tokenFrom(r.Context()), r.PathValue("id"))
+	if err == nil {
+		job, err = m.Cancel(job.ID)
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (m *Manager) handleRetry(w http.ResponseWriter, r *http.Request) {
+	job, err := m.authorize(tokenFrom(r.Context()), r.PathValue("id"))
+	if err == nil {
+		job, err = m.Retry(job.ID)
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleProfiles returns the job profiles by name
+func (m *Manager) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.Profiles())
+}
+
+// handleEvents streams snapshots of a job as server-sent events until the job
+// finished
+func (m *Manager) handleEvents(w http.ResponseWriter, r *http.Request) {
+	_, err := m.authorize(tokenFrom(r.Context()), r.PathValue("id"))
+	var jobs <-chan Job
+	if err == nil {
+		jobs, err = m.Watch(r.Context(), r.PathValue("id"))
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeEvents(w, jobs)
+}
+
+// handleAllEvents streams snapshots of the jobs of the token as server-sent events
+func (m *Manager) handleAllEvents(w http.ResponseWriter, r *http.Request) {
+	writeEvents(w, m.watchAll(r.Context(), tokenFrom(r.Context())))
+}
+
+// writeEvents writes the values received from ch as server-sent events
+func writeEvents[T any](w http.ResponseWriter, ch <-chan T) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for v := range ch {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// errorStatus maps manager errors to http status codes
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidSpec):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrJobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrJobFinished), errors.Is(err, ErrJobNotFinished):
+		return http.StatusConflict
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, errClosed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
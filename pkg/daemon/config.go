@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/easzlab/ezft/pkg/notify"
+	"gopkg.in/yaml.v3"
+)
+
+// Config the daemon configuration file
+type Config struct {
+	Profiles  Profiles                    // Job templates by name
+	Notifiers map[string]*notify.Notifier // Notifiers jobs attach by name
+	Tokens    map[string]*Token           // API tokens by name
+	Classes   map[string]*Class           // Bandwidth classes by name
+}
+
+// configFile the YAML layout of the daemon configuration file
+type configFile struct {
+	Profiles  map[string]any           `yaml:"profiles"`  // Job templates with the JSON field names of JobSpec
+	Notifiers map[string]notify.Config `yaml:"notifiers"` // Email, Slack and webhook notifiers
+	Tokens    map[string]*Token        `yaml:"tokens"`    // API tokens of tenants
+	Classes   map[string]*Class        `yaml:"classes"`   // Bandwidth classes
+}
+
+// LoadConfig reads the YAML configuration file at path
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	var file configFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	config := &Config{
+		Notifiers: map[string]*notify.Notifier{},
+		Tokens:    map[string]*Token{},
+		Classes:   map[string]*Class{},
+	}
+	if config.Profiles, err = parseProfiles(file.Profiles); err != nil {
+		return nil, err
+	}
+	for name, c := range file.Notifiers {
+		notifier, err := notify.New(&c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notifier %q: %w", name, err)
+		}
+		config.Notifiers[name] = notifier
+	}
+	for name, class := range file.Classes {
+		if class == nil {
+			class = &Class{}
+		}
+		if err := class.validate(); err != nil {
+			return nil, fmt.Errorf("invalid class %q: %w", name, err)
+		}
+		config.Classes[name] = class
+	}
+	secrets := map[string]bool{}
+	for name, tok := range file.Tokens {
+		if tok == nil {
+			tok = &Token{}
+		}
+		if err := tok.validate(config.Classes); err != nil {
+			return nil, fmt.Errorf("invalid token %q: %w", name, err)
+		}
+		if secrets[tok.Secret] {
+			return nil, fmt.Errorf("invalid token %q: token used twice", name)
+		}
+		secrets[tok.Secret] = true
+		tok.Name = name
+		config.Tokens[name] = tok
+	}
+	for name, profile := range config.Profiles {
+		for _, notifier := range profile.Notify {
+			if _, ok := config.Notifiers[notifier]; !ok {
+				return nil, fmt.Errorf("invalid profile %q: unknown notifier %q", name, notifier)
+			}
+		}
+		if _, ok := config.Classes[profile.Class]; profile.Class != "" && !ok {
+			return nil, fmt.Errorf("invalid profile %q: unknown class %q", name, profile.Class)
+		}
+	}
+	return config, nil
+}
+
+// NotifierNames returns the notifier names in alphabetical order
+func (c *Config) NotifierNames() []string {
+	names := make([]string, 0, len(c.Notifiers))
+	for name := range c.Notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetNotifiers sets the notifiers submitted jobs may attach by name
+func (m *Manager) SetNotifiers(notifiers map[string]*notify.Notifier) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifiers = notifiers
+}
+
+// checkNotifiers checks that all notifiers of a job exist, m.mu must be held
+func (m *Manager) checkNotifiers(names []string) error {
+	for _, name := range names {
+		if _, ok := m.notifiers[name]; !ok {
+			return fmt.Errorf("%w: unknown notifier %q", ErrInvalidSpec, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/hook"
+)
+
+func TestJobNotify(t *testing.T) {
+	url, root := newFileServer(t)
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("aaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan hook.Event, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e hook.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		received <- e
+	}))
+	defer ts.Close()
+
+	config, err := LoadConfig(writeConfig(t, "notifiers:\n  ci:\n    type: webhook\n    url: "+ts.URL+"\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if names := config.NotifierNames(); len(names) != 1 || names[0] != "ci" {
+		t.Fatalf("NotifierNames() = %v", names)
+	}
+	m := newTestManager(t, 1)
+	m.SetNotifiers(config.Notifiers)
+
+	if _, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/a.txt", Path: filepath.Join(t.TempDir(), "a.txt"), Notify: []string{"pager"}}); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("Submit() with an unknown notifier error = %v, want ErrInvalidSpec", err)
+	}
+	job, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/a.txt", Path: filepath.Join(t.TempDir(), "a.txt"), Notify: []string{"ci"}})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	select {
+	case e := <-received:
+		if e.Status != hook.StatusSuccess || e.Job != job.ID || e.Checksum == "" {
+			t.Errorf("Unexpected notification %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a notification of the finished job")
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown section":          "limits: {}\n",
+		"unknown type":             "notifiers:\n  ops:\n    type: pager\n",
+		"unknown field":            "notifiers:\n  ops:\n    type: slack\n    channel: ops\n",
+		"invalid throttle":         "notifiers:\n  ops:\n    type: slack\n    url: https://hooks.slack.com/x\n    throttle: often\n",
+		"token without paths":      "tokens:\n  a:\n    token: x\n",
+		"token without secret":     "tokens:\n  a:\n    admin: true\n",
+		"token of unknown class":   "tokens:\n  a:\n    token: x\n    paths: [/data]\n    classes: [bulk]\n",
+		"invalid token webhook":    "tokens:\n  a:\n    token: x\n    paths: [/data]\n    webhooks: [ftp://host/]\n",
+		"duplicate token":          "tokens:\n  a:\n    token: x\n    admin: true\n  b:\n    token: x\n    admin: true\n",
+		"invalid class limit":      "classes:\n  bulk:\n    limit: fast\n",
+		"profile of unknown class": "profiles:\n  a:\n    class: bulk\n",
+	} {
+		if _, err := LoadConfig(writeConfig(t, content)); err == nil {
+			t.Errorf("LoadConfig() of %s succeeded", name)
+		}
+	}
+	if _, err := LoadConfig(writeConfig(t, "")); err != nil {
+		t.Errorf("LoadConfig() of an empty file error = %v", err)
+	}
+}
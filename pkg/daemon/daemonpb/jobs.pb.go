@@ -0,0 +1,1383 @@
+// The job API of the ezft daemon over gRPC. The messages carry the same
+// fields as the JSON of the REST API under /api/v1/jobs.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: jobs.proto
+
+package daemonpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type JobType int32
+
+const (
+	JobType_JOB_TYPE_UNSPECIFIED JobType = 0
+	JobType_JOB_TYPE_DOWNLOAD    JobType = 1 // Download url into the local file path
+	JobType_JOB_TYPE_UPLOAD      JobType = 2 // Upload the local file path to url
+	JobType_JOB_TYPE_SYNC        JobType = 3 // Sync the local directory path with the remote directory url
+)
+
+// Enum value maps for JobType.
+var (
+	JobType_name = map[int32]string{
+		0: "JOB_TYPE_UNSPECIFIED",
+		1: "JOB_TYPE_DOWNLOAD",
+		2: "JOB_TYPE_UPLOAD",
+		3: "JOB_TYPE_SYNC",
+	}
+	JobType_value = map[string]int32{
+		"JOB_TYPE_UNSPECIFIED": 0,
+		"JOB_TYPE_DOWNLOAD":    1,
+		"JOB_TYPE_UPLOAD":      2,
+		"JOB_TYPE_SYNC":        3,
+	}
+)
+
+func (x JobType) Enum() *JobType {
+	p := new(JobType)
+	*p = x
+	return p
+}
+
+func (x JobType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (JobType) Descriptor() protoreflect.EnumDescriptor {
+	return file_jobs_proto_enumTypes[0].Descriptor()
+}
+
+func (JobType) Type() protoreflect.EnumType {
+	return &file_jobs_proto_enumTypes[0]
+}
+
+func (x JobType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use JobType.Descriptor instead.
+func (JobType) EnumDescriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{0}
+}
+
+type State int32
+
+const (
+	State_STATE_UNSPECIFIED State = 0
+	State_STATE_QUEUED      State = 1 // Waiting for a free slot
+	State_STATE_RUNNING     State = 2 // Transferring
+	State_STATE_SUCCEEDED   State = 3 // Finished successfully
+	State_STATE_FAILED      State = 4 // Stopped by an error
+	State_STATE_CANCELED    State = 5 // Canceled on request
+	State_STATE_SCHEDULED   State = 6 // Recurring job waiting for its next run
+	State_STATE_RETRYING    State = 7 // Failed job waiting for its next automatic retry
+)
+
+// Enum value maps for State.
+var (
+	State_name = map[int32]string{
+		0: "STATE_UNSPECIFIED",
+		1: "STATE_QUEUED",
+		2: "STATE_RUNNING",
+		3: "STATE_SUCCEEDED",
+		4: "STATE_FAILED",
+		5: "STATE_CANCELED",
+		6: "STATE_SCHEDULED",
+		7: "STATE_RETRYING",
+	}
+	State_value = map[string]int32{
+		"STATE_UNSPECIFIED": 0,
+		"STATE_QUEUED":      1,
+		"STATE_RUNNING":     2,
+		"STATE_SUCCEEDED":   3,
+		"STATE_FAILED":      4,
+		"STATE_CANCELED":    5,
+		"STATE_SCHEDULED":   6,
+		"STATE_RETRYING":    7,
+	}
+)
+
+func (x State) Enum() *State {
+	p := new(State)
+	*p = x
+	return p
+}
+
+func (x State) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (State) Descriptor() protoreflect.EnumDescriptor {
+	return file_jobs_proto_enumTypes[1].Descriptor()
+}
+
+func (State) Type() protoreflect.EnumType {
+	return &file_jobs_proto_enumTypes[1]
+}
+
+func (x State) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use State.Descriptor instead.
+func (State) EnumDescriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{1}
+}
+
+// JobRequest identifies the job of a call
+type JobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JobRequest) Reset() {
+	*x = JobRequest{}
+	mi := &file_jobs_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JobRequest) ProtoMessage() {}
+
+func (x *JobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_jobs_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JobRequest.ProtoReflect.Descriptor instead.
+func (*JobRequest) Descriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *JobRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+// Hooks run after a transfer
+type Hooks struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	OnSuccess     string                 `protobuf:"bytes,1,opt,name=on_success,json=onSuccess,proto3" json:"on_success,omitempty"` // Shell command run after a successful transfer
+	OnFailure     string                 `protobuf:"bytes,2,opt,name=on_failure,json=onFailure,proto3" json:"on_failure,omitempty"` // Shell command run after a failed transfer
+	Webhook       string                 `protobuf:"bytes,3,opt,name=webhook,proto3" json:"webhook,omitempty"`                      // URL the event is posted to as JSON after every transfer
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Hooks) Reset() {
+	*x = Hooks{}
+	mi := &file_jobs_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Hooks) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Hooks) ProtoMessage() {}
+
+func (x *Hooks) ProtoReflect() protoreflect.Message {
+	mi := &file_jobs_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Hooks.ProtoReflect.Descriptor instead.
+func (*Hooks) Descriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Hooks) GetOnSuccess() string {
+	if x != nil {
+		return x.OnSuccess
+	}
+	return ""
+}
+
+func (x *Hooks) GetOnFailure() string {
+	if x != nil {
+		return x.OnFailure
+	}
+	return ""
+}
+
+func (x *Hooks) GetWebhook() string {
+	if x != nil {
+		return x.Webhook
+	}
+	return ""
+}
+
+// JobSpec what a job transfers
+type JobSpec struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          JobType                `protobuf:"varint,1,opt,name=type,proto3,enum=ezft.daemon.v1.JobType" json:"type,omitempty"`
+	Url           string                 `protobuf:"bytes,2,opt,name=url,proto3" json:"url,omitempty"`                   // Remote file or directory URL
+	Path          string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"`                 // Absolute local file or directory path
+	Direction     string                 `protobuf:"bytes,4,opt,name=direction,proto3" json:"direction,omitempty"`       // Sync direction: pull (default), push or bidirectional
+	Conflict      string                 `protobuf:"bytes,5,opt,name=conflict,proto3" json:"conflict,omitempty"`         // Conflict strategy of bidirectional sync
+	Include       []string               `protobuf:"bytes,6,rep,name=include,proto3" json:"include,omitempty"`           // Sync filter patterns of files to include
+	Exclude       []string               `protobuf:"bytes,7,rep,name=exclude,proto3" json:"exclude,omitempty"`           // Sync filter patterns of files to skip
+	Delete        bool                   `protobuf:"varint,8,opt,name=delete,proto3" json:"delete,omitempty"`            // Delete sync destination files missing from the source
+	Delta         bool                   `protobuf:"varint,9,opt,name=delta,proto3" json:"delta,omitempty"`              // Update an existing download by delta transfer
+	Concurrency   int32                  `protobuf:"varint,10,opt,name=concurrency,proto3" json:"concurrency,omitempty"` // Chunk concurrency per file, 1 if unset
+	Username      string                 `protobuf:"bytes,11,opt,name=username,proto3" json:"username,omitempty"`        // Basic auth username
+	Password      string                 `protobuf:"bytes,12,opt,name=password,proto3" json:"password,omitempty"`        // Basic auth password, never returned
+	Priority      int32                  `protobuf:"varint,13,opt,name=priority,proto3" json:"priority,omitempty"`       // Jobs with a higher priority start first
+	Group         string                 `protobuf:"bytes,14,opt,name=group,proto3" json:"group,omitempty"`              // Group sharing running slots under fair-share scheduling
+	Cron          string                 `protobuf:"bytes,15,opt,name=cron,proto3" json:"cron,omitempty"`                // Cron expression running the job repeatedly
+	Overlap       string                 `protobuf:"bytes,16,opt,name=overlap,proto3" json:"overlap,omitempty"`          // Overlap policy of recurring runs: skip (default), queue or kill-previous
+	Jitter        string                 `protobuf:"bytes,17,opt,name=jitter,proto3" json:"jitter,omitempty"`            // Random delay of up to this duration added to every run, e.g. "5m"
+	Hooks         *Hooks                 `protobuf:"bytes,18,opt,name=hooks,proto3" json:"hooks,omitempty"`
+	Retries       int32                  `protobuf:"varint,19,opt,name=retries,proto3" json:"retries,omitempty"` // Automatic retries of a failed job before it stays failed
+	Backoff       string                 `protobuf:"bytes,20,opt,name=backoff,proto3" json:"backoff,omitempty"`  // Delay before the first retry, doubled for every further one
+	Weight        int32                  `protobuf:"varint,21,opt,name=weight,proto3" json:"weight,omitempty"`   // Part of the daemon bandwidth limit, 1 if unset
+	Profile       string                 `protobuf:"bytes,22,opt,name=profile,proto3" json:"profile,omitempty"`  // Profile of the daemon configuration providing unset fields
+	Notify        []string               `protobuf:"bytes,23,rep,name=notify,proto3" json:"notify,omitempty"`    // Notifiers of the daemon configuration told when the job finished
+	Class         string                 `protobuf:"bytes,24,opt,name=class,proto3" json:"class,omitempty"`      // Bandwidth class of the daemon configuration
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JobSpec) Reset() {
+	*x = JobSpec{}
+	mi := &file_jobs_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JobSpec) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JobSpec) ProtoMessage() {}
+
+func (x *JobSpec) ProtoReflect() protoreflect.Message {
+	mi := &file_jobs_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JobSpec.ProtoReflect.Descriptor instead.
+func (*JobSpec) Descriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *JobSpec) GetType() JobType {
+	if x != nil {
+		return x.Type
+	}
+	return JobType_JOB_TYPE_UNSPECIFIED
+}
+
+func (x *JobSpec) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *JobSpec) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *JobSpec) GetDirection() string {
+	if x != nil {
+		return x.Direction
+	}
+	return ""
+}
+
+func (x *JobSpec) GetConflict() string {
+	if x != nil {
+		return x.Conflict
+	}
+	return ""
+}
+
+func (x *JobSpec) GetInclude() []string {
+	if x != nil {
+		return x.Include
+	}
+	return nil
+}
+
+func (x *JobSpec) GetExclude() []string {
+	if x != nil {
+		return x.Exclude
+	}
+	return nil
+}
+
+func (x *JobSpec) GetDelete() bool {
+	if x != nil {
+		return x.Delete
+	}
+	return false
+}
+
+func (x *JobSpec) GetDelta() bool {
+	if x != nil {
+		return x.Delta
+	}
+	return false
+}
+
+func (x *JobSpec) GetConcurrency() int32 {
+	if x != nil {
+		return x.Concurrency
+	}
+	return 0
+}
+
+func (x *JobSpec) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *JobSpec) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *JobSpec) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *JobSpec) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *JobSpec) GetCron() string {
+	if x != nil {
+		return x.Cron
+	}
+	return ""
+}
+
+func (x *JobSpec) GetOverlap() string {
+	if x != nil {
+		return x.Overlap
+	}
+	return ""
+}
+
+func (x *JobSpec) GetJitter() string {
+	if x != nil {
+		return x.Jitter
+	}
+	return ""
+}
+
+func (x *JobSpec) GetHooks() *Hooks {
+	if x != nil {
+		return x.Hooks
+	}
+	return nil
+}
+
+func (x *JobSpec) GetRetries() int32 {
+	if x != nil {
+		return x.Retries
+	}
+	return 0
+}
+
+func (x *JobSpec) GetBackoff() string {
+	if x != nil {
+		return x.Backoff
+	}
+	return ""
+}
+
+func (x *JobSpec) GetWeight() int32 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
+
+func (x *JobSpec) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *JobSpec) GetNotify() []string {
+	if x != nil {
+		return x.Notify
+	}
+	return nil
+}
+
+func (x *JobSpec) GetClass() string {
+	if x != nil {
+		return x.Class
+	}
+	return ""
+}
+
+// Attempt a failed run of a job
+type Attempt struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Started       *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=started,proto3" json:"started,omitempty"`
+	Finished      *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=finished,proto3" json:"finished,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Attempt) Reset() {
+	*x = Attempt{}
+	mi := &file_jobs_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Attempt) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Attempt) ProtoMessage() {}
+
+func (x *Attempt) ProtoReflect() protoreflect.Message {
+	mi := &file_jobs_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Attempt.ProtoReflect.Descriptor instead.
+func (*Attempt) Descriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Attempt) GetStarted() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Started
+	}
+	return nil
+}
+
+func (x *Attempt) GetFinished() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Finished
+	}
+	return nil
+}
+
+func (x *Attempt) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// ManifestEntry a file of a tree, on one side of a sync
+type ManifestEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`   // Slash separated path relative to the tree root
+	Size          int64                  `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`  // File size, 0 for symbolic links
+	Mtime         *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=mtime,proto3" json:"mtime,omitempty"` // Modification time
+	Mode          uint32                 `protobuf:"varint,4,opt,name=mode,proto3" json:"mode,omitempty"`  // Permission bits, 0 if unknown
+	Link          string                 `protobuf:"bytes,5,opt,name=link,proto3" json:"link,omitempty"`   // Target of a symbolic link, empty for regular files
+	Dir           bool                   `protobuf:"varint,6,opt,name=dir,proto3" json:"dir,omitempty"`    // Directory
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ManifestEntry) Reset() {
+	*x = ManifestEntry{}
+	mi := &file_jobs_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ManifestEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ManifestEntry) ProtoMessage() {}
+
+func (x *ManifestEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_jobs_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ManifestEntry.ProtoReflect.Descriptor instead.
+func (*ManifestEntry) Descriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ManifestEntry) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ManifestEntry) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *ManifestEntry) GetMtime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Mtime
+	}
+	return nil
+}
+
+func (x *ManifestEntry) GetMode() uint32 {
+	if x != nil {
+		return x.Mode
+	}
+	return 0
+}
+
+func (x *ManifestEntry) GetLink() string {
+	if x != nil {
+		return x.Link
+	}
+	return ""
+}
+
+func (x *ManifestEntry) GetDir() bool {
+	if x != nil {
+		return x.Dir
+	}
+	return false
+}
+
+// SyncConflict a file changed on both sides of a bidirectional sync
+type SyncConflict struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Local         *ManifestEntry         `protobuf:"bytes,2,opt,name=local,proto3" json:"local,omitempty"`
+	Remote        *ManifestEntry         `protobuf:"bytes,3,opt,name=remote,proto3" json:"remote,omitempty"`
+	Resolution    string                 `protobuf:"bytes,4,opt,name=resolution,proto3" json:"resolution,omitempty"`
+	Renamed       string                 `protobuf:"bytes,5,opt,name=renamed,proto3" json:"renamed,omitempty"` // Name given to the local copy by keep-both
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncConflict) Reset() {
+	*x = SyncConflict{}
+	mi := &file_jobs_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncConflict) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncConflict) ProtoMessage() {}
+
+func (x *SyncConflict) ProtoReflect() protoreflect.Message {
+	mi := &file_jobs_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncConflict.ProtoReflect.Descriptor instead.
+func (*SyncConflict) Descriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *SyncConflict) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *SyncConflict) GetLocal() *ManifestEntry {
+	if x != nil {
+		return x.Local
+	}
+	return nil
+}
+
+func (x *SyncConflict) GetRemote() *ManifestEntry {
+	if x != nil {
+		return x.Remote
+	}
+	return nil
+}
+
+func (x *SyncConflict) GetResolution() string {
+	if x != nil {
+		return x.Resolution
+	}
+	return ""
+}
+
+func (x *SyncConflict) GetRenamed() string {
+	if x != nil {
+		return x.Renamed
+	}
+	return ""
+}
+
+// VerifiedFile the hashes of a file on both sides of a sync
+type VerifiedFile struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	LocalHash     string                 `protobuf:"bytes,2,opt,name=local_hash,json=localHash,proto3" json:"local_hash,omitempty"`
+	RemoteHash    string                 `protobuf:"bytes,3,opt,name=remote_hash,json=remoteHash,proto3" json:"remote_hash,omitempty"`
+	Match         bool                   `protobuf:"varint,4,opt,name=match,proto3" json:"match,omitempty"`
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"` // Why a hash could not be computed
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VerifiedFile) Reset() {
+	*x = VerifiedFile{}
+	mi := &file_jobs_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifiedFile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifiedFile) ProtoMessage() {}
+
+func (x *VerifiedFile) ProtoReflect() protoreflect.Message {
+	mi := &file_jobs_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifiedFile.ProtoReflect.Descriptor instead.
+func (*VerifiedFile) Descriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *VerifiedFile) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *VerifiedFile) GetLocalHash() string {
+	if x != nil {
+		return x.LocalHash
+	}
+	return ""
+}
+
+func (x *VerifiedFile) GetRemoteHash() string {
+	if x != nil {
+		return x.RemoteHash
+	}
+	return ""
+}
+
+func (x *VerifiedFile) GetMatch() bool {
+	if x != nil {
+		return x.Match
+	}
+	return false
+}
+
+func (x *VerifiedFile) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// SyncVerification the result of the verification pass of a sync
+type SyncVerification struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Remote        string                 `protobuf:"bytes,1,opt,name=remote,proto3" json:"remote,omitempty"`
+	LocalDir      string                 `protobuf:"bytes,2,opt,name=local_dir,json=localDir,proto3" json:"local_dir,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=time,proto3" json:"time,omitempty"`
+	Algorithm     string                 `protobuf:"bytes,4,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	Files         []*VerifiedFile        `protobuf:"bytes,5,rep,name=files,proto3" json:"files,omitempty"`
+	Mismatches    int32                  `protobuf:"varint,6,opt,name=mismatches,proto3" json:"mismatches,omitempty"` // Files differing or failing to hash
+	Signature     string                 `protobuf:"bytes,7,opt,name=signature,proto3" json:"signature,omitempty"`    // Hex HMAC-SHA256 of the JSON report without signature
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncVerification) Reset() {
+	*x = SyncVerification{}
+	mi := &file_jobs_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncVerification) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncVerification) ProtoMessage() {}
+
+func (x *SyncVerification) ProtoReflect() protoreflect.Message {
+	mi := &file_jobs_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncVerification.ProtoReflect.Descriptor instead.
+func (*SyncVerification) Descriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SyncVerification) GetRemote() string {
+	if x != nil {
+		return x.Remote
+	}
+	return ""
+}
+
+func (x *SyncVerification) GetLocalDir() string {
+	if x != nil {
+		return x.LocalDir
+	}
+	return ""
+}
+
+func (x *SyncVerification) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *SyncVerification) GetAlgorithm() string {
+	if x != nil {
+		return x.Algorithm
+	}
+	return ""
+}
+
+func (x *SyncVerification) GetFiles() []*VerifiedFile {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+func (x *SyncVerification) GetMismatches() int32 {
+	if x != nil {
+		return x.Mismatches
+	}
+	return 0
+}
+
+func (x *SyncVerification) GetSignature() string {
+	if x != nil {
+		return x.Signature
+	}
+	return ""
+}
+
+// SyncReport the result of a sync job
+type SyncReport struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Downloaded    int32                  `protobuf:"varint,1,opt,name=downloaded,proto3" json:"downloaded,omitempty"`
+	Uploaded      int32                  `protobuf:"varint,2,opt,name=uploaded,proto3" json:"uploaded,omitempty"`
+	Copied        int32                  `protobuf:"varint,3,opt,name=copied,proto3" json:"copied,omitempty"`
+	Deleted       int32                  `protobuf:"varint,4,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	Duration      *durationpb.Duration   `protobuf:"bytes,5,opt,name=duration,proto3" json:"duration,omitempty"`
+	Skipped       int32                  `protobuf:"varint,6,opt,name=skipped,proto3" json:"skipped,omitempty"`
+	Resumed       int32                  `protobuf:"varint,7,opt,name=resumed,proto3" json:"resumed,omitempty"` // Tasks completed by an interrupted run this one resumed
+	Bytes         int64                  `protobuf:"varint,8,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	Conflicts     []*SyncConflict        `protobuf:"bytes,9,rep,name=conflicts,proto3" json:"conflicts,omitempty"`
+	Verification  *SyncVerification      `protobuf:"bytes,10,opt,name=verification,proto3" json:"verification,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncReport) Reset() {
+	*x = SyncReport{}
+	mi := &file_jobs_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncReport) ProtoMessage() {}
+
+func (x *SyncReport) ProtoReflect() protoreflect.Message {
+	mi := &file_jobs_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncReport.ProtoReflect.Descriptor instead.
+func (*SyncReport) Descriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SyncReport) GetDownloaded() int32 {
+	if x != nil {
+		return x.Downloaded
+	}
+	return 0
+}
+
+func (x *SyncReport) GetUploaded() int32 {
+	if x != nil {
+		return x.Uploaded
+	}
+	return 0
+}
+
+func (x *SyncReport) GetCopied() int32 {
+	if x != nil {
+		return x.Copied
+	}
+	return 0
+}
+
+func (x *SyncReport) GetDeleted() int32 {
+	if x != nil {
+		return x.Deleted
+	}
+	return 0
+}
+
+func (x *SyncReport) GetDuration() *durationpb.Duration {
+	if x != nil {
+		return x.Duration
+	}
+	return nil
+}
+
+func (x *SyncReport) GetSkipped() int32 {
+	if x != nil {
+		return x.Skipped
+	}
+	return 0
+}
+
+func (x *SyncReport) GetResumed() int32 {
+	if x != nil {
+		return x.Resumed
+	}
+	return 0
+}
+
+func (x *SyncReport) GetBytes() int64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *SyncReport) GetConflicts() []*SyncConflict {
+	if x != nil {
+		return x.Conflicts
+	}
+	return nil
+}
+
+func (x *SyncReport) GetVerification() *SyncVerification {
+	if x != nil {
+		return x.Verification
+	}
+	return nil
+}
+
+// Job a transfer submitted to the daemon
+type Job struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Spec          *JobSpec               `protobuf:"bytes,2,opt,name=spec,proto3" json:"spec,omitempty"`
+	State         State                  `protobuf:"varint,3,opt,name=state,proto3,enum=ezft.daemon.v1.State" json:"state,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"` // Why the job failed
+	Created       *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created,proto3" json:"created,omitempty"`
+	Started       *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=started,proto3" json:"started,omitempty"`
+	Finished      *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=finished,proto3" json:"finished,omitempty"`
+	Bytes         int64                  `protobuf:"varint,8,opt,name=bytes,proto3" json:"bytes,omitempty"`                          // Bytes sent and received so far
+	Total         int64                  `protobuf:"varint,9,opt,name=total,proto3" json:"total,omitempty"`                          // Bytes to transfer, if known in advance
+	Report        *SyncReport            `protobuf:"bytes,10,opt,name=report,proto3" json:"report,omitempty"`                        // Result of a sync job
+	Schedule      string                 `protobuf:"bytes,11,opt,name=schedule,proto3" json:"schedule,omitempty"`                    // ID of the recurring job that started this run
+	Next          *timestamppb.Timestamp `protobuf:"bytes,12,opt,name=next,proto3" json:"next,omitempty"`                            // Next run of a recurring job
+	HookError     string                 `protobuf:"bytes,13,opt,name=hook_error,json=hookError,proto3" json:"hook_error,omitempty"` // Why the hooks of the job failed
+	Retries       int32                  `protobuf:"varint,14,opt,name=retries,proto3" json:"retries,omitempty"`                     // Automatic retries since submission or the last manual retry
+	RetryAt       *timestamppb.Timestamp `protobuf:"bytes,15,opt,name=retry_at,json=retryAt,proto3" json:"retry_at,omitempty"`       // Next automatic retry of a failed job
+	Attempts      []*Attempt             `protobuf:"bytes,16,rep,name=attempts,proto3" json:"attempts,omitempty"`                    // Failed attempts, oldest first
+	Owner         string                 `protobuf:"bytes,17,opt,name=owner,proto3" json:"owner,omitempty"`                          // Name of the API token that submitted the job
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Job) Reset() {
+	*x = Job{}
+	mi := &file_jobs_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Job) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Job) ProtoMessage() {}
+
+func (x *Job) ProtoReflect() protoreflect.Message {
+	mi := &file_jobs_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Job.ProtoReflect.Descriptor instead.
+func (*Job) Descriptor() ([]byte, []int) {
+	return file_jobs_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Job) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Job) GetSpec() *JobSpec {
+	if x != nil {
+		return x.Spec
+	}
+	return nil
+}
+
+func (x *Job) GetState() State {
+	if x != nil {
+		return x.State
+	}
+	return State_STATE_UNSPECIFIED
+}
+
+func (x *Job) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Job) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *Job) GetStarted() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Started
+	}
+	return nil
+}
+
+func (x *Job) GetFinished() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Finished
+	}
+	return nil
+}
+
+func (x *Job) GetBytes() int64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *Job) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *Job) GetReport() *SyncReport {
+	if x != nil {
+		return x.Report
+	}
+	return nil
+}
+
+func (x *Job) GetSchedule() string {
+	if x != nil {
+		return x.Schedule
+	}
+	return ""
+}
+
+func (x *Job) GetNext() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Next
+	}
+	return nil
+}
+
+func (x *Job) GetHookError() string {
+	if x != nil {
+		return x.HookError
+	}
+	return ""
+}
+
+func (x *Job) GetRetries() int32 {
+	if x != nil {
+		return x.Retries
+	}
+	return 0
+}
+
+func (x *Job) GetRetryAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RetryAt
+	}
+	return nil
+}
+
+func (x *Job) GetAttempts() []*Attempt {
+	if x != nil {
+		return x.Attempts
+	}
+	return nil
+}
+
+func (x *Job) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+var File_jobs_proto protoreflect.FileDescriptor
+
+const file_jobs_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"jobs.proto\x12\x0eezft.daemon.v1\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x1c\n" +
+	"\n" +
+	"JobRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"_\n" +
+	"\x05Hooks\x12\x1d\n" +
+	"\n" +
+	"on_success\x18\x01 \x01(\tR\tonSuccess\x12\x1d\n" +
+	"\n" +
+	"on_failure\x18\x02 \x01(\tR\tonFailure\x12\x18\n" +
+	"\awebhook\x18\x03 \x01(\tR\awebhook\"\x8b\x05\n" +
+	"\aJobSpec\x12+\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x17.ezft.daemon.v1.JobTypeR\x04type\x12\x10\n" +
+	"\x03url\x18\x02 \x01(\tR\x03url\x12\x12\n" +
+	"\x04path\x18\x03 \x01(\tR\x04path\x12\x1c\n" +
+	"\tdirection\x18\x04 \x01(\tR\tdirection\x12\x1a\n" +
+	"\bconflict\x18\x05 \x01(\tR\bconflict\x12\x18\n" +
+	"\ainclude\x18\x06 \x03(\tR\ainclude\x12\x18\n" +
+	"\aexclude\x18\a \x03(\tR\aexclude\x12\x16\n" +
+	"\x06delete\x18\b \x01(\bR\x06delete\x12\x14\n" +
+	"\x05delta\x18\t \x01(\bR\x05delta\x12 \n" +
+	"\vconcurrency\x18\n" +
+	" \x01(\x05R\vconcurrency\x12\x1a\n" +
+	"\busername\x18\v \x01(\tR\busername\x12\x1a\n" +
+	"\bpassword\x18\f \x01(\tR\bpassword\x12\x1a\n" +
+	"\bpriority\x18\r \x01(\x05R\bpriority\x12\x14\n" +
+	"\x05group\x18\x0e \x01(\tR\x05group\x12\x12\n" +
+	"\x04cron\x18\x0f \x01(\tR\x04cron\x12\x18\n" +
+	"\aoverlap\x18\x10 \x01(\tR\aoverlap\x12\x16\n" +
+	"\x06jitter\x18\x11 \x01(\tR\x06jitter\x12+\n" +
+	"\x05hooks\x18\x12 \x01(\v2\x15.ezft.daemon.v1.HooksR\x05hooks\x12\x18\n" +
+	"\aretries\x18\x13 \x01(\x05R\aretries\x12\x18\n" +
+	"\abackoff\x18\x14 \x01(\tR\abackoff\x12\x16\n" +
+	"\x06weight\x18\x15 \x01(\x05R\x06weight\x12\x18\n" +
+	"\aprofile\x18\x16 \x01(\tR\aprofile\x12\x16\n" +
+	"\x06notify\x18\x17 \x03(\tR\x06notify\x12\x14\n" +
+	"\x05class\x18\x18 \x01(\tR\x05class\"\x8d\x01\n" +
+	"\aAttempt\x124\n" +
+	"\astarted\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\astarted\x126\n" +
+	"\bfinished\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\bfinished\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"\xa3\x01\n" +
+	"\rManifestEntry\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x03R\x04size\x120\n" +
+	"\x05mtime\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x05mtime\x12\x12\n" +
+	"\x04mode\x18\x04 \x01(\rR\x04mode\x12\x12\n" +
+	"\x04link\x18\x05 \x01(\tR\x04link\x12\x10\n" +
+	"\x03dir\x18\x06 \x01(\bR\x03dir\"\xc8\x01\n" +
+	"\fSyncConflict\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x123\n" +
+	"\x05local\x18\x02 \x01(\v2\x1d.ezft.daemon.v1.ManifestEntryR\x05local\x125\n" +
+	"\x06remote\x18\x03 \x01(\v2\x1d.ezft.daemon.v1.ManifestEntryR\x06remote\x12\x1e\n" +
+	"\n" +
+	"resolution\x18\x04 \x01(\tR\n" +
+	"resolution\x12\x18\n" +
+	"\arenamed\x18\x05 \x01(\tR\arenamed\"\x8e\x01\n" +
+	"\fVerifiedFile\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x1d\n" +
+	"\n" +
+	"local_hash\x18\x02 \x01(\tR\tlocalHash\x12\x1f\n" +
+	"\vremote_hash\x18\x03 \x01(\tR\n" +
+	"remoteHash\x12\x14\n" +
+	"\x05match\x18\x04 \x01(\bR\x05match\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"\x87\x02\n" +
+	"\x10SyncVerification\x12\x16\n" +
+	"\x06remote\x18\x01 \x01(\tR\x06remote\x12\x1b\n" +
+	"\tlocal_dir\x18\x02 \x01(\tR\blocalDir\x12.\n" +
+	"\x04time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1c\n" +
+	"\talgorithm\x18\x04 \x01(\tR\talgorithm\x122\n" +
+	"\x05files\x18\x05 \x03(\v2\x1c.ezft.daemon.v1.VerifiedFileR\x05files\x12\x1e\n" +
+	"\n" +
+	"mismatches\x18\x06 \x01(\x05R\n" +
+	"mismatches\x12\x1c\n" +
+	"\tsignature\x18\a \x01(\tR\tsignature\"\xfd\x02\n" +
+	"\n" +
+	"SyncReport\x12\x1e\n" +
+	"\n" +
+	"downloaded\x18\x01 \x01(\x05R\n" +
+	"downloaded\x12\x1a\n" +
+	"\buploaded\x18\x02 \x01(\x05R\buploaded\x12\x16\n" +
+	"\x06copied\x18\x03 \x01(\x05R\x06copied\x12\x18\n" +
+	"\adeleted\x18\x04 \x01(\x05R\adeleted\x125\n" +
+	"\bduration\x18\x05 \x01(\v2\x19.google.protobuf.DurationR\bduration\x12\x18\n" +
+	"\askipped\x18\x06 \x01(\x05R\askipped\x12\x18\n" +
+	"\aresumed\x18\a \x01(\x05R\aresumed\x12\x14\n" +
+	"\x05bytes\x18\b \x01(\x03R\x05bytes\x12:\n" +
+	"\tconflicts\x18\t \x03(\v2\x1c.ezft.daemon.v1.SyncConflictR\tconflicts\x12D\n" +
+	"\fverification\x18\n" +
+	" \x01(\v2 .ezft.daemon.v1.SyncVerificationR\fverification\"\x90\x05\n" +
+	"\x03Job\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12+\n" +
+	"\x04spec\x18\x02 \x01(\v2\x17.ezft.daemon.v1.JobSpecR\x04spec\x12+\n" +
+	"\x05state\x18\x03 \x01(\x0e2\x15.ezft.daemon.v1.StateR\x05state\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x124\n" +
+	"\acreated\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\acreated\x124\n" +
+	"\astarted\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\astarted\x126\n" +
+	"\bfinished\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\bfinished\x12\x14\n" +
+	"\x05bytes\x18\b \x01(\x03R\x05bytes\x12\x14\n" +
+	"\x05total\x18\t \x01(\x03R\x05total\x122\n" +
+	"\x06report\x18\n" +
+	" \x01(\v2\x1a.ezft.daemon.v1.SyncReportR\x06report\x12\x1a\n" +
+	"\bschedule\x18\v \x01(\tR\bschedule\x12.\n" +
+	"\x04next\x18\f \x01(\v2\x1a.google.protobuf.TimestampR\x04next\x12\x1d\n" +
+	"\n" +
+	"hook_error\x18\r \x01(\tR\thookError\x12\x18\n" +
+	"\aretries\x18\x0e \x01(\x05R\aretries\x125\n" +
+	"\bretry_at\x18\x0f \x01(\v2\x1a.google.protobuf.TimestampR\aretryAt\x123\n" +
+	"\battempts\x18\x10 \x03(\v2\x17.ezft.daemon.v1.AttemptR\battempts\x12\x14\n" +
+	"\x05owner\x18\x11 \x01(\tR\x05owner*b\n" +
+	"\aJobType\x12\x18\n" +
+	"\x14JOB_TYPE_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11JOB_TYPE_DOWNLOAD\x10\x01\x12\x13\n" +
+	"\x0fJOB_TYPE_UPLOAD\x10\x02\x12\x11\n" +
+	"\rJOB_TYPE_SYNC\x10\x03*\xa7\x01\n" +
+	"\x05State\x12\x15\n" +
+	"\x11STATE_UNSPECIFIED\x10\x00\x12\x10\n" +
+	"\fSTATE_QUEUED\x10\x01\x12\x11\n" +
+	"\rSTATE_RUNNING\x10\x02\x12\x13\n" +
+	"\x0fSTATE_SUCCEEDED\x10\x03\x12\x10\n" +
+	"\fSTATE_FAILED\x10\x04\x12\x12\n" +
+	"\x0eSTATE_CANCELED\x10\x05\x12\x13\n" +
+	"\x0fSTATE_SCHEDULED\x10\x06\x12\x12\n" +
+	"\x0eSTATE_RETRYING\x10\a2\xc3\x01\n" +
+	"\x04Jobs\x129\n" +
+	"\tSubmitJob\x12\x17.ezft.daemon.v1.JobSpec\x1a\x13.ezft.daemon.v1.Job\x12<\n" +
+	"\tCancelJob\x12\x1a.ezft.daemon.v1.JobRequest\x1a\x13.ezft.daemon.v1.Job\x12B\n" +
+	"\rWatchProgress\x12\x1a.ezft.daemon.v1.JobRequest\x1a\x13.ezft.daemon.v1.Job0\x01B-Z+github.com/easzlab/ezft/pkg/daemon/daemonpbb\x06proto3"
+
+var (
+	file_jobs_proto_rawDescOnce sync.Once
+	file_jobs_proto_rawDescData []byte
+)
+
+func file_jobs_proto_rawDescGZIP() []byte {
+	file_jobs_proto_rawDescOnce.Do(func() {
+		file_jobs_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_jobs_proto_rawDesc), len(file_jobs_proto_rawDesc)))
+	})
+	return file_jobs_proto_rawDescData
+}
+
+var file_jobs_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_jobs_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_jobs_proto_goTypes = []any{
+	(JobType)(0),                  // 0: ezft.daemon.v1.JobType
+	(State)(0),                    // 1: ezft.daemon.v1.State
+	(*JobRequest)(nil),            // 2: ezft.daemon.v1.JobRequest
+	(*Hooks)(nil),                 // 3: ezft.daemon.v1.Hooks
+	(*JobSpec)(nil),               // 4: ezft.daemon.v1.JobSpec
+	(*Attempt)(nil),               // 5: ezft.daemon.v1.Attempt
+	(*ManifestEntry)(nil),         // 6: ezft.daemon.v1.ManifestEntry
+	(*SyncConflict)(nil),          // 7: ezft.daemon.v1.SyncConflict
+	(*VerifiedFile)(nil),          // 8: ezft.daemon.v1.VerifiedFile
+	(*SyncVerification)(nil),      // 9: ezft.daemon.v1.SyncVerification
+	(*SyncReport)(nil),            // 10: ezft.daemon.v1.SyncReport
+	(*Job)(nil),                   // 11: ezft.daemon.v1.Job
+	(*timestamppb.Timestamp)(nil), // 12: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),   // 13: google.protobuf.Duration
+}
+var file_jobs_proto_depIdxs = []int32{
+	0,  // 0: ezft.daemon.v1.JobSpec.type:type_name -> ezft.daemon.v1.JobType
+	3,  // 1: ezft.daemon.v1.JobSpec.hooks:type_name -> ezft.daemon.v1.Hooks
+	12, // 2: ezft.daemon.v1.Attempt.started:type_name -> google.protobuf.Timestamp
+	12, // 3: ezft.daemon.v1.Attempt.finished:type_name -> google.protobuf.Timestamp
+	12, // 4: ezft.daemon.v1.ManifestEntry.mtime:type_name -> google.protobuf.Timestamp
+	6,  // 5: ezft.daemon.v1.SyncConflict.local:type_name -> ezft.daemon.v1.ManifestEntry
+	6,  // 6: ezft.daemon.v1.SyncConflict.remote:type_name -> ezft.daemon.v1.ManifestEntry
+	12, // 7: ezft.daemon.v1.SyncVerification.time:type_name -> google.protobuf.Timestamp
+	8,  // 8: ezft.daemon.v1.SyncVerification.files:type_name -> ezft.daemon.v1.VerifiedFile
+	13, // 9: ezft.daemon.v1.SyncReport.duration:type_name -> google.protobuf.Duration
+	7,  // 10: ezft.daemon.v1.SyncReport.conflicts:type_name -> ezft.daemon.v1.SyncConflict
+	9,  // 11: ezft.daemon.v1.SyncReport.verification:type_name -> ezft.daemon.v1.SyncVerification
+	4,  // 12: ezft.daemon.v1.Job.spec:type_name -> ezft.daemon.v1.JobSpec
+	1,  // 13: ezft.daemon.v1.Job.state:type_name -> ezft.daemon.v1.State
+	12, // 14: ezft.daemon.v1.Job.created:type_name -> google.protobuf.Timestamp
+	12, // 15: ezft.daemon.v1.Job.started:type_name -> google.protobuf.Timestamp
+	12, // 16: ezft.daemon.v1.Job.finished:type_name -> google.protobuf.Timestamp
+	10, // 17: ezft.daemon.v1.Job.report:type_name -> ezft.daemon.v1.SyncReport
+	12, // 18: ezft.daemon.v1.Job.next:type_name -> google.protobuf.Timestamp
+	12, // 19: ezft.daemon.v1.Job.retry_at:type_name -> google.protobuf.Timestamp
+	5,  // 20: ezft.daemon.v1.Job.attempts:type_name -> ezft.daemon.v1.Attempt
+	4,  // 21: ezft.daemon.v1.Jobs.SubmitJob:input_type -> ezft.daemon.v1.JobSpec
+	2,  // 22: ezft.daemon.v1.Jobs.CancelJob:input_type -> ezft.daemon.v1.JobRequest
+	2,  // 23: ezft.daemon.v1.Jobs.WatchProgress:input_type -> ezft.daemon.v1.JobRequest
+	11, // 24: ezft.daemon.v1.Jobs.SubmitJob:output_type -> ezft.daemon.v1.Job
+	11, // 25: ezft.daemon.v1.Jobs.CancelJob:output_type -> ezft.daemon.v1.Job
+	11, // 26: ezft.daemon.v1.Jobs.WatchProgress:output_type -> ezft.daemon.v1.Job
+	24, // [24:27] is the sub-list for method output_type
+	21, // [21:24] is the sub-list for method input_type
+	21, // [21:21] is the sub-list for extension type_name
+	21, // [21:21] is the sub-list for extension extendee
+	0,  // [0:21] is the sub-list for field type_name
+}
+
+func init() { file_jobs_proto_init() }
+func file_jobs_proto_init() {
+	if File_jobs_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_jobs_proto_rawDesc), len(file_jobs_proto_rawDesc)),
+			NumEnums:      2,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_jobs_proto_goTypes,
+		DependencyIndexes: file_jobs_proto_depIdxs,
+		EnumInfos:         file_jobs_proto_enumTypes,
+		MessageInfos:      file_jobs_proto_msgTypes,
+	}.Build()
+	File_jobs_proto = out.File
+	file_jobs_proto_goTypes = nil
+	file_jobs_proto_depIdxs = nil
+}
@@ -0,0 +1,154 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+
+	"github.com/easzlab/ezft/pkg/daemon/daemonpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCService full name of the daemon gRPC service, defined with its protocol
+// buffer messages in daemonpb/jobs.proto
+const GRPCService = "ezft.daemon.v1.Jobs"
+
+// grpcServer serves the job API of a manager over gRPC on behalf of the token
+// of each call
+type grpcServer struct {
+	daemonpb.UnimplementedJobsServer
+	m *Manager
+}
+
+// NewGRPCServer returns a gRPC server serving the job API of m, requiring an
+// API token in the authorization metadata if any is configured
+func NewGRPCServer(m *Manager) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(m.unaryAuth),
+		grpc.ChainStreamInterceptor(m.streamAuth),
+	)
+	daemonpb.RegisterJobsServer(s, &grpcServer{m: m})
+	return s
+}
+
+func (s *grpcServer) SubmitJob(ctx context.Context, req *daemonpb.JobSpec) (*daemonpb.Job, error) {
+	job, err := s.m.submitRemote(specFromProto(req), tokenFrom(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return jobToProto(job), nil
+}
+
+func (s *grpcServer) CancelJob(ctx context.Context, req *daemonpb.JobRequest) (*daemonpb.Job, error) {
+	if _, err := s.m.authorize(tokenFrom(ctx), req.GetId()); err != nil {
+		return nil, grpcError(err)
+	}
+	job, err := s.m.Cancel(req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return jobToProto(job), nil
+}
+
+func (s *grpcServer) WatchProgress(req *daemonpb.JobRequest, stream daemonpb.Jobs_WatchProgressServer) error {
+	if _, err := s.m.authorize(tokenFrom(stream.Context()), req.GetId()); err != nil {
+		return grpcError(err)
+	}
+	jobs, err := s.m.Watch(stream.Context(), req.GetId())
+	if err != nil {
+		return grpcError(err)
+	}
+	for job := range jobs {
+		if err := stream.Send(jobToProto(job)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grpcError maps manager errors to gRPC status codes
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, ErrInvalidSpec):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrJobNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ErrJobFinished), errors.Is(err, ErrJobNotFinished):
+		code = codes.FailedPrecondition
+	case errors.Is(err, ErrUnauthorized):
+		code = codes.Unauthenticated
+	case errors.Is(err, ErrForbidden):
+		code = codes.PermissionDenied
+	case errors.Is(err, errClosed):
+		code = codes.Unavailable
+	}
+	return status.Error(code, err.Error())
+}
+
+// GRPCClient client of the daemon gRPC service
+type GRPCClient struct {
+	jobs  daemonpb.JobsClient
+	token string
+}
+
+// NewGRPCClient creates a client calling the daemon over conn
+func NewGRPCClient(conn grpc.ClientConnInterface) *GRPCClient {
+	return &GRPCClient{jobs: daemonpb.NewJobsClient(conn)}
+}
+
+// SetToken sets the API token sent with every call
+func (c *GRPCClient) SetToken(token string) {
+	c.token = token
+}
+
+// outgoing adds the API token to the metadata of a call
+func (c *GRPCClient) outgoing(ctx context.Context) context.Context {
+	if c.token == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.token)
+}
+
+// SubmitJob queues a job
+func (c *GRPCClient) SubmitJob(ctx context.Context, spec JobSpec) (Job, error) {
+	job, err := c.jobs.SubmitJob(c.outgoing(ctx), specToProto(spec))
+	if err != nil {
+		return Job{}, err
+	}
+	return jobFromProto(job), nil
+}
+
+// CancelJob stops a running job or removes a queued one from the queue
+func (c *GRPCClient) CancelJob(ctx context.Context, id string) (Job, error) {
+	job, err := c.jobs.CancelJob(c.outgoing(ctx), &daemonpb.JobRequest{Id: id})
+	if err != nil {
+		return Job{}, err
+	}
+	return jobFromProto(job), nil
+}
+
+// WatchProgress streams snapshots of a job until it finished
+func (c *GRPCClient) WatchProgress(ctx context.Context, id string) (*ProgressStream, error) {
+	stream, err := c.jobs.WatchProgress(c.outgoing(ctx), &daemonpb.JobRequest{Id: id})
+	if err != nil {
+		return nil, err
+	}
+	return &ProgressStream{stream: stream}, nil
+}
+
+// ProgressStream job snapshots received from WatchProgress
+type ProgressStream struct {
+	stream daemonpb.Jobs_WatchProgressClient
+}
+
+// Recv returns the next snapshot, io.EOF after the job finished
+func (s *ProgressStream) Recv() (Job, error) {
+	job, err := s.stream.Recv()
+	if err != nil {
+		return Job{}, err
+	}
+	return jobFromProto(job), nil
+}
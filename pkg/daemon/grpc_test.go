@@ -0,0 +1,103 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/hook"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPC(t *testing.T) {
+	url, root := newFileServer(t)
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("aaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestManager(t, 1)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewGRPCServer(m)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := NewGRPCClient(conn)
+	ctx := context.Background()
+
+	job, err := c.SubmitJob(ctx, JobSpec{Type: JobDownload, URL: url + "/a.txt", Path: filepath.Join(t.TempDir(), "a.txt")})
+	if err != nil {
+		t.Fatalf("SubmitJob() error = %v", err)
+	}
+	stream, err := c.WatchProgress(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("WatchProgress() error = %v", err)
+	}
+	var last Job
+	for {
+		snapshot, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv() error = %v", err)
+		}
+		last = snapshot
+	}
+	if last.ID != job.ID || last.State != StateSucceeded || last.Bytes == 0 {
+		t.Errorf("Expected the last snapshot of a succeeded job, got %+v", last)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"invalid spec", func() error {
+			_, err := c.SubmitJob(ctx, JobSpec{Type: JobDownload, URL: url + "/a.txt", Path: "a.txt"})
+			return err
+		}, codes.InvalidArgument},
+		{"command hook", func() error {
+			_, err := c.SubmitJob(ctx, JobSpec{Type: JobDownload, URL: url + "/a.txt", Path: filepath.Join(t.TempDir(), "a.txt"), Hooks: hook.Hooks{OnFailure: "true"}})
+			return err
+		}, codes.InvalidArgument},
+		{"cancel unknown job", func() error {
+			_, err := c.CancelJob(ctx, "unknown")
+			return err
+		}, codes.NotFound},
+		{"cancel finished job", func() error {
+			_, err := c.CancelJob(ctx, job.ID)
+			return err
+		}, codes.FailedPrecondition},
+		{"watch unknown job", func() error {
+			stream, err := c.WatchProgress(ctx, "unknown")
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}, codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := status.Code(tt.call()); code != tt.code {
+				t.Errorf("Expected code %s, got %s", tt.code, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/cron"
+	"github.com/easzlab/ezft/pkg/hook"
+	"github.com/easzlab/ezft/pkg/syncer"
+)
+
+// JobType kind of transfer run by a job
+type JobType string
+
+const (
+	JobDownload JobType = "download" // Download URL into the local file Path
+	JobUpload   JobType = "upload"   // Upload the local file Path to URL
+	JobSync     JobType = "sync"     // Sync the local directory Path with the remote directory URL
+)
+
+// State lifecycle state of a job
+type State string
+
+const (
+	StateQueued    State = "queued"    // Waiting for a free slot
+	StateRunning   State = "running"   // Transferring
+	StateSucceeded State = "succeeded" // Finished successfully
+	StateFailed    State = "failed"    // Stopped by an error
+	StateCanceled  State = "canceled"  // Canceled on request
+	StateScheduled State = "scheduled" // Recurring job waiting for its next run
+	StateRetrying  State = "retrying"  // Failed job waiting for its next automatic retry
+)
+
+// Overlap what a recurring job does when a run is due while the previous one
+// is still queued or running
+type Overlap string
+
+const (
+	OverlapSkip  Overlap = "skip"          // The due run is skipped
+	OverlapQueue Overlap = "queue"         // The due run starts once the previous one finished
+	OverlapKill  Overlap = "kill-previous" // The previous run is canceled
+)
+
+// DefaultBackoff delay before the first automatic retry of a failed job, the
+// delay doubles with every further retry
+const DefaultBackoff = 30 * time.Second
+
+// maxBackoff upper bound of the delay between automatic retries
+const maxBackoff = time.Hour
+
+// Finished reports whether the state is final
+func (s State) Finished() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCanceled
+}
+
+var (
+	// ErrInvalidSpec is returned when a submitted job specification is rejected
+	ErrInvalidSpec = errors.New("invalid job")
+	// ErrJobNotFound is returned for unknown job IDs
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobFinished is returned when canceling a job that already finished
+	ErrJobFinished = errors.New("job already finished")
+	// ErrJobNotFinished is returned when retrying a job that is queued or running
+	ErrJobNotFinished = errors.New("job not finished yet")
+)
+
+// JobSpec what a job transfers
+type JobSpec struct {
+	Type        JobType    `json:"type"`
+	Profile     string     `json:"profile,omitempty"`     // Profile of the daemon configuration providing unset fields
+	URL         string     `json:"url"`                   // Remote file or directory URL
+	Path        string     `json:"path"`                  // Absolute local file or directory path
+	Direction   string     `json:"direction,omitempty"`   // Sync direction: pull (default), push or bidirectional
+	Conflict    string     `json:"conflict,omitempty"`    // Conflict strategy of bidirectional sync
+	Include     []string   `json:"include,omitempty"`     // Sync filter patterns of files to include
+	Exclude     []string   `json:"exclude,omitempty"`     // Sync filter patterns of files to skip
+	Delete      bool       `json:"delete,omitempty"`      // Delete sync destination files missing from the source
+	Delta       bool       `json:"delta,omitempty"`       // Update an existing download by delta transfer
+	Concurrency int        `json:"concurrency,omitempty"` // Chunk concurrency per file, 1 if unset
+	Username    string     `json:"username,omitempty"`    // Basic auth username
+	Password    string     `json:"password,omitempty"`    // Basic auth password, never returned by the API
+	Priority    int        `json:"priority,omitempty"`    // Jobs with a higher priority start first, 0 if unset
+	Group       string     `json:"group,omitempty"`       // Group sharing running slots with other groups under fair-share scheduling
+	Weight      int        `json:"weight,omitempty"`      // Part of the daemon bandwidth limit relative to other running jobs, 1 if unset
+	Class       string     `json:"class,omitempty"`       // Bandwidth class of the daemon configuration setting the weight and limit
+	Cron        string     `json:"cron,omitempty"`        // Cron expression running the job repeatedly, e.g. "0 3 * * *"
+	Overlap     Overlap    `json:"overlap,omitempty"`     // Overlap policy of recurring runs, skip if unset
+	Jitter      string     `json:"jitter,omitempty"`      // Random delay of up to this duration added to every run, e.g. "5m"
+	Hooks       hook.Hooks `json:"hooks,omitzero"`        // Command or webhook run after the transfer
+	Notify      []string   `json:"notify,omitempty"`      // Notifiers of the daemon configuration told when the job finished
+	Retries     int        `json:"retries,omitempty"`     // Automatic retries of a failed job before it stays failed
+	Backoff     string     `json:"backoff,omitempty"`     // Delay before the first retry, doubled for every further one, 30s if unset
+}
+
+// validate checks the specification, returning an error wrapping ErrInvalidSpec
+func (s *JobSpec) validate() error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidSpec, fmt.Sprintf(format, args...))
+	}
+
+	switch s.Type {
+	case JobDownload, JobUpload, JobSync:
+	default:
+		return invalid("unknown type %q, must be one of: download, upload, sync", s.Type)
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil || u.Host == "" {
+		return invalid("invalid url %q", s.URL)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "ezft", "ezfts":
+		if s.Type != JobSync {
+			return invalid("%s:// URLs are only supported by sync jobs", u.Scheme)
+		}
+	default:
+		return invalid("unsupported url scheme %q", u.Scheme)
+	}
+
+	if !filepath.IsAbs(s.Path) {
+		return invalid("path must be absolute: %q", s.Path)
+	}
+	if s.Type == JobSync {
+		if _, err := s.direction(); err != nil {
+			return invalid("%v", err)
+		}
+		if s.Conflict != "" {
+			if _, err := syncer.ParseConflictStrategy(s.Conflict); err != nil {
+				return invalid("%v", err)
+			}
+		}
+	}
+	if s.Concurrency < 0 {
+		return invalid("concurrency must not be negative")
+	}
+	if s.Weight < 0 {
+		return invalid("weight must not be negative")
+	}
+
+	if s.Cron != "" {
+		if _, err := cron.Parse(s.Cron); err != nil {
+			return invalid("%v", err)
+		}
+	} else if s.Overlap != "" || s.Jitter != "" {
+		return invalid("overlap and jitter require a cron expression")
+	}
+	switch s.Overlap {
+	case "", OverlapSkip, OverlapQueue, OverlapKill:
+	default:
+		return invalid("invalid overlap policy %q, must be one of: skip, queue, kill-previous", s.Overlap)
+	}
+	if _, err := s.jitter(); err != nil {
+		return invalid("%v", err)
+	}
+	if s.Retries < 0 {
+		return invalid("retries must not be negative")
+	}
+	if s.Backoff != "" {
+		if s.Retries == 0 {
+			return invalid("backoff requires retries")
+		}
+		if d, err := time.ParseDuration(s.Backoff); err != nil || d <= 0 {
+			return invalid("invalid backoff %q", s.Backoff)
+		}
+	}
+	if err := s.Hooks.Validate(); err != nil {
+		return invalid("%v", err)
+	}
+	return nil
+}
+
+// jitter returns the maximum random delay of recurring runs
+func (s *JobSpec) jitter() (time.Duration, error) {
+	if s.Jitter == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s.Jitter)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid jitter %q", s.Jitter)
+	}
+	return d, nil
+}
+
+// backoff returns the delay before the given automatic retry, counted from 1
+func (s *JobSpec) backoff(retry int) time.Duration {
+	d := DefaultBackoff
+	if s.Backoff != "" {
+		// Validated on submission
+		d, _ = time.ParseDuration(s.Backoff)
+	}
+	for i := 1; i < retry && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// direction returns the sync direction, pull unless given
+func (s *JobSpec) direction() (syncer.Direction, error) {
+	switch s.Direction {
+	case "", "pull":
+		return syncer.Pull, nil
+	case "push":
+		return syncer.Push, nil
+	case "bidirectional":
+		return syncer.Bidirectional, nil
+	default:
+		return 0, fmt.Errorf("invalid direction %q, must be one of: pull, push, bidirectional", s.Direction)
+	}
+}
+
+// Job a transfer submitted to the daemon
+type Job struct {
+	ID        string         `json:"id"`
+	Spec      JobSpec        `json:"spec"`
+	State     State          `json:"state"`
+	Owner     string         `json:"owner,omitempty"` // Name of the API token that submitted the job
+	Error     string         `json:"error,omitempty"` // Why the job failed
+	Created   time.Time      `json:"created"`
+	Started   time.Time      `json:"started,omitzero"`
+	Finished  time.Time      `json:"finished,omitzero"`
+	Bytes     int64          `json:"bytes"`                // Bytes sent and received so far
+	Total     int64          `json:"total,omitempty"`      // Bytes to transfer, if known in advance
+	Report    *syncer.Report `json:"report,omitempty"`     // Result of a sync job
+	Schedule  string         `json:"schedule,omitempty"`   // ID of the recurring job that started this run
+	Next      time.Time      `json:"next,omitzero"`        // Next run of a recurring job
+	HookError string         `json:"hook_error,omitempty"` // Why the hooks of the job failed
+	Retries   int            `json:"retries,omitempty"`    // Automatic retries since submission or the last manual retry
+	RetryAt   time.Time      `json:"retry_at,omitzero"`    // Next automatic retry of a failed job
+	Attempts  []Attempt      `json:"attempts,omitempty"`   // Failed attempts, oldest first
+}
+
+// Attempt a failed run of a job
+type Attempt struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Error    string    `json:"error"`
+}
+
+// newJobID returns a random job ID
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,461 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/hook"
+	"github.com/easzlab/ezft/pkg/notify"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/utils/logger"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// DefaultMaxJobs default number of jobs running at the same time
+const DefaultMaxJobs = 2
+
+// errClosed is returned when submitting jobs to a closed manager
+var errClosed = errors.New("daemon is shutting down")
+
+// job a job with its runtime state, the embedded Job is guarded by the manager mutex
+type job struct {
+	Job
+	bytes        atomic.Int64       // Bytes transferred, updated while running
+	cancel       context.CancelFunc // Stops the running job
+	canceled     bool               // Cancel was requested
+	limiter      *ratelimit.Limiter // Share of the daemon bandwidth limit while running
+	classLimiter *ratelimit.Limiter // Bandwidth limit of the class of the job while running
+
+	stopRecurring context.CancelFunc // Stops the runs of a recurring job
+	pending       bool               // A run of a recurring job waits for the previous one
+	stopRetry     context.CancelFunc // Stops the pending automatic retry of a failed job
+}
+
+// snapshot returns a copy of the job safe to hand out, without credentials
+func (j *job) snapshot() Job {
+	s := j.Job
+	s.Bytes = j.bytes.Load()
+	s.Spec.Password = ""
+	return s
+}
+
+// Manager queues jobs and runs up to a maximum of them at the same time in the
+// order given by its scheduling policy
+type Manager struct {
+	mu        sync.Mutex
+	jobs      map[string]*job
+	order     []*job // All jobs in submission order
+	queue     []*job // Queued jobs in submission order
+	running   int
+	groups    map[string]int // Running jobs per group
+	maxJobs   int
+	policy    Policy
+	limiter   *ratelimit.Limiter
+	profiles  Profiles
+	notifiers map[string]*notify.Notifier
+	tokens    map[string]*Token // API tokens by name, the APIs are open without
+	classes   map[string]*Class // Bandwidth classes by name
+	logger    *zap.Logger
+	jobLogDir string // Directory of the log files of the jobs, empty for none
+	jobLogFmt string // Format of the log files of the jobs
+
+	historySize   int           // Finished jobs kept, 0 for all
+	historyAge    time.Duration // How long finished jobs are kept, 0 for ever
+	finishedTotal map[State]int // Jobs finished since start by state
+	bytesTotal    int64         // Bytes transferred by runs finished since start
+
+	db     *bolt.DB          // Database persisting all jobs, set by LoadState
+	saved  map[string][]byte // Jobs as last saved to db, owned by the goroutine saving them
+	dirty  chan struct{}     // Wakes up the goroutine saving the jobs
+	notify chan struct{}     // Closed and replaced whenever a job changes
+
+	ctx  context.Context // Parent of all job contexts, canceled by Close
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// NewManager creates a job manager running up to maxJobs jobs at the same time
+func NewManager(maxJobs int) *Manager {
+	if maxJobs < 1 {
+		maxJobs = DefaultMaxJobs
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	return &Manager{
+		jobs:          map[string]*job{},
+		groups:        map[string]int{},
+		finishedTotal: map[State]int{},
+		maxJobs:       maxJobs,
+		policy:        PolicyFIFO,
+		notify:        make(chan struct{}),
+		dirty:         make(chan struct{}, 1),
+		logger:        zap.NewNop(),
+		ctx:           ctx,
+		stop:          stop,
+	}
+}
+
+func (m *Manager) SetLogger(logger *zap.Logger) {
+	m.logger = logger
+}
+
+// SetJobLogs also logs every job to its own file in dir named by the job ID,
+// in format, runs of the job appending to it
+func (m *Manager) SetJobLogs(dir, format string) {
+	m.jobLogDir = dir
+	m.jobLogFmt = format
+}
+
+// jobLogger returns the logger of a run of j, also writing to the log file of
+// the job if any, and a function closing that file
+func (m *Manager) jobLogger(j *job) (*zap.Logger, func() error) {
+	l := m.logger
+	closeLog := func() error { return nil }
+	if m.jobLogDir != "" {
+		tee, closeFile, err := logger.Tee(l, filepath.Join(m.jobLogDir, j.ID+".log"), m.jobLogFmt)
+		if err != nil {
+			l.Warn("failed to open job log",
+				zap.String("job", j.ID),
+				zap.Error(err),
+			)
+		} else {
+			l, closeLog = tee, closeFile
+		}
+	}
+	return l.With(zap.String("job", j.ID)), closeLog
+}
+
+// SetPolicy sets the scheduling policy, FIFO by default
+func (m *Manager) SetPolicy(policy Policy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.policy = policy
+}
+
+// SetRateLimiter limits the bandwidth of all jobs together by limiter, running
+// jobs split it by their weight
+func (m *Manager) SetRateLimiter(limiter *ratelimit.Limiter) {
+	m.limiter = limiter
+}
+
+// Submit validates and queues a job, jobs with a cron expression are
+// scheduled to run repeatedly instead. Fields left unset are taken from the
+// profile of the job, if any.
+func (m *Manager) Submit(spec JobSpec) (Job, error) {
+	return m.submit(spec, nil)
+}
+
+// submitRemote queues a job submitted through the REST or gRPC API by tok.
+// Command hooks run shell commands on the daemon host, so they are only taken
+// from the profiles of the daemon configuration.
+func (m *Manager) submitRemote(spec JobSpec, tok *Token) (Job, error) {
+	if spec.Hooks.HasCommand() {
+		return Job{}, fmt.Errorf("%w: command hooks are only accepted from profiles", ErrInvalidSpec)
+	}
+	return m.submit(spec, tok)
+}
+
+// submit queues a job owned by tok, which must allow it
+func (m *Manager) submit(spec JobSpec, tok *Token) (Job, error) {
+	m.mu.Lock()
+	spec, err := m.profiles.apply(spec)
+	if err == nil {
+		err = m.checkNotifiers(spec.Notify)
+	}
+	if err == nil {
+		spec, err = m.applyClass(spec, tok)
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return Job{}, err
+	}
+	if err := spec.validate(); err != nil {
+		return Job{}, err
+	}
+	if err := tok.allows(&spec); err != nil {
+		return Job{}, err
+	}
+	owner := ""
+	if tok != nil {
+		owner = tok.Name
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx.Err() != nil {
+		return Job{}, errClosed
+	}
+
+	j := &job{Job: Job{
+		ID:      newJobID(),
+		Spec:    spec,
+		State:   StateQueued,
+		Owner:   owner,
+		Created: time.Now(),
+	}}
+	m.jobs[j.ID] = j
+	m.order = append(m.order, j)
+	if spec.Cron != "" {
+		m.startRecurring(j)
+	} else {
+		m.queue = append(m.queue, j)
+	}
+
+	m.logger.Info("job submitted",
+		zap.String("job", j.ID),
+		zap.String("type", string(spec.Type)),
+		zap.String("url", spec.URL),
+		zap.String("path", spec.Path),
+		zap.Int("priority", spec.Priority),
+		zap.String("cron", spec.Cron),
+		zap.String("profile", spec.Profile),
+		zap.String("owner", owner),
+	)
+	m.schedule()
+	m.changed()
+	return j.snapshot(), nil
+}
+
+// List returns all jobs in submission order
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]Job, len(m.order))
+	for i, j := range m.order {
+		jobs[i] = j.snapshot()
+	}
+	return jobs
+}
+
+// Get returns the job with the given ID
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return j.snapshot(), nil
+}
+
+// Cancel stops a running job, removes a queued one from the queue or stops
+// the future runs of a recurring one
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	if err := m.cancel(j); err != nil {
+		return Job{}, err
+	}
+	m.changed()
+	return j.snapshot(), nil
+}
+
+// cancel cancels a job, m.mu must be held
+func (m *Manager) cancel(j *job) error {
+	switch j.State {
+	case StateQueued:
+		for i, queued := range m.queue {
+			if queued == j {
+				m.queue = append(m.queue[:i], m.queue[i+1:]...)
+				break
+			}
+		}
+		j.State = StateCanceled
+		j.Finished = time.Now()
+		m.jobFinished(j)
+	case StateRunning:
+		// The job turns canceled once it stopped
+		j.canceled = true
+		j.cancel()
+	case StateScheduled:
+		j.stopRecurring()
+		j.State = StateCanceled
+		j.Finished = time.Now()
+		j.Next = time.Time{}
+		j.pending = false
+		m.jobFinished(j)
+	case StateRetrying:
+		j.stopRetry()
+		j.State = StateCanceled
+		j.Finished = time.Now()
+		j.RetryAt = time.Time{}
+		m.jobFinished(j)
+	default:
+		return ErrJobFinished
+	}
+
+	m.logger.Info("job canceled",
+		zap.String("job", j.ID),
+	)
+	return nil
+}
+
+// Retry queues a finished job again, keeping its ID and its failed attempts
+// with a fresh budget of automatic retries, a canceled recurring job is
+// scheduled again
+func (m *Manager) Retry(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx.Err() != nil {
+		return Job{}, errClosed
+	}
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	if !j.State.Finished() {
+		return Job{}, ErrJobNotFinished
+	}
+	j.State = StateQueued
+	j.Error, j.HookError = "", ""
+	j.Started, j.Finished = time.Time{}, time.Time{}
+	j.Total = 0
+	j.Report = nil
+	j.Retries = 0
+	j.canceled = false
+	j.bytes.Store(0)
+	if j.Spec.Cron != "" {
+		m.startRecurring(j)
+	} else {
+		m.queue = append(m.queue, j)
+	}
+
+	m.logger.Info("job retried",
+		zap.String("job", j.ID),
+	)
+	m.schedule()
+	m.changed()
+	return j.snapshot(), nil
+}
+
+// Close stops all running jobs and waits for them to return, the stopped jobs
+// are queued again to resume from the state database
+func (m *Manager) Close() {
+	m.stop()
+	m.wg.Wait()
+	m.closeState()
+}
+
+// schedule starts queued jobs while slots are free, m.mu must be held
+func (m *Manager) schedule() {
+	for m.running < m.maxJobs && len(m.queue) > 0 && m.ctx.Err() == nil {
+		i := m.next()
+		j := m.queue[i]
+		m.queue = append(m.queue[:i], m.queue[i+1:]...)
+		m.start(j)
+	}
+}
+
+// start runs a job in the background, m.mu must be held
+func (m *Manager) start(j *job) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	j.cancel = cancel
+	j.State = StateRunning
+	j.Started = time.Now()
+	j.Error = ""
+	if m.limiter != nil {
+		j.limiter = m.limiter.Share(j.Spec.Weight)
+	}
+	j.classLimiter = nil
+	if class := m.classes[j.Spec.Class]; class != nil && class.schedule != nil {
+		j.classLimiter = ratelimit.NewLimiter(class.schedule)
+	}
+	m.running++
+	m.groups[j.Spec.Group]++
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+
+		logger, closeLog := m.jobLogger(j)
+		defer closeLog()
+		logger.Info("job started",
+			zap.String("type", string(j.Spec.Type)),
+			zap.String("url", j.Spec.URL),
+			zap.String("path", j.Spec.Path),
+		)
+		err := m.execute(ctx, j, logger)
+		j.limiter.Close()
+
+		m.mu.Lock()
+		canceled := j.canceled
+		retry := err != nil && j.Retries < j.Spec.Retries
+		m.mu.Unlock()
+		// Failure hooks only run once the job failed for good
+		var event *hook.Event
+		var hookErr error
+		if !canceled && !retry && m.ctx.Err() == nil {
+			if event = jobEvent(j, err); event != nil {
+				hookErr = j.Spec.Hooks.Run(m.ctx, event)
+			}
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		j.Finished = time.Now()
+		if hookErr != nil {
+			j.HookError = hookErr.Error()
+			logger.Info("job hooks failed",
+				zap.Error(hookErr),
+			)
+		}
+		switch {
+		case j.canceled:
+			j.State = StateCanceled
+		case m.ctx.Err() != nil:
+			// Interrupted by Close
+			j.State = StateQueued
+		case err != nil:
+			j.Error = err.Error()
+			j.Attempts = append(j.Attempts, Attempt{Started: j.Started, Finished: j.Finished, Error: j.Error})
+			j.State = StateFailed
+			if retry {
+				j.Retries++
+				j.RetryAt = j.Finished.Add(j.Spec.backoff(j.Retries))
+				j.State = StateRetrying
+			}
+		default:
+			j.State = StateSucceeded
+		}
+		m.running--
+		m.bytesTotal += j.bytes.Load()
+		if m.groups[j.Spec.Group]--; m.groups[j.Spec.Group] == 0 {
+			delete(m.groups, j.Spec.Group)
+		}
+
+		logger.Info("job finished",
+			zap.String("state", string(j.State)),
+			zap.Int64("bytes", j.bytes.Load()),
+			zap.Duration("duration", j.Finished.Sub(j.Started)),
+			zap.Error(err),
+		)
+		if event != nil && (j.State == StateSucceeded || j.State == StateFailed) {
+			m.sendNotifications(j, event)
+		}
+		switch j.State {
+		case StateQueued:
+			j.Started, j.Finished = time.Time{}, time.Time{}
+		case StateRetrying:
+			j.Started, j.Finished = time.Time{}, time.Time{}
+			m.retryLater(j)
+		default:
+			m.jobFinished(j)
+		}
+		m.schedule()
+		m.changed()
+	}()
+}
@@ -0,0 +1,316 @@
+package daemon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/hook"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newFileServer serves a temporary directory with uploads enabled
+func newFileServer(t *testing.T) (string, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	srv := server.NewServer(root, 0)
+	srv.SetLogger(zap.NewNop())
+	srv.SetUploadEnabled(true)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts.URL, root
+}
+
+// blockingServer answers HEAD requests and holds GET requests until the client gives up
+func blockingServer(t *testing.T) string {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		if r.Method == "HEAD" {
+			return
+		}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func newTestManager(t *testing.T, maxJobs int) *Manager {
+	t.Helper()
+
+	m := NewManager(maxJobs)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// waitState waits until the job reaches state
+func waitState(t *testing.T, m *Manager, id string, state State) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := m.Get(id)
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", id, err)
+		}
+		if job.State == state {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job %s is %s, want %s (error: %s)", id, job.State, state, job.Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubmitInvalid(t *testing.T) {
+	m := newTestManager(t, 1)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name string
+		spec JobSpec
+	}{
+		{"unknown type", JobSpec{Type: "move", URL: "http://host/a", Path: dir}},
+		{"missing url", JobSpec{Type: JobDownload, Path: filepath.Join(dir, "a")}},
+		{"ftp url", JobSpec{Type: JobDownload, URL: "ftp://host/a", Path: filepath.Join(dir, "a")}},
+		{"ezft download", JobSpec{Type: JobDownload, URL: "ezft://host/a", Path: filepath.Join(dir, "a")}},
+		{"relative path", JobSpec{Type: JobDownload, URL: "http://host/a", Path: "a"}},
+		{"invalid direction", JobSpec{Type: JobSync, URL: "ezft://host/", Path: dir, Direction: "sideways"}},
+		{"invalid conflict", JobSpec{Type: JobSync, URL: "ezft://host/", Path: dir, Conflict: "coin-flip"}},
+		{"negative retries", JobSpec{Type: JobDownload, URL: "http://host/a", Path: filepath.Join(dir, "a"), Retries: -1}},
+		{"backoff without retries", JobSpec{Type: JobDownload, URL: "http://host/a", Path: filepath.Join(dir, "a"), Backoff: "1s"}},
+		{"invalid backoff", JobSpec{Type: JobDownload, URL: "http://host/a", Path: filepath.Join(dir, "a"), Retries: 1, Backoff: "soon"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := m.Submit(tt.spec); !errors.Is(err, ErrInvalidSpec) {
+				t.Errorf("Submit() error = %v, want ErrInvalidSpec", err)
+			}
+		})
+	}
+	if len(m.List()) != 0 {
+		t.Error("Rejected jobs must not be listed")
+	}
+}
+
+func TestJobs(t *testing.T) {
+	url, root := newFileServer(t)
+	local := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "remote.txt"), []byte("remote content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(local, "local.txt"), []byte("local content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestManager(t, 2)
+
+	download, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/remote.txt", Path: filepath.Join(local, "down", "remote.txt")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	upload, err := m.Submit(JobSpec{Type: JobUpload, URL: url + "/up/local.txt", Path: filepath.Join(local, "local.txt"), Password: "secret"})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if upload.Spec.Password != "" {
+		t.Error("Passwords must not be returned")
+	}
+
+	job := waitState(t, m, download.ID, StateSucceeded)
+	if job.Bytes == 0 || job.Started.IsZero() || job.Finished.IsZero() {
+		t.Errorf("Expected transferred bytes and timestamps, got %+v", job)
+	}
+	if content, _ := os.ReadFile(filepath.Join(local, "down", "remote.txt")); string(content) != "remote content" {
+		t.Errorf("Downloaded %q", content)
+	}
+	job = waitState(t, m, upload.ID, StateSucceeded)
+	if job.Total != int64(len("local content")) {
+		t.Errorf("Expected upload total %d, got %d", len("local content"), job.Total)
+	}
+	if content, _ := os.ReadFile(filepath.Join(root, "up", "local.txt")); string(content) != "local content" {
+		t.Errorf("Uploaded %q", content)
+	}
+
+	sync, err := m.Submit(JobSpec{Type: JobSync, URL: url + "/up/", Path: filepath.Join(local, "mirror")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	job = waitState(t, m, sync.ID, StateSucceeded)
+	if job.Report == nil || job.Report.Downloaded != 1 {
+		t.Errorf("Expected a sync report with 1 download, got %+v", job.Report)
+	}
+
+	failed, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/missing.txt", Path: filepath.Join(local, "missing.txt")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if job = waitState(t, m, failed.ID, StateFailed); job.Error == "" {
+		t.Error("Expected the error of a failed job")
+	}
+
+	if jobs := m.List(); len(jobs) != 4 || jobs[0].ID != download.ID {
+		t.Errorf("Expected 4 jobs in submission order, got %+v", jobs)
+	}
+}
+
+func TestJobLogs(t *testing.T) {
+	url, root := newFileServer(t)
+	if err := os.WriteFile(filepath.Join(root, "remote.txt"), []byte("remote content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	local := t.TempDir()
+	logs := t.TempDir()
+	m := newTestManager(t, 2)
+	m.SetLogger(zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(io.Discard), zap.InfoLevel)))
+	m.SetJobLogs(logs, "")
+
+	ok, _ := m.Submit(JobSpec{Type: JobDownload, URL: url + "/remote.txt", Path: filepath.Join(local, "remote.txt")})
+	failed, _ := m.Submit(JobSpec{Type: JobDownload, URL: url + "/missing.txt", Path: filepath.Join(local, "missing.txt")})
+	waitState(t, m, ok.ID, StateSucceeded)
+	waitState(t, m, failed.ID, StateFailed)
+
+	log, err := os.ReadFile(filepath.Join(logs, failed.ID+".log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(log), "job finished") || !strings.Contains(string(log), "missing.txt") {
+		t.Errorf("Log of the failed job:\n%s", log)
+	}
+	if strings.Contains(string(log), ok.ID) {
+		t.Error("Log of the failed job holds the other job")
+	}
+	if log, _ := os.ReadFile(filepath.Join(logs, ok.ID+".log")); !strings.Contains(string(log), `"state": "succeeded"`) {
+		t.Errorf("Log of the succeeded job:\n%s", log)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	url := blockingServer(t)
+	dir := t.TempDir()
+	m := newTestManager(t, 1)
+
+	running, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/a", Path: filepath.Join(dir, "a")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	queued, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/b", Path: filepath.Join(dir, "b")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitState(t, m, running.ID, StateRunning)
+	if job, _ := m.Get(queued.ID); job.State != StateQueued {
+		t.Fatalf("Expected the second job to wait for a free slot, got %s", job.State)
+	}
+
+	if job, err := m.Cancel(queued.ID); err != nil || job.State != StateCanceled {
+		t.Fatalf("Cancel() = %+v, %v", job, err)
+	}
+	if _, err := m.Cancel(running.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	waitState(t, m, running.ID, StateCanceled)
+
+	if _, err := m.Cancel(running.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("Cancel() of a finished job error = %v, want ErrJobFinished", err)
+	}
+	if _, err := m.Cancel("unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Cancel() of an unknown job error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestJobWeights(t *testing.T) {
+	url := blockingServer(t)
+	dir := t.TempDir()
+	m := newTestManager(t, 2)
+	limiter := ratelimit.NewLimiter(ratelimit.NewSchedule(1 << 20))
+	m.SetRateLimiter(limiter)
+
+	light, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/a", Path: filepath.Join(dir, "a")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	heavy, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/b", Path: filepath.Join(dir, "b"), Weight: 3})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitState(t, m, light.ID, StateRunning)
+	waitState(t, m, heavy.ID, StateRunning)
+
+	m.mu.Lock()
+	lightShare, heavyShare := m.jobs[light.ID].limiter, m.jobs[heavy.ID].limiter
+	m.mu.Unlock()
+	if lightShare.Rate() != 256<<10 || heavyShare.Rate() != 768<<10 {
+		t.Fatalf("Expected the jobs to split the limit 1:3, got %d and %d", lightShare.Rate(), heavyShare.Rate())
+	}
+
+	for _, id := range []string{light.ID, heavy.ID} {
+		if _, err := m.Cancel(id); err != nil {
+			t.Fatalf("Cancel() error = %v", err)
+		}
+		waitState(t, m, id, StateCanceled)
+	}
+}
+
+func TestJobHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands use sh")
+	}
+	url, root := newFileServer(t)
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("aaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "hook.out")
+	m := newTestManager(t, 1)
+
+	succeeded, err := m.Submit(JobSpec{
+		Type:  JobDownload,
+		URL:   url + "/a.txt",
+		Path:  filepath.Join(dir, "a.txt"),
+		Hooks: hook.Hooks{OnSuccess: "echo \"$EZFT_JOB $EZFT_CHECKSUM\" > " + out},
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitState(t, m, succeeded.ID, StateSucceeded)
+	sum := sha256.Sum256([]byte("aaa"))
+	if content, _ := os.ReadFile(out); strings.TrimSpace(string(content)) != succeeded.ID+" "+hex.EncodeToString(sum[:]) {
+		t.Errorf("Success hook saw %q", content)
+	}
+
+	failed, err := m.Submit(JobSpec{
+		Type:  JobDownload,
+		URL:   url + "/missing.txt",
+		Path:  filepath.Join(dir, "missing.txt"),
+		Hooks: hook.Hooks{OnFailure: "echo \"$EZFT_STATUS\" > " + out + "; exit 1"},
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	job := waitState(t, m, failed.ID, StateFailed)
+	if content, _ := os.ReadFile(out); strings.TrimSpace(string(content)) != "failure" {
+		t.Errorf("Failure hook saw %q", content)
+	}
+	if job.HookError == "" {
+		t.Error("Expected the error of the failed hook")
+	}
+
+	if _, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/a.txt", Path: filepath.Join(dir, "b.txt"), Hooks: hook.Hooks{Webhook: "ftp://host/"}}); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("Submit() with an invalid webhook error = %v, want ErrInvalidSpec", err)
+	}
+}
@@ -0,0 +1,94 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+// Profiles named job templates, each a partial job specification
+type Profiles map[string]JobSpec
+
+// parseProfiles converts the profiles of the configuration file, which use the
+// JSON field names of JobSpec
+func parseProfiles(raw map[string]any) (Profiles, error) {
+	profiles := Profiles{}
+	for name, v := range raw {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile %q: %w", name, err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		var spec JobSpec
+		if err := decoder.Decode(&spec); err != nil {
+			return nil, fmt.Errorf("invalid profile %q: %w", name, err)
+		}
+		if spec.Profile != "" {
+			return nil, fmt.Errorf("invalid profile %q: profiles cannot reference other profiles", name)
+		}
+		if spec.Path != "" && !filepath.IsAbs(spec.Path) {
+			return nil, fmt.Errorf("invalid profile %q: path must be absolute: %q", name, spec.Path)
+		}
+		profiles[name] = spec
+	}
+	return profiles, nil
+}
+
+// Names returns the profile names in alphabetical order
+func (p Profiles) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// apply fills the fields spec leaves unset from its profile. A relative path
+// is resolved against the path of the profile.
+func (p Profiles) apply(spec JobSpec) (JobSpec, error) {
+	if spec.Profile == "" {
+		return spec, nil
+	}
+	profile, ok := p[spec.Profile]
+	if !ok {
+		return spec, fmt.Errorf("%w: unknown profile %q", ErrInvalidSpec, spec.Profile)
+	}
+
+	path := spec.Path
+	merged := reflect.ValueOf(&spec).Elem()
+	defaults := reflect.ValueOf(profile)
+	for i := range merged.NumField() {
+		if field := merged.Field(i); field.IsZero() {
+			field.Set(defaults.Field(i))
+		}
+	}
+	if path != "" && !filepath.IsAbs(path) && profile.Path != "" {
+		spec.Path = filepath.Join(profile.Path, path)
+	}
+	return spec, nil
+}
+
+// SetProfiles sets the job profiles submitted jobs may reference
+func (m *Manager) SetProfiles(profiles Profiles) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.profiles = profiles
+}
+
+// Profiles returns the job profiles without credentials
+func (m *Manager) Profiles() Profiles {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	profiles := make(Profiles, len(m.profiles))
+	for name, spec := range m.profiles {
+		spec.Password = ""
+		profiles[name] = spec
+	}
+	return profiles
+}
@@ -0,0 +1,93 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ezft.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProfiles(t *testing.T) {
+	path := writeConfig(t, `
+profiles:
+  nightly-mirror:
+    type: sync
+    url: ezft://mirror/releases/
+    path: /data/mirror
+    exclude: ["*.tmp"]
+    concurrency: 4
+    weight: 2
+    password: secret
+    hooks:
+      on_failure: echo failed
+  iso:
+    type: download
+    retries: 3
+`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	profiles := config.Profiles
+	if names := profiles.Names(); len(names) != 2 || names[0] != "iso" || names[1] != "nightly-mirror" {
+		t.Fatalf("Names() = %v", names)
+	}
+	mirror := profiles["nightly-mirror"]
+	if mirror.Type != JobSync || mirror.Concurrency != 4 || mirror.Weight != 2 || len(mirror.Exclude) != 1 || mirror.Hooks.OnFailure != "echo failed" {
+		t.Errorf("Unexpected profile %+v", mirror)
+	}
+
+	for name, content := range map[string]string{
+		"unknown field":    "profiles:\n  a:\n    colour: red\n",
+		"relative path":    "profiles:\n  a:\n    path: data\n",
+		"nested profile":   "profiles:\n  a:\n    profile: b\n",
+		"invalid yaml":     "profiles: [",
+		"wrong field type": "profiles:\n  a:\n    concurrency: many\n",
+		"unknown notifier": "profiles:\n  a:\n    notify: [ops]\n",
+	} {
+		if _, err := LoadConfig(writeConfig(t, content)); err == nil {
+			t.Errorf("LoadConfig() of %s succeeded", name)
+		}
+	}
+}
+
+func TestSubmitProfile(t *testing.T) {
+	m := newTestManager(t, 1)
+	m.SetProfiles(Profiles{
+		"mirror": {Type: JobSync, URL: "ezft://mirror/releases/", Path: "/data/mirror", Concurrency: 4, Password: "secret", Cron: "0 3 * * *"},
+	})
+
+	job, err := m.Submit(JobSpec{Profile: "mirror", Path: "stable", Concurrency: 8})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if job.Spec.Type != JobSync || job.Spec.URL != "ezft://mirror/releases/" || job.Spec.Concurrency != 8 {
+		t.Errorf("Expected the profile to fill unset fields only, got %+v", job.Spec)
+	}
+	if job.Spec.Path != filepath.Join("/data/mirror", "stable") {
+		t.Errorf("Expected the path relative to the profile path, got %q", job.Spec.Path)
+	}
+	m.mu.Lock()
+	password := m.jobs[job.ID].Spec.Password
+	m.mu.Unlock()
+	if password != "secret" {
+		t.Error("Expected the job to use the credentials of the profile")
+	}
+
+	if _, err := m.Submit(JobSpec{Profile: "unknown"}); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("Submit() with an unknown profile error = %v, want ErrInvalidSpec", err)
+	}
+	if profiles := m.Profiles(); profiles["mirror"].Password != "" || profiles["mirror"].Concurrency != 4 {
+		t.Errorf("Profiles() = %+v, want the profile without credentials", profiles)
+	}
+}
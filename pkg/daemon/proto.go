@@ -0,0 +1,295 @@
+package daemon
+
+import (
+	"io/fs"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/daemon/daemonpb"
+	"github.com/easzlab/ezft/pkg/hook"
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/syncer"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Job types and states of the gRPC messages, the zero values of both
+// enumerations stand for an unset field
+var (
+	jobTypes = map[JobType]daemonpb.JobType{
+		JobDownload: daemonpb.JobType_JOB_TYPE_DOWNLOAD,
+		JobUpload:   daemonpb.JobType_JOB_TYPE_UPLOAD,
+		JobSync:     daemonpb.JobType_JOB_TYPE_SYNC,
+	}
+	states = map[State]daemonpb.State{
+		StateQueued:    daemonpb.State_STATE_QUEUED,
+		StateRunning:   daemonpb.State_STATE_RUNNING,
+		StateSucceeded: daemonpb.State_STATE_SUCCEEDED,
+		StateFailed:    daemonpb.State_STATE_FAILED,
+		StateCanceled:  daemonpb.State_STATE_CANCELED,
+		StateScheduled: daemonpb.State_STATE_SCHEDULED,
+		StateRetrying:  daemonpb.State_STATE_RETRYING,
+	}
+)
+
+// enumFromProto returns the key of values mapped to v, the zero value if none
+func enumFromProto[K comparable, V comparable](values map[K]V, v V) K {
+	for k, value := range values {
+		if value == v {
+			return k
+		}
+	}
+	var zero K
+	return zero
+}
+
+// timestampToProto returns the timestamp of t, nil for the zero time
+func timestampToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// timestampFromProto returns the time of ts, the zero time for nil
+func timestampFromProto(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func specToProto(s JobSpec) *daemonpb.JobSpec {
+	return &daemonpb.JobSpec{
+		Type:        jobTypes[s.Type],
+		Profile:     s.Profile,
+		Url:         s.URL,
+		Path:        s.Path,
+		Direction:   s.Direction,
+		Conflict:    s.Conflict,
+		Include:     s.Include,
+		Exclude:     s.Exclude,
+		Delete:      s.Delete,
+		Delta:       s.Delta,
+		Concurrency: int32(s.Concurrency),
+		Username:    s.Username,
+		Password:    s.Password,
+		Priority:    int32(s.Priority),
+		Group:       s.Group,
+		Weight:      int32(s.Weight),
+		Class:       s.Class,
+		Cron:        s.Cron,
+		Overlap:     string(s.Overlap),
+		Jitter:      s.Jitter,
+		Hooks: &daemonpb.Hooks{
+			OnSuccess: s.Hooks.OnSuccess,
+			OnFailure: s.Hooks.OnFailure,
+			Webhook:   s.Hooks.Webhook,
+		},
+		Notify:  s.Notify,
+		Retries: int32(s.Retries),
+		Backoff: s.Backoff,
+	}
+}
+
+func specFromProto(s *daemonpb.JobSpec) JobSpec {
+	return JobSpec{
+		Type:        enumFromProto(jobTypes, s.GetType()),
+		Profile:     s.GetProfile(),
+		URL:         s.GetUrl(),
+		Path:        s.GetPath(),
+		Direction:   s.GetDirection(),
+		Conflict:    s.GetConflict(),
+		Include:     s.GetInclude(),
+		Exclude:     s.GetExclude(),
+		Delete:      s.GetDelete(),
+		Delta:       s.GetDelta(),
+		Concurrency: int(s.GetConcurrency()),
+		Username:    s.GetUsername(),
+		Password:    s.GetPassword(),
+		Priority:    int(s.GetPriority()),
+		Group:       s.GetGroup(),
+		Weight:      int(s.GetWeight()),
+		Class:       s.GetClass(),
+		Cron:        s.GetCron(),
+		Overlap:     Overlap(s.GetOverlap()),
+		Jitter:      s.GetJitter(),
+		Hooks: hook.Hooks{
+			OnSuccess: s.GetHooks().GetOnSuccess(),
+			OnFailure: s.GetHooks().GetOnFailure(),
+			Webhook:   s.GetHooks().GetWebhook(),
+		},
+		Notify:  s.GetNotify(),
+		Retries: int(s.GetRetries()),
+		Backoff: s.GetBackoff(),
+	}
+}
+
+func jobToProto(j Job) *daemonpb.Job {
+	pb := &daemonpb.Job{
+		Id:        j.ID,
+		Spec:      specToProto(j.Spec),
+		State:     states[j.State],
+		Owner:     j.Owner,
+		Error:     j.Error,
+		Created:   timestampToProto(j.Created),
+		Started:   timestampToProto(j.Started),
+		Finished:  timestampToProto(j.Finished),
+		Bytes:     j.Bytes,
+		Total:     j.Total,
+		Report:    reportToProto(j.Report),
+		Schedule:  j.Schedule,
+		Next:      timestampToProto(j.Next),
+		HookError: j.HookError,
+		Retries:   int32(j.Retries),
+		RetryAt:   timestampToProto(j.RetryAt),
+	}
+	for _, a := range j.Attempts {
+		pb.Attempts = append(pb.Attempts, &daemonpb.Attempt{
+			Started:  timestampToProto(a.Started),
+			Finished: timestampToProto(a.Finished),
+			Error:    a.Error,
+		})
+	}
+	return pb
+}
+
+func jobFromProto(pb *daemonpb.Job) Job {
+	j := Job{
+		ID:        pb.GetId(),
+		Spec:      specFromProto(pb.GetSpec()),
+		State:     enumFromProto(states, pb.GetState()),
+		Owner:     pb.GetOwner(),
+		Error:     pb.GetError(),
+		Created:   timestampFromProto(pb.GetCreated()),
+		Started:   timestampFromProto(pb.GetStarted()),
+		Finished:  timestampFromProto(pb.GetFinished()),
+		Bytes:     pb.GetBytes(),
+		Total:     pb.GetTotal(),
+		Report:    reportFromProto(pb.GetReport()),
+		Schedule:  pb.GetSchedule(),
+		Next:      timestampFromProto(pb.GetNext()),
+		HookError: pb.GetHookError(),
+		Retries:   int(pb.GetRetries()),
+		RetryAt:   timestampFromProto(pb.GetRetryAt()),
+	}
+	for _, a := range pb.GetAttempts() {
+		j.Attempts = append(j.Attempts, Attempt{
+			Started:  timestampFromProto(a.GetStarted()),
+			Finished: timestampFromProto(a.GetFinished()),
+			Error:    a.GetError(),
+		})
+	}
+	return j
+}
+
+func reportToProto(r *syncer.Report) *daemonpb.SyncReport {
+	if r == nil {
+		return nil
+	}
+	pb := &daemonpb.SyncReport{
+		Downloaded: int32(r.Downloaded),
+		Uploaded:   int32(r.Uploaded),
+		Copied:     int32(r.Copied),
+		Deleted:    int32(r.Deleted),
+		Duration:   durationpb.New(r.Duration),
+		Skipped:    int32(r.Skipped),
+		Resumed:    int32(r.Resumed),
+		Bytes:      r.Bytes,
+	}
+	for _, c := range r.Conflicts {
+		pb.Conflicts = append(pb.Conflicts, &daemonpb.SyncConflict{
+			Path:       c.Path,
+			Local:      entryToProto(c.Local),
+			Remote:     entryToProto(c.Remote),
+			Resolution: c.Resolution,
+			Renamed:    c.Renamed,
+		})
+	}
+	if v := r.Verification; v != nil {
+		pb.Verification = &daemonpb.SyncVerification{
+			Remote:     v.Remote,
+			LocalDir:   v.LocalDir,
+			Time:       timestampToProto(v.Time),
+			Algorithm:  v.Algorithm,
+			Mismatches: int32(v.Mismatches),
+			Signature:  v.Signature,
+		}
+		for _, f := range v.Files {
+			pb.Verification.Files = append(pb.Verification.Files, &daemonpb.VerifiedFile{
+				Path:       f.Path,
+				LocalHash:  f.LocalHash,
+				RemoteHash: f.RemoteHash,
+				Match:      f.Match,
+				Error:      f.Error,
+			})
+		}
+	}
+	return pb
+}
+
+func reportFromProto(pb *daemonpb.SyncReport) *syncer.Report {
+	if pb == nil {
+		return nil
+	}
+	r := &syncer.Report{
+		Downloaded: int(pb.GetDownloaded()),
+		Uploaded:   int(pb.GetUploaded()),
+		Copied:     int(pb.GetCopied()),
+		Deleted:    int(pb.GetDeleted()),
+		Duration:   pb.GetDuration().AsDuration(),
+		Skipped:    int(pb.GetSkipped()),
+		Resumed:    int(pb.GetResumed()),
+		Bytes:      pb.GetBytes(),
+	}
+	for _, c := range pb.GetConflicts() {
+		r.Conflicts = append(r.Conflicts, syncer.Conflict{
+			Path:       c.GetPath(),
+			Local:      entryFromProto(c.GetLocal()),
+			Remote:     entryFromProto(c.GetRemote()),
+			Resolution: c.GetResolution(),
+			Renamed:    c.GetRenamed(),
+		})
+	}
+	if v := pb.GetVerification(); v != nil {
+		r.Verification = &syncer.Verification{
+			Remote:     v.GetRemote(),
+			LocalDir:   v.GetLocalDir(),
+			Time:       timestampFromProto(v.GetTime()),
+			Algorithm:  v.GetAlgorithm(),
+			Mismatches: int(v.GetMismatches()),
+			Signature:  v.GetSignature(),
+		}
+		for _, f := range v.GetFiles() {
+			r.Verification.Files = append(r.Verification.Files, syncer.VerifiedFile{
+				Path:       f.GetPath(),
+				LocalHash:  f.GetLocalHash(),
+				RemoteHash: f.GetRemoteHash(),
+				Match:      f.GetMatch(),
+				Error:      f.GetError(),
+			})
+		}
+	}
+	return r
+}
+
+func entryToProto(e manifest.Entry) *daemonpb.ManifestEntry {
+	return &daemonpb.ManifestEntry{
+		Path:  e.Path,
+		Size:  e.Size,
+		Mtime: timestampToProto(e.ModTime),
+		Mode:  uint32(e.Mode),
+		Link:  e.Link,
+		Dir:   e.Dir,
+	}
+}
+
+func entryFromProto(pb *daemonpb.ManifestEntry) manifest.Entry {
+	return manifest.Entry{
+		Path:    pb.GetPath(),
+		Size:    pb.GetSize(),
+		ModTime: timestampFromProto(pb.GetMtime()),
+		Mode:    fs.FileMode(pb.GetMode()),
+		Link:    pb.GetLink(),
+		Dir:     pb.GetDir(),
+	}
+}
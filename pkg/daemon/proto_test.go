@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/hook"
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/syncer"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestJobProto(t *testing.T) {
+	now := time.Date(2024, 5, 1, 3, 0, 0, 123, time.UTC)
+	tests := []struct {
+		name string
+		job  Job
+	}{
+		{"empty", Job{}},
+		{"download", Job{
+			ID: "1",
+			Spec: JobSpec{
+				Type: JobDownload, URL: "http://example.com/a", Path: "/tmp/a",
+				Delta: true, Concurrency: 4, Username: "user", Password: "secret",
+				Priority: -1, Group: "team",
+				Cron: "0 3 * * *", Overlap: OverlapKill, Jitter: "5m",
+				Hooks:   hook.Hooks{OnSuccess: "true", OnFailure: "false", Webhook: "http://example.com/hook"},
+				Retries: 3, Backoff: "1m",
+				Weight:  2,
+				Profile: "mirror",
+				Notify:  []string{"ops"},
+				Class:   "bulk",
+			},
+			State: StateRetrying, Error: "unexpected EOF",
+			Owner:   "team",
+			Created: now, Started: now.Add(time.Second), Finished: now.Add(time.Minute),
+			Bytes: 100, Total: 200,
+			Schedule: "0", Next: now.Add(time.Hour),
+			HookError: "exit status 1",
+			Retries:   1, RetryAt: now.Add(2 * time.Minute),
+			Attempts: []Attempt{{Started: now, Finished: now.Add(time.Second), Error: "unexpected EOF"}},
+		}},
+		{"sync", Job{
+			ID: "2",
+			Spec: JobSpec{
+				Type: JobSync, URL: "http://example.com/dir/", Path: "/tmp/dir",
+				Direction: "bidirectional", Conflict: "keep-both",
+				Include: []string{"*.txt"}, Exclude: []string{"tmp/"}, Delete: true,
+			},
+			State:   StateSucceeded,
+			Created: now,
+			Report: &syncer.Report{
+				Downloaded: 1, Uploaded: 2, Copied: 3, Deleted: 4, Duration: 1500 * time.Millisecond,
+				Skipped: 5, Resumed: 6, Bytes: 7,
+				Conflicts: []syncer.Conflict{{
+					Path:       "a.txt",
+					Local:      manifest.Entry{Path: "a.txt", Size: 1, ModTime: now, Mode: 0644},
+					Remote:     manifest.Entry{Path: "a.txt", Size: 2, ModTime: now, Link: "b.txt"},
+					Resolution: syncer.ResolutionKeptBoth,
+					Renamed:    "a.conflict.txt",
+				}},
+				Verification: &syncer.Verification{
+					Remote: "http://example.com/dir/", LocalDir: "/tmp/dir", Time: now, Algorithm: "sha256",
+					Files: []syncer.VerifiedFile{
+						{Path: "a.txt", LocalHash: "aa", RemoteHash: "aa", Match: true},
+						{Path: "b.txt", Error: "permission denied"},
+					},
+					Mismatches: 1, Signature: "ff",
+				},
+			},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := proto.Marshal(jobToProto(tt.job))
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			pb := jobToProto(Job{})
+			if err := proto.Unmarshal(data, pb); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if got := jobFromProto(pb); !reflect.DeepEqual(got, tt.job) {
+				t.Errorf("Expected %+v, got %+v", tt.job, got)
+			}
+		})
+	}
+}
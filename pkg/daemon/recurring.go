@@ -0,0 +1,129 @@
+package daemon
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/cron"
+	"go.uber.org/zap"
+)
+
+// startRecurring schedules the runs of a job with a cron expression until it
+// is canceled or the manager closed, m.mu must be held
+func (m *Manager) startRecurring(j *job) {
+	ctx, stop := context.WithCancel(m.ctx)
+	j.stopRecurring = stop
+	j.State = StateScheduled
+
+	// Both were validated on submission
+	schedule, _ := cron.Parse(j.Spec.Cron)
+	jitter, _ := j.Spec.jitter()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer stop()
+
+		for {
+			next := schedule.Next(time.Now())
+			if next.IsZero() {
+				return
+			}
+			if jitter > 0 {
+				next = next.Add(rand.N(jitter))
+			}
+
+			m.mu.Lock()
+			j.Next = next
+			m.changed()
+			m.mu.Unlock()
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			m.mu.Lock()
+			if ctx.Err() == nil {
+				m.due(j)
+			}
+			m.mu.Unlock()
+		}
+	}()
+}
+
+// due starts a run of the recurring job j according to its overlap policy,
+// m.mu must be held
+func (m *Manager) due(j *job) {
+	if active := m.activeRuns(j.ID); len(active) > 0 {
+		switch j.Spec.Overlap {
+		case OverlapQueue:
+			j.pending = true
+			m.logger.Info("recurring run waits for the previous one",
+				zap.String("job", j.ID),
+			)
+			return
+		case OverlapKill:
+			for _, run := range active {
+				m.cancel(run)
+			}
+		default:
+			m.logger.Info("recurring run skipped, the previous one is still active",
+				zap.String("job", j.ID),
+			)
+			return
+		}
+	}
+	m.submitRun(j)
+}
+
+// submitRun queues a run of the recurring job j, m.mu must be held
+func (m *Manager) submitRun(j *job) {
+	spec := j.Spec
+	spec.Cron, spec.Overlap, spec.Jitter = "", "", ""
+	run := &job{Job: Job{
+		ID:       newJobID(),
+		Spec:     spec,
+		State:    StateQueued,
+		Owner:    j.Owner,
+		Created:  time.Now(),
+		Schedule: j.ID,
+	}}
+	m.jobs[run.ID] = run
+	m.order = append(m.order, run)
+	m.queue = append(m.queue, run)
+
+	m.logger.Info("recurring run submitted",
+		zap.String("job", run.ID),
+		zap.String("schedule", j.ID),
+	)
+	m.schedule()
+	m.changed()
+}
+
+// runFinished starts the run of a recurring job that waited for the run j,
+// m.mu must be held
+func (m *Manager) runFinished(j *job) {
+	parent, ok := m.jobs[j.Schedule]
+	if j.Schedule == "" || !ok || !parent.pending || parent.State != StateScheduled || len(m.activeRuns(parent.ID)) > 0 {
+		return
+	}
+	parent.pending = false
+	m.submitRun(parent)
+}
+
+// activeRuns returns the queued, running and retrying runs of the recurring job
+// id, m.mu must be held
+func (m *Manager) activeRuns(id string) []*job {
+	var active []*job
+	for _, run := range m.order {
+		if run.Schedule == id && (run.State == StateQueued || run.State == StateRunning || run.State == StateRetrying) {
+			active = append(active, run)
+		}
+	}
+	return active
+}
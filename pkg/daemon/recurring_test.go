@@ -0,0 +1,127 @@
+package daemon
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fire makes the recurring job id due now
+func fire(m *Manager, id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.due(m.jobs[id])
+}
+
+// runs returns the runs of the recurring job id in submission order
+func runs(m *Manager, id string) []Job {
+	var runs []Job
+	for _, job := range m.List() {
+		if job.Schedule == id {
+			runs = append(runs, job)
+		}
+	}
+	return runs
+}
+
+func TestRecurringInvalid(t *testing.T) {
+	m := newTestManager(t, 1)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name string
+		spec JobSpec
+	}{
+		{"invalid cron", JobSpec{Cron: "every day"}},
+		{"invalid overlap", JobSpec{Cron: "@daily", Overlap: "wait"}},
+		{"invalid jitter", JobSpec{Cron: "@daily", Jitter: "soon"}},
+		{"negative jitter", JobSpec{Cron: "@daily", Jitter: "-5m"}},
+		{"jitter without cron", JobSpec{Jitter: "5m"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := tt.spec
+			spec.Type, spec.URL, spec.Path = JobSync, "ezft://host/", dir
+			if _, err := m.Submit(spec); !errors.Is(err, ErrInvalidSpec) {
+				t.Errorf("Submit() error = %v, want ErrInvalidSpec", err)
+			}
+		})
+	}
+}
+
+func TestRecurring(t *testing.T) {
+	url := blockingServer(t)
+	dir := t.TempDir()
+
+	tests := []struct {
+		overlap Overlap
+		check   func(t *testing.T, m *Manager, id string, first Job)
+	}{
+		{OverlapSkip, func(t *testing.T, m *Manager, id string, first Job) {
+			if r := runs(m, id); len(r) != 1 || r[0].State != StateRunning {
+				t.Errorf("Expected the due run to be skipped, got %+v", r)
+			}
+		}},
+		{OverlapQueue, func(t *testing.T, m *Manager, id string, first Job) {
+			if r := runs(m, id); len(r) != 1 {
+				t.Fatalf("Expected the due run to wait, got %+v", r)
+			}
+			m.Cancel(first.ID)
+			waitState(t, m, first.ID, StateCanceled)
+			if r := runs(m, id); len(r) != 2 || r[1].State.Finished() {
+				t.Errorf("Expected the waiting run to start after the previous one, got %+v", r)
+			}
+		}},
+		{OverlapKill, func(t *testing.T, m *Manager, id string, first Job) {
+			waitState(t, m, first.ID, StateCanceled)
+			if r := runs(m, id); len(r) != 2 || r[1].State.Finished() {
+				t.Errorf("Expected the previous run to be replaced, got %+v", r)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.overlap), func(t *testing.T) {
+			m := newTestManager(t, 2)
+			job, err := m.Submit(JobSpec{
+				Type:    JobDownload,
+				URL:     url + "/a",
+				Path:    filepath.Join(dir, string(tt.overlap)),
+				Cron:    "0 0 1 1 *",
+				Overlap: tt.overlap,
+				Jitter:  "1m",
+			})
+			if err != nil {
+				t.Fatalf("Submit() error = %v", err)
+			}
+			if job.State != StateScheduled {
+				t.Fatalf("Expected a scheduled job, got %s", job.State)
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for job, _ = m.Get(job.ID); job.Next.IsZero() && time.Now().Before(deadline); job, _ = m.Get(job.ID) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if job.Next.Month() != time.January || job.Next.Day() != 1 || job.Next.Hour() != 0 {
+				t.Errorf("Expected the next run on January 1st, got %v", job.Next)
+			}
+
+			fire(m, job.ID)
+			r := runs(m, job.ID)
+			if len(r) != 1 || r[0].Spec.Cron != "" {
+				t.Fatalf("Expected one run without the schedule, got %+v", r)
+			}
+			first := waitState(t, m, r[0].ID, StateRunning)
+			fire(m, job.ID)
+			tt.check(t, m, job.ID, first)
+
+			canceled, err := m.Cancel(job.ID)
+			if err != nil || canceled.State != StateCanceled || !canceled.Next.IsZero() {
+				t.Fatalf("Cancel() = %+v, %v", canceled, err)
+			}
+			retried, err := m.Retry(job.ID)
+			if err != nil || retried.State != StateScheduled {
+				t.Errorf("Expected a retried recurring job to be scheduled again, got %+v, %v", retried, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// retryLater queues the failed job j again at its RetryAt time unless it is
+// canceled or the manager closed before, m.mu must be held
+func (m *Manager) retryLater(j *job) {
+	ctx, stop := context.WithCancel(m.ctx)
+	j.stopRetry = stop
+	j.State = StateRetrying
+	delay := time.Until(j.RetryAt)
+
+	m.logger.Info("job retry scheduled",
+		zap.String("job", j.ID),
+		zap.Int("retry", j.Retries),
+		zap.Time("at", j.RetryAt),
+	)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer stop()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		j.State = StateQueued
+		j.RetryAt = time.Time{}
+		j.Total = 0
+		j.Report = nil
+		j.bytes.Store(0)
+		m.queue = append(m.queue, j)
+		m.schedule()
+		m.changed()
+	}()
+}
@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		backoff string
+		retry   int
+		want    time.Duration
+	}{
+		{"", 1, DefaultBackoff},
+		{"", 2, 2 * DefaultBackoff},
+		{"10s", 1, 10 * time.Second},
+		{"10s", 4, 80 * time.Second},
+		{"10m", 10, maxBackoff},
+	}
+	for _, tt := range tests {
+		spec := JobSpec{Backoff: tt.backoff}
+		if got := spec.backoff(tt.retry); got != tt.want {
+			t.Errorf("backoff(%q, %d) = %s, want %s", tt.backoff, tt.retry, got, tt.want)
+		}
+	}
+}
+
+func TestAutomaticRetry(t *testing.T) {
+	url, _ := newFileServer(t)
+	m := newTestManager(t, 1)
+
+	job, err := m.Submit(JobSpec{
+		Type:    JobDownload,
+		URL:     url + "/missing.txt",
+		Path:    filepath.Join(t.TempDir(), "missing.txt"),
+		Retries: 2,
+		Backoff: "10ms",
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	failed := waitState(t, m, job.ID, StateFailed)
+	if failed.Retries != 2 || len(failed.Attempts) != 3 {
+		t.Fatalf("Failed job has %d retries and %d attempts, want 2 and 3", failed.Retries, len(failed.Attempts))
+	}
+	for _, attempt := range failed.Attempts {
+		if attempt.Error == "" || attempt.Started.IsZero() || attempt.Finished.IsZero() {
+			t.Errorf("Incomplete attempt %+v", attempt)
+		}
+	}
+	if failed.Error != failed.Attempts[2].Error || !failed.RetryAt.IsZero() {
+		t.Errorf("Failed job %+v must report its last error without a retry time", failed)
+	}
+
+	// A manual retry keeps the history with a fresh retry budget
+	if _, err := m.Retry(job.ID); err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+	failed = waitState(t, m, job.ID, StateFailed)
+	if failed.Retries != 2 || len(failed.Attempts) != 6 {
+		t.Errorf("Retried job has %d retries and %d attempts, want 2 and 6", failed.Retries, len(failed.Attempts))
+	}
+}
+
+func TestCancelRetrying(t *testing.T) {
+	url, _ := newFileServer(t)
+	m := newTestManager(t, 1)
+
+	job, err := m.Submit(JobSpec{
+		Type:    JobDownload,
+		URL:     url + "/missing.txt",
+		Path:    filepath.Join(t.TempDir(), "missing.txt"),
+		Retries: 1,
+		Backoff: "1h",
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	retrying := waitState(t, m, job.ID, StateRetrying)
+	if retrying.RetryAt.Before(time.Now().Add(59*time.Minute)) || len(retrying.Attempts) != 1 {
+		t.Errorf("Retrying job %+v must wait an hour after one attempt", retrying)
+	}
+
+	if _, err := m.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	canceled := waitState(t, m, job.ID, StateCanceled)
+	if !canceled.RetryAt.IsZero() {
+		t.Error("Canceled job must not report a retry time")
+	}
+}
+
+func TestLoadStateRetrying(t *testing.T) {
+	url, _ := newFileServer(t)
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "jobs.db")
+
+	m := NewManager(1)
+	if err := m.LoadState(statePath); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	job, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/missing.txt", Path: filepath.Join(dir, "missing.txt"), Retries: 1, Backoff: "1h"})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	retrying := waitState(t, m, job.ID, StateRetrying)
+	m.Close()
+
+	restored := newTestManager(t, 1)
+	if err := restored.LoadState(statePath); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	got, err := restored.Get(job.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.State != StateRetrying || !got.RetryAt.Equal(retrying.RetryAt) || len(got.Attempts) != 1 {
+		t.Errorf("Expected the job to keep waiting for its retry, got %+v", got)
+	}
+}
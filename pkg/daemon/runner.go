@@ -0,0 +1,198 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/client"
+	"github.com/easzlab/ezft/pkg/hook"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/syncer"
+	"github.com/easzlab/ezft/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// execute runs the transfer of a job
+func (m *Manager) execute(ctx context.Context, j *job, logger *zap.Logger) error {
+	spec := j.Spec
+	concurrency := max(spec.Concurrency, 1)
+
+	switch spec.Type {
+	case JobDownload:
+		config := client.DefaultConfig()
+		config.URL = spec.URL
+		config.OutputPath = spec.Path
+		config.MaxConcurrency = concurrency
+		config.AutoChunk = true
+		config.EnableDelta = spec.Delta
+		config.Username = spec.Username
+		config.Password = spec.Password
+
+		c := client.NewClient(config)
+		c.SetLogger(logger)
+		c.SetHTTPClient(m.httpClient(j))
+		c.SetChunkRecord(m.chunkRecord(j))
+		return c.Download(ctx)
+
+	case JobUpload:
+		info, err := os.Stat(spec.Path)
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		j.Total = info.Size()
+		m.mu.Unlock()
+
+		u := client.NewUploader(&client.UploadConfig{
+			URL:       spec.URL,
+			InputPath: spec.Path,
+			Username:  spec.Username,
+			Password:  spec.Password,
+		})
+		u.SetLogger(logger)
+		u.SetHTTPClient(m.httpClient(j))
+		_, err = u.Upload(ctx)
+		return err
+
+	case JobSync:
+		direction, err := spec.direction()
+		if err != nil {
+			return err
+		}
+		s, err := syncer.NewSyncer(&syncer.Config{
+			LocalDir:       spec.Path,
+			RemoteURL:      spec.URL,
+			Direction:      direction,
+			Conflict:       syncer.ConflictStrategy(spec.Conflict),
+			Username:       spec.Username,
+			Password:       spec.Password,
+			ChunkSize:      client.DefaultConfig().ChunkSize,
+			MaxConcurrency: concurrency,
+			RetryCount:     client.DefaultConfig().RetryCount,
+			Include:        spec.Include,
+			Exclude:        spec.Exclude,
+			Delete:         spec.Delete,
+		})
+		if err != nil {
+			return err
+		}
+		s.SetLogger(logger)
+		s.WrapTransport(func(base http.RoundTripper) http.RoundTripper {
+			return m.wrapTransport(base, j)
+		})
+
+		report, err := s.Run(ctx)
+		m.mu.Lock()
+		j.Report = report
+		m.mu.Unlock()
+		return err
+
+	default:
+		return fmt.Errorf("unknown job type: %s", spec.Type)
+	}
+}
+
+// jobEvent returns the event reported to the hooks and notifiers of a job that
+// finished with err, nil if the job has neither
+func jobEvent(j *job, err error) *hook.Event {
+	spec := j.Spec
+	if spec.Hooks.Empty() && len(spec.Notify) == 0 {
+		return nil
+	}
+
+	event := &hook.Event{
+		Status:   hook.StatusSuccess,
+		Type:     string(spec.Type),
+		URL:      spec.URL,
+		Path:     spec.Path,
+		Bytes:    j.bytes.Load(),
+		Duration: time.Since(j.Started),
+		Job:      j.ID,
+	}
+	if err != nil {
+		event.Status = hook.StatusFailure
+		event.Error = err.Error()
+	} else if spec.Type != JobSync {
+		event.Checksum, _ = utils.CalculateFileHash(spec.Path, "sha256")
+	}
+	return event
+}
+
+// sendNotifications sends the event of a finished job to its notifiers in the
+// background, m.mu must be held
+func (m *Manager) sendNotifications(j *job, event *hook.Event) {
+	// Runs of a recurring job share the throttling of failure alerts
+	key := j.ID
+	if j.Schedule != "" {
+		key = j.Schedule
+	}
+	for _, name := range j.Spec.Notify {
+		notifier := m.notifiers[name]
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			if err := notifier.Notify(m.ctx, key, event); err != nil {
+				m.logger.Info("job notification failed",
+					zap.String("job", j.ID),
+					zap.String("notifier", name),
+					zap.Error(err),
+				)
+			}
+		}()
+	}
+}
+
+// httpClient returns an http client counting the traffic of j and limited by
+// its share of the bandwidth limit of all jobs and the limit of its class
+func (m *Manager) httpClient(j *job) *http.Client {
+	return &http.Client{Transport: m.wrapTransport(http.DefaultTransport.(*http.Transport).Clone(), j)}
+}
+
+func (m *Manager) wrapTransport(base http.RoundTripper, j *job) http.RoundTripper {
+	var transport http.RoundTripper = &countingTransport{base: base, n: &j.bytes}
+	if j.limiter != nil {
+		transport = ratelimit.NewTransport(transport, j.limiter)
+	}
+	if j.classLimiter != nil {
+		transport = ratelimit.NewTransport(transport, j.classLimiter)
+	}
+	return transport
+}
+
+// countingTransport counts the body bytes sent and received through an http.RoundTripper
+type countingTransport struct {
+	base http.RoundTripper
+	n    *atomic.Int64
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		counted := req.Clone(req.Context())
+		counted.Body = &countingReader{ReadCloser: req.Body, n: t.n}
+		req = counted
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countingReader{ReadCloser: resp.Body, n: t.n}
+	return resp, nil
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
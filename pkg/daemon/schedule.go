@@ -0,0 +1,50 @@
+package daemon
+
+import "fmt"
+
+// Policy how the next queued job to run is picked
+type Policy string
+
+const (
+	// PolicyFIFO runs the job with the highest priority first, jobs of the
+	// same priority in submission order
+	PolicyFIFO Policy = "fifo"
+	// PolicyFairShare shares the running slots between job groups, the next
+	// job comes from the group with the fewest running jobs and within it
+	// from the highest priority and earliest submission
+	PolicyFairShare Policy = "fair-share"
+)
+
+// ParsePolicy parses a scheduling policy name
+func ParsePolicy(s string) (Policy, error) {
+	switch policy := Policy(s); policy {
+	case PolicyFIFO, PolicyFairShare:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid scheduling policy %q, must be one of: fifo, fair-share", s)
+	}
+}
+
+// next returns the index in the queue of the job to start next, m.mu must be
+// held and the queue must not be empty
+func (m *Manager) next() int {
+	best := 0
+	for i, j := range m.queue[1:] {
+		if m.before(j, m.queue[best]) {
+			best = i + 1
+		}
+	}
+	return best
+}
+
+// before reports whether queued job a runs before b, which was submitted
+// earlier, m.mu must be held
+func (m *Manager) before(a, b *job) bool {
+	if m.policy == PolicyFairShare {
+		ra, rb := m.groups[a.Spec.Group], m.groups[b.Spec.Group]
+		if ra != rb {
+			return ra < rb
+		}
+	}
+	return a.Spec.Priority > b.Spec.Priority
+}
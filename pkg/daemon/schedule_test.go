@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSchedulePolicy(t *testing.T) {
+	queued := func(id, group string, priority int) *job {
+		return &job{Job: Job{ID: id, Spec: JobSpec{Group: group, Priority: priority}}}
+	}
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		running map[string]int
+		want    []string
+	}{
+		{"fifo", PolicyFIFO, nil, []string{"urgent", "mirror", "nightly-1", "nightly-2", "small"}},
+		{"fair-share", PolicyFairShare, map[string]int{"mirror": 1}, []string{"nightly-1", "small", "urgent", "nightly-2", "mirror"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, 1)
+			m.SetPolicy(tt.policy)
+			for group, n := range tt.running {
+				m.groups[group] = n
+			}
+			m.queue = []*job{
+				queued("mirror", "mirror", 0),
+				queued("nightly-1", "backup", 0),
+				queued("nightly-2", "backup", 0),
+				queued("small", "", 0),
+				queued("urgent", "mirror", 10),
+			}
+
+			var got []string
+			for len(m.queue) > 0 {
+				i := m.next()
+				j := m.queue[i]
+				m.queue = append(m.queue[:i], m.queue[i+1:]...)
+				m.groups[j.Spec.Group]++
+				got = append(got, j.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Got order %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Got order %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPriority(t *testing.T) {
+	url := blockingServer(t)
+	dir := t.TempDir()
+	m := newTestManager(t, 1)
+
+	submit := func(name string, priority int) Job {
+		job, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/" + name, Path: filepath.Join(dir, name), Priority: priority})
+		if err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+		return job
+	}
+	large := submit("large", 0)
+	waitState(t, m, large.ID, StateRunning)
+	normal := submit("normal", 0)
+	urgent := submit("urgent", 5)
+
+	if _, err := m.Cancel(large.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	waitState(t, m, urgent.ID, StateRunning)
+	if job, _ := m.Get(normal.ID); job.State != StateQueued {
+		t.Errorf("Expected the normal job to wait behind the urgent one, got %s", job.State)
+	}
+}
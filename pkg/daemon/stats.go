@@ -0,0 +1,195 @@
+package daemon
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Default retention of finished jobs
+const (
+	DefaultHistorySize = 1000
+	DefaultHistoryAge  = 30 * 24 * time.Hour
+)
+
+// Stats aggregate statistics of the finished jobs in the history
+type Stats struct {
+	Jobs        int        `json:"jobs"` // Finished jobs
+	Succeeded   int        `json:"succeeded"`
+	Failed      int        `json:"failed"`
+	Canceled    int        `json:"canceled"`
+	SuccessRate float64    `json:"success_rate"` // Part of the succeeded jobs among succeeded and failed ones, from 0 to 1
+	Bytes       int64      `json:"bytes"`        // Bytes transferred by finished jobs
+	MeanSpeed   float64    `json:"mean_speed"`   // Bytes per second of succeeded jobs
+	Days        []DayStats `json:"days"`         // Statistics by day the jobs finished, oldest first
+}
+
+// DayStats statistics of the jobs finished on one day
+type DayStats struct {
+	Date      string `json:"date"` // Local date, YYYY-MM-DD
+	Jobs      int    `json:"jobs"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// SetRetention limits the history of finished jobs to the latest maxJobs jobs
+// finished within maxAge, 0 for no limit
+func (m *Manager) SetRetention(maxJobs int, maxAge time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.historySize = maxJobs
+	m.historyAge = maxAge
+	if m.prune() {
+		m.changed()
+	}
+}
+
+// jobFinished counts a job that reached its final state and applies the
+// retention, m.mu must be held
+func (m *Manager) jobFinished(j *job) {
+	m.finishedTotal[j.State]++
+	m.runFinished(j)
+	m.prune()
+}
+
+// prune removes the finished jobs beyond the retention, reporting whether it
+// removed any, m.mu must be held
+func (m *Manager) prune() bool {
+	var finished []*job
+	for _, j := range m.order {
+		if j.State.Finished() {
+			finished = append(finished, j)
+		}
+	}
+	slices.SortStableFunc(finished, func(a, b *job) int {
+		return b.Finished.Compare(a.Finished)
+	})
+
+	expired := map[*job]bool{}
+	for i, j := range finished {
+		if (m.historySize > 0 && i >= m.historySize) || (m.historyAge > 0 && time.Since(j.Finished) > m.historyAge) {
+			expired[j] = true
+		}
+	}
+	if len(expired) == 0 {
+		return false
+	}
+
+	m.order = slices.DeleteFunc(m.order, func(j *job) bool { return expired[j] })
+	for j := range expired {
+		delete(m.jobs, j.ID)
+	}
+	m.logger.Info("finished jobs removed from the history",
+		zap.Int("jobs", len(expired)),
+	)
+	return true
+}
+
+// Stats returns the statistics of the finished jobs in the history
+func (m *Manager) Stats() Stats {
+	return m.stats(nil)
+}
+
+// stats returns the statistics of the finished jobs owned by tok
+func (m *Manager) stats(tok *Token) Stats {
+	var stats Stats
+	var seconds float64
+	var succeededBytes int64
+	days := map[string]*DayStats{}
+	for _, job := range m.List() {
+		if !job.State.Finished() || !tok.owns(job) {
+			continue
+		}
+		date := job.Finished.Local().Format(time.DateOnly)
+		day, ok := days[date]
+		if !ok {
+			day = &DayStats{Date: date}
+			days[date] = day
+		}
+
+		stats.Jobs++
+		stats.Bytes += job.Bytes
+		day.Jobs++
+		day.Bytes += job.Bytes
+		switch job.State {
+		case StateSucceeded:
+			stats.Succeeded++
+			day.Succeeded++
+			if !job.Started.IsZero() {
+				seconds += job.Finished.Sub(job.Started).Seconds()
+				succeededBytes += job.Bytes
+			}
+		case StateFailed:
+			stats.Failed++
+			day.Failed++
+		case StateCanceled:
+			stats.Canceled++
+		}
+	}
+
+	if stats.Succeeded+stats.Failed > 0 {
+		stats.SuccessRate = float64(stats.Succeeded) / float64(stats.Succeeded+stats.Failed)
+	}
+	if seconds > 0 {
+		stats.MeanSpeed = float64(succeededBytes) / seconds
+	}
+	stats.Days = make([]DayStats, 0, len(days))
+	for _, day := range days {
+		stats.Days = append(stats.Days, *day)
+	}
+	slices.SortFunc(stats.Days, func(a, b DayStats) int {
+		return strings.Compare(a.Date, b.Date)
+	})
+	return stats
+}
+
+// handleStats returns the statistics of the jobs of the token
+func (m *Manager) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.stats(tokenFrom(r.Context())))
+}
+
+// handleMetrics exports the jobs of the daemon in the Prometheus text format,
+// only to admin tokens if tokens are configured
+func (m *Manager) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if tok := tokenFrom(r.Context()); tok != nil && !tok.Admin {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%w: metrics require an admin token", ErrForbidden))
+		return
+	}
+
+	stats := m.Stats()
+	states := map[State]int{}
+	for _, job := range m.List() {
+		states[job.State]++
+	}
+	m.mu.Lock()
+	finished := maps.Clone(m.finishedTotal)
+	bytes := m.bytesTotal
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintln(w, "# HELP ezft_jobs Jobs of the daemon by state, finished ones within the history.")
+	fmt.Fprintln(w, "# TYPE ezft_jobs gauge")
+	for _, state := range []State{StateQueued, StateRunning, StateScheduled, StateRetrying, StateSucceeded, StateFailed, StateCanceled} {
+		fmt.Fprintf(w, "ezft_jobs{state=%q} %d\n", state, states[state])
+	}
+	fmt.Fprintln(w, "# HELP ezft_jobs_finished_total Jobs finished since the daemon started by final state.")
+	fmt.Fprintln(w, "# TYPE ezft_jobs_finished_total counter")
+	for _, state := range []State{StateSucceeded, StateFailed, StateCanceled} {
+		fmt.Fprintf(w, "ezft_jobs_finished_total{state=%q} %d\n", state, finished[state])
+	}
+	fmt.Fprintln(w, "# HELP ezft_transferred_bytes_total Bytes transferred by job runs finished since the daemon started.")
+	fmt.Fprintln(w, "# TYPE ezft_transferred_bytes_total counter")
+	fmt.Fprintf(w, "ezft_transferred_bytes_total %d\n", bytes)
+	fmt.Fprintln(w, "# HELP ezft_job_success_ratio Part of the succeeded jobs among succeeded and failed ones in the history.")
+	fmt.Fprintln(w, "# TYPE ezft_job_success_ratio gauge")
+	fmt.Fprintf(w, "ezft_job_success_ratio %g\n", stats.SuccessRate)
+	fmt.Fprintln(w, "# HELP ezft_job_mean_speed_bytes Mean speed of the succeeded jobs in the history in bytes per second.")
+	fmt.Fprintln(w, "# TYPE ezft_job_mean_speed_bytes gauge")
+	fmt.Fprintf(w, "ezft_job_mean_speed_bytes %g\n", stats.MeanSpeed)
+}
@@ -0,0 +1,145 @@
+package daemon
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// finishDownloads runs n downloads of a 4 byte file one after the other
+func finishDownloads(t *testing.T, m *Manager, n int) []string {
+	t.Helper()
+
+	url, root := newFileServer(t)
+	if err := os.WriteFile(filepath.Join(root, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	local := t.TempDir()
+	var ids []string
+	for i := range n {
+		job, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/file.txt", Path: filepath.Join(local, string(rune('a'+i)))})
+		if err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+		waitState(t, m, job.ID, StateSucceeded)
+		ids = append(ids, job.ID)
+	}
+	return ids
+}
+
+func TestRetention(t *testing.T) {
+	m := newTestManager(t, 1)
+	ids := finishDownloads(t, m, 3)
+
+	m.SetRetention(2, 0)
+	if _, err := m.Get(ids[0]); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("The oldest job must be pruned, got %v", err)
+	}
+	for _, id := range ids[1:] {
+		if _, err := m.Get(id); err != nil {
+			t.Errorf("Get(%s) error = %v", id, err)
+		}
+	}
+
+	m.mu.Lock()
+	m.jobs[ids[1]].Finished = time.Now().Add(-48 * time.Hour)
+	m.mu.Unlock()
+	m.SetRetention(2, 24*time.Hour)
+	if _, err := m.Get(ids[1]); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("The expired job must be pruned, got %v", err)
+	}
+	if _, err := m.Get(ids[2]); err != nil {
+		t.Errorf("Get(%s) error = %v", ids[2], err)
+	}
+
+	// Jobs finishing later are pruned as well
+	m.SetRetention(1, 0)
+	latest := finishDownloads(t, m, 1)
+	if jobs := m.List(); len(jobs) != 1 || jobs[0].ID != latest[0] {
+		t.Errorf("Expected only the latest job, got %+v", jobs)
+	}
+}
+
+func TestStats(t *testing.T) {
+	m := newTestManager(t, 1)
+	finishDownloads(t, m, 2)
+	failed, err := m.Submit(JobSpec{Type: JobDownload, URL: "http://127.0.0.1:1/missing", Path: filepath.Join(t.TempDir(), "missing")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitState(t, m, failed.ID, StateFailed)
+
+	stats := m.Stats()
+	if stats.Jobs != 3 || stats.Succeeded != 2 || stats.Failed != 1 || stats.Canceled != 0 {
+		t.Errorf("Unexpected counts %+v", stats)
+	}
+	if stats.Bytes != 8 {
+		t.Errorf("Bytes = %d, want 8", stats.Bytes)
+	}
+	if stats.SuccessRate < 0.66 || stats.SuccessRate > 0.67 {
+		t.Errorf("SuccessRate = %f, want 2/3", stats.SuccessRate)
+	}
+	today := time.Now().Format(time.DateOnly)
+	if len(stats.Days) != 1 || stats.Days[0] != (DayStats{Date: today, Jobs: 3, Succeeded: 2, Failed: 1, Bytes: 8}) {
+		t.Errorf("Unexpected days %+v", stats.Days)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := newTestManager(t, 1)
+	finishDownloads(t, m, 2)
+	api := httptest.NewServer(m.Handler())
+	defer api.Close()
+
+	get := func(token string) (int, string) {
+		req, _ := http.NewRequest("GET", api.URL+"/metrics", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET /metrics error = %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("")
+	if code != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d", code)
+	}
+	for _, line := range []string{
+		`ezft_jobs{state="succeeded"} 2`,
+		`ezft_jobs_finished_total{state="succeeded"} 2`,
+		`ezft_transferred_bytes_total 8`,
+		`ezft_job_success_ratio 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Metrics lack %q:\n%s", line, body)
+		}
+	}
+
+	// Pruned jobs remain in the counters
+	m.SetRetention(1, 0)
+	if _, body = get(""); !strings.Contains(body, `ezft_jobs{state="succeeded"} 1`+"\n") ||
+		!strings.Contains(body, `ezft_jobs_finished_total{state="succeeded"} 2`+"\n") {
+		t.Errorf("Unexpected metrics after pruning:\n%s", body)
+	}
+
+	m.SetAccess(map[string]*Token{
+		"ops":    {Name: "ops", Secret: "secret-ops", Admin: true},
+		"team-a": {Name: "team-a", Secret: "secret-a", Paths: []string{"/data"}},
+	}, nil)
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "secret-a": http.StatusForbidden, "secret-ops": http.StatusOK} {
+		if code, _ := get(token); code != want {
+			t.Errorf("GET /metrics with token %q status = %d, want %d", token, code, want)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/client"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// progressInterval how often the progress of running jobs is persisted
+const progressInterval = 5 * time.Second
+
+var (
+	jobsBucket   = []byte("jobs")   // Job ID to the job as JSON, with credentials
+	chunksBucket = []byte("chunks") // Job ID to a bucket of the chunks left of a download
+)
+
+// LoadState restores the jobs persisted in the database at path and keeps it
+// updated with all job changes from now on. Jobs that were queued or running
+// when the daemon stopped are queued again, downloads continue from the chunks
+// left they recorded in the database and syncs from the progress recorded next
+// to their local path, recurring jobs are scheduled again and failed jobs
+// waiting for an automatic retry keep waiting for it.
+func (m *Manager) LoadState(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	// Fails instead of waiting for another daemon using the database
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open job state: %w", err)
+	}
+
+	var saved []Job
+	records := map[string][]byte{}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(chunksBucket); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists(jobsBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var j Job
+			if err := json.Unmarshal(v, &j); err != nil {
+				return fmt.Errorf("job %s: %w", k, err)
+			}
+			saved = append(saved, j)
+			records[string(k)] = bytes.Clone(v)
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to read job state: %w", err)
+	}
+	slices.SortStableFunc(saved, func(a, b Job) int { return a.Created.Compare(b.Created) })
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.db = db
+	m.saved = records
+	resumed := 0
+	for _, saved := range saved {
+		if _, ok := m.jobs[saved.ID]; ok {
+			continue
+		}
+		j := &job{Job: saved}
+		j.bytes.Store(saved.Bytes)
+		switch {
+		case j.State == StateScheduled:
+			m.startRecurring(j)
+		case j.State == StateRetrying:
+			m.retryLater(j)
+		case !j.State.Finished():
+			j.State = StateQueued
+			j.Started = time.Time{}
+			m.queue = append(m.queue, j)
+			resumed++
+		}
+		m.jobs[j.ID] = j
+		m.order = append(m.order, j)
+	}
+
+	if len(saved) > 0 {
+		m.logger.Info("job state restored",
+			zap.Int("jobs", len(saved)),
+			zap.Int("resumed", resumed),
+		)
+	}
+	m.prune()
+	m.schedule()
+	m.changed()
+
+	m.wg.Add(1)
+	go m.persistJobs()
+	return nil
+}
+
+// persist wakes up the writer of the database, if any, m.mu must be held
+func (m *Manager) persist() {
+	if m.db == nil {
+		return
+	}
+	select {
+	case m.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// persistJobs saves the jobs whenever they change and the transferred bytes of
+// running jobs periodically, and removes finished jobs beyond the retention,
+// until the manager is closed
+func (m *Manager) persistJobs() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-m.dirty:
+		case <-ticker.C:
+			m.mu.Lock()
+			if m.prune() {
+				m.changed()
+			}
+			m.mu.Unlock()
+		}
+		m.save()
+	}
+}
+
+// save writes the jobs that changed since the last save to the database and
+// deletes the removed ones with their chunks, in one transaction outside of
+// m.mu. It only runs on the goroutine of persistJobs, and in Close once that
+// returned.
+func (m *Manager) save() {
+	changed := map[string][]byte{}
+	live := map[string]bool{}
+	m.mu.Lock()
+	for _, j := range m.order {
+		live[j.ID] = true
+		record := j.Job
+		record.Bytes = j.bytes.Load()
+		data, err := json.Marshal(record)
+		if err == nil && !bytes.Equal(data, m.saved[j.ID]) {
+			changed[j.ID] = data
+		}
+	}
+	m.mu.Unlock()
+	var removed []string
+	for id := range m.saved {
+		if !live[id] {
+			removed = append(removed, id)
+		}
+	}
+	if len(changed) == 0 && len(removed) == 0 {
+		return
+	}
+
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		jobs, chunks := tx.Bucket(jobsBucket), tx.Bucket(chunksBucket)
+		for id, data := range changed {
+			if err := jobs.Put([]byte(id), data); err != nil {
+				return err
+			}
+		}
+		for _, id := range removed {
+			if err := jobs.Delete([]byte(id)); err != nil {
+				return err
+			}
+			if chunks.Bucket([]byte(id)) != nil {
+				if err := chunks.DeleteBucket([]byte(id)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		m.logger.Info("failed to save job state",
+			zap.Error(err),
+		)
+		return
+	}
+	for id, data := range changed {
+		m.saved[id] = data
+	}
+	for _, id := range removed {
+		delete(m.saved, id)
+	}
+}
+
+// closeState saves the jobs a last time and closes the database, once all
+// jobs stopped
+func (m *Manager) closeState() {
+	m.mu.Lock()
+	db := m.db
+	m.mu.Unlock()
+	if db == nil {
+		return
+	}
+	m.save()
+
+	m.mu.Lock()
+	m.db = nil
+	m.mu.Unlock()
+	if err := db.Close(); err != nil {
+		m.logger.Info("failed to close job state",
+			zap.Error(err),
+		)
+	}
+}
+
+// chunkRecord keeps the chunks left of a download job in the database, one
+// record per chunk keyed by its start
+type chunkRecord struct {
+	db *bolt.DB
+	id []byte
+}
+
+// chunkRecord returns the record of the chunks left of j, nil without a
+// database
+func (m *Manager) chunkRecord(j *job) client.ChunkRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.db == nil {
+		return nil
+	}
+	return chunkRecord{db: m.db, id: []byte(j.ID)}
+}
+
+func (r chunkRecord) Load() ([]client.Chunk, bool, error) {
+	var chunks []client.Chunk
+	ok := false
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(chunksBucket).Bucket(r.id)
+		if b == nil {
+			return nil
+		}
+		ok = true
+		return b.ForEach(func(k, v []byte) error {
+			if len(k) != 8 || len(v) != 16 {
+				return errors.New("invalid chunk record")
+			}
+			chunks = append(chunks, client.Chunk{
+				Index: int64(binary.BigEndian.Uint64(v)),
+				Start: int64(binary.BigEndian.Uint64(k)),
+				End:   int64(binary.BigEndian.Uint64(v[8:])),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read chunk record: %w", err)
+	}
+	return chunks, ok, nil
+}
+
+func (r chunkRecord) Save(chunks []client.Chunk) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		parent := tx.Bucket(chunksBucket)
+		if parent.Bucket(r.id) != nil {
+			if err := parent.DeleteBucket(r.id); err != nil {
+				return err
+			}
+		}
+		b, err := parent.CreateBucket(r.id)
+		if err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			k := binary.BigEndian.AppendUint64(nil, uint64(chunk.Start))
+			v := binary.BigEndian.AppendUint64(nil, uint64(chunk.Index))
+			v = binary.BigEndian.AppendUint64(v, uint64(chunk.End))
+			if err := b.Put(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save chunk record: %w", err)
+	}
+	return nil
+}
+
+func (r chunkRecord) Remove() error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		parent := tx.Bucket(chunksBucket)
+		if parent.Bucket(r.id) == nil {
+			return nil
+		}
+		return parent.DeleteBucket(r.id)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete chunk record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestLoadState(t *testing.T) {
+	url, root := newFileServer(t)
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("aaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	blocking := blockingServer(t)
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state", "jobs.db")
+
+	m := NewManager(1)
+	if err := m.LoadState(statePath); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	done, err := m.Submit(JobSpec{Type: JobDownload, URL: url + "/a.txt", Path: filepath.Join(dir, "a.txt")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitState(t, m, done.ID, StateSucceeded)
+	interrupted, err := m.Submit(JobSpec{Type: JobDownload, URL: blocking + "/b", Path: filepath.Join(dir, "b"), Password: "secret"})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitState(t, m, interrupted.ID, StateRunning)
+	m.Close()
+
+	if job, _ := m.Get(interrupted.ID); job.State != StateQueued || !job.Started.IsZero() {
+		t.Errorf("Expected the interrupted job to be queued again, got %+v", job)
+	}
+	if info, err := os.Stat(statePath); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected a state file only readable by the owner, got %v, %v", info, err)
+	}
+
+	restored := newTestManager(t, 1)
+	if err := restored.LoadState(statePath); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	jobs := restored.List()
+	if len(jobs) != 2 || jobs[0].ID != done.ID || jobs[0].State != StateSucceeded || jobs[0].Bytes == 0 {
+		t.Fatalf("Expected the finished job to be restored as is, got %+v", jobs)
+	}
+	waitState(t, restored, interrupted.ID, StateRunning)
+	restored.mu.Lock()
+	password := restored.jobs[interrupted.ID].Spec.Password
+	restored.mu.Unlock()
+	if password != "secret" {
+		t.Error("Expected the credentials of a resumed job to be restored")
+	}
+}
+
+func TestLoadStateChunks(t *testing.T) {
+	content := make([]byte, 8*1024*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	var blocking atomic.Bool
+	var fromStart atomic.Int32
+	blocking.Store(true)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); rng != "" {
+			if strings.HasPrefix(rng, "bytes=0-") {
+				fromStart.Add(1)
+			} else if blocking.Load() {
+				<-r.Context().Done()
+				return
+			}
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "jobs.db")
+	output := filepath.Join(dir, "file.bin")
+
+	m := NewManager(1)
+	if err := m.LoadState(statePath); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	job, err := m.Submit(JobSpec{Type: JobDownload, URL: ts.URL + "/file.bin", Path: output, Concurrency: 1})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for job, _ = m.Get(job.ID); job.Bytes == 0; job, _ = m.Get(job.ID) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the first chunk")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.Close()
+
+	// The chunks left are records of the database, not a file next to the output
+	if _, err := os.Stat(output + ".failed_chunks.json"); !os.IsNotExist(err) {
+		t.Errorf("Expected no failed chunks file, got %v", err)
+	}
+	db, err := bolt.Open(statePath, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left int64
+	db.View(func(tx *bolt.Tx) error {
+		chunks, _, err := chunkRecord{db: db, id: []byte(job.ID)}.Load()
+		if err != nil {
+			t.Errorf("Load() error = %v", err)
+		}
+		for _, chunk := range chunks {
+			left += chunk.End - chunk.Start + 1
+		}
+		return nil
+	})
+	db.Close()
+	if left == 0 || left >= int64(len(content)) {
+		t.Fatalf("Expected the chunks after the first one recorded left, got %d bytes", left)
+	}
+
+	blocking.Store(false)
+	requests := fromStart.Load()
+	restored := newTestManager(t, 1)
+	if err := restored.LoadState(statePath); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	waitState(t, restored, job.ID, StateSucceeded)
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+		t.Error("Resumed download differs")
+	}
+	if n := fromStart.Load(); n != requests {
+		t.Errorf("The first chunk was downloaded again")
+	}
+	restored.mu.Lock()
+	j := restored.jobs[job.ID]
+	restored.mu.Unlock()
+	if _, ok, err := restored.chunkRecord(j).Load(); ok || err != nil {
+		t.Errorf("Expected the chunk record of the finished download removed, got %v, %v", ok, err)
+	}
+}
+
+func TestSaveChanges(t *testing.T) {
+	m := newTestManager(t, 1)
+	if err := m.LoadState(filepath.Join(t.TempDir(), "jobs.db")); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	job, err := m.Submit(JobSpec{Type: JobDownload, URL: blockingServer(t) + "/file", Path: filepath.Join(t.TempDir(), "file")})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	saved := func() *Job {
+		var j *Job
+		m.db.View(func(tx *bolt.Tx) error {
+			if data := tx.Bucket(jobsBucket).Get([]byte(job.ID)); data != nil {
+				j = &Job{}
+				json.Unmarshal(data, j)
+			}
+			return nil
+		})
+		return j
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for j := saved(); j == nil || j.State != StateRunning; j = saved() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the job to be saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Notifications without any change of the jobs write nothing
+	stats := m.db.Stats()
+	writes := stats.TxStats.GetWrite()
+	for range 10 {
+		m.mu.Lock()
+		m.changed()
+		m.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	stats = m.db.Stats()
+	if got := stats.TxStats.GetWrite(); got != writes {
+		t.Errorf("%d pages written without changes", got-writes)
+	}
+
+	if _, err := m.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	for j := saved(); j.State != StateCanceled; j = saved() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the canceled job to be saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadStateInvalid(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "jobs.db")
+	if err := os.WriteFile(statePath, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestManager(t, 1).LoadState(statePath); err == nil {
+		t.Error("Expected an error for a corrupt state file")
+	}
+}
@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"context"
+	"time"
+)
+
+// watchInterval how often watchers receive the progress of running jobs
+const watchInterval = time.Second
+
+// changed persists the jobs and wakes up all watchers, m.mu must be held
+func (m *Manager) changed() {
+	m.persist()
+	close(m.notify)
+	m.notify = make(chan struct{})
+}
+
+// Watch streams snapshots of a job, the current one first, then one on every
+// change and every second while the job runs. The channel is closed after the
+// job finished or ctx is done.
+func (m *Manager) Watch(ctx context.Context, id string) (<-chan Job, error) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return watch(ctx, m, func() (Job, bool) {
+		job := j.snapshot()
+		return job, job.State.Finished()
+	}), nil
+}
+
+// WatchAll streams snapshots of all jobs in submission order, the current ones
+// first, then on every change and every second, until ctx is done
+func (m *Manager) WatchAll(ctx context.Context) <-chan []Job {
+	return m.watchAll(ctx, nil)
+}
+
+// watchAll streams snapshots of the jobs owned by tok like WatchAll
+func (m *Manager) watchAll(ctx context.Context, tok *Token) <-chan []Job {
+	return watch(ctx, m, func() ([]Job, bool) {
+		jobs := make([]Job, 0, len(m.order))
+		for _, j := range m.order {
+			if tok.owns(j.Job) {
+				jobs = append(jobs, j.snapshot())
+			}
+		}
+		return jobs, false
+	})
+}
+
+// watch sends what snapshot returns, called with m.mu held, until it reports
+// being the last one or ctx is done
+func watch[T any](ctx context.Context, m *Manager, snapshot func() (T, bool)) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		for {
+			m.mu.Lock()
+			v, last := snapshot()
+			notify := m.notify
+			m.mu.Unlock()
+
+			select {
+			case ch <- v:
+			case <-ctx.Done():
+				return
+			}
+			if last {
+				return
+			}
+
+			select {
+			case <-notify:
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
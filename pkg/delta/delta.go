@@ -0,0 +1,383 @@
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// MinBlockSize minimum block size used for signatures
+	MinBlockSize = 2 * 1024
+	// MaxBlockSize maximum block size used for signatures
+	MaxBlockSize = 1024 * 1024
+	// maxLiteral maximum size of a single literal instruction
+	maxLiteral = 1024 * 1024
+
+	opCopy    byte = 'C'
+	opLiteral byte = 'L'
+	opEnd     byte = 'E'
+)
+
+// magic identifies a delta instruction stream
+var magic = []byte("EZD1")
+
+// ErrChecksumMismatch is returned when the patched output does not match the source
+var ErrChecksumMismatch = errors.New("delta checksum mismatch")
+
+// BlockSig signature of a single block of the base file
+type BlockSig struct {
+	Weak   uint32 `json:"weak"`   // Rolling checksum
+	Strong []byte `json:"strong"` // MD5 of block content
+}
+
+// Signature block signatures of a base file
+type Signature struct {
+	BlockSize int        `json:"blockSize"`
+	FileSize  int64      `json:"fileSize"`
+	Blocks    []BlockSig `json:"blocks"`
+}
+
+// BlockSizeFor returns a block size suited to the given file size,
+// following the rsync heuristic of roughly sqrt(fileSize)
+func BlockSizeFor(fileSize int64) int {
+	size := int(math.Sqrt(float64(fileSize)))
+	// Round up to a multiple of 1KB
+	size = (size + 1023) / 1024 * 1024
+	if size < MinBlockSize {
+		size = MinBlockSize
+	}
+	if size > MaxBlockSize {
+		size = MaxBlockSize
+	}
+	return size
+}
+
+// ComputeSignature computes block signatures of r, only full blocks are recorded
+func ComputeSignature(r io.Reader, blockSize int) (*Signature, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size: %d", blockSize)
+	}
+
+	sig := &Signature{BlockSize: blockSize}
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		sig.FileSize += int64(n)
+		if n == blockSize {
+			strong := md5.Sum(buf)
+			sig.Blocks = append(sig.Blocks, BlockSig{
+				Weak:   weakSum(buf),
+				Strong: strong[:],
+			})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Stats summarizes a generated delta
+type Stats struct {
+	CopiedBytes  int64 // Bytes reused from the base file
+	LiteralBytes int64 // Bytes sent as literal data
+}
+
+// ComputeDelta reads the new content from r and writes the instruction stream
+// that transforms the base described by sig into it
+func ComputeDelta(sig *Signature, r io.Reader, w io.Writer) (*Stats, error) {
+	if sig == nil || sig.BlockSize <= 0 {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	bs := sig.BlockSize
+	table := make(map[uint32][]int, len(sig.Blocks))
+	for i, b := range sig.Blocks {
+		table[b.Weak] = append(table[b.Weak], i)
+	}
+
+	enc, err := newEncoder(w)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	src := io.TeeReader(r, hash)
+	stats := &Stats{}
+
+	// data holds the pending literal followed by the current window
+	data := make([]byte, 0, 4*bs+maxLiteral)
+	lit, pos := 0, 0
+	eof := false
+	var rs rollingSum
+	valid := false
+
+	// fill makes sure at least need bytes are available after pos
+	fill := func(need int) error {
+		for !eof && len(data)-pos < need {
+			if lit > 0 {
+				n := copy(data, data[lit:])
+				data = data[:n]
+				pos -= lit
+				lit = 0
+			}
+			if len(data) == cap(data) {
+				grown := make([]byte, len(data), 2*cap(data))
+				copy(grown, data)
+				data = grown
+			}
+			n, err := src.Read(data[len(data):cap(data)])
+			data = data[:len(data)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		if err := fill(bs + 1); err != nil {
+			return nil, err
+		}
+		if len(data)-pos < bs {
+			break
+		}
+
+		window := data[pos : pos+bs]
+		if !valid {
+			rs.init(window)
+			valid = true
+		}
+
+		if idx, ok := lookup(table, sig.Blocks, rs.sum(), window); ok {
+			if err := enc.literal(data[lit:pos]); err != nil {
+				return nil, err
+			}
+			stats.LiteralBytes += int64(pos - lit)
+			if err := enc.copyBlock(idx); err != nil {
+				return nil, err
+			}
+			stats.CopiedBytes += int64(bs)
+			pos += bs
+			lit = pos
+			valid = false
+			continue
+		}
+
+		// Flush literal data before it grows unbounded
+		if pos-lit >= maxLiteral {
+			if err := enc.literal(data[lit:pos]); err != nil {
+				return nil, err
+			}
+			stats.LiteralBytes += int64(pos - lit)
+			lit = pos
+		}
+
+		// Slide the window by one byte
+		if pos+bs < len(data) {
+			rs.roll(data[pos], data[pos+bs])
+		} else {
+			valid = false
+		}
+		pos++
+	}
+
+	if err := enc.literal(data[lit:]); err != nil {
+		return nil, err
+	}
+	stats.LiteralBytes += int64(len(data) - lit)
+
+	if err := enc.end(hash.Sum(nil)); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Apply rebuilds the new content from base and the instruction stream,
+// writing it to w and verifying the result checksum
+func Apply(base io.ReaderAt, blockSize int, delta io.Reader, w io.Writer) (int64, error) {
+	if blockSize <= 0 {
+		return 0, fmt.Errorf("invalid block size: %d", blockSize)
+	}
+
+	r := bufio.NewReader(delta)
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, fmt.Errorf("failed to read delta header: %w", err)
+	}
+	if !bytes.Equal(header, magic) {
+		return 0, fmt.Errorf("invalid delta header")
+	}
+
+	hash := sha256.New()
+	out := io.MultiWriter(w, hash)
+	block := make([]byte, blockSize)
+	var written int64
+
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return written, fmt.Errorf("failed to read delta instruction: %w", err)
+		}
+
+		switch op {
+		case opCopy:
+			index, err := binary.ReadUvarint(r)
+			if err != nil {
+				return written, err
+			}
+			count, err := binary.ReadUvarint(r)
+			if err != nil {
+				return written, err
+			}
+			for i := uint64(0); i < count; i++ {
+				offset := int64(index+i) * int64(blockSize)
+				if _, err := base.ReadAt(block, offset); err != nil {
+					return written, fmt.Errorf("failed to read base block %d: %w", index+i, err)
+				}
+				if _, err := out.Write(block); err != nil {
+					return written, err
+				}
+				written += int64(blockSize)
+			}
+		case opLiteral:
+			size, err := binary.ReadUvarint(r)
+			if err != nil {
+				return written, err
+			}
+			if size > maxLiteral {
+				return written, fmt.Errorf("literal too large: %d", size)
+			}
+			n, err := io.CopyN(out, r, int64(size))
+			written += n
+			if err != nil {
+				return written, fmt.Errorf("failed to read literal data: %w", err)
+			}
+		case opEnd:
+			sum := make([]byte, sha256.Size)
+			if _, err := io.ReadFull(r, sum); err != nil {
+				return written, fmt.Errorf("failed to read delta checksum: %w", err)
+			}
+			if !bytes.Equal(sum, hash.Sum(nil)) {
+				return written, ErrChecksumMismatch
+			}
+			return written, nil
+		default:
+			return written, fmt.Errorf("unknown delta instruction: %q", op)
+		}
+	}
+}
+
+// lookup finds a base block matching the window
+func lookup(table map[uint32][]int, blocks []BlockSig, weak uint32, window []byte) (int, bool) {
+	candidates, ok := table[weak]
+	if !ok {
+		return 0, false
+	}
+	strong := md5.Sum(window)
+	for _, idx := range candidates {
+		if bytes.Equal(blocks[idx].Strong, strong[:]) {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// encoder writes the delta instruction stream, merging adjacent block copies
+type encoder struct {
+	w         *bufio.Writer
+	copyStart int
+	copyCount int
+	scratch   [binary.MaxVarintLen64]byte
+}
+
+func newEncoder(w io.Writer) (*encoder, error) {
+	e := &encoder{w: bufio.NewWriter(w)}
+	if _, err := e.w.Write(magic); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *encoder) copyBlock(index int) error {
+	if e.copyCount > 0 && e.copyStart+e.copyCount == index {
+		e.copyCount++
+		return nil
+	}
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	e.copyStart, e.copyCount = index, 1
+	return nil
+}
+
+func (e *encoder) flushCopy() error {
+	if e.copyCount == 0 {
+		return nil
+	}
+	if err := e.w.WriteByte(opCopy); err != nil {
+		return err
+	}
+	if err := e.uvarint(uint64(e.copyStart)); err != nil {
+		return err
+	}
+	if err := e.uvarint(uint64(e.copyCount)); err != nil {
+		return err
+	}
+	e.copyCount = 0
+	return nil
+}
+
+// literal writes data as literal instructions of at most maxLiteral bytes
+func (e *encoder) literal(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		n := min(len(data), maxLiteral)
+		if err := e.w.WriteByte(opLiteral); err != nil {
+			return err
+		}
+		if err := e.uvarint(uint64(n)); err != nil {
+			return err
+		}
+		if _, err := e.w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (e *encoder) end(sum []byte) error {
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	if err := e.w.WriteByte(opEnd); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sum); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+func (e *encoder) uvarint(v uint64) error {
+	n := binary.PutUvarint(e.scratch[:], v)
+	_, err := e.w.Write(e.scratch[:n])
+	return err
+}
@@ -0,0 +1,170 @@
+package delta
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func roundTrip(t *testing.T, base, target []byte, blockSize int) *Stats {
+	t.Helper()
+
+	sig, err := ComputeSignature(bytes.NewReader(base), blockSize)
+	if err != nil {
+		t.Fatalf("ComputeSignature() error = %v", err)
+	}
+
+	var stream bytes.Buffer
+	stats, err := ComputeDelta(sig, bytes.NewReader(target), &stream)
+	if err != nil {
+		t.Fatalf("ComputeDelta() error = %v", err)
+	}
+
+	var out bytes.Buffer
+	n, err := Apply(bytes.NewReader(base), blockSize, &stream, &out)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if n != int64(len(target)) {
+		t.Errorf("Apply() wrote %d bytes, want %d", n, len(target))
+	}
+	if !bytes.Equal(out.Bytes(), target) {
+		t.Fatal("Patched output does not match target")
+	}
+	return stats
+}
+
+func TestRollingSum(t *testing.T) {
+	data := randomData(1, 4096)
+	window := 512
+
+	var rs rollingSum
+	rs.init(data[:window])
+	for i := 1; i+window <= len(data); i++ {
+		rs.roll(data[i-1], data[i+window-1])
+		if want := weakSum(data[i : i+window]); rs.sum() != want {
+			t.Fatalf("rolling sum at %d = %x, want %x", i, rs.sum(), want)
+		}
+	}
+}
+
+func TestBlockSizeFor(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int
+	}{
+		{0, MinBlockSize},
+		{1024, MinBlockSize},
+		{100 * 1024 * 1024, 10240},
+		{1 << 50, MaxBlockSize},
+	}
+
+	for _, tt := range tests {
+		if got := BlockSizeFor(tt.size); got != tt.want {
+			t.Errorf("BlockSizeFor(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestDeltaIdentical(t *testing.T) {
+	data := randomData(2, 64*1024)
+	stats := roundTrip(t, data, data, 4096)
+
+	if stats.LiteralBytes != 0 {
+		t.Errorf("Expected no literal bytes, got %d", stats.LiteralBytes)
+	}
+	if stats.CopiedBytes != int64(len(data)) {
+		t.Errorf("Expected %d copied bytes, got %d", len(data), stats.CopiedBytes)
+	}
+}
+
+func TestDeltaAppend(t *testing.T) {
+	base := randomData(3, 100*1024)
+	tail := randomData(4, 10*1024)
+	target := append(append([]byte{}, base...), tail...)
+
+	stats := roundTrip(t, base, target, 4096)
+	if stats.LiteralBytes > int64(len(tail)+4096) {
+		t.Errorf("Expected literal bytes close to %d, got %d", len(tail), stats.LiteralBytes)
+	}
+}
+
+func TestDeltaInsertAndModify(t *testing.T) {
+	base := randomData(5, 200*1024)
+
+	target := append([]byte{}, base[:50*1024]...)
+	target = append(target, []byte("inserted bytes that shift the rest of the file")...)
+	target = append(target, base[50*1024:]...)
+	copy(target[150*1024:], randomData(6, 1000))
+
+	stats := roundTrip(t, base, target, 2048)
+	if stats.CopiedBytes < int64(len(base))/2 {
+		t.Errorf("Expected most data to be copied, got %d copied bytes", stats.CopiedBytes)
+	}
+}
+
+func TestDeltaLongLiteralTail(t *testing.T) {
+	// A changed tail longer than a literal instruction, ending with less
+	// than a block after the last flush
+	base := randomData(8, 64*1024)
+	for _, size := range []int{maxLiteral + 4096, 3*maxLiteral + 4095} {
+		target := append(append([]byte{}, base...), randomData(9, size)...)
+		stats := roundTrip(t, base, target, 4096)
+		if stats.LiteralBytes != int64(size) {
+			t.Errorf("tail of %d bytes: literal bytes = %d", size, stats.LiteralBytes)
+		}
+	}
+}
+
+func TestDeltaEmptyInputs(t *testing.T) {
+	data := randomData(7, 10000)
+
+	t.Run("empty base", func(t *testing.T) {
+		stats := roundTrip(t, nil, data, 2048)
+		if stats.LiteralBytes != int64(len(data)) {
+			t.Errorf("Expected all bytes literal, got %d", stats.LiteralBytes)
+		}
+	})
+
+	t.Run("empty target", func(t *testing.T) {
+		roundTrip(t, data, nil, 2048)
+	})
+}
+
+func TestApplyChecksumMismatch(t *testing.T) {
+	base := randomData(8, 16*1024)
+	target := randomData(9, 16*1024)
+
+	sig, err := ComputeSignature(bytes.NewReader(base), 2048)
+	if err != nil {
+		t.Fatalf("ComputeSignature() error = %v", err)
+	}
+
+	var stream bytes.Buffer
+	if _, err := ComputeDelta(sig, bytes.NewReader(target), &stream); err != nil {
+		t.Fatalf("ComputeDelta() error = %v", err)
+	}
+
+	// Corrupt the last checksum byte
+	raw := stream.Bytes()
+	raw[len(raw)-1] ^= 0xff
+
+	var out bytes.Buffer
+	if _, err := Apply(bytes.NewReader(base), 2048, bytes.NewReader(raw), &out); err != ErrChecksumMismatch {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestApplyInvalidHeader(t *testing.T) {
+	var out bytes.Buffer
+	_, err := Apply(bytes.NewReader(nil), 2048, bytes.NewReader([]byte("nope")), &out)
+	if err == nil {
+		t.Error("Expected error for invalid header")
+	}
+}
@@ -0,0 +1,34 @@
+package delta
+
+// rollingSum rsync style weak checksum that can be updated in O(1)
+// when the window slides by one byte
+type rollingSum struct {
+	a, b uint32
+	n    uint32
+}
+
+func (r *rollingSum) init(window []byte) {
+	r.a, r.b = 0, 0
+	r.n = uint32(len(window))
+	for i, c := range window {
+		r.a += uint32(c)
+		r.b += uint32(len(window)-i) * uint32(c)
+	}
+}
+
+// roll removes out from the window head and appends in at the tail
+func (r *rollingSum) roll(out, in byte) {
+	r.a = r.a - uint32(out) + uint32(in)
+	r.b = r.b - r.n*uint32(out) + r.a
+}
+
+func (r *rollingSum) sum() uint32 {
+	return (r.a & 0xffff) | (r.b << 16)
+}
+
+// weakSum computes the weak checksum of a full block
+func weakSum(block []byte) uint32 {
+	var r rollingSum
+	r.init(block)
+	return r.sum()
+}
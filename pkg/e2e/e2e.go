@@ -0,0 +1,246 @@
+// Package e2e encrypts payloads end to end with a key shared by client and
+// server, so file contents stay protected from TLS-terminating middleboxes.
+//
+// Every payload is encrypted on its own, like the STREAM construction of age:
+// a random salt derives a payload key from the shared key, and the payload is
+// split into segments of 64KiB sealed with AES-256-GCM. The nonce of each
+// segment is its counter with a flag marking the last segment, so segments
+// cannot be reordered, dropped or truncated without notice.
+package e2e
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Header marks encrypted responses with the ID of the key, it is set on HEAD
+// responses as well so clients learn about the encryption before downloading
+const Header = "X-Ezft-E2E"
+
+const (
+	segmentSize  = 64 * 1024 // Plaintext bytes per segment
+	saltSize     = 32
+	minSecretLen = 16
+)
+
+var (
+	// ErrDecrypt is returned when a payload fails authentication
+	ErrDecrypt = errors.New("payload decryption failed")
+	// ErrKeyMismatch is returned when a payload was encrypted with another key
+	ErrKeyMismatch = errors.New("end-to-end keys of client and server differ")
+)
+
+// Key a shared key encrypting payloads
+type Key struct {
+	key []byte
+	id  string
+}
+
+// ParseKey derives a key from a shared secret of at least 16 characters
+func ParseKey(secret string) (*Key, error) {
+	if len(secret) < minSecretLen {
+		return nil, fmt.Errorf("end-to-end key must have at least %d characters", minSecretLen)
+	}
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, "ezft e2e key", 32)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("ezft e2e key id"))
+	return &Key{key: key, id: hex.EncodeToString(mac.Sum(nil)[:8])}, nil
+}
+
+// ID identifies the key without revealing it
+func (k *Key) ID() string {
+	return k.id
+}
+
+// aead returns the cipher of the payload with the given salt
+func (k *Key) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, k.key, salt, "ezft e2e payload", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptedSize returns the size of the encrypted payload of n bytes
+func EncryptedSize(n int64) int64 {
+	segments := max((n+segmentSize-1)/segmentSize, 1)
+	return saltSize + n + segments*16
+}
+
+// nonce returns the nonce of segment counter
+func nonce(counter uint64, last bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[3:11], counter)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+// writer encrypts a payload
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+// NewWriter encrypts a payload to w, Close writes its last segment
+func (k *Key) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	salt := make([]byte, saltSize)
+	rand.Read(salt)
+	aead, err := k.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, buf: make([]byte, 0, segmentSize+16)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed payload")
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full segment is only sealed once more data follows, the last
+		// segment must be sealed as such
+		if len(w.buf) == segmentSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):segmentSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *writer) seal(last bool) error {
+	sealed := w.aead.Seal(w.buf[:0], nonce(w.counter, last), w.buf, nil)
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+// Close writes the last segment, it does not close the underlying writer
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+// reader decrypts a payload
+type reader struct {
+	key     *Key
+	r       io.Reader
+	aead    cipher.AEAD // nil until the salt is read
+	buf     []byte      // Sealed segment
+	out     []byte      // Decrypted segment, apart from buf as failed attempts clear it
+	plain   []byte      // Decrypted data not read yet
+	counter uint64
+	last    bool // Whether the last segment was decrypted
+	err     error
+}
+
+// NewReader decrypts the payload read from r, failing with ErrDecrypt when it
+// was modified or truncated
+func (k *Key) NewReader(r io.Reader) io.Reader {
+	return &reader{key: k, r: r}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.last {
+			return 0, io.EOF
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next segment
+func (r *reader) next() error {
+	if r.aead == nil {
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(r.r, salt); err != nil {
+			return truncated(err)
+		}
+		aead, err := r.key.aead(salt)
+		if err != nil {
+			return err
+		}
+		r.aead = aead
+		r.buf = make([]byte, segmentSize+16)
+		r.out = make([]byte, 0, segmentSize)
+	}
+
+	n, err := io.ReadFull(r.r, r.buf)
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		// A short segment must be the last one
+		return r.open(r.buf[:n], true)
+	case err != nil:
+		return truncated(err)
+	}
+	// A full segment is the last one when nothing follows
+	if r.open(r.buf, false) == nil {
+		return nil
+	}
+	return r.open(r.buf, true)
+}
+
+func (r *reader) open(sealed []byte, last bool) error {
+	plain, err := r.aead.Open(r.out[:0], nonce(r.counter, last), sealed, nil)
+	if err != nil {
+		return ErrDecrypt
+	}
+	if last {
+		// Data after the last segment was appended by someone else
+		var extra [1]byte
+		if n, _ := io.ReadFull(r.r, extra[:]); n > 0 {
+			return ErrDecrypt
+		}
+	}
+	r.counter++
+	r.plain = plain
+	r.last = last
+	return nil
+}
+
+// truncated turns the end of a payload before its last segment into an error
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %w", ErrDecrypt, io.ErrUnexpectedEOF)
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package e2e
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testKey(t *testing.T, secret string) *Key {
+	t.Helper()
+	key, err := ParseKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encrypt(t *testing.T, key *Key, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := key.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Uneven writes cross segment boundaries
+	for p := plain; len(p) > 0; {
+		n := min(len(p), 10000)
+		w.Write(p[:n])
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := testKey(t, "correct horse battery staple")
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3 * segmentSize} {
+		plain := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(plain)
+
+		sealed := encrypt(t, key, plain)
+		if int64(len(sealed)) != EncryptedSize(int64(size)) {
+			t.Errorf("size %d: encrypted to %d bytes, EncryptedSize() = %d", size, len(sealed), EncryptedSize(int64(size)))
+		}
+		got, err := io.ReadAll(key.NewReader(bytes.NewReader(sealed)))
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted %d bytes, error %v", size, len(got), err)
+		}
+	}
+}
+
+func TestTampering(t *testing.T) {
+	key := testKey(t, "correct horse battery staple")
+	plain := bytes.Repeat([]byte("payload "), segmentSize/4)
+	sealed := encrypt(t, key, plain)
+
+	flipped := bytes.Clone(sealed)
+	flipped[saltSize+100] ^= 1
+	segment := segmentSize + 16
+	// Dropping the last segment leaves a full segment not marked as last
+	tests := map[string][]byte{
+		"flipped bit": flipped,
+		"truncated":   sealed[:len(sealed)-1],
+		"dropped":     sealed[:saltSize+segment],
+		"appended":    append(bytes.Clone(sealed), 0),
+		"salt only":   sealed[:saltSize],
+	}
+	for name, data := range tests {
+		if _, err := io.ReadAll(key.NewReader(bytes.NewReader(data))); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: error = %v, want ErrDecrypt", name, err)
+		}
+	}
+
+	other := testKey(t, "another shared secret")
+	if other.ID() == key.ID() {
+		t.Error("Keys of different secrets share their ID")
+	}
+	if _, err := io.ReadAll(other.NewReader(bytes.NewReader(sealed))); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Decrypting with another key: error = %v, want ErrDecrypt", err)
+	}
+	if _, err := ParseKey("short"); err == nil {
+		t.Error("ParseKey() must reject short secrets")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	key := testKey(t, "correct horse battery staple")
+	plain := []byte("secret file contents")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/plain" {
+			w.Write(plain)
+			return
+		}
+		w.Header().Set(Header, key.ID())
+		ew, _ := key.NewWriter(w)
+		ew.Write(plain)
+		ew.Close()
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: NewTransport(nil, key)}
+	for _, path := range []string{"/encrypted", "/plain"} {
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("GET %s = %q, %v", path, got, err)
+		}
+	}
+
+	client = &http.Client{Transport: NewTransport(nil, testKey(t, "another shared secret"))}
+	if _, err := client.Get(ts.URL + "/encrypted"); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("GET with another key: error = %v, want ErrKeyMismatch", err)
+	}
+}
@@ -0,0 +1,51 @@
+package e2e
+
+import (
+	"io"
+	"net/http"
+)
+
+// Transport decrypts the responses of an http.RoundTripper encrypted by the server
+type Transport struct {
+	Base http.RoundTripper // Underlying transport, http.DefaultTransport if nil
+	Key  *Key
+}
+
+// NewTransport wraps base so encrypted response bodies are decrypted with key
+func NewTransport(base http.RoundTripper, key *Key) *Transport {
+	return &Transport{Base: base, Key: key}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	id := resp.Header.Get(Header)
+	if id == "" {
+		return resp, nil
+	}
+	if id != t.Key.ID() {
+		resp.Body.Close()
+		return nil, ErrKeyMismatch
+	}
+	if req.Method == http.MethodHead || (resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent) {
+		return resp, nil
+	}
+
+	resp.Body = &readCloser{Reader: t.Key.NewReader(resp.Body), Closer: resp.Body}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,282 @@
+package ftp_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/ftp"
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+)
+
+// newFTPServer serves a directory with the given files over FTP through the
+// handler of the file server
+func newFTPServer(t *testing.T, srv *server.Server, root string, config ftp.Config) string {
+	t.Helper()
+
+	for name, content := range map[string]string{
+		"a.txt":                 "hello ftp",
+		"dir/b.txt":             "bbb",
+		".ezft/sync-state.json": "{}",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv.SetLogger(zap.NewNop())
+	config.Addr = "127.0.0.1:0"
+	f := ftp.NewServer(srv.Handler(), config)
+	if err := f.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go f.Serve()
+	t.Cleanup(func() { f.Close() })
+	return f.Addr().String()
+}
+
+// ftpClient a minimal FTP client
+type ftpClient struct {
+	t         *testing.T
+	conn      net.Conn
+	text      *textproto.Conn
+	tlsConfig *tls.Config // Protects data connections when set
+}
+
+func dial(t *testing.T, addr string) *ftpClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &ftpClient{t: t, conn: conn, text: textproto.NewConn(conn)}
+	t.Cleanup(func() { c.text.Close() })
+	c.expect(220)
+	return c
+}
+
+// cmd sends a command and checks the code of the reply
+func (c *ftpClient) cmd(code int, format string, args ...any) string {
+	c.t.Helper()
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.expect(code)
+}
+
+func (c *ftpClient) expect(code int) string {
+	c.t.Helper()
+	got, msg, err := c.text.ReadResponse(0)
+	if err != nil && got == 0 {
+		c.t.Fatal(err)
+	}
+	if got != code {
+		c.t.Fatalf("Reply %d %q, want %d", got, msg, code)
+	}
+	return msg
+}
+
+func (c *ftpClient) login(user, password string) {
+	c.t.Helper()
+	c.cmd(331, "USER %s", user)
+	c.cmd(230, "PASS %s", password)
+}
+
+// data opens a data connection announced by EPSV
+func (c *ftpClient) data() net.Conn {
+	c.t.Helper()
+	msg := c.cmd(229, "EPSV")
+	port, err := strconv.Atoi(strings.TrimSuffix(msg[strings.Index(msg, "|||")+3:], "|)"))
+	if err != nil {
+		c.t.Fatalf("Invalid EPSV reply %q", msg)
+	}
+	host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if c.tlsConfig != nil {
+		return tls.Client(conn, c.tlsConfig)
+	}
+	return conn
+}
+
+// read runs a command receiving data, such as RETR or LIST
+func (c *ftpClient) read(format string, args ...any) string {
+	c.t.Helper()
+	conn := c.data()
+	defer conn.Close()
+	c.cmd(150, format, args...)
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.expect(226)
+	return string(data)
+}
+
+// write runs STOR, returning the final reply code
+func (c *ftpClient) write(name, content string) int {
+	c.t.Helper()
+	conn := c.data()
+	c.cmd(150, "STOR %s", name)
+	io.WriteString(conn, content)
+	conn.Close()
+	code, _, _ := c.text.ReadResponse(0)
+	return code
+}
+
+func TestFTPSession(t *testing.T) {
+	root := t.TempDir()
+	srv := server.NewServer(root, 0)
+	srv.SetAuth("user", "secret")
+	srv.SetUploadEnabled(true)
+	addr := newFTPServer(t, srv, root, ftp.Config{})
+
+	c := dial(t, addr)
+	c.cmd(530, "PWD")
+	c.cmd(331, "USER user")
+	c.cmd(530, "PASS wrong")
+	c.login("user", "secret")
+	c.cmd(200, "TYPE I")
+
+	// Browsing, internal files stay hidden
+	c.cmd(257, "PWD")
+	if got := c.read("NLST"); got != "a.txt\r\ndir\r\n" {
+		t.Errorf("NLST = %q", got)
+	}
+	c.cmd(250, "CWD dir")
+	if got := c.read("LIST -la"); !strings.HasPrefix(got, "-rw-r--r-- 1 ezft ezft            3 "+time.Now().UTC().Format("Jan _2 ")) || !strings.HasSuffix(got, " b.txt\r\n") {
+		t.Errorf("LIST = %q", got)
+	}
+	c.cmd(550, "CWD missing")
+	c.cmd(250, "CDUP")
+	c.cmd(550, "CWD /_ezft")
+
+	// Downloads, resumed with REST
+	if got := c.read("RETR a.txt"); got != "hello ftp" {
+		t.Errorf("RETR = %q", got)
+	}
+	c.cmd(350, "REST 6")
+	if got := c.read("RETR /a.txt"); got != "ftp" {
+		t.Errorf("RETR after REST = %q", got)
+	}
+	if got := c.cmd(213, "SIZE dir/b.txt"); got != "3" {
+		t.Errorf("SIZE = %q", got)
+	}
+	c.cmd(213, "MDTM a.txt")
+	c.cmd(550, "SIZE missing.txt")
+	c.cmd(550, "RETR /_ezft/manifest/")
+
+	// Uploads and deletes
+	if code := c.write("dir/new.txt", "uploaded"); code != 226 {
+		t.Errorf("STOR reply %d, want 226", code)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "dir", "new.txt")); string(data) != "uploaded" {
+		t.Errorf("Uploaded file = %q", data)
+	}
+	c.cmd(250, "DELE dir/new.txt")
+	if _, err := os.Stat(filepath.Join(root, "dir", "new.txt")); !os.IsNotExist(err) {
+		t.Error("File should be deleted")
+	}
+
+	c.cmd(502, "PORT 127,0,0,1,4,1")
+	c.cmd(425, "RETR a.txt")
+	c.cmd(221, "QUIT")
+}
+
+func TestFTPReadOnly(t *testing.T) {
+	// A single passive port, announced with PASV
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+
+	root := t.TempDir()
+	addr := newFTPServer(t, server.NewServer(root, 0), root, ftp.Config{MinPassivePort: port, MaxPassivePort: port})
+	c := dial(t, addr)
+	c.login("anonymous", "guest@example.com")
+
+	want := fmt.Sprintf("Entering Passive Mode (127,0,0,1,%d,%d)", port>>8, port&0xff)
+	if got := c.cmd(227, "PASV"); got != want {
+		t.Errorf("PASV = %q, want %q", got, want)
+	}
+	if code := c.write("new.txt", "data"); code != 550 {
+		t.Errorf("STOR reply %d, want 550", code)
+	}
+	if _, err := os.Stat(filepath.Join(root, "new.txt")); !os.IsNotExist(err) {
+		t.Error("Upload should be rejected when disabled")
+	}
+	c.cmd(550, "DELE a.txt")
+}
+
+func TestFTPS(t *testing.T) {
+	root := t.TempDir()
+	addr := newFTPServer(t, server.NewServer(root, 0), root, ftp.Config{TLS: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}})
+
+	c := dial(t, addr)
+	c.cmd(234, "AUTH TLS")
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	conn := tls.Client(c.conn, tlsConfig)
+	c.conn, c.text = conn, textproto.NewConn(conn)
+	c.cmd(200, "PBSZ 0")
+	c.cmd(200, "PROT P")
+	c.tlsConfig = tlsConfig
+	c.login("anonymous", "")
+
+	if got := c.read("RETR a.txt"); got != "hello ftp" {
+		t.Errorf("RETR over TLS = %q", got)
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	if low, high, err := ftp.ParsePortRange("30000-30100"); err != nil || low != 30000 || high != 30100 {
+		t.Errorf("ParsePortRange() = %d, %d, %v", low, high, err)
+	}
+	for _, s := range []string{"", "30000", "0-10", "200-100", "1-70000", "a-b"} {
+		if _, _, err := ftp.ParsePortRange(s); err == nil {
+			t.Errorf("ParsePortRange(%q) should fail", s)
+		}
+	}
+}
+
+// testCertificate returns a self-signed certificate
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ezft"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,154 @@
+// Package ftp serves files over passive-mode FTP and explicit FTPS for
+// clients which only speak FTP. It is a front end to the HTTP handler of the
+// file server: every command is turned into an in-process HTTP request, so
+// FTP shares authentication, upload rules and request logging with HTTP.
+package ftp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Config configures the FTP front end
+type Config struct {
+	Addr           string      // Address of control connections
+	MinPassivePort int         // Lowest port of data connections, any free port when 0
+	MaxPassivePort int         // Highest port of data connections
+	PublicIP       string      // IPv4 address given in PASV replies, the address clients connected to by default
+	TLS            *tls.Config // Offers AUTH TLS when set
+}
+
+// ParsePortRange parses a port range such as 30000-30100
+func ParsePortRange(s string) (int, int, error) {
+	from, to, ok := strings.Cut(s, "-")
+	low, err1 := strconv.Atoi(strings.TrimSpace(from))
+	high, err2 := strconv.Atoi(strings.TrimSpace(to))
+	if !ok || err1 != nil || err2 != nil || low <= 0 || high > 65535 || low > high {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return low, high, nil
+}
+
+// Server accepts FTP sessions, serving them through an HTTP handler
+type Server struct {
+	handler  http.Handler
+	config   Config
+	listener net.Listener
+	logger   *zap.Logger
+
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+	closed   bool
+}
+
+// NewServer creates a server translating FTP commands into requests of handler
+func NewServer(handler http.Handler, config Config) *Server {
+	return &Server{
+		handler:  handler,
+		config:   config,
+		logger:   zap.NewNop(),
+		sessions: make(map[*session]struct{}),
+	}
+}
+
+func (s *Server) SetLogger(logger *zap.Logger) {
+	s.logger = logger
+}
+
+// Listen listens for control connections on the configured address
+func (s *Server) Listen() error {
+	if s.config.PublicIP != "" {
+		if ip := net.ParseIP(s.config.PublicIP); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid public IPv4 address %q", s.config.PublicIP)
+		}
+	}
+	lis, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for FTP: %w", err)
+	}
+	s.listener = lis
+	return nil
+}
+
+// Addr returns the address of control connections
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve accepts sessions until Close
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		sess := newSession(s, conn)
+		if !s.track(sess, true) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer s.track(sess, false)
+			sess.serve()
+		}()
+	}
+}
+
+// Close stops accepting sessions and ends the open ones
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for sess := range s.sessions {
+		sess.close()
+	}
+	s.mu.Unlock()
+	return s.listener.Close()
+}
+
+// track adds or removes an open session, false once the server is closed
+func (s *Server) track(sess *session, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.sessions, sess)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	s.sessions[sess] = struct{}{}
+	return true
+}
+
+// listenPassive listens for a data connection on host, within the passive
+// port range when one is configured
+func (s *Server) listenPassive(host string) (net.Listener, error) {
+	low, high := s.config.MinPassivePort, s.config.MaxPassivePort
+	if low == 0 {
+		return net.Listen("tcp", net.JoinHostPort(host, "0"))
+	}
+
+	// Start at a random port so concurrent sessions rarely collide
+	n := high - low + 1
+	start := rand.IntN(n)
+	for i := range n {
+		port := low + (start+i)%n
+		lis, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err == nil {
+			return lis, nil
+		}
+	}
+	return nil, fmt.Errorf("no free passive port in %d-%d", low, high)
+}
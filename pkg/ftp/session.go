@@ -0,0 +1,719 @@
+package ftp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"go.uber.org/zap"
+)
+
+const (
+	idleTimeout = 5 * time.Minute  // Closes control connections without commands
+	dataTimeout = 30 * time.Second // Bounds TLS handshakes and waiting for data connections
+	maxLine     = 4096             // Longest command line
+	userAgent   = "ezft-ftp"       // User agent of the requests, telling FTP apart in the request log
+	apiPrefix   = "/_ezft/"        // Prefix of the ezft API of the handler, hidden from FTP
+	listPath    = apiPrefix + "list"
+)
+
+// errNoPassive is returned when a transfer is not preceded by PASV or EPSV
+var errNoPassive = errors.New("no passive data connection")
+
+// session one FTP control connection
+type session struct {
+	server  *Server
+	reader  *bufio.Reader
+	remote  string // Address of the client
+	passive net.Listener
+
+	user     string
+	password string
+	loggedIn bool
+	cwd      string // Current directory, slash separated and absolute
+	restart  int64  // Offset of the next transfer, set by REST
+	tlsOn    bool   // Whether the control connection is protected
+	protect  bool   // Whether data connections are protected (PROT P)
+
+	mu   sync.Mutex
+	ctrl net.Conn
+	data net.Conn // Data connection of the running transfer
+}
+
+func newSession(server *Server, conn net.Conn) *session {
+	return &session{
+		server: server,
+		reader: bufio.NewReaderSize(conn, maxLine),
+		remote: conn.RemoteAddr().String(),
+		cwd:    "/",
+		ctrl:   conn,
+	}
+}
+
+// serve runs commands until the client quits or the connection fails
+func (s *session) serve() {
+	defer s.close()
+	s.reply(220, "ezft FTP server ready")
+	for {
+		s.ctrl.SetReadDeadline(time.Now().Add(idleTimeout))
+		line, err := s.reader.ReadSlice('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), " ")
+		if !s.handle(strings.ToUpper(cmd), arg) {
+			return
+		}
+	}
+}
+
+// close ends the session, aborting a running transfer
+func (s *session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctrl.Close()
+	if s.data != nil {
+		s.data.Close()
+	}
+	if s.passive != nil {
+		s.passive.Close()
+	}
+}
+
+func (s *session) reply(code int, msg string) {
+	fmt.Fprintf(s.ctrl, "%d %s\r\n", code, msg)
+}
+
+// handle runs a command, false ends the session
+func (s *session) handle(cmd, arg string) bool {
+	switch cmd {
+	case "USER":
+		s.user, s.password, s.loggedIn = arg, "", false
+		s.reply(331, "Password required")
+	case "PASS":
+		s.login(arg)
+	case "AUTH":
+		return s.auth(arg)
+	case "PBSZ":
+		if !s.tlsOn {
+			s.reply(503, "Use AUTH TLS first")
+			return true
+		}
+		s.reply(200, "PBSZ=0")
+	case "PROT":
+		s.prot(arg)
+	case "FEAT":
+		s.feat()
+	case "SYST":
+		s.reply(215, "UNIX Type: L8")
+	case "NOOP":
+		s.reply(200, "OK")
+	case "OPTS":
+		if strings.EqualFold(arg, "UTF8 ON") {
+			s.reply(200, "Always in UTF8 mode")
+			return true
+		}
+		s.reply(501, "Option not understood")
+	case "QUIT":
+		s.reply(221, "Goodbye")
+		return false
+	default:
+		if !s.loggedIn {
+			s.reply(530, "Please login with USER and PASS")
+			return true
+		}
+		s.handleFile(cmd, arg)
+	}
+	return true
+}
+
+// handleFile runs a command of a logged in client
+func (s *session) handleFile(cmd, arg string) {
+	switch cmd {
+	case "PWD", "XPWD":
+		s.reply(257, `"`+strings.ReplaceAll(s.cwd, `"`, `""`)+`" is the current directory`)
+	case "CWD", "XCWD":
+		s.changeDir(arg)
+	case "CDUP", "XCUP":
+		s.changeDir("..")
+	case "TYPE":
+		// Files are always sent as they are, which clients expect from type I
+		// and which keeps ASCII transfers of text files intact on Unix
+		if t := strings.ToUpper(arg); t == "I" || t == "L 8" || strings.HasPrefix(t, "A") {
+			s.reply(200, "Type set to "+t)
+			return
+		}
+		s.reply(504, "Type not supported")
+	case "MODE":
+		s.acceptOnly(arg, "S")
+	case "STRU":
+		s.acceptOnly(arg, "F")
+	case "PASV":
+		s.pasv(false)
+	case "EPSV":
+		if strings.EqualFold(arg, "ALL") {
+			s.reply(200, "EPSV ALL accepted")
+			return
+		}
+		s.pasv(true)
+	case "PORT", "EPRT":
+		s.reply(502, "Active mode is not supported, use PASV or EPSV")
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			s.reply(501, "Invalid restart offset")
+			return
+		}
+		s.restart = offset
+		s.reply(350, fmt.Sprintf("Restarting at %d", offset))
+	case "LIST", "NLST", "RETR", "STOR":
+		// REST applies to the next transfer only
+		restart := s.restart
+		s.restart = 0
+		switch cmd {
+		case "LIST":
+			s.list(arg, true)
+		case "NLST":
+			s.list(arg, false)
+		case "RETR":
+			s.retr(arg, restart)
+		case "STOR":
+			s.stor(arg, restart)
+		}
+	case "DELE":
+		s.dele(arg)
+	case "SIZE":
+		s.size(arg)
+	case "MDTM":
+		s.mdtm(arg)
+	case "ABOR":
+		s.reply(225, "No transfer to abort")
+	default:
+		s.reply(502, "Command not implemented")
+	}
+}
+
+func (s *session) acceptOnly(arg, want string) {
+	if !strings.EqualFold(arg, want) {
+		s.reply(504, "Parameter not supported")
+		return
+	}
+	s.reply(200, "OK")
+}
+
+func (s *session) feat() {
+	features := []string{"EPSV", "MDTM", "PASV", "REST STREAM", "SIZE", "UTF8"}
+	if s.server.config.TLS != nil {
+		features = append(features, "AUTH TLS", "PBSZ", "PROT")
+	}
+	fmt.Fprintf(s.ctrl, "211-Features:\r\n")
+	for _, f := range features {
+		fmt.Fprintf(s.ctrl, " %s\r\n", f)
+	}
+	s.reply(211, "End")
+}
+
+// login checks the credentials with an authenticated request, any
+// credentials are accepted when the handler does not require authentication
+func (s *session) login(password string) {
+	if s.user == "" {
+		s.reply(503, "Login with USER first")
+		return
+	}
+	s.password = password
+	if resp := s.call(http.MethodGet, listPath+"/", nil, nil, nil); resp.status != http.StatusOK {
+		s.server.logger.Warn("ftp login failed",
+			zap.String("remoteAddr", s.remote),
+			zap.String("user", s.user),
+		)
+		s.password = ""
+		s.reply(530, "Login incorrect")
+		return
+	}
+
+	s.loggedIn = true
+	s.server.logger.Info("ftp login",
+		zap.String("remoteAddr", s.remote),
+		zap.String("user", s.user),
+		zap.Bool("tls", s.tlsOn),
+	)
+	s.reply(230, "Login successful")
+}
+
+// auth protects the control connection with TLS, the client logs in again
+func (s *session) auth(arg string) bool {
+	mech := strings.ToUpper(arg)
+	if s.server.config.TLS == nil || (mech != "TLS" && mech != "TLS-C" && mech != "SSL") {
+		s.reply(504, "AUTH mechanism not supported")
+		return true
+	}
+	if s.tlsOn {
+		s.reply(503, "Already using TLS")
+		return true
+	}
+	s.reply(234, "AUTH "+mech+" successful")
+
+	conn := tls.Server(s.ctrl, s.server.config.TLS)
+	conn.SetDeadline(time.Now().Add(dataTimeout))
+	if err := conn.Handshake(); err != nil {
+		s.server.logger.Debug("ftp TLS handshake failed",
+			zap.String("remoteAddr", s.remote),
+			zap.Error(err),
+		)
+		return false
+	}
+	conn.SetDeadline(time.Time{})
+
+	s.mu.Lock()
+	s.ctrl = conn
+	s.mu.Unlock()
+	s.reader = bufio.NewReaderSize(conn, maxLine)
+	s.tlsOn = true
+	s.user, s.password, s.loggedIn = "", "", false
+	return true
+}
+
+func (s *session) prot(arg string) {
+	if !s.tlsOn {
+		s.reply(503, "Use AUTH TLS first")
+		return
+	}
+	switch strings.ToUpper(arg) {
+	case "P":
+		s.protect = true
+	case "C":
+		s.protect = false
+	default:
+		s.reply(504, "Protection level not supported")
+		return
+	}
+	s.reply(200, "Protection level set to "+strings.ToUpper(arg))
+}
+
+// path returns the absolute path of the argument of a command
+func (s *session) path(arg string) string {
+	if !strings.HasPrefix(arg, "/") {
+		arg = s.cwd + "/" + arg
+	}
+	return path.Clean(arg)
+}
+
+// internal reports whether p belongs to the ezft API rather than the served files
+func internal(p string) bool {
+	return p+"/" == apiPrefix || strings.HasPrefix(p, apiPrefix)
+}
+
+func (s *session) changeDir(arg string) {
+	p := s.path(arg)
+	if internal(p) {
+		s.reply(550, "Permission denied")
+		return
+	}
+	if resp := s.call(http.MethodGet, listPath+p, nil, nil, nil); resp.status != http.StatusOK {
+		s.replyStatus(resp.status)
+		return
+	}
+	s.cwd = p
+	s.reply(250, "Directory changed to "+p)
+}
+
+// pasv opens the listener of the next data connection
+func (s *session) pasv(extended bool) {
+	s.closePassive()
+	host, _, _ := net.SplitHostPort(s.ctrl.LocalAddr().String())
+	lis, err := s.server.listenPassive(host)
+	if err != nil {
+		s.server.logger.Error("failed to open passive port", zap.Error(err))
+		s.reply(425, "Cannot open data connection")
+		return
+	}
+	s.mu.Lock()
+	s.passive = lis
+	s.mu.Unlock()
+
+	addr := lis.Addr().(*net.TCPAddr)
+	if extended {
+		s.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", addr.Port))
+		return
+	}
+	ip := addr.IP.To4()
+	if s.server.config.PublicIP != "" {
+		ip = net.ParseIP(s.server.config.PublicIP).To4()
+	}
+	if ip == nil {
+		s.closePassive()
+		s.reply(425, "Use EPSV over IPv6")
+		return
+	}
+	s.reply(227, fmt.Sprintf("Entering Passive Mode (%d,%d,%d,%d,%d,%d)", ip[0], ip[1], ip[2], ip[3], addr.Port>>8, addr.Port&0xff))
+}
+
+func (s *session) closePassive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.passive != nil {
+		s.passive.Close()
+		s.passive = nil
+	}
+}
+
+// dataConn accepts the data connection of the pending passive listener.
+// Only the host of the control connection may connect, other hosts could
+// otherwise steal transfers.
+func (s *session) dataConn() (net.Conn, error) {
+	s.mu.Lock()
+	lis := s.passive
+	s.passive = nil
+	s.mu.Unlock()
+	if lis == nil {
+		return nil, errNoPassive
+	}
+	defer lis.Close()
+
+	lis.(*net.TCPListener).SetDeadline(time.Now().Add(dataTimeout))
+	remoteHost, _, _ := net.SplitHostPort(s.remote)
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != remoteHost {
+			s.server.logger.Warn("ftp data connection from another host rejected",
+				zap.String("remoteAddr", conn.RemoteAddr().String()),
+				zap.String("controlAddr", s.remote),
+			)
+			conn.Close()
+			continue
+		}
+
+		s.mu.Lock()
+		s.data = conn
+		s.mu.Unlock()
+		return conn, nil
+	}
+}
+
+// closeData closes the data connection of a transfer
+func (s *session) closeData(conn net.Conn) error {
+	s.mu.Lock()
+	s.data = nil
+	s.mu.Unlock()
+	return conn.Close()
+}
+
+// openData accepts the data connection and tells the client the transfer
+// starts, protected data connections are negotiated after that like other
+// servers do
+func (s *session) openData() (net.Conn, error) {
+	conn, err := s.dataConn()
+	if err != nil {
+		return nil, err
+	}
+	s.reply(150, "Opening data connection")
+	if !s.protect {
+		return conn, nil
+	}
+
+	tlsConn := tls.Server(conn, s.server.config.TLS)
+	tlsConn.SetDeadline(time.Now().Add(dataTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		s.closeData(conn)
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	s.mu.Lock()
+	s.data = tlsConn
+	s.mu.Unlock()
+	return tlsConn, nil
+}
+
+// endTransfer closes the data connection and answers the transfer command
+func (s *session) endTransfer(conn net.Conn, err error) {
+	if closeErr := s.closeData(conn); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		s.server.logger.Debug("ftp transfer aborted",
+			zap.String("remoteAddr", s.remote),
+			zap.Error(err),
+		)
+		s.reply(426, "Transfer aborted")
+		return
+	}
+	s.reply(226, "Transfer complete")
+}
+
+func (s *session) list(arg string, long bool) {
+	// Options such as -la are ignored
+	arg = strings.TrimSpace(arg)
+	for strings.HasPrefix(arg, "-") {
+		_, arg, _ = strings.Cut(arg, " ")
+		arg = strings.TrimSpace(arg)
+	}
+	p := s.path(arg)
+	if internal(p) {
+		s.reply(550, "Permission denied")
+		return
+	}
+	if s.passive == nil {
+		s.reply(425, "Use PASV or EPSV first")
+		return
+	}
+
+	var buf bytes.Buffer
+	resp := s.call(http.MethodGet, listPath+p, nil, nil, func() (io.Writer, error) { return &buf, nil })
+	var m manifest.Manifest
+	if resp.status == http.StatusOK && json.Unmarshal(buf.Bytes(), &m) != nil {
+		resp.status = http.StatusInternalServerError
+	}
+	if resp.status != http.StatusOK {
+		s.closePassive()
+		s.replyStatus(resp.status)
+		return
+	}
+
+	conn, err := s.openData()
+	if err != nil {
+		s.reply(425, "Cannot open data connection")
+		return
+	}
+	w := bufio.NewWriter(conn)
+	now := time.Now()
+	for _, e := range m.Entries {
+		if long {
+			fmt.Fprintf(w, "%s\r\n", listLine(e, now))
+		} else {
+			fmt.Fprintf(w, "%s\r\n", e.Path)
+		}
+	}
+	s.endTransfer(conn, w.Flush())
+}
+
+// listLine formats an entry the way ls -l does, which FTP clients parse
+func listLine(e manifest.Entry, now time.Time) string {
+	mode := fs.FileMode(0644)
+	if e.Mode != 0 {
+		mode = e.Mode
+	}
+	if e.Dir {
+		mode = fs.ModeDir | 0755
+	}
+	layout := "Jan _2 15:04"
+	if mtime := e.ModTime.UTC(); mtime.After(now) || now.Sub(mtime) > 180*24*time.Hour {
+		layout = "Jan _2  2006"
+	}
+	return fmt.Sprintf("%s 1 ezft ezft %12d %s %s", mode, e.Size, e.ModTime.UTC().Format(layout), e.Path)
+}
+
+func (s *session) retr(arg string, restart int64) {
+	p := s.path(arg)
+	if internal(p) || p == "/" {
+		s.reply(550, "Not a regular file")
+		return
+	}
+	if s.passive == nil {
+		s.reply(425, "Use PASV or EPSV first")
+		return
+	}
+
+	header := http.Header{}
+	if restart > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", restart))
+	}
+	var conn net.Conn
+	resp := s.call(http.MethodGet, p, header, nil, func() (io.Writer, error) {
+		var err error
+		conn, err = s.openData()
+		return conn, err
+	})
+	if conn == nil {
+		s.closePassive()
+		if resp.err != nil {
+			s.reply(425, "Cannot open data connection")
+			return
+		}
+		s.replyStatus(resp.status)
+		return
+	}
+	s.endTransfer(conn, resp.err)
+}
+
+func (s *session) stor(arg string, restart int64) {
+	p := s.path(arg)
+	if internal(p) {
+		s.reply(550, "Permission denied")
+		return
+	}
+	if restart > 0 {
+		s.reply(554, "Restarting uploads is not supported")
+		return
+	}
+	if s.passive == nil {
+		s.reply(425, "Use PASV or EPSV first")
+		return
+	}
+
+	conn, err := s.openData()
+	if err != nil {
+		s.reply(425, "Cannot open data connection")
+		return
+	}
+	resp := s.call(http.MethodPut, p, nil, conn, nil)
+	closeErr := s.closeData(conn)
+	if resp.status != http.StatusCreated && resp.status != http.StatusNoContent {
+		s.replyStatus(resp.status)
+		return
+	}
+	if closeErr != nil {
+		s.reply(426, "Transfer aborted")
+		return
+	}
+	s.reply(226, "Transfer complete")
+}
+
+func (s *session) dele(arg string) {
+	p := s.path(arg)
+	if internal(p) {
+		s.reply(550, "Permission denied")
+		return
+	}
+	if resp := s.call(http.MethodDelete, p, nil, nil, nil); resp.status != http.StatusNoContent {
+		s.replyStatus(resp.status)
+		return
+	}
+	s.reply(250, "File deleted")
+}
+
+// stat returns the headers of the regular file at the argument of a command,
+// answering the client on failure
+func (s *session) stat(arg string) (http.Header, bool) {
+	p := s.path(arg)
+	if internal(p) || p == "/" {
+		s.reply(550, "Not a regular file")
+		return nil, false
+	}
+	resp := s.call(http.MethodHead, p, nil, nil, nil)
+	if resp.status != http.StatusOK {
+		s.replyStatus(resp.status)
+		return nil, false
+	}
+	return resp.header, true
+}
+
+func (s *session) size(arg string) {
+	header, ok := s.stat(arg)
+	if !ok {
+		return
+	}
+	s.reply(213, header.Get("Content-Length"))
+}
+
+func (s *session) mdtm(arg string) {
+	header, ok := s.stat(arg)
+	if !ok {
+		return
+	}
+	mtime, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		s.reply(550, "Modification time unknown")
+		return
+	}
+	s.reply(213, mtime.UTC().Format("20060102150405"))
+}
+
+// replyStatus answers a failed command with the reply matching the status of its request
+func (s *session) replyStatus(status int) {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusMethodNotAllowed:
+		s.reply(550, "Permission denied")
+	case http.StatusNotFound:
+		s.reply(550, "No such file or directory")
+	case http.StatusRequestedRangeNotSatisfiable:
+		s.reply(554, "Invalid restart offset")
+	default:
+		if status < http.StatusInternalServerError {
+			s.reply(550, "Requested action not taken")
+			return
+		}
+		s.reply(451, "Local error in processing")
+	}
+}
+
+// call serves a request for the path p through the handler with the
+// credentials of the session. The response body goes to the writer returned
+// by open once the status is successful, and is discarded otherwise.
+func (s *session) call(method, p string, header http.Header, body io.Reader, open func() (io.Writer, error)) *response {
+	u := &url.URL{Scheme: "http", Host: "ftp", Path: p}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return &response{status: http.StatusBadRequest}
+	}
+	if body != nil {
+		req.ContentLength = -1
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.RemoteAddr = s.remote
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+
+	resp := &response{header: make(http.Header), open: open}
+	s.server.handler.ServeHTTP(resp, req)
+	resp.WriteHeader(http.StatusOK)
+	return resp
+}
+
+// response an http.ResponseWriter streaming successful responses to a data connection
+type response struct {
+	header http.Header
+	status int
+	open   func() (io.Writer, error)
+	body   io.Writer // Destination of the body, nil to discard it
+	err    error     // Error opening or writing the body
+}
+
+func (r *response) Header() http.Header {
+	return r.header
+}
+
+func (r *response) WriteHeader(status int) {
+	if r.status != 0 {
+		return
+	}
+	r.status = status
+	if status >= 200 && status < 300 && r.open != nil {
+		r.body, r.err = r.open()
+	}
+}
+
+func (r *response) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.body == nil {
+		return len(b), nil
+	}
+	n, err := r.body.Write(b)
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,146 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Transfer outcomes reported to hooks
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// webhookTimeout upper bound of a webhook call
+const webhookTimeout = 30 * time.Second
+
+// Event the finished transfer a hook is run for
+type Event struct {
+	Status   string        `json:"status"` // StatusSuccess or StatusFailure
+	Type     string        `json:"type"`   // download, upload or sync
+	URL      string        `json:"url"`
+	Path     string        `json:"path"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+	Checksum string        `json:"checksum,omitempty"` // sha256 of the local file after a successful file transfer
+	Error    string        `json:"error,omitempty"`
+	Job      string        `json:"job,omitempty"` // ID of the daemon job
+}
+
+// Env returns the event as EZFT_* environment variables
+func (e *Event) Env() []string {
+	return []string{
+		"EZFT_STATUS=" + e.Status,
+		"EZFT_TYPE=" + e.Type,
+		"EZFT_URL=" + e.URL,
+		"EZFT_PATH=" + e.Path,
+		"EZFT_BYTES=" + strconv.FormatInt(e.Bytes, 10),
+		"EZFT_DURATION=" + strconv.FormatFloat(e.Duration.Seconds(), 'f', 3, 64),
+		"EZFT_CHECKSUM=" + e.Checksum,
+		"EZFT_ERROR=" + e.Error,
+		"EZFT_JOB=" + e.Job,
+	}
+}
+
+// Hooks what to run after a transfer finished
+type Hooks struct {
+	OnSuccess string `json:"on_success,omitempty"` // Shell command run after a successful transfer
+	OnFailure string `json:"on_failure,omitempty"` // Shell command run after a failed transfer
+	Webhook   string `json:"webhook,omitempty"`    // URL the event is posted to as JSON after every transfer
+}
+
+// Validate checks the webhook URL
+func (h *Hooks) Validate() error {
+	if h.Webhook == "" {
+		return nil
+	}
+	u, err := url.Parse(h.Webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %q", h.Webhook)
+	}
+	return nil
+}
+
+// Empty reports whether no hook is set
+func (h *Hooks) Empty() bool {
+	return h.OnSuccess == "" && h.OnFailure == "" && h.Webhook == ""
+}
+
+// HasCommand reports whether a shell command is set
+func (h *Hooks) HasCommand() bool {
+	return h.OnSuccess != "" || h.OnFailure != ""
+}
+
+// Run runs the command for the status of e, then calls the webhook, both are
+// attempted even if one of them fails
+func (h *Hooks) Run(ctx context.Context, e *Event) error {
+	command := h.OnFailure
+	if e.Status == StatusSuccess {
+		command = h.OnSuccess
+	}
+
+	var errs []error
+	if command != "" {
+		if err := runCommand(ctx, command, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if h.Webhook != "" {
+		if err := callWebhook(ctx, h.Webhook, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runCommand runs command through the shell with the event in its environment
+func runCommand(ctx context.Context, command string, e *Event) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), e.Env()...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("hook command failed: %w: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// callWebhook posts the event as JSON to the webhook URL
+func callWebhook(ctx context.Context, webhook string, e *Event) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook failed: server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHooksRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands use sh")
+	}
+
+	var received []Event
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+		received = append(received, e)
+	}))
+	defer ts.Close()
+
+	out := filepath.Join(t.TempDir(), "out")
+	hooks := &Hooks{
+		OnSuccess: "echo \"$EZFT_STATUS $EZFT_PATH $EZFT_BYTES $EZFT_DURATION $EZFT_CHECKSUM\" > " + out,
+		OnFailure: "echo \"$EZFT_STATUS $EZFT_ERROR\" > " + out,
+		Webhook:   ts.URL,
+	}
+	event := &Event{
+		Status:   StatusSuccess,
+		Type:     "download",
+		URL:      "http://host/a.iso",
+		Path:     "/data/a.iso",
+		Bytes:    1024,
+		Duration: 1500 * time.Millisecond,
+		Checksum: "abc",
+	}
+	if err := hooks.Run(context.Background(), event); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if content, _ := os.ReadFile(out); strings.TrimSpace(string(content)) != "success /data/a.iso 1024 1.500 abc" {
+		t.Errorf("Success hook saw %q", content)
+	}
+
+	event.Status, event.Error = StatusFailure, "connection reset"
+	if err := hooks.Run(context.Background(), event); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if content, _ := os.ReadFile(out); strings.TrimSpace(string(content)) != "failure connection reset" {
+		t.Errorf("Failure hook saw %q", content)
+	}
+
+	if len(received) != 2 || received[0].Status != StatusSuccess || received[1].Error != "connection reset" {
+		t.Errorf("Expected a webhook call per transfer, got %+v", received)
+	}
+}
+
+func TestHooksRunErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands use sh")
+	}
+
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	hooks := &Hooks{OnSuccess: "echo broken >&2; exit 3", Webhook: ts.URL}
+	err := hooks.Run(context.Background(), &Event{Status: StatusSuccess})
+	if err == nil || !strings.Contains(err.Error(), "broken") || !strings.Contains(err.Error(), "status 500") {
+		t.Errorf("Expected both the command and the webhook to fail, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the webhook to be called despite the failed command, got %d calls", calls)
+	}
+	if (&Hooks{}).Run(context.Background(), &Event{Status: StatusFailure}) != nil {
+		t.Error("Expected no error without hooks")
+	}
+}
@@ -0,0 +1,95 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChunkFormat identifies chunk manifests and their version
+const ChunkFormat = "ezft-chunks/1"
+
+const (
+	// MinChunkSize smallest chunk size of a chunk manifest
+	MinChunkSize = 64 * 1024
+	// MaxChunkSize largest chunk size of a chunk manifest
+	MaxChunkSize = 128 * 1024 * 1024
+	// DefaultChunkSize chunk size of manifests unless requested otherwise
+	DefaultChunkSize = 1024 * 1024
+)
+
+// ErrChunkMismatch is returned when data does not match the hash of its chunk
+var ErrChunkMismatch = errors.New("chunk does not match its hash")
+
+// Chunks a chunk manifest: the SHA-256 of every chunk of a file, so each can
+// be verified on its own while downloading and when resuming
+type Chunks struct {
+	Format    string   `json:"format"`
+	Name      string   `json:"name,omitempty"` // Base name of the file, informational
+	Size      int64    `json:"size"`
+	ChunkSize int64    `json:"chunk_size"`
+	SHA256    string   `json:"sha256"` // Hash of the whole file
+	Hashes    []string `json:"hashes"` // Hash of each chunk, the last one may be shorter
+}
+
+// HashChunks builds the chunk manifest of the size bytes read from r
+func HashChunks(r io.Reader, size, chunkSize int64) (*Chunks, error) {
+	if chunkSize < MinChunkSize || chunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("chunk size must be between %d and %d", MinChunkSize, MaxChunkSize)
+	}
+	c := &Chunks{Format: ChunkFormat, Size: size, ChunkSize: chunkSize}
+	whole := sha256.New()
+	for offset := int64(0); offset < size; offset += chunkSize {
+		h := sha256.New()
+		n := min(chunkSize, size-offset)
+		if _, err := io.CopyN(io.MultiWriter(h, whole), r, n); err != nil {
+			return nil, fmt.Errorf("failed to read chunk %d: %w", len(c.Hashes), err)
+		}
+		c.Hashes = append(c.Hashes, hex.EncodeToString(h.Sum(nil)))
+	}
+	c.SHA256 = hex.EncodeToString(whole.Sum(nil))
+	return c, nil
+}
+
+// ReadChunks decodes and checks a chunk manifest
+func ReadChunks(r io.Reader) (*Chunks, error) {
+	var c Chunks
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, fmt.Errorf("invalid chunk manifest: %w", err)
+	}
+	switch {
+	case c.Format != ChunkFormat:
+		return nil, fmt.Errorf("unsupported chunk manifest format %q", c.Format)
+	case c.ChunkSize < MinChunkSize || c.ChunkSize > MaxChunkSize || c.Size < 0:
+		return nil, errors.New("invalid chunk manifest sizes")
+	case int64(len(c.Hashes)) != (c.Size+c.ChunkSize-1)/c.ChunkSize:
+		return nil, fmt.Errorf("chunk manifest has %d hashes for %d bytes", len(c.Hashes), c.Size)
+	}
+	return &c, nil
+}
+
+// Range returns the first and last byte of chunk i
+func (c *Chunks) Range(i int) (int64, int64) {
+	start := int64(i) * c.ChunkSize
+	return start, min(start+c.ChunkSize, c.Size) - 1
+}
+
+// VerifyAt checks chunk i of the file read from r
+func (c *Chunks) VerifyAt(r io.ReaderAt, i int) error {
+	if i < 0 || i >= len(c.Hashes) {
+		return fmt.Errorf("chunk %d out of range", i)
+	}
+	start, end := c.Range(i)
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, start, end-start+1)); err != nil {
+		return err
+	}
+	// A short read leaves the hash of the data read so far, which mismatches
+	if hex.EncodeToString(h.Sum(nil)) != c.Hashes[i] {
+		return fmt.Errorf("chunk %d: %w", i, ErrChunkMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestHashChunks(t *testing.T) {
+	data := make([]byte, 2*MinChunkSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	c, err := HashChunks(bytes.NewReader(data), int64(len(data)), MinChunkSize)
+	if err != nil {
+		t.Fatalf("HashChunks() error = %v", err)
+	}
+	if len(c.Hashes) != 3 || c.Format != ChunkFormat {
+		t.Fatalf("Unexpected manifest: %d hashes, format %q", len(c.Hashes), c.Format)
+	}
+	if start, end := c.Range(2); start != 2*MinChunkSize || end != int64(len(data))-1 {
+		t.Errorf("Range(2) = %d, %d", start, end)
+	}
+
+	// Round trip through JSON
+	encoded, _ := json.Marshal(c)
+	c, err = ReadChunks(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("ReadChunks() error = %v", err)
+	}
+	for i := range c.Hashes {
+		if err := c.VerifyAt(bytes.NewReader(data), i); err != nil {
+			t.Errorf("VerifyAt(%d) error = %v", i, err)
+		}
+	}
+
+	// Corrupt and truncated chunks are detected
+	corrupt := bytes.Clone(data)
+	corrupt[MinChunkSize+5] ^= 1
+	if err := c.VerifyAt(bytes.NewReader(corrupt), 1); !errors.Is(err, ErrChunkMismatch) {
+		t.Errorf("VerifyAt() of a corrupt chunk error = %v", err)
+	}
+	if err := c.VerifyAt(bytes.NewReader(data[:len(data)-1]), 2); !errors.Is(err, ErrChunkMismatch) {
+		t.Errorf("VerifyAt() of a truncated chunk error = %v", err)
+	}
+	if err := c.VerifyAt(bytes.NewReader(corrupt), 0); err != nil {
+		t.Errorf("VerifyAt() of an intact chunk error = %v", err)
+	}
+}
+
+func TestReadChunksInvalid(t *testing.T) {
+	for _, s := range []string{
+		`not json`,
+		`{"format":"ezft-chunks/2","size":1,"chunk_size":65536,"hashes":["a"]}`,
+		`{"format":"ezft-chunks/1","size":1,"chunk_size":1,"hashes":["a"]}`,
+		`{"format":"ezft-chunks/1","size":70000,"chunk_size":65536,"hashes":["a"]}`,
+	} {
+		if _, err := ReadChunks(bytes.NewReader([]byte(s))); err == nil {
+			t.Errorf("ReadChunks(%s) should fail", s)
+		}
+	}
+	if _, err := HashChunks(bytes.NewReader(nil), 0, 1024); err == nil {
+		t.Error("HashChunks() with a too small chunk size should fail")
+	}
+}
@@ -0,0 +1,269 @@
+package manifest
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// listBatch number of directory entries read at once
+const listBatch = 1024
+
+// Sort orders of directory listings
+const (
+	SortName  = "name"  // By name
+	SortSize  = "size"  // By size, then name
+	SortMTime = "mtime" // By modification time, then name
+	SortNone  = "none"  // In directory order, streamed without buffering
+)
+
+// ListOptions selects the page and order of a directory listing
+type ListOptions struct {
+	Sort    string // Sort order, SortName if empty
+	Reverse bool   // Descending order
+	After   string // Continuation token of the previous page, the page starts after it
+	Offset  int    // Entries skipped
+	Limit   int    // Maximum entries returned, 0 for all
+}
+
+// listToken is the position a listing continues from, encoded in the
+// continuation token: the last entry listed in sorted orders, the number of
+// entries listed in directory order
+type listToken struct {
+	Sort    string `json:"s"`
+	Reverse bool   `json:"r,omitempty"`
+	Path    string `json:"p,omitempty"`
+	Size    int64  `json:"z,omitempty"`
+	MTime   int64  `json:"t,omitempty"`
+	Offset  int    `json:"o,omitempty"`
+}
+
+// Validate checks the sort order, page bounds and continuation token
+func (o ListOptions) Validate() error {
+	switch o.Sort {
+	case "", SortName, SortSize, SortMTime, SortNone:
+	default:
+		return fmt.Errorf("unknown sort order %q", o.Sort)
+	}
+	if o.Offset < 0 || o.Limit < 0 {
+		return errors.New("offset and limit must not be negative")
+	}
+	_, err := o.after()
+	return err
+}
+
+// sortOrder returns the sort order, SortName if not set
+func (o ListOptions) sortOrder() string {
+	if o.Sort == "" {
+		return SortName
+	}
+	return o.Sort
+}
+
+// after decodes the continuation token, nil if there is none
+func (o ListOptions) after() (*listToken, error) {
+	if o.After == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(o.After)
+	if err != nil {
+		return nil, errors.New("invalid continuation token")
+	}
+	var token listToken
+	if err := json.Unmarshal(b, &token); err != nil {
+		return nil, errors.New("invalid continuation token")
+	}
+	if token.Sort != o.sortOrder() || token.Reverse != o.Reverse {
+		return nil, errors.New("continuation token of another order")
+	}
+	return &token, nil
+}
+
+// encode returns the continuation token
+func (t listToken) encode() string {
+	b, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// less reports whether a sorts before b in the order of the options
+func (o ListOptions) less(a, b Entry) bool {
+	if o.Reverse {
+		a, b = b, a
+	}
+	switch o.Sort {
+	case SortSize:
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	case SortMTime:
+		if !a.ModTime.Equal(b.ModTime) {
+			return a.ModTime.Before(b.ModTime)
+		}
+	}
+	return a.Path < b.Path
+}
+
+// ListDir calls fn with the entries directly below dir as List returns them,
+// in the order and page of opts, and returns the continuation token of the
+// next page, empty when the listing is complete. The directory is read in
+// batches: unsorted listings are streamed and sorted pages only keep
+// offset+limit entries, as pages continuing from a token are ordered after
+// its entry instead of counted from the start. Whole sorted listings are
+// held in memory.
+func ListDir(dir string, opts ListOptions, fn func(Entry) error) (string, error) {
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+	after, _ := opts.after()
+	f, err := os.Open(dir)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if opts.Sort == SortNone {
+		skip := opts.Offset
+		if after != nil {
+			skip += after.Offset
+		}
+		listed, left, more := skip, opts.Limit, false
+		err := readEntries(f, dir, func(e Entry) error {
+			if skip > 0 {
+				skip--
+				return nil
+			}
+			if opts.Limit > 0 && left == 0 {
+				more = true
+				return io.EOF
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+			listed++
+			left--
+			return nil
+		})
+		if err != nil || !more {
+			return "", err
+		}
+		return listToken{Sort: SortNone, Offset: listed}.encode(), nil
+	}
+
+	var last Entry
+	if after != nil {
+		last = Entry{Path: after.Path, Size: after.Size, ModTime: time.Unix(0, after.MTime)}
+	}
+	page := &entryHeap{opts: opts}
+	// One more entry than the page tells whether another page follows
+	keep := opts.Offset + opts.Limit + 1
+	err = readEntries(f, dir, func(e Entry) error {
+		if after != nil && !opts.less(last, e) {
+			return nil
+		}
+		if opts.Limit == 0 {
+			page.entries = append(page.entries, e)
+			return nil
+		}
+		if len(page.entries) < keep {
+			heap.Push(page, e)
+		} else if opts.less(e, page.entries[0]) {
+			// The last entry of the page is replaced by one sorting before it
+			page.entries[0] = e
+			heap.Fix(page, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Slice(page.entries, func(i, j int) bool {
+		return opts.less(page.entries[i], page.entries[j])
+	})
+	if opts.Offset >= len(page.entries) {
+		return "", nil
+	}
+	entries := page.entries[opts.Offset:]
+	more := opts.Limit > 0 && len(entries) > opts.Limit
+	if more {
+		entries = entries[:opts.Limit]
+	}
+	for _, e := range entries {
+		if err := fn(e); err != nil {
+			return "", err
+		}
+	}
+	if !more {
+		return "", nil
+	}
+	e := entries[len(entries)-1]
+	return listToken{
+		Sort:    opts.sortOrder(),
+		Reverse: opts.Reverse,
+		Path:    e.Path,
+		Size:    e.Size,
+		MTime:   e.ModTime.UnixNano(),
+	}.encode(), nil
+}
+
+// readEntries calls fn with the listed entries of the open directory f in
+// directory order, fn ends the listing early by returning io.EOF
+func readEntries(f *os.File, dir string, fn func(Entry) error) error {
+	for {
+		batch, err := f.ReadDir(listBatch)
+		for _, d := range batch {
+			if IsInternal(d.Name()) {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(dir, d.Name()))
+			if err != nil {
+				continue
+			}
+			var e Entry
+			switch {
+			case info.IsDir():
+				e = Entry{Path: d.Name(), ModTime: info.ModTime(), Dir: true}
+			case info.Mode().IsRegular():
+				e = NewEntry(d.Name(), info)
+			default:
+				continue
+			}
+			if err := fn(e); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// entryHeap keeps the first entries of a sorted page, the root is the entry
+// sorting last so that it is the first to be replaced
+type entryHeap struct {
+	opts    ListOptions
+	entries []Entry
+}
+
+func (h *entryHeap) Len() int           { return len(h.entries) }
+func (h *entryHeap) Less(i, j int) bool { return h.opts.less(h.entries[j], h.entries[i]) }
+func (h *entryHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *entryHeap) Push(x any)         { h.entries = append(h.entries, x.(Entry)) }
+func (h *entryHeap) Pop() any {
+	e := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return e
+}
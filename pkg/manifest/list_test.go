@@ -0,0 +1,179 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestListDir(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"a.txt":          "aaa",
+		"b.txt":          "b",
+		"c.txt":          "cc",
+		"d.txt":          "dddd",
+		"e.bin.ezft.tmp": "partial",
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want string
+	}{
+		{"by name", ListOptions{}, "a.txt,b.txt,c.txt,d.txt"},
+		{"by size", ListOptions{Sort: SortSize}, "b.txt,c.txt,a.txt,d.txt"},
+		{"reversed", ListOptions{Reverse: true}, "d.txt,c.txt,b.txt,a.txt"},
+		{"first page", ListOptions{Limit: 2}, "a.txt,b.txt"},
+		{"second page", ListOptions{Offset: 2, Limit: 2}, "c.txt,d.txt"},
+		{"page by size", ListOptions{Sort: SortSize, Reverse: true, Offset: 1, Limit: 2}, "a.txt,c.txt"},
+		{"past the end", ListOptions{Offset: 10, Limit: 2}, ""},
+		{"offset only", ListOptions{Offset: 3}, "d.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			_, err := ListDir(root, tt.opts, func(e Entry) error {
+				names = append(names, e.Path)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("ListDir() error = %v", err)
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("ListDir() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	n := 0
+	_, err := ListDir(root, ListOptions{Sort: SortNone, Offset: 1, Limit: 2}, func(e Entry) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Errorf("Unsorted page: %d entries, error %v, want 2", n, err)
+	}
+
+	if _, err := ListDir(root, ListOptions{Sort: "color"}, func(Entry) error { return nil }); err == nil {
+		t.Error("Expected error for an unknown sort order")
+	}
+}
+
+func TestListDirContinuation(t *testing.T) {
+	root := t.TempDir()
+	for i := range 25 {
+		name := filepath.Join(root, fmt.Sprintf("f%02d", i))
+		if err := os.WriteFile(name, make([]byte, i%7), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, opts := range []ListOptions{
+		{Limit: 4},
+		{Sort: SortSize, Limit: 3},
+		{Sort: SortMTime, Reverse: true, Limit: 5},
+		{Sort: SortNone, Limit: 6},
+	} {
+		// Every entry is listed once, in the order of the whole listing
+		var want []string
+		whole := opts
+		whole.Limit = 0
+		if _, err := ListDir(root, whole, func(e Entry) error {
+			want = append(want, e.Path)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		pages := 0
+		for {
+			n := 0
+			next, err := ListDir(root, opts, func(e Entry) error {
+				got = append(got, e.Path)
+				n++
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%+v: ListDir() error = %v", opts, err)
+			}
+			if n > opts.Limit {
+				t.Fatalf("%+v: page of %d entries", opts, n)
+			}
+			pages++
+			if next == "" {
+				break
+			}
+			opts.After = next
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%+v: pages = %v, want %v", opts, got, want)
+		}
+		if wantPages := (25 + opts.Limit - 1) / opts.Limit; pages != wantPages {
+			t.Errorf("%+v: %d pages, want %d", opts, pages, wantPages)
+		}
+	}
+
+	next, _ := ListDir(root, ListOptions{Limit: 2}, func(Entry) error { return nil })
+	for _, opts := range []ListOptions{
+		{Sort: SortSize, After: next},
+		{Reverse: true, After: next},
+		{After: "not a token"},
+	} {
+		if _, err := ListDir(root, opts, func(Entry) error { return nil }); err == nil {
+			t.Errorf("%+v: expected error for a token of another listing", opts)
+		}
+	}
+}
+
+// Sorted pages keep offset+limit entries, however deep in the listing they
+// are and however many entries the directory holds
+func TestListDirMemory(t *testing.T) {
+	root := t.TempDir()
+	const count = 10000
+	for i := range count {
+		f, err := os.Create(filepath.Join(root, fmt.Sprintf("file-with-a-long-name-%05d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	// Live heap while the page is listed, once the entries read are garbage
+	inUse := func(opts ListOptions) uint64 {
+		var before, during runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		measured := false
+		if _, err := ListDir(root, opts, func(Entry) error {
+			if !measured {
+				runtime.GC()
+				runtime.ReadMemStats(&during)
+				measured = true
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return during.HeapAlloc - min(during.HeapAlloc, before.HeapAlloc)
+	}
+
+	whole := inUse(ListOptions{})
+	first := inUse(ListOptions{Limit: 10})
+	var next string
+	if next, _ = ListDir(root, ListOptions{Offset: count / 2, Limit: 10}, func(Entry) error { return nil }); next == "" {
+		t.Fatal("no continuation token in the middle of the listing")
+	}
+	deep := inUse(ListOptions{After: next, Limit: 10})
+	if first > whole/4 || deep > whole/4 {
+		t.Errorf("heap of a page: first %d bytes, deep %d bytes, whole listing %d bytes", first, deep, whole)
+	}
+}
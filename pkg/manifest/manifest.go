@@ -0,0 +1,196 @@
+package manifest
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry describes a file of a directory tree
+type Entry struct {
+	Path    string      `json:"path"`           // Slash separated path relative to the tree root
+	Size    int64       `json:"size"`           // File size, 0 for symbolic links
+	ModTime time.Time   `json:"mtime"`          // Modification time
+	Mode    fs.FileMode `json:"mode,omitempty"` // Permission bits, 0 if unknown
+	Link    string      `json:"link,omitempty"` // Target of a symbolic link, empty for regular files
+	Dir     bool        `json:"dir,omitempty"`  // Directory, only listed by List
+}
+
+// NewEntry describes the regular file at path p with the given file info.
+// Permissions are left unknown on Windows, which has no POSIX modes
+func NewEntry(p string, info fs.FileInfo) Entry {
+	e := Entry{Path: p, Size: info.Size(), ModTime: info.ModTime()}
+	if runtime.GOOS != "windows" {
+		e.Mode = info.Mode().Perm()
+	}
+	return e
+}
+
+// IsLink reports whether the entry is a symbolic link
+func (e Entry) IsLink() bool {
+	return e.Link != ""
+}
+
+// LinkInTree reports whether the entry is a symbolic link with a relative
+// target that stays inside the tree
+func (e Entry) LinkInTree() bool {
+	if !e.IsLink() || path.IsAbs(e.Link) || filepath.IsAbs(e.Link) {
+		return false
+	}
+	return filepath.IsLocal(path.Join(path.Dir(e.Path), e.Link))
+}
+
+// LinkInRoot reports whether the symbolic link of the entry, created in the
+// tree at root, resolves inside root on disk. Unlike LinkInTree it follows the
+// links already in the tree, through which a target lexically inside the tree
+// can lead out of it, such as e to .. in a directory d linked to the root.
+func (e Entry) LinkInRoot(root string) (bool, error) {
+	if !e.LinkInTree() {
+		return false, nil
+	}
+	realRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false, err
+	}
+	if realRoot, err = filepath.EvalSymlinks(realRoot); err != nil {
+		return false, err
+	}
+	// Not joined, which would drop the directories before .. unresolved
+	dir := filepath.Join(root, filepath.FromSlash(path.Dir(e.Path)))
+	target, err := evalExisting(dir + string(filepath.Separator) + filepath.FromSlash(e.Link))
+	if err != nil {
+		return false, err
+	}
+	if target, err = filepath.Abs(target); err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(realRoot, target)
+	return err == nil && filepath.IsLocal(rel), nil
+}
+
+// evalExisting returns name with the symbolic links of its longest existing
+// part resolved, the missing rest appended as it is
+func evalExisting(name string) (string, error) {
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(name)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		name = strings.TrimRight(name, string(filepath.Separator))
+		i := strings.LastIndexByte(name, filepath.Separator)
+		if i < 0 {
+			return "", err
+		}
+		rest = append([]string{name[i+1:]}, rest...)
+		name = name[:i+1]
+	}
+}
+
+// Manifest listing of all files of a directory tree
+type Manifest struct {
+	Entries []Entry `json:"entries"`
+	Next    string  `json:"next,omitempty"` // Continuation token of the next page of a listing
+}
+
+// IsInternal reports whether a file name belongs to ezft bookkeeping
+// (state directories, temporary and resume files) and must not be synced
+func IsInternal(name string) bool {
+	return strings.HasPrefix(name, ".ezft") ||
+		strings.HasSuffix(name, ".ezft.tmp") ||
+		strings.HasSuffix(name, ".ezft.token") ||
+		strings.HasSuffix(name, ".ezft.etag") ||
+		strings.HasSuffix(name, ".ezft.lock") ||
+		strings.HasSuffix(name, ".ezft.verified") ||
+		strings.HasSuffix(name, ".failed_chunks.json")
+}
+
+// Scan walks root and returns the manifest of all regular files and symbolic
+// links below it, links are listed with their target and never followed
+func Scan(root string) (*Manifest, error) {
+	m := &Manifest{Entries: []Entry{}}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		if IsInternal(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		link := d.Type()&fs.ModeSymlink != 0
+		if !d.Type().IsRegular() && !link {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if link {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			m.Entries = append(m.Entries, Entry{
+				Path:    filepath.ToSlash(rel),
+				ModTime: info.ModTime(),
+				Link:    filepath.ToSlash(target),
+			})
+			return nil
+		}
+		m.Entries = append(m.Entries, NewEntry(filepath.ToSlash(rel), info))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(m.Entries, func(i, j int) bool {
+		return m.Entries[i].Path < m.Entries[j].Path
+	})
+	return m, nil
+}
+
+// List returns the entries directly below dir as clients browsing the tree
+// see them: directories included and symbolic links followed, broken links
+// and internal files left out
+func List(dir string) (*Manifest, error) {
+	m := &Manifest{Entries: []Entry{}}
+	_, err := ListDir(dir, ListOptions{}, func(e Entry) error {
+		m.Entries = append(m.Entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Map indexes the manifest entries by path
+func (m *Manifest) Map() map[string]Entry {
+	entries := make(map[string]Entry, len(m.Entries))
+	for _, e := range m.Entries {
+		entries[e.Path] = e
+	}
+	return entries
+}
@@ -0,0 +1,224 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestIsInternal(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{".ezft", true},
+		{".ezft-upload-123", true},
+		{"file.iso.ezft.tmp", true},
+		{"file.iso.ezft.token", true},
+		{"file.iso.ezft.etag", true},
+		{"file.iso.ezft.lock", true},
+		{"file.iso.ezft.verified", true},
+		{"file.iso.failed_chunks.json", true},
+		{"file.iso", false},
+		{"ezft.yaml", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsInternal(tt.name); got != tt.want {
+			t.Errorf("IsInternal(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"a.txt":                 "a",
+		"dir/b.txt":             "bb",
+		"dir/sub/c.txt":         "ccc",
+		".ezft/sync-state.json": "{}",
+		"dir/d.bin.ezft.tmp":    "partial",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	m, err := Scan(root)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	want := []Entry{
+		{Path: "a.txt", Size: 1},
+		{Path: "dir/b.txt", Size: 2},
+		{Path: "dir/sub/c.txt", Size: 3},
+	}
+	if len(m.Entries) != len(want) {
+		t.Fatalf("Expected %d entries, got %d: %+v", len(want), len(m.Entries), m.Entries)
+	}
+	for i, e := range m.Entries {
+		if e.Path != want[i].Path || e.Size != want[i].Size {
+			t.Errorf("Entry %d = %s (%d), want %s (%d)", i, e.Path, e.Size, want[i].Path, want[i].Size)
+		}
+		if e.ModTime.IsZero() {
+			t.Errorf("Entry %s has no modification time", e.Path)
+		}
+	}
+
+	entries := m.Map()
+	if _, ok := entries["dir/b.txt"]; !ok {
+		t.Error("Map() should contain dir/b.txt")
+	}
+}
+
+func TestScanModesAndLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX modes and symbolic links are not supported")
+	}
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "run.sh"), []byte("#!/bin/sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(root, "run.sh"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"dir/up": "../run.sh", "abs": "/etc/passwd", "loop": "dir"} {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := Scan(root)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	entries := m.Map()
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %+v", m.Entries)
+	}
+	if e := entries["run.sh"]; e.Mode != 0750 || e.IsLink() {
+		t.Errorf("run.sh = %+v, want mode 0750", e)
+	}
+
+	tests := []struct {
+		path   string
+		link   string
+		inTree bool
+	}{
+		{"dir/up", "../run.sh", true},
+		{"abs", "/etc/passwd", false},
+		{"loop", "dir", true},
+	}
+	for _, tt := range tests {
+		e := entries[tt.path]
+		if e.Link != tt.link || e.Size != 0 {
+			t.Errorf("%s = %+v, want link to %s", tt.path, e, tt.link)
+		}
+		if e.LinkInTree() != tt.inTree {
+			t.Errorf("%s LinkInTree() = %v, want %v", tt.path, e.LinkInTree(), tt.inTree)
+		}
+	}
+}
+
+func TestLinkInRoot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Symbolic links need privileges on Windows")
+	}
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "sub", "deep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// d is the root itself, sd the directory sub
+	if err := os.Symlink(".", filepath.Join(root, "d")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(root, "sd")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		link   string
+		inRoot bool
+	}{
+		{"up", "sub/deep/..", true},
+		{"d/e", "..", false},
+		{"d/d/e", "../sub", false},
+		{"x", "d/..", false},
+		{"x", "sd/..", true},
+		{"sd/x", "..", true},
+		{"sd/x", "../..", false},
+		{"x", "missing/..", true},
+		{"new/dir/x", "../../sub", true},
+		{"abs", "/etc", false},
+	}
+	for _, tt := range tests {
+		got, err := Entry{Path: tt.path, Link: tt.link}.LinkInRoot(root)
+		if err != nil {
+			t.Errorf("%s -> %s: LinkInRoot() error = %v", tt.path, tt.link, err)
+		}
+		if got != tt.inRoot {
+			t.Errorf("%s -> %s: LinkInRoot() = %v, want %v", tt.path, tt.link, got, tt.inRoot)
+		}
+	}
+}
+
+func TestScanMissingRoot(t *testing.T) {
+	if _, err := Scan(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected error for missing root")
+	}
+}
+
+func TestList(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"a.txt":            "a",
+		"dir/b.txt":        "bb",
+		".ezft/state.json": "{}",
+		"c.bin.ezft.tmp":   "partial",
+		"dir/sub/d.txt":    "ddd",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		os.Symlink("a.txt", filepath.Join(root, "link"))
+		os.Symlink("missing", filepath.Join(root, "broken"))
+	}
+
+	m, err := List(root)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []Entry{{Path: "a.txt", Size: 1}, {Path: "dir", Dir: true}}
+	if runtime.GOOS != "windows" {
+		want = append(want, Entry{Path: "link", Size: 1})
+	}
+	if len(m.Entries) != len(want) {
+		t.Fatalf("List() = %+v, want %d entries", m.Entries, len(want))
+	}
+	for i, e := range m.Entries {
+		if e.Path != want[i].Path || e.Size != want[i].Size || e.Dir != want[i].Dir || e.IsLink() {
+			t.Errorf("Entry %d = %+v, want %+v", i, e, want[i])
+		}
+	}
+
+	if _, err := List(filepath.Join(root, "a.txt")); err == nil {
+		t.Error("Expected error for a file")
+	}
+}
@@ -0,0 +1,106 @@
+package manifest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrSumMismatch is returned when files do not match the hashes of a checksum
+// file
+var ErrSumMismatch = errors.New("files do not match their checksums")
+
+// Sum a line of a checksum file
+type Sum struct {
+	Hash string // Hex encoded
+	Path string // Slash separated, relative to the directory of the checksum file unless absolute
+}
+
+// WriteSums writes sums in the format of sha256sum and the like, so their
+// --check verifies them too
+func WriteSums(w io.Writer, sums []Sum) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range sums {
+		// Names with a backslash or a newline are escaped, and the line
+		// marked with a leading backslash
+		name := s.Path
+		if strings.ContainsAny(name, "\\\n") {
+			name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+			bw.WriteString("\\")
+		}
+		fmt.Fprintf(bw, "%s  %s\n", s.Hash, name)
+	}
+	return bw.Flush()
+}
+
+// ReadSums parses a checksum file in the format of sha256sum and the like,
+// skipping blank lines and comments
+func ReadSums(r io.Reader) ([]Sum, error) {
+	var sums []Sum
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		escaped := strings.HasPrefix(line, "\\")
+		line = strings.TrimPrefix(line, "\\")
+		hash, name, ok := strings.Cut(line, " ")
+		// A space or an asterisk for binary mode separates the name
+		if !ok || hash == "" || name == "" || (name[0] != ' ' && name[0] != '*') || len(name) < 2 {
+			return nil, fmt.Errorf("invalid checksum line %d", n)
+		}
+		name = name[1:]
+		if escaped {
+			var err error
+			if name, err = unescapeSumName(name); err != nil {
+				return nil, fmt.Errorf("invalid checksum line %d: %w", n, err)
+			}
+		}
+		sums = append(sums, Sum{Hash: strings.ToLower(hash), Path: name})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// SumAlgo returns the hash algorithm of a hex encoded hash guessed from its
+// length, empty if none has that length
+func SumAlgo(hash string) string {
+	switch len(hash) {
+	case 32:
+		return "md5"
+	case 40:
+		return "sha1"
+	case 64:
+		return "sha256"
+	case 128:
+		return "sha512"
+	}
+	return ""
+}
+
+func unescapeSumName(name string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '\\' {
+			sb.WriteByte(name[i])
+			continue
+		}
+		if i++; i == len(name) {
+			return "", errors.New("trailing backslash")
+		}
+		switch name[i] {
+		case '\\':
+			sb.WriteByte('\\')
+		case 'n':
+			sb.WriteByte('\n')
+		default:
+			return "", fmt.Errorf("unknown escape \\%c", name[i])
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,55 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSums(t *testing.T) {
+	sums := []Sum{
+		{Hash: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", Path: "os.img"},
+		{Hash: "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752", Path: "dir/with space"},
+		{Hash: "fd61a03af4f77d870fc21e05e7e80678095c92d808cfb3b5c279ee04c74aca13", Path: "odd\\name\nline"},
+	}
+	var sb strings.Builder
+	if err := WriteSums(&sb, sums); err != nil {
+		t.Fatal(err)
+	}
+	want := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08  os.img\n" +
+		"60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752  dir/with space\n" +
+		"\\fd61a03af4f77d870fc21e05e7e80678095c92d808cfb3b5c279ee04c74aca13  odd\\\\name\\nline\n"
+	if sb.String() != want {
+		t.Errorf("WriteSums() = %q, want %q", sb.String(), want)
+	}
+	got, err := ReadSums(strings.NewReader("# comment\n\n" + sb.String()))
+	if err != nil || !reflect.DeepEqual(got, sums) {
+		t.Errorf("ReadSums() = %v, %v", got, err)
+	}
+
+	// Binary mode of sha256sum -b
+	got, err = ReadSums(strings.NewReader("ABCD *os.img\r\n"))
+	if err != nil || len(got) != 1 || got[0] != (Sum{Hash: "abcd", Path: "os.img"}) {
+		t.Errorf("ReadSums(binary) = %v, %v", got, err)
+	}
+	for _, invalid := range []string{"abcd\n", "abcd os.img\n", "\\abcd  bad\\x\n", "abcd  \n"} {
+		if _, err := ReadSums(strings.NewReader(invalid)); err == nil {
+			t.Errorf("ReadSums(%q) must fail", invalid)
+		}
+	}
+}
+
+func TestSumAlgo(t *testing.T) {
+	tests := map[string]string{
+		strings.Repeat("a", 32):  "md5",
+		strings.Repeat("a", 40):  "sha1",
+		strings.Repeat("a", 64):  "sha256",
+		strings.Repeat("a", 128): "sha512",
+		"abc":                    "",
+	}
+	for hash, want := range tests {
+		if got := SumAlgo(hash); got != want {
+			t.Errorf("SumAlgo(%d chars) = %q, want %q", len(hash), got, want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package metrics
+
+import "expvar"
+
+// ServerStats counters of the requests a server answered over HTTP, FTP and
+// QUIC, published by expvar as ezft_server
+var ServerStats = expvar.NewMap("ezft_server")
+
+// Names of the counters of ServerStats
+const (
+	Requests       = "requests"        // Requests answered
+	ActiveRequests = "active_requests" // Requests being answered
+	BytesSent      = "bytes_sent"      // Bytes of the response bodies
+	BytesReceived  = "bytes_received"  // Bytes of the request bodies
+	ServerErrors   = "server_errors"   // Requests answered with a 5xx status
+	SlowRequests   = "slow_requests"   // Requests over the slow request threshold
+)
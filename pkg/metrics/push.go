@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/hook"
+)
+
+// DefaultStatsDPort port of StatsD targets without one
+const DefaultStatsDPort = "8125"
+
+// DefaultJob job of the metrics pushed to a Pushgateway URL without path
+const DefaultJob = "ezft"
+
+// pushTimeout upper bound of a push
+const pushTimeout = 10 * time.Second
+
+// Transfer the metrics of a finished transfer
+type Transfer struct {
+	Type     string // download, upload or sync
+	Bytes    int64  // Bytes transferred
+	Duration time.Duration
+	Retries  int64 // Chunk or whole file transfers retried
+	Err      error // Error the transfer failed with, nil if it succeeded
+}
+
+// status returns the outcome of the transfer
+func (t *Transfer) status() string {
+	if t.Err != nil {
+		return hook.StatusFailure
+	}
+	return hook.StatusSuccess
+}
+
+// Pusher pushes the metrics of finished transfers to StatsD or a Prometheus
+// Pushgateway, for runs too short to be scraped
+type Pusher struct {
+	statsd     string // Address of the StatsD server, empty for a Pushgateway
+	prefix     string // Prefix of the StatsD metric names
+	gateway    string // URL the metrics are pushed to on the Pushgateway
+	httpClient *http.Client
+}
+
+// NewPusher creates a pusher to target: statsd://host[:port][/prefix] sends
+// StatsD metrics over UDP named prefix.type.metric, ezft.type.metric by
+// default, and an http or https URL is a Pushgateway, the metrics replacing
+// those of the group of its path, /metrics/job/ezft by default
+func NewPusher(target string) (*Pusher, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid metrics push target %q, want statsd://host:port or a Pushgateway URL", target)
+	}
+	switch u.Scheme {
+	case "statsd":
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), DefaultStatsDPort)
+		}
+		prefix := strings.Trim(u.Path, "/")
+		if prefix == "" {
+			prefix = DefaultJob
+		}
+		return &Pusher{statsd: addr, prefix: strings.ReplaceAll(prefix, "/", ".")}, nil
+	case "http", "https":
+		if strings.Trim(u.Path, "/") == "" {
+			u.Path = "/metrics/job/" + DefaultJob
+		}
+		return &Pusher{gateway: u.String(), httpClient: http.DefaultClient}, nil
+	default:
+		return nil, fmt.Errorf("invalid metrics push target %q, want statsd://host:port or a Pushgateway URL", target)
+	}
+}
+
+// SetHTTPClient replaces the http client of Pushgateway pushes
+func (p *Pusher) SetHTTPClient(httpClient *http.Client) {
+	p.httpClient = httpClient
+}
+
+// Push pushes the metrics of t
+func (p *Pusher) Push(ctx context.Context, t *Transfer) error {
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+	if p.statsd != "" {
+		return p.pushStatsD(ctx, t)
+	}
+	return p.pushGateway(ctx, t)
+}
+
+// pushStatsD sends the metrics of t as a single StatsD datagram: counters of
+// the bytes, retries and outcome, and the duration as a timer
+func (p *Pusher) pushStatsD(ctx context.Context, t *Transfer) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", p.statsd)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to StatsD: %w", err)
+	}
+	defer conn.Close()
+
+	name := p.prefix + "." + t.Type
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s.bytes:%d|c\n", name, t.Bytes)
+	fmt.Fprintf(&b, "%s.duration:%d|ms\n", name, t.Duration.Milliseconds())
+	fmt.Fprintf(&b, "%s.retries:%d|c\n", name, t.Retries)
+	fmt.Fprintf(&b, "%s.%s:1|c", name, t.status())
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		return fmt.Errorf("failed to push metrics to StatsD: %w", err)
+	}
+	return nil
+}
+
+// pushGateway replaces the metrics of the group of the Pushgateway by those of
+// t in the Prometheus text format
+func (p *Pusher) pushGateway(ctx context.Context, t *Transfer) error {
+	success := 0
+	if t.Err == nil {
+		success = 1
+	}
+	labels := fmt.Sprintf("{type=%q}", t.Type)
+	var b bytes.Buffer
+	metric := func(name, help string, value any) {
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+		fmt.Fprintf(&b, "%s%s %v\n", name, labels, value)
+	}
+	metric("ezft_transfer_bytes", "Bytes transferred by the last transfer.", t.Bytes)
+	metric("ezft_transfer_duration_seconds", "Duration of the last transfer.", t.Duration.Seconds())
+	metric("ezft_transfer_retries", "Chunk or whole file transfers retried by the last transfer.", t.Retries)
+	metric("ezft_transfer_success", "Whether the last transfer succeeded.", success)
+	metric("ezft_transfer_completion_timestamp_seconds", "Time the last transfer finished.", time.Now().Unix())
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", p.gateway, &b)
+	if err != nil {
+		return fmt.Errorf("invalid Pushgateway URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to the Pushgateway: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to push metrics to the Pushgateway: server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
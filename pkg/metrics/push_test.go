@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewPusher(t *testing.T) {
+	tests := []struct {
+		target  string
+		statsd  string
+		prefix  string
+		gateway string
+	}{
+		{"statsd://127.0.0.1", "127.0.0.1:8125", "ezft", ""},
+		{"statsd://metrics.example.com:9125/backup/nightly", "metrics.example.com:9125", "backup.nightly", ""},
+		{"http://pushgateway:9091", "", "", "http://pushgateway:9091/metrics/job/ezft"},
+		{"https://pushgateway/metrics/job/backup/instance/host1", "", "", "https://pushgateway/metrics/job/backup/instance/host1"},
+	}
+	for _, tt := range tests {
+		p, err := NewPusher(tt.target)
+		if err != nil {
+			t.Errorf("NewPusher(%q) error = %v", tt.target, err)
+			continue
+		}
+		if p.statsd != tt.statsd || p.prefix != tt.prefix || p.gateway != tt.gateway {
+			t.Errorf("NewPusher(%q) = %+v", tt.target, p)
+		}
+	}
+	for _, target := range []string{"", "127.0.0.1:8125", "udp://127.0.0.1:8125", "http:///metrics"} {
+		if _, err := NewPusher(target); err == nil {
+			t.Errorf("NewPusher(%q) succeeded", target)
+		}
+	}
+}
+
+func TestPushStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	p, err := NewPusher("statsd://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer := &Transfer{Type: "download", Bytes: 4096, Duration: 1500 * time.Millisecond, Retries: 2, Err: errors.New("404")}
+	if err := p.Push(context.Background(), transfer); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ezft.download.bytes:4096|c\nezft.download.duration:1500|ms\nezft.download.retries:2|c\nezft.download.failure:1|c"
+	if got := string(buf[:n]); got != want {
+		t.Errorf("Pushed %q, want %q", got, want)
+	}
+}
+
+func TestPushGateway(t *testing.T) {
+	var method, path, body string
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	p, err := NewPusher(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer := &Transfer{Type: "upload", Bytes: 4096, Duration: 2 * time.Second}
+	if err := p.Push(context.Background(), transfer); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if method != "PUT" || path != "/metrics/job/ezft" {
+		t.Errorf("Pushed with %s %s", method, path)
+	}
+	for _, want := range []string{
+		"# TYPE ezft_transfer_bytes gauge\n",
+		"ezft_transfer_bytes{type=\"upload\"} 4096\n",
+		"ezft_transfer_duration_seconds{type=\"upload\"} 2\n",
+		"ezft_transfer_retries{type=\"upload\"} 0\n",
+		"ezft_transfer_success{type=\"upload\"} 1\n",
+		"ezft_transfer_completion_timestamp_seconds{type=\"upload\"} ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Pushed metrics lack %q:\n%s", want, body)
+		}
+	}
+
+	status = http.StatusBadRequest
+	if err := p.Push(context.Background(), transfer); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Push() error = %v, want the status", err)
+	}
+}
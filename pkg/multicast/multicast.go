@@ -0,0 +1,159 @@
+// Package multicast pushes a file to many receivers of a LAN at once. The
+// sender multicasts the file in UDP datagrams, so the network carries it once
+// whatever the number of receivers. Receivers write the datagrams as they
+// arrive and fetch the ones they missed from the sender over HTTP, the unicast
+// repair channel, then check the whole file against its SHA-256.
+package multicast
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultGroup multicast group of transfers unless given, in the
+	// organization-local scope
+	DefaultGroup = "239.255.77.1:7700"
+	// PayloadSize bytes of the file carried by a datagram, small enough not
+	// to be fragmented on Ethernet
+	PayloadSize = 1400
+	// announceEvery number of data datagrams between two announces, receivers
+	// joining late wait for the next one
+	announceEvery = 1024
+)
+
+const magic = "EZMC"
+
+// Datagram types
+const (
+	typeAnnounce byte = 1 // Describes the file of the session
+	typeData     byte = 2 // Carries PayloadSize bytes of the file at an offset
+	typeEnd      byte = 3 // Ends a pass over the file
+)
+
+// headerSize magic, type and session of all datagrams
+const headerSize = len(magic) + 1 + 8
+
+// Announce describes the file being sent in a session
+type Announce struct {
+	Session    uint64
+	Size       int64
+	RepairPort int      // Port of the HTTP server of the sender serving missed datagrams
+	SHA256     [32]byte // Hash of the whole file
+	Name       string   // Base name of the file, informational
+}
+
+var errInvalidDatagram = errors.New("invalid datagram")
+
+// ErrHashMismatch is returned when the received file does not match the hash
+// announced by the sender
+var ErrHashMismatch = errors.New("received file does not match the SHA-256 of the sender")
+
+func appendHeader(b []byte, typ byte, session uint64) []byte {
+	b = append(b, magic...)
+	b = append(b, typ)
+	return binary.BigEndian.AppendUint64(b, session)
+}
+
+// encodeAnnounce encodes a as an announce datagram
+func encodeAnnounce(a *Announce) []byte {
+	b := appendHeader(make([]byte, 0, headerSize+44+len(a.Name)), typeAnnounce, a.Session)
+	b = binary.BigEndian.AppendUint64(b, uint64(a.Size))
+	b = binary.BigEndian.AppendUint16(b, uint16(a.RepairPort))
+	b = append(b, a.SHA256[:]...)
+	return append(b, a.Name...)
+}
+
+// encodeData encodes the data of the file at offset as a data datagram into b
+func encodeData(b []byte, session uint64, offset int64, data []byte) []byte {
+	b = appendHeader(b[:0], typeData, session)
+	b = binary.BigEndian.AppendUint64(b, uint64(offset))
+	return append(b, data...)
+}
+
+// encodeEnd encodes the end of a pass, remaining passes follow it
+func encodeEnd(session uint64, remaining int) []byte {
+	b := appendHeader(make([]byte, 0, headerSize+2), typeEnd, session)
+	return binary.BigEndian.AppendUint16(b, uint16(remaining))
+}
+
+// datagram a decoded datagram
+type datagram struct {
+	typ       byte
+	session   uint64
+	announce  *Announce // Announce datagrams
+	offset    int64     // Data datagrams
+	data      []byte    // Data datagrams, referencing the buffer decoded
+	remaining int       // End datagrams
+}
+
+// decode decodes the datagram b
+func decode(b []byte) (*datagram, error) {
+	if len(b) < headerSize || string(b[:len(magic)]) != magic {
+		return nil, errInvalidDatagram
+	}
+	d := &datagram{typ: b[len(magic)], session: binary.BigEndian.Uint64(b[len(magic)+1:])}
+	b = b[headerSize:]
+	switch d.typ {
+	case typeAnnounce:
+		if len(b) < 42 {
+			return nil, errInvalidDatagram
+		}
+		a := &Announce{
+			Session:    d.session,
+			Size:       int64(binary.BigEndian.Uint64(b)),
+			RepairPort: int(binary.BigEndian.Uint16(b[8:])),
+			Name:       string(b[42:]),
+		}
+		copy(a.SHA256[:], b[10:42])
+		if a.Size < 0 {
+			return nil, errInvalidDatagram
+		}
+		d.announce = a
+	case typeData:
+		if len(b) < 8 || len(b)-8 > PayloadSize {
+			return nil, errInvalidDatagram
+		}
+		d.offset = int64(binary.BigEndian.Uint64(b))
+		d.data = b[8:]
+		if d.offset < 0 || d.offset%PayloadSize != 0 {
+			return nil, errInvalidDatagram
+		}
+	case typeEnd:
+		if len(b) < 2 {
+			return nil, errInvalidDatagram
+		}
+		d.remaining = int(binary.BigEndian.Uint16(b))
+	default:
+		return nil, fmt.Errorf("%w: unknown type %d", errInvalidDatagram, d.typ)
+	}
+	return d, nil
+}
+
+// resolve resolves the group address and the interface named ifname, nil if
+// empty
+func resolve(group, ifname string) (*net.UDPAddr, *net.Interface, error) {
+	addr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid group %q: %w", group, err)
+	}
+	if ifname == "" {
+		return addr, nil, nil
+	}
+	ifi, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid interface %q: %w", ifname, err)
+	}
+	return addr, ifi, nil
+}
+
+// Report the outcome of a transfer
+type Report struct {
+	Size      int64
+	Multicast int64 // Bytes sent or received by multicast
+	Repaired  int64 // Bytes sent or received over the repair channel
+	Duration  time.Duration
+}
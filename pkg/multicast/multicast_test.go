@@ -0,0 +1,124 @@
+package multicast
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDatagrams(t *testing.T) {
+	a := &Announce{Session: 42, Size: 123456789, RepairPort: 7701, Name: "os.img"}
+	a.SHA256[3] = 7
+	d, err := decode(encodeAnnounce(a))
+	if err != nil || d.typ != typeAnnounce || *d.announce != *a {
+		t.Errorf("decode(announce) = %+v, %v", d, err)
+	}
+
+	data := []byte("payload")
+	d, err = decode(encodeData(nil, 42, 3*PayloadSize, data))
+	if err != nil || d.typ != typeData || d.session != 42 || d.offset != 3*PayloadSize || !bytes.Equal(d.data, data) {
+		t.Errorf("decode(data) = %+v, %v", d, err)
+	}
+	if _, err := decode(encodeData(nil, 42, 5, data)); err == nil {
+		t.Error("decode() must reject unaligned offsets")
+	}
+
+	d, err = decode(encodeEnd(42, 2))
+	if err != nil || d.typ != typeEnd || d.remaining != 2 {
+		t.Errorf("decode(end) = %+v, %v", d, err)
+	}
+	for _, b := range [][]byte{nil, []byte("EZMC"), []byte("XXXX\x02\x00\x00\x00\x00\x00\x00\x00\x2a")} {
+		if _, err := decode(b); err == nil {
+			t.Errorf("decode(%q) must fail", b)
+		}
+	}
+}
+
+// freeUDPAddr returns a loopback UDP address nobody listens on
+func freeUDPAddr(t *testing.T) string {
+	t.Helper()
+	r := NewReceiver(&ReceiverConfig{Group: "127.0.0.1:0"})
+	if err := r.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	return r.conn.LocalAddr().String()
+}
+
+func TestSendReceive(t *testing.T) {
+	tests := []struct {
+		name   string
+		passes int
+		drop   func(offset int64) bool
+	}{
+		{"lossless", 1, nil},
+		// Every 7th datagram is lost and fetched over the repair channel
+		{"lossy", 1, func(offset int64) bool { return offset/PayloadSize%7 == 3 }},
+		// The datagrams lost in the first pass arrive in the second
+		{"passes", 2, func(offset int64) bool { return offset < 100*PayloadSize }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			content := make([]byte, 500*PayloadSize+123)
+			rand.New(rand.NewSource(1)).Read(content)
+			src := filepath.Join(dir, "os.img")
+			if err := os.WriteFile(src, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// Loopback unicast stands in for the group, multicast routes are not
+			// available everywhere
+			group := freeUDPAddr(t)
+			receiver := NewReceiver(&ReceiverConfig{Group: group, OutputPath: filepath.Join(dir, "out.img"), Wait: 10 * time.Second})
+			if err := receiver.Listen(); err != nil {
+				t.Fatal(err)
+			}
+			defer receiver.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			received := make(chan *Report, 1)
+			go func() {
+				report, err := receiver.Receive(ctx)
+				if err != nil {
+					t.Errorf("Receive() error = %v", err)
+				}
+				received <- report
+			}()
+
+			sender := NewSender(&SenderConfig{Path: src, Group: group, Passes: tt.passes, RepairAddr: "127.0.0.1:0", Linger: time.Second})
+			// Paced, the loopback drops bursts beyond the socket buffer
+			sender.config.Rate = 20 * 1024 * 1024
+			dropped := make(map[int64]bool)
+			sender.drop = func(offset int64) bool {
+				// Only the first pass loses datagrams
+				if tt.drop == nil || dropped[offset] || !tt.drop(offset) {
+					return false
+				}
+				dropped[offset] = true
+				return true
+			}
+			if _, err := sender.Send(ctx); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			report := <-received
+			if report == nil {
+				return
+			}
+			if got, _ := os.ReadFile(filepath.Join(dir, "out.img")); !bytes.Equal(got, content) {
+				t.Fatal("Received file differs")
+			}
+			if report.Multicast+report.Repaired != int64(len(content)) {
+				t.Errorf("Multicast %d + repaired %d bytes, want %d", report.Multicast, report.Repaired, len(content))
+			}
+			if (tt.name == "lossy") != (report.Repaired > 0) {
+				t.Errorf("Repaired = %d bytes", report.Repaired)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package multicast
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultIdleTimeout time without datagrams after which receivers repair
+	DefaultIdleTimeout = 5 * time.Second
+	// maxRepairSize bounds the bytes of a repair request
+	maxRepairSize = 16 * 1024 * 1024
+	// readBufferSize socket buffer absorbing bursts of datagrams
+	readBufferSize = 8 * 1024 * 1024
+)
+
+// ReceiverConfig multicast receiver configuration
+type ReceiverConfig struct {
+	Group       string        // Multicast group and port, DefaultGroup if empty
+	Interface   string        // Network interface to join the group on, chosen by the system if empty
+	OutputPath  string        // File written
+	Wait        time.Duration // Time to wait for a sender, no limit if 0
+	IdleTimeout time.Duration // Time without datagrams after which the missed ones are repaired
+}
+
+// Receiver receives a file multicast to a group
+type Receiver struct {
+	config *ReceiverConfig
+	conn   *net.UDPConn
+	client *http.Client
+	logger *zap.Logger
+}
+
+// NewReceiver creates a receiver with config
+func NewReceiver(config *ReceiverConfig) *Receiver {
+	if config.Group == "" {
+		config.Group = DefaultGroup
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultIdleTimeout
+	}
+	return &Receiver{config: config, client: &http.Client{Timeout: 5 * time.Minute}, logger: zap.NewNop()}
+}
+
+func (r *Receiver) SetLogger(logger *zap.Logger) {
+	r.logger = logger
+}
+
+// Listen joins the group. A unicast address is listened on instead, which
+// serves a single receiver.
+func (r *Receiver) Listen() error {
+	group, ifi, err := resolve(r.config.Group, r.config.Interface)
+	if err != nil {
+		return err
+	}
+	if group.IP.IsMulticast() {
+		r.conn, err = net.ListenMulticastUDP("udp4", ifi, group)
+	} else {
+		r.conn, err = net.ListenUDP("udp4", group)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to join group: %w", err)
+	}
+	r.conn.SetReadBuffer(readBufferSize)
+	return nil
+}
+
+// Close leaves the group
+func (r *Receiver) Close() error {
+	return r.conn.Close()
+}
+
+// session the file being received
+type session struct {
+	announce *Announce
+	sender   *net.UDPAddr
+	file     *os.File
+	received []bool // Datagrams received, by offset / PayloadSize
+	missing  int    // Datagrams not received yet
+	ended    bool   // The last pass ended
+}
+
+// Receive receives the first file sent to the group once joined, repairing
+// the datagrams missed, and checks it against its hash
+func (r *Receiver) Receive(ctx context.Context) (*Report, error) {
+	start := time.Now()
+	stop := context.AfterFunc(ctx, func() { r.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	s, err := r.waitAnnounce(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.file.Close()
+	r.logger.Info("receiving multicast file",
+		zap.String("name", s.announce.Name),
+		zap.Int64("size", s.announce.Size),
+		zap.String("sender", s.sender.String()),
+	)
+
+	report := &Report{Size: s.announce.Size}
+	buf := make([]byte, 64*1024)
+	for s.missing > 0 && !s.ended {
+		r.conn.SetReadDeadline(time.Now().Add(r.config.IdleTimeout))
+		n, _, err := r.conn.ReadFromUDP(buf)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			r.logger.Info("no datagram received for a while, repairing")
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		d, err := decode(buf[:n])
+		if err != nil || d.session != s.announce.Session {
+			continue
+		}
+		switch d.typ {
+		case typeData:
+			i := d.offset / PayloadSize
+			if i >= int64(len(s.received)) || s.received[i] || int64(len(d.data)) != min(PayloadSize, s.announce.Size-d.offset) {
+				continue
+			}
+			if _, err := s.file.WriteAt(d.data, d.offset); err != nil {
+				return nil, err
+			}
+			s.received[i] = true
+			s.missing--
+			report.Multicast += int64(len(d.data))
+		case typeEnd:
+			s.ended = d.remaining == 0
+		}
+	}
+
+	if report.Repaired, err = r.repair(ctx, s); err != nil {
+		return nil, err
+	}
+	if err := r.verify(s); err != nil {
+		return nil, err
+	}
+	report.Duration = time.Since(start)
+	r.logger.Info("multicast file received",
+		zap.Int64("multicast", report.Multicast),
+		zap.Int64("repaired", report.Repaired),
+		zap.Duration("duration", report.Duration),
+	)
+	return report, nil
+}
+
+// waitAnnounce waits for the announce of a session and prepares the output file
+func (r *Receiver) waitAnnounce(ctx context.Context) (*session, error) {
+	var deadline time.Time
+	if r.config.Wait > 0 {
+		deadline = time.Now().Add(r.config.Wait)
+	}
+	r.conn.SetReadDeadline(deadline)
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, fmt.Errorf("no sender within %s", r.config.Wait)
+		}
+		if err != nil {
+			return nil, err
+		}
+		d, err := decode(buf[:n])
+		if err != nil || d.typ != typeAnnounce {
+			continue
+		}
+
+		file, err := os.OpenFile(r.config.OutputPath, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create file: %w", err)
+		}
+		if err := file.Truncate(d.announce.Size); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to allocate file: %w", err)
+		}
+		count := (d.announce.Size + PayloadSize - 1) / PayloadSize
+		return &session{
+			announce: d.announce,
+			sender:   addr,
+			file:     file,
+			received: make([]bool, count),
+			missing:  int(count),
+		}, nil
+	}
+}
+
+// repair fetches the datagrams missed from the sender, returning the bytes
+// fetched
+func (r *Receiver) repair(ctx context.Context, s *session) (int64, error) {
+	if s.missing == 0 {
+		return 0, nil
+	}
+	repairURL := "http://" + net.JoinHostPort(s.sender.IP.String(), strconv.Itoa(s.announce.RepairPort)) + "/"
+	r.logger.Info("repairing missed datagrams",
+		zap.Int("missing", s.missing),
+		zap.String("url", repairURL),
+	)
+
+	var repaired int64
+	for i := 0; i < len(s.received); {
+		if s.received[i] {
+			i++
+			continue
+		}
+		// Consecutive missed datagrams are fetched at once
+		j := i + 1
+		for j < len(s.received) && !s.received[j] && int64(j-i)*PayloadSize < maxRepairSize {
+			j++
+		}
+		start := int64(i) * PayloadSize
+		end := min(int64(j)*PayloadSize, s.announce.Size) - 1
+		n, err := r.fetchRange(ctx, repairURL, s.file, start, end)
+		if err != nil {
+			return repaired, fmt.Errorf("failed to repair bytes %d-%d: %w", start, end, err)
+		}
+		repaired += n
+		i = j
+	}
+	return repaired, nil
+}
+
+// fetchRange copies the bytes start to end of the file from the sender
+func (r *Receiver) fetchRange(ctx context.Context, repairURL string, file *os.File, start, end int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, repairURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("sender returned status %d", resp.StatusCode)
+	}
+	n, err := io.Copy(io.NewOffsetWriter(file, start), io.LimitReader(resp.Body, end-start+1))
+	if err == nil && n != end-start+1 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// verify checks the received file against the hash of the announce
+func (r *Receiver) verify(s *session) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(s.file, 0, s.announce.Size)); err != nil {
+		return err
+	}
+	if !bytes.Equal(hash.Sum(nil), s.announce.SHA256[:]) {
+		return ErrHashMismatch
+	}
+	return s.file.Close()
+}
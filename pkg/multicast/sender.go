@@ -0,0 +1,218 @@
+package multicast
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"go.uber.org/zap"
+	"golang.org/x/net/ipv4"
+)
+
+// SenderConfig multicast sender configuration
+type SenderConfig struct {
+	Path       string        // File to send
+	Group      string        // Multicast group and port, DefaultGroup if empty
+	Interface  string        // Network interface to multicast on, the one of the default route if empty
+	TTL        int           // Routers the datagrams cross, 1 keeps them in the LAN
+	Rate       int64         // Bytes multicast per second, 0 for no limit
+	Passes     int           // Times the file is multicast, receivers missing datagrams catch them in later passes
+	RepairAddr string        // Address serving missed datagrams to receivers over HTTP
+	Linger     time.Duration // Time repairs are served after the last pass
+}
+
+// Sender multicasts a file to the receivers of a group
+type Sender struct {
+	config *SenderConfig
+	logger *zap.Logger
+	drop   func(offset int64) bool // Datagrams left out, for tests
+}
+
+// NewSender creates a sender with config
+func NewSender(config *SenderConfig) *Sender {
+	if config.Group == "" {
+		config.Group = DefaultGroup
+	}
+	if config.TTL <= 0 {
+		config.TTL = 1
+	}
+	if config.Passes <= 0 {
+		config.Passes = 1
+	}
+	return &Sender{config: config, logger: zap.NewNop()}
+}
+
+func (s *Sender) SetLogger(logger *zap.Logger) {
+	s.logger = logger
+}
+
+// Send multicasts the file, then serves repairs until Linger elapsed or ctx
+// is done
+func (s *Sender) Send(ctx context.Context) (*Report, error) {
+	start := time.Now()
+	group, ifi, err := resolve(s.config.Group, s.config.Interface)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(s.config.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", s.config.Path)
+	}
+
+	// Receivers check the file they put together against its hash
+	sessionID := make([]byte, 8)
+	cryptorand.Read(sessionID)
+	announce := &Announce{
+		Session: binary.BigEndian.Uint64(sessionID),
+		Size:    info.Size(),
+		Name:    filepath.Base(s.config.Path),
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, info.Size())); err != nil {
+		return nil, fmt.Errorf("failed to hash file: %w", err)
+	}
+	copy(announce.SHA256[:], hash.Sum(nil))
+
+	// Repairs are range requests to the file
+	lis, err := net.Listen("tcp", s.config.RepairAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for repairs: %w", err)
+	}
+	announce.RepairPort = lis.Addr().(*net.TCPAddr).Port
+	var repaired atomic.Int64
+	repair := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(&countingWriter{ResponseWriter: w, n: &repaired}, r, announce.Name, info.ModTime(), io.NewSectionReader(file, 0, info.Size()))
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go repair.Serve(lis)
+	defer repair.Close()
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	pc := ipv4.NewPacketConn(conn)
+	if group.IP.IsMulticast() {
+		if err := pc.SetMulticastTTL(s.config.TTL); err != nil {
+			return nil, fmt.Errorf("failed to set TTL: %w", err)
+		}
+		// Receivers on the sending host get the datagrams too
+		pc.SetMulticastLoopback(true)
+		if ifi != nil {
+			if err := pc.SetMulticastInterface(ifi); err != nil {
+				return nil, fmt.Errorf("failed to set interface: %w", err)
+			}
+		}
+	}
+
+	s.logger.Info("multicasting file",
+		zap.String("path", s.config.Path),
+		zap.String("group", group.String()),
+		zap.Int64("size", info.Size()),
+		zap.String("repair", lis.Addr().String()),
+	)
+
+	var limiter *ratelimit.Limiter
+	if s.config.Rate > 0 {
+		limiter = ratelimit.NewLimiter(ratelimit.NewSchedule(s.config.Rate))
+		defer limiter.Close()
+	}
+	report := &Report{Size: info.Size()}
+	announceDatagram := encodeAnnounce(announce)
+	buf := make([]byte, PayloadSize)
+	datagram := make([]byte, 0, headerSize+8+PayloadSize)
+	for pass := 1; pass <= s.config.Passes; pass++ {
+		for offset, n := int64(0), 0; offset < info.Size(); offset += PayloadSize {
+			if n%announceEvery == 0 {
+				if _, err := conn.WriteTo(announceDatagram, group); err != nil {
+					return nil, err
+				}
+			}
+			n++
+			size, err := file.ReadAt(buf, offset)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			if err := limiter.WaitN(ctx, size); err != nil {
+				return nil, err
+			}
+			if s.drop != nil && s.drop(offset) {
+				continue
+			}
+			if _, err := conn.WriteTo(encodeData(datagram, announce.Session, offset, buf[:size]), group); err != nil {
+				return nil, err
+			}
+			report.Multicast += int64(size)
+		}
+		// Sent a few times, receivers wait for the end of the last pass to repair
+		for range 3 {
+			conn.WriteTo(announceDatagram, group)
+			conn.WriteTo(encodeEnd(announce.Session, s.config.Passes-pass), group)
+		}
+		s.logger.Info("pass completed",
+			zap.Int("pass", pass),
+			zap.Duration("duration", time.Since(start)),
+		)
+	}
+
+	// Receivers which missed the end learn it from the datagrams repeated
+	// while repairs are served
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	linger := time.NewTimer(s.config.Linger)
+	defer linger.Stop()
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-linger.C:
+			done = true
+		case <-ticker.C:
+			conn.WriteTo(announceDatagram, group)
+			conn.WriteTo(encodeEnd(announce.Session, 0), group)
+		}
+	}
+
+	report.Repaired = repaired.Load()
+	report.Duration = time.Since(start)
+	s.logger.Info("multicast completed",
+		zap.Int64("multicast", report.Multicast),
+		zap.Int64("repaired", report.Repaired),
+		zap.Duration("duration", report.Duration),
+	)
+	return report, nil
+}
+
+// countingWriter counts the bytes of a response body
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n.Add(int64(n))
+	return n, err
+}
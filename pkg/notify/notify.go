@@ -0,0 +1,180 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/hook"
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+// DefaultThrottle window in which repeated failure alerts of the same job are
+// suppressed
+const DefaultThrottle = time.Hour
+
+// Sender types
+const (
+	TypeEmail   = "email"
+	TypeSlack   = "slack"
+	TypeWebhook = "webhook"
+)
+
+// Message a notification about a finished transfer
+type Message struct {
+	*hook.Event
+	Suppressed int `json:"suppressed,omitempty"` // Failure alerts of the same job suppressed since the last one
+}
+
+// Subject returns a one line summary of the message
+func (m *Message) Subject() string {
+	name := m.Job
+	if name == "" {
+		name = m.URL
+	}
+	if m.Status == hook.StatusSuccess {
+		return fmt.Sprintf("ezft %s %s succeeded", m.Type, name)
+	}
+	return fmt.Sprintf("ezft %s %s failed", m.Type, name)
+}
+
+// Text returns the details of the message
+func (m *Message) Text() string {
+	text := fmt.Sprintf("URL: %s\nPath: %s\nBytes: %s\nDuration: %s\n",
+		m.URL, m.Path, utils.FormatBytes(m.Bytes), utils.FormatDuration(m.Duration))
+	if m.Checksum != "" {
+		text += fmt.Sprintf("SHA256: %s\n", m.Checksum)
+	}
+	if m.Error != "" {
+		text += fmt.Sprintf("Error: %s\n", m.Error)
+	}
+	if m.Suppressed > 0 {
+		text += fmt.Sprintf("%d more failures suppressed since the last alert\n", m.Suppressed)
+	}
+	return text
+}
+
+// Sender delivers notifications
+type Sender interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// Config configuration of a notifier
+type Config struct {
+	Type     string   `yaml:"type"`     // email, slack or webhook
+	URL      string   `yaml:"url"`      // Slack incoming webhook or webhook URL
+	SMTP     string   `yaml:"smtp"`     // host:port of the SMTP server sending emails
+	From     string   `yaml:"from"`     // Sender address of emails
+	To       []string `yaml:"to"`       // Recipients of emails
+	Username string   `yaml:"username"` // SMTP username, no authentication if empty
+	Password string   `yaml:"password"` // SMTP password
+	Events   []string `yaml:"events"`   // Statuses notified: success, failure, both if empty
+	Throttle string   `yaml:"throttle"` // Window suppressing repeated failure alerts of a job, 1h if empty, 0 for none
+}
+
+// Notifier sends the notifications of one configured sender, throttling
+// repeated failure alerts
+type Notifier struct {
+	sender   Sender
+	events   []string
+	throttle time.Duration
+	now      func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]*failure // Last failure alert by job
+}
+
+// failure throttling state of the failure alerts of a job
+type failure struct {
+	last       time.Time
+	suppressed int
+}
+
+// New creates a notifier from its configuration
+func New(c *Config) (*Notifier, error) {
+	var sender Sender
+	switch c.Type {
+	case TypeEmail:
+		if c.SMTP == "" || c.From == "" || len(c.To) == 0 {
+			return nil, fmt.Errorf("email notifiers require smtp, from and to")
+		}
+		sender = &Email{Addr: c.SMTP, From: c.From, To: c.To, Username: c.Username, Password: c.Password}
+	case TypeSlack, TypeWebhook:
+		if err := (&hook.Hooks{Webhook: c.URL}).Validate(); err != nil || c.URL == "" {
+			return nil, fmt.Errorf("invalid %s url %q", c.Type, c.URL)
+		}
+		if c.Type == TypeSlack {
+			sender = &Slack{URL: c.URL}
+		} else {
+			sender = &Webhook{URL: c.URL}
+		}
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q, must be one of: email, slack, webhook", c.Type)
+	}
+
+	for _, event := range c.Events {
+		if event != hook.StatusSuccess && event != hook.StatusFailure {
+			return nil, fmt.Errorf("invalid event %q, must be one of: success, failure", event)
+		}
+	}
+	throttle := DefaultThrottle
+	if c.Throttle != "" {
+		d, err := time.ParseDuration(c.Throttle)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid throttle %q", c.Throttle)
+		}
+		throttle = d
+	}
+
+	n := NewNotifier(sender)
+	n.events = c.Events
+	n.throttle = throttle
+	return n, nil
+}
+
+// NewNotifier creates a notifier sending all events through sender, with the
+// default throttle
+func NewNotifier(sender Sender) *Notifier {
+	return &Notifier{
+		sender:   sender,
+		throttle: DefaultThrottle,
+		now:      time.Now,
+		failures: map[string]*failure{},
+	}
+}
+
+// SetThrottle sets the window suppressing repeated failure alerts, 0 for none
+func (n *Notifier) SetThrottle(throttle time.Duration) {
+	n.throttle = throttle
+}
+
+// Notify sends a notification about e unless the notifier ignores its status.
+// A failure alert is suppressed if one was sent for the same key within the
+// throttle window, a success ends the throttling of its key.
+func (n *Notifier) Notify(ctx context.Context, key string, e *hook.Event) error {
+	if len(n.events) > 0 && !slices.Contains(n.events, e.Status) {
+		return nil
+	}
+
+	m := &Message{Event: e}
+	n.mu.Lock()
+	if e.Status == hook.StatusFailure {
+		now := n.now()
+		if last, ok := n.failures[key]; ok {
+			if now.Sub(last.last) < n.throttle {
+				last.suppressed++
+				n.mu.Unlock()
+				return nil
+			}
+			m.Suppressed = last.suppressed
+		}
+		n.failures[key] = &failure{last: now}
+	} else {
+		delete(n.failures, key)
+	}
+	n.mu.Unlock()
+
+	return n.sender.Send(ctx, m)
+}
@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/hook"
+)
+
+func TestNew(t *testing.T) {
+	valid := []Config{
+		{Type: TypeEmail, SMTP: "mail:25", From: "ezft@example.com", To: []string{"ops@example.com"}},
+		{Type: TypeSlack, URL: "https://hooks.slack.com/services/x"},
+		{Type: TypeWebhook, URL: "http://ci/hook", Events: []string{hook.StatusFailure}, Throttle: "0s"},
+	}
+	for _, c := range valid {
+		if _, err := New(&c); err != nil {
+			t.Errorf("New(%+v) error = %v", c, err)
+		}
+	}
+
+	invalid := []Config{
+		{Type: "pager"},
+		{Type: TypeEmail, SMTP: "mail:25"},
+		{Type: TypeSlack},
+		{Type: TypeWebhook, URL: "ftp://ci/hook"},
+		{Type: TypeWebhook, URL: "http://ci/hook", Events: []string{"started"}},
+		{Type: TypeWebhook, URL: "http://ci/hook", Throttle: "often"},
+	}
+	for _, c := range invalid {
+		if _, err := New(&c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+}
+
+func TestSenders(t *testing.T) {
+	var bodies []map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+	}))
+	defer ts.Close()
+
+	m := &Message{Event: &hook.Event{Status: hook.StatusFailure, Type: "download", URL: "http://host/a.iso", Error: "timeout", Job: "42"}, Suppressed: 2}
+	if err := (&Slack{URL: ts.URL}).Send(context.Background(), m); err != nil {
+		t.Fatalf("Slack Send() error = %v", err)
+	}
+	if err := (&Webhook{URL: ts.URL}).Send(context.Background(), m); err != nil {
+		t.Fatalf("Webhook Send() error = %v", err)
+	}
+
+	text, _ := bodies[0]["text"].(string)
+	if !strings.Contains(text, "ezft download 42 failed") || !strings.Contains(text, "timeout") || !strings.Contains(text, "2 more failures") {
+		t.Errorf("Unexpected Slack text %q", text)
+	}
+	if bodies[1]["status"] != hook.StatusFailure || bodies[1]["job"] != "42" || bodies[1]["suppressed"] != float64(2) {
+		t.Errorf("Unexpected webhook body %v", bodies[1])
+	}
+}
+
+func TestEmailMessage(t *testing.T) {
+	e := &Email{From: "ezft@example.com", To: []string{"a@example.com", "b@example.com"}}
+	msg := string(e.message(&Message{Event: &hook.Event{Status: hook.StatusSuccess, Type: "sync", URL: "ezft://mirror/", Job: "7"}}))
+	for _, want := range []string{"From: ezft@example.com\r\n", "To: a@example.com, b@example.com\r\n", "Subject: ezft sync 7 succeeded\r\n", "\r\n\r\nURL: ezft://mirror/\r\n"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Email message %q lacks %q", msg, want)
+		}
+	}
+}
+
+// recorder records the messages sent through it
+type recorder struct {
+	messages []*Message
+}
+
+func (r *recorder) Send(ctx context.Context, m *Message) error {
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+func TestNotifierThrottle(t *testing.T) {
+	r := &recorder{}
+	n := NewNotifier(r)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	failure := &hook.Event{Status: hook.StatusFailure}
+	ctx := context.Background()
+	n.Notify(ctx, "a", failure)
+	n.Notify(ctx, "a", failure)
+	n.Notify(ctx, "b", failure)
+	if len(r.messages) != 2 {
+		t.Fatalf("Expected the repeated failure of a to be suppressed, got %d messages", len(r.messages))
+	}
+
+	// After the window the next alert reports the suppressed ones
+	now = now.Add(DefaultThrottle)
+	n.Notify(ctx, "a", failure)
+	if len(r.messages) != 3 || r.messages[2].Suppressed != 1 {
+		t.Fatalf("Expected an alert reporting 1 suppressed failure, got %+v", r.messages)
+	}
+
+	// A success is always sent and ends the throttling
+	n.Notify(ctx, "a", &hook.Event{Status: hook.StatusSuccess})
+	n.Notify(ctx, "a", failure)
+	if len(r.messages) != 5 || r.messages[4].Suppressed != 0 {
+		t.Errorf("Expected success and failure to be sent, got %d messages", len(r.messages))
+	}
+
+	// Notifiers only send the statuses they are configured for
+	n.events = []string{hook.StatusFailure}
+	n.Notify(ctx, "c", &hook.Event{Status: hook.StatusSuccess})
+	if len(r.messages) != 5 {
+		t.Error("Expected the success to be ignored")
+	}
+}
@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// sendTimeout upper bound of delivering a notification
+const sendTimeout = 30 * time.Second
+
+// Email sends notifications by email
+type Email struct {
+	Addr     string // host:port of the SMTP server
+	From     string
+	To       []string
+	Username string // No authentication if empty
+	Password string
+}
+
+// Send implements Sender
+func (s *Email) Send(ctx context.Context, m *Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Addr, auth, s.From, s.To, s.message(m))
+	}()
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("email notification failed: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("email notification failed: %w", ctx.Err())
+	}
+}
+
+// message returns the email with its headers
+func (s *Email) message(m *Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject())
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(m.Text(), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// Slack posts notifications to a Slack incoming webhook
+type Slack struct {
+	URL string
+}
+
+// Send implements Sender
+func (s *Slack) Send(ctx context.Context, m *Message) error {
+	payload := map[string]string{"text": "*" + m.Subject() + "*\n" + m.Text()}
+	if err := postJSON(ctx, s.URL, payload); err != nil {
+		return fmt.Errorf("slack notification failed: %w", err)
+	}
+	return nil
+}
+
+// Webhook posts notifications as JSON, the hook event with the number of
+// suppressed failures
+type Webhook struct {
+	URL string
+}
+
+// Send implements Sender
+func (s *Webhook) Send(ctx context.Context, m *Message) error {
+	if err := postJSON(ctx, s.URL, m); err != nil {
+		return fmt.Errorf("webhook notification failed: %w", err)
+	}
+	return nil
+}
+
+// postJSON posts v as JSON to url
+func postJSON(ctx context.Context, url string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
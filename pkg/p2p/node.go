@@ -0,0 +1,333 @@
+package p2p
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Node serves the completed ranges of a file being downloaded to the other
+// peers of its swarm, safe for concurrent use
+type Node struct {
+	path   string // Local file
+	server *http.Server
+	lis    net.Listener
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	key      string
+	ranges   []Range
+	served   int64                    // Bytes served to peers
+	expected map[string]chan net.Conn // Punches waiting for the connection of a peer by its address
+}
+
+// NewNode creates a node serving the local file at path
+func NewNode(path string) *Node {
+	n := &Node{path: path, logger: zap.NewNop(), expected: make(map[string]chan net.Conn)}
+	n.server = &http.Server{Handler: n, ReadHeaderTimeout: 10 * time.Second}
+	return n
+}
+
+func (n *Node) SetLogger(logger *zap.Logger) {
+	n.logger = logger
+}
+
+// SetKey sets the key of the swarm, requests must carry it
+func (n *Node) SetKey(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.key = key
+}
+
+// Add marks the range r of the file as complete
+func (n *Node) Add(r Range) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.ranges = addRange(n.ranges, r)
+}
+
+// Ranges returns the completed ranges of the file
+func (n *Node) Ranges() []Range {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Range(nil), n.ranges...)
+}
+
+// Served returns the bytes served to peers
+func (n *Node) Served() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.served
+}
+
+// Listen listens on the TCP address addr, sharing the port with the
+// connections of Dialer
+func (n *Node) Listen(addr string) error {
+	// Fails on a port in use like any listener, the port being shared only
+	// once it is taken
+	probe, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for peers: %w", err)
+	}
+	addr = probe.Addr().String()
+	probe.Close()
+	lc := net.ListenConfig{Control: reusePort}
+	lis, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for peers: %w", err)
+	}
+	n.lis = &listener{Listener: lis, node: n}
+	return nil
+}
+
+// Dialer returns a dialer connecting from the port of the node, so that its
+// connections go through the NAT mapping of the port that the tracker sees
+// and that other peers punch holes to
+func (n *Node) Dialer() *net.Dialer {
+	local := *n.Addr().(*net.TCPAddr)
+	if local.IP.IsUnspecified() {
+		local.IP = nil
+	}
+	return &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+		LocalAddr: &local,
+		Control:   reusePort,
+	}
+}
+
+// Addr returns the address the node listens on
+func (n *Node) Addr() net.Addr {
+	return n.lis.Addr()
+}
+
+// Serve serves peers until Close
+func (n *Node) Serve() error {
+	if err := n.server.Serve(n.lis); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops serving peers
+func (n *Node) Close() error {
+	return n.server.Close()
+}
+
+// listener hands the connections of peers expected by a hole punch to the
+// punch instead of the server
+type listener struct {
+	net.Listener
+	node *Node
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || !l.node.handOver(conn) {
+			return conn, err
+		}
+	}
+}
+
+// handOver passes conn to the punch waiting for it, if any
+func (n *Node) handOver(conn net.Conn) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	addr := conn.RemoteAddr().String()
+	accepted := n.expected[addr]
+	if accepted == nil {
+		return false
+	}
+	delete(n.expected, addr)
+	accepted <- conn
+	return true
+}
+
+// expect makes the listener hand the next connection from addr to the channel
+// returned until done is called
+func (n *Node) expect(addr string) (accepted chan net.Conn, done func()) {
+	accepted = make(chan net.Conn, 1)
+	n.mu.Lock()
+	n.expected[addr] = accepted
+	n.mu.Unlock()
+	return accepted, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.expected[addr] == accepted {
+			delete(n.expected, addr)
+		}
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		default:
+		}
+	}
+}
+
+// punch connects to the peer at its public address addr from the port of the
+// node while the peer connects back, so that the NATs of both let the
+// connection through, and returns the connection established first: the one
+// dialed or the one of the peer handed to accepted. Failed attempts are
+// repeated until PunchTimeout.
+func (n *Node) punch(ctx context.Context, addr string, accepted <-chan net.Conn) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, PunchTimeout)
+	defer cancel()
+	dialer := n.Dialer()
+	dialer.Timeout = time.Second
+	for {
+		select {
+		case conn := <-accepted:
+			return conn, nil
+		default:
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			return conn, nil
+		}
+		select {
+		case conn := <-accepted:
+			return conn, nil
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to punch a hole to %s: %w", addr, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// serveConn serves the requests of a peer over conn, until either end closes it
+func (n *Node) serveConn(conn net.Conn) {
+	l := &connListener{conns: make(chan net.Conn, 1), closed: make(chan struct{}), addr: conn.LocalAddr()}
+	l.conns <- &listenedConn{Conn: conn, l: l}
+	go n.server.Serve(l)
+}
+
+// connListener accepts a single connection, and is closed along with it
+type connListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+	addr   net.Addr
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// listenedConn closes its listener when it is closed
+type listenedConn struct {
+	net.Conn
+	l *connListener
+}
+
+func (c *listenedConn) Close() error {
+	c.l.Close()
+	return c.Conn.Close()
+}
+
+// ServeHTTP answers a range request of a peer, for completed ranges only
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	n.mu.Lock()
+	key, ranges := n.key, n.ranges
+	n.mu.Unlock()
+	if key == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(SwarmHeader)), []byte(key)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	want, err := parseRange(r.Header.Get("Range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !Covers(ranges, want) {
+		http.Error(w, "Range not available", http.StatusNotFound)
+		return
+	}
+
+	file, err := os.Open(n.path)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	length := want.End - want.Start + 1
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", want.Start, want.End))
+	w.Header().Set("Content-Length", fmt.Sprint(length))
+	w.WriteHeader(http.StatusPartialContent)
+	written, err := io.Copy(w, io.NewSectionReader(file, want.Start, length))
+
+	n.mu.Lock()
+	n.served += written
+	n.mu.Unlock()
+	n.logger.Debug("chunk served to peer",
+		zap.String("remoteAddr", r.RemoteAddr),
+		zap.Int64("start", want.Start),
+		zap.Int64("size", written),
+		zap.Error(err),
+	)
+}
+
+// parseRange parses a Range header with a single closed byte range
+func parseRange(s string) (Range, error) {
+	var r Range
+	if _, err := fmt.Sscanf(strings.TrimSpace(s), "bytes=%d-%d", &r.Start, &r.End); err != nil || r.Start < 0 || r.End < r.Start {
+		return Range{}, fmt.Errorf("invalid range %q", s)
+	}
+	return r, nil
+}
+
+// Fetch copies the range r of the file from peer to w, with the key of the swarm
+func Fetch(ctx context.Context, client *http.Client, peer Peer, key string, r Range, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(SwarmHeader, key)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.Start, r.End))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("peer %s returned status %d", peer.ID, resp.StatusCode)
+	}
+
+	length := r.End - r.Start + 1
+	n, err := io.Copy(w, io.LimitReader(resp.Body, length))
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("peer %s sent %d of %d bytes: %w", peer.ID, n, length, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
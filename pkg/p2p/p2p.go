@@ -0,0 +1,164 @@
+// Package p2p lets clients downloading the same file from an ezft server
+// exchange the chunks they completed. The server acts as tracker, telling
+// every peer which other peers hold which ranges of the file, as seed,
+// serving all chunks no peer has yet, and as relay to the peers others cannot
+// connect to. Peers behind NAT connect to each other by hole punching, which
+// the tracker negotiates through the relay.
+package p2p
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"time"
+)
+
+const (
+	// SwarmHeader carries the key of the swarm in requests to peers, peers
+	// refuse requests without it
+	SwarmHeader = "X-Ezft-Swarm"
+	// PieceSize the unit the server hashes files in, peers only exchange
+	// chunks made of whole pieces
+	PieceSize = 1024 * 1024
+	// AnnounceInterval interval at which peers announce their ranges
+	AnnounceInterval = 5 * time.Second
+	// PeerTTL time after which peers that stopped announcing leave the swarm
+	PeerTTL = 30 * time.Second
+	// PunchTimeout time two peers try to connect to each other once a hole
+	// punch is negotiated
+	PunchTimeout = 5 * time.Second
+)
+
+// ErrPieceMismatch is returned when data from a peer does not match the hash of its piece
+var ErrPieceMismatch = errors.New("piece does not match its hash")
+
+// Range an inclusive byte range of a file
+type Range struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Announce a peer tells the tracker where it serves the file and which ranges
+// of it it has
+type Announce struct {
+	ID      string  `json:"id"`
+	Port    int     `json:"port"`              // Port of the peer, on the address it announces from
+	URL     string  `json:"url,omitempty"`     // URL of the peer, overriding Port
+	Ranges  []Range `json:"ranges,omitempty"`  // Completed ranges of the file
+	Relay   bool    `json:"relay,omitempty"`   // The peer also serves through the relay of the tracker
+	Punch   bool    `json:"punch,omitempty"`   // Sent from the port of the peer, which accepts hole punches to the address it comes from
+	Stopped bool    `json:"stopped,omitempty"` // The peer leaves the swarm
+}
+
+// Peer another member of a swarm
+type Peer struct {
+	ID     string  `json:"id"`
+	URL    string  `json:"url"`
+	Ranges []Range `json:"ranges"`
+	Relay  bool    `json:"relay,omitempty"` // Reachable through the relay of the tracker as well
+	Addr   string  `json:"addr,omitempty"`  // Public address of the peer accepting hole punches
+}
+
+// Punch a peer asks the tracker to negotiate a hole punch to a relayed peer
+type Punch struct {
+	ID string `json:"id"` // Peer asking
+}
+
+// Swarm the answer of the tracker to an announce
+type Swarm struct {
+	Key   string `json:"key"`   // Secret of the swarm, only given to clients allowed to download the file
+	Peers []Peer `json:"peers"` // Some of the other peers
+}
+
+// Pieces the SHA-256 hashes of the pieces of a file
+type Pieces struct {
+	Size      int64    `json:"size"`
+	PieceSize int64    `json:"piece_size"`
+	Hashes    []string `json:"hashes"`
+}
+
+// Aligned reports whether r is made of whole pieces
+func (p *Pieces) Aligned(r Range) bool {
+	return r.Start%p.PieceSize == 0 && ((r.End+1)%p.PieceSize == 0 || r.End == p.Size-1) && r.End < p.Size
+}
+
+// NewVerifier returns a writer hashing the pieces starting at offset start,
+// which must be the start of a piece
+func (p *Pieces) NewVerifier(start int64) *Verifier {
+	return &Verifier{pieces: p, index: int(start / p.PieceSize), hash: sha256.New()}
+}
+
+// Verifier checks the data of consecutive pieces against their hashes
+type Verifier struct {
+	pieces *Pieces
+	index  int   // Piece being hashed
+	n      int64 // Bytes of the piece hashed so far
+	hash   hash.Hash
+}
+
+// pieceLen returns the size of piece i
+func (p *Pieces) pieceLen(i int) int64 {
+	return min(p.PieceSize, p.Size-int64(i)*p.PieceSize)
+}
+
+// Write hashes b, failing with ErrPieceMismatch once a piece is complete and
+// does not match
+func (v *Verifier) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if v.index >= len(v.pieces.Hashes) {
+			return written, fmt.Errorf("data beyond the last piece")
+		}
+		n := min(int64(len(b)), v.pieces.pieceLen(v.index)-v.n)
+		v.hash.Write(b[:n])
+		v.n += n
+		written += int(n)
+		b = b[n:]
+		if v.n == v.pieces.pieceLen(v.index) {
+			if hex.EncodeToString(v.hash.Sum(nil)) != v.pieces.Hashes[v.index] {
+				return written, fmt.Errorf("piece %d: %w", v.index, ErrPieceMismatch)
+			}
+			v.index++
+			v.n = 0
+			v.hash.Reset()
+		}
+	}
+	return written, nil
+}
+
+// Complete reports an error unless the data ended on a piece boundary
+func (v *Verifier) Complete() error {
+	if v.n != 0 {
+		return fmt.Errorf("piece %d: incomplete", v.index)
+	}
+	return nil
+}
+
+// addRange returns ranges with r added, sorted and merged
+func addRange(ranges []Range, r Range) []Range {
+	ranges = append(ranges, r)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End+1 {
+			last.End = max(last.End, r.End)
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// Covers reports whether the sorted, merged ranges contain r
+func Covers(ranges []Range, r Range) bool {
+	for _, have := range ranges {
+		if have.Start <= r.Start && r.End <= have.End {
+			return true
+		}
+	}
+	return false
+}
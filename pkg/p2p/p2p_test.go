@@ -0,0 +1,334 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddRange(t *testing.T) {
+	var ranges []Range
+	for _, r := range []Range{{10, 19}, {0, 4}, {30, 39}, {5, 9}, {15, 25}} {
+		ranges = addRange(ranges, r)
+	}
+	if fmt.Sprint(ranges) != "[{0 25} {30 39}]" {
+		t.Errorf("ranges = %v, want [{0 25} {30 39}]", ranges)
+	}
+
+	tests := []struct {
+		r    Range
+		want bool
+	}{
+		{Range{0, 25}, true},
+		{Range{3, 7}, true},
+		{Range{30, 39}, true},
+		{Range{20, 30}, false},
+		{Range{26, 29}, false},
+		{Range{35, 40}, false},
+	}
+	for _, tt := range tests {
+		if got := Covers(ranges, tt.r); got != tt.want {
+			t.Errorf("Covers(%v) = %v, want %v", tt.r, got, tt.want)
+		}
+	}
+}
+
+// testPieces returns the pieces of content with the given piece size
+func testPieces(content []byte, pieceSize int64) *Pieces {
+	pieces := &Pieces{Size: int64(len(content)), PieceSize: pieceSize}
+	for offset := int64(0); offset < int64(len(content)); offset += pieceSize {
+		sum := sha256.Sum256(content[offset:min(offset+pieceSize, int64(len(content)))])
+		pieces.Hashes = append(pieces.Hashes, hex.EncodeToString(sum[:]))
+	}
+	return pieces
+}
+
+func TestVerifier(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	pieces := testPieces(content, 100)
+
+	if !pieces.Aligned(Range{100, 249}) || !pieces.Aligned(Range{0, 99}) || pieces.Aligned(Range{50, 149}) || pieces.Aligned(Range{0, 149}) {
+		t.Error("Unexpected alignment")
+	}
+
+	// Pieces are checked whatever the size of the writes
+	v := pieces.NewVerifier(100)
+	for _, b := range [][]byte{content[100:130], content[130:220], content[220:]} {
+		if _, err := v.Write(b); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := v.Complete(); err != nil {
+		t.Errorf("Complete() error = %v", err)
+	}
+
+	v = pieces.NewVerifier(0)
+	v.Write(content[:50])
+	if err := v.Complete(); err == nil {
+		t.Error("Complete() must fail within a piece")
+	}
+	corrupt := bytes.Clone(content[:100])
+	corrupt[42] = 'x'
+	if _, err := pieces.NewVerifier(0).Write(corrupt); !errors.Is(err, ErrPieceMismatch) {
+		t.Errorf("Write() error = %v, want ErrPieceMismatch", err)
+	}
+}
+
+func TestTrackerAnnounce(t *testing.T) {
+	tracker := NewTracker()
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	peers, err := tracker.Announce("swarm", &Announce{ID: "a", Port: 7400}, "10.0.0.1:50000")
+	if err != nil || len(peers) != 0 {
+		t.Fatalf("Announce() = %v, %v", peers, err)
+	}
+	tracker.Announce("other", &Announce{ID: "c", Port: 7400}, "10.0.0.3:50000")
+	ranges := []Range{{0, 99}}
+	peers, err = tracker.Announce("swarm", &Announce{ID: "b", URL: "http://peer-b/", Ranges: ranges}, "10.0.0.2:50000")
+	if err != nil || len(peers) != 1 || peers[0].ID != "a" || peers[0].URL != "http://10.0.0.1:7400/" {
+		t.Fatalf("Announce() = %+v, %v", peers, err)
+	}
+	peers, _ = tracker.Announce("swarm", &Announce{ID: "a", Port: 7400}, "10.0.0.1:50000")
+	if len(peers) != 1 || peers[0].URL != "http://peer-b/" || fmt.Sprint(peers[0].Ranges) != fmt.Sprint(ranges) {
+		t.Errorf("Announce() = %+v", peers)
+	}
+
+	if _, err := tracker.Announce("swarm", &Announce{ID: "d"}, "10.0.0.4:50000"); err == nil {
+		t.Error("Announce() must fail without port")
+	}
+
+	// Peers leave when they stop, or when they stop announcing
+	tracker.Announce("swarm", &Announce{ID: "b", Stopped: true}, "10.0.0.2:50000")
+	if n := tracker.Peers(); n != 2 {
+		t.Errorf("Peers() = %d, want 2", n)
+	}
+	now = now.Add(PeerTTL + time.Second)
+	if n := tracker.Peers(); n != 0 {
+		t.Errorf("Peers() = %d, want 0 after the TTL", n)
+	}
+}
+
+func TestTrackerPieces(t *testing.T) {
+	tracker := NewTracker()
+	content := make([]byte, PieceSize*2+10)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	open := func() (io.ReadCloser, int64, error) {
+		return io.NopCloser(bytes.NewReader(content)), int64(len(content)), nil
+	}
+
+	var pieces *Pieces
+	deadline := time.Now().Add(5 * time.Second)
+	for ok := false; !ok; {
+		if time.Now().After(deadline) {
+			t.Fatal("Pieces were not hashed")
+		}
+		pieces, ok = tracker.Pieces("swarm", open)
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := testPieces(content, PieceSize)
+	if pieces.Size != want.Size || fmt.Sprint(pieces.Hashes) != fmt.Sprint(want.Hashes) {
+		t.Errorf("Pieces() = %+v, want %+v", pieces, want)
+	}
+}
+
+func TestNode(t *testing.T) {
+	content := bytes.Repeat([]byte("chunk data "), 1000)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	node := NewNode(path)
+	node.SetKey("secret")
+	node.Add(Range{0, 4999})
+	if err := node.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go node.Serve()
+	defer node.Close()
+
+	ctx := context.Background()
+	peer := Peer{ID: "node", URL: "http://" + node.Addr().String() + "/"}
+	var buf bytes.Buffer
+	if err := Fetch(ctx, http.DefaultClient, peer, "secret", Range{1000, 2999}, &buf); err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), content[1000:3000]) {
+		t.Error("Fetched range differs")
+	}
+	if node.Served() != 2000 {
+		t.Errorf("Served() = %d, want 2000", node.Served())
+	}
+
+	if err := Fetch(ctx, http.DefaultClient, peer, "wrong", Range{0, 99}, io.Discard); err == nil {
+		t.Error("Fetch() must fail with another key")
+	}
+	if err := Fetch(ctx, http.DefaultClient, peer, "secret", Range{4000, 5999}, io.Discard); err == nil {
+		t.Error("Fetch() must fail for a range the node does not have")
+	}
+}
+
+func TestRelay(t *testing.T) {
+	content := bytes.Repeat([]byte("relayed data "), 1000)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker()
+	relay := NewRelay(tracker)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /relay/{id}", func(w http.ResponseWriter, r *http.Request) {
+		relay.Forward(w, r, r.PathValue("id"))
+	})
+	mux.HandleFunc("GET /relay/{id}/accept", func(w http.ResponseWriter, r *http.Request) {
+		relay.Accept(w, r, r.PathValue("id"))
+	})
+	mux.HandleFunc("POST /relay/{id}/respond/{token}", func(w http.ResponseWriter, r *http.Request) {
+		relay.Respond(w, r, r.PathValue("id"), r.PathValue("token"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// The node never listens, it is only reachable through the relay
+	if _, err := tracker.Announce("swarm", &Announce{ID: "hidden", URL: "http://10.0.0.1:7400/", Relay: true}, "127.0.0.1:1234"); err != nil {
+		t.Fatal(err)
+	}
+	node := NewNode(path)
+	node.SetKey("swarm")
+	node.Add(Range{0, 4999})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go node.Relay(ctx, http.DefaultClient, ts.URL+"/relay/hidden")
+
+	peer := Peer{ID: "hidden", URL: ts.URL + "/relay/hidden"}
+	for i := range 3 {
+		var buf bytes.Buffer
+		r := Range{int64(i) * 1000, int64(i)*1000 + 1499}
+		if err := Fetch(ctx, http.DefaultClient, peer, "swarm", r, &buf); err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if !bytes.Equal(buf.Bytes(), content[r.Start:r.End+1]) {
+			t.Errorf("Relayed range %v differs", r)
+		}
+	}
+
+	if err := Fetch(ctx, http.DefaultClient, peer, "wrong", Range{0, 99}, io.Discard); err == nil {
+		t.Error("Fetch() must fail with another key")
+	}
+	if err := Fetch(ctx, http.DefaultClient, peer, "swarm", Range{4000, 5999}, io.Discard); err == nil {
+		t.Error("Fetch() must fail for a range the node does not have")
+	}
+	if tracker.Relayed("swarm", "other") {
+		t.Error("Relayed() must be false for peers which did not announce")
+	}
+}
+
+func TestPunch(t *testing.T) {
+	content := bytes.Repeat([]byte("punched data "), 1000)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker()
+	relay := NewRelay(tracker)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /relay/{id}/accept", func(w http.ResponseWriter, r *http.Request) {
+		relay.Accept(w, r, r.PathValue("id"))
+	})
+	mux.HandleFunc("POST /relay/{id}/punch", func(w http.ResponseWriter, r *http.Request) {
+		relay.Punch(w, r, r.PathValue("id"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	newNode := func(p string) *Node {
+		node := NewNode(p)
+		node.SetKey("swarm")
+		if err := node.Listen("127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		go node.Serve()
+		t.Cleanup(func() { node.Close() })
+		return node
+	}
+	// Both nodes announce from their port, the hidden one advertising an
+	// address nobody can connect to
+	hidden := newNode(path)
+	hidden.Add(Range{0, 4999})
+	if _, err := tracker.Announce("swarm", &Announce{ID: "hidden", URL: "http://10.0.0.1:7400/", Relay: true, Punch: true}, hidden.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	node := newNode(filepath.Join(t.TempDir(), "data.bin"))
+	peers, err := tracker.Announce("swarm", &Announce{ID: "node", Port: 1, Punch: true}, node.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0].Addr != hidden.Addr().String() {
+		t.Fatalf("Expected the public address of the hidden node, got %+v", peers)
+	}
+	if _, err := tracker.Announce("swarm", &Announce{ID: "other", Port: 1}, "127.0.0.1:1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hidden.Relay(ctx, http.DefaultClient, ts.URL+"/relay/hidden")
+
+	relayURL := ts.URL + "/relay/hidden"
+	conn, err := node.Punch(ctx, http.DefaultClient, relayURL, "node", peers[0])
+	if err != nil {
+		t.Fatalf("Punch() error = %v", err)
+	}
+	dialed := false
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if dialed {
+				return nil, errors.New("connection already used")
+			}
+			dialed = true
+			return conn, nil
+		},
+	}}
+	defer client.CloseIdleConnections()
+	peer := Peer{ID: "hidden", URL: "http://" + peers[0].Addr + "/"}
+	for i := range 3 {
+		var buf bytes.Buffer
+		r := Range{int64(i) * 1000, int64(i)*1000 + 1499}
+		if err := Fetch(ctx, client, peer, "swarm", r, &buf); err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if !bytes.Equal(buf.Bytes(), content[r.Start:r.End+1]) {
+			t.Errorf("Range %v fetched over the punched connection differs", r)
+		}
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		peer Peer
+	}{
+		{"peer without address", "node", Peer{ID: "hidden"}},
+		{"asking peer not accepting punches", "other", peers[0]},
+		{"unknown asking peer", "unknown", peers[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := node.Punch(ctx, http.DefaultClient, relayURL, tt.id, tt.peer); err == nil {
+				t.Error("Punch() must fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,423 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// AcceptTimeout time a relayed peer waits for a request before asking again
+	AcceptTimeout = 25 * time.Second
+	// RelayTimeout time a request waits for the relayed peer to answer,
+	// below the response header timeout of clients
+	RelayTimeout = 5 * time.Second
+	// relayQueueSize bounds the requests waiting for each relayed peer
+	relayQueueSize = 16
+	// maxPunchSize bounds the body of a hole punch request
+	maxPunchSize = 1024
+)
+
+// RelayRequest a request to a relayed peer, handed to it by the relay
+type RelayRequest struct {
+	Token string `json:"token"`           // Identifies the answer of the peer
+	Range string `json:"range"`           // Range header of the request
+	Punch string `json:"punch,omitempty"` // Public address of a peer to connect to by hole punching, instead of a request to answer
+}
+
+// relayCall a request forwarded to a relayed peer, waiting for its answer
+type relayCall struct {
+	RelayRequest
+	resp chan *http.Response // Answer of the peer
+	done chan struct{}       // Closed once the answer is forwarded or given up
+}
+
+// relayQueue the requests waiting for a relayed peer
+type relayQueue struct {
+	key   string // Swarm of the peer
+	calls chan *relayCall
+}
+
+// Relay forwards the requests of peers to the peers of the tracker they cannot
+// reach, such as peers behind NAT. Relayed peers keep asking the relay for
+// requests and post their answers back, so they only open connections to the
+// server. The relay also hands them the hole punches other peers ask for, after
+// which both peers connect to each other directly. Safe for concurrent use.
+type Relay struct {
+	tracker *Tracker
+	logger  *zap.Logger
+
+	mu      sync.Mutex
+	queues  map[string]*relayQueue // Requests waiting for each relayed peer
+	pending map[string]*relayCall  // Requests handed to peers by token
+}
+
+// NewRelay creates a relay for the peers of tracker announcing Relay
+func NewRelay(tracker *Tracker) *Relay {
+	return &Relay{
+		tracker: tracker,
+		logger:  zap.NewNop(),
+		queues:  make(map[string]*relayQueue),
+		pending: make(map[string]*relayCall),
+	}
+}
+
+func (rl *Relay) SetLogger(logger *zap.Logger) {
+	rl.logger = logger
+}
+
+// authorize checks that the request carries the key of the swarm of the
+// relayed peer id, writing an error response otherwise
+func (rl *Relay) authorize(w http.ResponseWriter, r *http.Request, id string) bool {
+	if !rl.tracker.Relayed(r.Header.Get(SwarmHeader), id) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// queue returns the requests waiting for peer id of swarm key, rl.mu must be
+// held. The queues of peers which left are dropped along.
+func (rl *Relay) queue(key, id string) chan *relayCall {
+	if q := rl.queues[id]; q != nil && q.key == key {
+		return q.calls
+	}
+	for other, q := range rl.queues {
+		if !rl.tracker.Relayed(q.key, other) {
+			delete(rl.queues, other)
+		}
+	}
+	q := &relayQueue{key: key, calls: make(chan *relayCall, relayQueueSize)}
+	rl.queues[id] = q
+	return q.calls
+}
+
+// Forward passes the range request r to the relayed peer id and copies its
+// answer to w
+func (rl *Relay) Forward(w http.ResponseWriter, r *http.Request, id string) {
+	if !rl.authorize(w, r, id) {
+		return
+	}
+	token := make([]byte, 16)
+	cryptorand.Read(token)
+	call := &relayCall{
+		RelayRequest: RelayRequest{Token: hex.EncodeToString(token), Range: r.Header.Get("Range")},
+		resp:         make(chan *http.Response, 1),
+		done:         make(chan struct{}),
+	}
+	defer close(call.done)
+
+	rl.mu.Lock()
+	q := rl.queue(r.Header.Get(SwarmHeader), id)
+	rl.pending[call.Token] = call
+	rl.mu.Unlock()
+	defer func() {
+		rl.mu.Lock()
+		delete(rl.pending, call.Token)
+		rl.mu.Unlock()
+	}()
+	select {
+	case q <- call:
+	default:
+		http.Error(w, "Relay busy", http.StatusServiceUnavailable)
+		return
+	}
+
+	timer := time.NewTimer(RelayTimeout)
+	defer timer.Stop()
+	var resp *http.Response
+	select {
+	case resp = <-call.resp:
+	case <-timer.C:
+		http.Error(w, "Peer did not answer", http.StatusGatewayTimeout)
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	for _, h := range []string{"Content-Length", "Content-Range", "Content-Type"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	written, err := io.Copy(w, resp.Body)
+	rl.logger.Debug("chunk relayed",
+		zap.String("peer", id),
+		zap.String("remoteAddr", r.RemoteAddr),
+		zap.Int64("size", written),
+		zap.Error(err),
+	)
+}
+
+// Accept hands the next request for the relayed peer id to it, answering
+// 204 No Content when none came within AcceptTimeout
+func (rl *Relay) Accept(w http.ResponseWriter, r *http.Request, id string) {
+	if !rl.authorize(w, r, id) {
+		return
+	}
+	rl.mu.Lock()
+	q := rl.queue(r.Header.Get(SwarmHeader), id)
+	rl.mu.Unlock()
+
+	timer := time.NewTimer(AcceptTimeout)
+	defer timer.Stop()
+	select {
+	case call := <-q:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(call.RelayRequest)
+	case <-timer.C:
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+}
+
+// Punch negotiates a hole punch between the peer asking and the relayed peer
+// id, which both must accept hole punches: the relayed peer gets the public
+// address of the peer asking and connects to it, while that one connects to the
+// address the tracker lists for the relayed peer.
+func (rl *Relay) Punch(w http.ResponseWriter, r *http.Request, id string) {
+	if !rl.authorize(w, r, id) {
+		return
+	}
+	var p Punch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPunchSize)).Decode(&p); err != nil {
+		http.Error(w, "Invalid punch", http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get(SwarmHeader)
+	addr, ok := rl.tracker.PunchAddr(key, p.ID)
+	if _, target := rl.tracker.PunchAddr(key, id); !ok || !target {
+		http.Error(w, "Hole punches not accepted", http.StatusConflict)
+		return
+	}
+
+	rl.mu.Lock()
+	q := rl.queue(key, id)
+	rl.mu.Unlock()
+	select {
+	case q <- &relayCall{RelayRequest: RelayRequest{Punch: addr}}:
+	default:
+		http.Error(w, "Relay busy", http.StatusServiceUnavailable)
+		return
+	}
+	rl.logger.Debug("hole punch negotiated",
+		zap.String("peer", id),
+		zap.String("from", addr),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Respond forwards the answer of the relayed peer id to the request token,
+// the body of r being the HTTP response of the peer
+func (rl *Relay) Respond(w http.ResponseWriter, r *http.Request, id, token string) {
+	if !rl.authorize(w, r, id) {
+		return
+	}
+	rl.mu.Lock()
+	call := rl.pending[token]
+	rl.mu.Unlock()
+	if call == nil {
+		http.Error(w, "Request expired", http.StatusNotFound)
+		return
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(r.Body), nil)
+	if err != nil {
+		http.Error(w, "Invalid response", http.StatusBadRequest)
+		return
+	}
+	defer resp.Body.Close()
+
+	select {
+	case call.resp <- resp:
+	default:
+		http.Error(w, "Request already answered", http.StatusConflict)
+		return
+	}
+	select {
+	case <-call.done:
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+}
+
+// Relay serves the other peers through the relay at relayURL until ctx is
+// done, for nodes they cannot reach directly, and connects to those asking
+// for a hole punch. Requests to the relay go through client.
+func (n *Node) Relay(ctx context.Context, client *http.Client, relayURL string) {
+	for ctx.Err() == nil {
+		req, err := n.accept(ctx, client, relayURL)
+		if err != nil {
+			n.logger.Debug("failed to accept relayed request", zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		switch {
+		case req == nil:
+		case req.Punch != "":
+			go n.acceptPunch(ctx, req.Punch)
+		default:
+			go n.answer(ctx, client, relayURL, req)
+		}
+	}
+}
+
+// accept waits for the next relayed request, nil if none came
+func (n *Node) accept(ctx context.Context, client *http.Client, relayURL string) (*RelayRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, AcceptTimeout+10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, relayURL+"/accept", nil)
+	if err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	req.Header.Set(SwarmHeader, n.key)
+	n.mu.Unlock()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("relay returned status %d", resp.StatusCode)
+	}
+	var rr RelayRequest
+	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
+		return nil, fmt.Errorf("invalid relayed request: %w", err)
+	}
+	return &rr, nil
+}
+
+// answer serves the relayed request rr and posts the response to the relay
+func (n *Node) answer(ctx context.Context, client *http.Client, relayURL string, rr *RelayRequest) {
+	n.mu.Lock()
+	key := n.key
+	n.mu.Unlock()
+	pr, pw := io.Pipe()
+	go func() {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "relay"
+		r.Header.Set(SwarmHeader, key)
+		r.Header.Set("Range", rr.Range)
+		rw := &pipeResponse{w: pw, header: make(http.Header)}
+		n.ServeHTTP(rw, r)
+		rw.WriteHeader(http.StatusOK)
+		pw.Close()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, relayURL+"/respond/"+rr.Token, pr)
+	if err == nil {
+		req.Header.Set(SwarmHeader, key)
+		req.Header.Set("Content-Type", "application/http")
+		var resp *http.Response
+		if resp, err = client.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				err = fmt.Errorf("relay returned status %d", resp.StatusCode)
+			}
+		}
+	}
+	// Unblocks the handler if the relay stopped reading
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		n.logger.Debug("failed to answer relayed request", zap.Error(err))
+	}
+}
+
+// acceptPunch connects to the peer at addr which asked for a hole punch and
+// serves it over the connection
+func (n *Node) acceptPunch(ctx context.Context, addr string) {
+	accepted, done := n.expect(addr)
+	defer done()
+	conn, err := n.punch(ctx, addr, accepted)
+	if err != nil {
+		n.logger.Debug("failed to accept hole punch", zap.Error(err))
+		return
+	}
+	n.logger.Debug("hole punched",
+		zap.String("remoteAddr", conn.RemoteAddr().String()),
+	)
+	n.serveConn(conn)
+}
+
+// Punch asks the relay of peer at relayURL for a hole punch on behalf of the
+// peer id of the node and returns the connection to peer, which must accept
+// hole punches. Requests to the relay go through client.
+func (n *Node) Punch(ctx context.Context, client *http.Client, relayURL, id string, peer Peer) (net.Conn, error) {
+	if peer.Addr == "" {
+		return nil, fmt.Errorf("peer %s does not accept hole punches", peer.ID)
+	}
+	// Expected before the peer may connect
+	accepted, done := n.expect(peer.Addr)
+	defer done()
+
+	body, err := json.Marshal(Punch{ID: id})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, relayURL+"/punch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	req.Header.Set(SwarmHeader, n.key)
+	n.mu.Unlock()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return nil, fmt.Errorf("relay returned status %d", resp.StatusCode)
+	}
+	return n.punch(ctx, peer.Addr, accepted)
+}
+
+// pipeResponse writes a response in HTTP/1.1 wire format, its body unframed
+// and ending with the stream
+type pipeResponse struct {
+	w           io.Writer
+	header      http.Header
+	wroteHeader bool
+}
+
+func (p *pipeResponse) Header() http.Header {
+	return p.header
+}
+
+func (p *pipeResponse) WriteHeader(code int) {
+	if p.wroteHeader {
+		return
+	}
+	p.wroteHeader = true
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	p.header.Write(&b)
+	b.WriteString("\r\n")
+	io.WriteString(p.w, b.String())
+}
+
+func (p *pipeResponse) Write(b []byte) (int, error) {
+	p.WriteHeader(http.StatusOK)
+	return p.w.Write(b)
+}
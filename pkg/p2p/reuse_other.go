@@ -0,0 +1,11 @@
+//go:build (!unix || solaris) && !windows
+
+package p2p
+
+import "syscall"
+
+// reusePort leaves the socket as is, the connections a node dials cannot
+// share its port, so hole punches fail and peers go through the relay
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build unix && !solaris
+
+package p2p
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePort lets the listener of a node and the connections it dials share
+// its port
+func reusePort(network, address string, c syscall.RawConn) error {
+	var err error
+	if ctrlErr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+		if err == nil {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	return err
+}
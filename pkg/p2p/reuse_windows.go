@@ -0,0 +1,15 @@
+package p2p
+
+import "syscall"
+
+// reusePort lets the listener of a node and the connections it dials share
+// its port
+func reusePort(network, address string, c syscall.RawConn) error {
+	var err error
+	if ctrlErr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+package p2p
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxPeers bounds the peers returned by an announce
+const maxPeers = 50
+
+// maxPieceCaches bounds the files whose piece hashes the tracker keeps
+const maxPieceCaches = 64
+
+// Tracker keeps the swarms of the files being downloaded, safe for concurrent use
+type Tracker struct {
+	secret []byte
+	now    func() time.Time
+
+	mu     sync.Mutex
+	swarms map[string]map[string]*member // Members of the swarms by key and peer ID
+	pieces map[string]*Pieces            // Piece hashes by swarm key, nil while being computed
+}
+
+type member struct {
+	Peer
+	lastSeen time.Time
+}
+
+// alive reports whether the member announced within PeerTTL of now
+func (m *member) alive(now time.Time) bool {
+	return now.Sub(m.lastSeen) <= PeerTTL
+}
+
+// NewTracker creates a tracker with a new secret, deriving the keys of swarms
+func NewTracker() *Tracker {
+	secret := make([]byte, 32)
+	cryptorand.Read(secret)
+	return &Tracker{
+		secret: secret,
+		now:    time.Now,
+		swarms: make(map[string]map[string]*member),
+		pieces: make(map[string]*Pieces),
+	}
+}
+
+// Key returns the key of the swarm of the file at p in its current version,
+// files changing get a new swarm
+func (t *Tracker) Key(p string, info os.FileInfo) string {
+	mac := hmac.New(sha256.New, t.secret)
+	fmt.Fprintf(mac, "%s\x00%d\x00%d", p, info.Size(), info.ModTime().UnixNano())
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Announce records the peer announcing from remoteAddr in the swarm key and
+// returns some of the other peers. Peers announcing without an URL are reached
+// at their announced port on the host they announce from, and peers accepting
+// hole punches at the address they announce from.
+func (t *Tracker) Announce(key string, a *Announce, remoteAddr string) ([]Peer, error) {
+	if a.ID == "" {
+		return nil, fmt.Errorf("missing peer ID")
+	}
+	if a.Stopped {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.swarms[key], a.ID)
+		if len(t.swarms[key]) == 0 {
+			delete(t.swarms, key)
+		}
+		return nil, nil
+	}
+	peerURL := a.URL
+	if peerURL == "" {
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			return nil, err
+		}
+		if a.Port <= 0 || a.Port > 65535 {
+			return nil, fmt.Errorf("invalid port %d", a.Port)
+		}
+		peerURL = "http://" + net.JoinHostPort(host, strconv.Itoa(a.Port)) + "/"
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.expire(now)
+	swarm := t.swarms[key]
+	if swarm == nil {
+		swarm = make(map[string]*member)
+		t.swarms[key] = swarm
+	}
+	peer := Peer{ID: a.ID, URL: peerURL, Ranges: a.Ranges, Relay: a.Relay}
+	if a.Punch {
+		peer.Addr = remoteAddr
+	}
+	swarm[a.ID] = &member{Peer: peer, lastSeen: now}
+
+	peers := make([]Peer, 0, len(swarm)-1)
+	for id, m := range swarm {
+		if id != a.ID {
+			peers = append(peers, m.Peer)
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > maxPeers {
+		peers = peers[:maxPeers]
+	}
+	return peers, nil
+}
+
+// expire removes the peers which stopped announcing, t.mu must be held
+func (t *Tracker) expire(now time.Time) {
+	for key, swarm := range t.swarms {
+		for id, m := range swarm {
+			if !m.alive(now) {
+				delete(swarm, id)
+			}
+		}
+		if len(swarm) == 0 {
+			delete(t.swarms, key)
+		}
+	}
+}
+
+// Peers returns the number of peers in all swarms
+func (t *Tracker) Peers() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(t.now())
+	n := 0
+	for _, swarm := range t.swarms {
+		n += len(swarm)
+	}
+	return n
+}
+
+// Relayed reports whether the peer id of swarm key serves through the relay
+func (t *Tracker) Relayed(key, id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.swarms[key][id]
+	return ok && m.Relay && m.alive(t.now())
+}
+
+// PunchAddr returns the public address of the peer id of swarm key, if it
+// accepts hole punches
+func (t *Tracker) PunchAddr(key, id string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.swarms[key][id]
+	if !ok || m.Addr == "" || !m.alive(t.now()) {
+		return "", false
+	}
+	return m.Addr, true
+}
+
+// Pieces returns the piece hashes of the file of swarm key, opened by open.
+// Hashing a large file takes a while, so it runs in the background and
+// Pieces returns false until the hashes are ready.
+func (t *Tracker) Pieces(key string, open func() (io.ReadCloser, int64, error)) (*Pieces, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if pieces, ok := t.pieces[key]; ok {
+		return pieces, pieces != nil
+	}
+	if len(t.pieces) >= maxPieceCaches {
+		for k, p := range t.pieces {
+			if p != nil {
+				delete(t.pieces, k)
+				break
+			}
+		}
+	}
+	t.pieces[key] = nil
+
+	go func() {
+		pieces, err := hashPieces(open)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if err != nil {
+			delete(t.pieces, key)
+			return
+		}
+		t.pieces[key] = pieces
+	}()
+	return nil, false
+}
+
+// hashPieces hashes the pieces of the file opened by open
+func hashPieces(open func() (io.ReadCloser, int64, error)) (*Pieces, error) {
+	r, size, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	pieces := &Pieces{Size: size, PieceSize: PieceSize}
+	hash := sha256.New()
+	for offset := int64(0); offset < size; offset += PieceSize {
+		hash.Reset()
+		if _, err := io.CopyN(hash, r, min(PieceSize, size-offset)); err != nil {
+			return nil, err
+		}
+		pieces.Hashes = append(pieces.Hashes, hex.EncodeToString(hash.Sum(nil)))
+	}
+	return pieces, nil
+}
@@ -0,0 +1,415 @@
+package quicproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// In blast mode the data of a range travels as UDP datagrams next to the QUIC
+// connection, sent at a rate instead of being bound by a congestion window,
+// which fills links with a high bandwidth-delay product where TCP and QUIC
+// stay far below the capacity. The stream of the request carries the feedback
+// of the client: the blocks to send again and the loss it observed, the server
+// backs off on heavy loss and probes towards the requested rate otherwise.
+//
+// A datagram starts with a zero byte, which QUIC packets never do, followed by
+// the session of the blast and the index of its block, both big endian.
+
+const (
+	blastHeaderSize  = 9                      // Zero byte, session and block index
+	blastBlockSize   = 1200                   // Data of a datagram, fitting the minimal IPv6 MTU with the header
+	blastTick        = time.Millisecond       // Pacing interval of the server
+	blastBurst       = 10 * time.Millisecond  // Traffic the server may send at once after a late tick
+	blastFeedback    = 100 * time.Millisecond // Feedback interval of the client
+	blastMinWait     = 200 * time.Millisecond // Minimal wait before requesting a block again
+	blastIdleTimeout = 10 * time.Second       // A blast fails after the client received nothing for this long
+	blastMaxSilence  = 30 * time.Second       // A blast fails after the server received no feedback for this long
+	blastMinSamples  = 16                     // Datagrams of a feedback needed to adjust the rate
+	maxMissing       = 4096                   // Blocks requested again by a single feedback
+	sessionBuffer    = 1024                   // Datagrams queued for a blast, more are dropped
+)
+
+// ReadWriterAt the destination of a blast, read again to verify it
+type ReadWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// blockCount returns the number of blocks of a range of length bytes
+func blockCount(length int64) uint32 {
+	return uint32((length + blastBlockSize - 1) / blastBlockSize)
+}
+
+// blockLen returns the data bytes of a block of a range of length bytes
+func blockLen(length int64, block uint32) int {
+	return int(min(length-int64(block)*blastBlockSize, blastBlockSize))
+}
+
+// adjustRate returns the rate of a blast following a feedback
+func adjustRate(rate, target float64, fb *Feedback) float64 {
+	total := fb.Received + fb.Lost
+	if total < blastMinSamples {
+		return rate
+	}
+	loss := float64(fb.Lost) / float64(total)
+	switch {
+	case loss > 0.1:
+		return max(rate*0.8, target/32)
+	case loss < 0.02:
+		return min(rate*1.1, target)
+	}
+	return rate
+}
+
+// blast sends the range of file requested by req as datagrams to the client of
+// conn, reading its feedback from r, and answers with the checksum of the
+// range once the client received all of it. It returns the bytes of data sent,
+// retransmissions included.
+func (s *Server) blast(conn *quic.Conn, w io.Writer, r *bufio.Reader, file *os.File, req *Request) (int64, error) {
+	done := make(chan struct{})
+	defer close(done)
+	feedback := make(chan *Feedback)
+	go func() {
+		defer close(feedback)
+		for {
+			var fb Feedback
+			if err := readHeader(r, &fb); err != nil {
+				return
+			}
+			select {
+			case feedback <- &fb:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	blocks := blockCount(req.Length)
+	target := float64(req.Rate)
+	rate := target
+	var (
+		next   uint32   // Next block of the first pass
+		queue  []uint32 // Blocks to send again
+		queued = make([]bool, blocks)
+		credit float64 // Bytes the rate allows to send now
+		sent   int64
+	)
+	// The first pass reads the range in order, hashing it for the checksum
+	first := bufio.NewReaderSize(io.NewSectionReader(file, req.Offset, req.Length), 256*1024)
+	hash := sha256.New()
+	packet := make([]byte, blastHeaderSize+blastBlockSize)
+	binary.BigEndian.PutUint32(packet[1:5], req.Session)
+	addr := conn.RemoteAddr()
+
+	send := func() error {
+		var block uint32
+		switch {
+		case len(queue) > 0:
+			block, queue = queue[0], queue[1:]
+			queued[block] = false
+			data := packet[blastHeaderSize : blastHeaderSize+blockLen(req.Length, block)]
+			if _, err := file.ReadAt(data, req.Offset+int64(block)*blastBlockSize); err != nil {
+				return err
+			}
+		case next < blocks:
+			block = next
+			next++
+			data := packet[blastHeaderSize : blastHeaderSize+blockLen(req.Length, block)]
+			if _, err := io.ReadFull(first, data); err != nil {
+				return err
+			}
+			hash.Write(data)
+		default:
+			credit = 0
+			return nil
+		}
+		binary.BigEndian.PutUint32(packet[5:9], block)
+		n := blastHeaderSize + blockLen(req.Length, block)
+		if _, err := s.transport.WriteTo(packet[:n], addr); err != nil {
+			return err
+		}
+		credit -= float64(n)
+		sent += int64(n - blastHeaderSize)
+		return nil
+	}
+
+	ticker := time.NewTicker(blastTick)
+	defer ticker.Stop()
+	silence := time.NewTimer(blastMaxSilence)
+	defer silence.Stop()
+	last := time.Now()
+	for {
+		select {
+		case fb, ok := <-feedback:
+			if !ok {
+				return sent, errors.New("blast aborted by the client")
+			}
+			silence.Reset(blastMaxSilence)
+			if fb.Done {
+				if _, err := io.Copy(hash, first); err != nil {
+					return sent, err
+				}
+				return sent, writeHeader(w, &Response{Checksum: hex.EncodeToString(hash.Sum(nil))})
+			}
+			rate = adjustRate(rate, target, fb)
+			for _, block := range fb.Missing {
+				if block < next && !queued[block] {
+					queued[block] = true
+					queue = append(queue, block)
+				}
+			}
+		case now := <-ticker.C:
+			credit = min(credit+now.Sub(last).Seconds()*rate, rate*blastBurst.Seconds())
+			last = now
+			for credit > 0 {
+				if err := send(); err != nil {
+					return sent, err
+				}
+			}
+		case <-silence.C:
+			return sent, fmt.Errorf("no blast feedback for %s", blastMaxSilence)
+		case <-conn.Context().Done():
+			return sent, context.Cause(conn.Context())
+		}
+	}
+}
+
+// receiver tracks the blocks received by a blast
+type receiver struct {
+	length    int64
+	received  []bool
+	requested []time.Time // Last time a block was requested again
+	remaining uint32
+	complete  uint32    // Blocks before it were all received
+	highest   int64     // Highest block received, -1 before the first
+	checked   uint32    // Blocks before it were counted as lost if missing
+	got       int       // Datagrams received since the previous feedback
+	lost      int       // Blocks found missing since the previous feedback
+	lastData  time.Time // Arrival of the last datagram
+}
+
+func newReceiver(length int64) *receiver {
+	blocks := blockCount(length)
+	return &receiver{
+		length:    length,
+		received:  make([]bool, blocks),
+		requested: make([]time.Time, blocks),
+		remaining: blocks,
+		highest:   -1,
+		lastData:  time.Now(),
+	}
+}
+
+// receive accounts a datagram, returning the block and its data unless it
+// is invalid or was received before
+func (r *receiver) receive(packet []byte) (uint32, []byte, bool) {
+	r.got++
+	r.lastData = time.Now()
+	block := binary.BigEndian.Uint32(packet[5:9])
+	data := packet[blastHeaderSize:]
+	if block >= uint32(len(r.received)) || r.received[block] || len(data) != blockLen(r.length, block) {
+		return 0, nil, false
+	}
+	r.received[block] = true
+	r.remaining--
+	r.highest = max(r.highest, int64(block))
+	return block, data, true
+}
+
+// feedback returns the feedback to send now. Blocks skipped by the in order
+// first pass of the server were lost, the tail of the range is requested
+// again once no data arrived for wait. A block is requested again at most once
+// per wait.
+func (r *receiver) feedback(now time.Time, wait time.Duration) *Feedback {
+	for ; int64(r.checked) < r.highest; r.checked++ {
+		if !r.received[r.checked] {
+			r.lost++
+		}
+	}
+	for r.complete < uint32(len(r.received)) && r.received[r.complete] {
+		r.complete++
+	}
+	end := uint32(r.highest + 1)
+	if now.Sub(r.lastData) >= wait {
+		end = uint32(len(r.received))
+	}
+
+	fb := &Feedback{Received: r.got, Lost: r.lost}
+	for block := r.complete; block < end && len(fb.Missing) < maxMissing; block++ {
+		if !r.received[block] && now.Sub(r.requested[block]) >= wait {
+			fb.Missing = append(fb.Missing, block)
+			r.requested[block] = now
+		}
+	}
+	r.got, r.lost = 0, 0
+	return fb
+}
+
+// BlastChunk receives length bytes of the file at p from offset as datagrams
+// the server sends at up to rate bytes per second, writing them to dst at their
+// offsets in the file. The range is verified against the checksum sent by the
+// server, returning ErrChecksum when they differ. With a resume token it fails
+// with ErrChanged once the file changed.
+func (c *Conn) BlastChunk(ctx context.Context, p, token string, offset, length, rate int64, dst ReadWriterAt) error {
+	session, packets := c.packetConn.subscribe()
+	defer c.packetConn.unsubscribe(session)
+
+	ex, err := c.request(ctx, &Request{Op: OpBlast, Path: p, Token: token, Offset: offset, Length: length, Rate: rate, Session: session})
+	if err != nil {
+		return err
+	}
+	defer ex.close()
+	if ex.resp.Length != length {
+		return fmt.Errorf("server sent %d bytes instead of %d", ex.resp.Length, length)
+	}
+	final := make(chan *Response, 1)
+	go func() {
+		var resp Response
+		if err := readHeader(ex.r, &resp); err != nil {
+			resp.Error = err.Error()
+		}
+		final <- &resp
+	}()
+
+	recv := newReceiver(length)
+	ticker := time.NewTicker(blastFeedback)
+	defer ticker.Stop()
+	for recv.remaining > 0 {
+		select {
+		case packet := <-packets:
+			block, data, ok := recv.receive(packet)
+			if !ok {
+				continue
+			}
+			if _, err := dst.WriteAt(data, offset+int64(block)*blastBlockSize); err != nil {
+				return err
+			}
+		case now := <-ticker.C:
+			if now.Sub(recv.lastData) > blastIdleTimeout {
+				return fmt.Errorf("blast received no data for %s", blastIdleTimeout)
+			}
+			wait := max(2*c.conn.ConnectionStats().SmoothedRTT, blastMinWait)
+			if err := writeHeader(ex.stream, recv.feedback(now, wait)); err != nil {
+				return err
+			}
+		case resp := <-final:
+			if err := resp.err(); err != nil {
+				return fmt.Errorf("blast ended early: %w", err)
+			}
+			return errors.New("blast ended early")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if err := writeHeader(ex.stream, &Feedback{Done: true}); err != nil {
+		return err
+	}
+	ex.stream.Close()
+	var resp *Response
+	select {
+	case resp = <-final:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := resp.err(); err != nil {
+		return fmt.Errorf("failed to read blast checksum: %w", err)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(dst, offset, length)); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != resp.Checksum {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// packetConn the socket of a client connection. It hands the blast datagrams
+// from the server to their sessions on the read loop of QUIC, which only sees
+// the other packets.
+type packetConn struct {
+	net.PacketConn
+	udpConn *net.UDPConn
+	server  string // Address of the server
+
+	mu          sync.Mutex
+	lastSession uint32
+	sessions    map[uint32]chan []byte // Datagrams of the blasts in progress by session
+}
+
+func newPacketConn(udpConn *net.UDPConn, server net.Addr) *packetConn {
+	return &packetConn{
+		PacketConn: udpConn,
+		udpConn:    udpConn,
+		server:     server.String(),
+		sessions:   make(map[uint32]chan []byte),
+	}
+}
+
+// SetReadBuffer, SetWriteBuffer and SyscallConn let QUIC tune the socket
+
+func (c *packetConn) SetReadBuffer(bytes int) error {
+	return c.udpConn.SetReadBuffer(bytes)
+}
+
+func (c *packetConn) SetWriteBuffer(bytes int) error {
+	return c.udpConn.SetWriteBuffer(bytes)
+}
+
+func (c *packetConn) SyscallConn() (syscall.RawConn, error) {
+	return c.udpConn.SyscallConn()
+}
+
+// ReadFrom returns the next packet which is not a blast datagram
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || n < blastHeaderSize || b[0] != 0 {
+			return n, addr, err
+		}
+		if addr.String() != c.server {
+			continue
+		}
+		c.mu.Lock()
+		packets := c.sessions[binary.BigEndian.Uint32(b[1:5])]
+		c.mu.Unlock()
+		if packets == nil {
+			continue
+		}
+		select {
+		case packets <- bytes.Clone(b[:n]):
+		default:
+		}
+	}
+}
+
+// subscribe returns a new session and the channel receiving its datagrams
+func (c *packetConn) subscribe() (uint32, chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSession++
+	packets := make(chan []byte, sessionBuffer)
+	c.sessions[c.lastSession] = packets
+	return c.lastSession, packets
+}
+
+func (c *packetConn) unsubscribe(session uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sessions, session)
+}
@@ -0,0 +1,177 @@
+package quicproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/quic-go/quic-go"
+)
+
+// Conn a client connection to an ezft QUIC server, safe for concurrent use.
+// Every request runs on its own stream, so a lost packet only delays the
+// chunk it belongs to.
+type Conn struct {
+	conn       *quic.Conn
+	packetConn *packetConn
+	transport  *quic.Transport
+	username   string
+	password   string
+	limiter    *ratelimit.Limiter
+}
+
+// Dial connects to the server at the UDP address addr, which must present the
+// certificate with the given fingerprint
+func Dial(ctx context.Context, addr, fingerprint string) (*Conn, error) {
+	tlsConfig := &tls.Config{
+		NextProtos: []string{ALPN},
+		// The certificate is self-signed, it is verified by its fingerprint
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 || !strings.EqualFold(certFingerprint(cs.PeerCertificates[0].Raw), fingerprint) {
+				return fmt.Errorf("certificate of %s does not match its fingerprint", addr)
+			}
+			return nil
+		},
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect over QUIC: %w", err)
+	}
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect over QUIC: %w", err)
+	}
+	// The connection owns its socket, blast datagrams arrive on it as well
+	pc := newPacketConn(udpConn, raddr)
+	transport := &quic.Transport{Conn: pc}
+	conn, err := transport.Dial(ctx, raddr, tlsConfig, quicConfig())
+	if err != nil {
+		transport.Close()
+		udpConn.Close()
+		return nil, fmt.Errorf("failed to connect over QUIC: %w", err)
+	}
+	return &Conn{conn: conn, packetConn: pc, transport: transport}, nil
+}
+
+// SetAuth sends the given credentials with all requests
+func (c *Conn) SetAuth(username, password string) {
+	c.username = username
+	c.password = password
+}
+
+// SetRateLimiter limits the chunk data received by limiter
+func (c *Conn) SetRateLimiter(limiter *ratelimit.Limiter) {
+	c.limiter = limiter
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	c.conn.CloseWithError(0, "")
+	c.transport.Close()
+	return c.packetConn.Close()
+}
+
+// Stat returns the size, modification time and resume token of the file at p
+func (c *Conn) Stat(ctx context.Context, p string) (*Info, error) {
+	ex, err := c.request(ctx, &Request{Op: OpStat, Path: p})
+	if err != nil {
+		return nil, err
+	}
+	ex.close()
+	return &ex.resp.Info, nil
+}
+
+// ReadChunk writes length bytes of the file at p from offset to w and verifies
+// them against the hash sent by the server, returning ErrChecksum when they
+// differ. With a resume token it fails with ErrChanged once the file changed.
+func (c *Conn) ReadChunk(ctx context.Context, p, token string, offset, length int64, w io.Writer) error {
+	ex, err := c.request(ctx, &Request{Op: OpChunk, Path: p, Token: token, Offset: offset, Length: length})
+	if err != nil {
+		return err
+	}
+	defer ex.close()
+	if ex.resp.Length != length {
+		return fmt.Errorf("server sent %d bytes instead of %d", ex.resp.Length, length)
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), c.limiter.Reader(ctx, io.LimitReader(ex.r, length)))
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("chunk ended after %d of %d bytes: %w", n, length, io.ErrUnexpectedEOF)
+	}
+	sum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(ex.r, sum); err != nil {
+		return fmt.Errorf("failed to read chunk checksum: %w", err)
+	}
+	if !bytes.Equal(sum, hash.Sum(nil)) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// exchange a request and the stream carrying it
+type exchange struct {
+	resp   *Response
+	r      *bufio.Reader // Data following the response
+	stream *quic.Stream
+	stop   func() bool
+}
+
+// close closes the stream of the exchange
+func (e *exchange) close() {
+	e.stop()
+	e.stream.CancelRead(0)
+	e.stream.Close()
+}
+
+// request sends req on a new stream and reads the response, the caller must
+// close the exchange once it read the data following the response. The send
+// side of the stream stays open for the feedback of blasts.
+func (c *Conn) request(ctx context.Context, req *Request) (*exchange, error) {
+	stream, err := c.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ex := &exchange{stream: stream}
+	ex.stop = context.AfterFunc(ctx, func() {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+	})
+
+	req.Username = c.username
+	req.Password = c.password
+	if err := writeHeader(stream, req); err != nil {
+		ex.close()
+		return nil, err
+	}
+	if req.Op != OpBlast {
+		stream.Close()
+	}
+
+	ex.r = bufio.NewReaderSize(stream, maxHeaderSize)
+	var resp Response
+	if err := readHeader(ex.r, &resp); err != nil {
+		ex.close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := resp.err(); err != nil {
+		ex.close()
+		return nil, err
+	}
+	ex.resp = &resp
+	return ex, nil
+}
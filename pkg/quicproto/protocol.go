@@ -0,0 +1,198 @@
+package quicproto
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ALPN protocol ezft peers negotiate over QUIC
+const ALPN = "ezft/1"
+
+// Operations of requests
+const (
+	OpStat  = "stat"  // Returns the size, modification time and resume token of a file
+	OpChunk = "chunk" // Returns a byte range of a file followed by its SHA-256
+	OpBlast = "blast" // Sends a byte range of a file as UDP datagrams at a given rate
+)
+
+// maxHeaderSize bounds the JSON line opening requests and responses
+const maxHeaderSize = 64 * 1024
+
+var (
+	// ErrNotFound is returned when the requested file does not exist
+	ErrNotFound = errors.New("file not found")
+	// ErrUnauthorized is returned when the credentials are missing or wrong
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrChanged is returned when the file changed since its resume token was issued
+	ErrChanged = errors.New("remote file changed since the resume token was issued")
+	// ErrChecksum is returned when a received chunk does not match its hash
+	ErrChecksum = errors.New("chunk checksum mismatch")
+)
+
+// errorCodes maps the error codes of responses to errors
+var errorCodes = map[string]error{
+	"not_found":    ErrNotFound,
+	"unauthorized": ErrUnauthorized,
+	"changed":      ErrChanged,
+}
+
+// Discovery response of the quic endpoint of the HTTP server, telling
+// clients where to reach the server over QUIC
+type Discovery struct {
+	Port        int    `json:"port"`        // UDP port, on the host of the HTTP server
+	Fingerprint string `json:"fingerprint"` // SHA-256 of the certificate of the server
+	Blast       bool   `json:"blast"`       // Whether the server supports the UDP blast mode
+}
+
+// Request the JSON line opening a stream, every stream carries one request
+type Request struct {
+	Op       string `json:"op"`
+	Path     string `json:"path"`              // Slash separated path below the server root
+	Token    string `json:"token,omitempty"`   // Resume token of the file, chunk requests fail with ErrChanged once it changed
+	Offset   int64  `json:"offset,omitempty"`  // First byte of a chunk
+	Length   int64  `json:"length,omitempty"`  // Bytes of a chunk
+	Rate     int64  `json:"rate,omitempty"`    // Target rate of a blast in bytes per second
+	Session  uint32 `json:"session,omitempty"` // ID the datagrams of a blast carry, chosen by the client
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// Info describes a remote file
+type Info struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Token   string    `json:"token"` // Resume token, changes with the size or modification time of the file
+}
+
+// Response the JSON line answering a request. The data of a chunk follows it,
+// then the SHA-256 of the data. A blast is answered by a second response with
+// the checksum once the client received all datagrams.
+type Response struct {
+	Info
+	Length   int64  `json:"length,omitempty"`   // Bytes of chunk data following the response
+	Checksum string `json:"checksum,omitempty"` // SHA-256 of a blasted range, sent once the client received it
+	Error    string `json:"error,omitempty"`
+	Code     string `json:"code,omitempty"` // not_found, unauthorized, changed or invalid
+}
+
+// Feedback the JSON lines a client sends on the stream of a blast while
+// receiving its datagrams
+type Feedback struct {
+	Missing  []uint32 `json:"missing,omitempty"`  // Blocks to send again
+	Received int      `json:"received,omitempty"` // Datagrams received since the previous feedback
+	Lost     int      `json:"lost,omitempty"`     // Blocks found missing since the previous feedback
+	Done     bool     `json:"done,omitempty"`     // All blocks were received
+}
+
+// err returns the error of a response, nil if it succeeded
+func (r *Response) err() error {
+	if r.Error == "" {
+		return nil
+	}
+	if err, ok := errorCodes[r.Code]; ok {
+		return err
+	}
+	return errors.New(r.Error)
+}
+
+// errorResponse returns the response reporting err
+func errorResponse(err error) *Response {
+	code := "invalid"
+	for c, e := range errorCodes {
+		if errors.Is(err, e) {
+			code = c
+		}
+	}
+	return &Response{Error: err.Error(), Code: code}
+}
+
+// writeHeader writes v as a JSON line
+func writeHeader(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// readHeader reads a JSON line into v
+func readHeader(r *bufio.Reader, v any) error {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return fmt.Errorf("header exceeds %d bytes", maxHeaderSize)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, v)
+}
+
+// resumeToken identifies the version of the file at p
+func resumeToken(p string, info os.FileInfo) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%d\x00%d", p, info.Size(), info.ModTime().UnixNano()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// quicConfig tunes QUIC for transfers over links with a high bandwidth-delay
+// product, where the default flow control windows would throttle a stream
+func quicConfig() *quic.Config {
+	return &quic.Config{
+		MaxIdleTimeout:                 time.Minute,
+		KeepAlivePeriod:                15 * time.Second,
+		InitialStreamReceiveWindow:     4 * 1024 * 1024,
+		MaxStreamReceiveWindow:         32 * 1024 * 1024,
+		InitialConnectionReceiveWindow: 16 * 1024 * 1024,
+		MaxConnectionReceiveWindow:     128 * 1024 * 1024,
+		MaxIncomingStreams:             256,
+	}
+}
+
+// newCertificate generates the self-signed certificate of a server, clients
+// trust it by the fingerprint the server advertises over HTTP
+func newCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "ezft"},
+		DNSNames:     []string{"ezft"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// certFingerprint returns the hex SHA-256 of a DER certificate
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
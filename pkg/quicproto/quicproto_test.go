@@ -0,0 +1,250 @@
+package quicproto
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestServer serves root over QUIC on a local port, requiring credentials
+// if auth is given
+func newTestServer(t *testing.T, root string, auth ...string) *Server {
+	t.Helper()
+
+	s, err := NewServer(root)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	if len(auth) == 2 {
+		s.SetAuth(auth[0], auth[1])
+	}
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func dial(t *testing.T, s *Server) *Conn {
+	t.Helper()
+
+	conn, err := Dial(context.Background(), s.Addr().String(), s.Fingerprint())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestReadChunk(t *testing.T) {
+	root := t.TempDir()
+	content := make([]byte, 3*1024*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	if err := os.WriteFile(filepath.Join(root, "data.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	conn := dial(t, newTestServer(t, root))
+	ctx := context.Background()
+
+	info, err := conn.Stat(ctx, "/data.bin")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size != int64(len(content)) || info.Token == "" {
+		t.Errorf("Unexpected info %+v", info)
+	}
+
+	// Chunks are transferred on concurrent streams
+	got := make([]byte, len(content))
+	chunk := int64(1024 * 1024)
+	errs := make(chan error, 3)
+	for offset := int64(0); offset < int64(len(content)); offset += chunk {
+		go func() {
+			var buf bytes.Buffer
+			err := conn.ReadChunk(ctx, "/data.bin", info.Token, offset, chunk, &buf)
+			copy(got[offset:], buf.Bytes())
+			errs <- err
+		}()
+	}
+	for range 3 {
+		if err := <-errs; err != nil {
+			t.Fatalf("ReadChunk() error = %v", err)
+		}
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("Chunks differ from the file")
+	}
+
+	if err := conn.ReadChunk(ctx, "/data.bin", info.Token, int64(len(content))-10, 20, &bytes.Buffer{}); err == nil {
+		t.Error("Expected an error for a range beyond the file")
+	}
+	if _, err := conn.Stat(ctx, "/missing.bin"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() error = %v, want ErrNotFound", err)
+	}
+	if _, err := conn.Stat(ctx, "/../../etc/passwd"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Paths must stay below the root, got %v", err)
+	}
+}
+
+func TestResumeToken(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "data.bin")
+	if err := os.WriteFile(path, []byte("version 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conn := dial(t, newTestServer(t, root))
+	ctx := context.Background()
+
+	info, err := conn.Stat(ctx, "/data.bin")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("version 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+
+	err = conn.ReadChunk(ctx, "/data.bin", info.Token, 0, 9, &bytes.Buffer{})
+	if !errors.Is(err, ErrChanged) {
+		t.Errorf("ReadChunk() error = %v, want ErrChanged", err)
+	}
+	var buf bytes.Buffer
+	if err := conn.ReadChunk(ctx, "/data.bin", "", 0, 9, &buf); err != nil || buf.String() != "version 2" {
+		t.Errorf("ReadChunk() without token = %q, %v", buf.String(), err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "data.bin"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conn := dial(t, newTestServer(t, root, "user", "secret"))
+	ctx := context.Background()
+
+	if _, err := conn.Stat(ctx, "/data.bin"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Stat() error = %v, want ErrUnauthorized", err)
+	}
+	conn.SetAuth("user", "secret")
+	if _, err := conn.Stat(ctx, "/data.bin"); err != nil {
+		t.Errorf("Stat() error = %v", err)
+	}
+}
+
+func TestDialFingerprint(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	other, err := NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := Dial(ctx, s.Addr().String(), other.Fingerprint()); err == nil {
+		t.Error("Dial() must reject a certificate with another fingerprint")
+	}
+}
+
+func TestBlastChunk(t *testing.T) {
+	root := t.TempDir()
+	content := make([]byte, 2*1024*1024+77)
+	rand.New(rand.NewSource(2)).Read(content)
+	if err := os.WriteFile(filepath.Join(root, "data.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	conn := dial(t, newTestServer(t, root))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	info, err := conn.Stat(ctx, "/data.bin")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	dst, err := os.Create(filepath.Join(t.TempDir(), "data.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	// Two blasts share the connection
+	half := int64(len(content)) / 2
+	errs := make(chan error, 2)
+	for _, offset := range []int64{0, half} {
+		go func() {
+			errs <- conn.BlastChunk(ctx, "/data.bin", info.Token, offset, min(half+1, int64(len(content))-offset), 50*1024*1024, dst)
+		}()
+	}
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatalf("BlastChunk() error = %v", err)
+		}
+	}
+	if got, _ := os.ReadFile(dst.Name()); !bytes.Equal(got, content) {
+		t.Error("Blasted file differs")
+	}
+
+	if err := conn.BlastChunk(ctx, "/data.bin", info.Token, 0, 10, 0, dst); err == nil {
+		t.Error("Expected an error for a blast without rate")
+	}
+}
+
+func TestReceiverFeedback(t *testing.T) {
+	length := int64(10*blastBlockSize - 100)
+	r := newReceiver(length)
+	packet := func(block uint32) []byte {
+		p := make([]byte, blastHeaderSize+max(blockLen(length, block), 1))
+		binary.BigEndian.PutUint32(p[5:9], block)
+		return p
+	}
+	for _, block := range []uint32{0, 1, 3, 4, 6, 4} {
+		r.receive(packet(block))
+	}
+	if _, _, ok := r.receive(packet(20)); ok {
+		t.Error("Blocks beyond the range must be ignored")
+	}
+
+	now := time.Now()
+	fb := r.feedback(now, time.Second)
+	if fmt.Sprint(fb.Missing) != "[2 5]" || fb.Lost != 2 || fb.Received != 7 {
+		t.Errorf("Unexpected feedback %+v", fb)
+	}
+	// Requested blocks wait before being requested again, the tail is
+	// requested once no data arrived for the wait
+	fb = r.feedback(now.Add(500*time.Millisecond), time.Second)
+	if len(fb.Missing) != 0 || fb.Lost != 0 || fb.Received != 0 {
+		t.Errorf("Unexpected feedback %+v", fb)
+	}
+	fb = r.feedback(now.Add(2*time.Second), time.Second)
+	if fmt.Sprint(fb.Missing) != "[2 5 7 8 9]" {
+		t.Errorf("Missing = %v, want [2 5 7 8 9]", fb.Missing)
+	}
+}
+
+func TestAdjustRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		fb       Feedback
+		rate     float64
+		expected float64
+	}{
+		{"heavy loss", Feedback{Received: 80, Lost: 20}, 1000, 800},
+		{"floor", Feedback{Received: 10, Lost: 90}, 30, 1000.0 / 32},
+		{"no loss", Feedback{Received: 100}, 500, 550},
+		{"at target", Feedback{Received: 100}, 1000, 1000},
+		{"moderate loss", Feedback{Received: 95, Lost: 5}, 500, 500},
+		{"few samples", Feedback{Received: 5, Lost: 5}, 500, 500},
+	}
+	for _, tt := range tests {
+		if got := adjustRate(tt.rate, 1000, &tt.fb); got != tt.expected {
+			t.Errorf("%s: adjustRate() = %v, want %v", tt.name, got, tt.expected)
+		}
+	}
+}
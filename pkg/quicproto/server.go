@@ -0,0 +1,282 @@
+package quicproto
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/metrics"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/utils"
+	"github.com/quic-go/quic-go"
+	"go.uber.org/zap"
+)
+
+// Server serves the files below a root directory over QUIC
+type Server struct {
+	root        string
+	mu          sync.RWMutex // Guards the credentials, limiter and threshold, which change while serving
+	username    string
+	password    string
+	authEnabled bool
+	cert        tls.Certificate
+	udpConn     *net.UDPConn
+	transport   *quic.Transport // Shares the UDP socket between QUIC and blast datagrams
+	listener    *quic.Listener
+	limiter     *ratelimit.Limiter // Bandwidth limit of the file data of all streams, nil for none
+	slowRequest time.Duration      // Requests taking longer are logged as slow, 0 disables it
+	logger      *zap.Logger
+}
+
+// NewServer creates a server of the files below root with a new self-signed
+// certificate
+func NewServer(root string) (*Server, error) {
+	cert, err := newCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate: %w", err)
+	}
+	return &Server{root: root, cert: cert, logger: zap.NewNop()}, nil
+}
+
+func (s *Server) SetLogger(logger *zap.Logger) {
+	s.logger = logger
+}
+
+// SetRateLimiter limits the file data sent by limiter, the rate of blast
+// transfers being capped by its current rate. It may be called while
+// serving, streams already sending keep the limiter they started with.
+func (s *Server) SetRateLimiter(limiter *ratelimit.Limiter) {
+	s.mu.Lock()
+	s.limiter = limiter
+	s.mu.Unlock()
+}
+
+// SetSlowRequestThreshold logs requests taking longer than threshold at warn
+// level, 0 disables it
+func (s *Server) SetSlowRequestThreshold(threshold time.Duration) {
+	s.mu.Lock()
+	s.slowRequest = threshold
+	s.mu.Unlock()
+}
+
+// SetAuth requires the given credentials for all requests, an empty username
+// accepts requests without any. It may be called while serving.
+func (s *Server) SetAuth(username, password string) {
+	s.mu.Lock()
+	s.username = username
+	s.password = password
+	s.authEnabled = username != ""
+	s.mu.Unlock()
+}
+
+// authorized reports whether req carries the credentials the server requires
+func (s *Server) authorized(req *Request) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.authEnabled ||
+		(subtle.ConstantTimeCompare([]byte(req.Username), []byte(s.username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(req.Password), []byte(s.password)) == 1)
+}
+
+// rateLimiter returns the bandwidth limit of the streams starting, nil for none
+func (s *Server) rateLimiter() *ratelimit.Limiter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.limiter
+}
+
+// slowThreshold returns the duration of the requests logged as slow
+func (s *Server) slowThreshold() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.slowRequest
+}
+
+// Fingerprint returns the SHA-256 of the server certificate, which clients
+// verify instead of a certificate chain
+func (s *Server) Fingerprint() string {
+	return certFingerprint(s.cert.Certificate[0])
+}
+
+// Listen listens on the UDP address addr
+func (s *Server) Listen(addr string) error {
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{s.cert},
+		NextProtos:   []string{ALPN},
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for QUIC: %w", err)
+	}
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for QUIC: %w", err)
+	}
+	transport := &quic.Transport{Conn: udpConn}
+	listener, err := transport.Listen(tlsConfig, quicConfig())
+	if err != nil {
+		transport.Close()
+		udpConn.Close()
+		return fmt.Errorf("failed to listen for QUIC: %w", err)
+	}
+	s.udpConn = udpConn
+	s.transport = transport
+	s.listener = listener
+	return nil
+}
+
+// Addr returns the address the server listens on, nil before Listen
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Serve accepts connections until Close
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept(context.Background())
+		if err != nil {
+			if errors.Is(err, quic.ErrServerClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the server and closes its connections
+func (s *Server) Close() error {
+	s.listener.Close()
+	s.transport.Close()
+	return s.udpConn.Close()
+}
+
+// serveConn serves the streams of a connection, each one carrying a request
+func (s *Server) serveConn(conn *quic.Conn) {
+	for {
+		stream, err := conn.AcceptStream(conn.Context())
+		if err != nil {
+			return
+		}
+		go s.serveStream(conn, stream)
+	}
+}
+
+func (s *Server) serveStream(conn *quic.Conn, stream *quic.Stream) {
+	defer stream.Close()
+
+	var req Request
+	r := bufio.NewReaderSize(stream, maxHeaderSize)
+	if err := readHeader(r, &req); err != nil {
+		stream.CancelRead(0)
+		return
+	}
+	start := time.Now()
+	metrics.ServerStats.Add(metrics.ActiveRequests, 1)
+	n, err := s.handle(conn, stream, r, &req)
+	metrics.ServerStats.Add(metrics.ActiveRequests, -1)
+	duration := time.Since(start)
+	metrics.ServerStats.Add(metrics.Requests, 1)
+	metrics.ServerStats.Add(metrics.BytesSent, n)
+	s.logger.Info("QUIC request served",
+		zap.String("remoteAddr", conn.RemoteAddr().String()),
+		zap.String("op", req.Op),
+		zap.String("path", req.Path),
+		zap.Int64("offset", req.Offset),
+		zap.Int64("respSize", n),
+		zap.Duration("duration", duration),
+		zap.Error(err),
+	)
+	if threshold := s.slowThreshold(); threshold > 0 && duration > threshold {
+		metrics.ServerStats.Add(metrics.SlowRequests, 1)
+		s.logger.Warn("Slow QUIC request",
+			zap.String("remoteAddr", conn.RemoteAddr().String()),
+			zap.String("op", req.Op),
+			zap.String("path", req.Path),
+			zap.Int64("offset", req.Offset),
+			zap.Int64("length", req.Length),
+			zap.Int64("respSize", n),
+			zap.Duration("duration", duration),
+			zap.Duration("threshold", threshold),
+			zap.String("speed", utils.CalculateSpeed(n, duration)),
+			zap.Error(err),
+		)
+	}
+}
+
+// handle answers a request read from r on stream w, returning the bytes of
+// file data sent
+func (s *Server) handle(conn *quic.Conn, w io.Writer, r *bufio.Reader, req *Request) (int64, error) {
+	if !s.authorized(req) {
+		return 0, writeHeader(w, errorResponse(ErrUnauthorized))
+	}
+
+	name := filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+req.Path)))
+	file, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrNotFound
+		}
+		return 0, writeHeader(w, errorResponse(err))
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, writeHeader(w, errorResponse(fmt.Errorf("not a regular file: %s", req.Path)))
+	}
+
+	resp := &Response{Info: Info{Size: info.Size(), ModTime: info.ModTime(), Token: resumeToken(req.Path, info)}}
+	switch req.Op {
+	case OpStat:
+		return 0, writeHeader(w, resp)
+	case OpChunk, OpBlast:
+		if req.Token != "" && req.Token != resp.Token {
+			return 0, writeHeader(w, errorResponse(ErrChanged))
+		}
+		if req.Offset < 0 || req.Length <= 0 || req.Offset+req.Length > info.Size() {
+			return 0, writeHeader(w, errorResponse(fmt.Errorf("invalid range %d+%d of %d bytes", req.Offset, req.Length, info.Size())))
+		}
+		resp.Length = req.Length
+		if req.Op == OpBlast {
+			if req.Rate <= 0 {
+				return 0, writeHeader(w, errorResponse(fmt.Errorf("invalid rate %d", req.Rate)))
+			}
+			if limiter := s.rateLimiter(); limiter != nil && limiter.Rate() > 0 {
+				req.Rate = min(req.Rate, limiter.Rate())
+			}
+			if err := writeHeader(w, resp); err != nil {
+				return 0, err
+			}
+			return s.blast(conn, w, r, file, req)
+		}
+		if err := writeHeader(w, resp); err != nil {
+			return 0, err
+		}
+		hash := sha256.New()
+		if limiter := s.rateLimiter(); limiter != nil {
+			w = limiter.Writer(conn.Context(), w)
+		}
+		n, err := io.Copy(io.MultiWriter(w, hash), io.NewSectionReader(file, req.Offset, req.Length))
+		if err != nil {
+			return n, err
+		}
+		_, err = w.Write(hash.Sum(nil))
+		return n, err
+	default:
+		return 0, writeHeader(w, errorResponse(fmt.Errorf("unknown operation %q", req.Op)))
+	}
+}
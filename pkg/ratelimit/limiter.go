@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxReadSize bounds a single limited read so waits stay short
+const maxReadSize = 32 * 1024
+
+// Limiter token bucket shared by all transfers of a process, its rate follows
+// a schedule. A burst of up to one second of traffic is allowed
+type Limiter struct {
+	schedule *Schedule
+	now      func() time.Time
+	parent   *Limiter // Limiter whose rate this share splits, nil unless created by Share
+	weight   int      // Weight of a share
+
+	mu      sync.Mutex
+	tokens  float64 // Negative while transfers are in debt
+	last    time.Time
+	weights int // Total weight of the open shares of the limiter
+}
+
+// NewLimiter creates a limiter following schedule
+func NewLimiter(schedule *Schedule) *Limiter {
+	return &Limiter{
+		schedule: schedule,
+		now:      time.Now,
+	}
+}
+
+// Schedule returns the schedule followed by the limiter
+func (l *Limiter) Schedule() *Schedule {
+	return l.schedule
+}
+
+// Share returns a limiter for one of several transfers splitting the rate of
+// l: each open share gets the part weight / total weight of all open shares, so
+// all shares together stay within the rate of l. A weight below 1 counts as 1.
+// The share must be closed once its transfer finished.
+func (l *Limiter) Share(weight int) *Limiter {
+	weight = max(weight, 1)
+	l.mu.Lock()
+	l.weights += weight
+	l.mu.Unlock()
+	return &Limiter{schedule: l.schedule, now: l.now, parent: l, weight: weight}
+}
+
+// Close releases the weight of a share, it does nothing for other limiters
+func (l *Limiter) Close() {
+	if l == nil || l.parent == nil {
+		return
+	}
+	l.parent.mu.Lock()
+	l.parent.weights -= l.weight
+	l.parent.mu.Unlock()
+}
+
+// Rate returns the rate in effect now, for shares their current part of it
+func (l *Limiter) Rate() int64 {
+	return l.rateAt(l.now())
+}
+
+// rateAt returns the rate in effect at t, for shares their part of it
+func (l *Limiter) rateAt(t time.Time) int64 {
+	rate := l.schedule.RateAt(t)
+	if l.parent == nil || rate <= 0 {
+		return rate
+	}
+	l.parent.mu.Lock()
+	total := max(l.parent.weights, l.weight)
+	l.parent.mu.Unlock()
+	return max(rate*int64(l.weight)/int64(total), 1)
+}
+
+// WaitN accounts n transferred bytes, blocking until the rate in effect allows them
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	rate := float64(l.rateAt(now))
+	if rate <= 0 {
+		l.tokens = 0
+		l.last = now
+		l.mu.Unlock()
+		return nil
+	}
+
+	if l.last.IsZero() {
+		l.tokens = rate
+	} else {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*rate, rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reader returns a reader limited by l
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r, limiter: l}
+}
+
+// Writer returns a writer limited by l
+func (l *Limiter) Writer(ctx context.Context, w io.Writer) io.Writer {
+	return &writer{ctx: ctx, w: w, limiter: l}
+}
+
+type writer struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), maxReadSize)
+		if err := w.limiter.WaitN(w.ctx, n); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > maxReadSize {
+		p = p[:maxReadSize]
+	}
+	n, err := r.r.Read(p)
+	if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
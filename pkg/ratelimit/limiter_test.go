@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterReader(t *testing.T) {
+	// 64KB/s with a one second burst, 96KB takes about half a second
+	l := NewLimiter(NewSchedule(64 << 10))
+	data := make([]byte, 96<<10)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, l.Reader(context.Background(), bytes.NewReader(data)))
+	elapsed := time.Since(start)
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("Copy() = %d, %v", n, err)
+	}
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected about 500ms, took %v", elapsed)
+	}
+}
+
+func TestLimiterWriter(t *testing.T) {
+	l := NewLimiter(NewSchedule(64 << 10))
+	var buf bytes.Buffer
+
+	start := time.Now()
+	n, err := l.Writer(context.Background(), &buf).Write(make([]byte, 96<<10))
+	elapsed := time.Since(start)
+	if err != nil || n != 96<<10 || buf.Len() != n {
+		t.Fatalf("Write() = %d, %v, %d bytes written", n, err, buf.Len())
+	}
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected about 500ms, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Writer(ctx, &buf).Write(make([]byte, 128<<10)); err == nil {
+		t.Error("Write() must fail once the context is canceled")
+	}
+}
+
+func TestLimiterFollowsSchedule(t *testing.T) {
+	s, _ := ParseSchedule("09:00,1K 18:00,off")
+	l := NewLimiter(s)
+
+	// Outside of the limited window nothing blocks
+	l.now = func() time.Time { return time.Date(2024, 1, 1, 20, 0, 0, 0, time.Local) }
+	start := time.Now()
+	if err := l.WaitN(context.Background(), 10<<20); err != nil {
+		t.Fatalf("WaitN() error = %v", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("Unlimited window should not block")
+	}
+
+	// Inside it, a large transfer blocks until the context is done
+	l.now = func() time.Time { return time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local) }
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.WaitN(ctx, 10<<20); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	if err := l.WaitN(context.Background(), 1<<30); err != nil {
+		t.Errorf("Nil limiter should not limit, got %v", err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	body := make([]byte, 96<<10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ := io.ReadAll(r.Body)
+		w.Write(received)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: NewTransport(nil, NewLimiter(NewSchedule(128<<10)))}
+
+	// The upload takes the burst, echoing it back waits for the refill
+	start := time.Now()
+	resp, err := client.Post(ts.URL, "application/octet-stream", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	received, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	elapsed := time.Since(start)
+
+	if err != nil || len(received) != len(body) {
+		t.Fatalf("Expected %d bytes echoed, got %d (%v)", len(body), len(received), err)
+	}
+	if elapsed < 400*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("Expected about 500ms, took %v", elapsed)
+	}
+}
+
+func TestLimiterShare(t *testing.T) {
+	l := NewLimiter(NewSchedule(60 << 10))
+	now := time.Now()
+
+	small := l.Share(1)
+	large := l.Share(2)
+	if got := small.rateAt(now); got != 20<<10 {
+		t.Errorf("Share of weight 1 rate = %d, want %d", got, 20<<10)
+	}
+	if got := large.rateAt(now); got != 40<<10 {
+		t.Errorf("Share of weight 2 rate = %d, want %d", got, 40<<10)
+	}
+
+	// A closed share leaves its part to the others
+	large.Close()
+	if got := small.rateAt(now); got != 60<<10 {
+		t.Errorf("Remaining share rate = %d, want %d", got, 60<<10)
+	}
+	if got := l.Share(0).rateAt(now); got != 30<<10 {
+		t.Errorf("Share of weight 0 rate = %d, want %d", got, 30<<10)
+	}
+
+	// Shares of an unlimited limiter are unlimited
+	if got := NewLimiter(NewSchedule(Unlimited)).Share(1).rateAt(now); got != Unlimited {
+		t.Errorf("Share of unlimited rate = %d", got)
+	}
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+// Unlimited rate of windows without a bandwidth limit
+const Unlimited = 0
+
+// window bandwidth limit starting at a time of day, in effect until the next window starts
+type window struct {
+	Start time.Duration // Offset from midnight, local time
+	Rate  int64         // Bytes per second, Unlimited for no limit
+}
+
+// Schedule time-of-day bandwidth limits
+type Schedule struct {
+	windows []window // Sorted by start
+}
+
+// NewSchedule creates a schedule applying rate all day
+func NewSchedule(rate int64) *Schedule {
+	return &Schedule{windows: []window{{Start: 0, Rate: rate}}}
+}
+
+// ParseSchedule parses a bandwidth schedule, either a single rate applied all
+// day such as "1M", or space separated HH:MM,rate windows each lasting until
+// the next one, e.g. "09:00,1M 18:00,off" limits to 1MB/s during office hours.
+// The last window of the day carries on past midnight until the first one
+func ParseSchedule(s string) (*Schedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty bandwidth schedule")
+	}
+	if len(fields) == 1 && !strings.Contains(fields[0], ",") {
+		rate, err := ParseRate(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return NewSchedule(rate), nil
+	}
+
+	schedule := &Schedule{}
+	seen := map[time.Duration]bool{}
+	for _, field := range fields {
+		at, rateStr, ok := strings.Cut(field, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule window %q, expected HH:MM,rate", field)
+		}
+		start, err := parseTimeOfDay(at)
+		if err != nil {
+			return nil, err
+		}
+		if seen[start] {
+			return nil, fmt.Errorf("duplicate schedule window at %s", at)
+		}
+		seen[start] = true
+
+		rate, err := ParseRate(rateStr)
+		if err != nil {
+			return nil, err
+		}
+		schedule.windows = append(schedule.windows, window{Start: start, Rate: rate})
+	}
+	sort.Slice(schedule.windows, func(i, j int) bool {
+		return schedule.windows[i].Start < schedule.windows[j].Start
+	})
+	return schedule, nil
+}
+
+// RateAt returns the limit in bytes per second in effect at t
+func (s *Schedule) RateAt(t time.Time) int64 {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	// Before the first window of the day the last one of the previous day applies
+	rate := s.windows[len(s.windows)-1].Rate
+	for _, w := range s.windows {
+		if w.Start > offset {
+			break
+		}
+		rate = w.Rate
+	}
+	return rate
+}
+
+// String formats the schedule in the syntax accepted by ParseSchedule
+func (s *Schedule) String() string {
+	if len(s.windows) == 1 && s.windows[0].Start == 0 {
+		return formatRate(s.windows[0].Rate)
+	}
+	parts := make([]string, len(s.windows))
+	for i, w := range s.windows {
+		parts[i] = fmt.Sprintf("%02d:%02d,%s", int(w.Start.Hours()), int(w.Start.Minutes())%60, formatRate(w.Rate))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseRate parses a rate in bytes per second with an optional binary unit
+// suffix (K, M, G or T, optionally followed by i, B or B/s) such as 500k,
+// 10M or 1.5GiB, "off" or 0 for no limit. The client, server and daemon
+// limits are all parsed by it.
+func ParseRate(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	if v == "OFF" || v == "UNLIMITED" {
+		return Unlimited, nil
+	}
+	rate, err := utils.ParseBytes(strings.TrimSuffix(v, "/S"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q, expected bytes per second such as 500k, 10M or 1.5G", s)
+	}
+	return rate, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func formatRate(rate int64) string {
+	if rate == Unlimited {
+		return "off"
+	}
+	return utils.FormatSize(rate)
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"512k", 512 * 1024, false},
+		{"1M", 1 << 20, false},
+		{"1MB", 1 << 20, false},
+		{"1.5MB/s", 3 << 19, false},
+		{"2G", 2 << 30, false},
+		{"500k", 500 << 10, false},
+		{"10M", 10 << 20, false},
+		{"1.5G", 3 << 29, false},
+		{"1.5GiB", 3 << 29, false},
+		{"64KiB/s", 64 << 10, false},
+		{"1T", 1 << 40, false},
+		{"off", Unlimited, false},
+		{"0", Unlimited, false},
+		{"fast", 0, true},
+		{"-1M", 0, true},
+		{"inf", 0, true},
+		{"NaN", 0, true},
+		{"1e30G", 0, true},
+		{"1.5X", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseRate(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRate() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleRateAt(t *testing.T) {
+	s, err := ParseSchedule("18:00,off 09:00,1M 12:00,512K")
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		t    time.Time
+		want int64
+	}{
+		{at(0, 30), Unlimited}, // Previous day's last window
+		{at(9, 0), 1 << 20},
+		{at(11, 59), 1 << 20},
+		{at(12, 0), 512 << 10},
+		{at(17, 59), 512 << 10},
+		{at(18, 0), Unlimited},
+		{at(23, 59), Unlimited},
+	}
+	for _, tt := range tests {
+		if got := s.RateAt(tt.t); got != tt.want {
+			t.Errorf("RateAt(%s) = %d, want %d", tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+
+	if got := s.String(); got != "09:00,1M 12:00,512K 18:00,off" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestParseScheduleSingleRate(t *testing.T) {
+	s, err := ParseSchedule("2M")
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+	if got := s.RateAt(time.Now()); got != 2<<20 {
+		t.Errorf("RateAt() = %d, want %d", got, 2<<20)
+	}
+	if s.String() != "2M" {
+		t.Errorf("String() = %q", s.String())
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, in := range []string{"", "09:00", "25:00,1M", "09:00,fast", "09:00,1M 09:00,2M", "1M 2M"} {
+		if _, err := ParseSchedule(in); err == nil {
+			t.Errorf("Expected error for %q", in)
+		}
+	}
+}
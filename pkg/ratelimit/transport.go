@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+)
+
+// Transport limits request and response bodies of an http.RoundTripper
+type Transport struct {
+	Base    http.RoundTripper // Underlying transport, http.DefaultTransport if nil
+	Limiter *Limiter
+}
+
+// NewTransport wraps base so all bodies sent and received are limited by limiter
+func NewTransport(base http.RoundTripper, limiter *Limiter) *Transport {
+	return &Transport{Base: base, Limiter: limiter}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		limited := req.Clone(req.Context())
+		limited.Body = &readCloser{Reader: t.Limiter.Reader(req.Context(), req.Body), Closer: req.Body}
+		req = limited
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &readCloser{Reader: t.Limiter.Reader(req.Context(), resp.Body), Closer: resp.Body}
+	return resp, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,182 @@
+package rsyncd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// maxLine longest line of the daemon handshake
+	maxLine = 4096
+	// maxFrame largest data written in one multiplexed message
+	maxFrame = 32 * 1024
+	// mplexBase added to the message code in the header of multiplexed messages
+	mplexBase = 7
+)
+
+// Message codes of the multiplexed stream
+const (
+	msgData  = 0
+	msgError = 3
+	msgInfo  = 2
+)
+
+var errLineTooLong = errors.New("line too long")
+
+// conn reads and writes the values of the rsync protocol. Errors are sticky:
+// after the first one reads return zero values and writes are dropped, so
+// callers check err once per step instead of after every value.
+type conn struct {
+	r       *bufio.Reader
+	w       io.Writer
+	out     []byte
+	mux     bool // Output is multiplexed, from the start of the transfer on
+	err     error
+	read    int64 // Bytes read, reported to the client at the end
+	written int64 // Bytes written, reported to the client at the end
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: w}
+}
+
+func (c *conn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// readFull reads len(p) bytes, flushing the output first as the client may
+// wait for it before it sends more
+func (c *conn) readFull(p []byte) {
+	c.flush()
+	if c.err != nil {
+		clear(p)
+		return
+	}
+	n, err := io.ReadFull(c.r, p)
+	c.read += int64(n)
+	if err != nil {
+		c.fail(err)
+		clear(p)
+	}
+}
+
+func (c *conn) readInt() int32 {
+	var b [4]byte
+	c.readFull(b[:])
+	return int32(binary.LittleEndian.Uint32(b[:]))
+}
+
+// readLine reads a line of the handshake without its newline
+func (c *conn) readLine() string {
+	c.flush()
+	if c.err != nil {
+		return ""
+	}
+	var line []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			c.fail(err)
+			return ""
+		}
+		c.read++
+		if b == '\n' {
+			return strings.TrimSuffix(string(line), "\r")
+		}
+		if len(line) >= maxLine {
+			c.fail(errLineTooLong)
+			return ""
+		}
+		line = append(line, b)
+	}
+}
+
+func (c *conn) write(p []byte) {
+	if c.err != nil {
+		return
+	}
+	c.out = append(c.out, p...)
+	if len(c.out) >= maxFrame {
+		c.flush()
+	}
+}
+
+func (c *conn) writeByte(b byte) {
+	c.write([]byte{b})
+}
+
+func (c *conn) writeInt(v int32) {
+	c.write(binary.LittleEndian.AppendUint32(nil, uint32(v)))
+}
+
+// writeLongint writes a 64-bit value, as 32 bits when it fits
+func (c *conn) writeLongint(v int64) {
+	if v >= 0 && v <= 0x7fffffff {
+		c.writeInt(int32(v))
+		return
+	}
+	c.writeInt(-1)
+	c.write(binary.LittleEndian.AppendUint64(nil, uint64(v)))
+}
+
+// printf writes a line of the handshake
+func (c *conn) printf(format string, args ...any) {
+	c.write(fmt.Appendf(nil, format, args...))
+}
+
+// startMux multiplexes everything written from now on
+func (c *conn) startMux() {
+	c.flush()
+	c.mux = true
+}
+
+// message sends text to the client as a message of code, the client prints
+// it. Before the output is multiplexed it is dropped.
+func (c *conn) message(code byte, text string) {
+	if !c.mux {
+		return
+	}
+	c.flush()
+	c.frame(code, []byte(text))
+}
+
+// flush writes the buffered output, as data messages once multiplexed
+func (c *conn) flush() {
+	if c.err != nil || len(c.out) == 0 {
+		return
+	}
+	out := c.out
+	c.out = c.out[:0]
+	if !c.mux {
+		c.send(out)
+		return
+	}
+	for len(out) > 0 {
+		n := min(len(out), maxFrame)
+		c.frame(msgData, out[:n])
+		out = out[n:]
+	}
+}
+
+// frame writes a multiplexed message
+func (c *conn) frame(code byte, data []byte) {
+	header := uint32(mplexBase+code)<<24 | uint32(len(data))
+	c.send(append(binary.LittleEndian.AppendUint32(nil, header), data...))
+}
+
+func (c *conn) send(p []byte) {
+	if c.err != nil {
+		return
+	}
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	if err != nil {
+		c.fail(err)
+	}
+}
@@ -0,0 +1,364 @@
+package rsyncd
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"golang.org/x/crypto/md4"
+)
+
+// Flags of the entries of the file list
+const (
+	xmitTopDir   = 0x01
+	xmitSameMode = 0x02
+	xmitSameUID  = 0x08
+	xmitSameGID  = 0x10
+	xmitSameName = 0x20
+	xmitLongName = 0x40
+	xmitSameTime = 0x80
+)
+
+// File types of the modes on the wire
+const (
+	modeType = 0o170000
+	modeDir  = 0o040000
+	modeFile = 0o100000
+	modeLink = 0o120000
+)
+
+// checksumLen length of the file checksums of the file list
+const checksumLen = md4.Size
+
+// file an entry of the file list
+type file struct {
+	name  string // Slash separated path of the transfer, "." for the directory whose contents are sent
+	path  string // Path on disk
+	mode  uint32 // Type and permission bits as on the wire
+	size  int64
+	mtime int64
+	uid   int
+	gid   int
+	link  string // Target of a symbolic link
+	top   bool   // Directory given by the client
+	sum   []byte // MD4 of the contents, with --checksum
+}
+
+func (f *file) regular() bool {
+	return f.mode&modeType == modeFile
+}
+
+// buildList lists the files of the paths the client asked for, sorted by name
+// as the client sorts them: the file indexes of the transfer refer to the
+// sorted list
+func (s *session) buildList() {
+	seen := map[string]bool{}
+	for _, p := range s.opts.paths {
+		s.addPath(p, seen)
+	}
+	slices.SortFunc(s.files, func(a, b *file) int {
+		return strings.Compare(a.name, b.name)
+	})
+}
+
+// addPath adds a path of the client, its contents too if it is a directory
+// the client asked the contents of or when recursing
+func (s *session) addPath(p string, seen map[string]bool) {
+	contents := strings.HasSuffix(p, "/")
+	p = strings.TrimSuffix(p, "/")
+	diskPath := filepath.Join(s.module.Path, filepath.FromSlash(p))
+	stat := os.Lstat
+	if contents {
+		stat = os.Stat
+	}
+	info, err := stat(diskPath)
+	if err != nil {
+		s.errorf("failed to stat %s: %v", p, unwrapPath(err))
+		return
+	}
+	name := path.Base(p)
+	if contents {
+		name = "."
+	}
+	f, info := s.newFile(name, diskPath, info, nil)
+	if f == nil {
+		return
+	}
+	if !info.IsDir() {
+		s.add(f, seen)
+		return
+	}
+	if !s.opts.recursive && !s.opts.dirs {
+		s.infof("skipping directory %s", name)
+		return
+	}
+	f.top = true
+	s.add(f, seen)
+	if contents || s.opts.recursive {
+		s.addDir(f, []os.FileInfo{info}, seen)
+	}
+}
+
+// addDir adds the entries of the directory dir, descending into
+// subdirectories when recursing. parents are the directories dir is in,
+// itself included, to tell loops of followed links.
+func (s *session) addDir(dir *file, parents []os.FileInfo, seen map[string]bool) {
+	entries, err := os.ReadDir(dir.path)
+	if err != nil {
+		s.errorf("failed to read directory %s: %v", dir.name, unwrapPath(err))
+	}
+	for _, e := range entries {
+		if manifest.IsInternal(e.Name()) {
+			continue
+		}
+		name := e.Name()
+		if dir.name != "." {
+			name = dir.name + "/" + name
+		}
+		diskPath := filepath.Join(dir.path, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			s.errorf("failed to stat %s: %v", name, unwrapPath(err))
+			continue
+		}
+		f, info := s.newFile(name, diskPath, info, parents)
+		if f == nil {
+			continue
+		}
+		s.add(f, seen)
+		if info.IsDir() && s.opts.recursive {
+			s.addDir(f, append(parents, info), seen)
+		}
+	}
+}
+
+// add appends f to the list unless a file of the same name is listed
+func (s *session) add(f *file, seen map[string]bool) {
+	if seen[f.name] {
+		return
+	}
+	seen[f.name] = true
+	s.files = append(s.files, f)
+}
+
+// newFile returns the entry of the file at diskPath with the lstat info, nil
+// if it is not sent. Symbolic links are sent as links or followed depending
+// on the options, the info returned is the one of the file sent.
+func (s *session) newFile(name, diskPath string, info os.FileInfo, parents []os.FileInfo) (*file, os.FileInfo) {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Stat(diskPath)
+		switch {
+		case s.opts.copyLinks || (s.opts.copyDirLinks && err == nil && target.IsDir()):
+			if err != nil {
+				s.errorf("symbolic link %s has no referent", name)
+				return nil, nil
+			}
+			for _, parent := range parents {
+				if os.SameFile(parent, target) {
+					s.infof("skipping directory loop %s", name)
+					return nil, nil
+				}
+			}
+			info = target
+		case s.opts.links:
+			link, err := os.Readlink(diskPath)
+			if err != nil {
+				s.errorf("failed to read symbolic link %s: %v", name, unwrapPath(err))
+				return nil, nil
+			}
+			f := s.fileOf(name, diskPath, info)
+			f.mode = modeLink | 0o777
+			f.size = int64(len(link))
+			f.link = filepath.ToSlash(link)
+			return f, info
+		default:
+			s.infof("skipping non-regular file %q", name)
+			return nil, nil
+		}
+	}
+
+	f := s.fileOf(name, diskPath, info)
+	switch {
+	case info.IsDir():
+		f.mode |= modeDir
+	case info.Mode().IsRegular():
+		f.mode |= modeFile
+		f.size = info.Size()
+		if s.opts.checksum {
+			sum, err := fileChecksum(diskPath)
+			if err != nil {
+				s.errorf("failed to read %s: %v", name, unwrapPath(err))
+				return nil, nil
+			}
+			f.sum = sum
+		}
+	default:
+		s.infof("skipping non-regular file %q", name)
+		return nil, nil
+	}
+	return f, info
+}
+
+// fileOf returns the entry of a file with the attributes common to all types
+func (s *session) fileOf(name, diskPath string, info os.FileInfo) *file {
+	f := &file{
+		name:  name,
+		path:  diskPath,
+		mode:  permBits(info.Mode()),
+		mtime: info.ModTime().Unix(),
+	}
+	f.uid, f.gid = owner(info)
+	return f
+}
+
+// permBits returns the permission bits of mode as on the wire
+func permBits(mode fs.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	return bits
+}
+
+// fileChecksum returns the MD4 of the contents of the file at path
+func fileChecksum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := md4.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// unwrapPath drops the operation and path of a file error, the client is
+// told the path relative to the module instead
+func unwrapPath(err error) error {
+	if pe, ok := err.(*fs.PathError); ok {
+		return pe.Err
+	}
+	return err
+}
+
+// sendList sends the file list. Every entry only carries the attributes that
+// differ from the entry before it, and the part of its name after the prefix
+// shared with the name before it.
+func (s *session) sendList() {
+	c := s.c
+	var (
+		lastName string
+		mode     uint32
+		mtime    int32
+		uid, gid int
+	)
+	for _, f := range s.files {
+		var flags byte
+		if f.top {
+			flags |= xmitTopDir
+		}
+		if f.mode == mode {
+			flags |= xmitSameMode
+		} else {
+			mode = f.mode
+		}
+		if !s.opts.owner || (f.uid == uid && lastName != "") {
+			flags |= xmitSameUID
+		} else {
+			uid = f.uid
+		}
+		if !s.opts.group || (f.gid == gid && lastName != "") {
+			flags |= xmitSameGID
+		} else {
+			gid = f.gid
+		}
+		if int32(f.mtime) == mtime {
+			flags |= xmitSameTime
+		} else {
+			mtime = int32(f.mtime)
+		}
+		shared := 0
+		for shared < min(len(f.name), len(lastName), 255) && f.name[shared] == lastName[shared] {
+			shared++
+		}
+		rest := f.name[shared:]
+		if shared > 0 {
+			flags |= xmitSameName
+		}
+		if len(rest) > 255 {
+			flags |= xmitLongName
+		}
+		// A zero byte ends the list, the top directory flag means nothing
+		// to files and the long name flag only widens the length
+		if flags == 0 {
+			if f.mode&modeType == modeDir {
+				flags |= xmitLongName
+			} else {
+				flags |= xmitTopDir
+			}
+		}
+
+		c.writeByte(flags)
+		if flags&xmitSameName != 0 {
+			c.writeByte(byte(shared))
+		}
+		if flags&xmitLongName != 0 {
+			c.writeInt(int32(len(rest)))
+		} else {
+			c.writeByte(byte(len(rest)))
+		}
+		c.write([]byte(rest))
+		c.writeLongint(f.size)
+		if flags&xmitSameTime == 0 {
+			c.writeInt(mtime)
+		}
+		if flags&xmitSameMode == 0 {
+			c.writeInt(int32(mode))
+		}
+		if flags&xmitSameUID == 0 {
+			c.writeInt(int32(uid))
+		}
+		if flags&xmitSameGID == 0 {
+			c.writeInt(int32(gid))
+		}
+		if f.mode&modeType == modeLink {
+			c.writeInt(int32(len(f.link)))
+			c.write([]byte(f.link))
+		}
+		if s.opts.checksum {
+			sum := f.sum
+			if sum == nil {
+				sum = make([]byte, checksumLen)
+			}
+			c.write(sum)
+		}
+		lastName = f.name
+	}
+	c.writeByte(0)
+
+	// No user and group names follow the IDs, the client keeps the IDs
+	if s.opts.owner && !s.opts.numericIDs {
+		c.writeInt(0)
+	}
+	if s.opts.group && !s.opts.numericIDs {
+		c.writeInt(0)
+	}
+	if s.opts.ignoreErrors {
+		c.writeInt(0)
+	} else {
+		c.writeInt(s.ioError)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package rsyncd
+
+import "os"
+
+// owner returns the user and group ID of a file, which files have not here
+func owner(info os.FileInfo) (uid, gid int) {
+	return 0, 0
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package rsyncd
+
+import (
+	"os"
+	"syscall"
+)
+
+// owner returns the user and group ID of a file
+func owner(info os.FileInfo) (uid, gid int) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+	return 0, 0
+}
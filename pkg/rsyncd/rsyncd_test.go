@@ -0,0 +1,634 @@
+package rsyncd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+// testBlockLength block length of the checksums the test client sends
+const testBlockLength = 700
+
+// newTestServer serves a module "files" of a directory with a few files,
+// links and ezft bookkeeping
+func newTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range map[string][]byte{
+		"a.bin":         testData(20000),
+		"-x.txt":        []byte("sorts before the directory itself"),
+		"dir/b.txt":     []byte("bbb"),
+		"dir/sub/c.txt": []byte("ccc"),
+		".ezft/state":   []byte("{}"),
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.bin", filepath.Join(root, "link")); err != nil {
+		t.Skipf("symbolic links not supported: %v", err)
+	}
+
+	s := NewServer(Config{
+		Addr:    "127.0.0.1:0",
+		Modules: []Module{{Name: "files", Path: root, Comment: "test files"}, {Name: "other", Path: t.TempDir()}},
+	})
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return s, root
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+	return data
+}
+
+// checksum1 the rolling checksum as rsync computes it over a whole block,
+// from signed chars
+func checksum1(buf []byte) uint32 {
+	var s1, s2 uint32
+	b := func(i int) uint32 { return uint32(int8(buf[i])) }
+	i := 0
+	for ; i < len(buf)-4; i += 4 {
+		s2 += 4*(s1+b(i)) + 3*b(i+1) + 2*b(i+2) + b(i+3)
+		s1 += b(i) + b(i+1) + b(i+2) + b(i+3)
+	}
+	for ; i < len(buf); i++ {
+		s1 += b(i)
+		s2 += s1
+	}
+	return s1&0xffff + s2<<16
+}
+
+// testClient the receiving side of the rsync protocol, pulling like a stock
+// client with protocol version 27
+type testClient struct {
+	t        *testing.T
+	conn     net.Conn
+	r        *bufio.Reader
+	mux      bool
+	in       []byte   // Data of the multiplexed messages not read yet
+	messages []string // Errors and notices of the server
+}
+
+// entry a file of the file list
+type entry struct {
+	name  string
+	size  int64
+	mtime int32
+	mode  uint32
+	link  string
+	sum   []byte
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	c := &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	if got := c.line(); got != "@RSYNCD: 27.0" {
+		t.Fatalf("Expected greeting @RSYNCD: 27.0, got %q", got)
+	}
+	c.send("@RSYNCD: 31.0 sha512 sha256 sha1 md5 md4\n")
+	return c
+}
+
+func (c *testClient) send(s string) {
+	if _, err := io.WriteString(c.conn, s); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) line() string {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("failed to read line: %v", err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+// open asks for module, answering the challenge with password if the server
+// requires authentication, and returns the last line of the server
+func (c *testClient) open(module, user, password string) string {
+	c.send(module + "\n")
+	line := c.line()
+	if challenge, ok := strings.CutPrefix(line, "@RSYNCD: AUTHREQD "); ok {
+		h := md4.New()
+		h.Write([]byte{0, 0, 0, 0})
+		h.Write([]byte(password + challenge))
+		c.send(user + " " + base64.RawStdEncoding.EncodeToString(h.Sum(nil)) + "\n")
+		line = c.line()
+	}
+	return line
+}
+
+// read reads n bytes, from the data messages once multiplexed
+func (c *testClient) read(n int) []byte {
+	if !c.mux {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			c.t.Fatal(err)
+		}
+		return b
+	}
+	for len(c.in) < n {
+		var header [4]byte
+		if _, err := io.ReadFull(c.r, header[:]); err != nil {
+			c.t.Fatalf("failed to read message, messages %q: %v", c.messages, err)
+		}
+		h := binary.LittleEndian.Uint32(header[:])
+		data := make([]byte, h&0xffffff)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			c.t.Fatal(err)
+		}
+		if code := h>>24 - mplexBase; code != msgData {
+			c.messages = append(c.messages, string(data))
+			continue
+		}
+		c.in = append(c.in, data...)
+	}
+	b := c.in[:n:n]
+	c.in = c.in[n:]
+	return b
+}
+
+// drain reads the messages of the server until it closes the connection
+func (c *testClient) drain() {
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(c.r, header[:]); err == io.EOF {
+			return
+		} else if err != nil {
+			c.t.Fatal(err)
+		}
+		h := binary.LittleEndian.Uint32(header[:])
+		data := make([]byte, h&0xffffff)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			c.t.Fatal(err)
+		}
+		if h>>24-mplexBase != msgData {
+			c.messages = append(c.messages, string(data))
+		}
+	}
+}
+
+func (c *testClient) readInt() int32 {
+	return int32(binary.LittleEndian.Uint32(c.read(4)))
+}
+
+func (c *testClient) readLongint() int64 {
+	if v := c.readInt(); v != -1 {
+		return int64(v)
+	}
+	return int64(binary.LittleEndian.Uint64(c.read(8)))
+}
+
+func (c *testClient) writeInt(v int32) {
+	if _, err := c.conn.Write(binary.LittleEndian.AppendUint32(nil, uint32(v))); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// start sends the arguments and reads the checksum seed, then sends an empty
+// filter list
+func (c *testClient) start(args ...string) int32 {
+	c.send(strings.Join(args, "\n") + "\n\n")
+	seed := c.readInt()
+	c.mux = true
+	c.writeInt(0)
+	return seed
+}
+
+// readList reads the file list, with the user and group IDs of -o and -g and
+// the checksums of -c
+func (c *testClient) readList(ids, checksum bool) ([]entry, int32) {
+	var list []entry
+	var last entry
+	for {
+		flags := c.read(1)[0]
+		if flags == 0 {
+			break
+		}
+		e := entry{mtime: last.mtime, mode: last.mode}
+		shared := 0
+		if flags&xmitSameName != 0 {
+			shared = int(c.read(1)[0])
+		}
+		var n int
+		if flags&xmitLongName != 0 {
+			n = int(c.readInt())
+		} else {
+			n = int(c.read(1)[0])
+		}
+		e.name = last.name[:shared] + string(c.read(n))
+		e.size = c.readLongint()
+		if flags&xmitSameTime == 0 {
+			e.mtime = c.readInt()
+		}
+		if flags&xmitSameMode == 0 {
+			e.mode = uint32(c.readInt())
+		}
+		if ids && flags&xmitSameUID == 0 {
+			c.readInt()
+		}
+		if ids && flags&xmitSameGID == 0 {
+			c.readInt()
+		}
+		if e.mode&modeType == modeLink {
+			e.link = string(c.read(int(c.readInt())))
+		}
+		if checksum {
+			e.sum = c.read(md4.Size)
+		}
+		list = append(list, e)
+		last = e
+	}
+	if ids {
+		if id := c.readInt(); id != 0 {
+			c.t.Fatalf("Expected no user names, got ID %d", id)
+		}
+		if id := c.readInt(); id != 0 {
+			c.t.Fatalf("Expected no group names, got ID %d", id)
+		}
+	}
+	return list, c.readInt()
+}
+
+// fetch asks for file ndx with the checksums of basis and returns the file
+// built from the reply, and the number of blocks of basis it reused
+func (c *testClient) fetch(ndx int32, seed int32, basis []byte) ([]byte, int) {
+	seedBytes := binary.LittleEndian.AppendUint32(nil, uint32(seed))
+	count := (len(basis) + testBlockLength - 1) / testBlockLength
+	req := binary.LittleEndian.AppendUint32(nil, uint32(ndx))
+	for _, v := range []int{count, testBlockLength, md4.Size, len(basis) % testBlockLength} {
+		req = binary.LittleEndian.AppendUint32(req, uint32(v))
+	}
+	for i := 0; i < count; i++ {
+		block := basis[i*testBlockLength : min(len(basis), (i+1)*testBlockLength)]
+		req = binary.LittleEndian.AppendUint32(req, checksum1(block))
+		h := md4.New()
+		h.Write(block)
+		h.Write(seedBytes)
+		req = h.Sum(req)
+	}
+	if _, err := c.conn.Write(req); err != nil {
+		c.t.Fatal(err)
+	}
+
+	if got := c.readInt(); got != ndx {
+		c.t.Fatalf("Expected file %d, got %d", ndx, got)
+	}
+	for i, want := range []int{count, testBlockLength, md4.Size, len(basis) % testBlockLength} {
+		if got := c.readInt(); int(got) != want {
+			c.t.Fatalf("Expected checksum header value %d to be %d, got %d", i, want, got)
+		}
+	}
+	var data []byte
+	matched := 0
+	for {
+		token := c.readInt()
+		if token == 0 {
+			break
+		}
+		if token > 0 {
+			data = append(data, c.read(int(token))...)
+			continue
+		}
+		i := int(-token - 1)
+		data = append(data, basis[i*testBlockLength:min(len(basis), (i+1)*testBlockLength)]...)
+		matched++
+	}
+	h := md4.New()
+	h.Write(seedBytes)
+	h.Write(data)
+	if got := c.read(md4.Size); !bytes.Equal(got, h.Sum(nil)) {
+		c.t.Fatalf("Checksum of file %d does not match", ndx)
+	}
+	return data, matched
+}
+
+// finish ends both phases of the transfer, reads the statistics and says
+// goodbye
+func (c *testClient) finish(total int64) {
+	for range 2 {
+		c.writeInt(indexDone)
+		if got := c.readInt(); got != indexDone {
+			c.t.Fatalf("Expected end of phase, got %d", got)
+		}
+	}
+	c.readLongint()
+	c.readLongint()
+	if got := c.readLongint(); got != total {
+		c.t.Errorf("Expected total size %d, got %d", total, got)
+	}
+	c.writeInt(indexDone)
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		c.t.Errorf("Expected the server to close the connection, got %v", err)
+	}
+}
+
+func TestModuleList(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := dial(t, s.Addr().String())
+	c.send("\n")
+	for _, want := range []string{"files          \ttest files", "other          \t", "@RSYNCD: EXIT"} {
+		if got := c.line(); got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestPull(t *testing.T) {
+	s, root := newTestServer(t)
+	original, err := os.ReadFile(filepath.Join(root, "a.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The copy of the client differs in the middle and lacks the tail
+	basis := bytes.Clone(original[:19000])
+	copy(basis[9000:], "changed")
+
+	tests := []struct {
+		name     string
+		args     []string
+		checksum bool
+		ids      bool
+		want     []string
+	}{
+		{
+			name: "contents",
+			args: []string{"--server", "--sender", "-vlogDtpre.iLsfxCIvu", ".", "files/"},
+			ids:  true,
+			want: []string{"-x.txt", ".", "a.bin", "dir", "dir/b.txt", "dir/sub", "dir/sub/c.txt", "link"},
+		},
+		{
+			name: "directory",
+			args: []string{"--server", "--sender", "-rt", ".", "files/dir"},
+			want: []string{"dir", "dir/b.txt", "dir/sub", "dir/sub/c.txt"},
+		},
+		{
+			name:     "dirs and checksums",
+			args:     []string{"--server", "--sender", "-dLc", "--numeric-ids", ".", "files/../"},
+			checksum: true,
+			want:     []string{"-x.txt", ".", "a.bin", "dir", "link"},
+		},
+		{
+			name: "file",
+			args: []string{"--server", "--sender", "-t", ".", "files/a.bin"},
+			want: []string{"a.bin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dial(t, s.Addr().String())
+			if got := c.open("files", "", ""); got != "@RSYNCD: OK" {
+				t.Fatalf("Expected @RSYNCD: OK, got %q", got)
+			}
+			seed := c.start(tt.args...)
+			list, ioError := c.readList(tt.ids, tt.checksum)
+			if ioError != 0 {
+				t.Errorf("Expected no io error, got %d, messages %q", ioError, c.messages)
+			}
+			var names []string
+			for _, e := range list {
+				names = append(names, e.name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Fatalf("Expected file list %q, got %q", tt.want, names)
+			}
+
+			var total int64
+			for i, e := range list {
+				switch e.mode & modeType {
+				case modeLink:
+					if e.link != "a.bin" {
+						t.Errorf("Expected link to a.bin, got %q", e.link)
+					}
+					continue
+				case modeDir:
+					continue
+				}
+				total += e.size
+				if e.mode&0o777 != 0o644 {
+					t.Errorf("Expected mode 0644 of %s, got %o", e.name, e.mode&0o777)
+				}
+				want, err := os.ReadFile(filepath.Join(root, "a.bin"))
+				if e.name != "a.bin" && e.name != "link" {
+					want, err = os.ReadFile(filepath.Join(root, filepath.FromSlash(e.name)))
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.checksum {
+					sum := md4.New()
+					sum.Write(want)
+					if !bytes.Equal(e.sum, sum.Sum(nil)) {
+						t.Errorf("Checksum of %s does not match", e.name)
+					}
+				}
+				var base []byte
+				if e.name == "a.bin" {
+					base = basis
+				}
+				got, matched := c.fetch(int32(i), seed, base)
+				if !bytes.Equal(got, want) {
+					t.Errorf("Contents of %s do not match", e.name)
+				}
+				if base != nil && matched < 20 {
+					t.Errorf("Expected most blocks of %s to be reused, got %d", e.name, matched)
+				}
+			}
+			c.finish(total)
+		})
+	}
+}
+
+func TestPullErrors(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"compression", []string{"--server", "--sender", "-vlogDtprze.iLsfxCIvu", ".", "files/"}, "option -z is not supported"},
+		{"push", []string{"--server", "-vlogDtpre.iLsfxCIvu", ".", "files/"}, "module files is read only"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dial(t, s.Addr().String())
+			if got := c.open("files", "", ""); got != "@RSYNCD: OK" {
+				t.Fatalf("Expected @RSYNCD: OK, got %q", got)
+			}
+			c.start(tt.args...)
+			c.drain()
+			if len(c.messages) != 1 || c.messages[0] != "ezft: "+tt.want+"\n" {
+				t.Errorf("Expected error %q, got %q", tt.want, c.messages)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		c := dial(t, s.Addr().String())
+		c.open("files", "", "")
+		c.start("--server", "--sender", "-r", ".", "files/missing")
+		list, ioError := c.readList(false, false)
+		if len(list) != 0 || ioError == 0 {
+			t.Errorf("Expected an empty list and an io error, got %d files and %d", len(list), ioError)
+		}
+		if len(c.messages) != 1 || !strings.Contains(c.messages[0], "failed to stat missing") {
+			t.Errorf("Expected an error about the missing file, got %q", c.messages)
+		}
+	})
+
+	t.Run("unknown module", func(t *testing.T) {
+		c := dial(t, s.Addr().String())
+		if got := c.open("nope", "", ""); got != "@ERROR: Unknown module 'nope'" {
+			t.Errorf("Expected unknown module error, got %q", got)
+		}
+	})
+}
+
+func TestAuth(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.SetAuth("admin", "secret")
+
+	c := dial(t, s.Addr().String())
+	if got := c.open("files", "admin", "wrong"); got != "@ERROR: auth failed on module files" {
+		t.Errorf("Expected auth failure, got %q", got)
+	}
+
+	c = dial(t, s.Addr().String())
+	if got := c.open("files", "admin", "secret"); got != "@RSYNCD: OK" {
+		t.Fatalf("Expected @RSYNCD: OK, got %q", got)
+	}
+	seed := c.start("--server", "--sender", "-t", ".", "files/dir/b.txt")
+	list, _ := c.readList(false, false)
+	if len(list) != 1 {
+		t.Fatalf("Expected one file, got %d", len(list))
+	}
+	if got, _ := c.fetch(0, seed, nil); string(got) != "bbb" {
+		t.Errorf("Expected bbb, got %q", got)
+	}
+	c.finish(3)
+}
+
+func TestRollingSum(t *testing.T) {
+	data := testData(5000)
+	const n = 700
+	var rs rollingSum
+	rs.init(data[:n])
+	for i := 0; ; i++ {
+		if want := checksum1(data[i : i+n]); rs.sum() != want {
+			t.Fatalf("Rolling checksum at %d = %x, want %x", i, rs.sum(), want)
+		}
+		if i+n == len(data) {
+			break
+		}
+		rs.roll(data[i], data[i+n], n)
+	}
+}
+
+func TestModulePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"files", "./"},
+		{"files/", "./"},
+		{"files/.", "./"},
+		{"files/dir", "dir"},
+		{"files/dir/", "dir/"},
+		{"files/dir/.", "dir/"},
+		{"files/../../etc/passwd", "etc/passwd"},
+		{"files/a/../../..", "./"},
+		{"dir/b.txt", "dir/b.txt"},
+		{"filesystem", "filesystem"},
+	}
+	for _, tt := range tests {
+		if got := modulePath(tt.path, "files"); got != tt.want {
+			t.Errorf("modulePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestSystemRsync pulls the module with the rsync binary of the system, if
+// any, first into an empty directory, then again over a changed copy so
+// that the blocks of the basis are matched
+func TestSystemRsync(t *testing.T) {
+	rsync, err := exec.LookPath("rsync")
+	if err != nil {
+		t.Skip("rsync not installed")
+	}
+	s, root := newTestServer(t)
+	s.SetAuth("admin", "secret")
+	dest := t.TempDir()
+	pull := func() {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, rsync, "-rlt", "-I", "rsync://admin@"+s.Addr().String()+"/files/", dest+"/")
+		cmd.Env = append(os.Environ(), "RSYNC_PASSWORD=secret")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("rsync failed: %v\n%s", err, out)
+		}
+	}
+
+	pull()
+	for _, name := range []string{"a.bin", "-x.txt", "dir/b.txt", "dir/sub/c.txt"} {
+		want, _ := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name))); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Expected %s pulled, got %d bytes, %v", name, len(got), err)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "link")); err != nil || target != "a.bin" {
+		t.Errorf("Expected link to a.bin, got %q, %v", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, ".ezft")); !os.IsNotExist(err) {
+		t.Errorf("Expected ezft bookkeeping not to be served, got %v", err)
+	}
+
+	basis := filepath.Join(dest, "a.bin")
+	changed, err := os.ReadFile(basis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(changed[10000:], "changed in the middle")
+	if err := os.WriteFile(basis, changed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pull()
+	want, _ := os.ReadFile(filepath.Join(root, "a.bin"))
+	if got, err := os.ReadFile(basis); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Expected a.bin restored from its basis, got %d bytes, %v", len(got), err)
+	}
+}
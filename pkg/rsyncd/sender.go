@@ -0,0 +1,324 @@
+package rsyncd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"golang.org/x/crypto/md4"
+)
+
+const (
+	// chunkSize largest literal data sent in one token
+	chunkSize = 32 * 1024
+	// maxBlockLength largest block length of the checksums a client sends
+	maxBlockLength = 1 << 29
+	// maxBlocks most block checksums a client may send for one file
+	maxBlocks = 1 << 24
+	// maxPhase the last phase of the transfer, the one files failing their
+	// checksum are sent again in
+	maxPhase = 1
+	// indexDone ends a phase of the transfer
+	indexDone = -1
+)
+
+// sumHead the checksums of the blocks of the copy of a file the client has,
+// which it builds the file from with the matching blocks and the data sent
+type sumHead struct {
+	count     int32 // Number of blocks
+	length    int32 // Block length
+	s2length  int32 // Length of the strong checksums
+	remainder int32 // Length of the last block, 0 if it is a full one
+	strong    []byte
+	table     map[uint32][]int32 // Block indexes by rolling checksum
+}
+
+// blockLen returns the length of block i
+func (h *sumHead) blockLen(i int32) int {
+	if i == h.count-1 && h.remainder != 0 {
+		return int(h.remainder)
+	}
+	return int(h.length)
+}
+
+// find returns the block with the checksums of window, weak being its
+// rolling checksum
+func (h *sumHead) find(weak uint32, window []byte, seed []byte) (int32, bool) {
+	var strong []byte
+	for _, i := range h.table[weak] {
+		if h.blockLen(i) != len(window) {
+			continue
+		}
+		if strong == nil {
+			strong = strongSum(window, seed)
+		}
+		n := h.s2length
+		if bytes.Equal(strong[:n], h.strong[i*n:(i+1)*n]) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// sendFiles answers the requests of the client: for every file index it sends
+// the file as a delta against the block checksums following the index, and
+// echoes the end of the phases, until the last phase ended. The statistics of
+// the transfer end it.
+func (s *session) sendFiles() error {
+	c := s.c
+	phase := 0
+	for {
+		ndx := c.readInt()
+		if c.err != nil {
+			return c.err
+		}
+		if ndx == indexDone {
+			phase++
+			if phase > maxPhase {
+				break
+			}
+			c.writeInt(indexDone)
+			continue
+		}
+		if ndx < 0 || int(ndx) >= len(s.files) || !s.files[ndx].regular() {
+			return fmt.Errorf("invalid file index %d", ndx)
+		}
+		head, err := s.readSums()
+		if err != nil {
+			return err
+		}
+		if err := s.sendFile(ndx, head); err != nil {
+			return err
+		}
+	}
+	c.writeInt(indexDone)
+
+	var total int64
+	for _, f := range s.files {
+		if f.regular() {
+			total += f.size
+		}
+	}
+	c.writeLongint(c.read)
+	c.writeLongint(c.written)
+	c.writeLongint(total)
+	if ndx := c.readInt(); c.err == nil && ndx != indexDone {
+		return fmt.Errorf("invalid packet at end of run (%d)", ndx)
+	}
+	return c.err
+}
+
+// readSums reads the block checksums of a file
+func (s *session) readSums() (*sumHead, error) {
+	c := s.c
+	h := &sumHead{
+		count:     c.readInt(),
+		length:    c.readInt(),
+		s2length:  c.readInt(),
+		remainder: c.readInt(),
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	if h.count < 0 || h.count > maxBlocks ||
+		h.length < 0 || h.length > maxBlockLength || (h.count > 0 && h.length == 0) ||
+		h.s2length < 0 || h.s2length > md4.Size ||
+		h.remainder < 0 || h.remainder > h.length {
+		return nil, fmt.Errorf("invalid checksum header %d/%d/%d/%d", h.count, h.length, h.s2length, h.remainder)
+	}
+	h.strong = make([]byte, int(h.count)*int(h.s2length))
+	h.table = make(map[uint32][]int32, h.count)
+	for i := range h.count {
+		weak := uint32(c.readInt())
+		c.readFull(h.strong[int(i)*int(h.s2length) : int(i+1)*int(h.s2length)])
+		h.table[weak] = append(h.table[weak], i)
+	}
+	return h, c.err
+}
+
+// sendFile sends the file of index ndx: the index, the checksum header it
+// answers, the delta and the checksum of the whole file
+func (s *session) sendFile(ndx int32, head *sumHead) error {
+	c := s.c
+	f := s.files[ndx]
+	r, err := os.Open(f.path)
+	if err != nil {
+		// The client does not wait for files which are not sent
+		s.errorf("failed to open %s: %v", f.name, unwrapPath(err))
+		return nil
+	}
+	defer r.Close()
+
+	c.writeInt(ndx)
+	c.writeInt(head.count)
+	c.writeInt(head.length)
+	c.writeInt(head.s2length)
+	c.writeInt(head.remainder)
+
+	seed := binary.LittleEndian.AppendUint32(nil, uint32(s.seed))
+	sum := md4.New()
+	sum.Write(seed)
+	if err := s.sendDelta(io.TeeReader(r, sum), head, seed); err != nil {
+		return fmt.Errorf("failed to read %s: %w", f.name, err)
+	}
+	c.write(sum.Sum(nil))
+	return c.err
+}
+
+// sendDelta sends r as tokens: literal data, preceded by its length, and the
+// negated indexes of the blocks of the client matching the data, plus one.
+// A zero token ends the file. Blocks are searched at every offset with the
+// rolling checksum, the short last block only at the end of the file.
+func (s *session) sendDelta(r io.Reader, head *sumHead, seed []byte) error {
+	bs := int(head.length)
+	if head.count == 0 {
+		buf := make([]byte, chunkSize)
+		for {
+			n, err := io.ReadFull(r, buf)
+			s.literal(buf[:n])
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		s.c.writeInt(0)
+		return nil
+	}
+
+	// data holds the pending literal followed by the current window
+	data := make([]byte, 0, 2*bs+chunkSize)
+	lit, pos := 0, 0
+	eof := false
+	fill := func(need int) error {
+		for !eof && len(data)-pos < need {
+			if lit > 0 {
+				n := copy(data, data[lit:])
+				data = data[:n]
+				pos -= lit
+				lit = 0
+			}
+			if len(data) == cap(data) {
+				data = slices.Grow(data, cap(data))
+			}
+			n, err := r.Read(data[len(data):cap(data)])
+			data = data[:len(data)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var rs rollingSum
+	valid := false
+	for {
+		if err := fill(bs + 1); err != nil {
+			return err
+		}
+		if len(data)-pos < bs {
+			break
+		}
+		window := data[pos : pos+bs]
+		if !valid {
+			rs.init(window)
+			valid = true
+		}
+		if i, ok := head.find(rs.sum(), window, seed); ok {
+			s.literal(data[lit:pos])
+			s.c.writeInt(-(i + 1))
+			pos += bs
+			lit = pos
+			valid = false
+			continue
+		}
+		// Send literal data before it grows unbounded
+		if pos-lit >= chunkSize {
+			s.literal(data[lit:pos])
+			lit = pos
+		}
+		if pos+bs < len(data) {
+			rs.roll(data[pos], data[pos+bs], bs)
+		} else {
+			valid = false
+		}
+		pos++
+	}
+
+	last := head.count - 1
+	if tail := head.blockLen(last); tail < bs && len(data)-lit >= tail {
+		window := data[len(data)-tail:]
+		if i, ok := head.find(weakSum(window), window, seed); ok && i == last {
+			s.literal(data[lit : len(data)-tail])
+			s.c.writeInt(-(i + 1))
+			lit = len(data)
+		}
+	}
+	s.literal(data[lit:])
+	s.c.writeInt(0)
+	return nil
+}
+
+// literal sends data as literal tokens
+func (s *session) literal(data []byte) {
+	for len(data) > 0 {
+		n := min(len(data), chunkSize)
+		s.c.writeInt(int32(n))
+		s.c.write(data[:n])
+		data = data[n:]
+	}
+}
+
+// strongSum returns the strong checksum of a block, the MD4 of the block
+// followed by the checksum seed
+func strongSum(block, seed []byte) []byte {
+	h := md4.New()
+	h.Write(block)
+	h.Write(seed)
+	return h.Sum(nil)
+}
+
+// weakSum returns the rolling checksum of a block
+func weakSum(block []byte) uint32 {
+	var rs rollingSum
+	rs.init(block)
+	return rs.sum()
+}
+
+// rollingSum the rolling checksum of rsync, updated in O(1) when the window
+// slides by one byte. Unlike the one of the delta package it adds the bytes
+// as signed values, as rsync does.
+type rollingSum struct {
+	s1, s2 uint32
+}
+
+func (r *rollingSum) init(window []byte) {
+	r.s1, r.s2 = 0, 0
+	for _, b := range window {
+		r.s1 += signed(b)
+		r.s2 += r.s1
+	}
+}
+
+// roll removes out from the head of a window of n bytes and appends in
+func (r *rollingSum) roll(out, in byte, n int) {
+	r.s1 -= signed(out)
+	r.s2 -= uint32(n) * signed(out)
+	r.s1 += signed(in)
+	r.s2 += r.s1
+}
+
+func (r *rollingSum) sum() uint32 {
+	return r.s1&0xffff | r.s2<<16
+}
+
+// signed returns b as the signed char rsync adds
+func signed(b byte) uint32 {
+	return uint32(int32(int8(b)))
+}
@@ -0,0 +1,180 @@
+// Package rsyncd serves directories to stock rsync clients over the rsync
+// daemon protocol, easing the migration of mirrors pulling with
+// rsync://host/module/path. It speaks protocol version 27, which every rsync
+// since 2.6.0 negotiates down to: clients list the modules, receive the file
+// list and pull files as deltas against the copies they already have.
+// Modules are read-only and compression (-z), hard links (-H), ACLs (-A),
+// extended attributes (-X) and relative paths (-R) are refused.
+package rsyncd
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"go.uber.org/zap"
+)
+
+// Module a directory served to rsync clients
+type Module struct {
+	Name    string // Name clients give in rsync://host/name/path
+	Path    string // Directory served
+	Comment string // Description shown in the module listing
+}
+
+// Config configures the rsync daemon
+type Config struct {
+	Addr    string // Address of client connections, such as :873
+	Modules []Module
+}
+
+// Server accepts rsync clients pulling from its modules
+type Server struct {
+	config   Config
+	listener net.Listener
+	logger   *zap.Logger
+
+	mu          sync.Mutex // Guards the credentials, limiter and sessions
+	username    string
+	password    string
+	authEnabled bool
+	limiter     *ratelimit.Limiter // Bandwidth limit of the data sent, nil for none
+	sessions    map[*session]struct{}
+	closed      bool
+}
+
+// NewServer creates a server of the modules of config
+func NewServer(config Config) *Server {
+	return &Server{
+		config:   config,
+		logger:   zap.NewNop(),
+		sessions: make(map[*session]struct{}),
+	}
+}
+
+func (s *Server) SetLogger(logger *zap.Logger) {
+	s.logger = logger
+}
+
+// SetAuth requires the given credentials for all modules, an empty username
+// serves them without. Clients give the password in RSYNC_PASSWORD or with
+// --password-file. It may be called while serving.
+func (s *Server) SetAuth(username, password string) {
+	s.mu.Lock()
+	s.username = username
+	s.password = password
+	s.authEnabled = username != ""
+	s.mu.Unlock()
+}
+
+// SetRateLimiter limits the data sent by limiter, nil removes the limit. It
+// may be called while serving, sessions keep the limiter they started with.
+func (s *Server) SetRateLimiter(limiter *ratelimit.Limiter) {
+	s.mu.Lock()
+	s.limiter = limiter
+	s.mu.Unlock()
+}
+
+// credentials returns the credentials clients need, whether any
+func (s *Server) credentials() (username, password string, enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.username, s.password, s.authEnabled
+}
+
+// authorized reports whether response answers challenge with the password of
+// username
+func (s *Server) authorized(username, response, challenge string) bool {
+	user, password, enabled := s.credentials()
+	if !enabled {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(username), []byte(user)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(response), []byte(authResponse(password, challenge))) == 1
+}
+
+// rateLimiter returns the bandwidth limit of the sessions starting, nil for none
+func (s *Server) rateLimiter() *ratelimit.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limiter
+}
+
+// module returns the module called name
+func (s *Server) module(name string) (Module, bool) {
+	for _, m := range s.config.Modules {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Module{}, false
+}
+
+// Listen listens for clients on the configured address
+func (s *Server) Listen() error {
+	lis, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for rsync: %w", err)
+	}
+	s.listener = lis
+	return nil
+}
+
+// Addr returns the address of client connections
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve accepts clients until Close
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		sess := &session{server: s, conn: conn, ctx: ctx, cancel: cancel}
+		if !s.track(sess, true) {
+			cancel()
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer s.track(sess, false)
+			sess.serve()
+		}()
+	}
+}
+
+// Close stops accepting clients and ends the open sessions
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for sess := range s.sessions {
+		sess.close()
+	}
+	s.mu.Unlock()
+	return s.listener.Close()
+}
+
+// track adds or removes an open session, false once the server is closed
+func (s *Server) track(sess *session, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.sessions, sess)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	s.sessions[sess] = struct{}{}
+	return true
+}
@@ -0,0 +1,421 @@
+package rsyncd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/md4"
+)
+
+const (
+	// protocolVersion the rsync protocol version spoken, clients negotiate
+	// down to it
+	protocolVersion = 27
+	// maxArgs most arguments a client may send
+	maxArgs = 1024
+	// maxFilterRule longest filter rule a client may send
+	maxFilterRule = 4096
+)
+
+// ioErrorGeneral the io error flag reported when files could not be listed
+const ioErrorGeneral = 1
+
+// session a client connection
+type session struct {
+	server *Server
+	conn   net.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	c      *conn
+
+	module  Module
+	opts    options
+	seed    int32
+	ioError int32
+	files   []*file
+}
+
+func (s *session) close() {
+	s.cancel()
+	s.conn.Close()
+}
+
+func (s *session) serve() {
+	defer s.close()
+
+	var w io.Writer = s.conn
+	if limiter := s.server.rateLimiter(); limiter != nil {
+		w = limiter.Writer(s.ctx, w)
+	}
+	s.c = newConn(s.conn, w)
+
+	start := time.Now()
+	metrics.ServerStats.Add(metrics.ActiveRequests, 1)
+	err := s.run()
+	metrics.ServerStats.Add(metrics.ActiveRequests, -1)
+	if s.module.Name == "" {
+		return
+	}
+	metrics.ServerStats.Add(metrics.Requests, 1)
+	metrics.ServerStats.Add(metrics.BytesSent, s.c.written)
+	s.server.logger.Info("rsync session served",
+		zap.String("remoteAddr", s.conn.RemoteAddr().String()),
+		zap.String("module", s.module.Name),
+		zap.Strings("paths", s.opts.paths),
+		zap.Int("files", len(s.files)),
+		zap.Int64("respSize", s.c.written),
+		zap.Duration("duration", time.Since(start)),
+		zap.Error(err),
+	)
+}
+
+// run greets the client, lists the modules or authenticates it for a module,
+// and sends the files it asks for
+func (s *session) run() error {
+	c := s.c
+	c.printf("@RSYNCD: %d.0\n", protocolVersion)
+	greeting := c.readLine()
+	if c.err != nil {
+		return c.err
+	}
+	version, ok := parseGreeting(greeting)
+	if !ok {
+		c.printf("@ERROR: protocol startup error\n")
+		c.flush()
+		return fmt.Errorf("invalid greeting %q", greeting)
+	}
+	if version < protocolVersion {
+		c.printf("@ERROR: protocol version %d is too old, ezft needs %d or later\n", version, protocolVersion)
+		c.flush()
+		return fmt.Errorf("unsupported protocol version %d", version)
+	}
+
+	name := c.readLine()
+	if c.err != nil {
+		return c.err
+	}
+	if name == "" || name == "#list" {
+		s.list()
+		return c.err
+	}
+	m, ok := s.server.module(name)
+	if !ok {
+		c.printf("@ERROR: Unknown module '%s'\n", name)
+		c.flush()
+		return nil
+	}
+	s.module = m
+
+	if _, _, enabled := s.server.credentials(); enabled {
+		challenge, err := newChallenge()
+		if err != nil {
+			return err
+		}
+		c.printf("@RSYNCD: AUTHREQD %s\n", challenge)
+		user, response, _ := strings.Cut(c.readLine(), " ")
+		if c.err != nil {
+			return c.err
+		}
+		if !s.server.authorized(user, response, challenge) {
+			s.server.logger.Warn("rsync login failed",
+				zap.String("remoteAddr", s.conn.RemoteAddr().String()),
+				zap.String("module", name),
+				zap.String("user", user),
+			)
+			c.printf("@ERROR: auth failed on module %s\n", name)
+			c.flush()
+			return nil
+		}
+	}
+	c.printf("@RSYNCD: OK\n")
+
+	var args []string
+	for {
+		arg := c.readLine()
+		if c.err != nil {
+			return c.err
+		}
+		if arg == "" {
+			break
+		}
+		if len(args) == maxArgs {
+			return errors.New("too many arguments")
+		}
+		args = append(args, arg)
+	}
+	opts, optsErr := parseArgs(args, name)
+	s.opts = opts
+
+	// The checksum seed goes before the transfer, everything after it is
+	// multiplexed so that errors reach the client
+	s.seed = opts.seed
+	for s.seed == 0 {
+		var b [4]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return err
+		}
+		s.seed = int32(binary.LittleEndian.Uint32(b[:]))
+	}
+	c.writeInt(s.seed)
+	c.startMux()
+	if err := s.readFilters(); err != nil {
+		return err
+	}
+	if optsErr != nil {
+		c.message(msgError, fmt.Sprintf("ezft: %v\n", optsErr))
+		c.flush()
+		return optsErr
+	}
+
+	s.buildList()
+	s.sendList()
+	if c.err != nil || len(s.files) == 0 {
+		c.flush()
+		return c.err
+	}
+	if err := s.sendFiles(); err != nil {
+		return err
+	}
+	c.flush()
+	return c.err
+}
+
+// list sends the names and comments of the modules
+func (s *session) list() {
+	for _, m := range s.server.config.Modules {
+		s.c.printf("%-15s\t%s\n", m.Name, m.Comment)
+	}
+	s.c.printf("@RSYNCD: EXIT\n")
+	s.c.flush()
+}
+
+// readFilters reads the filter rules of the client. The rules are applied on
+// the client side when it receives the file list, the sender does not need
+// them.
+func (s *session) readFilters() error {
+	c := s.c
+	for {
+		n := c.readInt()
+		if c.err != nil {
+			return c.err
+		}
+		if n == 0 {
+			return nil
+		}
+		if n < 0 || n > maxFilterRule {
+			return fmt.Errorf("invalid filter rule length %d", n)
+		}
+		c.readFull(make([]byte, n))
+	}
+}
+
+// errorf sends an error about a file to the client, which exits with code 23
+// (partial transfer) in the end
+func (s *session) errorf(format string, args ...any) {
+	s.ioError |= ioErrorGeneral
+	s.c.message(msgError, "ezft: "+fmt.Sprintf(format, args...)+"\n")
+}
+
+// infof sends a notice to the client
+func (s *session) infof(format string, args ...any) {
+	s.c.message(msgInfo, fmt.Sprintf(format, args...)+"\n")
+}
+
+// parseGreeting returns the protocol version of a greeting such as
+// "@RSYNCD: 31.0 sha512 sha256 sha1 md5 md4"
+func parseGreeting(line string) (int, bool) {
+	rest, ok := strings.CutPrefix(line, "@RSYNCD: ")
+	if !ok {
+		return 0, false
+	}
+	rest, _, _ = strings.Cut(rest, " ")
+	major, _, _ := strings.Cut(rest, ".")
+	version, err := strconv.Atoi(major)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// newChallenge returns a random challenge of the authentication
+func newChallenge() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// authResponse returns the response to challenge a client knowing password
+// sends: the unpadded base64 of the MD4 of the password and challenge, after
+// the zero seed protocols before 30 start every MD4 with
+func authResponse(password, challenge string) string {
+	h := md4.New()
+	h.Write(make([]byte, 4))
+	h.Write([]byte(password))
+	h.Write([]byte(challenge))
+	return base64.RawStdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// options the options of a client affecting what the server sends
+type options struct {
+	recursive    bool // -r, descend into directories
+	dirs         bool // -d, send directories without descending into them
+	links        bool // -l, send symbolic links as links
+	copyLinks    bool // -L, send the files symbolic links point to
+	copyDirLinks bool // -k, send the directories symbolic links point to
+	owner        bool // -o, send the user ID of files
+	group        bool // -g, send the group ID of files
+	checksum     bool // -c, send the checksum of files in the file list
+	numericIDs   bool // --numeric-ids, send no user and group names
+	ignoreErrors bool // --ignore-errors, do not report the files that could not be listed
+	seed         int32
+	paths        []string // Module relative paths, a trailing slash sends the contents of a directory
+}
+
+// Options changing the protocol in ways the server does not speak:
+// compression, hard links, ACLs, extended attributes, relative paths and
+// protected arguments, and the options sending file names or partial files
+var (
+	unsupportedShort = "zHAXRs"
+	unsupportedLong  = []string{
+		"compress", "hard-links", "acls", "xattrs", "relative", "protect-args", "secluded-args",
+		"files-from", "append", "append-verify", "iconv",
+	}
+)
+
+// parseArgs parses the arguments of the server side the client sends, such as
+// --server --sender -vlogDtpre.iLsfxCIvu . module/path. Options which only
+// matter to the client are ignored. An error means the client asked for
+// something the server does not support, the options parsed are returned
+// regardless.
+func parseArgs(args []string, module string) (options, error) {
+	var opts options
+	var err error
+	unsupported := func(option string) {
+		if err == nil {
+			err = fmt.Errorf("option %s is not supported", option)
+		}
+	}
+	sender := false
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "--server":
+		case arg == "--sender":
+			sender = true
+		case strings.HasPrefix(arg, "--"):
+			name, value, _ := strings.Cut(arg[2:], "=")
+			switch name {
+			case "recursive":
+				opts.recursive = true
+			case "dirs":
+				opts.dirs = true
+			case "links":
+				opts.links = true
+			case "copy-links":
+				opts.copyLinks = true
+			case "copy-dirlinks":
+				opts.copyDirLinks = true
+			case "owner":
+				opts.owner = true
+			case "group":
+				opts.group = true
+			case "checksum":
+				opts.checksum = true
+			case "numeric-ids":
+				opts.numericIDs = true
+			case "ignore-errors":
+				opts.ignoreErrors = true
+			case "checksum-seed":
+				seed, perr := strconv.ParseInt(value, 10, 32)
+				if perr != nil && err == nil {
+					err = fmt.Errorf("invalid checksum seed %q", value)
+				}
+				opts.seed = int32(seed)
+			default:
+				if slices.Contains(unsupportedLong, name) {
+					unsupported("--" + name)
+				}
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+		flags:
+			for i := 1; i < len(arg); i++ {
+				switch arg[i] {
+				case 'e':
+					// The rest are the capabilities of the client, for
+					// protocols from 30 on
+					break flags
+				case 'r':
+					opts.recursive = true
+				case 'd':
+					opts.dirs = true
+				case 'l':
+					opts.links = true
+				case 'L':
+					opts.copyLinks = true
+				case 'k':
+					opts.copyDirLinks = true
+				case 'o':
+					opts.owner = true
+				case 'g':
+					opts.group = true
+				case 'c':
+					opts.checksum = true
+				default:
+					if strings.IndexByte(unsupportedShort, arg[i]) >= 0 {
+						unsupported("-" + arg[i:i+1])
+					}
+				}
+			}
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if !sender && err == nil {
+		err = fmt.Errorf("module %s is read only", module)
+	}
+	// The first argument is the directory the paths are relative to
+	if len(positional) > 0 {
+		positional = positional[1:]
+	}
+	for _, p := range positional {
+		opts.paths = append(opts.paths, modulePath(p, module))
+	}
+	if len(opts.paths) == 0 && err == nil {
+		err = errors.New("no path given")
+	}
+	return opts, err
+}
+
+// modulePath returns the path below the module of a path a client sends,
+// such as module/dir/: slash separated, without the module name, never above
+// the module root, "." for the root itself and with a trailing slash when the
+// contents of a directory are asked for
+func modulePath(p, module string) string {
+	if p == module || strings.HasPrefix(p, module+"/") {
+		p = p[len(module):]
+	}
+	contents := strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") || p == "." || p == ""
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	switch {
+	case p == "":
+		return "./"
+	case contents:
+		return p + "/"
+	}
+	return p
+}
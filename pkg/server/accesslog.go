@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// w3cFields fields of the lines of the access log
+const w3cFields = "date time c-ip cs-username cs-method cs-uri-stem cs-uri-query cs-version sc-status sc-bytes cs-bytes time-taken cs(User-Agent) cs(Referer) cs(Range)"
+
+// AccessLog writes the requests answered by the server in the W3C extended
+// log format, read by log analyzers such as AWStats and GoAccess
+type AccessLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAccessLog returns an access log writing to w, starting with the
+// directives naming software and the fields of the lines. They are written
+// again each time a log is created on the same file, as the format allows.
+func NewAccessLog(w io.Writer, software string) (*AccessLog, error) {
+	_, err := fmt.Fprintf(w, "#Version: 1.0\n#Software: %s\n#Start-Date: %s\n#Fields: %s\n",
+		software, time.Now().UTC().Format(time.DateTime), w3cFields)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessLog{w: w}, nil
+}
+
+// Log writes the line of the request r answered with status after duration,
+// with the bytes of the response and request bodies
+func (l *AccessLog) Log(r *http.Request, status int, sent, received int64, duration time.Duration) error {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	username, _, _ := r.BasicAuth()
+	line := strings.Join([]string{
+		time.Now().UTC().Format(time.DateTime), // date and time
+		w3cValue(ip),
+		w3cValue(username),
+		w3cValue(r.Method),
+		w3cValue(r.URL.EscapedPath()),
+		w3cValue(r.URL.RawQuery),
+		w3cValue(r.Proto),
+		fmt.Sprint(status),
+		fmt.Sprint(sent),
+		fmt.Sprint(received),
+		fmt.Sprintf("%.3f", duration.Seconds()),
+		w3cValue(r.Header.Get("User-Agent")),
+		w3cValue(r.Header.Get("Referer")),
+		w3cValue(r.Header.Get("Range")),
+	}, " ")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = io.WriteString(l.w, line+"\n")
+	return err
+}
+
+// w3cValue returns s as a field value: - if empty, with spaces and control
+// characters replaced by + as IIS does
+func w3cValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return '+'
+		}
+		return r
+	}, s)
+}
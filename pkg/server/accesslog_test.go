@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestAccessLog(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a b.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	accessLog, err := NewAccessLog(&buf, "ezft test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(root, 0)
+	server.SetLogger(zap.NewNop())
+	server.SetAuth("alice", "secret")
+	server.SetAccessLog(accessLog)
+
+	req := httptest.NewRequest("GET", "/a%20b.txt?x=1", nil)
+	req.RemoteAddr = "192.168.1.1:12345"
+	req.SetBasicAuth("alice", "secret")
+	req.Header.Set("User-Agent", "curl/8.0 (x86_64)")
+	req.Header.Set("Range", "bytes=1-3")
+	server.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || lines[0] != "#Version: 1.0" || lines[1] != "#Software: ezft test" || lines[3] != "#Fields: "+w3cFields {
+		t.Fatalf("Access log = %q", buf.String())
+	}
+	fields := strings.Split(lines[4], " ")
+	if len(fields) != len(strings.Split(w3cFields, " ")) {
+		t.Fatalf("Access log line %q, want the %d fields", lines[4], len(strings.Split(w3cFields, " ")))
+	}
+	want := []string{"192.168.1.1", "alice", "GET", "/a%20b.txt", "x=1", "HTTP/1.1", "206", "3", "0"}
+	if got := fields[2:11]; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Access log fields = %q, want %q", got, want)
+	}
+	if got := fields[12:]; strings.Join(got, " ") != "curl/8.0+(x86_64) - bytes=1-3" {
+		t.Errorf("Access log header fields = %q", got)
+	}
+}
+
+func TestW3CValue(t *testing.T) {
+	tests := map[string]string{
+		"":              "-",
+		"ezft-ftp":      "ezft-ftp",
+		"Mozilla/5.0 x": "Mozilla/5.0+x",
+		"a\tb\nc":       "a+b+c",
+	}
+	for s, want := range tests {
+		if got := w3cValue(s); got != want {
+			t.Errorf("w3cValue(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
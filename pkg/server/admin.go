@@ -0,0 +1,49 @@
+package server
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"go.uber.org/zap"
+)
+
+// DefaultAdminAddr address of the admin endpoints, only reachable locally
+const DefaultAdminAddr = "127.0.0.1:6060"
+
+// AdminHandler returns the http handler of the admin endpoints: the profiles
+// of net/http/pprof under /debug/pprof/ and the expvar variables, the request
+// counters of the server among them, at /debug/vars
+func AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// SetAdminAddr serves the admin endpoints on addr next to the files, empty
+// disables them. They are not authenticated, so addr should not be reachable
+// from untrusted networks.
+func (s *Server) SetAdminAddr(addr string) {
+	s.adminAddr = addr
+}
+
+// ListenAdmin serves the admin endpoints on the configured address
+func (s *Server) ListenAdmin() (net.Addr, error) {
+	ln, err := net.Listen("tcp", s.adminAddr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: AdminHandler()}
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			s.logger.Error("Admin endpoints failed", zap.Error(err))
+		}
+	}()
+	return ln.Addr(), nil
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestAdminHandler(t *testing.T) {
+	server := NewServer(t.TempDir(), 0)
+	server.SetLogger(zap.NewNop())
+	files := httptest.NewServer(server.Handler())
+	defer files.Close()
+	resp, err := http.Get(files.URL + "/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	server.SetAdminAddr("127.0.0.1:0")
+	addr, err := server.ListenAdmin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Get("http://" + addr.String() + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var vars struct {
+		Server map[string]int64 `json:"ezft_server"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Server["requests"] < 1 || vars.Server["active_requests"] != 0 {
+		t.Errorf("ezft_server = %v, want the requests served", vars.Server)
+	}
+
+	resp, err = http.Get("http://" + addr.String() + "/debug/pprof/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "goroutine") {
+		t.Errorf("GET /debug/pprof/ = %d %s", resp.StatusCode, body)
+	}
+
+	// Not exposed with the files
+	resp, err = http.Get(files.URL + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /debug/vars on the file server = %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,426 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/easzlab/ezft/pkg/compress"
+	"github.com/easzlab/ezft/pkg/delta"
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/p2p"
+	"github.com/easzlab/ezft/pkg/quicproto"
+	"github.com/easzlab/ezft/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// APIPrefix prefix of the ezft specific endpoints
+const APIPrefix = "/_ezft/"
+
+// maxSignatureSize limits the size of a signature uploaded by clients
+const maxSignatureSize = 64 * 1024 * 1024
+
+// maxChunkManifests bounds the chunk manifests kept in memory
+const maxChunkManifests = 64
+
+// maxAnnounceSize limits the size of an announce of a peer
+const maxAnnounceSize = 1024 * 1024
+
+// registerAPI registers the ezft specific endpoints
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+APIPrefix+"signature/{path...}", s.handleSignature)
+	var deltaHandler http.Handler = http.HandlerFunc(s.handleDelta)
+	if s.e2eKey != nil {
+		deltaHandler = s.EncryptMiddleware(deltaHandler)
+	}
+	mux.Handle("POST "+APIPrefix+"delta/{path...}", deltaHandler)
+	var diffHandler http.Handler = http.HandlerFunc(s.handleDiff)
+	if s.e2eKey != nil {
+		diffHandler = s.EncryptMiddleware(diffHandler)
+	}
+	mux.Handle("GET "+APIPrefix+"diff/{path...}", diffHandler)
+	mux.HandleFunc("GET "+APIPrefix+"manifest/{path...}", s.handleManifest)
+	mux.HandleFunc("GET "+APIPrefix+"list/{path...}", s.handleList)
+	mux.HandleFunc("GET "+APIPrefix+"hash/{path...}", s.handleHash)
+	mux.HandleFunc("GET "+APIPrefix+"chunks/{path...}", s.handleChunks)
+	mux.HandleFunc("GET "+APIPrefix+"quic/{path...}", s.handleQUIC)
+	if s.compression {
+		// The dictionary is sampled from the file, it is protected like its chunks
+		var compressionHandler http.Handler = http.HandlerFunc(s.handleCompression)
+		if s.e2eKey != nil {
+			compressionHandler = s.EncryptMiddleware(compressionHandler)
+		}
+		mux.Handle("GET "+APIPrefix+"compression/{path...}", compressionHandler)
+	}
+
+	if s.tracker != nil {
+		mux.HandleFunc("POST "+APIPrefix+"peers/{path...}", s.handlePeers)
+		mux.HandleFunc("GET "+APIPrefix+"pieces/{path...}", s.handlePieces)
+	}
+
+	if s.uploadEnabled {
+		mux.HandleFunc("PUT /", s.handleUpload)
+		mux.HandleFunc("DELETE /", s.handleDelete)
+		mux.HandleFunc("POST "+APIPrefix+"copy/{path...}", s.handleCopy)
+		mux.HandleFunc("POST "+APIPrefix+"patch/{path...}", s.handlePatch)
+	}
+}
+
+// registerRelay registers the endpoints of the relay between peers
+func (s *Server) registerRelay(mux *http.ServeMux) {
+	s.relay.SetLogger(s.logger)
+	mux.HandleFunc("GET "+APIPrefix+"relay/{id}", func(w http.ResponseWriter, r *http.Request) {
+		s.relay.Forward(w, r, r.PathValue("id"))
+	})
+	mux.HandleFunc("GET "+APIPrefix+"relay/{id}/accept", func(w http.ResponseWriter, r *http.Request) {
+		s.relay.Accept(w, r, r.PathValue("id"))
+	})
+	mux.HandleFunc("POST "+APIPrefix+"relay/{id}/respond/{token}", func(w http.ResponseWriter, r *http.Request) {
+		s.relay.Respond(w, r, r.PathValue("id"), r.PathValue("token"))
+	})
+	mux.HandleFunc("POST "+APIPrefix+"relay/{id}/punch", func(w http.ResponseWriter, r *http.Request) {
+		s.relay.Punch(w, r, r.PathValue("id"))
+	})
+}
+
+// resolvePath maps a request path to a file path under the root directory.
+// Paths the system cannot name below the root are rejected, such as those
+// with backslashes, drive letters or device names like CON on Windows, where
+// they would otherwise escape the root or open a device.
+func (s *Server) resolvePath(p string) (string, error) {
+	rel := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if rel == "" {
+		return s.root, nil
+	}
+	local, err := filepath.Localize(rel)
+	if err != nil {
+		return "", fmt.Errorf("invalid path %q: %w", p, err)
+	}
+	return filepath.Join(s.root, local), nil
+}
+
+// openRegularFile opens a regular file under root, writing an error response on failure
+func (s *Server) openRegularFile(w http.ResponseWriter, p string) (*os.File, os.FileInfo, bool) {
+	name, err := s.resolvePath(p)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}
+		return nil, nil, false
+	}
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		http.Error(w, "Not a regular file", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	return file, info, true
+}
+
+// handleSignature returns block signatures of a file, used by clients to push deltas
+func (s *Server) handleSignature(w http.ResponseWriter, r *http.Request) {
+	file, info, ok := s.openRegularFile(w, r.PathValue("path"))
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	blockSize := delta.BlockSizeFor(info.Size())
+	if v := r.URL.Query().Get("block-size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < delta.MinBlockSize || n > delta.MaxBlockSize {
+			http.Error(w, "Invalid block size", http.StatusBadRequest)
+			return
+		}
+		blockSize = n
+	}
+
+	sig, err := delta.ComputeSignature(file, blockSize)
+	if err != nil {
+		s.logger.Error("failed to compute signature",
+			zap.String("path", r.PathValue("path")),
+			zap.Error(err),
+		)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sig)
+}
+
+// handleDelta receives the signature of the client copy and returns the
+// instruction stream rebuilding the server file from it
+func (s *Server) handleDelta(w http.ResponseWriter, r *http.Request) {
+	var sig delta.Signature
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSignatureSize)).Decode(&sig); err != nil {
+		http.Error(w, "Invalid signature", http.StatusBadRequest)
+		return
+	}
+	if sig.BlockSize < delta.MinBlockSize || sig.BlockSize > delta.MaxBlockSize {
+		http.Error(w, "Invalid block size", http.StatusBadRequest)
+		return
+	}
+
+	file, _, ok := s.openRegularFile(w, r.PathValue("path"))
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/x-ezft-delta")
+	stats, err := delta.ComputeDelta(&sig, file, w)
+	if err != nil {
+		// Headers are already sent, the client detects the truncated stream
+		s.logger.Error("failed to compute delta",
+			zap.String("path", r.PathValue("path")),
+			zap.Error(err),
+		)
+		return
+	}
+
+	s.logger.Debug("delta sent",
+		zap.String("path", r.PathValue("path")),
+		zap.Int64("copied", stats.CopiedBytes),
+		zap.Int64("literal", stats.LiteralBytes),
+	)
+}
+
+// handleManifest returns the listing of all files below a directory
+func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request) {
+	dir, err := s.resolvePath(r.PathValue("path"))
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if !info.IsDir() {
+		http.Error(w, "Not a directory", http.StatusBadRequest)
+		return
+	}
+
+	m, err := manifest.Scan(dir)
+	if err != nil {
+		s.logger.Error("failed to scan directory",
+			zap.String("path", r.PathValue("path")),
+			zap.Error(err),
+		)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// handleChunks returns the chunk manifest of a file, for the chunk size of the
+// chunk_size parameter or 1MB. Manifests are kept until the file changes.
+func (s *Server) handleChunks(w http.ResponseWriter, r *http.Request) {
+	chunkSize := int64(manifest.DefaultChunkSize)
+	if v := r.URL.Query().Get("chunk_size"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < manifest.MinChunkSize || n > manifest.MaxChunkSize {
+			http.Error(w, "Invalid chunk size", http.StatusBadRequest)
+			return
+		}
+		chunkSize = n
+	}
+
+	name := r.PathValue("path")
+	file, info, ok := s.openRegularFile(w, name)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	key := fmt.Sprintf("%s\x00%d\x00%d\x00%d", path.Clean("/"+name), info.Size(), info.ModTime().UnixNano(), chunkSize)
+	s.chunksMu.Lock()
+	chunks := s.chunks[key]
+	s.chunksMu.Unlock()
+	if chunks == nil {
+		var err error
+		if chunks, err = manifest.HashChunks(file, info.Size(), chunkSize); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		chunks.Name = info.Name()
+
+		s.chunksMu.Lock()
+		if s.chunks == nil || len(s.chunks) >= maxChunkManifests {
+			s.chunks = make(map[string]*manifest.Chunks)
+		}
+		s.chunks[key] = chunks
+		s.chunksMu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chunks)
+}
+
+// handleQUIC tells clients the UDP port and certificate fingerprint of the
+// native protocol, not found unless it is served. The path is ignored, it lets
+// clients build the URL like the other endpoints.
+func (s *Server) handleQUIC(w http.ResponseWriter, r *http.Request) {
+	q := s.quicServer()
+	if q == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quicproto.Discovery{
+		Port:        q.Addr().(*net.UDPAddr).Port,
+		Fingerprint: q.Fingerprint(),
+		Blast:       true,
+	})
+}
+
+// swarmKey returns the key of the swarm downloading the file at p
+func (s *Server) swarmKey(w http.ResponseWriter, p string) (string, bool) {
+	file, info, ok := s.openRegularFile(w, p)
+	if !ok {
+		return "", false
+	}
+	file.Close()
+	return s.tracker.Key(path.Clean("/"+p), info), true
+}
+
+// handlePeers records the announce of a peer downloading a file and returns
+// the key of its swarm with some of the other peers
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	var announce p2p.Announce
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnounceSize)).Decode(&announce); err != nil {
+		http.Error(w, "Invalid announce", http.StatusBadRequest)
+		return
+	}
+	key, ok := s.swarmKey(w, r.PathValue("path"))
+	if !ok {
+		return
+	}
+	peers, err := s.tracker.Announce(key, &announce, r.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p2p.Swarm{Key: key, Peers: peers})
+}
+
+// handlePieces returns the piece hashes peers verify chunks with, accepted
+// while the file is still being hashed
+func (s *Server) handlePieces(w http.ResponseWriter, r *http.Request) {
+	p := r.PathValue("path")
+	key, ok := s.swarmKey(w, p)
+	if !ok {
+		return
+	}
+	pieces, ok := s.tracker.Pieces(key, func() (io.ReadCloser, int64, error) {
+		name, err := s.resolvePath(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := file.Stat()
+		if err != nil || s.tracker.Key(path.Clean("/"+p), info) != key {
+			file.Close()
+			return nil, 0, fmt.Errorf("file changed: %s", p)
+		}
+		return file, info.Size(), nil
+	})
+	if !ok {
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pieces)
+}
+
+// HashResult response of the hash endpoint
+type HashResult struct {
+	Algo string `json:"algo"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// handleHash returns the checksum of a file, sha256 unless algo is given
+func (s *Server) handleHash(w http.ResponseWriter, r *http.Request) {
+	algo := r.URL.Query().Get("algo")
+	if algo == "" {
+		algo = "sha256"
+	}
+	h, err := utils.NewHash(algo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, info, ok := s.openRegularFile(w, r.PathValue("path"))
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HashResult{
+		Algo: algo,
+		Hash: fmt.Sprintf("%x", h.Sum(nil)),
+		Size: info.Size(),
+	})
+}
+
+// handleCompression answers the compression handshake of a file: the codecs
+// of the server and the dictionary to compress its chunks with, or why they
+// are sent as they are
+func (s *Server) handleCompression(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("path")
+	file, info, ok := s.openRegularFile(w, name)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	dict, err := compress.Sample(file, info.Size())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	offer := compress.Offer{Codecs: []string{compress.Zstd}}
+	if offer.Reason = compress.Skip(name, info.Size(), dict); offer.Reason == "" {
+		offer.Compressible = true
+		offer.Dictionary = dict
+		offer.DictionaryID = compress.DictionaryID(dict)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(offer)
+}
@@ -0,0 +1,500 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/compress"
+	"github.com/easzlab/ezft/pkg/delta"
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/p2p"
+	"github.com/easzlab/ezft/pkg/quicproto"
+	"go.uber.org/zap"
+)
+
+func newTestAPIServer(t *testing.T, files map[string][]byte) *httptest.Server {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	server := NewServer(root, 0)
+	server.SetLogger(zap.NewNop())
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestResolvePath(t *testing.T) {
+	server := NewServer("/srv/files", 0)
+	windows := runtime.GOOS == "windows"
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"a.txt", "/srv/files/a.txt", false},
+		{"/dir/b.txt", "/srv/files/dir/b.txt", false},
+		{"", "/srv/files", false},
+		{"../../etc/passwd", "/srv/files/etc/passwd", false},
+		{"dir/../../c.txt", "/srv/files/c.txt", false},
+		{"a\x00b", "", true},
+		// Separators, drives and devices on Windows, plain names elsewhere
+		{`..\..\secret`, `/srv/files/..\..\secret`, windows},
+		{"C:/Windows/win.ini", "/srv/files/C:/Windows/win.ini", windows},
+		{"dir/CON", "/srv/files/dir/CON", windows},
+		{"dir/nul.txt", "/srv/files/dir/nul.txt", windows},
+	}
+
+	for _, tt := range tests {
+		got, err := server.resolvePath(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolvePath(%q) = %q, want error", tt.input, got)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("resolvePath(%q) = %q, %v, want %q", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestHandleSignature(t *testing.T) {
+	content := make([]byte, 10*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	ts := newTestAPIServer(t, map[string][]byte{"dir/data.bin": content})
+
+	resp, err := http.Get(ts.URL + APIPrefix + "signature/dir/data.bin?block-size=2048")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var sig delta.Signature
+	if err := json.NewDecoder(resp.Body).Decode(&sig); err != nil {
+		t.Fatalf("Failed to decode signature: %v", err)
+	}
+	if sig.BlockSize != 2048 || sig.FileSize != int64(len(content)) || len(sig.Blocks) != 5 {
+		t.Errorf("Unexpected signature: blockSize=%d fileSize=%d blocks=%d", sig.BlockSize, sig.FileSize, len(sig.Blocks))
+	}
+}
+
+func TestHandleSignatureErrors(t *testing.T) {
+	ts := newTestAPIServer(t, map[string][]byte{"dir/data.bin": []byte("data")})
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"not_found", "signature/missing.bin", http.StatusNotFound},
+		{"directory", "signature/dir", http.StatusBadRequest},
+		{"invalid_block_size", "signature/dir/data.bin?block-size=1", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + APIPrefix + tt.path)
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandleDelta(t *testing.T) {
+	base := make([]byte, 64*1024)
+	rand.New(rand.NewSource(2)).Read(base)
+	target := append(append([]byte{}, base...), []byte("appended")...)
+	ts := newTestAPIServer(t, map[string][]byte{"data.bin": target})
+
+	sig, err := delta.ComputeSignature(bytes.NewReader(base), 4096)
+	if err != nil {
+		t.Fatalf("ComputeSignature() error = %v", err)
+	}
+	body, _ := json.Marshal(sig)
+
+	resp, err := http.Post(ts.URL+APIPrefix+"delta/data.bin", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var out bytes.Buffer
+	if _, err := delta.Apply(bytes.NewReader(base), 4096, resp.Body, &out); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), target) {
+		t.Error("Patched content does not match server file")
+	}
+}
+
+func TestHandleDeltaInvalidSignature(t *testing.T) {
+	ts := newTestAPIServer(t, map[string][]byte{"data.bin": []byte("data")})
+
+	resp, err := http.Post(ts.URL+APIPrefix+"delta/data.bin", "application/json", bytes.NewReader([]byte("{")))
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandleManifest(t *testing.T) {
+	ts := newTestAPIServer(t, map[string][]byte{
+		"releases/v1/app.bin": []byte("v1"),
+		"releases/notes.txt":  []byte("notes"),
+		"other.txt":           []byte("other"),
+	})
+
+	resp, err := http.Get(ts.URL + APIPrefix + "manifest/releases/")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var m manifest.Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatalf("Failed to decode manifest: %v", err)
+	}
+	if len(m.Entries) != 2 || m.Entries[0].Path != "notes.txt" || m.Entries[1].Path != "v1/app.bin" {
+		t.Errorf("Unexpected manifest entries: %+v", m.Entries)
+	}
+
+	// Root directory and error cases
+	for path, want := range map[string]int{
+		"manifest/":          http.StatusOK,
+		"manifest/missing/":  http.StatusNotFound,
+		"manifest/other.txt": http.StatusBadRequest,
+	} {
+		resp, err := http.Get(ts.URL + APIPrefix + path)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s: expected status %d, got %d", path, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestHandleList(t *testing.T) {
+	ts := newTestAPIServer(t, map[string][]byte{
+		"releases/v1/app.bin": []byte("v1"),
+		"releases/notes.txt":  []byte("notes"),
+	})
+
+	resp, err := http.Get(ts.URL + APIPrefix + "list/releases/")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var m manifest.Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatalf("Failed to decode listing: %v", err)
+	}
+	if len(m.Entries) != 2 || m.Entries[0].Path != "notes.txt" || m.Entries[0].Size != 5 || !m.Entries[1].Dir {
+		t.Errorf("Unexpected listing entries: %+v", m.Entries)
+	}
+
+	for path, want := range map[string]int{
+		"list/":                   http.StatusOK,
+		"list/missing/":           http.StatusNotFound,
+		"list/releases/notes.txt": http.StatusBadRequest,
+	} {
+		resp, err := http.Get(ts.URL + APIPrefix + path)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s: expected status %d, got %d", path, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestHandleHash(t *testing.T) {
+	ts := newTestAPIServer(t, map[string][]byte{"hello.txt": []byte("Hello, World!")})
+
+	resp, err := http.Get(ts.URL + APIPrefix + "hash/hello.txt")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result HashResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode hash: %v", err)
+	}
+	if result.Algo != "sha256" || result.Hash != "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f" || result.Size != 13 {
+		t.Errorf("Unexpected hash result: %+v", result)
+	}
+
+	resp, err = http.Get(ts.URL + APIPrefix + "hash/hello.txt?algo=crc32")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unsupported algorithm, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandleQUIC(t *testing.T) {
+	ts := newTestAPIServer(t, nil)
+	resp, err := http.Get(ts.URL + APIPrefix + "quic/file.iso")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 without QUIC, got %d", resp.StatusCode)
+	}
+
+	server := NewServer(t.TempDir(), 0)
+	server.SetLogger(zap.NewNop())
+	if err := server.ListenQUIC("127.0.0.1:0"); err != nil {
+		t.Fatalf("ListenQUIC() error = %v", err)
+	}
+	ts = httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	resp, err = http.Get(ts.URL + APIPrefix + "quic/file.iso")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+	var discovery quicproto.Discovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		t.Fatalf("Failed to decode discovery: %v", err)
+	}
+	if discovery.Port != server.quic.Addr().(*net.UDPAddr).Port || discovery.Fingerprint != server.quic.Fingerprint() || !discovery.Blast {
+		t.Errorf("Unexpected discovery %+v", discovery)
+	}
+}
+
+// The endpoint may be asked while QUIC starts listening, as Start serves HTTP
+// and QUIC at once
+func TestHandleQUICWhileListening(t *testing.T) {
+	server := NewServer(t.TempDir(), 0)
+	server.SetLogger(zap.NewNop())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ListenQUIC("127.0.0.1:0")
+	}()
+	for {
+		rec := httptest.NewRecorder()
+		server.handleQUIC(rec, httptest.NewRequest("GET", APIPrefix+"quic/file.iso", nil))
+		if rec.Code != http.StatusOK && rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("ListenQUIC() error = %v", err)
+			}
+			return
+		default:
+		}
+	}
+}
+
+func TestHandlePeers(t *testing.T) {
+	ts := newTestAPIServer(t, map[string][]byte{"file.iso": []byte("data")})
+	resp, err := http.Post(ts.URL+APIPrefix+"peers/file.iso", "application/json", bytes.NewReader([]byte(`{"id":"a","port":7400}`)))
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 without tracker, got %d", resp.StatusCode)
+	}
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file.iso"), bytes.Repeat([]byte("data"), 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(root, 0)
+	server.SetLogger(zap.NewNop())
+	server.SetTrackerEnabled(true)
+	ts = httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	announce := func(body string) (int, p2p.Swarm) {
+		resp, err := http.Post(ts.URL+APIPrefix+"peers/file.iso", "application/json", bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer resp.Body.Close()
+		var swarm p2p.Swarm
+		json.NewDecoder(resp.Body).Decode(&swarm)
+		return resp.StatusCode, swarm
+	}
+	if status, swarm := announce(`{"id":"a","port":7400}`); status != http.StatusOK || swarm.Key == "" || len(swarm.Peers) != 0 {
+		t.Errorf("Unexpected announce response %d %+v", status, swarm)
+	}
+	status, swarm := announce(`{"id":"b","port":7401,"ranges":[{"start":0,"end":99}]}`)
+	if status != http.StatusOK || len(swarm.Peers) != 1 || swarm.Peers[0].URL != "http://127.0.0.1:7400/" {
+		t.Errorf("Unexpected announce response %d %+v", status, swarm)
+	}
+	if status, _ := announce(`{"id":"c"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without port, got %d", status)
+	}
+
+	// Piece hashes are computed in the background
+	var pieces p2p.Pieces
+	for i := 0; ; i++ {
+		resp, err := http.Get(ts.URL + APIPrefix + "pieces/file.iso")
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			json.NewDecoder(resp.Body).Decode(&pieces)
+			resp.Body.Close()
+			break
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted || i == 100 {
+			t.Fatalf("Unexpected status %d", resp.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pieces.Size != 4000 || len(pieces.Hashes) != 1 {
+		t.Errorf("Unexpected pieces %+v", pieces)
+	}
+}
+
+func TestHandleCompression(t *testing.T) {
+	text := bytes.Repeat([]byte("compressible log line\n"), 20000)
+	random := make([]byte, 512*1024)
+	rand.New(rand.NewSource(5)).Read(random)
+
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "app.log"), text, 0644)
+	os.WriteFile(filepath.Join(root, "random.bin"), random, 0644)
+	server := NewServer(root, 0)
+	server.SetLogger(zap.NewNop())
+	server.SetCompressionEnabled(true)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	offer := func(name string) compress.Offer {
+		resp, err := http.Get(ts.URL + APIPrefix + "compression/" + name)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer resp.Body.Close()
+		var offer compress.Offer
+		if err := json.NewDecoder(resp.Body).Decode(&offer); err != nil {
+			t.Fatalf("Failed to decode offer of %s: %v", name, err)
+		}
+		return offer
+	}
+	if o := offer("app.log"); !o.Compressible || o.DictionaryID != compress.DictionaryID(o.Dictionary) || len(o.Codecs) == 0 {
+		t.Errorf("Unexpected offer for a log: %+v", o)
+	}
+	if o := offer("random.bin"); o.Compressible || o.Reason != "incompressible content" || o.Dictionary != nil {
+		t.Errorf("Unexpected offer for random data: %+v", o)
+	}
+
+	resp, err := http.Get(ts.URL + APIPrefix + "compression/missing.log")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a missing file, got %d", resp.StatusCode)
+	}
+
+	// Not offered unless enabled
+	resp, err = http.Get(newTestAPIServer(t, nil).URL + APIPrefix + "compression/app.log")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 with compression disabled, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandleChunks(t *testing.T) {
+	content := make([]byte, 200*1024)
+	rand.New(rand.NewSource(9)).Read(content)
+	ts := newTestAPIServer(t, map[string][]byte{"data.bin": content})
+
+	resp, err := http.Get(ts.URL + APIPrefix + "chunks/data.bin?chunk_size=65536")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+	chunks, err := manifest.ReadChunks(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read chunk manifest: %v", err)
+	}
+	if chunks.Name != "data.bin" || chunks.ChunkSize != 65536 || len(chunks.Hashes) != 4 {
+		t.Errorf("Unexpected chunk manifest: %+v", chunks)
+	}
+	for i := range chunks.Hashes {
+		if err := chunks.VerifyAt(bytes.NewReader(content), i); err != nil {
+			t.Errorf("VerifyAt(%d) error = %v", i, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"chunks/data.bin", http.StatusOK},
+		{"chunks/data.bin?chunk_size=1024", http.StatusBadRequest},
+		{"chunks/missing.bin", http.StatusNotFound},
+	} {
+		resp, err := http.Get(ts.URL + APIPrefix + tt.path)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, resp.StatusCode)
+		}
+	}
+}
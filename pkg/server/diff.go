@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/easzlab/ezft/pkg/compress"
+	"github.com/easzlab/ezft/pkg/delta"
+	"go.uber.org/zap"
+)
+
+const (
+	// DiffFormatHeader carries the format of a diff
+	DiffFormatHeader = "X-Ezft-Diff-Format"
+	// BlockSizeHeader carries the block size a diff in the delta format refers
+	// to the base file in
+	BlockSizeHeader = "X-Ezft-Block-Size"
+	// BaseSizeHeader carries the size of the base file of a diff
+	BaseSizeHeader = "X-Ezft-Base-Size"
+)
+
+const (
+	// DiffZstd diffs in the format of zstd --patch-from, a zstd frame
+	// compressed with the base file as its dictionary
+	DiffZstd = "zstd"
+	// DiffDelta diffs in the delta format, for files too large for zstd
+	DiffDelta = "delta"
+)
+
+// diffDir keeps the generated diffs below the root directory
+const diffDir = ".ezft/diffs"
+
+// handleDiff serves the binary diff turning the file given by the from
+// parameter into the file at path. Files up to compress.MaxPatchSize together
+// are diffed with zstd, which finds matches at any byte offset; larger ones in
+// the delta format, whose rolling checksums still find inserted and shifted
+// blocks. Diffs are generated on the first request and kept until either file
+// changes, so they can be downloaded with range requests; they are streamed
+// when the root is read-only.
+func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	name, from := r.PathValue("path"), r.URL.Query().Get("from")
+	if from == "" {
+		http.Error(w, "Missing from parameter", http.StatusBadRequest)
+		return
+	}
+	target, targetInfo, ok := s.openRegularFile(w, name)
+	if !ok {
+		return
+	}
+	defer target.Close()
+	base, baseInfo, ok := s.openRegularFile(w, from)
+	if !ok {
+		return
+	}
+	defer base.Close()
+
+	format, blockSize := diffFormat(baseInfo.Size(), targetInfo.Size())
+	w.Header().Set(DiffFormatHeader, format)
+	if format == DiffDelta {
+		w.Header().Set(BlockSizeHeader, strconv.Itoa(blockSize))
+		w.Header().Set("Content-Type", "application/x-ezft-delta")
+	} else {
+		w.Header().Set("Content-Type", "application/zstd")
+	}
+	w.Header().Set(BaseSizeHeader, strconv.FormatInt(baseInfo.Size(), 10))
+
+	cached, err := s.diffFile(name, from, targetInfo, baseInfo, format, blockSize)
+	if err != nil {
+		s.logger.Info("diff not cached, streaming it",
+			zap.String("path", name),
+			zap.Error(err),
+		)
+		if err := writeDiff(base, target, format, blockSize, w); err != nil {
+			// Headers are already sent, the client detects the truncated stream
+			s.logger.Error("failed to compute diff",
+				zap.String("path", name),
+				zap.Error(err),
+			)
+		}
+		return
+	}
+
+	file, err := os.Open(cached)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	http.ServeContent(w, r, "", targetInfo.ModTime(), file)
+}
+
+// diffFile returns the cached diff between two versions of a file, generating
+// it if needed. Diffs of older versions of the same pair are removed.
+func (s *Server) diffFile(name, from string, targetInfo, baseInfo os.FileInfo, format string, blockSize int) (string, error) {
+	dir := filepath.Join(s.root, filepath.FromSlash(diffDir))
+	pair := sha256.Sum256([]byte(filepath.Clean("/"+name) + "\x00" + filepath.Clean("/"+from)))
+	version := sha256.Sum256(fmt.Appendf(nil, "%d %d %d %d %s %d",
+		targetInfo.Size(), targetInfo.ModTime().UnixNano(), baseInfo.Size(), baseInfo.ModTime().UnixNano(), format, blockSize))
+	prefix := hex.EncodeToString(pair[:8]) + "-"
+	path := filepath.Join(dir, prefix+hex.EncodeToString(version[:8])+".ezd")
+
+	s.diffMu.Lock()
+	defer s.diffMu.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// Generated from fresh handles, the ones of the request may be read already
+	targetPath, err := s.resolvePath(name)
+	if err != nil {
+		return "", err
+	}
+	target, err := os.Open(targetPath)
+	if err != nil {
+		return "", err
+	}
+	defer target.Close()
+	basePath, err := s.resolvePath(from)
+	if err != nil {
+		return "", err
+	}
+	base, err := os.Open(basePath)
+	if err != nil {
+		return "", err
+	}
+	defer base.Close()
+
+	tmp, err := os.CreateTemp(dir, ".diff-*")
+	if err != nil {
+		return "", err
+	}
+	err = writeDiff(base, target, format, blockSize, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	old, _ := filepath.Glob(filepath.Join(dir, prefix+"*.ezd"))
+	for _, p := range old {
+		if p != path {
+			os.Remove(p)
+		}
+	}
+	s.logger.Info("diff generated",
+		zap.String("path", name),
+		zap.String("from", from),
+	)
+	return path, nil
+}
+
+// diffFormat returns the format of the diff between files of the given sizes,
+// and the block size of the delta format
+func diffFormat(baseSize, targetSize int64) (string, int) {
+	if baseSize+targetSize <= compress.MaxPatchSize {
+		return DiffZstd, 0
+	}
+	return DiffDelta, delta.BlockSizeFor(targetSize)
+}
+
+// writeDiff writes the diff turning base into target in format
+func writeDiff(base, target *os.File, format string, blockSize int, w io.Writer) error {
+	if format == DiffZstd {
+		data, err := io.ReadAll(base)
+		if err != nil {
+			return err
+		}
+		info, err := target.Stat()
+		if err != nil {
+			return err
+		}
+		pw, err := compress.NewPatchWriter(w, data, info.Size())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(pw, target); err != nil {
+			pw.Close()
+			return err
+		}
+		return pw.Close()
+	}
+
+	sig, err := delta.ComputeSignature(base, blockSize)
+	if err != nil {
+		return err
+	}
+	_, err = delta.ComputeDelta(sig, target, w)
+	return err
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/compress"
+	"github.com/easzlab/ezft/pkg/delta"
+	"go.uber.org/zap"
+)
+
+func TestHandleDiff(t *testing.T) {
+	oldContent := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(6)).Read(oldContent)
+	newContent := append([]byte("new header"), oldContent...)
+	copy(newContent[500*1024:], bytes.Repeat([]byte("x"), 8192))
+
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "v1.img"), oldContent, 0644)
+	os.WriteFile(filepath.Join(root, "v2.img"), newContent, 0644)
+	server := NewServer(root, 0)
+	server.SetLogger(zap.NewNop())
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	get := func(path, rng string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", ts.URL+APIPrefix+path, nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, diff := get("diff/v2.img?from=/v1.img", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if len(diff) > 4*1024 {
+		t.Errorf("Diff has %d bytes, want only the changed bytes", len(diff))
+	}
+	if got := resp.Header.Get(BaseSizeHeader); got != strconv.Itoa(len(oldContent)) {
+		t.Errorf("%s = %s, want %d", BaseSizeHeader, got, len(oldContent))
+	}
+	if got := resp.Header.Get(DiffFormatHeader); got != DiffZstd {
+		t.Errorf("%s = %s, want %s", DiffFormatHeader, got, DiffZstd)
+	}
+	r, err := compress.NewPatchReader(bytes.NewReader(diff), oldContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(rebuilt, newContent) {
+		t.Fatalf("Applying the diff failed: %v", err)
+	}
+
+	// The cached diff is served with range requests
+	resp, part := get("diff/v2.img?from=v1.img", "bytes=0-9")
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(part, diff[:10]) {
+		t.Errorf("Range of the cached diff: status %d, %q", resp.StatusCode, part)
+	}
+
+	// A new version replaces the cached diff
+	os.WriteFile(filepath.Join(root, "v2.img"), oldContent, 0644)
+	os.Chtimes(filepath.Join(root, "v2.img"), time.Now(), time.Now().Add(time.Minute))
+	if _, diff2 := get("diff/v2.img?from=v1.img", ""); bytes.Equal(diff, diff2) {
+		t.Error("Diff should follow the new version")
+	}
+	if cached, _ := filepath.Glob(filepath.Join(root, ".ezft", "diffs", "*.ezd")); len(cached) != 1 {
+		t.Errorf("%d cached diffs, want 1", len(cached))
+	}
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"diff/v2.img", http.StatusBadRequest},
+		{"diff/v2.img?from=v0.img", http.StatusNotFound},
+		{"diff/v3.img?from=v1.img", http.StatusNotFound},
+	} {
+		if resp, _ := get(tt.path, ""); resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	if format, _ := diffFormat(compress.MaxPatchSize/2, compress.MaxPatchSize/2); format != DiffZstd {
+		t.Errorf("diffFormat() = %s, want %s", format, DiffZstd)
+	}
+	format, blockSize := diffFormat(compress.MaxPatchSize, 1)
+	if format != DiffDelta || blockSize != delta.BlockSizeFor(1) {
+		t.Errorf("diffFormat() = %s, %d, want %s", format, blockSize, DiffDelta)
+	}
+
+	oldContent := make([]byte, 512*1024)
+	rand.New(rand.NewSource(8)).Read(oldContent)
+	newContent := append([]byte("moved"), oldContent[256*1024:]...)
+	newContent = append(newContent, oldContent[:256*1024]...)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "old"), oldContent, 0644)
+	os.WriteFile(filepath.Join(dir, "new"), newContent, 0644)
+
+	for _, format := range []string{DiffZstd, DiffDelta} {
+		base, _ := os.Open(filepath.Join(dir, "old"))
+		target, _ := os.Open(filepath.Join(dir, "new"))
+		var diff bytes.Buffer
+		err := writeDiff(base, target, format, 4096, &diff)
+		base.Close()
+		target.Close()
+		if err != nil {
+			t.Fatalf("writeDiff(%s) error = %v", format, err)
+		}
+		if diff.Len() > 16*1024 {
+			t.Errorf("Diff in %s has %d bytes, want only the moved halves referenced", format, diff.Len())
+		}
+
+		var rebuilt bytes.Buffer
+		if format == DiffDelta {
+			_, err = delta.Apply(bytes.NewReader(oldContent), 4096, &diff, &rebuilt)
+		} else {
+			var r io.ReadCloser
+			if r, err = compress.NewPatchReader(&diff, oldContent); err == nil {
+				_, err = rebuilt.ReadFrom(r)
+				r.Close()
+			}
+		}
+		if err != nil || !bytes.Equal(rebuilt.Bytes(), newContent) {
+			t.Errorf("Applying the diff in %s failed: %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// fileCache is an http.FileSystem keeping the descriptors of the most recently
+// served files open, so concurrent range requests for a hot file share one
+// descriptor instead of opening and closing it per request. A cached file is
+// checked against a stat of its path on every open and reopened once it was
+// replaced or changed.
+type fileCache struct {
+	dir  http.Dir
+	size int // Maximum number of cached files
+
+	mu    sync.Mutex
+	files map[string]*list.Element // Elements of lru by file path
+	lru   *list.List               // *cachedFile, most recently used first
+}
+
+// cachedFile an open file shared by the requests reading it
+type cachedFile struct {
+	name    string
+	file    *os.File
+	info    fs.FileInfo // Stat of the file when it was opened
+	refs    int         // Open handles
+	evicted bool        // Closed once the last handle is closed
+}
+
+// newFileCache caches up to size open files below root
+func newFileCache(root string, size int) *fileCache {
+	return &fileCache{
+		dir:   http.Dir(root),
+		size:  size,
+		files: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// Open opens name like http.Dir, regular files are served from the cache
+func (c *fileCache) Open(name string) (http.File, error) {
+	p := filepath.Join(string(c.dir), filepath.FromSlash(path.Clean("/"+name)))
+	info, err := os.Stat(p)
+	if err != nil || !info.Mode().IsRegular() {
+		// Directories and errors are left to http.Dir
+		return c.dir.Open(name)
+	}
+
+	if h := c.get(p, info); h != nil {
+		return h, nil
+	}
+
+	file, err := c.dir.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	osFile, ok := file.(*os.File)
+	if !ok {
+		return file, nil
+	}
+	info, err = osFile.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return file, nil
+	}
+	return c.add(p, osFile, info), nil
+}
+
+// get returns a handle of the cached file at path p if it is still the
+// version of info, a changed file is dropped
+func (c *fileCache) get(p string, info fs.FileInfo) http.File {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.files[p]
+	if !ok {
+		return nil
+	}
+	f := e.Value.(*cachedFile)
+	if !sameVersion(f.info, info) {
+		c.remove(e)
+		return nil
+	}
+	f.refs++
+	c.lru.MoveToFront(e)
+	return &cachedHandle{cached: f, cache: c}
+}
+
+// add caches a file opened for a request and returns its handle. The file is
+// closed when a concurrent request cached the same version first.
+func (c *fileCache) add(p string, file *os.File, info fs.FileInfo) http.File {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.files[p]; ok {
+		if f := e.Value.(*cachedFile); sameVersion(f.info, info) {
+			file.Close()
+			f.refs++
+			c.lru.MoveToFront(e)
+			return &cachedHandle{cached: f, cache: c}
+		}
+		c.remove(e)
+	}
+
+	f := &cachedFile{name: p, file: file, info: info, refs: 1}
+	c.files[p] = c.lru.PushFront(f)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+	return &cachedHandle{cached: f, cache: c}
+}
+
+// remove drops a file from the cache, it is closed once no request reads it
+func (c *fileCache) remove(e *list.Element) {
+	f := c.lru.Remove(e).(*cachedFile)
+	delete(c.files, f.name)
+	f.evicted = true
+	if f.refs == 0 {
+		f.file.Close()
+	}
+}
+
+// release closes a handle of a cached file
+func (c *fileCache) release(f *cachedFile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f.refs--
+	if f.refs == 0 && f.evicted {
+		f.file.Close()
+	}
+}
+
+// Close closes all cached files not read by a request, the others are closed
+// by their last request
+func (c *fileCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Front())
+	}
+}
+
+// sameVersion reports whether two stats are of the same unchanged file
+func sameVersion(a, b fs.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// cachedHandle reads a cached file at its own offset with ReadAt, as the
+// descriptor is shared by concurrent requests
+type cachedHandle struct {
+	cached *cachedFile
+	cache  *fileCache
+	offset int64
+	closed bool
+}
+
+func (h *cachedHandle) Read(p []byte) (int, error) {
+	if h.closed {
+		return 0, os.ErrClosed
+	}
+	n, err := h.cached.file.ReadAt(p, h.offset)
+	h.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (h *cachedHandle) Seek(offset int64, whence int) (int64, error) {
+	if h.closed {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += h.offset
+	case io.SeekEnd:
+		offset += h.cached.info.Size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	h.offset = offset
+	return offset, nil
+}
+
+func (h *cachedHandle) Readdir(int) ([]fs.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+func (h *cachedHandle) Stat() (fs.FileInfo, error) {
+	return h.cached.info, nil
+}
+
+func (h *cachedHandle) Close() error {
+	if h.closed {
+		return os.ErrClosed
+	}
+	h.closed = true
+	h.cache.release(h.cached)
+	return nil
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestFileCache(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "app.bin")
+	if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	cache := newFileCache(root, 1)
+	defer cache.Close()
+
+	read := func(name string, offset int64) string {
+		t.Helper()
+		f, err := cache.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", name, err)
+		}
+		defer f.Close()
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			t.Fatalf("Seek() error = %v", err)
+		}
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatalf("ReadAll() error = %v", err)
+		}
+		return string(b)
+	}
+
+	// Handles of the same file read at their own offsets
+	a, _ := cache.Open("/app.bin")
+	b, _ := cache.Open("/app.bin")
+	a.Seek(6, io.SeekStart)
+	buf := make([]byte, 2)
+	io.ReadFull(a, buf)
+	if string(buf) != "67" {
+		t.Errorf("First handle read %q, want 67", buf)
+	}
+	io.ReadFull(b, buf)
+	if string(buf) != "01" {
+		t.Errorf("Second handle read %q, want 01", buf)
+	}
+	if len(cache.files) != 1 || cache.lru.Front().Value.(*cachedFile).refs != 2 {
+		t.Errorf("Expected one cached file shared by both handles")
+	}
+	a.Close()
+	b.Close()
+
+	// A changed file is reopened
+	if err := os.WriteFile(path, []byte("abc"), 0644); err != nil {
+		t.Fatalf("Failed to change file: %v", err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	if got := read("/app.bin", 1); got != "bc" {
+		t.Errorf("Read after change = %q, want bc", got)
+	}
+
+	// A replaced file is reopened
+	tmp := filepath.Join(root, "new.bin")
+	os.WriteFile(tmp, []byte("xyz"), 0644)
+	os.Chtimes(tmp, time.Now(), time.Now().Add(time.Minute))
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("Failed to replace file: %v", err)
+	}
+	if got := read("/app.bin", 0); got != "xyz" {
+		t.Errorf("Read after replace = %q, want xyz", got)
+	}
+
+	// The least recently used file is evicted, directories are not cached
+	os.WriteFile(filepath.Join(root, "other.bin"), []byte("other"), 0644)
+	read("/other.bin", 0)
+	if _, ok := cache.files[path]; ok || len(cache.files) != 1 {
+		t.Errorf("Expected app.bin to be evicted, cached %d files", len(cache.files))
+	}
+	if d, err := cache.Open("/"); err != nil {
+		t.Errorf("Open(/) error = %v", err)
+	} else {
+		d.Close()
+	}
+	if _, err := cache.Open("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Open(/missing) error = %v, want not exist", err)
+	}
+}
+
+func TestFileCacheRange(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "app.bin"), []byte("0123456789"), 0644)
+
+	server := NewServer(root, 0)
+	server.SetLogger(zap.NewNop())
+	server.SetOpenFileCache(4)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/app.bin", nil)
+		req.Header.Set("Range", "bytes=2-4")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusPartialContent || string(body) != "234" {
+			t.Errorf("Range request: status %d, body %q", resp.StatusCode, body)
+		}
+	}
+	if len(server.files.files) != 1 {
+		t.Errorf("Expected the file to be cached")
+	}
+}
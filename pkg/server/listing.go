@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"go.uber.org/zap"
+)
+
+const (
+	// listBufferSize buffers listings written to the client
+	listBufferSize = 32 * 1024
+	// defaultListLimit entries of a listing page without a limit
+	defaultListLimit = 1000
+	// maxListLimit bounds the entries a sorted page keeps in memory, its
+	// offset included
+	maxListLimit = 10000
+)
+
+// parseListOptions reads the sort, order, after, offset and limit parameters
+// of a listing request. Pages hold defaultListLimit entries unless limit asks
+// for another size, and sorted pages are at most maxListLimit entries past the
+// start or the continuation token.
+func parseListOptions(r *http.Request) (manifest.ListOptions, error) {
+	q := r.URL.Query()
+	opts := manifest.ListOptions{Sort: q.Get("sort"), After: q.Get("after"), Limit: defaultListLimit}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Reverse = true
+	default:
+		return opts, errors.New("invalid order")
+	}
+	for name, v := range map[string]*int{"offset": &opts.Offset, "limit": &opts.Limit} {
+		if s := q.Get(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return opts, errors.New("invalid " + name)
+			}
+			*v = n
+		}
+	}
+	if opts.Limit == 0 {
+		opts.Limit = defaultListLimit
+	}
+	if opts.Sort != manifest.SortNone && opts.Offset+opts.Limit > maxListLimit {
+		return opts, fmt.Errorf("offset and limit beyond %d entries, continue with the next token", maxListLimit)
+	}
+	return opts, opts.Validate()
+}
+
+// listFormat writes the entries of a listing in one format
+type listFormat struct {
+	contentType string
+	header      string
+	entry       func(bw *bufio.Writer, e manifest.Entry, i int) error
+	footer      func(bw *bufio.Writer, next string)
+}
+
+// streamList writes a page of the entries of dir as they are read from the
+// directory. Failures before the first entry are answered with an error
+// response.
+func (s *Server) streamList(w http.ResponseWriter, r *http.Request, dir string, f listFormat) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bw := bufio.NewWriterSize(w, listBufferSize)
+	start := func() {
+		w.Header().Set("Content-Type", f.contentType)
+		bw.WriteString(f.header)
+	}
+	n := 0
+	next, err := manifest.ListDir(dir, opts, func(e manifest.Entry) error {
+		if n == 0 {
+			start()
+		}
+		n++
+		// Buffered write errors stick, a gone client ends the listing
+		return f.entry(bw, e, n-1)
+	})
+	if err != nil {
+		if n == 0 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		// Headers are already sent, the client detects the truncated listing
+		s.logger.Error("failed to list directory",
+			zap.String("path", r.URL.Path),
+			zap.Error(err),
+		)
+		return
+	}
+	if n == 0 {
+		start()
+	}
+	f.footer(bw, next)
+	bw.Flush()
+}
+
+// jsonList writes listings as the JSON manifest of the list endpoint, with
+// the continuation token of the next page if any
+var jsonList = listFormat{
+	contentType: "application/json",
+	header:      `{"entries":[`,
+	entry: func(bw *bufio.Writer, e manifest.Entry, i int) error {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = bw.Write(b)
+		return err
+	},
+	footer: func(bw *bufio.Writer, next string) {
+		bw.WriteByte(']')
+		if next != "" {
+			b, _ := json.Marshal(next)
+			bw.WriteString(`,"next":`)
+			bw.Write(b)
+		}
+		bw.WriteString("}\n")
+	},
+}
+
+// htmlList writes listings as the HTML page of a directory, with a link to
+// the next page if any
+func htmlList(r *http.Request) listFormat {
+	return listFormat{
+		contentType: "text/html; charset=utf-8",
+		header:      "<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n",
+		entry: func(bw *bufio.Writer, e manifest.Entry, _ int) error {
+			name := e.Path
+			if e.Dir {
+				name += "/"
+			}
+			link := url.URL{Path: name}
+			_, err := bw.WriteString("<a href=\"" + html.EscapeString(link.String()) + "\">" + html.EscapeString(name) + "</a>\n")
+			return err
+		},
+		footer: func(bw *bufio.Writer, next string) {
+			bw.WriteString("</pre>\n")
+			if next != "" {
+				q := r.URL.Query()
+				q.Del("offset")
+				q.Set("after", next)
+				bw.WriteString("<a href=\"?" + html.EscapeString(q.Encode()) + "\">Next page</a>\n")
+			}
+		},
+	}
+}
+
+// handleList returns the entries of a directory, subdirectories included.
+// Entries are sorted by name unless the sort parameter asks for size, mtime
+// or none, order=desc reverses the order and offset and limit select a page,
+// continued by the token of the previous page in after.
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	dir, err := s.resolvePath(r.PathValue("path"))
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if !info.IsDir() {
+		http.Error(w, "Not a directory", http.StatusBadRequest)
+		return
+	}
+	s.streamList(w, r, dir, jsonList)
+}
+
+// DirectoryMiddleware lists directories without an index.html as HTML,
+// streamed and paged like the listings of the API
+func (s *Server) DirectoryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		dir, err := s.resolvePath(r.URL.Path)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.streamList(w, r, dir, htmlList(r))
+	})
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+)
+
+func TestHandleListPage(t *testing.T) {
+	ts := newTestAPIServer(t, map[string][]byte{
+		"dir/a.txt": []byte("aaa"),
+		"dir/b.txt": []byte("b"),
+		"dir/c.txt": []byte("cc"),
+	})
+
+	tests := []struct {
+		query  string
+		status int
+		want   string
+	}{
+		{"", http.StatusOK, "a.txt,b.txt,c.txt"},
+		{"?sort=size&order=desc", http.StatusOK, "a.txt,c.txt,b.txt"},
+		{"?offset=1&limit=1", http.StatusOK, "b.txt"},
+		{"?offset=5", http.StatusOK, ""},
+		{"?sort=color", http.StatusBadRequest, ""},
+		{"?limit=-1", http.StatusBadRequest, ""},
+		{"?order=up", http.StatusBadRequest, ""},
+		{"?after=bogus", http.StatusBadRequest, ""},
+		{"?offset=9000&limit=2000", http.StatusBadRequest, ""},
+		{"?sort=none&offset=9000&limit=2000", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL + APIPrefix + "list/dir/" + tt.query)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.status, resp.StatusCode)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var m manifest.Manifest
+			if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+				t.Fatalf("%q: failed to decode listing: %v", tt.query, err)
+			}
+			names := []string{}
+			for _, e := range m.Entries {
+				names = append(names, e.Path)
+			}
+			if got := strings.Join(names, ","); got != tt.want || m.Entries == nil {
+				t.Errorf("%q: listing = %q, want %q", tt.query, got, tt.want)
+			}
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestDirectoryMiddleware(t *testing.T) {
+	ts := newTestAPIServer(t, map[string][]byte{
+		"files/a b.txt":         []byte("a"),
+		"files/<b>.txt":         []byte("b"),
+		"files/sub/c.txt":       []byte("c"),
+		"files/x.iso.ezft.tmp":  []byte("partial"),
+		"site/index.html":       []byte("welcome"),
+		"site/other/readme.txt": []byte("readme"),
+	})
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	body := get("/files/")
+	for _, want := range []string{
+		`<a href="%3Cb%3E.txt">&lt;b&gt;.txt</a>`,
+		`<a href="a%20b.txt">a b.txt</a>`,
+		`<a href="sub/">sub/</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Listing misses %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "ezft.tmp") {
+		t.Errorf("Listing shows internal files:\n%s", body)
+	}
+
+	body = get("/files/?limit=2")
+	_, link, ok := strings.Cut(body, `<a href="?after=`)
+	if !ok || strings.Contains(body, "sub/") {
+		t.Fatalf("Unexpected first page:\n%s", body)
+	}
+	token, _, _ := strings.Cut(link, "&amp;limit=2\">Next page</a>")
+	if body := get("/files/?limit=2&after=" + token); !strings.Contains(body, "sub/") || strings.Contains(body, "Next page") {
+		t.Errorf("Unexpected second page:\n%s", body)
+	}
+
+	if body := get("/site/"); body != "welcome" {
+		t.Errorf("Expected the index page, got %q", body)
+	}
+}
+
+func TestHandleListDefaultPage(t *testing.T) {
+	files := map[string][]byte{}
+	for i := range defaultListLimit + 10 {
+		files[fmt.Sprintf("big/%05d", i)] = nil
+	}
+	ts := newTestAPIServer(t, files)
+
+	// Pages of defaultListLimit entries are followed to the end
+	var names []string
+	query := ""
+	for pages := 0; ; pages++ {
+		resp, err := http.Get(ts.URL + APIPrefix + "list/big/" + query)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		var m manifest.Manifest
+		err = json.NewDecoder(resp.Body).Decode(&m)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to decode listing: %v", err)
+		}
+		if len(m.Entries) > defaultListLimit {
+			t.Fatalf("Page of %d entries", len(m.Entries))
+		}
+		for _, e := range m.Entries {
+			names = append(names, e.Path)
+		}
+		if m.Next == "" {
+			if pages != 1 {
+				t.Errorf("Listed in %d pages, want 2", pages+1)
+			}
+			break
+		}
+		query = "?after=" + url.QueryEscape(m.Next)
+	}
+	if len(names) != defaultListLimit+10 || names[0] != "00000" || names[len(names)-1] != fmt.Sprintf("%05d", defaultListLimit+9) {
+		t.Errorf("Listed %d entries from %s", len(names), names[0])
+	}
+}
@@ -1,9 +1,18 @@
 package server
 
 import (
+	"crypto/subtle"
+	"errors"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
+	"github.com/easzlab/ezft/pkg/compress"
+	"github.com/easzlab/ezft/pkg/e2e"
+	"github.com/easzlab/ezft/pkg/metrics"
+	"github.com/easzlab/ezft/pkg/utils"
 	"go.uber.org/zap"
 )
 
@@ -25,6 +34,14 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
+// ReadFrom keeps the sendfile path of the http server, which file responses
+// take through io.ReaderFrom, so files are sent without userland buffers
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	n, err := io.Copy(rw.ResponseWriter, src)
+	rw.responseSize += n
+	return n, err
+}
+
 // Logging middleware
 func (s *Server) LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -38,7 +55,9 @@ func (s *Server) LoggingMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Call the next handler
+		metrics.ServerStats.Add(metrics.ActiveRequests, 1)
 		next.ServeHTTP(rw, r)
+		metrics.ServerStats.Add(metrics.ActiveRequests, -1)
 
 		// Get request information
 		duration := time.Since(start)
@@ -49,9 +68,22 @@ func (s *Server) LoggingMiddleware(next http.Handler) http.Handler {
 			contentLength = 0
 		}
 
+		if s.accessLog != nil {
+			if err := s.accessLog.Log(r, rw.statusCode, rw.responseSize, contentLength, duration); err != nil {
+				s.logger.Warn("Failed to write the access log", zap.Error(err))
+			}
+		}
+
+		metrics.ServerStats.Add(metrics.Requests, 1)
+		metrics.ServerStats.Add(metrics.BytesSent, rw.responseSize)
+		metrics.ServerStats.Add(metrics.BytesReceived, contentLength)
+		if rw.statusCode >= 500 {
+			metrics.ServerStats.Add(metrics.ServerErrors, 1)
+		}
+
 		// Log detailed information
-		s.logger.Info("",
-			zap.String("time", start.Format("2006-01-02 15:04:05")),
+		s.logger.Info("request",
+			zap.String("startTime", start.Format("2006-01-02 15:04:05")),
 			zap.String("remoteAddr", r.RemoteAddr),
 			zap.String("method", r.Method),
 			zap.String("url", r.URL.RequestURI()), // Use RequestURI to include query parameters
@@ -62,6 +94,22 @@ func (s *Server) LoggingMiddleware(next http.Handler) http.Handler {
 			zap.String("userAgent", userAgent),
 			zap.String("referer", referer),
 		)
+		if threshold := s.slowThreshold(); threshold > 0 && duration > threshold {
+			metrics.ServerStats.Add(metrics.SlowRequests, 1)
+			s.logger.Warn("Slow request",
+				zap.String("remoteAddr", r.RemoteAddr),
+				zap.String("userAgent", userAgent),
+				zap.String("method", r.Method),
+				zap.String("url", r.URL.RequestURI()),
+				zap.String("range", r.Header.Get("Range")),
+				zap.Int("statusCode", rw.statusCode),
+				zap.Int64("reqSize", contentLength),
+				zap.Int64("respSize", rw.responseSize),
+				zap.Duration("duration", duration),
+				zap.Duration("threshold", threshold),
+				zap.String("speed", utils.CalculateSpeed(rw.responseSize+contentLength, duration)),
+			)
+		}
 	})
 }
 
@@ -80,8 +128,11 @@ func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Check username and password (hardcoded here, should be retrieved from secure storage in production)
-		if username != "admin" || password != "password" {
+		// Check username and password in constant time
+		wantUsername, wantPassword, _ := s.credentials()
+		userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(wantUsername)) == 1
+		passMatch := subtle.ConstantTimeCompare([]byte(password), []byte(wantPassword)) == 1
+		if !userMatch || !passMatch {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			s.logger.Warn("Invalid credentials",
 				zap.String("remoteAddr", r.RemoteAddr),
@@ -93,3 +144,203 @@ func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// encryptWriter encrypts the body of successful responses
+type encryptWriter struct {
+	http.ResponseWriter
+	key         *e2e.Key
+	head        bool
+	wroteHeader bool
+	body        io.WriteCloser // Encrypting writer, nil for other responses
+	err         error
+}
+
+func (ew *encryptWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+	if code != http.StatusOK && code != http.StatusPartialContent {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	// HEAD responses keep the plaintext length, which clients take as file size
+	h := ew.Header()
+	h.Set(e2e.Header, ew.key.ID())
+	h.Set("Cache-Control", "no-transform")
+	if !ew.head {
+		if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil {
+			h.Set("Content-Length", strconv.FormatInt(e2e.EncryptedSize(n), 10))
+		}
+		h.Set("Content-Type", "application/octet-stream")
+	}
+	ew.ResponseWriter.WriteHeader(code)
+	if !ew.head {
+		ew.body, ew.err = ew.key.NewWriter(ew.ResponseWriter)
+	}
+}
+
+func (ew *encryptWriter) Write(b []byte) (int, error) {
+	ew.WriteHeader(http.StatusOK)
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	if ew.body == nil {
+		return ew.ResponseWriter.Write(b)
+	}
+	return ew.body.Write(b)
+}
+
+// EncryptMiddleware encrypts the payloads of successful responses end to end
+// with the key of the server, only clients sharing it can read them
+func (s *Server) EncryptMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &encryptWriter{ResponseWriter: w, key: s.e2eKey, head: r.Method == http.MethodHead}
+		next.ServeHTTP(ew, r)
+		if ew.body != nil {
+			ew.body.Close()
+		}
+	})
+}
+
+// compressWriter compresses the body of successful responses
+type compressWriter struct {
+	http.ResponseWriter
+	dict        []byte
+	wroteHeader bool
+	body        io.WriteCloser // Compressing writer, nil for other responses
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set(compress.Header, compress.Zstd)
+		h.Add("Vary", compress.Header)
+		cw.body = compress.NewWriter(cw.ResponseWriter, cw.dict)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	cw.WriteHeader(http.StatusOK)
+	if cw.body == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.body.Write(b)
+}
+
+// CompressMiddleware compresses file downloads for clients holding the current
+// dictionary of the file, clients with an outdated one get it as it is
+func (s *Server) CompressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := compress.ParseAccept(r.Header.Get(compress.Header))
+		if !ok || r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		dict, err := s.dictionary(r.URL.Path)
+		if err != nil || compress.DictionaryID(dict) != id {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, dict: dict}
+		next.ServeHTTP(cw, r)
+		if cw.body != nil {
+			cw.body.Close()
+		}
+	})
+}
+
+// rangesWriter advertises range requests on the responses of files
+type rangesWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (rw *rangesWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	switch code {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		rw.Header().Set("Accept-Ranges", "bytes")
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *rangesWriter) Write(b []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	return rw.ResponseWriter.Write(b)
+}
+
+// ReadFrom keeps the sendfile path of the files sent
+func (rw *rangesWriter) ReadFrom(src io.Reader) (int64, error) {
+	rw.WriteHeader(http.StatusOK)
+	return io.Copy(rw.ResponseWriter, src)
+}
+
+// RangesMiddleware sends Accept-Ranges: bytes with every file and every
+// answer to a range request, HEAD included, so that clients probing with HEAD
+// find downloads resumable. The file server leaves it out of the responses to
+// unsatisfiable ranges.
+func (s *Server) RangesMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&rangesWriter{ResponseWriter: w}, r)
+	})
+}
+
+// limitWriter writes the body of a response at the rate of a limiter
+type limitWriter struct {
+	http.ResponseWriter
+	body io.Writer
+}
+
+func (lw *limitWriter) Write(b []byte) (int, error) {
+	return lw.body.Write(b)
+}
+
+func (lw *limitWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// LimitMiddleware sends file downloads within the bandwidth limit shared by
+// all of them, the limit in effect when they started
+func (s *Server) LimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := s.rateLimiter()
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&limitWriter{ResponseWriter: w, body: limiter.Writer(r.Context(), w)}, r)
+	})
+}
+
+// dictionary samples the compression dictionary of a regular file
+func (s *Server) dictionary(p string) ([]byte, error) {
+	name, err := s.resolvePath(p)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("not a regular file")
+	}
+	return compress.Sample(file, info.Size())
+}
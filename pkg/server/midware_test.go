@@ -3,14 +3,20 @@ package server
 import (
 	"bytes"
 	"encoding/base64"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/easzlab/ezft/pkg/e2e"
+	"github.com/easzlab/ezft/pkg/ratelimit"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestResponseWriter_WriteHeader(t *testing.T) {
@@ -119,6 +125,40 @@ func TestResponseWriter_MultipleWrites(t *testing.T) {
 	}
 }
 
+// readerFromRecorder records the readers of ReadFrom
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom int
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom++
+	return io.Copy(r.ResponseRecorder.Body, src)
+}
+
+func TestResponseWriter_ReadFrom(t *testing.T) {
+	recorder := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	rw := &responseWriter{
+		ResponseWriter: recorder,
+		statusCode:     200,
+	}
+
+	// Files reach the ReadFrom of the http server, where it uses sendfile
+	n, err := io.Copy(rw, io.LimitReader(strings.NewReader("Hello, World!"), 5))
+	if err != nil {
+		t.Fatalf("io.Copy() error = %v", err)
+	}
+	if n != 5 || rw.responseSize != 5 {
+		t.Errorf("io.Copy() = %d, responseSize = %d, want 5", n, rw.responseSize)
+	}
+	if recorder.readFrom != 1 {
+		t.Errorf("ReadFrom called %d times, want 1", recorder.readFrom)
+	}
+	if recorder.Body.String() != "Hello" {
+		t.Errorf("Body = %q, want %q", recorder.Body.String(), "Hello")
+	}
+}
+
 func TestLoggingMiddleware(t *testing.T) {
 	// Capture log output
 	var logBuffer bytes.Buffer
@@ -375,3 +415,195 @@ func TestMiddlewareChaining_AuthFailure(t *testing.T) {
 		t.Errorf("Expected status 401, got %d", recorder.Code)
 	}
 }
+
+func TestEncryptMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("secret file contents ", 10000)
+	if err := os.WriteFile(dir+"/data.bin", []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	key, err := e2e.ParseKey("0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(dir, 0)
+	server.SetLogger(zap.NewNop())
+	server.SetE2EKey(key)
+	handler := server.Handler()
+
+	// HEAD announces the key and keeps the plaintext length
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("HEAD", "/data.bin", nil))
+	if got := recorder.Header().Get(e2e.Header); got != key.ID() {
+		t.Errorf("HEAD %s = %q, want %q", e2e.Header, got, key.ID())
+	}
+	if got := recorder.Header().Get("Content-Length"); got != strconv.Itoa(len(content)) {
+		t.Errorf("HEAD Content-Length = %s, want %d", got, len(content))
+	}
+
+	for _, rng := range []string{"", "bytes=1000-99999"} {
+		req := httptest.NewRequest("GET", "/data.bin", nil)
+		want := content
+		if rng != "" {
+			req.Header.Set("Range", rng)
+			want = content[1000:100000]
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		body := recorder.Body.Bytes()
+		if bytes.Contains(body, []byte("secret file contents")) {
+			t.Errorf("Body of %q is not encrypted", rng)
+		}
+		if got := recorder.Header().Get("Content-Length"); got != strconv.FormatInt(e2e.EncryptedSize(int64(len(want))), 10) || len(body) != int(e2e.EncryptedSize(int64(len(want)))) {
+			t.Errorf("Content-Length of %q = %s, body %d bytes", rng, got, len(body))
+		}
+		plain, err := io.ReadAll(key.NewReader(bytes.NewReader(body)))
+		if err != nil || string(plain) != want {
+			t.Errorf("Decrypting %q failed: %v", rng, err)
+		}
+	}
+
+	// Errors are sent as they are
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/missing.bin", nil))
+	if recorder.Code != http.StatusNotFound || recorder.Header().Get(e2e.Header) != "" {
+		t.Errorf("Missing file: status %d, %s %q", recorder.Code, e2e.Header, recorder.Header().Get(e2e.Header))
+	}
+}
+
+func TestLimitMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("x"), 96<<10)
+	if err := os.WriteFile(dir+"/data.bin", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(dir, 0)
+	server.SetLogger(zap.NewNop())
+	// 64KB/s with a one second burst shared by both downloads, 192KB take
+	// about two seconds
+	server.SetRateLimiter(ratelimit.NewLimiter(ratelimit.NewSchedule(64 << 10)))
+	handler := server.Handler()
+
+	start := time.Now()
+	done := make(chan *httptest.ResponseRecorder, 2)
+	for range 2 {
+		go func() {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/data.bin", nil))
+			done <- recorder
+		}()
+	}
+	for range 2 {
+		if recorder := <-done; recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), content) {
+			t.Errorf("GET status %d, %d bytes", recorder.Code, recorder.Body.Len())
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Expected about 2s, took %v", elapsed)
+	}
+}
+
+func TestRangesMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("0123456789", 500)
+	if err := os.WriteFile(dir+"/data.bin", []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir+"/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(dir, 0)
+	server.SetLogger(zap.NewNop())
+	handler := server.Handler()
+
+	tests := []struct {
+		method, path, rng string
+		wantCode          int
+		wantLength        string
+		wantRange         string
+		wantAccept        string
+	}{
+		{"HEAD", "/data.bin", "", http.StatusOK, "5000", "", "bytes"},
+		{"HEAD", "/data.bin", "bytes=10-19", http.StatusPartialContent, "10", "bytes 10-19/5000", "bytes"},
+		{"HEAD", "/data.bin", "bytes=4990-", http.StatusPartialContent, "10", "bytes 4990-4999/5000", "bytes"},
+		{"HEAD", "/data.bin", "bytes=9000-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */5000", "bytes"},
+		{"GET", "/data.bin", "", http.StatusOK, "5000", "", "bytes"},
+		{"GET", "/data.bin", "bytes=10-19", http.StatusPartialContent, "10", "bytes 10-19/5000", "bytes"},
+		{"GET", "/data.bin", "bytes=9000-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */5000", "bytes"},
+		{"HEAD", "/missing.bin", "", http.StatusNotFound, "", "", ""},
+		{"GET", "/sub/", "", http.StatusOK, "", "", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.rng != "" {
+			req.Header.Set("Range", tt.rng)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		h := recorder.Header()
+		name := tt.method + " " + tt.path + " " + tt.rng
+		if recorder.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d", name, recorder.Code, tt.wantCode)
+		}
+		if got := h.Get("Accept-Ranges"); got != tt.wantAccept {
+			t.Errorf("%s: Accept-Ranges = %q, want %q", name, got, tt.wantAccept)
+		}
+		if tt.wantLength != "" && h.Get("Content-Length") != tt.wantLength {
+			t.Errorf("%s: Content-Length = %q, want %q", name, h.Get("Content-Length"), tt.wantLength)
+		}
+		if got := h.Get("Content-Range"); got != tt.wantRange {
+			t.Errorf("%s: Content-Range = %q, want %q", name, got, tt.wantRange)
+		}
+		// The server drops the bodies of errors to HEAD, the recorder keeps them
+		if tt.method == "HEAD" && tt.wantCode < 300 && recorder.Body.Len() != 0 {
+			t.Errorf("%s: %d bytes of body", name, recorder.Body.Len())
+		}
+		if tt.method == "GET" && tt.wantCode == http.StatusPartialContent && recorder.Body.String() != content[10:20] {
+			t.Errorf("%s: body %q", name, recorder.Body.String())
+		}
+	}
+
+	// The file server still sends files through io.ReaderFrom
+	if _, ok := any(&rangesWriter{}).(io.ReaderFrom); !ok {
+		t.Error("rangesWriter does not implement io.ReaderFrom")
+	}
+}
+
+func TestLoggingMiddleware_SlowRequest(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Write([]byte("data"))
+	})
+
+	var logs bytes.Buffer
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	server := NewServer("/tmp", 8080)
+	server.SetLogger(zap.New(zapcore.NewCore(encoder, zapcore.AddSync(&logs), zap.WarnLevel)))
+	server.SetSlowRequestThreshold(20 * time.Millisecond)
+	handler := server.LoggingMiddleware(testHandler)
+
+	req := httptest.NewRequest("GET", "/fast", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if logs.Len() != 0 {
+		t.Errorf("Fast request logged as slow: %s", logs.String())
+	}
+
+	req = httptest.NewRequest("GET", "/slow", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	req.RemoteAddr = "192.168.1.1:12345"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	for _, want := range []string{`"msg":"Slow request"`, `"remoteAddr":"192.168.1.1:12345"`, `"url":"/slow"`, `"range":"bytes=0-3"`, `"respSize":4`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("Slow request log lacks %s: %s", want, logs.String())
+		}
+	}
+
+	logs.Reset()
+	server.SetSlowRequestThreshold(0)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if logs.Len() != 0 {
+		t.Errorf("Slow request logged with the threshold disabled: %s", logs.String())
+	}
+}
@@ -1,24 +1,68 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/easzlab/ezft/pkg/e2e"
+	"github.com/easzlab/ezft/pkg/ftp"
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/p2p"
+	"github.com/easzlab/ezft/pkg/quicproto"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/rsyncd"
+	"github.com/easzlab/ezft/pkg/sockopt"
 	"go.uber.org/zap"
 )
 
+// Default credentials of AuthMiddleware, override them with SetAuth
+const (
+	defaultUsername = "admin"
+	defaultPassword = "password"
+)
+
 // Server file download server
 type Server struct {
-	root   string // File root directory
-	port   int    // Service port
-	logger *zap.Logger
+	root          string // File root directory
+	port          int    // Service port
+	username      string // Basic auth username
+	password      string // Basic auth password
+	authEnabled   bool   // Whether to require basic auth for all requests
+	uploadEnabled bool   // Whether to accept file uploads
+	quicEnabled   bool   // Whether to serve the native protocol over QUIC
+	compression   bool   // Whether to compress chunks for clients asking for it
+	quic          *quicproto.Server
+	tracker       *p2p.Tracker // Tracker of peer-assisted downloads, nil unless enabled
+	relay         *p2p.Relay   // Relay of the peers of the tracker others cannot reach
+	ftpConfig     *ftp.Config  // FTP front end, nil unless enabled
+	ftp           *ftp.Server
+	adminAddr     string         // Address of the pprof and expvar endpoints, empty unless enabled
+	accessLog     *AccessLog     // Log of the requests for log analyzers, nil for none
+	rsyncConfig   *rsyncd.Config // rsync daemon, nil unless enabled
+	rsync         *rsyncd.Server
+	e2eKey        *e2e.Key           // Key encrypting file contents end to end, nil sends them as they are
+	limiter       *ratelimit.Limiter // Bandwidth limit of all downloads together, nil for none
+	slowRequest   time.Duration      // Requests taking longer are logged as slow, 0 disables it
+	socket        *sockopt.Options   // TCP options of the HTTP connections, nil for the system defaults
+	files         *fileCache         // Open descriptors of hot files, nil opens files per request
+	settingsMu    sync.RWMutex       // Guards the credentials, limiter and threshold, which change while serving
+	diffMu        sync.Mutex         // Serializes generating diffs
+	chunksMu      sync.Mutex
+	chunks        map[string]*manifest.Chunks // Chunk manifests by file version and chunk size
+	logger        *zap.Logger
 }
 
 // NewServer creates a new file server
 func NewServer(root string, port int) *Server {
 	return &Server{
-		root: root,
-		port: port,
+		root:     root,
+		port:     port,
+		username: defaultUsername,
+		password: defaultPassword,
 	}
 }
 
@@ -26,23 +70,324 @@ func (s *Server) SetLogger(logger *zap.Logger) {
 	s.logger = logger
 }
 
-// Start starts the server
-func (s *Server) Start() error {
-	fs := http.FileServer(http.Dir(s.root))
+// SetAuth requires basic auth with the given credentials for all requests,
+// an empty username serves them without. Like SetRateLimiter and
+// SetSlowRequestThreshold it may be called while serving, to reload the
+// configuration: requests in progress are not affected.
+func (s *Server) SetAuth(username, password string) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.username = username
+	s.password = password
+	s.authEnabled = username != ""
+	if s.quic != nil {
+		s.quic.SetAuth(username, password)
+	}
+	if s.rsync != nil {
+		s.rsync.SetAuth(username, password)
+	}
+}
+
+// SetUploadEnabled enables or disables file uploads (PUT) under the root directory
+func (s *Server) SetUploadEnabled(enabled bool) {
+	s.uploadEnabled = enabled
+}
+
+// SetTrackerEnabled enables or disables the tracker letting clients
+// downloading the same file exchange chunks with each other
+func (s *Server) SetTrackerEnabled(enabled bool) {
+	s.tracker, s.relay = nil, nil
+	if enabled {
+		s.tracker = p2p.NewTracker()
+		s.relay = p2p.NewRelay(s.tracker)
+	}
+}
+
+// SetE2EKey encrypts file contents with key before they leave the server,
+// nil disables the encryption. The native protocol over QUIC is not offered
+// then, as it sends file contents as they are.
+func (s *Server) SetE2EKey(key *e2e.Key) {
+	s.e2eKey = key
+}
+
+// SetCompressionEnabled offers ezft clients to compress the chunks of files
+// which are not compressed already
+func (s *Server) SetCompressionEnabled(enabled bool) {
+	s.compression = enabled
+}
+
+// SetRateLimiter limits the bandwidth of all file downloads together, over
+// HTTP, FTP, QUIC and rsync, nil removes the limit
+func (s *Server) SetRateLimiter(limiter *ratelimit.Limiter) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.limiter = limiter
+	if s.quic != nil {
+		s.quic.SetRateLimiter(limiter)
+	}
+	if s.rsync != nil {
+		s.rsync.SetRateLimiter(limiter)
+	}
+}
+
+// SetSlowRequestThreshold logs requests taking longer than threshold at warn
+// level with their range, bytes and client, 0 disables it
+func (s *Server) SetSlowRequestThreshold(threshold time.Duration) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.slowRequest = threshold
+	if s.quic != nil {
+		s.quic.SetSlowRequestThreshold(threshold)
+	}
+}
+
+// credentials returns the credentials requests need, whether any
+func (s *Server) credentials() (username, password string, enabled bool) {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.username, s.password, s.authEnabled
+}
+
+// rateLimiter returns the bandwidth limit of the downloads starting, nil for
+// none
+func (s *Server) rateLimiter() *ratelimit.Limiter {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.limiter
+}
+
+// slowThreshold returns the duration of the requests logged as slow
+func (s *Server) slowThreshold() time.Duration {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.slowRequest
+}
+
+// quicServer returns the server of the native protocol, nil until it listens
+func (s *Server) quicServer() *quicproto.Server {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.quic
+}
+
+// SetSocketOptions sets the TCP options of the HTTP connections, nil keeps
+// the system defaults
+func (s *Server) SetSocketOptions(options *sockopt.Options) {
+	s.socket = options
+}
+
+// SetOpenFileCache keeps the descriptors of up to size recently served files
+// open and shares them between requests, 0 opens files per request. Cached
+// files are read with pread instead of sendfile.
+func (s *Server) SetOpenFileCache(size int) {
+	if s.files != nil {
+		s.files.Close()
+		s.files = nil
+	}
+	if size > 0 {
+		s.files = newFileCache(s.root, size)
+	}
+}
+
+// SetAccessLog also writes the requests over HTTP and FTP to log, nil
+// disables it
+func (s *Server) SetAccessLog(log *AccessLog) {
+	s.accessLog = log
+}
+
+// SetQUICEnabled serves the native ezft protocol over QUIC next to HTTP, on
+// the UDP port of the same number
+func (s *Server) SetQUICEnabled(enabled bool) {
+	s.quicEnabled = enabled
+}
+
+// ListenQUIC serves the native ezft protocol over QUIC on the UDP address
+// addr, clients discover it through the quic endpoint
+func (s *Server) ListenQUIC(addr string) error {
+	q, err := quicproto.NewServer(s.root)
+	if err != nil {
+		return err
+	}
+	q.SetLogger(s.logger)
+	if err := q.Listen(addr); err != nil {
+		return err
+	}
+	// Settings changed from now on reach q as well
+	s.settingsMu.Lock()
+	q.SetRateLimiter(s.limiter)
+	q.SetSlowRequestThreshold(s.slowRequest)
+	if s.authEnabled {
+		q.SetAuth(s.username, s.password)
+	}
+	s.quic = q
+	s.settingsMu.Unlock()
+	go q.Serve()
+	return nil
+}
+
+// SetFTPConfig serves the root over FTP next to HTTP, nil disables it
+func (s *Server) SetFTPConfig(config *ftp.Config) {
+	s.ftpConfig = config
+}
+
+// ListenFTP serves the root over FTP with the configured options. FTP
+// commands go through the HTTP handler, so they are authenticated, restricted
+// and logged like HTTP requests.
+func (s *Server) ListenFTP() error {
+	f := ftp.NewServer(s.Handler(), *s.ftpConfig)
+	f.SetLogger(s.logger)
+	if err := f.Listen(); err != nil {
+		return err
+	}
+	s.ftp = f
+	go f.Serve()
+	return nil
+}
+
+// SetRsyncConfig serves directories to rsync clients over the rsync daemon
+// protocol next to HTTP, nil disables it
+func (s *Server) SetRsyncConfig(config *rsyncd.Config) {
+	s.rsyncConfig = config
+}
+
+// ListenRsync serves the modules of the rsync configuration, read-only and
+// with the credentials and bandwidth limit of HTTP
+func (s *Server) ListenRsync() error {
+	r := rsyncd.NewServer(*s.rsyncConfig)
+	r.SetLogger(s.logger)
+	if err := r.Listen(); err != nil {
+		return err
+	}
+	// Settings changed from now on reach r as well
+	s.settingsMu.Lock()
+	r.SetRateLimiter(s.limiter)
+	if s.authEnabled {
+		r.SetAuth(s.username, s.password)
+	}
+	s.rsync = r
+	s.settingsMu.Unlock()
+	go r.Serve()
+	return nil
+}
 
-	handler := s.LoggingMiddleware(fs)
+// Handler returns the http handler serving files and the ezft API
+func (s *Server) Handler() http.Handler {
+	var root http.FileSystem = http.Dir(s.root)
+	if s.files != nil {
+		root = s.files
+	}
+	fs := s.DirectoryMiddleware(s.RangesMiddleware(http.FileServer(root)))
 
 	// Create a new ServeMux to avoid conflicts with global DefaultServeMux
 	mux := http.NewServeMux()
-	mux.Handle("/", handler)
+	// Files are only read, other methods are left to the upload endpoints.
+	// Chunks are compressed before they are encrypted.
+	if s.compression {
+		fs = s.CompressMiddleware(fs)
+	}
+	if s.e2eKey != nil {
+		fs = s.EncryptMiddleware(fs)
+	}
+	fs = s.LimitMiddleware(fs)
+	mux.Handle("GET /", fs)
+	s.registerAPI(mux)
 
+	// Auth may be turned on or off while serving
+	auth := s.AuthMiddleware(mux)
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, enabled := s.credentials(); enabled {
+			auth.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+	if s.relay != nil {
+		// Peers authenticate to the relay with the key of their swarm, which
+		// the tracker only hands out to authenticated clients
+		outer := http.NewServeMux()
+		outer.Handle("/", handler)
+		s.registerRelay(outer)
+		handler = outer
+	}
+	return s.LoggingMiddleware(handler)
+}
+
+// Start starts the server
+func (s *Server) Start() error {
 	addr := fmt.Sprintf(":%d", s.port)
 	fmt.Printf("Serving file server at %s, root: %s\n", addr, s.root)
-	s.logger.Info("",
-		zap.String("message", "Serving file server"),
+	_, _, authEnabled := s.credentials()
+	bwlimit := "off"
+	if limiter := s.rateLimiter(); limiter != nil {
+		bwlimit = limiter.Schedule().String()
+	}
+	s.logger.Info("Serving file server",
 		zap.String("root", s.root),
 		zap.String("addr", addr),
+		zap.Bool("upload", s.uploadEnabled),
+		zap.Bool("auth", authEnabled),
+		zap.Bool("quic", s.quicEnabled),
+		zap.Bool("tracker", s.tracker != nil),
+		zap.Bool("ftp", s.ftpConfig != nil),
+		zap.Bool("rsync", s.rsyncConfig != nil),
+		zap.Bool("e2e", s.e2eKey != nil),
+		zap.Bool("compression", s.compression),
+		zap.String("bwlimit", bwlimit),
+		zap.Duration("slowRequest", s.slowRequest),
+		zap.String("admin", s.adminAddr),
 	)
 
-	return http.ListenAndServe(addr, mux)
+	// rsync sends file contents as they are
+	if s.rsyncConfig != nil && s.e2eKey != nil {
+		return errors.New("rsync cannot be served with end-to-end encryption")
+	}
+
+	if s.quicEnabled && s.e2eKey == nil {
+		if err := s.ListenQUIC(addr); err != nil {
+			return err
+		}
+	}
+
+	if s.ftpConfig != nil {
+		if err := s.ListenFTP(); err != nil {
+			return err
+		}
+		fmt.Printf("Serving FTP at %s\n", s.ftp.Addr())
+	}
+
+	if s.adminAddr != "" {
+		addr, err := s.ListenAdmin()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Serving pprof and expvar at http://%s/debug/\n", addr)
+	}
+
+	if s.rsyncConfig != nil {
+		if err := s.ListenRsync(); err != nil {
+			return err
+		}
+		fmt.Printf("Serving rsync at %s\n", s.rsync.Addr())
+	}
+
+	srv := &http.Server{Addr: addr, Handler: s.Handler(), Protocols: Protocols()}
+	if s.socket == nil {
+		return srv.ListenAndServe()
+	}
+	lis, err := s.socket.Listen(context.Background(), addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(lis)
+}
+
+// Protocols returns the protocols the server speaks: HTTP/1.1 and HTTP/2, in
+// cleartext as well so clients can multiplex all chunks of a download over
+// one connection without TLS
+func Protocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return protocols
 }
@@ -5,11 +5,13 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/easzlab/ezft/pkg/ratelimit"
 	"go.uber.org/zap"
 )
 
@@ -114,6 +116,19 @@ func TestServer_Start(t *testing.T) {
 		t.Errorf("Expected status 200 for directory listing, got %d", resp.StatusCode)
 	}
 
+	// Test cleartext HTTP/2
+	h2c := &http.Transport{Protocols: new(http.Protocols)}
+	h2c.Protocols.SetUnencryptedHTTP2(true)
+	resp, err = (&http.Client{Transport: h2c}).Get(baseURL + "/test.txt")
+	if err != nil {
+		t.Fatalf("Failed to make HTTP/2 request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 over HTTP/2, got %d over %s", resp.StatusCode, resp.Proto)
+	}
+
 	// Check if server stops (with timeout)
 	select {
 	case err := <-serverErr:
@@ -301,3 +316,46 @@ func findAvailablePort(t *testing.T) int {
 
 	return listener.Addr().(*net.TCPAddr).Port
 }
+
+func TestServer_ReloadSettings(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(root, 0)
+	server.SetLogger(zap.NewNop())
+	handler := server.Handler()
+
+	get := func(username, password string) int {
+		req := httptest.NewRequest("GET", "/file.txt", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Settings changed after the handler is built apply to the next requests
+	server.SetAuth("alice", "secret")
+	if code := get("", ""); code != http.StatusUnauthorized {
+		t.Errorf("status without credentials = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := get("alice", "secret"); code != http.StatusOK {
+		t.Errorf("status with credentials = %d, want %d", code, http.StatusOK)
+	}
+	server.SetAuth("alice", "changed")
+	if code := get("alice", "secret"); code != http.StatusForbidden {
+		t.Errorf("status with old credentials = %d, want %d", code, http.StatusForbidden)
+	}
+	server.SetAuth("", "")
+	if code := get("", ""); code != http.StatusOK {
+		t.Errorf("status with auth disabled = %d, want %d", code, http.StatusOK)
+	}
+
+	server.SetRateLimiter(ratelimit.NewLimiter(ratelimit.NewSchedule(1 << 20)))
+	server.SetRateLimiter(nil)
+	if code := get("", ""); code != http.StatusOK {
+		t.Errorf("status with limit removed = %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,396 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/delta"
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// MtimeHeader carries the modification time (RFC 3339) to apply to an uploaded file
+	MtimeHeader = "X-Ezft-Mtime"
+	// ModeHeader carries the permission bits (octal) to apply to an uploaded file
+	ModeHeader = "X-Ezft-Mode"
+	// LinkHeader turns an upload into the creation of a symbolic link to the header value
+	LinkHeader = "X-Ezft-Link"
+)
+
+// errIncomplete is returned when fewer bytes than announced are received
+var errIncomplete = errors.New("incomplete upload")
+
+// handleUpload stores the request body as the file addressed by the URL path,
+// the file is replaced atomically once the whole body is received
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, APIPrefix) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	target, existed, ok := s.uploadTarget(w, r.URL.Path)
+	if !ok {
+		return
+	}
+
+	if link := r.Header.Get(LinkHeader); link != "" {
+		s.storeLink(w, r, target, existed, link)
+		return
+	}
+
+	mtime, ok := requestMtime(w, r)
+	if !ok {
+		return
+	}
+	mode, ok := requestMode(w, r)
+	if !ok {
+		return
+	}
+
+	written, err := storeFile(target, r.Body, r.ContentLength, mtime, mode)
+	if errors.Is(err, errIncomplete) {
+		http.Error(w, "Incomplete upload", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.uploadError(w, r, "failed to store file", err)
+		return
+	}
+
+	s.logger.Info("file uploaded",
+		zap.String("path", r.URL.Path),
+		zap.Int64("size", written),
+	)
+	writeStored(w, existed)
+}
+
+// handleCopy copies the file given by the from query parameter to the path,
+// letting clients replay renames without uploading the content again. The
+// copy keeps the source mtime and mode unless the mtime or mode header is given
+func (s *Server) handleCopy(w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		http.Error(w, "Missing from parameter", http.StatusBadRequest)
+		return
+	}
+	target, existed, ok := s.uploadTarget(w, r.PathValue("path"))
+	if !ok {
+		return
+	}
+	if !uploadPath(from) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	src, info, ok := s.openRegularFile(w, from)
+	if !ok {
+		return
+	}
+	defer src.Close()
+
+	mtime, ok := requestMtime(w, r)
+	if !ok {
+		return
+	}
+	if mtime.IsZero() {
+		mtime = info.ModTime()
+	}
+	mode, ok := requestMode(w, r)
+	if !ok {
+		return
+	}
+	if mode == 0 {
+		mode = info.Mode().Perm()
+	}
+
+	written, err := storeFile(target, src, info.Size(), mtime, mode)
+	if err != nil {
+		s.uploadError(w, r, "failed to copy file", err)
+		return
+	}
+
+	s.logger.Info("file copied",
+		zap.String("from", from),
+		zap.String("path", r.PathValue("path")),
+		zap.Int64("size", written),
+	)
+	writeStored(w, existed)
+}
+
+// handlePatch rebuilds the file from its current content and a delta sent by
+// the client, computed against the signature of the file. The file is
+// replaced atomically once the delta is applied and verified
+func (s *Server) handlePatch(w http.ResponseWriter, r *http.Request) {
+	blockSize, err := strconv.Atoi(r.URL.Query().Get("block-size"))
+	if err != nil || blockSize < delta.MinBlockSize || blockSize > delta.MaxBlockSize {
+		http.Error(w, "Invalid block size", http.StatusBadRequest)
+		return
+	}
+	target, existed, ok := s.uploadTarget(w, r.PathValue("path"))
+	if !ok {
+		return
+	}
+	if !existed {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	mtime, ok := requestMtime(w, r)
+	if !ok {
+		return
+	}
+	mode, ok := requestMode(w, r)
+	if !ok {
+		return
+	}
+
+	base, _, ok := s.openRegularFile(w, r.PathValue("path"))
+	if !ok {
+		return
+	}
+	defer base.Close()
+
+	pr, pw := io.Pipe()
+	applied := make(chan error, 1)
+	go func() {
+		_, err := delta.Apply(base, blockSize, r.Body, pw)
+		pw.CloseWithError(err)
+		applied <- err
+	}()
+
+	written, err := storeFile(target, pr, -1, mtime, mode)
+	// Unblock the delta writer if storing stopped early
+	pr.CloseWithError(err)
+	applyErr := <-applied
+
+	if applyErr != nil && errors.Is(err, applyErr) {
+		s.logger.Warn("failed to apply delta",
+			zap.String("path", r.PathValue("path")),
+			zap.Error(applyErr),
+		)
+		http.Error(w, "Invalid delta", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.uploadError(w, r, "failed to store file", err)
+		return
+	}
+
+	s.logger.Info("file patched",
+		zap.String("path", r.PathValue("path")),
+		zap.Int64("size", written),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDelete removes the file addressed by the URL path, or moves it to the
+// path given by the backup query parameter
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, APIPrefix) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	target, existed, ok := s.uploadTarget(w, r.URL.Path)
+	if !ok {
+		return
+	}
+	if !existed {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var err error
+	if backupPath := r.URL.Query().Get("backup"); backupPath != "" {
+		backup, _, ok := s.uploadTarget(w, backupPath)
+		if !ok {
+			return
+		}
+		if err = os.MkdirAll(filepath.Dir(backup), 0755); err == nil {
+			err = os.Rename(target, backup)
+		}
+	} else {
+		err = os.Remove(target)
+	}
+	if err != nil {
+		s.uploadError(w, r, "failed to delete file", err)
+		return
+	}
+	utils.RemoveEmptyDirs(s.root, filepath.Dir(target))
+
+	s.logger.Info("file deleted",
+		zap.String("path", r.URL.Path),
+		zap.String("backup", r.URL.Query().Get("backup")),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestMtime parses the mtime header, the zero time is returned if it is absent
+func requestMtime(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	v := r.Header.Get(MtimeHeader)
+	if v == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		http.Error(w, "Invalid "+MtimeHeader+" header", http.StatusBadRequest)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// requestMode parses the mode header, 0 is returned if it is absent
+func requestMode(w http.ResponseWriter, r *http.Request) (fs.FileMode, bool) {
+	v := r.Header.Get(ModeHeader)
+	if v == "" {
+		return 0, true
+	}
+	mode, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || mode == 0 || mode > uint64(fs.ModePerm) {
+		http.Error(w, "Invalid "+ModeHeader+" header", http.StatusBadRequest)
+		return 0, false
+	}
+	return fs.FileMode(mode), true
+}
+
+// storeLink replaces target by a symbolic link, only relative links staying
+// inside the served directory, also through the links already in it, are
+// accepted
+func (s *Server) storeLink(w http.ResponseWriter, r *http.Request, target string, existed bool, link string) {
+	entry := manifest.Entry{Path: strings.TrimPrefix(path.Clean(r.URL.Path), "/"), Link: link}
+	inRoot, err := entry.LinkInRoot(s.root)
+	if err != nil || !inRoot {
+		http.Error(w, "Link target outside of the served directory", http.StatusForbidden)
+		return
+	}
+
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		s.uploadError(w, r, "failed to create link", err)
+		return
+	}
+	tmp := filepath.Join(dir, fmt.Sprintf(".ezft-link-%d", time.Now().UnixNano()))
+	if err := os.Symlink(filepath.FromSlash(link), tmp); err != nil {
+		s.uploadError(w, r, "failed to create link", err)
+		return
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		s.uploadError(w, r, "failed to create link", err)
+		return
+	}
+
+	s.logger.Info("link created",
+		zap.String("path", r.URL.Path),
+		zap.String("target", link),
+	)
+	writeStored(w, existed)
+}
+
+// uploadTarget resolves the file path written by an upload, writing an error
+// response if it may not be written
+func (s *Server) uploadTarget(w http.ResponseWriter, p string) (string, bool, bool) {
+	if !uploadPath(p) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false, false
+	}
+	target, err := s.resolvePath(p)
+	if err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false, false
+	}
+
+	// Links are replaced rather than followed
+	info, err := os.Lstat(target)
+	if err == nil && info.IsDir() {
+		http.Error(w, "Target is a directory", http.StatusConflict)
+		return "", false, false
+	}
+	return target, err == nil, true
+}
+
+// uploadPath reports whether the upload endpoints may write, copy or move the
+// file at p, which must not be a directory or belong to ezft bookkeeping
+func uploadPath(p string) bool {
+	if p == "" || strings.HasSuffix(p, "/") {
+		return false
+	}
+	for _, name := range strings.Split(path.Clean("/"+p), "/") {
+		if manifest.IsInternal(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// storeFile writes r into a temporary file next to target and renames it over
+// target, size is checked unless negative, mtime applied unless zero and mode
+// applied unless 0, in which case the file is made world readable
+func storeFile(target string, r io.Reader, size int64, mtime time.Time, mode fs.FileMode) (int64, error) {
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".ezft-upload-*")
+	if err != nil {
+		return 0, err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	// Local files are copied in the kernel
+	var written int64
+	if f, ok := r.(*os.File); ok {
+		written, err = utils.CopyFile(tmp, f)
+	} else {
+		written, err = io.Copy(tmp, r)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, err
+	}
+	if size >= 0 && written != size {
+		return written, errIncomplete
+	}
+
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return written, err
+	}
+	if !mtime.IsZero() {
+		if err := os.Chtimes(tmpPath, mtime, mtime); err != nil {
+			return written, err
+		}
+	}
+	return written, os.Rename(tmpPath, target)
+}
+
+// writeStored answers a successful write, 201 for new files and 204 for replaced ones
+func writeStored(w http.ResponseWriter, existed bool) {
+	if existed {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) uploadError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	s.logger.Error(msg,
+		zap.String("path", r.URL.Path),
+		zap.Error(err),
+	)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
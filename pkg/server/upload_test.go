@@ -0,0 +1,374 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/delta"
+	"go.uber.org/zap"
+)
+
+func newUploadServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	server := NewServer(root, 0)
+	server.SetLogger(zap.NewNop())
+	server.SetUploadEnabled(true)
+
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return ts, root
+}
+
+func put(t *testing.T, url string, body []byte, header map[string]string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestHandleUpload(t *testing.T) {
+	ts, root := newUploadServer(t)
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	resp := put(t, ts.URL+"/dir/new.txt", []byte("hello"), map[string]string{
+		MtimeHeader: mtime.Format(time.RFC3339Nano),
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+
+	path := filepath.Join(root, "dir", "new.txt")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read uploaded file: %v", err)
+	}
+	if string(content) != "hello" {
+		t.Errorf("Expected content %q, got %q", "hello", content)
+	}
+	info, _ := os.Stat(path)
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected mtime %v, got %v", mtime, info.ModTime())
+	}
+
+	// Replace existing file
+	resp = put(t, ts.URL+"/dir/new.txt", []byte("replaced"), nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", resp.StatusCode)
+	}
+	content, _ = os.ReadFile(path)
+	if string(content) != "replaced" {
+		t.Errorf("Expected content %q, got %q", "replaced", content)
+	}
+
+	// No temporary files left behind
+	entries, _ := os.ReadDir(filepath.Join(root, "dir"))
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 file in upload directory, got %d", len(entries))
+	}
+}
+
+func TestHandleUploadRejected(t *testing.T) {
+	ts, root := newUploadServer(t)
+	if err := os.MkdirAll(filepath.Join(root, "existing"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+		want   int
+	}{
+		{"directory_target", "/existing", nil, http.StatusConflict},
+		{"trailing_slash", "/dir/", nil, http.StatusForbidden},
+		{"api_prefix", APIPrefix + "file", nil, http.StatusForbidden},
+		{"internal_name", "/.ezft-upload-x", nil, http.StatusForbidden},
+		{"internal_dir", "/.ezft/sync-state.json", nil, http.StatusForbidden},
+		{"invalid_mtime", "/file.txt", map[string]string{MtimeHeader: "yesterday"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := put(t, ts.URL+tt.path, []byte("data"), tt.header)
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandleUploadModeAndLink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX modes and symbolic links are not supported")
+	}
+	ts, root := newUploadServer(t)
+
+	resp := put(t, ts.URL+"/bin/run.sh", []byte("#!/bin/sh"), map[string]string{ModeHeader: "755"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+	info, _ := os.Stat(filepath.Join(root, "bin", "run.sh"))
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("Expected mode 0755, got %o", info.Mode().Perm())
+	}
+
+	resp = put(t, ts.URL+"/bin/run", nil, map[string]string{LinkHeader: "run.sh"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "bin", "run")); err != nil || target != "run.sh" {
+		t.Errorf("Readlink() = %q, %v, want run.sh", target, err)
+	}
+
+	// Links are replaced, not written through
+	resp = put(t, ts.URL+"/bin/run", []byte("file"), nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", resp.StatusCode)
+	}
+	if content, _ := os.ReadFile(filepath.Join(root, "bin", "run.sh")); string(content) != "#!/bin/sh" {
+		t.Errorf("Link target was overwritten: %q", content)
+	}
+
+	// Links chained through a link to the root must not lead out of it
+	resp = put(t, ts.URL+"/d", nil, map[string]string{LinkHeader: "."})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+	resp = put(t, ts.URL+"/d/e", nil, map[string]string{LinkHeader: ".."})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a chained link, got %d", resp.StatusCode)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "e")); !os.IsNotExist(err) {
+		t.Errorf("Chained link created: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+		status int
+	}{
+		{"absolute link", "/abs", map[string]string{LinkHeader: "/etc/passwd"}, http.StatusForbidden},
+		{"link outside root", "/bin/up", map[string]string{LinkHeader: "../../etc/passwd"}, http.StatusForbidden},
+		{"invalid mode", "/bad.txt", map[string]string{ModeHeader: "rwx"}, http.StatusBadRequest},
+		{"mode out of range", "/bad.txt", map[string]string{ModeHeader: "4755"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := put(t, ts.URL+tt.path, []byte("x"), tt.header)
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandleUploadDisabled(t *testing.T) {
+	ts := newTestAPIServer(t, nil)
+
+	resp := put(t, ts.URL+"/file.txt", []byte("data"), nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Upload should be rejected when disabled, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandleCopy(t *testing.T) {
+	ts, root := newUploadServer(t)
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	src := filepath.Join(root, "a", "src.txt")
+	if err := os.MkdirAll(filepath.Dir(src), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(src, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	os.Chtimes(src, mtime, mtime)
+
+	tests := []struct {
+		name string
+		url  string
+		want int
+	}{
+		{"copy", APIPrefix + "copy/b/dst.txt?from=a/src.txt", http.StatusCreated},
+		{"replace", APIPrefix + "copy/b/dst.txt?from=/a/src.txt", http.StatusNoContent},
+		{"missing_from", APIPrefix + "copy/b/dst.txt", http.StatusBadRequest},
+		{"source_not_found", APIPrefix + "copy/b/dst.txt?from=a/missing.txt", http.StatusNotFound},
+		{"internal_target", APIPrefix + "copy/.ezft/x?from=a/src.txt", http.StatusForbidden},
+		{"internal_source", APIPrefix + "copy/b/dst.txt?from=.ezft/sync-state.json", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(ts.URL+tt.url, "", nil)
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+
+	dst := filepath.Join(root, "b", "dst.txt")
+	content, err := os.ReadFile(dst)
+	if err != nil || string(content) != "content" {
+		t.Errorf("Expected copied content, got %q (%v)", content, err)
+	}
+	if info, err := os.Stat(dst); err == nil && !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected mtime %v, got %v", mtime, info.ModTime())
+	}
+}
+
+func TestHandlePatch(t *testing.T) {
+	ts, root := newUploadServer(t)
+	old := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	target := filepath.Join(root, "file.bin")
+	if err := os.WriteFile(target, old, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	updated := append([]byte("prefix"), old...)
+	sig, err := delta.ComputeSignature(bytes.NewReader(old), delta.MinBlockSize)
+	if err != nil {
+		t.Fatalf("ComputeSignature() error = %v", err)
+	}
+	var patch bytes.Buffer
+	if _, err := delta.ComputeDelta(sig, bytes.NewReader(updated), &patch); err != nil {
+		t.Fatalf("ComputeDelta() error = %v", err)
+	}
+
+	post := func(url string, body []byte) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+		req.Header.Set(MtimeHeader, time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC).Format(time.RFC3339Nano))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	url := ts.URL + APIPrefix + "patch/file.bin?block-size=" + strconv.Itoa(delta.MinBlockSize)
+	if code := post(url, []byte("garbage")); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid delta, got %d", code)
+	}
+	if content, _ := os.ReadFile(target); !bytes.Equal(content, old) {
+		t.Error("Invalid delta must leave the file unchanged")
+	}
+	if code := post(ts.URL+APIPrefix+"patch/missing.bin?block-size=2048", patch.Bytes()); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for missing file, got %d", code)
+	}
+	if code := post(ts.URL+APIPrefix+"patch/file.bin", patch.Bytes()); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for missing block size, got %d", code)
+	}
+
+	if code := post(url, patch.Bytes()); code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", code)
+	}
+	if content, _ := os.ReadFile(target); !bytes.Equal(content, updated) {
+		t.Error("Patched content mismatch")
+	}
+	if info, _ := os.Stat(target); !info.ModTime().Equal(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)) {
+		t.Errorf("Expected mtime to be applied, got %v", info.ModTime())
+	}
+	entries, _ := os.ReadDir(root)
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files, got %d entries", len(entries))
+	}
+}
+
+func TestHandleDelete(t *testing.T) {
+	ts, root := newUploadServer(t)
+	for _, name := range []string{"dir/a.txt", "dir/sub/b.txt"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	del := func(url string) int {
+		t.Helper()
+		req, _ := http.NewRequest("DELETE", ts.URL+url, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := del("/dir/sub/b.txt"); code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(root, "dir", "sub")); !os.IsNotExist(err) {
+		t.Error("Empty parent directory should be removed")
+	}
+
+	if code := del("/dir/a.txt?backup=/.ezft/dir/a.txt"); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a backup into ezft files, got %d", code)
+	}
+	if code := del("/dir/a.txt?backup=/trash/dir/a.txt"); code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", code)
+	}
+	if content, err := os.ReadFile(filepath.Join(root, "trash", "dir", "a.txt")); err != nil || string(content) != "dir/a.txt" {
+		t.Errorf("Expected file moved to backup directory, got %q (%v)", content, err)
+	}
+
+	if code := del("/dir/a.txt"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", code)
+	}
+	if code := del("/.ezft/sync-state.json"); code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", code)
+	}
+}
+
+func TestHandlerWithAuth(t *testing.T) {
+	server := NewServer(t.TempDir(), 0)
+	server.SetLogger(zap.NewNop())
+	server.SetAuth("user", "secret")
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/", nil)
+	req.SetBasicAuth("user", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,314 @@
+// Package share serves a single file for a short while, under a URL with a
+// random token so only those given the URL find it. The share stops after a
+// number of downloads or once its time to live elapsed.
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Config share configuration
+type Config struct {
+	Path         string        // File shared
+	Addr         string        // Address listened on, any free port of all interfaces if empty
+	MaxDownloads int           // Downloads after which the share stops, no limit if 0
+	TTL          time.Duration // Time after which the share stops, no limit if 0
+}
+
+// Report the outcome of a share
+type Report struct {
+	Downloads int   // Complete downloads of the file
+	Bytes     int64 // Bytes served, retried and partial downloads included
+	Duration  time.Duration
+}
+
+// Share serves a file under a URL with a random token
+type Share struct {
+	config *Config
+	token  string
+	name   string
+	file   *os.File
+	info   os.FileInfo
+	lis    net.Listener
+	logger *zap.Logger
+
+	mu        sync.Mutex
+	received  map[string]coverage // Bytes received by client host since its last complete download
+	downloads int
+	bytes     int64
+	full      chan struct{} // Closed once MaxDownloads were downloaded
+
+	onDownload func(host string, n int) // Called on every complete download
+}
+
+// NewShare creates a share with config
+func NewShare(config *Config) *Share {
+	return &Share{
+		config:   config,
+		name:     filepath.Base(config.Path),
+		received: make(map[string]coverage),
+		full:     make(chan struct{}),
+		logger:   zap.NewNop(),
+	}
+}
+
+func (s *Share) SetLogger(logger *zap.Logger) {
+	s.logger = logger
+}
+
+// OnDownload sets a function called with the client host and the number of
+// downloads so far on every complete download
+func (s *Share) OnDownload(f func(host string, n int)) {
+	s.onDownload = f
+}
+
+// Listen opens the file and listens on the address of the share
+func (s *Share) Listen() error {
+	file, err := os.Open(s.config.Path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return fmt.Errorf("%s is not a regular file", s.config.Path)
+	}
+	addr := s.config.Addr
+	if addr == "" {
+		addr = ":0"
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	token := make([]byte, 16)
+	rand.Read(token)
+	s.token = base64.RawURLEncoding.EncodeToString(token)
+	s.file, s.info, s.lis = file, info, lis
+	return nil
+}
+
+// Size returns the size of the file shared
+func (s *Share) Size() int64 {
+	return s.info.Size()
+}
+
+// URLs returns the URLs of the file, one per address of the host when
+// listening on all interfaces
+func (s *Share) URLs() []string {
+	addr := s.lis.Addr().(*net.TCPAddr)
+	hosts := []string{addr.IP.String()}
+	if addr.IP.IsUnspecified() {
+		hosts = hostAddrs()
+	}
+	path := "/" + s.token + "/" + url.PathEscape(s.name)
+	urls := make([]string, len(hosts))
+	for i, host := range hosts {
+		urls[i] = "http://" + net.JoinHostPort(host, strconv.Itoa(addr.Port)) + path
+	}
+	return urls
+}
+
+// hostAddrs returns the IPv4 addresses of the interfaces that are up, those
+// of other hosts first, loopback if there is none
+func hostAddrs() []string {
+	var hosts []string
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+			hosts = append(hosts, ipNet.IP.String())
+		}
+	}
+	return append(hosts, "127.0.0.1")
+}
+
+// Serve serves the file until MaxDownloads complete downloads, the time to
+// live elapsed or ctx is done. Downloads in progress when the share stops on
+// its own are served to the end.
+func (s *Share) Serve(ctx context.Context) (*Report, error) {
+	start := time.Now()
+	defer s.file.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{token}/{name}", s.handleFile)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(s.lis) }()
+
+	var expired <-chan time.Time
+	if s.config.TTL > 0 {
+		timer := time.NewTimer(s.config.TTL)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		srv.Close()
+		err = ctx.Err()
+	case err = <-served:
+	case <-expired:
+		s.logger.Info("share expired")
+		srv.Shutdown(ctx)
+	case <-s.full:
+		s.logger.Info("all downloads completed")
+		srv.Shutdown(ctx)
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := &Report{Downloads: s.downloads, Bytes: s.bytes, Duration: time.Since(start)}
+	s.logger.Info("share stopped",
+		zap.Int("downloads", report.Downloads),
+		zap.Int64("bytes", report.Bytes),
+		zap.Duration("duration", report.Duration),
+	)
+	return report, err
+}
+
+func (s *Share) handleFile(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.PathValue("token")), []byte(s.token)) != 1 || r.PathValue("name") != s.name {
+		http.NotFound(w, r)
+		return
+	}
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	s.mu.Lock()
+	done := s.config.MaxDownloads > 0 && s.downloads >= s.config.MaxDownloads
+	s.mu.Unlock()
+	if done {
+		http.Error(w, "Share completed", http.StatusGone)
+		return
+	}
+
+	s.logger.Info("serving file",
+		zap.String("client", host),
+		zap.String("method", r.Method),
+		zap.String("range", r.Header.Get("Range")),
+	)
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, s.name, s.info.ModTime(), io.NewSectionReader(s.file, 0, s.info.Size()))
+	if r.Method == http.MethodGet {
+		s.record(host, cw)
+	}
+}
+
+// record accounts the bytes of a response to host, counting a download once
+// host received all bytes of the file
+func (s *Share) record(host string, cw *countingWriter) {
+	size := s.info.Size()
+	var start int64
+	switch cw.status {
+	case http.StatusOK:
+	case http.StatusPartialContent:
+		// Multipart responses to several ranges are not accounted
+		var end, total int64
+		if _, err := fmt.Sscanf(cw.Header().Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil {
+			return
+		}
+	default:
+		return
+	}
+
+	s.mu.Lock()
+	s.bytes += cw.n
+	received := s.received[host].add(start, start+cw.n)
+	if !received.covers(size) || (size == 0 && cw.status != http.StatusOK) {
+		s.received[host] = received
+		s.mu.Unlock()
+		return
+	}
+	delete(s.received, host)
+	s.downloads++
+	n := s.downloads
+	if n == s.config.MaxDownloads {
+		close(s.full)
+	}
+	s.mu.Unlock()
+
+	s.logger.Info("download completed",
+		zap.String("client", host),
+		zap.Int("downloads", n),
+	)
+	if s.onDownload != nil {
+		s.onDownload(host, n)
+	}
+}
+
+// countingWriter records the status and counts the bytes of a response body
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// span the bytes start to end, excluded, of the file
+type span struct {
+	start, end int64
+}
+
+// coverage the disjoint spans of the file received, sorted
+type coverage []span
+
+// add returns the coverage with the bytes start to end, excluded
+func (c coverage) add(start, end int64) coverage {
+	if start >= end {
+		return c
+	}
+	merged := make(coverage, 0, len(c)+1)
+	for _, sp := range c {
+		if sp.end < start || sp.start > end {
+			merged = append(merged, sp)
+			continue
+		}
+		start, end = min(start, sp.start), max(end, sp.end)
+	}
+	merged = append(merged, span{start, end})
+	sort.Slice(merged, func(i, j int) bool { return merged[i].start < merged[j].start })
+	return merged
+}
+
+// covers reports whether all size bytes of the file were received
+func (c coverage) covers(size int64) bool {
+	return size == 0 || (len(c) == 1 && c[0].start == 0 && c[0].end >= size)
+}
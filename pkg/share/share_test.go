@@ -0,0 +1,128 @@
+package share
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShare(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	path := filepath.Join(t.TempDir(), "release notes.txt")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewShare(&Config{Path: path, Addr: "127.0.0.1:0", MaxDownloads: 2})
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	urls := s.URLs()
+	if len(urls) != 1 || !strings.HasSuffix(urls[0], "/release%20notes.txt") {
+		t.Fatalf("URLs() = %v", urls)
+	}
+	fileURL := urls[0]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reported := make(chan *Report, 1)
+	go func() {
+		report, _ := s.Serve(ctx)
+		reported <- report
+	}()
+	downloads := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.downloads
+	}
+
+	get := func(u, rangeHeader string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, u, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// Only the URL with the token serves the file
+	wrongToken := strings.Replace(fileURL, "/"+s.token+"/", "/"+strings.Repeat("x", len(s.token))+"/", 1)
+	for _, u := range []string{wrongToken, strings.TrimSuffix(fileURL, "/release%20notes.txt") + "/other.txt"} {
+		if status, _ := get(u, ""); status != http.StatusNotFound {
+			t.Errorf("GET %s status = %d, want 404", u, status)
+		}
+	}
+
+	// A download in ranges counts once all were received, overlapping or not
+	for _, r := range []string{"bytes=0-4999", "bytes=2000-7999", "bytes=8000-9989"} {
+		if status, body := get(fileURL, r); status != http.StatusPartialContent || body == "" {
+			t.Fatalf("GET %s status = %d", r, status)
+		}
+		if n := downloads(); n != 0 {
+			t.Fatalf("%d downloads after %s", n, r)
+		}
+	}
+	if status, _ := get(fileURL, "bytes=9990-"); status != http.StatusPartialContent || downloads() != 1 {
+		t.Fatalf("%d downloads, want 1", downloads())
+	}
+	if status, body := get(fileURL, ""); status != http.StatusOK || body != content {
+		t.Fatalf("GET status = %d, %d bytes", status, len(body))
+	}
+
+	select {
+	case report := <-reported:
+		if report == nil || report.Downloads != 2 || report.Bytes != 23000 {
+			t.Errorf("Serve() = %+v", report)
+		}
+	case <-ctx.Done():
+		t.Fatal("Share did not stop after 2 downloads")
+	}
+	if _, err := http.Get(fileURL); err == nil {
+		t.Error("Share still served after stopping")
+	}
+}
+
+func TestShareTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewShare(&Config{Path: path, Addr: "127.0.0.1:0", TTL: 100 * time.Millisecond})
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	report, err := s.Serve(context.Background())
+	if err != nil || report.Downloads != 0 {
+		t.Fatalf("Serve() = %+v, %v", report, err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > 5*time.Second {
+		t.Errorf("Share stopped after %s", d)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	var c coverage
+	for _, sp := range []span{{50, 60}, {0, 10}, {10, 20}, {30, 40}, {35, 55}} {
+		c = c.add(sp.start, sp.end)
+	}
+	if got := fmt.Sprint(c); got != "[{0 20} {30 60}]" {
+		t.Errorf("coverage = %s", got)
+	}
+	if c.covers(60) {
+		t.Error("covers(60) with a hole")
+	}
+	if c = c.add(20, 30); !c.covers(60) || c.covers(61) {
+		t.Errorf("coverage = %v", c)
+	}
+}
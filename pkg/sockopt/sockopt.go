@@ -0,0 +1,104 @@
+// Package sockopt tunes the TCP sockets of clients and servers, for links
+// the system defaults do not suit such as 10GbE and satellite links
+package sockopt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Options socket options of TCP connections, the zero value keeps the
+// defaults of Go and of the system
+type Options struct {
+	Nagle       bool   // Whether to enable Nagle's algorithm, disabled by Go setting TCP_NODELAY
+	ReadBuffer  int    // Size of the receive buffer, SO_RCVBUF, 0 for the system default
+	WriteBuffer int    // Size of the send buffer, SO_SNDBUF, 0 for the system default
+	Congestion  string // TCP congestion control algorithm such as bbr or cubic, Linux only, empty for the system default
+}
+
+// Control sets the buffer sizes and congestion control of a socket before it
+// connects or listens, so that they apply to the window scaling negotiated.
+// It is meant for net.Dialer and net.ListenConfig, which ignore the sockets
+// of other networks than TCP.
+func (o *Options) Control(network, address string, c syscall.RawConn) error {
+	var err error
+	ctrlErr := c.Control(func(fd uintptr) {
+		if o.ReadBuffer > 0 {
+			if err = syscall.SetsockoptInt(sysFD(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.ReadBuffer); err != nil {
+				err = fmt.Errorf("failed to set the receive buffer size: %w", err)
+				return
+			}
+		}
+		if o.WriteBuffer > 0 {
+			if err = syscall.SetsockoptInt(sysFD(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.WriteBuffer); err != nil {
+				err = fmt.Errorf("failed to set the send buffer size: %w", err)
+				return
+			}
+		}
+		if o.Congestion != "" {
+			if err = setCongestion(fd, o.Congestion); err != nil {
+				err = fmt.Errorf("failed to set the congestion control %q: %w", o.Congestion, err)
+			}
+		}
+	})
+	if ctrlErr != nil {
+		return ctrlErr
+	}
+	return err
+}
+
+// Apply sets the options of a connection set once it is established,
+// TCP_NODELAY which Go enables on every TCP connection
+func (o *Options) Apply(conn net.Conn) error {
+	if tcp, ok := conn.(*net.TCPConn); ok && o.Nagle {
+		return tcp.SetNoDelay(false)
+	}
+	return nil
+}
+
+// Dialer returns the DialContext of d with the options set on its connections
+func (o *Options) Dialer(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.Control = o.Control
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := o.Apply(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// Listen listens on the TCP address addr with the options set on the
+// connections accepted
+func (o *Options) Listen(ctx context.Context, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: o.Control}
+	lis, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{Listener: lis, options: o}, nil
+}
+
+// listener applies options to the connections it accepts
+type listener struct {
+	net.Listener
+	options *Options
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if err := l.options.Apply(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,36 @@
+package sockopt
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// availableCongestion lists the congestion control algorithms of the kernel
+const availableCongestion = "/proc/sys/net/ipv4/tcp_available_congestion_control"
+
+func sysFD(fd uintptr) int {
+	return int(fd)
+}
+
+func setCongestion(fd uintptr, name string) error {
+	return unix.SetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION, name)
+}
+
+// CheckCongestion checks that the kernel offers the congestion control
+// algorithm name, modules of others may have to be loaded first
+func CheckCongestion(name string) error {
+	data, err := os.ReadFile(availableCongestion)
+	if err != nil {
+		// Checked when the sockets are created then
+		return nil
+	}
+	available := strings.Fields(string(data))
+	if !slices.Contains(available, name) {
+		return fmt.Errorf("unavailable congestion control %q, available: %s", name, strings.Join(available, ", "))
+	}
+	return nil
+}
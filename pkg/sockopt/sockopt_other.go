@@ -0,0 +1,17 @@
+//go:build !linux
+
+package sockopt
+
+import "errors"
+
+var errCongestionUnsupported = errors.New("selecting the congestion control is only supported on Linux")
+
+func setCongestion(fd uintptr, name string) error {
+	return errCongestionUnsupported
+}
+
+// CheckCongestion returns an error, the congestion control is only selected
+// on Linux
+func CheckCongestion(name string) error {
+	return errCongestionUnsupported
+}
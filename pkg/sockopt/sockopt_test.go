@@ -0,0 +1,54 @@
+package sockopt
+
+import (
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+)
+
+func TestListenAndDial(t *testing.T) {
+	o := &Options{Nagle: true, ReadBuffer: 256 * 1024, WriteBuffer: 256 * 1024}
+	lis, err := o.Listen(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("hello"))
+		conn.Close()
+	}()
+
+	conn, err := o.Dialer(&net.Dialer{})(context.Background(), "tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatalf("Dialer() error = %v", err)
+	}
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("ReadAll() = %q, %v", data, err)
+	}
+}
+
+func TestCongestion(t *testing.T) {
+	o := &Options{Congestion: "no-such-algorithm"}
+	if _, err := o.Listen(context.Background(), "127.0.0.1:0"); err == nil {
+		t.Error("Listen() with an unknown congestion control error = nil")
+	}
+	if err := CheckCongestion("no-such-algorithm"); err == nil {
+		t.Error("CheckCongestion() of an unknown congestion control error = nil")
+	}
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	// Every kernel offering the selection has cubic or reno
+	if CheckCongestion("cubic") != nil && CheckCongestion("reno") != nil {
+		t.Error("CheckCongestion() rejects both cubic and reno")
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !windows
+
+package sockopt
+
+func sysFD(fd uintptr) int {
+	return int(fd)
+}
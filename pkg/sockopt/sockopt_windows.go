@@ -0,0 +1,7 @@
+package sockopt
+
+import "syscall"
+
+func sysFD(fd uintptr) syscall.Handle {
+	return syscall.Handle(fd)
+}
@@ -0,0 +1,96 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+// cacheFile name of the file caching hashes of local files
+const cacheFile = "hash-cache.json"
+
+// hashEntry hash of a local file, valid as long as size and mtime are unchanged
+type hashEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"sha256"`
+}
+
+// hashCache sha256 of local files by path, kept across syncs so unchanged
+// files are not hashed again. It belongs to the local directory and is
+// shared by all remotes the directory is synced with
+type hashCache struct {
+	Files map[string]hashEntry `json:"files"`
+	dirty bool
+}
+
+func cachePath(localDir string) string {
+	return filepath.Join(localDir, stateDir, cacheFile)
+}
+
+// loadHashCache loads the hash cache of localDir, a missing or unreadable
+// cache is treated as empty since it can always be rebuilt
+func loadHashCache(localDir string) *hashCache {
+	cache := &hashCache{Files: map[string]hashEntry{}}
+
+	data, err := os.ReadFile(cachePath(localDir))
+	if err != nil {
+		return cache
+	}
+	var saved hashCache
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Files == nil {
+		return cache
+	}
+	return &saved
+}
+
+// hash returns the sha256 of a local file, hashing it only when the cached
+// entry does not match its size and mtime
+func (c *hashCache) hash(localDir string, e manifest.Entry) (string, error) {
+	if cached, ok := c.Files[e.Path]; ok && cached.Size == e.Size && cached.ModTime.Equal(e.ModTime) {
+		return cached.Hash, nil
+	}
+
+	h, err := utils.CalculateFileHash(filepath.Join(localDir, filepath.FromSlash(e.Path)), "sha256")
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", e.Path, err)
+	}
+	c.Files[e.Path] = hashEntry{Size: e.Size, ModTime: e.ModTime, Hash: h}
+	c.dirty = true
+	return h, nil
+}
+
+// prune drops entries of files no longer present locally
+func (c *hashCache) prune(local map[string]manifest.Entry) {
+	for p := range c.Files {
+		if _, ok := local[p]; !ok {
+			delete(c.Files, p)
+			c.dirty = true
+		}
+	}
+}
+
+// save writes the cache into the local state directory if it changed
+func (c *hashCache) save(localDir string) error {
+	if !c.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(localDir, stateDir), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to serialize hash cache: %w", err)
+	}
+	if err := os.WriteFile(cachePath(localDir), data, 0644); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,138 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+)
+
+// ConflictStrategy how to resolve files changed on both sides of a bidirectional sync
+type ConflictStrategy string
+
+const (
+	NewerWins ConflictStrategy = "newer-wins" // The most recently modified copy replaces the other
+	KeepBoth  ConflictStrategy = "keep-both"  // The local copy is renamed and both versions are kept on both sides
+	Abort     ConflictStrategy = "abort"      // Nothing is transferred when any conflict is found
+)
+
+// Conflict resolutions recorded in the report
+const (
+	ResolutionLocalWins  = "local-wins"
+	ResolutionRemoteWins = "remote-wins"
+	ResolutionKeptBoth   = "kept-both"
+	ResolutionAborted    = "aborted"
+)
+
+// ErrConflicts is returned when conflicts are found with the abort strategy
+var ErrConflicts = errors.New("sync aborted due to conflicts")
+
+// Conflict file changed on both sides since the last sync
+type Conflict struct {
+	Path       string         `json:"path"`
+	Local      manifest.Entry `json:"local"`
+	Remote     manifest.Entry `json:"remote"`
+	Resolution string         `json:"resolution"`
+	Renamed    string         `json:"renamed,omitempty"` // Name given to the local copy by keep-both
+}
+
+// ParseConflictStrategy parses a conflict strategy name
+func ParseConflictStrategy(s string) (ConflictStrategy, error) {
+	switch strategy := ConflictStrategy(s); strategy {
+	case NewerWins, KeepBoth, Abort:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("invalid conflict strategy %q, must be one of: newer-wins, keep-both, abort", s)
+	}
+}
+
+// resolveConflict records the conflict and returns the action resolving it
+func (s *Syncer) resolveConflict(p string, l, r manifest.Entry, report *Report) Action {
+	conflict := Conflict{Path: p, Local: l, Remote: r}
+
+	var action Action
+	switch s.config.Conflict {
+	case NewerWins:
+		// Ties favor the remote copy
+		if l.ModTime.After(r.ModTime) {
+			conflict.Resolution = ResolutionLocalWins
+			action = ActionUpload
+		} else {
+			conflict.Resolution = ResolutionRemoteWins
+			action = ActionDownload
+		}
+	case KeepBoth:
+		conflict.Resolution = ResolutionKeptBoth
+		conflict.Renamed = conflictName(p, time.Now())
+		action = ActionKeepBoth
+	default:
+		conflict.Resolution = ResolutionAborted
+	}
+
+	report.Conflicts = append(report.Conflicts, conflict)
+	return action
+}
+
+// keepBoth renames the local copy, fetches the remote version under the
+// original name and uploads the renamed local copy
+func (s *Syncer) keepBoth(ctx context.Context, task Task, rec *recorder) error {
+	renamed := ""
+	for _, c := range rec.report.Conflicts {
+		if c.Path == task.Path {
+			renamed = c.Renamed
+		}
+	}
+	if renamed == "" {
+		return fmt.Errorf("no conflict recorded for %s", task.Path)
+	}
+
+	// A resumed sync may find the local copy renamed already
+	if _, err := os.Stat(s.localPath(renamed)); os.IsNotExist(err) {
+		if err := os.Rename(s.localPath(task.Path), s.localPath(renamed)); err != nil {
+			return fmt.Errorf("failed to rename local copy: %w", err)
+		}
+	}
+
+	l, err := s.download(ctx, task.Path, *task.Remote)
+	if err != nil {
+		return err
+	}
+	rec.downloaded(task.Path, *l, *task.Remote)
+
+	renamedLocal := *task.Local
+	renamedLocal.Path = renamed
+	r, err := s.upload(ctx, Task{Path: renamed, Action: ActionUpload, Local: &renamedLocal})
+	if err != nil {
+		return err
+	}
+	rec.uploaded(renamed, renamedLocal, *r)
+	return nil
+}
+
+// conflictName returns the name given to the local copy of a conflicting file,
+// e.g. dir/report.conflict-20250102-150405.pdf
+func conflictName(p string, now time.Time) string {
+	dir, file := path.Split(p)
+	ext := path.Ext(file)
+	// Dot files such as .bashrc have no extension
+	if ext == file {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(file, ext)
+	return dir + stem + ".conflict-" + now.Format("20060102-150405") + ext
+}
+
+// WriteConflictReport writes the conflicts of the run as JSON
+func (r *Report) WriteConflictReport(path string) error {
+	data, err := json.MarshalIndent(r.Conflicts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize conflict report: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,88 @@
+package syncer
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+)
+
+// filter selects the files taking part in a sync by glob patterns. A file is
+// synced if it matches any include pattern, or none are given, and no
+// exclude pattern.
+//
+// Patterns use path.Match syntax. A pattern without a slash matches any path
+// component, e.g. *.log or node_modules. A pattern with a slash is matched
+// against the path from the sync root and its leading directories, e.g.
+// build/*.tmp or docs/api. A trailing slash only matches directories.
+type filter struct {
+	include    []string
+	exclude    []string
+	ignoreDirs []string // Directories below the sync root never synced, regardless of patterns
+}
+
+func newFilter(include, exclude []string) (*filter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(strings.Trim(pattern, "/"), ""); err != nil || strings.Trim(pattern, "/") == "" {
+			return nil, fmt.Errorf("invalid filter pattern: %q", pattern)
+		}
+	}
+	return &filter{include: include, exclude: exclude}, nil
+}
+
+// match reports whether the slash separated path p is synced
+func (f *filter) match(p string) bool {
+	for _, dir := range f.ignoreDirs {
+		if strings.HasPrefix(p, dir+"/") {
+			return false
+		}
+	}
+	if len(f.include) > 0 && !matchAny(f.include, p) {
+		return false
+	}
+	return !matchAny(f.exclude, p)
+}
+
+// apply returns the entries of m selected by the filter, indexed by path
+func (f *filter) apply(m *manifest.Manifest) map[string]manifest.Entry {
+	entries := make(map[string]manifest.Entry, len(m.Entries))
+	for _, e := range m.Entries {
+		if f.match(e.Path) {
+			entries[e.Path] = e
+		}
+	}
+	return entries
+}
+
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern, p string) bool {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.Trim(pattern, "/")
+	anchored := strings.Contains(pattern, "/")
+
+	names := strings.Split(p, "/")
+	if dirOnly {
+		// The last component is the file itself
+		names = names[:len(names)-1]
+	}
+
+	for i := range names {
+		subject := names[i]
+		if anchored {
+			subject = strings.Join(names[:i+1], "/")
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
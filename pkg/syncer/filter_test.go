@@ -0,0 +1,46 @@
+package syncer
+
+import "testing"
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		path    string
+		want    bool
+	}{
+		{"no_patterns", nil, nil, "a/b.txt", true},
+		{"exclude_extension", nil, []string{"*.log"}, "logs/app.log", false},
+		{"exclude_other_extension", nil, []string{"*.log"}, "app.txt", true},
+		{"exclude_directory_name", nil, []string{"node_modules"}, "web/node_modules/x/index.js", false},
+		{"exclude_directory_only", nil, []string{"build/"}, "build", true},
+		{"exclude_directory_only_match", nil, []string{"build/"}, "build/out.bin", false},
+		{"exclude_anchored", nil, []string{"docs/*.tmp"}, "docs/a.tmp", false},
+		{"exclude_anchored_nested", nil, []string{"docs/*.tmp"}, "src/docs/a.tmp", true},
+		{"exclude_anchored_directory", nil, []string{"docs/api"}, "docs/api/index.html", false},
+		{"include_extension", []string{"*.tar.gz"}, nil, "v1/app.tar.gz", true},
+		{"include_miss", []string{"*.tar.gz"}, nil, "v1/notes.txt", false},
+		{"include_then_exclude", []string{"*.tar.gz"}, []string{"old/"}, "old/app.tar.gz", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatalf("newFilter() error = %v", err)
+			}
+			if got := f.match(tt.path); got != tt.want {
+				t.Errorf("match(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFilterInvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"[", "/", ""} {
+		if _, err := newFilter(nil, []string{pattern}); err == nil {
+			t.Errorf("Expected error for pattern %q", pattern)
+		}
+	}
+}
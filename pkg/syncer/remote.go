@@ -0,0 +1,391 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/client"
+	"github.com/easzlab/ezft/pkg/delta"
+	"github.com/easzlab/ezft/pkg/manifest"
+)
+
+// apiPrefix prefix of the ezft server specific endpoints
+const apiPrefix = "/_ezft/"
+
+const (
+	// mtimeHeader carries the modification time of uploaded files
+	mtimeHeader = "X-Ezft-Mtime"
+	// modeHeader carries the permission bits of uploaded files
+	modeHeader = "X-Ezft-Mode"
+	// linkHeader carries the target of uploaded symbolic links
+	linkHeader = "X-Ezft-Link"
+)
+
+// DefaultPort port of ezft:// URLs without an explicit port, the default port of ezft server
+const DefaultPort = "8080"
+
+// errDeltaUnsupported is returned when the server cannot patch files
+var errDeltaUnsupported = errors.New("delta upload not supported")
+
+// statusError unexpected status code returned by the server
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server returned error status: %d", e.code)
+}
+
+// Unwrap makes refused credentials match client.ErrUnauthorized
+func (e *statusError) Unwrap() error {
+	if e.code == http.StatusUnauthorized || e.code == http.StatusForbidden {
+		return client.ErrUnauthorized
+	}
+	return nil
+}
+
+// notSupported reports whether err is a status telling the endpoint does not exist
+func notSupported(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && (se.code == http.StatusNotFound || se.code == http.StatusMethodNotAllowed)
+}
+
+// errCopyUnsupported is returned when the server cannot copy files, e.g. an
+// older server or one without upload enabled
+var errCopyUnsupported = errors.New("remote copy not supported")
+
+// remote talks to the ezft server holding the remote side of a sync
+type remote struct {
+	base       *url.URL // Remote directory URL, always ending with a slash
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+func newRemote(rawURL, username, password string, httpClient *http.Client) (*remote, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "ezft", "ezfts":
+		// ezft://host/dir/ is http://host:8080/dir/, ezfts:// the same over https
+		u.Scheme = strings.Replace(u.Scheme, "ezft", "http", 1)
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), DefaultPort)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported remote URL scheme: %s", u.Scheme)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+
+	return &remote{
+		base:       u,
+		username:   username,
+		password:   password,
+		httpClient: httpClient,
+	}, nil
+}
+
+// fileURL returns the URL of a file relative to the remote directory
+func (r *remote) fileURL(path string) string {
+	u := *r.base
+	u.Path += path
+	return u.String()
+}
+
+// apiURL returns the URL of an ezft endpoint for a path relative to the remote directory
+func (r *remote) apiURL(endpoint, path string) string {
+	u := *r.base
+	u.Path = apiPrefix + endpoint + u.Path + path
+	return u.String()
+}
+
+func (r *remote) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; ezft/1.0)")
+	if r.username != "" || r.password != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	return req, nil
+}
+
+// probe sends a HEAD request to the remote directory, returning the status
+// code of the server
+func (r *remote) probe(ctx context.Context) (int, error) {
+	req, err := r.newRequest(ctx, "HEAD", r.base.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// getJSON performs a GET request and decodes the JSON response into v
+func (r *remote) getJSON(ctx context.Context, url string, v any) error {
+	req, err := r.newRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode}
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// manifest fetches the listing of the remote directory, a missing directory
+// is listed as empty if allowMissing is set
+func (r *remote) manifest(ctx context.Context, allowMissing bool) (*manifest.Manifest, error) {
+	var m manifest.Manifest
+	if err := r.getJSON(ctx, r.apiURL("manifest", ""), &m); err != nil {
+		var se *statusError
+		if allowMissing && errors.As(err, &se) && se.code == http.StatusNotFound {
+			return &manifest.Manifest{}, nil
+		}
+		return nil, fmt.Errorf("failed to fetch remote manifest: %w", err)
+	}
+	for _, e := range m.Entries {
+		if !validPath(e.Path) {
+			return nil, fmt.Errorf("remote manifest lists invalid path %q", e.Path)
+		}
+	}
+	return &m, nil
+}
+
+// validPath reports whether the slash separated path of a remote entry names a
+// file inside the sync root that is not ezft bookkeeping, so that a hostile
+// server cannot have files written or deleted elsewhere
+func validPath(p string) bool {
+	if !filepath.IsLocal(filepath.FromSlash(p)) || path.Clean(p) != p {
+		return false
+	}
+	for _, name := range strings.Split(p, "/") {
+		if manifest.IsInternal(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// hash fetches the sha256 of a remote file
+func (r *remote) hash(ctx context.Context, path string) (string, error) {
+	var result struct {
+		Hash string `json:"hash"`
+	}
+	if err := r.getJSON(ctx, r.apiURL("hash", path)+"?algo=sha256", &result); err != nil {
+		return "", fmt.Errorf("failed to fetch remote hash of %s: %w", path, err)
+	}
+	return result.Hash, nil
+}
+
+// upload sends a local file to the remote path, preserving its modification time and mode
+func (r *remote) upload(ctx context.Context, localPath, path string) (*manifest.Entry, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := r.newRequest(ctx, "PUT", r.fileURL(path), file)
+	if err != nil {
+		return nil, err
+	}
+	entry := manifest.NewEntry(path, info)
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	setMetadata(req, entry)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return nil, fmt.Errorf("upload of %s failed, status code: %d", path, resp.StatusCode)
+	}
+	return &entry, nil
+}
+
+// uploadLink creates the symbolic link described by e on the server
+func (r *remote) uploadLink(ctx context.Context, e manifest.Entry) error {
+	req, err := r.newRequest(ctx, "PUT", r.fileURL(e.Path), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(linkHeader, e.Link)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("upload of link %s failed, status code: %d", e.Path, resp.StatusCode)
+	}
+	return nil
+}
+
+// setMetadata sets the mtime and, if known, mode headers of an upload of e
+func setMetadata(req *http.Request, e manifest.Entry) {
+	req.Header.Set(mtimeHeader, e.ModTime.UTC().Format(time.RFC3339Nano))
+	if e.Mode != 0 {
+		req.Header.Set(modeHeader, strconv.FormatUint(uint64(e.Mode.Perm()), 8))
+	}
+}
+
+// copy copies the remote file from to the path of e on the server, the copy
+// is given the mtime and mode of e
+func (r *remote) copy(ctx context.Context, from string, e manifest.Entry) error {
+	path := e.Path
+	u := r.apiURL("copy", path) + "?from=" + url.QueryEscape(r.base.Path+from)
+	req, err := r.newRequest(ctx, "POST", u, nil)
+	if err != nil {
+		return err
+	}
+	setMetadata(req, e)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return errCopyUnsupported
+	default:
+		return fmt.Errorf("copy of %s to %s failed, status code: %d", from, path, resp.StatusCode)
+	}
+}
+
+// uploadDelta updates the remote file from a local file by sending only the
+// blocks missing on the server, computed against the signature of the remote copy
+func (r *remote) uploadDelta(ctx context.Context, localPath, path string) (*manifest.Entry, *delta.Stats, error) {
+	var sig delta.Signature
+	if err := r.getJSON(ctx, r.apiURL("signature", path), &sig); err != nil {
+		if notSupported(err) {
+			return nil, nil, errDeltaUnsupported
+		}
+		return nil, nil, fmt.Errorf("failed to fetch signature of %s: %w", path, err)
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pr, pw := io.Pipe()
+	type result struct {
+		stats *delta.Stats
+		err   error
+	}
+	computed := make(chan result, 1)
+	go func() {
+		stats, err := delta.ComputeDelta(&sig, file, pw)
+		pw.CloseWithError(err)
+		computed <- result{stats, err}
+	}()
+
+	u := r.apiURL("patch", path) + "?block-size=" + strconv.Itoa(sig.BlockSize)
+	req, err := r.newRequest(ctx, "POST", u, pr)
+	if err != nil {
+		pr.Close()
+		<-computed
+		return nil, nil, err
+	}
+	entry := manifest.NewEntry(path, info)
+	req.Header.Set("Content-Type", "application/x-ezft-delta")
+	setMetadata(req, entry)
+
+	resp, err := r.httpClient.Do(req)
+	// Stop the delta computation if the server answered before reading it all
+	pr.Close()
+	res := <-computed
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, nil, errDeltaUnsupported
+	default:
+		return nil, nil, fmt.Errorf("delta upload of %s failed, status code: %d", path, resp.StatusCode)
+	}
+	if res.err != nil {
+		return nil, nil, fmt.Errorf("failed to compute delta of %s: %w", path, res.err)
+	}
+	return &entry, res.stats, nil
+}
+
+// delete removes a remote file, or moves it below backupDir relative to the
+// remote directory if given. Files already gone are not an error
+func (r *remote) delete(ctx context.Context, filePath, backupDir string) error {
+	u := r.fileURL(filePath)
+	if backupDir != "" {
+		backup := path.Join(r.base.Path, filepath.ToSlash(backupDir), filePath)
+		u += "?backup=" + url.QueryEscape(backup)
+	}
+	req, err := r.newRequest(ctx, "DELETE", u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("delete of %s failed, status code: %d", filePath, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package syncer
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNewRemote(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{"http://host:9000/releases", "http://host:9000/releases/", false},
+		{"https://host/releases/?x=1", "https://host/releases/", false},
+		{"ezft://host/releases/", "http://host:8080/releases/", false},
+		{"ezft://host:9000/releases/", "http://host:9000/releases/", false},
+		{"ezfts://[::1]/releases/", "https://[::1]:8080/releases/", false},
+		{"ftp://host/releases/", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			r, err := newRemote(tt.url, "", "", http.DefaultClient)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newRemote() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && r.base.String() != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, r.base.String())
+			}
+		})
+	}
+}
+
+func TestRemoteURLs(t *testing.T) {
+	r, err := newRemote("http://host/data/", "", "", http.DefaultClient)
+	if err != nil {
+		t.Fatalf("newRemote() error = %v", err)
+	}
+	if got := r.fileURL("dir/a b.txt"); got != "http://host/data/dir/a%20b.txt" {
+		t.Errorf("Unexpected file URL %s", got)
+	}
+	if got := r.apiURL("patch", "a.txt"); got != "http://host/_ezft/patch/data/a.txt" {
+		t.Errorf("Unexpected API URL %s", got)
+	}
+}
@@ -0,0 +1,172 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// renameSources files that disappeared from one side since the last sync
+// while unchanged on the other, indexed by size. A new file of the same size
+// and content on the other side is taken as a rename and copied from them
+// instead of being transferred
+type renameSources struct {
+	local  map[int64][]manifest.Entry // Local files whose remote copy disappeared
+	remote map[int64][]manifest.Entry // Remote files whose local copy disappeared
+}
+
+func findRenameSources(local, remote map[string]manifest.Entry, state *syncState) *renameSources {
+	sources := &renameSources{
+		local:  map[int64][]manifest.Entry{},
+		remote: map[int64][]manifest.Entry{},
+	}
+	for p, base := range state.Files {
+		l, hasLocal := local[p]
+		r, hasRemote := remote[p]
+		switch {
+		case hasLocal && !hasRemote && sameFile(l, base.Local):
+			sources.local[l.Size] = append(sources.local[l.Size], l)
+		case hasRemote && !hasLocal && sameFile(r, base.Remote):
+			sources.remote[r.Size] = append(sources.remote[r.Size], r)
+		}
+	}
+	return sources
+}
+
+// findRename turns a task transferring a new file into a copy if one of the
+// rename sources has the same content. Only sources of the same size are
+// hashed, local hashes come from the cache
+func (s *Syncer) findRename(ctx context.Context, task *Task, sources *renameSources) {
+	var candidates []manifest.Entry
+	var size int64
+	switch {
+	case task.Action == ActionDownload && task.Local == nil:
+		size = task.Remote.Size
+		candidates = sources.local[size]
+	case task.Action == ActionUpload && task.Remote == nil:
+		size = task.Local.Size
+		candidates = sources.remote[size]
+	}
+	// Empty files are cheaper to transfer than to match
+	if len(candidates) == 0 || size == 0 {
+		return
+	}
+
+	from, err := s.matchRename(ctx, task, candidates)
+	if err != nil {
+		s.logger.Warn("rename detection failed",
+			zap.String("path", task.Path),
+			zap.Error(err),
+		)
+		return
+	}
+	if from != "" {
+		task.Action = ActionCopy
+		task.From = from
+	}
+}
+
+// matchRename returns the path of the candidate with the same content as the
+// new file of the task, or an empty path if none matches
+func (s *Syncer) matchRename(ctx context.Context, task *Task, candidates []manifest.Entry) (string, error) {
+	var want string
+	var err error
+	if task.Local == nil {
+		want, err = s.remote.hash(ctx, task.Path)
+	} else {
+		want, err = s.cache.hash(s.config.LocalDir, *task.Local)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range candidates {
+		var got string
+		if task.Local == nil {
+			got, err = s.cache.hash(s.config.LocalDir, c)
+		} else {
+			got, err = s.remote.hash(ctx, c.Path)
+		}
+		if err != nil {
+			return "", err
+		}
+		if got == want {
+			return c.Path, nil
+		}
+	}
+	return "", nil
+}
+
+// copyFile replays a detected rename on the side receiving the new file,
+// falling back to a transfer if the server cannot copy
+func (s *Syncer) copyFile(ctx context.Context, task Task, rec *recorder) error {
+	if task.Local == nil {
+		l, err := s.copyLocal(task.From, *task.Remote)
+		if err != nil {
+			return err
+		}
+		rec.copied(task.Path, *l, *task.Remote)
+		return nil
+	}
+
+	err := s.remote.copy(ctx, task.From, *task.Local)
+	if errors.Is(err, errCopyUnsupported) {
+		task.Action = ActionUpload
+		return s.execute(ctx, task, rec)
+	}
+	if err != nil {
+		return err
+	}
+	rec.copied(task.Path, *task.Local, *task.Local)
+	return nil
+}
+
+// copyLocal copies a local file to the path of e and applies its mtime and mode
+func (s *Syncer) copyLocal(from string, e manifest.Entry) (*manifest.Entry, error) {
+	src, err := os.Open(s.localPath(from))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	target := s.localPath(e.Path)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".ezft-copy-*")
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = utils.CopyFile(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy %s: %w", from, err)
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return nil, err
+	}
+	if err := applyMetadata(tmpPath, e); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+	entry := manifest.NewEntry(e.Path, info)
+	return &entry, nil
+}
@@ -0,0 +1,132 @@
+package syncer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+)
+
+func TestHashCache(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a.txt", "hello", t1)
+	entry := manifest.Entry{Path: "a.txt", Size: 5, ModTime: t1}
+
+	cache := loadHashCache(dir)
+	h, err := cache.hash(dir, entry)
+	if err != nil {
+		t.Fatalf("hash() error = %v", err)
+	}
+	if h != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("Unexpected hash %s", h)
+	}
+	if err := cache.save(dir); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	// Unchanged files are served from the saved cache
+	cache = loadHashCache(dir)
+	cache.Files["a.txt"] = hashEntry{Size: 5, ModTime: t1, Hash: "cached"}
+	if h, _ := cache.hash(dir, entry); h != "cached" {
+		t.Errorf("Expected cached hash, got %s", h)
+	}
+
+	// A changed mtime invalidates the entry
+	writeFile(t, dir, "a.txt", "hello", t2)
+	if h, _ := cache.hash(dir, manifest.Entry{Path: "a.txt", Size: 5, ModTime: t2}); h == "cached" {
+		t.Error("Expected file to be hashed again after mtime change")
+	}
+
+	cache.prune(map[string]manifest.Entry{})
+	if len(cache.Files) != 0 {
+		t.Errorf("Expected pruned cache, got %d entries", len(cache.Files))
+	}
+}
+
+func TestLoadHashCacheCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".ezft/"+cacheFile, "not json", t1)
+
+	if cache := loadHashCache(dir); len(cache.Files) != 0 {
+		t.Errorf("Expected empty cache, got %d entries", len(cache.Files))
+	}
+}
+
+func TestSyncPullRename(t *testing.T) {
+	env := newTestEnv(t)
+	content := strings.Repeat("data", 2000)
+	writeFile(t, env.remote, "old.bin", content, t1)
+	if _, err := env.run(t, Pull, Abort); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(env.remote, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(env.remote, "old.bin"), filepath.Join(env.remote, "dir", "new.bin")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := env.run(t, Pull, Abort)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Copied != 1 || report.Downloaded != 0 || report.Bytes != 0 {
+		t.Errorf("Expected rename to be copied locally, got %+v", report)
+	}
+	if got := readFile(t, env.local, "dir/new.bin"); got != content {
+		t.Error("dir/new.bin content mismatch")
+	}
+	info, _ := os.Stat(filepath.Join(env.local, "dir", "new.bin"))
+	if !info.ModTime().Equal(t1) {
+		t.Errorf("Expected mtime %v, got %v", t1, info.ModTime())
+	}
+
+	// The copy is in sync afterwards
+	report, err = env.run(t, Pull, Abort)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Copied != 0 || report.Downloaded != 0 {
+		t.Errorf("Expected no transfer, got %+v", report)
+	}
+}
+
+func TestSyncPushRename(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.local, "old.bin", "same content", t1)
+	if _, err := env.run(t, Push, Abort); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if err := os.Rename(filepath.Join(env.local, "old.bin"), filepath.Join(env.local, "new.bin")); err != nil {
+		t.Fatal(err)
+	}
+	// Same size but different content is not a rename
+	writeFile(t, env.local, "other.bin", "diff content", t2)
+
+	report, err := env.run(t, Push, Abort)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Copied != 1 || report.Uploaded != 1 {
+		t.Errorf("Expected 1 copy and 1 upload, got %+v", report)
+	}
+	if got := readFile(t, env.remote, "new.bin"); got != "same content" {
+		t.Errorf("Expected copied content, got %q", got)
+	}
+	info, _ := os.Stat(filepath.Join(env.remote, "new.bin"))
+	if !info.ModTime().Equal(t1) {
+		t.Errorf("Expected mtime %v, got %v", t1, info.ModTime())
+	}
+
+	report, err = env.run(t, Push, Abort)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Copied != 0 || report.Uploaded != 0 {
+		t.Errorf("Expected no transfer, got %+v", report)
+	}
+}
@@ -0,0 +1,185 @@
+package syncer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+const (
+	// sessionFile name of the file holding the plan of a sync in progress
+	sessionFile = "sync-session.json"
+	// journalFile name of the file logging the progress of the session
+	journalFile = "sync-session.log"
+)
+
+// session plan of a sync in progress. It is written before any task runs and
+// removed once all tasks succeeded, completed tasks are appended to the
+// journal so an interrupted sync resumes without planning again
+type session struct {
+	Key       string               `json:"key"`       // Remote and options the plan was made for
+	Tasks     []Task               `json:"tasks"`     // Planned tasks, deletions last
+	Base      map[string]fileState `json:"base"`      // Baseline of the files already in sync
+	Conflicts []Conflict           `json:"conflicts"` // Conflicts resolved by the plan
+
+	done map[string]bool // Paths of completed tasks
+}
+
+// journalEntry line of the session journal
+type journalEntry struct {
+	Path  string     `json:"path"`
+	State *fileState `json:"state,omitempty"` // Baseline of a file written by a task
+	Done  bool       `json:"done,omitempty"`  // The task of path completed
+}
+
+// sessionOptions settings a plan depends on, a session is only resumed with the same ones
+type sessionOptions struct {
+	Remote    string           `json:"remote"`
+	Direction Direction        `json:"direction"`
+	Conflict  ConflictStrategy `json:"conflict"`
+	Include   []string         `json:"include"`
+	Exclude   []string         `json:"exclude"`
+	Delete    bool             `json:"delete"`
+	BackupDir string           `json:"backup_dir"`
+}
+
+func sessionPath(localDir, name string) string {
+	return filepath.Join(localDir, stateDir, name)
+}
+
+// sessionKey identifies the remote and options of the syncer
+func (s *Syncer) sessionKey() string {
+	data, _ := json.Marshal(sessionOptions{
+		Remote:    s.remote.base.String(),
+		Direction: s.config.Direction,
+		Conflict:  s.config.Conflict,
+		Include:   s.config.Include,
+		Exclude:   s.config.Exclude,
+		Delete:    s.config.Delete,
+		BackupDir: s.config.BackupDir,
+	})
+	return string(data)
+}
+
+// loadSession loads the interrupted session of localDir with its journal
+// applied. Nil is returned when there is none or it was made for another key
+func loadSession(localDir, key string) (*session, error) {
+	data, err := os.ReadFile(sessionPath(localDir, sessionFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync session: %w", err)
+	}
+
+	var sess session
+	if err := json.Unmarshal(data, &sess); err != nil || sess.Key != key {
+		// A session cut short while being written was never started
+		return nil, nil
+	}
+	if sess.Base == nil {
+		sess.Base = map[string]fileState{}
+	}
+	sess.done = map[string]bool{}
+
+	journal, err := os.ReadFile(sessionPath(localDir, journalFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read sync journal: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(journal))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Only the last line may be incomplete
+			break
+		}
+		if entry.State != nil {
+			sess.Base[entry.Path] = *entry.State
+		}
+		if entry.Done {
+			sess.done[entry.Path] = true
+		}
+	}
+	return &sess, nil
+}
+
+// pending returns the tasks not completed yet
+func (sess *session) pending() []Task {
+	var tasks []Task
+	for _, task := range sess.Tasks {
+		if !sess.done[task.Path] {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// save writes the session into the local state directory and starts an empty journal
+func (sess *session) save(localDir string) (*journal, error) {
+	if err := os.MkdirAll(filepath.Join(localDir, stateDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.Remove(sessionPath(localDir, journalFile)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize sync session: %w", err)
+	}
+	if err := utils.WriteFileAtomic(sessionPath(localDir, sessionFile), data, 0644); err != nil {
+		return nil, err
+	}
+	return openJournal(localDir)
+}
+
+// removeSession deletes the session of localDir with its journal
+func removeSession(localDir string) error {
+	for _, name := range []string{sessionFile, journalFile} {
+		if err := os.Remove(sessionPath(localDir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// journal appends the progress of a session, writes are serialized by the recorder
+type journal struct {
+	file *os.File
+	err  error // First write error, progress after it is not recorded
+}
+
+func openJournal(localDir string) (*journal, error) {
+	f, err := os.OpenFile(sessionPath(localDir, journalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sync journal: %w", err)
+	}
+	return &journal{file: f}, nil
+}
+
+func (j *journal) write(entry journalEntry) {
+	if j == nil || j.err != nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		_, err = j.file.Write(append(data, '\n'))
+	}
+	j.err = err
+}
+
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+	if err := j.file.Close(); j.err == nil {
+		j.err = err
+	}
+	return j.err
+}
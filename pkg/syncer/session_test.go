@@ -0,0 +1,123 @@
+package syncer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncResumeSession(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.local, "a.txt", "aaa", t1)
+	writeFile(t, env.local, "b.txt", "bbb", t1)
+	// A directory in the way makes the upload of b.txt fail
+	writeFile(t, env.remote, "b.txt/blocker", "x", t1)
+
+	config := Config{Direction: Push, Transfers: 1}
+	if _, err := env.runConfig(t, config); err == nil {
+		t.Fatal("Expected the upload of b.txt to fail")
+	}
+	if !exists(env.local, ".ezft/"+sessionFile) {
+		t.Fatal("Expected the session to be kept after a failed run")
+	}
+
+	// Changes made meanwhile are left to the next planned run
+	if err := os.RemoveAll(filepath.Join(env.remote, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, env.local, "a.txt", "changed", t2)
+	writeFile(t, env.local, "c.txt", "ccc", t1)
+
+	report, err := env.runConfig(t, config)
+	if err != nil {
+		t.Fatalf("Resumed run error = %v", err)
+	}
+	if report.Resumed != 1 || report.Uploaded != 1 {
+		t.Errorf("Expected 1 resumed and 1 uploaded task, got %+v", report)
+	}
+	if got := readFile(t, env.remote, "b.txt"); got != "bbb" {
+		t.Errorf("b.txt = %q, want bbb", got)
+	}
+	if got := readFile(t, env.remote, "a.txt"); got != "aaa" {
+		t.Errorf("a.txt = %q, resumed run must not plan again", got)
+	}
+	if exists(env.remote, "c.txt") {
+		t.Error("c.txt must not be uploaded by the resumed run")
+	}
+	if exists(env.local, ".ezft/"+sessionFile) || exists(env.local, ".ezft/"+journalFile) {
+		t.Error("Expected the session to be removed after completion")
+	}
+
+	report, err = env.runConfig(t, config)
+	if err != nil {
+		t.Fatalf("Run error = %v", err)
+	}
+	if report.Resumed != 0 || report.Uploaded != 2 || report.Skipped != 1 {
+		t.Errorf("Expected a.txt and c.txt uploaded and b.txt unchanged, got %+v", report)
+	}
+}
+
+func TestSyncResumeSessionDiscarded(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.local, "a.txt", "aaa", t1)
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"other options", Config{Direction: Push, Exclude: []string{"*.tmp"}}},
+		{"no resume", Config{Direction: Push, NoResume: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeFile(t, env.remote, "a.txt/blocker", "x", t1)
+			if _, err := env.runConfig(t, Config{Direction: Push}); err == nil {
+				t.Fatal("Expected the upload of a.txt to fail")
+			}
+			if err := os.RemoveAll(filepath.Join(env.remote, "a.txt")); err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := env.runConfig(t, tt.config)
+			if err != nil {
+				t.Fatalf("Run error = %v", err)
+			}
+			if report.Resumed != 0 || report.Uploaded != 1 {
+				t.Errorf("Expected a fresh run uploading a.txt, got %+v", report)
+			}
+			if exists(env.local, ".ezft/"+sessionFile) {
+				t.Error("Expected the session to be removed")
+			}
+			if err := os.Remove(filepath.Join(env.remote, "a.txt")); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLoadSessionTruncatedJournal(t *testing.T) {
+	dir := t.TempDir()
+	sess := &session{Key: "k", Tasks: []Task{{Path: "a", Action: ActionDelete}, {Path: "b", Action: ActionDelete}}}
+	j, err := sess.save(dir)
+	if err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	j.write(journalEntry{Path: "a", Done: true})
+	if _, err := j.file.WriteString(`{"path":"b","do`); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadSession(dir, "k")
+	if err != nil || loaded == nil {
+		t.Fatalf("loadSession() = %v, %v", loaded, err)
+	}
+	if pending := loaded.pending(); len(pending) != 1 || pending[0].Path != "b" {
+		t.Errorf("pending() = %+v, want only b", pending)
+	}
+	if other, _ := loadSession(dir, "other"); other != nil {
+		t.Error("Expected no session for another key")
+	}
+}
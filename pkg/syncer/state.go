@@ -0,0 +1,69 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+// stateDir directory holding sync bookkeeping inside the local root
+const stateDir = ".ezft"
+
+// stateFile name of the file recording the last synced state
+const stateFile = "sync-state.json"
+
+// fileState both sides of a file as they were after the last sync
+type fileState struct {
+	Local  manifest.Entry `json:"local"`
+	Remote manifest.Entry `json:"remote"`
+}
+
+// syncState baseline used to tell which side changed since the last sync
+type syncState struct {
+	Remote string               `json:"remote"` // Remote URL the baseline belongs to
+	Files  map[string]fileState `json:"files"`
+}
+
+func statePath(localDir string) string {
+	return filepath.Join(localDir, stateDir, stateFile)
+}
+
+// loadState loads the baseline of localDir, an empty baseline is returned
+// when none exists or it belongs to another remote
+func loadState(localDir, remoteURL string) (*syncState, error) {
+	state := &syncState{Remote: remoteURL, Files: map[string]fileState{}}
+
+	data, err := os.ReadFile(statePath(localDir))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %w", err)
+	}
+
+	var saved syncState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse sync state: %w", err)
+	}
+	if saved.Remote != remoteURL || saved.Files == nil {
+		return state, nil
+	}
+	return &saved, nil
+}
+
+// save writes the baseline into the local state directory
+func (s *syncState) save(localDir string) error {
+	if err := os.MkdirAll(filepath.Join(localDir, stateDir), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to serialize sync state: %w", err)
+	}
+	return utils.WriteFileAtomic(statePath(localDir), data, 0644)
+}
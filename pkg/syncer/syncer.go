@@ -0,0 +1,776 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/client"
+	"github.com/easzlab/ezft/pkg/delta"
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/easzlab/ezft/pkg/ratelimit"
+	"github.com/easzlab/ezft/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// Direction direction in which changes are propagated
+type Direction int
+
+const (
+	Pull          Direction = iota // Remote changes are applied locally
+	Push                           // Local changes are applied to the remote
+	Bidirectional                  // Changes on either side are applied to the other
+)
+
+// Config sync configuration
+type Config struct {
+	LocalDir       string           // Local directory
+	RemoteURL      string           // Remote directory URL on an ezft server
+	Direction      Direction        // Sync direction
+	Conflict       ConflictStrategy // Conflict strategy of bidirectional sync
+	Username       string           // Basic auth username
+	Password       string           // Basic auth password
+	NoProxyEnv     bool             // Ignore the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, connecting directly
+	ChunkSize      int64            // Chunk size of file downloads
+	MaxConcurrency int              // Chunk concurrency of file downloads
+	RetryCount     int              // Retry count of file downloads
+	Transfers      int              // Number of files transferred in parallel
+	DryRun         bool             // Only plan the sync, nothing is transferred or written
+	Include        []string         // Patterns of files to sync, all files if empty
+	Exclude        []string         // Patterns of files left out of the sync
+	Delete         bool             // Delete destination files missing from the source
+	MaxDelete      int              // Maximum number of deletions in a run, no limit if 0
+	BackupDir      string           // Directory relative to the destination root receiving deleted files instead of removing them
+	Debounce       time.Duration    // Quiet period after local changes before a watch mode sync
+	PollInterval   time.Duration    // Interval of remote change polling in watch mode
+	NoResume       bool             // Plan from scratch instead of resuming an interrupted sync
+	Verify         bool             // Hash the transferred files on both sides after the sync
+}
+
+// Action operation planned for a file
+type Action string
+
+const (
+	ActionDownload Action = "download"  // Copy the remote file to the local side
+	ActionUpload   Action = "upload"    // Copy the local file to the remote side
+	ActionKeepBoth Action = "keep-both" // Rename the local copy and keep both versions on both sides
+	ActionCopy     Action = "copy"      // Copy a renamed file on the side receiving it instead of transferring it
+	ActionDelete   Action = "delete"    // Delete the file on the side it still exists
+	ActionSkip     Action = "skip"      // Nothing to do
+)
+
+// Task operation planned for a single file
+type Task struct {
+	Path   string          `json:"path"`
+	Action Action          `json:"action"`
+	Local  *manifest.Entry `json:"local,omitempty"`  // Local entry, nil if missing
+	Remote *manifest.Entry `json:"remote,omitempty"` // Remote entry, nil if missing
+	From   string          `json:"from,omitempty"`   // Source path of a copy
+}
+
+// Bytes returns the number of bytes the task transfers
+func (t Task) Bytes() int64 {
+	switch t.Action {
+	case ActionDownload:
+		return t.Remote.Size
+	case ActionUpload:
+		return t.Local.Size
+	case ActionKeepBoth:
+		return t.Remote.Size + t.Local.Size
+	default:
+		return 0
+	}
+}
+
+// Report result of a sync run
+type Report struct {
+	Downloaded   int           `json:"downloaded"`
+	Uploaded     int           `json:"uploaded"`
+	Copied       int           `json:"copied"`
+	Deleted      int           `json:"deleted"`
+	Duration     time.Duration `json:"duration"`
+	Skipped      int           `json:"skipped"`
+	Resumed      int           `json:"resumed"` // Tasks completed by an interrupted run this one resumed
+	Bytes        int64         `json:"bytes"`
+	Conflicts    []Conflict    `json:"conflicts"`
+	Verification *Verification `json:"verification,omitempty"` // Result of the verification pass, if enabled
+	Plan         []Task        `json:"-"`                      // Planned operations including skipped files
+}
+
+// ErrTooManyDeletes is returned when a sync would delete more files than allowed,
+// no file is deleted in that case
+var ErrTooManyDeletes = errors.New("too many deletions")
+
+// Syncer synchronizes a local directory with a directory of an ezft server
+type Syncer struct {
+	config *Config
+	remote *remote
+	filter *filter
+	cache  *hashCache
+	logger *zap.Logger
+
+	lastRemote *manifest.Manifest // Remote manifest the last run was planned against
+
+	credentials   client.CredentialsFunc // Asked for credentials the server requires, nil to fail
+	authenticated bool                   // The server accepted the credentials, or needs none
+}
+
+// NewSyncer creates a new syncer
+func NewSyncer(config *Config) (*Syncer, error) {
+	if config.LocalDir == "" {
+		return nil, fmt.Errorf("local directory is required")
+	}
+	if config.Conflict == "" {
+		config.Conflict = Abort
+	}
+	if config.Transfers < 1 {
+		config.Transfers = DefaultTransfers
+	}
+	if config.Debounce <= 0 {
+		config.Debounce = DefaultDebounce
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+
+	// One client shared by all transfers, keeping enough idle connections
+	// for every transfer and chunk worker to be reused
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Proxy: client.ProxyFunc(config.NoProxyEnv),
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second, // Connection establishment timeout
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ResponseHeaderTimeout: 30 * time.Second, // Response header timeout, covers server side hashing
+			MaxIdleConnsPerHost:   config.Transfers * max(config.MaxConcurrency, 1),
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+
+	r, err := newRemote(config.RemoteURL, config.Username, config.Password, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	f, err := newFilter(config.Include, config.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	if config.BackupDir != "" {
+		if !filepath.IsLocal(config.BackupDir) {
+			return nil, fmt.Errorf("backup directory must be relative to the destination directory: %s", config.BackupDir)
+		}
+		// Backups are never synced themselves
+		f.ignoreDirs = append(f.ignoreDirs, filepath.ToSlash(filepath.Clean(config.BackupDir)))
+	}
+
+	return &Syncer{
+		config: config,
+		remote: r,
+		filter: f,
+		logger: zap.NewNop(),
+	}, nil
+}
+
+func (s *Syncer) SetLogger(logger *zap.Logger) {
+	s.logger = logger
+}
+
+// SetCredentialsFunc sets the function asked for credentials when the server
+// refuses the sync without any
+func (s *Syncer) SetCredentialsFunc(f client.CredentialsFunc) {
+	s.credentials = f
+}
+
+// SetRateLimiter limits the bandwidth of all transfers of the syncer by
+// limiter, which may be shared with other syncers and clients
+func (s *Syncer) SetRateLimiter(limiter *ratelimit.Limiter) {
+	s.WrapTransport(func(base http.RoundTripper) http.RoundTripper {
+		return ratelimit.NewTransport(base, limiter)
+	})
+}
+
+// WrapTransport wraps the transport of all requests of the syncer, e.g. to observe its traffic
+func (s *Syncer) WrapTransport(wrap func(http.RoundTripper) http.RoundTripper) {
+	wrapped := *s.remote.httpClient
+	wrapped.Transport = wrap(s.remote.httpClient.Transport)
+	s.remote.httpClient = &wrapped
+}
+
+// Run executes the sync
+func (s *Syncer) Run(ctx context.Context) (*Report, error) {
+	start := time.Now()
+	if err := s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	report, err := s.run(ctx)
+	if report != nil {
+		report.Duration = time.Since(start)
+	}
+	return report, err
+}
+
+// authenticate asks for credentials before the first run if the server
+// refuses requests to the remote directory without any
+func (s *Syncer) authenticate(ctx context.Context) error {
+	if s.credentials == nil || s.authenticated || s.config.Username != "" || s.config.Password != "" {
+		return nil
+	}
+	code, err := s.remote.probe(ctx)
+	if err != nil {
+		return err
+	}
+	if code == http.StatusUnauthorized {
+		username, password, err := s.credentials(s.remote.base.Host)
+		if err != nil {
+			return fmt.Errorf("%w: %w", client.ErrUnauthorized, err)
+		}
+		s.config.Username, s.config.Password = username, password
+		s.remote.username, s.remote.password = username, password
+	}
+	s.authenticated = true
+	return nil
+}
+
+func (s *Syncer) run(ctx context.Context) (*Report, error) {
+	if !s.config.DryRun {
+		resumed, err := s.resumeSession(ctx)
+		if resumed != nil || err != nil {
+			return resumed, err
+		}
+	}
+
+	localManifest, err := s.scanLocal()
+	if err != nil {
+		return nil, err
+	}
+	// Uploads create the remote directory
+	remoteManifest, err := s.remote.manifest(ctx, s.config.Direction != Pull)
+	if err != nil {
+		return nil, err
+	}
+	s.lastRemote = remoteManifest
+	state, err := loadState(s.config.LocalDir, s.remote.base.String())
+	if err != nil {
+		return nil, err
+	}
+	s.cache = loadHashCache(s.config.LocalDir)
+	s.cache.prune(localManifest.Map())
+
+	report := &Report{Conflicts: []Conflict{}}
+	next := &syncState{Remote: state.Remote, Files: map[string]fileState{}}
+
+	tasks, err := s.plan(ctx, localManifest, remoteManifest, state, next, report)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("sync planned",
+		zap.Int("tasks", len(tasks)),
+		zap.Int("skipped", report.Skipped),
+		zap.Int("conflicts", len(report.Conflicts)),
+	)
+
+	transfers, deletes := splitDeletes(tasks)
+	var limitErr error
+	if s.config.MaxDelete > 0 && len(deletes) > s.config.MaxDelete {
+		limitErr = fmt.Errorf("%w: %d files to delete, limit is %d", ErrTooManyDeletes, len(deletes), s.config.MaxDelete)
+		deletes = nil
+	}
+
+	if s.config.DryRun {
+		return report, limitErr
+	}
+	if err := s.cache.save(s.config.LocalDir); err != nil {
+		return report, err
+	}
+
+	if s.config.Conflict == Abort && len(report.Conflicts) > 0 {
+		return report, ErrConflicts
+	}
+
+	sess := &session{
+		Key:       s.sessionKey(),
+		Tasks:     append(transfers, deletes...),
+		Base:      next.Files,
+		Conflicts: report.Conflicts,
+	}
+	return report, s.execSession(ctx, sess, next, report, limitErr)
+}
+
+// resumeSession finishes the tasks of an interrupted sync of the same remote
+// and options. A nil report is returned when there is nothing to resume
+func (s *Syncer) resumeSession(ctx context.Context) (*Report, error) {
+	var sess *session
+	if !s.config.NoResume {
+		var err error
+		if sess, err = loadSession(s.config.LocalDir, s.sessionKey()); err != nil {
+			return nil, err
+		}
+	}
+	if sess == nil {
+		// Discard sessions made for other options, they must not be resumed later
+		return nil, removeSession(s.config.LocalDir)
+	}
+
+	pending := sess.pending()
+	report := &Report{Conflicts: sess.Conflicts, Resumed: len(sess.Tasks) - len(pending), Plan: pending}
+	if report.Conflicts == nil {
+		report.Conflicts = []Conflict{}
+	}
+	next := &syncState{Remote: s.remote.base.String(), Files: sess.Base}
+	s.cache = loadHashCache(s.config.LocalDir)
+
+	s.logger.Info("resuming interrupted sync",
+		zap.Int("tasks", len(sess.Tasks)),
+		zap.Int("done", report.Resumed),
+	)
+	return report, s.execSession(ctx, sess, next, report, nil)
+}
+
+// execSession runs the pending tasks of sess, journaling their progress. The
+// session is kept for a later run to resume unless all of them succeed
+func (s *Syncer) execSession(ctx context.Context, sess *session, next *syncState, report *Report, limitErr error) error {
+	if len(sess.Tasks) == 0 {
+		if s.config.Verify {
+			report.Verification = s.verify(ctx, nil)
+		}
+		if err := next.save(s.config.LocalDir); err != nil {
+			return err
+		}
+		return limitErr
+	}
+
+	var j *journal
+	var err error
+	if sess.done == nil {
+		j, err = sess.save(s.config.LocalDir)
+	} else {
+		// Resumed, keep appending to the journal
+		j, err = openJournal(s.config.LocalDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	transfers, deletes := splitDeletes(sess.pending())
+	rec := &recorder{next: next, report: report, journal: j}
+	execErr := s.runTasks(ctx, transfers, rec)
+	// Deletions run last since renamed files are copied from files about to be
+	// deleted, and are skipped entirely if anything went wrong
+	if execErr == nil {
+		execErr = s.runTasks(ctx, deletes, rec)
+	}
+	if err := j.close(); err != nil {
+		s.logger.Warn("failed to record sync progress",
+			zap.Error(err),
+		)
+	}
+
+	// Record progress even if the sync stopped half way
+	if err := next.save(s.config.LocalDir); err != nil {
+		return err
+	}
+	// Files written before a failure are verified too
+	if s.config.Verify && ctx.Err() == nil {
+		report.Verification = s.verify(ctx, rec.written)
+	}
+	if execErr != nil {
+		return execErr
+	}
+	if err := removeSession(s.config.LocalDir); err != nil {
+		return err
+	}
+	if v := report.Verification; v != nil && v.Mismatches > 0 {
+		return fmt.Errorf("%w: %d of %d files differ", ErrVerifyFailed, v.Mismatches, len(v.Files))
+	}
+	return limitErr
+}
+
+// scanLocal lists the local directory, creating it unless in dry-run mode
+func (s *Syncer) scanLocal() (*manifest.Manifest, error) {
+	if s.config.DryRun {
+		if _, err := os.Stat(s.config.LocalDir); os.IsNotExist(err) {
+			return &manifest.Manifest{}, nil
+		}
+	} else if err := os.MkdirAll(s.config.LocalDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create local directory: %w", err)
+	}
+
+	m, err := manifest.Scan(s.config.LocalDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan local directory: %w", err)
+	}
+	return m, nil
+}
+
+// plan compares both sides and returns the operations to run, files already
+// in sync are recorded into next
+func (s *Syncer) plan(ctx context.Context, local, remote *manifest.Manifest, state, next *syncState, report *Report) ([]Task, error) {
+	localEntries := s.filter.apply(local)
+	remoteEntries := s.filter.apply(remote)
+	s.dropOutsideLinks(localEntries, remoteEntries)
+
+	paths := make([]string, 0, len(localEntries)+len(remoteEntries))
+	for p := range localEntries {
+		paths = append(paths, p)
+	}
+	for p := range remoteEntries {
+		if _, ok := localEntries[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	sources := findRenameSources(localEntries, remoteEntries, state)
+
+	var tasks []Task
+	for _, p := range paths {
+		var l, r *manifest.Entry
+		if e, ok := localEntries[p]; ok {
+			l = &e
+		}
+		if e, ok := remoteEntries[p]; ok {
+			r = &e
+		}
+
+		conflicts := len(report.Conflicts)
+		action, err := s.decide(ctx, p, l, r, state, report)
+		if err != nil {
+			return nil, err
+		}
+		if action == "" && len(report.Conflicts) > conflicts {
+			// Unresolved conflict, leave both sides untouched
+			continue
+		}
+		if action == "" {
+			report.Skipped++
+			report.Plan = append(report.Plan, Task{Path: p, Action: ActionSkip, Local: l, Remote: r})
+			if l != nil && r != nil {
+				next.Files[p] = fileState{Local: *l, Remote: *r}
+			}
+			continue
+		}
+		task := Task{Path: p, Action: action, Local: l, Remote: r}
+		s.findRename(ctx, &task, sources)
+		report.Plan = append(report.Plan, task)
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// dropOutsideLinks leaves paths out of the sync where either side is a
+// symbolic link with an absolute target or one pointing outside the tree,
+// such links cannot be recreated safely on the other side
+func (s *Syncer) dropOutsideLinks(sides ...map[string]manifest.Entry) {
+	for _, entries := range sides {
+		for p, e := range entries {
+			if !e.IsLink() || e.LinkInTree() {
+				continue
+			}
+			s.logger.Warn("skipping link pointing outside the sync directory",
+				zap.String("path", p),
+				zap.String("target", e.Link),
+			)
+			for _, side := range sides {
+				delete(side, p)
+			}
+		}
+	}
+}
+
+// decide returns the action needed for a file, or an empty action if nothing is to be done
+func (s *Syncer) decide(ctx context.Context, p string, l, r *manifest.Entry, state *syncState, report *Report) (Action, error) {
+	switch s.config.Direction {
+	case Pull:
+		if r == nil && s.config.Delete {
+			return ActionDelete, nil
+		}
+		if r != nil && (l == nil || !sameFile(*l, *r)) {
+			return ActionDownload, nil
+		}
+		return "", nil
+	case Push:
+		if l == nil && s.config.Delete {
+			return ActionDelete, nil
+		}
+		if l != nil && (r == nil || !sameFile(*l, *r)) {
+			return ActionUpload, nil
+		}
+		return "", nil
+	}
+
+	// A file deleted on one side since the last sync is deleted on the other
+	// unless it was changed there
+	base, ok := state.Files[p]
+	switch {
+	case r == nil:
+		if ok && s.config.Delete && sameFile(*l, base.Local) {
+			return ActionDelete, nil
+		}
+		return ActionUpload, nil
+	case l == nil:
+		if ok && s.config.Delete && sameFile(*r, base.Remote) {
+			return ActionDelete, nil
+		}
+		return ActionDownload, nil
+	case sameFile(*l, *r):
+		return "", nil
+	}
+
+	localChanged := !ok || !sameFile(*l, base.Local)
+	remoteChanged := !ok || !sameFile(*r, base.Remote)
+
+	switch {
+	case !localChanged && !remoteChanged:
+		return "", nil
+	case localChanged && !remoteChanged:
+		return ActionUpload, nil
+	case !localChanged && remoteChanged:
+		return ActionDownload, nil
+	}
+
+	// Both sides changed, identical content is not a conflict
+	if l.Size == r.Size && l.Mode == r.Mode && !l.IsLink() && !r.IsLink() {
+		same, err := s.sameContent(ctx, p, *l)
+		if err != nil {
+			return "", err
+		}
+		if same {
+			return "", nil
+		}
+	}
+
+	return s.resolveConflict(p, *l, *r, report), nil
+}
+
+// sameContent compares the local and remote file by sha256, the local hash is cached
+func (s *Syncer) sameContent(ctx context.Context, p string, l manifest.Entry) (bool, error) {
+	localHash, err := s.cache.hash(s.config.LocalDir, l)
+	if err != nil {
+		return false, err
+	}
+	remoteHash, err := s.remote.hash(ctx, p)
+	if err != nil {
+		return false, err
+	}
+	return localHash == remoteHash, nil
+}
+
+// execute runs a single task and records its result
+func (s *Syncer) execute(ctx context.Context, task Task, rec *recorder) error {
+	s.logger.Debug("sync file",
+		zap.String("path", task.Path),
+		zap.String("action", string(task.Action)),
+	)
+
+	switch task.Action {
+	case ActionDownload:
+		l, err := s.download(ctx, task.Path, *task.Remote)
+		if err != nil {
+			return err
+		}
+		rec.downloaded(task.Path, *l, *task.Remote)
+	case ActionUpload:
+		r, err := s.upload(ctx, task)
+		if err != nil {
+			return err
+		}
+		rec.uploaded(task.Path, *task.Local, *r)
+	case ActionKeepBoth:
+		return s.keepBoth(ctx, task, rec)
+	case ActionCopy:
+		return s.copyFile(ctx, task, rec)
+	case ActionDelete:
+		if err := s.delete(ctx, task); err != nil {
+			return err
+		}
+		rec.deleted()
+	default:
+		return fmt.Errorf("unknown action: %s", task.Action)
+	}
+	return nil
+}
+
+// download fetches a remote file into the local directory and applies its
+// modification time and mode, existing local copies are updated by delta
+// transfer. Symbolic links are recreated as links
+func (s *Syncer) download(ctx context.Context, p string, r manifest.Entry) (*manifest.Entry, error) {
+	localPath := s.localPath(p)
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if r.IsLink() {
+		return s.createLink(p, r)
+	}
+	// Never write through a local link replaced by a file
+	if info, err := os.Lstat(localPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(localPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Size == 0 {
+		// Nothing to transfer, the downloader would skip empty files
+		if err := os.WriteFile(localPath, nil, 0644); err != nil {
+			return nil, err
+		}
+	} else {
+		_, statErr := os.Stat(localPath)
+		c := client.NewClient(&client.DownloadConfig{
+			URL:            s.remote.fileURL(p),
+			OutputPath:     localPath,
+			ChunkSize:      s.config.ChunkSize,
+			MaxConcurrency: s.config.MaxConcurrency,
+			RetryCount:     s.config.RetryCount,
+			EnableResume:   true,
+			AutoChunk:      true,
+			EnableDelta:    statErr == nil,
+			Username:       s.config.Username,
+			Password:       s.config.Password,
+		})
+		c.SetLogger(s.logger)
+		c.SetHTTPClient(s.remote.httpClient)
+		if err := c.Download(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyMetadata(localPath, r); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	l := manifest.NewEntry(p, info)
+	return &l, nil
+}
+
+// createLink replaces the local file of p by the symbolic link of r, which
+// must not lead out of the local directory through the links already in it
+func (s *Syncer) createLink(p string, r manifest.Entry) (*manifest.Entry, error) {
+	localPath := s.localPath(p)
+	inRoot, err := manifest.Entry{Path: p, Link: r.Link}.LinkInRoot(s.config.LocalDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve link: %w", err)
+	}
+	if !inRoot {
+		return nil, fmt.Errorf("link to %s leads outside the sync directory", r.Link)
+	}
+	if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := os.Symlink(filepath.FromSlash(r.Link), localPath); err != nil {
+		return nil, fmt.Errorf("failed to create link: %w", err)
+	}
+	info, err := os.Lstat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest.Entry{Path: p, ModTime: info.ModTime(), Link: r.Link}, nil
+}
+
+// applyMetadata sets the modification time and, where supported and known,
+// the mode of e on a local file
+func applyMetadata(localPath string, e manifest.Entry) error {
+	if e.Mode != 0 && runtime.GOOS != "windows" {
+		if err := os.Chmod(localPath, e.Mode.Perm()); err != nil {
+			return fmt.Errorf("failed to set mode: %w", err)
+		}
+	}
+	if err := os.Chtimes(localPath, e.ModTime, e.ModTime); err != nil {
+		return fmt.Errorf("failed to set modification time: %w", err)
+	}
+	return nil
+}
+
+// upload sends a local file to the server, existing remote copies are
+// updated by delta transfer
+func (s *Syncer) upload(ctx context.Context, task Task) (*manifest.Entry, error) {
+	if task.Local.IsLink() {
+		if err := s.remote.uploadLink(ctx, *task.Local); err != nil {
+			return nil, err
+		}
+		return task.Local, nil
+	}
+
+	localPath := s.localPath(task.Path)
+	// Files smaller than a block have nothing to reuse
+	if task.Remote != nil && !task.Remote.IsLink() && task.Remote.Size >= delta.MinBlockSize && task.Local.Size >= delta.MinBlockSize {
+		r, stats, err := s.remote.uploadDelta(ctx, localPath, task.Path)
+		if err == nil {
+			s.logger.Debug("delta uploaded",
+				zap.String("path", task.Path),
+				zap.Int64("copied", stats.CopiedBytes),
+				zap.Int64("literal", stats.LiteralBytes),
+			)
+			return r, nil
+		}
+		if !errors.Is(err, errDeltaUnsupported) {
+			return nil, err
+		}
+		s.logger.Info("delta upload not supported by server, uploading whole file",
+			zap.String("path", task.Path),
+		)
+	}
+	return s.remote.upload(ctx, localPath, task.Path)
+}
+
+// delete removes the file from the side it still exists on, moving it below
+// the backup directory if one is configured
+func (s *Syncer) delete(ctx context.Context, task Task) error {
+	if task.Local == nil {
+		return s.remote.delete(ctx, task.Path, s.config.BackupDir)
+	}
+
+	localPath := s.localPath(task.Path)
+	var err error
+	if s.config.BackupDir != "" {
+		backup := filepath.Join(s.config.LocalDir, s.config.BackupDir, filepath.FromSlash(task.Path))
+		if err = os.MkdirAll(filepath.Dir(backup), 0755); err == nil {
+			err = os.Rename(localPath, backup)
+		}
+	} else {
+		err = os.Remove(localPath)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	utils.RemoveEmptyDirs(s.config.LocalDir, filepath.Dir(localPath))
+	return nil
+}
+
+// splitDeletes separates deletions from the other tasks
+func splitDeletes(tasks []Task) (transfers, deletes []Task) {
+	for _, task := range tasks {
+		if task.Action == ActionDelete {
+			deletes = append(deletes, task)
+		} else {
+			transfers = append(transfers, task)
+		}
+	}
+	return transfers, deletes
+}
+
+func (s *Syncer) localPath(p string) string {
+	return filepath.Join(s.config.LocalDir, filepath.FromSlash(p))
+}
+
+// sameFile reports whether two entries describe the same file version, links
+// are compared by target and modes only when known on both sides
+func sameFile(a, b manifest.Entry) bool {
+	if a.IsLink() || b.IsLink() {
+		return a.Link == b.Link
+	}
+	if a.Mode != 0 && b.Mode != 0 && a.Mode != b.Mode {
+		return false
+	}
+	return a.Size == b.Size && a.ModTime.Equal(b.ModTime)
+}
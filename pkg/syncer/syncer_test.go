@@ -0,0 +1,771 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+)
+
+// testEnv a local directory and a remote directory served by an ezft server
+type testEnv struct {
+	local  string
+	remote string
+	url    string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	root := t.TempDir()
+	srv := server.NewServer(root, 0)
+	srv.SetLogger(zap.NewNop())
+	srv.SetUploadEnabled(true)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	remote := filepath.Join(root, "data")
+	if err := os.MkdirAll(remote, 0755); err != nil {
+		t.Fatalf("Failed to create remote directory: %v", err)
+	}
+
+	return &testEnv{
+		local:  filepath.Join(t.TempDir(), "local"),
+		remote: remote,
+		url:    ts.URL + "/data/",
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string, mtime time.Time) {
+	t.Helper()
+
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+}
+
+func readFile(t *testing.T, dir, name string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return string(content)
+}
+
+func (e *testEnv) run(t *testing.T, direction Direction, conflict ConflictStrategy) (*Report, error) {
+	t.Helper()
+
+	s, err := NewSyncer(&Config{
+		LocalDir:   e.local,
+		RemoteURL:  e.url,
+		Direction:  direction,
+		Conflict:   conflict,
+		ChunkSize:  1024,
+		RetryCount: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewSyncer() error = %v", err)
+	}
+	return s.Run(context.Background())
+}
+
+var (
+	t1 = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	t2 = time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	t3 = time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+)
+
+func TestNewSyncerInvalidConfig(t *testing.T) {
+	if _, err := NewSyncer(&Config{RemoteURL: "http://localhost/"}); err == nil {
+		t.Error("Expected error for missing local directory")
+	}
+	if _, err := NewSyncer(&Config{LocalDir: "x", RemoteURL: "ftp://localhost/"}); err == nil {
+		t.Error("Expected error for unsupported scheme")
+	}
+}
+
+func TestSyncPull(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.remote, "a.txt", "remote a", t1)
+	writeFile(t, env.remote, "dir/b.txt", strings.Repeat("b", 5000), t1)
+	writeFile(t, env.remote, "empty.txt", "", t1)
+
+	report, err := env.run(t, Pull, Abort)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Downloaded != 3 {
+		t.Errorf("Expected 3 downloads, got %d", report.Downloaded)
+	}
+	if got := readFile(t, env.local, "dir/b.txt"); got != strings.Repeat("b", 5000) {
+		t.Error("dir/b.txt content mismatch")
+	}
+	info, _ := os.Stat(filepath.Join(env.local, "a.txt"))
+	if !info.ModTime().Equal(t1) {
+		t.Errorf("Expected mtime %v, got %v", t1, info.ModTime())
+	}
+
+	// Second run has nothing to do
+	report, err = env.run(t, Pull, Abort)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Downloaded != 0 || report.Skipped != 3 {
+		t.Errorf("Expected no transfer, got %+v", report)
+	}
+
+	// Remote change is pulled, local-only files stay untouched
+	writeFile(t, env.remote, "a.txt", "remote a v2", t2)
+	writeFile(t, env.local, "local.txt", "local only", t2)
+	report, err = env.run(t, Pull, Abort)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Downloaded != 1 || report.Uploaded != 0 {
+		t.Errorf("Expected 1 download, got %+v", report)
+	}
+	if got := readFile(t, env.local, "a.txt"); got != "remote a v2" {
+		t.Errorf("Expected updated content, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(env.remote, "local.txt")); !os.IsNotExist(err) {
+		t.Error("Pull sync must not upload local files")
+	}
+}
+
+func TestSyncCredentialsFunc(t *testing.T) {
+	root := t.TempDir()
+	srv := server.NewServer(root, 0)
+	srv.SetLogger(zap.NewNop())
+	srv.SetUploadEnabled(true)
+	srv.SetAuth("user", "secret")
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	writeFile(t, root, "data/a.txt", "remote a", t1)
+	local := filepath.Join(t.TempDir(), "local")
+	writeFile(t, local, "b.txt", "local b", t1)
+
+	s, err := NewSyncer(&Config{LocalDir: local, RemoteURL: ts.URL + "/data/", Direction: Bidirectional, ChunkSize: 1024, RetryCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var asked int
+	s.SetCredentialsFunc(func(string) (string, string, error) {
+		asked++
+		return "user", "secret", nil
+	})
+	for range 2 {
+		if _, err := s.Run(context.Background()); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+	}
+	if asked != 1 {
+		t.Errorf("Credentials asked %d times, want 1", asked)
+	}
+	if got := readFile(t, local, "a.txt"); got != "remote a" {
+		t.Errorf("a.txt = %q", got)
+	}
+	if got := readFile(t, filepath.Join(root, "data"), "b.txt"); got != "local b" {
+		t.Errorf("Remote b.txt = %q", got)
+	}
+}
+
+func TestSyncBidirectional(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.remote, "remote.txt", "from remote", t1)
+	writeFile(t, env.local, "local.txt", "from local", t1)
+
+	report, err := env.run(t, Bidirectional, Abort)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Downloaded != 1 || report.Uploaded != 1 {
+		t.Errorf("Expected 1 download and 1 upload, got %+v", report)
+	}
+	if got := readFile(t, env.remote, "local.txt"); got != "from local" {
+		t.Errorf("Expected uploaded content, got %q", got)
+	}
+	if got := readFile(t, env.local, "remote.txt"); got != "from remote" {
+		t.Errorf("Expected downloaded content, got %q", got)
+	}
+
+	// One-sided changes are propagated without conflicts
+	writeFile(t, env.local, "local.txt", "local edit", t2)
+	writeFile(t, env.remote, "remote.txt", "remote edit", t2)
+	report, err = env.run(t, Bidirectional, Abort)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Downloaded != 1 || report.Uploaded != 1 || len(report.Conflicts) != 0 {
+		t.Errorf("Expected 1 download and 1 upload without conflicts, got %+v", report)
+	}
+	if got := readFile(t, env.remote, "local.txt"); got != "local edit" {
+		t.Errorf("Expected local edit on remote, got %q", got)
+	}
+	if got := readFile(t, env.local, "remote.txt"); got != "remote edit" {
+		t.Errorf("Expected remote edit locally, got %q", got)
+	}
+}
+
+// setupConflict syncs a file and then changes it on both sides
+func setupConflict(t *testing.T, env *testEnv) {
+	t.Helper()
+
+	writeFile(t, env.remote, "doc.txt", "original", t1)
+	if _, err := env.run(t, Bidirectional, Abort); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	writeFile(t, env.local, "doc.txt", "local version", t3)
+	writeFile(t, env.remote, "doc.txt", "remote version", t2)
+}
+
+func TestSyncConflictAbort(t *testing.T) {
+	env := newTestEnv(t)
+	setupConflict(t, env)
+
+	report, err := env.run(t, Bidirectional, Abort)
+	if !errors.Is(err, ErrConflicts) {
+		t.Fatalf("Expected ErrConflicts, got %v", err)
+	}
+	if len(report.Conflicts) != 1 || report.Conflicts[0].Resolution != ResolutionAborted {
+		t.Errorf("Unexpected conflicts: %+v", report.Conflicts)
+	}
+	if readFile(t, env.local, "doc.txt") != "local version" || readFile(t, env.remote, "doc.txt") != "remote version" {
+		t.Error("Abort must leave both sides untouched")
+	}
+
+	reportPath := filepath.Join(t.TempDir(), "conflicts.json")
+	if err := report.WriteConflictReport(reportPath); err != nil {
+		t.Fatalf("WriteConflictReport() error = %v", err)
+	}
+	data, _ := os.ReadFile(reportPath)
+	var conflicts []Conflict
+	if err := json.Unmarshal(data, &conflicts); err != nil {
+		t.Fatalf("Conflict report is not valid JSON: %v", err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Path != "doc.txt" || conflicts[0].Local.Size != int64(len("local version")) {
+		t.Errorf("Unexpected conflict report: %+v", conflicts)
+	}
+}
+
+func TestSyncConflictNewerWins(t *testing.T) {
+	env := newTestEnv(t)
+	setupConflict(t, env)
+
+	report, err := env.run(t, Bidirectional, NewerWins)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(report.Conflicts) != 1 || report.Conflicts[0].Resolution != ResolutionLocalWins {
+		t.Errorf("Unexpected conflicts: %+v", report.Conflicts)
+	}
+	if got := readFile(t, env.remote, "doc.txt"); got != "local version" {
+		t.Errorf("Expected newer local version on remote, got %q", got)
+	}
+}
+
+func TestSyncConflictKeepBoth(t *testing.T) {
+	env := newTestEnv(t)
+	setupConflict(t, env)
+
+	report, err := env.run(t, Bidirectional, KeepBoth)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(report.Conflicts) != 1 {
+		t.Fatalf("Expected 1 conflict, got %+v", report.Conflicts)
+	}
+	renamed := report.Conflicts[0].Renamed
+	if !strings.HasPrefix(renamed, "doc.conflict-") || !strings.HasSuffix(renamed, ".txt") {
+		t.Errorf("Unexpected renamed file: %s", renamed)
+	}
+
+	for _, dir := range []string{env.local, env.remote} {
+		if got := readFile(t, dir, "doc.txt"); got != "remote version" {
+			t.Errorf("Expected remote version in %s, got %q", dir, got)
+		}
+		if got := readFile(t, dir, renamed); got != "local version" {
+			t.Errorf("Expected local version as %s in %s, got %q", renamed, dir, got)
+		}
+	}
+
+	// Everything is in sync afterwards
+	report, err = env.run(t, Bidirectional, Abort)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Downloaded != 0 || report.Uploaded != 0 {
+		t.Errorf("Expected no transfer after conflict resolution, got %+v", report)
+	}
+}
+
+func TestSyncIdenticalChangesAreNotConflicts(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.remote, "same.txt", "same content", t1)
+	writeFile(t, env.local, "same.txt", "same content", t2)
+
+	report, err := env.run(t, Bidirectional, Abort)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(report.Conflicts) != 0 || report.Skipped != 1 {
+		t.Errorf("Expected identical file to be skipped, got %+v", report)
+	}
+}
+
+func TestConflictName(t *testing.T) {
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"report.pdf", "report.conflict-20250102-150405.pdf"},
+		{"dir/archive.tar.gz", "dir/archive.tar.conflict-20250102-150405.gz"},
+		{"dir/.bashrc", "dir/.bashrc.conflict-20250102-150405"},
+		{"README", "README.conflict-20250102-150405"},
+	}
+
+	for _, tt := range tests {
+		if got := conflictName(tt.input, now); got != tt.want {
+			t.Errorf("conflictName(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseConflictStrategy(t *testing.T) {
+	for _, name := range []string{"newer-wins", "keep-both", "abort"} {
+		if _, err := ParseConflictStrategy(name); err != nil {
+			t.Errorf("ParseConflictStrategy(%q) error = %v", name, err)
+		}
+	}
+	if _, err := ParseConflictStrategy("local-wins"); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.remote, "remote.txt", "from remote", t1)
+	writeFile(t, env.local, "local.txt", "local", t1)
+
+	s, err := NewSyncer(&Config{
+		LocalDir:  env.local,
+		RemoteURL: env.url,
+		Direction: Bidirectional,
+		DryRun:    true,
+	})
+	if err != nil {
+		t.Fatalf("NewSyncer() error = %v", err)
+	}
+
+	report, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(report.Plan) != 2 {
+		t.Fatalf("Expected 2 planned tasks, got %+v", report.Plan)
+	}
+	want := map[string]Action{"local.txt": ActionUpload, "remote.txt": ActionDownload}
+	for _, task := range report.Plan {
+		if want[task.Path] != task.Action {
+			t.Errorf("Expected %s for %s, got %s", want[task.Path], task.Path, task.Action)
+		}
+	}
+	if report.Plan[0].Bytes() != 5 || report.Plan[1].Bytes() != 11 {
+		t.Errorf("Unexpected byte totals: %d, %d", report.Plan[0].Bytes(), report.Plan[1].Bytes())
+	}
+
+	// Nothing is written on either side
+	if _, err := os.Stat(filepath.Join(env.local, "remote.txt")); !os.IsNotExist(err) {
+		t.Error("Dry run must not download files")
+	}
+	if _, err := os.Stat(filepath.Join(env.remote, "local.txt")); !os.IsNotExist(err) {
+		t.Error("Dry run must not upload files")
+	}
+	if _, err := os.Stat(filepath.Join(env.local, stateDir)); !os.IsNotExist(err) {
+		t.Error("Dry run must not write sync state")
+	}
+}
+
+func TestSyncDryRunMissingLocalDir(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.remote, "a.txt", "a", t1)
+
+	s, err := NewSyncer(&Config{LocalDir: env.local, RemoteURL: env.url, Direction: Pull, DryRun: true})
+	if err != nil {
+		t.Fatalf("NewSyncer() error = %v", err)
+	}
+	report, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(report.Plan) != 1 || report.Plan[0].Action != ActionDownload {
+		t.Errorf("Unexpected plan: %+v", report.Plan)
+	}
+	if _, err := os.Stat(env.local); !os.IsNotExist(err) {
+		t.Error("Dry run must not create the local directory")
+	}
+}
+
+func TestSyncPushDelta(t *testing.T) {
+	root := t.TempDir()
+	srv := server.NewServer(root, 0)
+	srv.SetLogger(zap.NewNop())
+	srv.SetUploadEnabled(true)
+	handler := srv.Handler()
+
+	// Count bytes of full uploads and delta uploads
+	var putBytes, patchBytes int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == "PUT":
+			putBytes += int64(len(body))
+		case strings.HasPrefix(r.URL.Path, "/_ezft/patch/"):
+			patchBytes += int64(len(body))
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	local := t.TempDir()
+	content := strings.Repeat("0123456789abcdef", 16*1024)
+	writeFile(t, local, "big.bin", content, t1)
+
+	run := func() *Report {
+		t.Helper()
+		s, err := NewSyncer(&Config{LocalDir: local, RemoteURL: ts.URL + "/data/", Direction: Push, Transfers: 1})
+		if err != nil {
+			t.Fatalf("NewSyncer() error = %v", err)
+		}
+		report, err := s.Run(context.Background())
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		return report
+	}
+
+	run()
+	if putBytes != int64(len(content)) {
+		t.Errorf("Expected full upload of %d bytes, got %d", len(content), putBytes)
+	}
+
+	updated := "prefix" + content
+	writeFile(t, local, "big.bin", updated, t2)
+	putBytes = 0
+	report := run()
+	if report.Uploaded != 1 {
+		t.Errorf("Expected 1 upload, got %+v", report)
+	}
+	if putBytes != 0 || patchBytes == 0 || patchBytes > int64(len(content))/10 {
+		t.Errorf("Expected small delta upload, got put %d bytes, patch %d bytes", putBytes, patchBytes)
+	}
+	if got := readFile(t, filepath.Join(root, "data"), "big.bin"); got != updated {
+		t.Error("Remote content mismatch after delta upload")
+	}
+	info, _ := os.Stat(filepath.Join(root, "data", "big.bin"))
+	if !info.ModTime().Equal(t2) {
+		t.Errorf("Expected mtime %v, got %v", t2, info.ModTime())
+	}
+}
+
+func TestSyncPushFilters(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.local, "app.tar.gz", "app", t1)
+	writeFile(t, env.local, "debug.log", "log", t1)
+	writeFile(t, env.local, "tmp/cache.tar.gz", "cache", t1)
+
+	u := strings.Replace(env.url, "http://", "ezft://", 1)
+	config := &Config{
+		LocalDir:  env.local,
+		RemoteURL: u,
+		Direction: Push,
+		Include:   []string{"*.tar.gz"},
+		Exclude:   []string{"tmp/"},
+		DryRun:    true,
+	}
+	s, err := NewSyncer(config)
+	if err != nil {
+		t.Fatalf("NewSyncer() error = %v", err)
+	}
+	report, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(report.Plan) != 1 || report.Plan[0].Path != "app.tar.gz" || report.Plan[0].Action != ActionUpload {
+		t.Errorf("Unexpected plan: %+v", report.Plan)
+	}
+
+	config.DryRun = false
+	s, _ = NewSyncer(config)
+	if report, err = s.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Uploaded != 1 {
+		t.Errorf("Expected 1 upload, got %+v", report)
+	}
+	for _, name := range []string{"debug.log", "tmp/cache.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(env.remote, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("Filtered file %s must not be uploaded", name)
+		}
+	}
+}
+
+func (e *testEnv) runConfig(t *testing.T, config Config) (*Report, error) {
+	t.Helper()
+
+	config.LocalDir = e.local
+	config.RemoteURL = e.url
+	s, err := NewSyncer(&config)
+	if err != nil {
+		t.Fatalf("NewSyncer() error = %v", err)
+	}
+	return s.Run(context.Background())
+}
+
+func exists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+	return err == nil
+}
+
+func TestSyncPullDelete(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.remote, "keep.txt", "keep", t1)
+	writeFile(t, env.local, "keep.txt", "keep", t1)
+	writeFile(t, env.local, "dir/stale.txt", "stale", t1)
+	writeFile(t, env.local, "gone.txt", "gone", t1)
+	writeFile(t, env.local, "app.log", "log", t1)
+
+	report, err := env.runConfig(t, Config{Direction: Pull, Delete: true, Exclude: []string{"*.log"}, BackupDir: "trash"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Deleted != 2 {
+		t.Errorf("Expected 2 deletions, got %+v", report)
+	}
+	if exists(env.local, "gone.txt") || exists(env.local, "dir") {
+		t.Error("Files missing from the source should be deleted along with empty directories")
+	}
+	if got := readFile(t, env.local, "trash/dir/stale.txt"); got != "stale" {
+		t.Errorf("Expected deleted file in backup directory, got %q", got)
+	}
+	if !exists(env.local, "app.log") || !exists(env.local, "keep.txt") {
+		t.Error("Excluded and unchanged files must be kept")
+	}
+
+	// The backup directory itself is not deleted or synced
+	report, err = env.runConfig(t, Config{Direction: Pull, Delete: true, Exclude: []string{"*.log"}, BackupDir: "trash"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Deleted != 0 || !exists(env.local, "trash/gone.txt") {
+		t.Errorf("Backup directory must be left alone, got %+v", report)
+	}
+}
+
+func TestSyncPushDelete(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.local, "keep.txt", "keep", t1)
+	writeFile(t, env.remote, "a/old.txt", "old", t1)
+	writeFile(t, env.remote, "b.txt", "b", t1)
+
+	report, err := env.runConfig(t, Config{Direction: Push, Delete: true, BackupDir: "trash"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Deleted != 2 || report.Uploaded != 1 {
+		t.Errorf("Expected 2 deletions and 1 upload, got %+v", report)
+	}
+	if exists(env.remote, "a") || exists(env.remote, "b.txt") {
+		t.Error("Remote files missing locally should be deleted")
+	}
+	if got := readFile(t, env.remote, "trash/a/old.txt"); got != "old" {
+		t.Errorf("Expected deleted file in remote backup directory, got %q", got)
+	}
+}
+
+func TestSyncMaxDelete(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.remote, "new.txt", "new", t1)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeFile(t, env.local, name, name, t1)
+	}
+
+	// Dry run reports the limit too
+	report, err := env.runConfig(t, Config{Direction: Pull, Delete: true, MaxDelete: 2, DryRun: true})
+	if !errors.Is(err, ErrTooManyDeletes) || report == nil || len(report.Plan) != 4 {
+		t.Fatalf("Expected ErrTooManyDeletes with plan, got %v", err)
+	}
+
+	report, err = env.runConfig(t, Config{Direction: Pull, Delete: true, MaxDelete: 2})
+	if !errors.Is(err, ErrTooManyDeletes) {
+		t.Fatalf("Expected ErrTooManyDeletes, got %v", err)
+	}
+	if report.Deleted != 0 || report.Downloaded != 1 {
+		t.Errorf("Expected transfers without deletions, got %+v", report)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if !exists(env.local, name) {
+			t.Errorf("%s must not be deleted", name)
+		}
+	}
+
+	if _, err := env.runConfig(t, Config{Direction: Pull, Delete: true, MaxDelete: 3}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if exists(env.local, "a.txt") {
+		t.Error("Deletions within the limit should run")
+	}
+}
+
+func TestSyncBidirectionalDelete(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.local, "deleted-locally.txt", "a", t1)
+	writeFile(t, env.local, "deleted-remotely.txt", "b", t1)
+	writeFile(t, env.local, "changed-remotely.txt", "c", t1)
+	config := Config{Direction: Bidirectional, Delete: true}
+	if _, err := env.runConfig(t, config); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	os.Remove(filepath.Join(env.local, "deleted-locally.txt"))
+	os.Remove(filepath.Join(env.remote, "deleted-remotely.txt"))
+	os.Remove(filepath.Join(env.local, "changed-remotely.txt"))
+	writeFile(t, env.remote, "changed-remotely.txt", "c v2", t2)
+
+	report, err := env.runConfig(t, config)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Deleted != 2 || report.Downloaded != 1 {
+		t.Errorf("Expected 2 deletions and 1 download, got %+v", report)
+	}
+	if exists(env.remote, "deleted-locally.txt") || exists(env.local, "deleted-remotely.txt") {
+		t.Error("Deletions should be propagated")
+	}
+	// A change wins over a deletion on the other side
+	if got := readFile(t, env.local, "changed-remotely.txt"); got != "c v2" {
+		t.Errorf("Expected changed file to be restored, got %q", got)
+	}
+}
+
+func TestNewSyncerInvalidBackupDir(t *testing.T) {
+	for _, dir := range []string{"../trash", "/tmp/trash"} {
+		if _, err := NewSyncer(&Config{LocalDir: "x", RemoteURL: "http://localhost/", BackupDir: dir}); err == nil {
+			t.Errorf("Expected error for backup directory %s", dir)
+		}
+	}
+}
+
+func TestSyncModesAndLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX modes and symbolic links are not supported")
+	}
+	env := newTestEnv(t)
+	writeFile(t, env.local, "bin/run.sh", "#!/bin/sh", t1)
+	if err := os.Chmod(filepath.Join(env.local, "bin", "run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"run": "bin/run.sh", "bin/self": "run.sh", "passwd": "/etc/passwd"} {
+		if err := os.Symlink(target, filepath.Join(env.local, filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := env.runConfig(t, Config{Direction: Push})
+	if err != nil {
+		t.Fatalf("Push error = %v", err)
+	}
+	if report.Uploaded != 3 {
+		t.Errorf("Expected 3 uploads, got %+v", report)
+	}
+	if info, _ := os.Stat(filepath.Join(env.remote, "bin", "run.sh")); info.Mode().Perm() != 0755 {
+		t.Errorf("Expected remote mode 0755, got %o", info.Mode().Perm())
+	}
+	if target, _ := os.Readlink(filepath.Join(env.remote, "run")); target != "bin/run.sh" {
+		t.Errorf("Expected remote link to bin/run.sh, got %q", target)
+	}
+	if _, err := os.Lstat(filepath.Join(env.remote, "passwd")); !os.IsNotExist(err) {
+		t.Error("Links pointing outside the sync directory must not be synced")
+	}
+
+	// A mode change alone is propagated
+	if err := os.Chmod(filepath.Join(env.local, "bin", "run.sh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = env.runConfig(t, Config{Direction: Push}); err != nil || report.Uploaded != 1 {
+		t.Fatalf("Expected 1 upload, got %+v, %v", report, err)
+	}
+	if info, _ := os.Stat(filepath.Join(env.remote, "bin", "run.sh")); info.Mode().Perm() != 0700 {
+		t.Errorf("Expected remote mode 0700, got %o", info.Mode().Perm())
+	}
+
+	// Pulling into another directory recreates modes, mtimes and links
+	env.local = filepath.Join(t.TempDir(), "copy")
+	if _, err := env.runConfig(t, Config{Direction: Pull}); err != nil {
+		t.Fatalf("Pull error = %v", err)
+	}
+	info, err := os.Stat(filepath.Join(env.local, "bin", "run.sh"))
+	if err != nil || info.Mode().Perm() != 0700 || !info.ModTime().Equal(t1) {
+		t.Errorf("Expected pulled file with mode 0700 and mtime %v, got %v", t1, info)
+	}
+	for name, want := range map[string]string{"run": "bin/run.sh", "bin/self": "run.sh"} {
+		if target, err := os.Readlink(filepath.Join(env.local, filepath.FromSlash(name))); err != nil || target != want {
+			t.Errorf("Readlink(%s) = %q, %v, want %s", name, target, err, want)
+		}
+	}
+	if report, err = env.runConfig(t, Config{Direction: Pull}); err != nil || report.Skipped != 3 {
+		t.Errorf("Expected everything in sync, got %+v, %v", report, err)
+	}
+}
+
+func TestSyncHostileManifest(t *testing.T) {
+	for _, p := range []string{"../escape.txt", "a/../../escape.txt", "/tmp/escape.txt", "a//b.txt", "", ".ezft/state.json", "a/b.txt.ezft.token"} {
+		t.Run(p, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.URL.Path, apiPrefix+"manifest/") {
+					json.NewEncoder(w).Encode(map[string]any{"entries": []map[string]any{{"path": p, "size": 4, "mtime": t1}}})
+					return
+				}
+				io.WriteString(w, "evil")
+			}))
+			defer ts.Close()
+
+			parent := t.TempDir()
+			local := filepath.Join(parent, "local")
+			s, err := NewSyncer(&Config{LocalDir: local, RemoteURL: ts.URL + "/data/", Direction: Pull, RetryCount: 1})
+			if err != nil {
+				t.Fatalf("NewSyncer() error = %v", err)
+			}
+			if _, err := s.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid path") {
+				t.Errorf("Run() error = %v, want the manifest rejected", err)
+			}
+			if exists(parent, "escape.txt") {
+				t.Error("Expected no file written outside the local directory")
+			}
+		})
+	}
+}
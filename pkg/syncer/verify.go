@@ -0,0 +1,134 @@
+package syncer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/utils"
+)
+
+// ErrVerifyFailed is returned when a transferred file differs between both sides after the sync
+var ErrVerifyFailed = errors.New("verification failed")
+
+// VerifiedFile result of comparing a transferred file on both sides
+type VerifiedFile struct {
+	Path       string `json:"path"`
+	LocalHash  string `json:"local_hash,omitempty"`
+	RemoteHash string `json:"remote_hash,omitempty"`
+	Match      bool   `json:"match"`
+	Error      string `json:"error,omitempty"` // Why a hash could not be computed
+}
+
+// Verification report of the post-sync verification pass
+type Verification struct {
+	Remote     string         `json:"remote"`
+	LocalDir   string         `json:"local_dir"`
+	Time       time.Time      `json:"time"`
+	Algorithm  string         `json:"algorithm"`
+	Files      []VerifiedFile `json:"files"`
+	Mismatches int            `json:"mismatches"`          // Files differing or failing to hash
+	Signature  string         `json:"signature,omitempty"` // Hex HMAC-SHA256 of the report without signature
+}
+
+// verify hashes the files written by the run on both sides
+func (s *Syncer) verify(ctx context.Context, paths []string) *Verification {
+	sort.Strings(paths)
+	v := &Verification{
+		Remote:    s.remote.base.String(),
+		LocalDir:  s.config.LocalDir,
+		Time:      time.Now().UTC().Truncate(time.Second),
+		Algorithm: "sha256",
+		Files:     make([]VerifiedFile, len(paths)),
+	}
+
+	// Indexes are handed out to Transfers workers, each filling its own slots
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(s.config.Transfers, len(paths)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				v.Files[i] = s.verifyFile(ctx, paths[i])
+			}
+		}()
+	}
+	for i := range paths {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, f := range v.Files {
+		if !f.Match {
+			v.Mismatches++
+		}
+	}
+	return v
+}
+
+func (s *Syncer) verifyFile(ctx context.Context, p string) VerifiedFile {
+	f := VerifiedFile{Path: p}
+
+	var err error
+	if f.LocalHash, err = utils.CalculateFileHash(s.localPath(p), "sha256"); err != nil {
+		f.Error = fmt.Sprintf("local: %v", err)
+		return f
+	}
+	if f.RemoteHash, err = s.remote.hash(ctx, p); err != nil {
+		f.Error = fmt.Sprintf("remote: %v", err)
+		return f
+	}
+	f.Match = f.LocalHash == f.RemoteHash
+	return f
+}
+
+// Sign sets the signature of the report to its HMAC-SHA256 under key
+func (v *Verification) Sign(key []byte) error {
+	mac, err := v.mac(key)
+	if err != nil {
+		return err
+	}
+	v.Signature = hex.EncodeToString(mac)
+	return nil
+}
+
+// CheckSignature reports whether the report is unmodified since it was signed with key
+func (v *Verification) CheckSignature(key []byte) bool {
+	signature, err := hex.DecodeString(v.Signature)
+	if err != nil {
+		return false
+	}
+	mac, err := v.mac(key)
+	return err == nil && hmac.Equal(signature, mac)
+}
+
+func (v *Verification) mac(key []byte) ([]byte, error) {
+	unsigned := *v
+	unsigned.Signature = ""
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize verification report: %w", err)
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// Write writes the report as JSON
+func (v *Verification) Write(path string) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize verification report: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,72 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSyncVerify(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.local, "a.txt", "aaa", t1)
+	writeFile(t, env.local, "dir/b.txt", "bbb", t1)
+
+	report, err := env.runConfig(t, Config{Direction: Push, Verify: true})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	v := report.Verification
+	if v == nil || len(v.Files) != 2 || v.Mismatches != 0 {
+		t.Fatalf("Expected 2 matching files, got %+v", v)
+	}
+	if v.Files[0].Path != "a.txt" || !v.Files[0].Match || v.Files[0].LocalHash == "" {
+		t.Errorf("Unexpected result %+v", v.Files[0])
+	}
+
+	// Nothing transferred, nothing verified
+	report, err = env.runConfig(t, Config{Direction: Push, Verify: true})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(report.Verification.Files) != 0 {
+		t.Errorf("Expected no verified files, got %+v", report.Verification.Files)
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.local, "a.txt", "aaa", t1)
+	writeFile(t, env.remote, "a.txt", "aab", t1)
+
+	s, err := NewSyncer(&Config{LocalDir: env.local, RemoteURL: env.url})
+	if err != nil {
+		t.Fatalf("NewSyncer() error = %v", err)
+	}
+	v := s.verify(context.Background(), []string{"missing.txt", "a.txt"})
+	if v.Mismatches != 2 {
+		t.Fatalf("Expected 2 mismatches, got %+v", v)
+	}
+	if f := v.Files[0]; f.Path != "a.txt" || f.Match || f.LocalHash == f.RemoteHash {
+		t.Errorf("Unexpected result %+v", f)
+	}
+	if f := v.Files[1]; f.Path != "missing.txt" || f.Error == "" {
+		t.Errorf("Expected an error for missing.txt, got %+v", f)
+	}
+}
+
+func TestVerificationSignature(t *testing.T) {
+	v := &Verification{Remote: "http://host/data/", Algorithm: "sha256", Files: []VerifiedFile{{Path: "a.txt", LocalHash: "00", RemoteHash: "00", Match: true}}}
+	if err := v.Sign([]byte("secret")); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if v.Signature == "" || !v.CheckSignature([]byte("secret")) {
+		t.Fatal("Expected a valid signature")
+	}
+	if v.CheckSignature([]byte("other")) {
+		t.Error("Signature must not check with another key")
+	}
+
+	v.Files[0].RemoteHash = "01"
+	if v.CheckSignature([]byte("secret")) {
+		t.Error("Signature must not check after the report was modified")
+	}
+}
@@ -0,0 +1,205 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultDebounce default quiet period after a local change before syncing
+	DefaultDebounce = 2 * time.Second
+	// DefaultPollInterval default interval of remote manifest polling
+	DefaultPollInterval = 10 * time.Second
+	// maxBatchDebounces bounds how long continuous local changes delay a sync,
+	// in multiples of the debounce period
+	maxBatchDebounces = 10
+)
+
+// Watch syncs once, then keeps syncing until ctx is cancelled. Local changes
+// are picked up by filesystem notifications and batched until no change
+// happened for the debounce period, remote changes by polling the manifest.
+// onRun is called with the result of every run, a failed run does not stop
+// watching
+func (s *Syncer) Watch(ctx context.Context, onRun func(*Report, error)) error {
+	if s.config.DryRun {
+		return fmt.Errorf("watch mode cannot be combined with dry run")
+	}
+
+	run := func() {
+		report, err := s.Run(ctx)
+		if ctx.Err() == nil {
+			onRun(report, err)
+		}
+	}
+	run()
+
+	var changes <-chan struct{}
+	if s.config.Direction != Pull {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return fmt.Errorf("failed to create file watcher: %w", err)
+		}
+		defer watcher.Close()
+
+		if err := s.watchTree(watcher, s.config.LocalDir); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", s.config.LocalDir, err)
+		}
+		changes = s.localChanges(ctx, watcher)
+	}
+
+	var poll <-chan time.Time
+	if s.config.Direction != Push {
+		ticker := time.NewTicker(s.config.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	debounce := time.NewTimer(0)
+	if !debounce.Stop() {
+		<-debounce.C
+	}
+	var batchStart time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changes:
+			if batchStart.IsZero() {
+				batchStart = time.Now()
+			}
+			// Keep extending the quiet period, but not beyond the batch limit
+			wait := s.config.Debounce
+			if remaining := time.Until(batchStart.Add(maxBatchDebounces * s.config.Debounce)); remaining < wait {
+				wait = max(remaining, 0)
+			}
+			debounce.Reset(wait)
+		case <-debounce.C:
+			batchStart = time.Time{}
+			run()
+		case <-poll:
+			changed, err := s.remoteChanged(ctx)
+			if err != nil {
+				s.logger.Warn("failed to poll remote manifest",
+					zap.Error(err),
+				)
+				continue
+			}
+			if changed {
+				run()
+			}
+		}
+	}
+}
+
+// remoteChanged reports whether the remote manifest differs from the one the
+// last run was planned against
+func (s *Syncer) remoteChanged(ctx context.Context) (bool, error) {
+	m, err := s.remote.manifest(ctx, s.config.Direction != Pull)
+	if err != nil {
+		return false, err
+	}
+	if s.lastRemote == nil || len(m.Entries) != len(s.lastRemote.Entries) {
+		return true, nil
+	}
+	for i, e := range m.Entries {
+		last := s.lastRemote.Entries[i]
+		if e.Path != last.Path || !sameFile(e, last) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// watchTree adds dir and all directories below it to the watcher
+func (s *Syncer) watchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories may disappear while walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p != s.config.LocalDir && s.ignoredPath(p) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(p)
+	})
+}
+
+// localChanges forwards relevant watcher events, coalescing them into a
+// single pending signal
+func (s *Syncer) localChanges(ctx context.Context, watcher *fsnotify.Watcher) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				s.logger.Warn("file watcher error",
+					zap.Error(err),
+				)
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod || s.ignoredPath(event.Name) {
+					continue
+				}
+				if event.Has(fsnotify.Create) {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						if err := s.watchTree(watcher, event.Name); err != nil {
+							s.logger.Warn("failed to watch directory",
+								zap.String("path", event.Name),
+								zap.Error(err),
+							)
+						}
+					}
+				}
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes
+}
+
+// ignoredPath reports whether changes of a local path never need a sync:
+// ezft bookkeeping, temporary files and the backup directory
+func (s *Syncer) ignoredPath(p string) bool {
+	rel, err := filepath.Rel(s.config.LocalDir, p)
+	if err != nil || !filepath.IsLocal(rel) {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+	for _, name := range strings.Split(rel, "/") {
+		if manifest.IsInternal(name) {
+			return true
+		}
+	}
+	for _, dir := range s.filter.ignoreDirs {
+		if rel == dir || strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package syncer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func startWatch(t *testing.T, config *Config) (runs func() int) {
+	t.Helper()
+
+	s, err := NewSyncer(config)
+	if err != nil {
+		t.Fatalf("NewSyncer() error = %v", err)
+	}
+
+	var mu sync.Mutex
+	count := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Watch(ctx, func(report *Report, err error) {
+			if err != nil {
+				t.Errorf("Sync run failed: %v", err)
+			}
+			mu.Lock()
+			count++
+			mu.Unlock()
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Watch() error = %v", err)
+		}
+	})
+
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+}
+
+func TestWatchPush(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.local, "initial.txt", "initial", t1)
+
+	runs := startWatch(t, &Config{
+		LocalDir:  env.local,
+		RemoteURL: env.url,
+		Direction: Push,
+		Debounce:  50 * time.Millisecond,
+	})
+	if !waitFor(t, 5*time.Second, func() bool { return exists(env.remote, "initial.txt") }) {
+		t.Fatal("Initial sync did not upload existing files")
+	}
+
+	// A burst of changes, including a new directory, is batched into few runs
+	for _, name := range []string{"a.txt", "b.txt", "dir/c.txt"} {
+		writeFile(t, env.local, name, name, t2)
+	}
+	if !waitFor(t, 5*time.Second, func() bool { return exists(env.remote, "dir/c.txt") && exists(env.remote, "a.txt") }) {
+		t.Fatal("Local changes were not pushed")
+	}
+	writeFile(t, env.local, "dir/d.txt", "d", t2)
+	if !waitFor(t, 5*time.Second, func() bool { return exists(env.remote, "dir/d.txt") }) {
+		t.Fatal("Changes in a new directory were not pushed")
+	}
+
+	// The sync state written by runs does not trigger further runs
+	time.Sleep(300 * time.Millisecond)
+	settled := runs()
+	time.Sleep(300 * time.Millisecond)
+	if runs() != settled {
+		t.Errorf("Expected no runs without changes, got %d more", runs()-settled)
+	}
+}
+
+func TestWatchPull(t *testing.T) {
+	env := newTestEnv(t)
+
+	startWatch(t, &Config{
+		LocalDir:     env.local,
+		RemoteURL:    env.url,
+		Direction:    Pull,
+		PollInterval: 50 * time.Millisecond,
+	})
+
+	writeFile(t, env.remote, "new.txt", "new", t1)
+	if !waitFor(t, 5*time.Second, func() bool { return exists(env.local, "new.txt") }) {
+		t.Fatal("Remote changes were not pulled")
+	}
+}
+
+func TestWatchIgnoredPath(t *testing.T) {
+	s, err := NewSyncer(&Config{LocalDir: "/data", RemoteURL: "http://localhost/", BackupDir: "trash"})
+	if err != nil {
+		t.Fatalf("NewSyncer() error = %v", err)
+	}
+
+	tests := map[string]bool{
+		"/data/a.txt":                    false,
+		"/data/dir/b.txt":                false,
+		"/data/.ezft/sync-state.json":    true,
+		"/data/dir/.ezft-copy-123":       true,
+		"/data/file.bin.ezft.tmp":        true,
+		"/data/trash/a.txt":              true,
+		"/data/trash":                    true,
+		filepath.Join(os.TempDir(), "x"): true,
+	}
+	for p, want := range tests {
+		if got := s.ignoredPath(p); got != want {
+			t.Errorf("ignoredPath(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
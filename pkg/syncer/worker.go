@@ -0,0 +1,129 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/easzlab/ezft/pkg/manifest"
+	"go.uber.org/zap"
+)
+
+// DefaultTransfers default number of files transferred in parallel
+const DefaultTransfers = 4
+
+// recorder collects task results from concurrent workers
+type recorder struct {
+	mu      sync.Mutex
+	next    *syncState
+	report  *Report
+	journal *journal // Session journal, nil if progress is not recorded
+	written []string // Paths of the files written by tasks
+}
+
+// synced records the baseline of a file written by a task
+func (r *recorder) synced(p string, local, remote manifest.Entry) {
+	state := fileState{Local: local, Remote: remote}
+	r.next.Files[p] = state
+	if !local.IsLink() {
+		r.written = append(r.written, p)
+	}
+	r.journal.write(journalEntry{Path: p, State: &state})
+}
+
+func (r *recorder) downloaded(p string, local, remote manifest.Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.synced(p, local, remote)
+	r.report.Downloaded++
+	r.report.Bytes += remote.Size
+}
+
+func (r *recorder) uploaded(p string, local, remote manifest.Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.synced(p, local, remote)
+	r.report.Uploaded++
+	r.report.Bytes += local.Size
+}
+
+func (r *recorder) copied(p string, local, remote manifest.Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.synced(p, local, remote)
+	r.report.Copied++
+}
+
+func (r *recorder) deleted() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.report.Deleted++
+}
+
+// done records the completion of the task of p
+func (r *recorder) done(p string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.journal.write(journalEntry{Path: p, Done: true})
+}
+
+// runTasks executes tasks with a pool of Transfers workers, a failed task
+// does not stop the others
+func (s *Syncer) runTasks(ctx context.Context, tasks []Task, rec *recorder) error {
+	queue := make(chan Task)
+	var wg sync.WaitGroup
+
+	var errMutex sync.Mutex
+	var firstErr error
+	failed := 0
+
+	workers := min(s.config.Transfers, len(tasks))
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				if err := s.execute(ctx, task, rec); err != nil {
+					s.logger.Error("sync task failed",
+						zap.String("path", task.Path),
+						zap.String("action", string(task.Action)),
+						zap.Error(err),
+					)
+
+					errMutex.Lock()
+					failed++
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to %s %s: %w", task.Action, task.Path, err)
+					}
+					errMutex.Unlock()
+					continue
+				}
+				rec.done(task.Path)
+			}
+		}()
+	}
+
+feed:
+	for _, task := range tasks {
+		select {
+		case queue <- task:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if ctx.Err() != nil && firstErr == nil {
+		return ctx.Err()
+	}
+	if failed > 1 {
+		return fmt.Errorf("%d of %d files failed, first error: %w", failed, len(tasks), firstErr)
+	}
+	return firstErr
+}
@@ -0,0 +1,105 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+)
+
+func TestSyncParallelTransfers(t *testing.T) {
+	root := t.TempDir()
+	remoteDir := filepath.Join(root, "data")
+	for i := 0; i < 20; i++ {
+		writeFile(t, remoteDir, fmt.Sprintf("f%02d.txt", i), strings.Repeat("x", 100+i), t1)
+	}
+
+	srv := server.NewServer(root, 0)
+	srv.SetLogger(zap.NewNop())
+	handler := srv.Handler()
+
+	// Track concurrent file requests and new connections
+	var inFlight, maxInFlight, conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && strings.HasSuffix(r.URL.Path, ".txt") {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				m := atomic.LoadInt32(&maxInFlight)
+				if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	s, err := NewSyncer(&Config{
+		LocalDir:   filepath.Join(t.TempDir(), "local"),
+		RemoteURL:  ts.URL + "/data/",
+		Direction:  Pull,
+		Transfers:  4,
+		RetryCount: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewSyncer() error = %v", err)
+	}
+
+	report, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Downloaded != 20 {
+		t.Errorf("Expected 20 downloads, got %d", report.Downloaded)
+	}
+	if maxInFlight < 2 || maxInFlight > 4 {
+		t.Errorf("Expected between 2 and 4 parallel transfers, got %d", maxInFlight)
+	}
+	// Each download issues several requests, reused connections keep this close to the transfer count
+	if conns > 8 {
+		t.Errorf("Expected connections to be reused, got %d new connections", conns)
+	}
+}
+
+func TestSyncParallelTransfersPartialFailure(t *testing.T) {
+	env := newTestEnv(t)
+	writeFile(t, env.local, "ok1.txt", "ok", t1)
+	writeFile(t, env.local, "ok2.txt", "ok", t1)
+
+	// Uploads are rejected by a server without upload support
+	srv := server.NewServer(filepath.Dir(env.remote), 0)
+	srv.SetLogger(zap.NewNop())
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	s, err := NewSyncer(&Config{LocalDir: env.local, RemoteURL: ts.URL + "/data/", Direction: Push, Transfers: 2})
+	if err != nil {
+		t.Fatalf("NewSyncer() error = %v", err)
+	}
+
+	_, err = s.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "2 of 2 files failed") {
+		t.Errorf("Expected aggregated error, got %v", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(env.local, stateDir, stateFile)); statErr != nil {
+		t.Errorf("Sync state should be saved after failures: %v", statErr)
+	}
+}
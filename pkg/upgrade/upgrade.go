@@ -0,0 +1,98 @@
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment of a process started by an upgrade
+const (
+	envListeners = "EZFT_UPGRADE_LISTENERS" // Names of the inherited listeners, comma separated, from fd 3 on
+	envHandoff   = "EZFT_UPGRADE_HANDOFF"   // Descriptors of the ready and handoff pipes, comma separated
+)
+
+// ReadyTimeout how long a new process may take until it is ready to take over
+const ReadyTimeout = 30 * time.Second
+
+// ErrUnsupported is returned by Start on platforms that cannot pass listeners
+// to a child process
+var ErrUnsupported = errors.New("upgrades are not supported on this platform")
+
+// Upgraded reports whether the process was started by an upgrade
+func Upgraded() bool {
+	return os.Getenv(envHandoff) != ""
+}
+
+// Listen returns the listener named name inherited from the previous process,
+// or a new TCP listener on addr
+func Listen(name, addr string) (net.Listener, error) {
+	names := strings.Split(os.Getenv(envListeners), ",")
+	if i := slices.Index(names, name); i >= 0 && Upgraded() {
+		f := os.NewFile(uintptr(3+i), name)
+		defer f.Close()
+		lis, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inherit %s listener: %w", name, err)
+		}
+		return lis, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Ready tells the previous process that this one started and waits until the
+// previous one stopped its work, call it after Listen. It returns at once
+// unless the process was started by an upgrade.
+func Ready() error {
+	if !Upgraded() {
+		return nil
+	}
+	var ready, handoff int
+	if _, err := fmt.Sscanf(os.Getenv(envHandoff), "%d,%d", &ready, &handoff); err != nil {
+		return fmt.Errorf("invalid %s: %w", envHandoff, err)
+	}
+	readyPipe := os.NewFile(uintptr(ready), "ready")
+	handoffPipe := os.NewFile(uintptr(handoff), "handoff")
+	defer handoffPipe.Close()
+
+	_, err := readyPipe.Write([]byte{1})
+	readyPipe.Close()
+	if err != nil {
+		return fmt.Errorf("failed to signal readiness: %w", err)
+	}
+	// The previous process closes the pipe once it stopped, or exited
+	io.Copy(io.Discard, handoffPipe)
+
+	os.Unsetenv(envListeners)
+	os.Unsetenv(envHandoff)
+	return nil
+}
+
+// Child a process started by an upgrade, waiting to take over
+type Child struct {
+	Pid     int
+	handoff *os.File
+}
+
+// Handoff lets the child take over, once this process stopped its work
+func (c *Child) Handoff() error {
+	return c.handoff.Close()
+}
+
+// env returns the environment of a child inheriting the listeners names, with
+// its pipes at the descriptors following them
+func env(names []string) []string {
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, envListeners+"=") || strings.HasPrefix(kv, envHandoff+"=")
+	})
+	return append(env,
+		envListeners+"="+strings.Join(names, ","),
+		envHandoff+"="+strconv.Itoa(3+len(names))+","+strconv.Itoa(4+len(names)),
+	)
+}
@@ -0,0 +1,91 @@
+//go:build !windows
+
+package upgrade
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestMain runs the child of TestUpgrade, which serves its pid on the
+// inherited listener once the parent handed over
+func TestMain(m *testing.M) {
+	if Upgraded() {
+		lis, err := Listen("api", "")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := Ready(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, os.Getpid())
+		})}
+		go srv.Serve(lis)
+		time.Sleep(time.Second)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestUpgrade(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+
+	child, err := Start(map[string]net.Listener{"api": lis})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if child.Pid == os.Getpid() {
+		t.Fatal("Expected a new process")
+	}
+
+	// Connections made while handing over wait for the child
+	lis.Close()
+	done := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr)
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		done <- string(body)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := child.Handoff(); err != nil {
+		t.Fatalf("Handoff() error = %v", err)
+	}
+
+	select {
+	case body := <-done:
+		if body != strconv.Itoa(child.Pid) {
+			t.Errorf("Expected the child %d to answer, got %q", child.Pid, body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The child did not answer")
+	}
+}
+
+func TestListen(t *testing.T) {
+	lis, err := Listen("api", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	lis.Close()
+	if err := Ready(); err != nil {
+		t.Errorf("Ready() error = %v without upgrade", err)
+	}
+}
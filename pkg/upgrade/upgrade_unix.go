@@ -0,0 +1,90 @@
+//go:build !windows
+
+package upgrade
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// Notify relays the upgrade signal, SIGUSR2, to c
+func Notify(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// Start runs the current executable again with the same arguments, passing it
+// the listeners by name. It returns once the new process is ready to take
+// over, which it does after Handoff, or an error if it exited or did not get
+// ready within ReadyTimeout.
+func Start(listeners map[string]net.Listener) (*Child, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range names {
+		fl, ok := listeners[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("cannot pass %s listener", name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, fmt.Errorf("failed to pass %s listener: %w", name, err)
+		}
+		files = append(files, f)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+	handoffR, handoffW, err := os.Pipe()
+	if err != nil {
+		readyW.Close()
+		return nil, err
+	}
+	files = append(files, readyW, handoffR)
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = env(names)
+	if err := cmd.Start(); err != nil {
+		handoffW.Close()
+		return nil, fmt.Errorf("failed to start %s: %w", path, err)
+	}
+	go cmd.Wait()
+	// Only the child keeps its ends of the pipes open, so a child that exits
+	// early ends the read below
+	readyW.Close()
+	handoffR.Close()
+	files = files[:len(names)]
+
+	readyR.SetReadDeadline(time.Now().Add(ReadyTimeout))
+	if _, err := readyR.Read(make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		handoffW.Close()
+		return nil, fmt.Errorf("new process did not get ready: %w", err)
+	}
+	return &Child{Pid: cmd.Process.Pid, handoff: handoffW}, nil
+}
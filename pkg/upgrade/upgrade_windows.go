@@ -0,0 +1,16 @@
+//go:build windows
+
+package upgrade
+
+import (
+	"net"
+	"os"
+)
+
+// Notify does nothing, Windows has no upgrade signal
+func Notify(c chan<- os.Signal) {}
+
+// Start returns ErrUnsupported, Windows processes cannot inherit listeners
+func Start(listeners map[string]net.Listener) (*Child, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Sizes of the buffers of the pool, powers of two from 32KB to 2MB
+const (
+	minBufferShift = 15
+	maxBufferShift = 21
+)
+
+// bufferPools pools of the buffers of each size, shared by the process
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+// bufferClass returns the index of the pool of the smallest buffers holding
+// size bytes, false if they are larger than the largest
+func bufferClass(size int) (int, bool) {
+	shift := max(bits.Len(uint(max(size, 1)-1)), minBufferShift)
+	return shift - minBufferShift, shift <= maxBufferShift
+}
+
+// GetBuffer returns a buffer of size bytes, taken from a process-wide pool
+// up to 2MB, to give back with PutBuffer once no longer used
+func GetBuffer(size int) *[]byte {
+	class, ok := bufferClass(size)
+	if !ok {
+		buf := make([]byte, size)
+		return &buf
+	}
+	if p, ok := bufferPools[class].Get().(*[]byte); ok {
+		*p = (*p)[:size]
+		return p
+	}
+	buf := make([]byte, size, 1<<(class+minBufferShift))
+	return &buf
+}
+
+// PutBuffer gives back a buffer of GetBuffer to the pool
+func PutBuffer(buf *[]byte) {
+	class, ok := bufferClass(cap(*buf))
+	if !ok || cap(*buf) != 1<<(class+minBufferShift) {
+		return
+	}
+	bufferPools[class].Put(buf)
+}
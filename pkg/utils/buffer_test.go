@@ -0,0 +1,33 @@
+package utils
+
+import "testing"
+
+func TestBuffer(t *testing.T) {
+	tests := []struct {
+		size    int
+		wantCap int
+	}{
+		{1, 32 * 1024},
+		{32 * 1024, 32 * 1024},
+		{32*1024 + 1, 64 * 1024},
+		{1024 * 1024, 1024 * 1024},
+		{2 * 1024 * 1024, 2 * 1024 * 1024},
+		{3 * 1024 * 1024, 3 * 1024 * 1024},
+	}
+	for _, tt := range tests {
+		buf := GetBuffer(tt.size)
+		if len(*buf) != tt.size || cap(*buf) != tt.wantCap {
+			t.Errorf("GetBuffer(%d) = len %d cap %d, want cap %d", tt.size, len(*buf), cap(*buf), tt.wantCap)
+		}
+		PutBuffer(buf)
+	}
+
+	// Buffers given back are reused for sizes of the same class
+	allocs := testing.AllocsPerRun(100, func() {
+		buf := GetBuffer(100 * 1024)
+		PutBuffer(buf)
+	})
+	if allocs > 0 {
+		t.Errorf("GetBuffer() allocated %v times per buffer reused", allocs)
+	}
+}
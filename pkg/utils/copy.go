@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"io"
+	"os"
+)
+
+// CopyFile copies src to dst, both local files, from their current offsets.
+// From the start of src to an empty dst, the blocks of src are shared by a
+// reflink on filesystems supporting it, such as btrfs and XFS. Otherwise the
+// kernel copies them with copy_file_range or splice where available, which
+// io.Copy uses between files, without going through userland buffers.
+func CopyFile(dst, src *os.File) (int64, error) {
+	if n, ok := reflink(dst, src); ok {
+		return n, nil
+	}
+	return io.Copy(dst, src)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package utils
+
+import "os"
+
+// reflink is only supported on Linux
+func reflink(dst, src *os.File) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	if err := os.WriteFile(filepath.Join(dir, "src"), data, 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64 // Offset of the source to copy from
+	}{
+		{"whole_file", 0},
+		{"from_offset", 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := os.Open(filepath.Join(dir, "src"))
+			if err != nil {
+				t.Fatalf("Failed to open source file: %v", err)
+			}
+			defer src.Close()
+			if _, err := src.Seek(tt.offset, io.SeekStart); err != nil {
+				t.Fatalf("Seek() error = %v", err)
+			}
+			dst, err := os.Create(filepath.Join(dir, tt.name))
+			if err != nil {
+				t.Fatalf("Failed to create destination file: %v", err)
+			}
+			defer dst.Close()
+
+			n, err := CopyFile(dst, src)
+			if err != nil {
+				t.Fatalf("CopyFile() error = %v", err)
+			}
+			if want := int64(len(data)) - tt.offset; n != want {
+				t.Errorf("CopyFile() = %d, want %d", n, want)
+			}
+
+			// Writes continue after the copy
+			if _, err := dst.Write([]byte("end")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			got, err := os.ReadFile(dst.Name())
+			if err != nil {
+				t.Fatalf("Failed to read destination file: %v", err)
+			}
+			want := append(bytes.Clone(data[tt.offset:]), "end"...)
+			if !bytes.Equal(got, want) {
+				t.Errorf("CopyFile() copied %d bytes not matching the source", len(got))
+			}
+		})
+	}
+}
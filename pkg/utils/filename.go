@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultFilename names downloads whose URL does not end with a file name
+const DefaultFilename = "index.html"
+
+// URLFilename returns the name of the file a download of rawURL is saved as
+// by default: the last segment of its path, percent-decoded and sanitized,
+// without the query. URLs of a directory or of the root give
+// DefaultFilename.
+func URLFilename(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// Unparsable URLs keep the name they end with
+		name := rawURL[strings.LastIndex(rawURL, "/")+1:]
+		name, _, _ = strings.Cut(name, "?")
+		return filenameOrDefault(decodeName(name))
+	}
+	if strings.HasSuffix(u.Path, "/") {
+		return DefaultFilename
+	}
+	// The path is decoded already, encoded slashes included
+	return filenameOrDefault(path.Base(u.Path))
+}
+
+// DispositionFilename returns the file name a Content-Disposition header
+// suggests, sanitized, or "" if it has none. The RFC 5987 filename* parameter
+// takes precedence over filename, whose value is percent-decoded when servers
+// sent it encoded. Directories of the name are dropped.
+func DispositionFilename(header string) string {
+	// filename* is decoded into filename by ParseMediaType
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	name := params["filename"]
+	if name == "" {
+		return ""
+	}
+	if strings.Contains(name, "%") {
+		name = decodeName(name)
+	}
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	if name == "" {
+		return ""
+	}
+	return SanitizeFilename(name)
+}
+
+// decodeName percent-decodes name, kept as is unless it decodes to UTF-8
+func decodeName(name string) string {
+	decoded, err := url.PathUnescape(name)
+	if err != nil || !utf8.ValidString(decoded) {
+		return name
+	}
+	return decoded
+}
+
+// filenameOrDefault sanitizes name, DefaultFilename if it is empty
+func filenameOrDefault(name string) string {
+	if name == "" || name == "/" || name == "." {
+		return DefaultFilename
+	}
+	return SanitizeFilename(name)
+}
@@ -0,0 +1,48 @@
+package utils
+
+import "testing"
+
+func TestURLFilename(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/file.zip", "file.zip"},
+		{"http://example.com/down/%E6%96%87%E4%BB%B6.iso", "文件.iso"},
+		{"http://example.com/a%20b.txt?token=x", "a b.txt"},
+		{"http://example.com/dir%2Ffile.txt", "file.txt"},
+		{"http://example.com/a%3Ab.txt", "a_b.txt"},
+		{"http://example.com/dir/", DefaultFilename},
+		{"http://example.com", DefaultFilename},
+		{"http://example.com/%2E%2E", "__"},
+	}
+	for _, tt := range tests {
+		if got := URLFilename(tt.url); got != tt.want {
+			t.Errorf("URLFilename(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestDispositionFilename(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{`attachment; filename="report.pdf"`, "report.pdf"},
+		{`attachment; filename*=UTF-8''%E6%96%87%E4%BB%B6.iso`, "文件.iso"},
+		{`attachment; filename="fallback.iso"; filename*=UTF-8''%E6%96%87%E4%BB%B6.iso`, "文件.iso"},
+		{`attachment; filename="%E6%96%87%E4%BB%B6.iso"`, "文件.iso"},
+		{`attachment; filename="100%.txt"`, "100%.txt"},
+		{`attachment; filename="../../etc/passwd"`, "passwd"},
+		{`attachment; filename="C:\\temp\\a.txt"`, "a.txt"},
+		{`attachment; filename=".."`, "__"},
+		{"attachment; filename=\"a\x01b\"", "a_b"},
+		{`attachment`, ""},
+		{``, ""},
+	}
+	for _, tt := range tests {
+		if got := DispositionFilename(tt.header); got != tt.want {
+			t.Errorf("DispositionFilename(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
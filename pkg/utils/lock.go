@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned by TryLock for a file locked by another process
+var ErrLocked = errors.New("file locked by another process")
+
+// FileLock is an exclusive advisory lock on a lock file, held by the process
+// until Unlock
+type FileLock struct {
+	path string
+	file *os.File
+}
+
+// TryLock takes the lock of the lock file at path, created if missing, and
+// writes the ID of the process into it. It fails with ErrLocked at once when
+// another process holds the lock, whose ID LockOwner returns. Locks are
+// released when their process exits, crashes included.
+func TryLock(path string) (*FileLock, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+		// The holder may have removed the file between the open and the
+		// lock, a lock on it would lock nothing
+		opened, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(path); err != nil || !os.SameFile(opened, current) {
+			file.Close()
+			continue
+		}
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &FileLock{path: path, file: file}, nil
+	}
+}
+
+// Unlock releases the lock and removes the lock file
+func (l *FileLock) Unlock() error {
+	return unlockFile(l.path, l.file)
+}
+
+// LockOwner returns the ID of the process that took the lock of the lock
+// file at path, 0 if unknown
+func LockOwner(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
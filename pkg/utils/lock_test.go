@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.bin.ezft.lock")
+
+	lock, err := TryLock(path)
+	if err != nil {
+		t.Fatalf("TryLock() error = %v", err)
+	}
+	if pid := LockOwner(path); pid != os.Getpid() {
+		t.Errorf("LockOwner() = %d, want %d", pid, os.Getpid())
+	}
+	// Locks are held per open file, a second one conflicts in this process
+	if second, err := TryLock(path); !errors.Is(err, ErrLocked) {
+		if err == nil {
+			second.Unlock()
+		}
+		t.Fatalf("TryLock() of a locked file error = %v, want ErrLocked", err)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("lock file not removed: %v", err)
+	}
+	if pid := LockOwner(path); pid != 0 {
+		t.Errorf("LockOwner() of no lock = %d, want 0", pid)
+	}
+
+	lock, err = TryLock(path)
+	if err != nil {
+		t.Fatalf("TryLock() after Unlock() error = %v", err)
+	}
+	lock.Unlock()
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package utils
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive flock on file without waiting
+func lockFile(file *os.File) error {
+	err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
+
+// unlockFile removes the lock file before releasing its lock, so that the
+// next process to lock it creates a new one
+func unlockFile(path string, file *os.File) error {
+	err := os.Remove(path)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffset is where the byte locked in a lock file lies, beyond its content
+// so that other processes can still read the owner
+const lockOffset = 1 << 30
+
+// lockFile takes an exclusive lock on a byte of file without waiting
+func lockFile(file *os.File) error {
+	ol := windows.Overlapped{Offset: lockOffset}
+	err := windows.LockFileEx(windows.Handle(file.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return ErrLocked
+	}
+	return err
+}
+
+// unlockFile releases the lock before removing the lock file, which cannot
+// be removed while open. A process opening it in between keeps it.
+func unlockFile(path string, file *os.File) error {
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, windows.ERROR_SHARING_VIOLATION) && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -3,27 +3,141 @@ package logger
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-func NewLogger(file, level string) (*zap.Logger, error) {
-	var err error
-	var l *zap.Logger
-	if l, err = newLogger(file, level); err != nil {
-		return nil, fmt.Errorf("failed to open log file: %s", err)
+// Formats of the log
+const (
+	FormatConsole = "console" // Human readable lines
+	FormatJSON    = "json"    // One JSON object per line, for log ingestion pipelines
+)
+
+// Outputs of the log
+const (
+	OutputFile   = "file"   // The log file, rotated
+	OutputStdout = "stdout" // The standard output
+	OutputStderr = "stderr" // The standard error, as containers expect
+)
+
+// Config configuration of a logger
+type Config struct {
+	File   string // Log file, rotated
+	Output string // Outputs separated by commas such as "file,stderr", OutputFile if empty
+	Level  string // Minimum level: debug, info, warn, error...
+	Format string // FormatConsole or FormatJSON, FormatConsole if empty
+}
+
+// New creates a logger of config, writing to all its outputs
+func New(config Config) (*zap.Logger, error) {
+	level, err := parseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := newEncoder(config.Format)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := ParseOutput(config.Output)
+	if err != nil {
+		return nil, err
 	}
-	return l, nil
+	writers := make([]zapcore.WriteSyncer, 0, len(outputs))
+	for _, output := range outputs {
+		switch output {
+		case OutputFile:
+			ws, err := newFileWriter(config.File)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open log file: %s", err)
+			}
+			writers = append(writers, ws)
+		case OutputStdout:
+			writers = append(writers, zapcore.Lock(os.Stdout))
+		case OutputStderr:
+			writers = append(writers, zapcore.Lock(os.Stderr))
+		}
+	}
+	return newCore(zapcore.NewMultiWriteSyncer(writers...), encoder, level), nil
+}
+
+// Tee returns a logger writing to l and also to file, created or appended to,
+// in format at the level of l, and a function closing file. Unlike the log
+// file, file is not rotated as it holds the log of a single transfer. Fields
+// added to l with With are not written to file.
+func Tee(l *zap.Logger, file, format string) (*zap.Logger, func() error, error) {
+	encoder, err := newEncoder(format)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	core := zapcore.NewCore(encoder, zapcore.Lock(f), zapcore.LevelOf(l.Core()))
+	tee := l.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(c, core)
+	}))
+	return tee, f.Close, nil
+}
+
+// WritesFile reports whether the outputs separated by commas in output
+// include the log file, whose directory then has to exist
+func WritesFile(output string) bool {
+	outputs, err := ParseOutput(output)
+	return err == nil && slices.Contains(outputs, OutputFile)
+}
+
+// ParseOutput returns the outputs of the log separated by commas in s,
+// OutputFile if empty
+func ParseOutput(s string) ([]string, error) {
+	if s == "" {
+		return []string{OutputFile}, nil
+	}
+	var outputs []string
+	for _, output := range strings.Split(s, ",") {
+		output = strings.TrimSpace(output)
+		switch output {
+		case OutputFile, OutputStdout, OutputStderr:
+		default:
+			return nil, fmt.Errorf("invalid log output %q, must be %s, %s or %s", output, OutputFile, OutputStdout, OutputStderr)
+		}
+		if !slices.Contains(outputs, output) {
+			outputs = append(outputs, output)
+		}
+	}
+	return outputs, nil
+}
+
+func NewLogger(file, level string) (*zap.Logger, error) {
+	return New(Config{File: file, Level: level})
+}
+
+// NewConsoleLogger creates a logger writing to the standard output instead
+// of a file
+func NewConsoleLogger(loglevel string) (*zap.Logger, error) {
+	return New(Config{Output: OutputStdout, Level: loglevel})
 }
 
-func newLogger(logfile, loglevel string) (*zap.Logger, error) {
+// CheckFormat checks that format is a format of the log
+func CheckFormat(format string) error {
+	_, err := newEncoder(format)
+	return err
+}
+
+func parseLevel(loglevel string) (zapcore.Level, error) {
 	var level zapcore.Level
 	if err := level.UnmarshalText([]byte(loglevel)); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal level %s, error: %s", loglevel, err)
+		return level, fmt.Errorf("failed to unmarshal level %s, error: %s", loglevel, err)
 	}
+	return level, nil
+}
 
+// newFileWriter returns a writer of the log file, rotated
+func newFileWriter(logfile string) (zapcore.WriteSyncer, error) {
 	// check if logfile is valid
 	f, err := os.OpenFile(logfile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
@@ -32,12 +146,26 @@ func newLogger(logfile, loglevel string) (*zap.Logger, error) {
 	defer f.Close()
 	f.Close()
 
+	// use lumberjack to rotate logfile
+	writer := &lumberjack.Logger{
+		Filename:   logfile,
+		MaxSize:    100, // megabytes
+		MaxBackups: 7,
+		//MaxAge:     28,    //days
+		LocalTime: true,
+		Compress:  false,
+	}
+	return zapcore.AddSync(writer), nil
+}
+
+// newEncoder returns the encoder of the log format
+func newEncoder(format string) (zapcore.Encoder, error) {
 	cfg := zapcore.EncoderConfig{
 		TimeKey:  "time",
 		LevelKey: "level",
 		//NameKey:    "logger",
-		CallerKey: "caller",
-		//MessageKey: "msg",
+		CallerKey:  "caller",
+		MessageKey: "msg",
 		//StacktraceKey:  "stacktrace",
 		LineEnding:     zapcore.DefaultLineEnding,
 		EncodeLevel:    zapcore.CapitalLevelEncoder,
@@ -45,25 +173,25 @@ func newLogger(logfile, loglevel string) (*zap.Logger, error) {
 		EncodeDuration: zapcore.SecondsDurationEncoder,
 		EncodeCaller:   zapcore.ShortCallerEncoder,
 	}
-
-	// use lumberjack to rotate logfile
-	writer := &lumberjack.Logger{
-		Filename:   logfile,
-		MaxSize:    100, // megabytes
-		MaxBackups: 7,
-		//MaxAge:     28,    //days
-		LocalTime: true,
-		Compress:  false,
+	switch format {
+	case "", FormatConsole:
+		return zapcore.NewConsoleEncoder(cfg), nil
+	case FormatJSON:
+		// Machine readable values
+		cfg.EncodeLevel = zapcore.LowercaseLevelEncoder
+		cfg.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+		return zapcore.NewJSONEncoder(cfg), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be %s or %s", format, FormatConsole, FormatJSON)
 	}
+}
 
+func newCore(ws zapcore.WriteSyncer, encoder zapcore.Encoder, level zapcore.Level) *zap.Logger {
 	core := zapcore.NewCore(
-		zapcore.NewConsoleEncoder(cfg),
-		//zapcore.NewMultiWriteSyncer(zapcore.AddSync(writer), zapcore.AddSync(os.Stdout)),
-		zapcore.NewMultiWriteSyncer(zapcore.AddSync(writer)),
+		encoder,
+		ws,
 		level,
 	)
 
-	logger := zap.New(core, zap.AddCaller())
-
-	return logger, nil
+	return zap.New(core, zap.AddCaller())
 }
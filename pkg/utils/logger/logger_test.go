@@ -1,12 +1,16 @@
 package logger
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 // TestNewLoggerSuccess tests successful creation of logger.
@@ -96,3 +100,120 @@ func TestLoggerActualLogging(t *testing.T) {
 	// Clean up
 	defer os.Remove(logFile)
 }
+
+// TestNewConsoleLogger tests creation of a logger writing to the standard output.
+func TestNewConsoleLogger(t *testing.T) {
+	logger, err := NewConsoleLogger("warn")
+	require.NoError(t, err)
+	assert.False(t, logger.Core().Enabled(zapcore.InfoLevel))
+	assert.True(t, logger.Core().Enabled(zapcore.WarnLevel))
+
+	_, err = NewConsoleLogger("invalid")
+	require.Error(t, err)
+}
+
+// TestNewJSONFormat tests that the JSON format writes the message in its field.
+func TestNewJSONFormat(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	logger, err := New(Config{File: logFile, Level: "info", Format: FormatJSON})
+	require.NoError(t, err)
+	logger.Info("download completed", zap.Int64("size", 42))
+	logger.Debug("not logged")
+	logger.Sync()
+
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "download completed", entry["msg"])
+	assert.Equal(t, "info", entry["level"])
+	assert.Equal(t, float64(42), entry["size"])
+	assert.Contains(t, entry, "time")
+
+	_, err = New(Config{File: logFile, Level: "info", Format: "xml"})
+	assert.ErrorContains(t, err, "invalid log format")
+}
+
+// TestNewConsoleFormat tests that the console format writes the message after the caller.
+func TestNewConsoleFormat(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	logger, err := New(Config{File: logFile, Level: "info"})
+	require.NoError(t, err)
+	logger.Info("download completed")
+	logger.Sync()
+
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Regexp(t, `^\S+ \S+\tINFO\tlogger/logger_test.go:\d+\tdownload completed\n$`, string(data))
+}
+
+// TestParseOutput tests parsing of the outputs of the log.
+func TestParseOutput(t *testing.T) {
+	outputs, err := ParseOutput("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{OutputFile}, outputs)
+
+	outputs, err = ParseOutput("file, stderr,file")
+	require.NoError(t, err)
+	assert.Equal(t, []string{OutputFile, OutputStderr}, outputs)
+
+	_, err = ParseOutput("file,syslog")
+	assert.ErrorContains(t, err, `invalid log output "syslog"`)
+
+	assert.True(t, WritesFile(""))
+	assert.True(t, WritesFile("stdout,file"))
+	assert.False(t, WritesFile("stderr"))
+	assert.False(t, WritesFile("syslog"))
+}
+
+// TestNewConsoleOutput tests that loggers without file output need no log file.
+func TestNewConsoleOutput(t *testing.T) {
+	logger, err := New(Config{File: "/path/to/nowhere/test.log", Output: OutputStderr, Level: "info"})
+	require.NoError(t, err)
+	assert.NotNil(t, logger)
+	_, err = os.Stat("/path/to/nowhere")
+	assert.True(t, os.IsNotExist(err), "no log directory should be created")
+
+	// Both the file and the console
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	logger, err = New(Config{File: logFile, Output: "file,stderr", Level: "info"})
+	require.NoError(t, err)
+	logger.Info("written to both")
+	logger.Sync()
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "written to both")
+}
+
+// TestTee tests that a teed logger writes to both logs until closed.
+func TestTee(t *testing.T) {
+	dir := t.TempDir()
+	main, err := New(Config{File: filepath.Join(dir, "main.log"), Level: "info"})
+	require.NoError(t, err)
+	transferLog := filepath.Join(dir, "transfer.log")
+	tee, closeLog, err := Tee(main.With(zap.String("batch", "b1")), transferLog, FormatJSON)
+	require.NoError(t, err)
+	tee.Info("download failed", zap.String("url", "http://files.example.com/os.img"))
+	tee.Debug("below the level of the main log")
+	require.NoError(t, closeLog())
+	main.Info("next download")
+	main.Sync()
+
+	data, err := os.ReadFile(filepath.Join(dir, "main.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "download failed")
+	assert.Contains(t, string(data), "next download")
+	data, err = os.ReadFile(transferLog)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "download failed", entry["msg"])
+	assert.Equal(t, "http://files.example.com/os.img", entry["url"])
+
+	_, _, err = Tee(main, filepath.Join(dir, "missing", "transfer.log"), FormatConsole)
+	assert.Error(t, err)
+}
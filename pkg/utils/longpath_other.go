@@ -0,0 +1,8 @@
+//go:build !windows
+
+package utils
+
+// LongPath returns p, only Windows limits the length of paths
+func LongPath(p string) string {
+	return p
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxDirPath is the longest path Windows APIs accept without the extended
+// prefix, that of a directory leaving room for an 8.3 file name
+const maxDirPath = 248
+
+// LongPath returns p with the extended-length prefix \\?\ when it is too long
+// for the Windows APIs without it, as required by those called directly
+// rather than through package os. The prefix turns off the normalization of
+// the path, so p is made absolute and clean first.
+func LongPath(p string) string {
+	if len(p) < maxDirPath || strings.HasPrefix(p, `\\?\`) || strings.HasPrefix(p, `\\.\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		// \\server\share\file
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	long := strings.Repeat("d", 300)
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`C:\short\file.txt`, `C:\short\file.txt`},
+		{`C:\` + long + `\file.txt`, `\\?\C:\` + long + `\file.txt`},
+		{`C:\` + long + `\..\file.txt`, `\\?\C:\file.txt`},
+		{`\\server\share\` + long, `\\?\UNC\server\share\` + long},
+		{`\\?\C:\` + long, `\\?\C:\` + long},
+	}
+	for _, tt := range tests {
+		if got := LongPath(tt.input); got != tt.want {
+			t.Errorf("LongPath(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
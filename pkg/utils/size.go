@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits binary units of sizes by suffix
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// ParseBytes parses a size in bytes with an optional binary unit suffix, K,
+// M, G or T optionally followed by i or B, such as 4096, 512K, 4M, 1.5GiB
+func ParseBytes(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "B")
+	v = strings.TrimSuffix(v, "I")
+
+	multiplier := 1.0
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(v, unit.suffix) {
+			multiplier = float64(unit.size)
+			v = strings.TrimSuffix(v, unit.suffix)
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) || n*multiplier > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q, expected bytes such as 512K, 4M or 10G", s)
+	}
+	return int64(n * multiplier), nil
+}
+
+// FormatSize formats a size in bytes in the largest binary unit dividing it,
+// as accepted by ParseBytes, such as 4M or 1536K
+func FormatSize(size int64) string {
+	for _, unit := range sizeUnits {
+		if size != 0 && size%unit.size == 0 {
+			return strconv.FormatInt(size/unit.size, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+// ByteSize a size flag in bytes, given with an optional binary unit such as
+// 4M, it implements pflag.Value
+type ByteSize int64
+
+func (b *ByteSize) Set(s string) error {
+	size, err := ParseBytes(s)
+	if err != nil {
+		return err
+	}
+	*b = ByteSize(size)
+	return nil
+}
+
+func (b *ByteSize) String() string {
+	return FormatSize(int64(*b))
+}
+
+func (b *ByteSize) Type() string {
+	return "size"
+}
@@ -0,0 +1,67 @@
+package utils
+
+import "testing"
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"4096", 4096, false},
+		{"512k", 512 << 10, false},
+		{"4M", 4 << 20, false},
+		{"4MB", 4 << 20, false},
+		{"4MiB", 4 << 20, false},
+		{"1.5G", 3 << 29, false},
+		{"10G", 10 << 30, false},
+		{"2T", 2 << 40, false},
+		{" 1m ", 1 << 20, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"M", 0, true},
+		{"big", 0, true},
+		{"-4M", 0, true},
+		{"inf", 0, true},
+		{"1e30T", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBytes(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:          "0",
+		1000:       "1000",
+		4096:       "4K",
+		1536 << 10: "1536K",
+		4 << 20:    "4M",
+		10 << 30:   "10G",
+		1 << 40:    "1T",
+	}
+	for size, want := range tests {
+		if got := FormatSize(size); got != want {
+			t.Errorf("FormatSize(%d) = %q, want %q", size, got, want)
+		}
+		if parsed, err := ParseBytes(want); err != nil || parsed != size {
+			t.Errorf("ParseBytes(%q) = %d, %v, want %d", want, parsed, err, size)
+		}
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	size := ByteSize(1 << 20)
+	if size.String() != "1M" || size.Type() != "size" {
+		t.Errorf("String() = %q, Type() = %q", size.String(), size.Type())
+	}
+	if err := size.Set("8M"); err != nil || size != 8<<20 {
+		t.Errorf("Set(8M) = %v, size %d", err, size)
+	}
+	if err := size.Set("eight"); err == nil || size != 8<<20 {
+		t.Errorf("Set(eight) = %v, size %d", err, size)
+	}
+}
@@ -2,12 +2,18 @@ package utils
 
 import (
 	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // FormatBytes formats bytes to human readable format
@@ -87,6 +93,42 @@ func CalculateFileMD5(filename string) (string, error) {
 	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
+// NewHash creates a hash by algorithm name: md5, sha1, sha256 or sha512
+func NewHash(algo string) (hash.Hash, error) {
+	switch strings.ToLower(algo) {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", algo)
+	}
+}
+
+// CalculateFileHash calculates the hex encoded hash of file with the given algorithm
+func CalculateFileHash(filename, algo string) (string, error) {
+	h, err := NewHash(algo)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
 // SanitizeFilename cleans filename, removes unsafe characters
 func SanitizeFilename(filename string) string {
 	// Remove path separators and other unsafe characters
@@ -95,9 +137,52 @@ func SanitizeFilename(filename string) string {
 	for _, char := range unsafe {
 		result = strings.ReplaceAll(result, char, "_")
 	}
+	// Control characters, invalid UTF-8 included, could mislead terminals
+	result = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return '_'
+		}
+		return r
+	}, result)
+	// Names of the directory itself or its parent
+	if strings.Trim(result, ".") == "" {
+		result = strings.Repeat("_", max(len(result), 1))
+	}
+	// Windows drops trailing dots and spaces, and reserves the names of
+	// devices whatever their extension
+	if trimmed := strings.TrimRight(result, ". "); trimmed != result {
+		result = trimmed + strings.Repeat("_", len(result)-len(trimmed))
+	}
+	if isReservedName(result) {
+		result = "_" + result
+	}
 	return result
 }
 
+// isReservedName reports whether name is that of a Windows device, such as
+// CON, NUL.txt or com1.tar.gz
+func isReservedName(name string) bool {
+	base, _, _ := strings.Cut(name, ".")
+	base = strings.ToUpper(strings.TrimRight(base, " "))
+	switch base {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+	if len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) {
+		return base[3] >= '1' && base[3] <= '9'
+	}
+	return false
+}
+
+// ParseCredentials splits "username:password" credentials
+func ParseCredentials(s string) (string, string, error) {
+	username, password, ok := strings.Cut(s, ":")
+	if !ok || username == "" {
+		return "", "", fmt.Errorf("invalid credentials, expected username:password")
+	}
+	return username, password, nil
+}
+
 // EnsureDir ensures directory exists, create if it doesn't exist
 func EnsureDir(dir string) error {
 	absPath, err := filepath.Abs(dir)
@@ -114,6 +199,20 @@ func EnsureDir(dir string) error {
 	return nil
 }
 
+// RemoveEmptyDirs removes dir and its parents up to, but not including, root as long as they are empty
+func RemoveEmptyDirs(root, dir string) {
+	for {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || !filepath.IsLocal(rel) {
+			return
+		}
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
 // ProgressBar progress bar structure
 type ProgressBar struct {
 	total   int64
@@ -171,6 +171,66 @@ func TestCalculateFileMD5(t *testing.T) {
 	}
 }
 
+func TestCalculateFileHash(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "hash_test.txt")
+
+	err := os.WriteFile(testFile, []byte("Hello, World!"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	tests := []struct {
+		algo     string
+		expected string
+	}{
+		{"md5", "65a8e27d8879283831b664bd8b7f0ad4"},
+		{"sha1", "0a0a9f2a6772942557ab5355d76af442f8f65e01"},
+		{"SHA256", "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f"},
+	}
+
+	for _, test := range tests {
+		hash, err := CalculateFileHash(testFile, test.algo)
+		if err != nil {
+			t.Fatalf("CalculateFileHash(%s) failed: %v", test.algo, err)
+		}
+		if hash != test.expected {
+			t.Errorf("CalculateFileHash(%s) = %s, expected %s", test.algo, hash, test.expected)
+		}
+	}
+
+	// Test unsupported algorithm
+	if _, err := CalculateFileHash(testFile, "crc32"); err == nil {
+		t.Errorf("CalculateFileHash should return error for unsupported algorithm")
+	}
+}
+
+func TestParseCredentials(t *testing.T) {
+	tests := []struct {
+		input    string
+		username string
+		password string
+		wantErr  bool
+	}{
+		{"admin:secret", "admin", "secret", false},
+		{"admin:pa:ss", "admin", "pa:ss", false},
+		{"admin:", "admin", "", false},
+		{"admin", "", "", true},
+		{":secret", "", "", true},
+	}
+
+	for _, test := range tests {
+		username, password, err := ParseCredentials(test.input)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseCredentials(%q) error = %v, wantErr %v", test.input, err, test.wantErr)
+			continue
+		}
+		if username != test.username || password != test.password {
+			t.Errorf("ParseCredentials(%q) = %q, %q, expected %q, %q", test.input, username, password, test.username, test.password)
+		}
+	}
+}
+
 func TestSanitizeFilename(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -185,6 +245,16 @@ func TestSanitizeFilename(t *testing.T) {
 		{"file\"with\"quotes.txt", "file_with_quotes.txt"},
 		{"file<with>brackets.txt", "file_with_brackets.txt"},
 		{"file|with|pipes.txt", "file_with_pipes.txt"},
+		{"file\twith\x1bcontrols.txt", "file_with_controls.txt"},
+		{"..", "__"},
+		{"name. ", "name__"},
+		{"CON", "_CON"},
+		{"nul.txt", "_nul.txt"},
+		{"com1.tar.gz", "_com1.tar.gz"},
+		{"LPT9", "_LPT9"},
+		{"COM0", "COM0"},
+		{"console.log", "console.log"},
+		{"", "_"},
 	}
 
 	for _, test := range tests {
@@ -195,6 +265,32 @@ func TestSanitizeFilename(t *testing.T) {
 	}
 }
 
+func TestRemoveEmptyDirs(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a", "b", "c"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "keep.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	RemoveEmptyDirs(root, filepath.Join(root, "a", "b", "c"))
+
+	if _, err := os.Stat(filepath.Join(root, "a", "b")); !os.IsNotExist(err) {
+		t.Error("Empty directories should be removed")
+	}
+	if _, err := os.Stat(filepath.Join(root, "a")); err != nil {
+		t.Error("Non-empty directory should be kept")
+	}
+
+	// Root itself is never removed
+	os.Remove(filepath.Join(root, "a", "keep.txt"))
+	RemoveEmptyDirs(root, filepath.Join(root, "a"))
+	if _, err := os.Stat(root); err != nil {
+		t.Error("Root directory should be kept")
+	}
+}
+
 func TestEnsureDir(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -0,0 +1,11 @@
+package utils
+
+import "errors"
+
+var (
+	// ErrXattrUnsupported is returned when the system or file system of a
+	// file does not support extended attributes
+	ErrXattrUnsupported = errors.New("extended attributes not supported")
+	// ErrXattrMissing is returned for an extended attribute not set
+	ErrXattrMissing = errors.New("extended attribute not set")
+)
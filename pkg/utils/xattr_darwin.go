@@ -0,0 +1,6 @@
+package utils
+
+import "golang.org/x/sys/unix"
+
+// errNoXattr is returned by Getxattr for an attribute not set
+const errNoXattr = unix.ENOATTR
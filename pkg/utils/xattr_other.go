@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package utils
+
+// SetXattr returns ErrXattrUnsupported, extended attributes are only
+// supported on Linux and macOS
+func SetXattr(path, name, value string) error {
+	return ErrXattrUnsupported
+}
+
+// GetXattr returns ErrXattrUnsupported, extended attributes are only
+// supported on Linux and macOS
+func GetXattr(path, name string) (string, error) {
+	return "", ErrXattrUnsupported
+}
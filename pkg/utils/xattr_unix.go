@@ -0,0 +1,40 @@
+//go:build linux || darwin
+
+package utils
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetXattr sets the extended attribute name of the file at path to value
+func SetXattr(path, name, value string) error {
+	err := unix.Setxattr(path, name, []byte(value), 0)
+	if errors.Is(err, unix.ENOTSUP) {
+		return ErrXattrUnsupported
+	}
+	return err
+}
+
+// GetXattr returns the extended attribute name of the file at path,
+// ErrXattrMissing if it is not set
+func GetXattr(path, name string) (string, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := unix.Getxattr(path, name, buf)
+		switch {
+		case errors.Is(err, unix.ERANGE):
+			// Grown since sized
+			buf = make([]byte, 2*len(buf))
+			continue
+		case errors.Is(err, errNoXattr):
+			return "", ErrXattrMissing
+		case errors.Is(err, unix.ENOTSUP):
+			return "", ErrXattrUnsupported
+		case err != nil:
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/easzlab/ezft/pkg/server"
+	"go.uber.org/zap"
+)
+
+// bufferedWriter hides the io.ReaderFrom of the response writer, so the
+// server copies files through a userland buffer instead of sendfile
+type bufferedWriter struct {
+	http.ResponseWriter
+}
+
+func (w bufferedWriter) Write(b []byte) (int, error) {
+	return w.ResponseWriter.Write(b)
+}
+
+// BenchmarkServeFile benchmarks serving whole files and ranges of them with
+// sendfile against copying them through a buffer
+func BenchmarkServeFile(b *testing.B) {
+	const size = 64 * 1024 * 1024
+	root := b.TempDir()
+	testContent := make([]byte, size)
+	for i := range testContent {
+		testContent[i] = byte(i % 256)
+	}
+	if err := os.WriteFile(filepath.Join(root, "test.bin"), testContent, 0644); err != nil {
+		b.Fatalf("Failed to create test file: %v", err)
+	}
+
+	srv := server.NewServer(root, 0)
+	srv.SetLogger(zap.NewNop())
+	handler := srv.Handler()
+
+	modes := []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"Sendfile", handler},
+		{"Buffered", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.ServeHTTP(bufferedWriter{w}, r)
+		})},
+	}
+	requests := []struct {
+		name  string
+		rng   string
+		bytes int64
+	}{
+		{"Whole", "", size},
+		{"Range", fmt.Sprintf("bytes=%d-%d", size/4, size*3/4-1), size / 2},
+	}
+
+	for _, mode := range modes {
+		ts := httptest.NewServer(mode.handler)
+		for _, req := range requests {
+			b.Run(mode.name+"/"+req.name, func(b *testing.B) {
+				benchmarkServeFile(b, ts.URL+"/test.bin", req.rng, req.bytes)
+			})
+		}
+		ts.Close()
+	}
+}
+
+func benchmarkServeFile(b *testing.B, url, rng string, size int64) {
+	b.SetBytes(size)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			b.Fatalf("Failed to create request: %v", err)
+		}
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			b.Fatalf("Request failed: %v", err)
+		}
+		n, err := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil || n != size {
+			b.Fatalf("Read %d bytes, want %d: %v", n, size, err)
+		}
+	}
+}